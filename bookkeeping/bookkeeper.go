@@ -0,0 +1,143 @@
+package bookkeeping
+
+import (
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xhttp"
+	"net/http"
+	"time"
+)
+
+const (
+	OutcomeSuccess     = "success"
+	OutcomeClientError = "client_error"
+	OutcomeServerError = "server_error"
+)
+
+// Outcome classifies a status code for bookkeeping purposes
+func Outcome(statusCode int) string {
+	switch {
+	case statusCode >= 500:
+		return OutcomeServerError
+	case statusCode >= 400:
+		return OutcomeClientError
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// PrincipalFunc determines the caller of a request before it is handled
+type PrincipalFunc func(*http.Request) string
+
+// BasicPrincipal uses the username of a request's basic auth credentials as the principal
+func BasicPrincipal(request *http.Request) string {
+	username, _, _ := request.BasicAuth()
+	return username
+}
+
+// Bookkeeper is an Alice-style decorator that writes a Record to a Sink for every request
+type Bookkeeper struct {
+	// Sink receives each completed record.  If nil, records are written to Logger.
+	Sink Sink
+
+	// Logger is used when no Sink is configured.  If nil, logging.DefaultLogger() is used.
+	Logger logging.Logger
+
+	// Principal supplies the initial principal for each record.  Downstream handlers may
+	// override it with SetPrincipal.  If nil, BasicPrincipal is used.
+	Principal PrincipalFunc
+
+	// Instance identifies the server instance that handled each request, typically from
+	// service.Options.Identity.  If set, it is copied into each record.
+	Instance string
+
+	now func() time.Time
+}
+
+func (b *Bookkeeper) sink() Sink {
+	if b != nil && b.Sink != nil {
+		return b.Sink
+	}
+
+	if b != nil && b.Logger != nil {
+		return NewLoggerSink(b.Logger)
+	}
+
+	return NewLoggerSink(logging.DefaultLogger())
+}
+
+func (b *Bookkeeper) principal() PrincipalFunc {
+	if b != nil && b.Principal != nil {
+		return b.Principal
+	}
+
+	return BasicPrincipal
+}
+
+func (b *Bookkeeper) instance() string {
+	if b != nil {
+		return b.Instance
+	}
+
+	return ""
+}
+
+func (b *Bookkeeper) clock() func() time.Time {
+	if b != nil && b.now != nil {
+		return b.now
+	}
+
+	return time.Now
+}
+
+// Then decorates the delegate so that each request it handles produces a bookkeeping record
+func (b *Bookkeeper) Then(delegate http.Handler) http.Handler {
+	var (
+		sink      = b.sink()
+		principal = b.principal()
+		instance  = b.instance()
+		now       = b.clock()
+	)
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := now()
+		r := &recorder{
+			record: Record{
+				Time:       start,
+				Method:     request.Method,
+				URL:        request.URL.String(),
+				RemoteAddr: request.RemoteAddr,
+				Principal:  principal(request),
+				Instance:   instance,
+			},
+		}
+
+		writer := xhttp.WrapResponseWriter(response)
+		defer func() {
+			// a panicking delegate is still audited, as net/http will abort the response
+			recovered := recover()
+			statusCode := writer.StatusCode()
+			if recovered != nil {
+				statusCode = http.StatusInternalServerError
+			} else if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+
+			r.update(func(record *Record) {
+				record.StatusCode = statusCode
+				record.Outcome = Outcome(statusCode)
+				record.Latency = now().Sub(start)
+				if recovered != nil && len(record.Error) == 0 {
+					record.Error = fmt.Sprintf("panic: %v", recovered)
+				}
+			})
+
+			sink.Write(r.snapshot())
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		delegate.ServeHTTP(writer, request.WithContext(withRecorder(request.Context(), r)))
+	})
+}
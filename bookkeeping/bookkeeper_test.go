@@ -0,0 +1,153 @@
+package bookkeeping
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOutcome(t *testing.T) {
+	testData := []struct {
+		statusCode int
+		expected   string
+	}{
+		{http.StatusOK, OutcomeSuccess},
+		{http.StatusMovedPermanently, OutcomeSuccess},
+		{http.StatusBadRequest, OutcomeClientError},
+		{http.StatusNotFound, OutcomeClientError},
+		{http.StatusInternalServerError, OutcomeServerError},
+		{http.StatusServiceUnavailable, OutcomeServerError},
+	}
+
+	for _, record := range testData {
+		assert.Equal(t, record.expected, Outcome(record.statusCode))
+	}
+}
+
+func TestBasicPrincipal(t *testing.T) {
+	request := httptest.NewRequest("GET", "/", nil)
+	assert.Equal(t, "", BasicPrincipal(request))
+
+	request.SetBasicAuth("joe", "secret")
+	assert.Equal(t, "joe", BasicPrincipal(request))
+}
+
+func TestBookkeeperDefaults(t *testing.T) {
+	assert := assert.New(t)
+	for _, b := range []*Bookkeeper{nil, new(Bookkeeper), &Bookkeeper{Logger: logging.TestLogger(t)}} {
+		assert.NotNil(b.sink())
+		assert.NotNil(b.principal())
+		assert.NotNil(b.clock())
+	}
+}
+
+func TestBookkeeper(t *testing.T) {
+	testData := []struct {
+		handler         http.HandlerFunc
+		expectedCode    int
+		expectedOutcome string
+	}{
+		{
+			func(response http.ResponseWriter, request *http.Request) {},
+			http.StatusOK,
+			OutcomeSuccess,
+		},
+		{
+			func(response http.ResponseWriter, request *http.Request) {
+				response.Write([]byte("body"))
+			},
+			http.StatusOK,
+			OutcomeSuccess,
+		},
+		{
+			func(response http.ResponseWriter, request *http.Request) {
+				response.WriteHeader(http.StatusNotFound)
+			},
+			http.StatusNotFound,
+			OutcomeClientError,
+		},
+		{
+			func(response http.ResponseWriter, request *http.Request) {
+				SetPrincipal(request.Context(), "downstream")
+				SetDestination(request.Context(), "mac:112233445566")
+				SetTransactionUUID(request.Context(), "uuid")
+				response.WriteHeader(http.StatusBadGateway)
+			},
+			http.StatusBadGateway,
+			OutcomeServerError,
+		},
+	}
+
+	for _, record := range testData {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			records []Record
+			start   = time.Unix(1500000000, 0)
+			current = start
+
+			bookkeeper = Bookkeeper{
+				Sink:     SinkFunc(func(r Record) { records = append(records, r) }),
+				Instance: "east/http://talaria-1:8080",
+				now: func() time.Time {
+					defer func() { current = current.Add(time.Second) }()
+					return current
+				},
+			}
+
+			handler  = bookkeeper.Then(record.handler)
+			request  = httptest.NewRequest("PUT", "/api/v2/device", nil)
+			response = httptest.NewRecorder()
+		)
+
+		request.SetBasicAuth("joe", "secret")
+		handler.ServeHTTP(response, request)
+		require.Len(records, 1)
+
+		actual := records[0]
+		assert.Equal(start, actual.Time)
+		assert.Equal("PUT", actual.Method)
+		assert.Equal("/api/v2/device", actual.URL)
+		assert.Equal(request.RemoteAddr, actual.RemoteAddr)
+		assert.Equal(record.expectedCode, actual.StatusCode)
+		assert.Equal(record.expectedOutcome, actual.Outcome)
+		assert.Equal(time.Second, actual.Latency)
+		assert.Equal("east/http://talaria-1:8080", actual.Instance)
+
+		if len(actual.Destination) > 0 {
+			assert.Equal("downstream", actual.Principal)
+			assert.Equal("mac:112233445566", actual.Destination)
+			assert.Equal("uuid", actual.TransactionUUID)
+		} else {
+			assert.Equal("joe", actual.Principal)
+		}
+	}
+}
+
+func TestBookkeeperPanic(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		records    []Record
+		bookkeeper = Bookkeeper{
+			Sink: SinkFunc(func(r Record) { records = append(records, r) }),
+		}
+
+		handler = bookkeeper.Then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic("expected")
+		}))
+	)
+
+	assert.Panics(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+
+	if assert.Len(records, 1) {
+		assert.Equal(http.StatusInternalServerError, records[0].StatusCode)
+		assert.Equal(OutcomeServerError, records[0].Outcome)
+		assert.Equal("panic: expected", records[0].Error)
+	}
+}
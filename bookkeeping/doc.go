@@ -0,0 +1,9 @@
+/*
+Package bookkeeping emits one structured audit record for each API request.
+
+A Bookkeeper decorates a handler.  It places a mutable record into the request context,
+so that downstream code can contribute what only it knows, such as the device destination
+or the WRP transaction UUID, via functions like SetDestination.  When the decorated handler
+returns, the completed Record is written to a pluggable Sink.
+*/
+package bookkeeping
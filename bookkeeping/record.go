@@ -0,0 +1,87 @@
+package bookkeeping
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Record is the bookkeeping entry produced for a single request
+type Record struct {
+	Time            time.Time     `json:"time"`
+	Method          string        `json:"method"`
+	URL             string        `json:"url"`
+	RemoteAddr      string        `json:"remoteAddr,omitempty"`
+	Principal       string        `json:"principal,omitempty"`
+	Destination     string        `json:"destination,omitempty"`
+	TransactionUUID string        `json:"transactionUUID,omitempty"`
+	StatusCode      int           `json:"statusCode"`
+	Outcome         string        `json:"outcome"`
+	Error           string        `json:"error,omitempty"`
+	Latency         time.Duration `json:"latency"`
+	Instance        string        `json:"instance,omitempty"`
+}
+
+// recorder guards a Record that is being filled in while a request is processed.
+// Handlers may hand work off to other goroutines, so access is serialized.
+type recorder struct {
+	lock   sync.Mutex
+	record Record
+}
+
+func (r *recorder) update(f func(*Record)) {
+	r.lock.Lock()
+	f(&r.record)
+	r.lock.Unlock()
+}
+
+func (r *recorder) snapshot() Record {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.record
+}
+
+type contextKey struct{}
+
+func withRecorder(ctx context.Context, r *recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+func update(ctx context.Context, f func(*Record)) bool {
+	if r, ok := ctx.Value(contextKey{}).(*recorder); ok {
+		r.update(f)
+		return true
+	}
+
+	return false
+}
+
+// SetPrincipal records the authenticated caller for the current request.  This function
+// returns false if the context is not associated with a bookkeeping record.
+func SetPrincipal(ctx context.Context, principal string) bool {
+	return update(ctx, func(r *Record) { r.Principal = principal })
+}
+
+// SetDestination records the device destination for the current request.  This function
+// returns false if the context is not associated with a bookkeeping record.
+func SetDestination(ctx context.Context, destination string) bool {
+	return update(ctx, func(r *Record) { r.Destination = destination })
+}
+
+// SetTransactionUUID records the WRP transaction UUID for the current request.  This function
+// returns false if the context is not associated with a bookkeeping record.
+func SetTransactionUUID(ctx context.Context, transactionUUID string) bool {
+	return update(ctx, func(r *Record) { r.TransactionUUID = transactionUUID })
+}
+
+// SetError records an error that describes why the current request failed.  This function
+// returns false if the context is not associated with a bookkeeping record.
+func SetError(ctx context.Context, err error) bool {
+	return update(ctx, func(r *Record) {
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Error = ""
+		}
+	})
+}
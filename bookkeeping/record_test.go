@@ -0,0 +1,46 @@
+package bookkeeping
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSettersNoRecord(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		ctx    = context.Background()
+	)
+
+	assert.False(SetPrincipal(ctx, "principal"))
+	assert.False(SetDestination(ctx, "mac:112233445566"))
+	assert.False(SetTransactionUUID(ctx, "uuid"))
+	assert.False(SetError(ctx, errors.New("expected")))
+}
+
+func TestSetters(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		r      = new(recorder)
+		ctx    = withRecorder(context.Background(), r)
+	)
+
+	assert.True(SetPrincipal(ctx, "principal"))
+	assert.True(SetDestination(ctx, "mac:112233445566"))
+	assert.True(SetTransactionUUID(ctx, "uuid"))
+	assert.True(SetError(ctx, errors.New("expected")))
+
+	assert.Equal(
+		Record{
+			Principal:       "principal",
+			Destination:     "mac:112233445566",
+			TransactionUUID: "uuid",
+			Error:           "expected",
+		},
+		r.snapshot(),
+	)
+
+	assert.True(SetError(ctx, nil))
+	assert.Equal("", r.snapshot().Error)
+}
@@ -0,0 +1,47 @@
+package bookkeeping
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/logging"
+	"io"
+	"sync"
+)
+
+// Sink is the destination for completed bookkeeping records.  Implementations must be
+// safe for concurrent use, as records are written from request goroutines.
+type Sink interface {
+	Write(Record)
+}
+
+// SinkFunc is a function type that implements Sink
+type SinkFunc func(Record)
+
+func (sf SinkFunc) Write(r Record) {
+	sf(r)
+}
+
+// NewLoggerSink produces a Sink that writes each record as JSON to a logger at the Info level
+func NewLoggerSink(logger logging.Logger) Sink {
+	return SinkFunc(func(r Record) {
+		if data, err := json.Marshal(r); err != nil {
+			logger.Error("Unable to marshal bookkeeping record: %s", err)
+		} else {
+			logger.Info("%s", data)
+		}
+	})
+}
+
+// NewWriterSink produces a Sink that writes each record as a line of JSON to the given writer.
+// Writes are serialized, so an io.Writer that is not safe for concurrent use may be supplied.
+func NewWriterSink(writer io.Writer) Sink {
+	var (
+		lock    sync.Mutex
+		encoder = json.NewEncoder(writer)
+	)
+
+	return SinkFunc(func(r Record) {
+		lock.Lock()
+		encoder.Encode(r)
+		lock.Unlock()
+	})
+}
@@ -0,0 +1,47 @@
+package bookkeeping
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLoggerSink(t *testing.T) {
+	var (
+		output bytes.Buffer
+		sink   = NewLoggerSink(&logging.LoggerWriter{Writer: &output})
+	)
+
+	sink.Write(Record{Method: "GET", Destination: "mac:112233445566"})
+	assert.Contains(t, output.String(), `"destination":"mac:112233445566"`)
+}
+
+func TestNewWriterSink(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		output  bytes.Buffer
+		sink    = NewWriterSink(&output)
+		records = []Record{
+			{Method: "GET", URL: "/first", StatusCode: 200, Outcome: OutcomeSuccess, Latency: time.Second},
+			{Method: "POST", URL: "/second", StatusCode: 503, Outcome: OutcomeServerError},
+		}
+	)
+
+	for _, r := range records {
+		sink.Write(r)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(lines, len(records))
+	for i, line := range lines {
+		var actual Record
+		require.NoError(json.Unmarshal([]byte(line), &actual))
+		assert.Equal(records[i], actual)
+	}
+}
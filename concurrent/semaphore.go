@@ -0,0 +1,137 @@
+package concurrent
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrorSemaphoreWeight = errors.New("The requested weight exceeds the size of the semaphore")
+)
+
+// semaphoreWaiter is a blocked call to Acquire.  The ready channel is closed once
+// the waiter's weight has been granted.
+type semaphoreWaiter struct {
+	weight int64
+	ready  chan struct{}
+}
+
+// Semaphore is a weighted semaphore, used to bound the total cost of concurrent operations.
+// Waiters are granted their weight in the order they called Acquire, so a large request is not
+// starved by a stream of smaller ones.  Instances must be created via NewSemaphore.
+type Semaphore struct {
+	size    int64
+	current int64
+
+	lock    sync.Mutex
+	waiters list.List
+}
+
+// NewSemaphore creates a Semaphore with the given total weight.  The size must be positive.
+func NewSemaphore(size int64) *Semaphore {
+	if size < 1 {
+		panic("The size of a semaphore must be positive")
+	}
+
+	return &Semaphore{size: size}
+}
+
+// Size returns the total weight available from this semaphore
+func (s *Semaphore) Size() int64 {
+	return s.size
+}
+
+// Acquire blocks until the given weight is available or the context is done.  If the context
+// is done first, its error is returned and no weight is acquired.  A weight larger than Size()
+// can never be granted, so ErrorSemaphoreWeight is returned immediately for such requests.
+func (s *Semaphore) Acquire(ctx context.Context, weight int64) error {
+	if weight > s.size {
+		return ErrorSemaphoreWeight
+	}
+
+	s.lock.Lock()
+	if s.size-s.current >= weight && s.waiters.Len() == 0 {
+		s.current += weight
+		s.lock.Unlock()
+		return nil
+	}
+
+	waiter := semaphoreWaiter{weight: weight, ready: make(chan struct{})}
+	element := s.waiters.PushBack(waiter)
+	s.lock.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+
+	case <-ctx.Done():
+		s.lock.Lock()
+		select {
+		case <-waiter.ready:
+			// the weight was granted after the context was done, so give it back
+			s.current -= weight
+			s.notifyWaiters()
+
+		default:
+			front := s.waiters.Front() == element
+			s.waiters.Remove(element)
+
+			// removing the first waiter may allow the ones behind it to proceed
+			if front && s.size > s.current {
+				s.notifyWaiters()
+			}
+		}
+
+		s.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires the given weight without blocking.  It returns false, acquiring nothing,
+// if the weight is not immediately available or if other callers are waiting.
+func (s *Semaphore) TryAcquire(weight int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.size-s.current >= weight && s.waiters.Len() == 0 {
+		s.current += weight
+		return true
+	}
+
+	return false
+}
+
+// Release returns weight to this semaphore.  Releasing more than is held panics.
+func (s *Semaphore) Release(weight int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.current -= weight
+	if s.current < 0 {
+		panic("Released more weight than held by the semaphore")
+	}
+
+	s.notifyWaiters()
+}
+
+// notifyWaiters grants weight to waiters in order, stopping at the first one that does not fit.
+// This method must be called under the lock.
+func (s *Semaphore) notifyWaiters() {
+	for {
+		next := s.waiters.Front()
+		if next == nil {
+			return
+		}
+
+		waiter := next.Value.(semaphoreWaiter)
+		if s.size-s.current < waiter.weight {
+			return
+		}
+
+		s.current += waiter.weight
+		s.waiters.Remove(next)
+		close(waiter.ready)
+	}
+}
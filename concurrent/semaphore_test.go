@@ -0,0 +1,169 @@
+package concurrent
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewSemaphoreInvalidSize(t *testing.T) {
+	assert := assert.New(t)
+	assert.Panics(func() { NewSemaphore(0) })
+	assert.Panics(func() { NewSemaphore(-1) })
+}
+
+func TestSemaphoreTryAcquire(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		semaphore = NewSemaphore(3)
+	)
+
+	assert.Equal(int64(3), semaphore.Size())
+	assert.True(semaphore.TryAcquire(2))
+	assert.False(semaphore.TryAcquire(2))
+	assert.True(semaphore.TryAcquire(1))
+	assert.False(semaphore.TryAcquire(1))
+
+	semaphore.Release(3)
+	assert.True(semaphore.TryAcquire(3))
+	semaphore.Release(3)
+
+	assert.Panics(func() { semaphore.Release(1) })
+}
+
+func TestSemaphoreAcquire(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		semaphore = NewSemaphore(2)
+		acquired  = make(chan struct{})
+	)
+
+	require.NoError(semaphore.Acquire(context.Background(), 2))
+	assert.Equal(ErrorSemaphoreWeight, semaphore.Acquire(context.Background(), 3))
+
+	go func() {
+		defer close(acquired)
+		assert.NoError(semaphore.Acquire(context.Background(), 1))
+	}()
+
+	select {
+	case <-acquired:
+		assert.Fail("Acquire should have blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	semaphore.Release(1)
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		assert.Fail("Acquire did not unblock after Release")
+	}
+
+	semaphore.Release(2)
+	assert.True(semaphore.TryAcquire(2))
+}
+
+func TestSemaphoreAcquireCancel(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		semaphore   = NewSemaphore(2)
+		ctx, cancel = context.WithCancel(context.Background())
+		result      = make(chan error, 1)
+	)
+
+	require.NoError(semaphore.Acquire(context.Background(), 1))
+
+	// a blocked, large waiter holds back smaller requests
+	go func() {
+		result <- semaphore.Acquire(ctx, 2)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(semaphore.TryAcquire(1))
+
+	cancel()
+	assert.Equal(context.Canceled, <-result)
+
+	// once the waiter is gone, the remaining weight is available again
+	assert.True(semaphore.TryAcquire(1))
+	assert.False(semaphore.TryAcquire(1))
+}
+
+func TestSemaphoreAcquireCancelUnblocksWaiters(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		semaphore   = NewSemaphore(2)
+		ctx, cancel = context.WithCancel(context.Background())
+		large       = make(chan error, 1)
+		small       = make(chan error, 1)
+	)
+
+	require.NoError(semaphore.Acquire(context.Background(), 1))
+
+	go func() {
+		large <- semaphore.Acquire(ctx, 2)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		small <- semaphore.Acquire(context.Background(), 1)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	assert.Equal(context.Canceled, <-large)
+
+	select {
+	case err := <-small:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("The small waiter was not granted after the large waiter was cancelled")
+	}
+}
+
+func TestSemaphoreConcurrency(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		semaphore = NewSemaphore(3)
+		waitGroup sync.WaitGroup
+
+		lock    sync.Mutex
+		current int
+		maximum int
+	)
+
+	for i := 0; i < 50; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if !assert.NoError(semaphore.Acquire(context.Background(), 1)) {
+				return
+			}
+
+			defer semaphore.Release(1)
+
+			lock.Lock()
+			current++
+			if current > maximum {
+				maximum = current
+			}
+			lock.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			lock.Lock()
+			current--
+			lock.Unlock()
+		}()
+	}
+
+	waitGroup.Wait()
+	assert.True(maximum <= 3)
+	assert.True(semaphore.TryAcquire(3))
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/server"
+	"github.com/Comcast/webpa-common/service"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Config is the fully loaded, validated configuration of a WebPA server
+type Config struct {
+	// Server is the configuration of the primary, alternate, health, pprof, and metrics servers
+	Server *server.WebPA
+
+	// Device is the configuration of the device Manager.  This is never nil, since
+	// device defaults are always applied.
+	Device *device.Options
+
+	// Discovery is the service discovery configuration.  It is nil if the configuration
+	// has no service discovery section.
+	Discovery *service.Options
+
+	// Security is the inbound authorization configuration.  It is nil if the configuration
+	// has no security section.
+	Security *Security
+}
+
+// ApplyDefaults fills in any unset device Manager options with the device package defaults, so
+// that the loaded configuration reflects the values actually in effect.  Service discovery and
+// security have no defaults, as those sections are optional.
+func ApplyDefaults(o *device.Options) {
+	if len(o.DeviceNameHeader) == 0 {
+		o.DeviceNameHeader = device.DefaultDeviceNameHeader
+	}
+
+	if len(o.ConveyHeader) == 0 {
+		o.ConveyHeader = device.DefaultConveyHeader
+	}
+
+	if o.HandshakeTimeout == 0 {
+		o.HandshakeTimeout = device.DefaultHandshakeTimeout
+	}
+
+	if o.IdlePeriod == 0 {
+		o.IdlePeriod = device.DefaultIdlePeriod
+	}
+
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = device.DefaultWriteTimeout
+	}
+
+	if o.PingPeriod == 0 {
+		o.PingPeriod = device.DefaultPingPeriod
+	}
+
+	if o.DeviceMessageQueueSize == 0 {
+		o.DeviceMessageQueueSize = device.DefaultDeviceMessageQueueSize
+	}
+}
+
+/*
+Load reads and validates the configuration for a WebPA server.  It is a superset of server.Initialize:
+
+    var (
+      f = pflag.NewFlagSet("petasos", pflag.ContinueOnError)
+      v = viper.New()
+
+      logger, c, err = config.Load("petasos", os.Args[1:], f, v)
+    )
+
+    if err != nil {
+      logger.Error("Unable to load configuration: %s", err)
+      os.Exit(1)
+    }
+
+    manager := device.NewManager(c.Device, nil)
+
+As with server.Initialize, a logger is always returned.  Any validation problems are returned together
+as an Errors value.
+*/
+func Load(applicationName string, arguments []string, f *pflag.FlagSet, v *viper.Viper) (logger logging.Logger, c *Config, err error) {
+	defer func() {
+		if err != nil {
+			// never return a Config in the presence of an error
+			c = nil
+			if logger == nil {
+				logger = logging.DefaultLogger()
+			}
+		}
+	}()
+
+	if err = server.Configure(applicationName, arguments, f, v); err != nil {
+		return
+	}
+
+	if err = v.ReadInConfig(); err != nil {
+		return
+	}
+
+	c = new(Config)
+	if c.Server, err = newWebPA(v); err != nil {
+		return
+	}
+
+	if logger, err = c.Server.Log.NewLogger(applicationName); err != nil {
+		return
+	}
+
+	if c.Device, err = device.NewOptions(logger, v.Sub(device.DeviceManagerKey)); err != nil {
+		return
+	}
+
+	ApplyDefaults(c.Device)
+
+	if v.IsSet(service.DiscoveryKey) {
+		if c.Discovery, err = service.NewOptions(logger, nil, v.Sub(service.DiscoveryKey)); err != nil {
+			return
+		}
+	}
+
+	if v.IsSet(SecurityKey) {
+		c.Security = new(Security)
+		if err = unmarshalJSON(v.Get(SecurityKey), c.Security); err != nil {
+			return
+		}
+	}
+
+	err = Validate(c)
+	return
+}
+
+func newWebPA(v *viper.Viper) (*server.WebPA, error) {
+	webPA := new(server.WebPA)
+	if err := v.Unmarshal(webPA); err != nil {
+		return nil, err
+	}
+
+	return webPA, nil
+}
+
+// unmarshalJSON decodes a raw Viper value into a struct using its JSON tags and any custom
+// json.Unmarshaler implementations, which Viper's own decoding does not honor.
+func unmarshalJSON(raw interface{}, value interface{}) error {
+	data, err := json.Marshal(normalize(raw))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+// normalize converts the map[interface{}]interface{} values produced by YAML decoding into
+// map[string]interface{}, which encoding/json requires.
+func normalize(raw interface{}) interface{} {
+	switch value := raw.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			normalized[fmt.Sprintf("%v", k)] = normalize(v)
+		}
+
+		return normalized
+
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			normalized[k] = normalize(v)
+		}
+
+		return normalized
+
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for i, v := range value {
+			normalized[i] = normalize(v)
+		}
+
+		return normalized
+
+	default:
+		return raw
+	}
+}
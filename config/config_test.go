@@ -0,0 +1,116 @@
+package config
+
+import (
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/secure/key"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func load(t *testing.T, file string) (*Config, error) {
+	var (
+		f = pflag.NewFlagSet("config", pflag.ContinueOnError)
+		v = viper.New()
+
+		logger, c, err = Load("config", []string{"-f", file}, f, v)
+	)
+
+	require.NotNil(t, logger)
+	return c, err
+}
+
+func TestLoadJSON(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		c, err  = load(t, "example")
+	)
+
+	require.NoError(err)
+	require.NotNil(c)
+
+	require.NotNil(c.Server)
+	assert.Equal(":8080", c.Server.Primary.Address)
+	assert.Equal("config", c.Server.Primary.Name)
+	assert.Equal(30*time.Second, c.Server.Health.LogInterval)
+
+	require.NotNil(c.Device)
+	assert.NotNil(c.Device.Logger)
+	assert.Equal(30*time.Second, c.Device.PingPeriod)
+	assert.Equal(device.DefaultIdlePeriod, c.Device.IdlePeriod)
+	assert.Equal(device.DefaultDeviceNameHeader, c.Device.DeviceNameHeader)
+	assert.Equal(5000, c.Device.InitialCapacity)
+
+	require.NotNil(c.Discovery)
+	assert.Equal([]string{"zk1:2181", "zk2:2181"}, c.Discovery.Servers)
+	assert.Equal(10*time.Second, c.Discovery.Timeout)
+	assert.Equal("example", c.Discovery.ServiceName)
+
+	require.NotNil(c.Security)
+	assert.Equal([]string{"dXNlcjpwYXNzd29yZA=="}, c.Security.Basic)
+	require.NotNil(c.Security.JWT)
+	assert.Equal("current", c.Security.JWT.DefaultKeyId)
+	assert.Equal("http://keys.example.com/{keyId}", c.Security.JWT.Keys.URI)
+	assert.Equal(key.PurposeVerify, c.Security.JWT.Keys.Purpose)
+	assert.Equal(24*time.Hour, time.Duration(c.Security.JWT.Keys.UpdateInterval))
+	assert.Equal(5, c.Security.JWT.Claims.ExpLeeway)
+}
+
+func TestLoadYAML(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		c, err  = load(t, "example-yaml")
+	)
+
+	require.NoError(err)
+	require.NotNil(c)
+	assert.Equal(":8080", c.Server.Primary.Address)
+	assert.Equal(2*time.Minute, c.Device.IdlePeriod)
+	assert.Equal(device.DefaultPingPeriod, c.Device.PingPeriod)
+	assert.Nil(c.Discovery)
+
+	require.NotNil(c.Security)
+	assert.Equal([]string{"dXNlcjpwYXNzd29yZA=="}, c.Security.Basic)
+	require.NotNil(c.Security.JWT)
+	assert.Equal("not a real key", c.Security.JWT.Keys.Data)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		c, err = load(t, "invalid")
+	)
+
+	assert.Nil(c)
+	errors, ok := err.(Errors)
+	if assert.True(ok, "expected an Errors, but got: %v", err) {
+		assert.Len(errors, 7, errors.Error())
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	c, err := load(t, "nosuch")
+	assert.Nil(t, c)
+	assert.Error(t, err)
+}
+
+func TestApplyDefaults(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		o      = &device.Options{PingPeriod: time.Second}
+	)
+
+	ApplyDefaults(o)
+	assert.Equal(device.DefaultDeviceNameHeader, o.DeviceNameHeader)
+	assert.Equal(device.DefaultConveyHeader, o.ConveyHeader)
+	assert.Equal(device.DefaultHandshakeTimeout, o.HandshakeTimeout)
+	assert.Equal(device.DefaultIdlePeriod, o.IdlePeriod)
+	assert.Equal(device.DefaultWriteTimeout, o.WriteTimeout)
+	assert.Equal(time.Second, o.PingPeriod)
+	assert.Equal(device.DefaultDeviceMessageQueueSize, o.DeviceMessageQueueSize)
+}
@@ -0,0 +1,7 @@
+/*
+Package config loads the standard WebPA configuration through Viper.  A single Load call reads the
+server, device manager, service discovery, and security sections from a JSON or YAML file and the
+environment, applies defaults, validates the result, and produces the typed option structs consumed
+by the server, device, service, and secure packages.
+*/
+package config
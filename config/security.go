@@ -0,0 +1,72 @@
+package config
+
+import (
+	"github.com/Comcast/webpa-common/secure"
+	"github.com/Comcast/webpa-common/secure/key"
+	"github.com/SermoDigital/jose/jwt"
+	"strings"
+)
+
+const (
+	// SecurityKey is the Viper subkey under which Security is stored
+	SecurityKey = "secure"
+)
+
+// JWT describes how JWS-signed bearer tokens are validated
+type JWT struct {
+	// DefaultKeyId is the key used when a token does not specify a "kid" header
+	DefaultKeyId string `json:"defaultKeyId"`
+
+	// Keys describes where the verification keys are loaded from
+	Keys key.ResolverFactory `json:"keys"`
+
+	// KeyChain optionally describes an ordered list of key sources, such as a local file
+	// followed by a JWKS endpoint.  When present, it is used instead of Keys.
+	KeyChain key.ChainFactory `json:"keyChain,omitempty"`
+
+	// Claims describes the expected claims and leeways to apply to each token
+	Claims secure.JWTValidatorFactory `json:"claims"`
+}
+
+// Security describes the validation of inbound authorization tokens
+type Security struct {
+	// Basic holds the accepted values of Basic authorization tokens
+	Basic []string `json:"basic,omitempty"`
+
+	// JWT configures the validation of bearer tokens.  If nil, bearer tokens are rejected.
+	JWT *JWT `json:"jwt,omitempty"`
+}
+
+// NewValidator creates the secure.Validator described by this configuration.  A token is valid
+// if any configured scheme accepts it.
+func (s *Security) NewValidator() (secure.Validator, error) {
+	var validators secure.Validators
+	if len(s.Basic) > 0 {
+		validators = append(validators, secure.ExactMatchValidator(strings.Join(s.Basic, ",")))
+	}
+
+	if s.JWT != nil {
+		var (
+			resolver key.Resolver
+			err      error
+		)
+
+		if len(s.JWT.KeyChain) > 0 {
+			resolver, err = s.JWT.KeyChain.NewResolver()
+		} else {
+			resolver, err = s.JWT.Keys.NewResolver()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		validators = append(validators, secure.JWSValidator{
+			DefaultKeyId:  s.JWT.DefaultKeyId,
+			Resolver:      resolver,
+			JWTValidators: []*jwt.Validator{s.JWT.Claims.New()},
+		})
+	}
+
+	return validators, nil
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"fmt"
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/server"
+	"github.com/Comcast/webpa-common/service"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors is the aggregate of every problem found while validating configuration
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// validator accumulates validation errors
+type validator struct {
+	errors Errors
+}
+
+func (v *validator) errorf(format string, arguments ...interface{}) {
+	v.errors = append(v.errors, fmt.Errorf(format, arguments...))
+}
+
+// address verifies that a bind address has the form [host]:port.  Empty addresses
+// are only permitted when the address is optional.
+func (v *validator) address(name, address string, required bool) {
+	if len(address) == 0 {
+		if required {
+			v.errorf("%s: an address is required", name)
+		}
+
+		return
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		v.errorf("%s: invalid address [%s]: %s", name, address, err)
+		return
+	}
+
+	if number, err := strconv.Atoi(port); err == nil {
+		if number < 0 || number > 65535 {
+			v.errorf("%s: port %d is out of range", name, number)
+		}
+	} else if _, err := net.LookupPort("tcp", port); err != nil {
+		v.errorf("%s: invalid port [%s]", name, port)
+	}
+}
+
+// nonNegative verifies that a duration is not negative
+func (v *validator) nonNegative(name string, value time.Duration) {
+	if value < 0 {
+		v.errorf("%s: duration cannot be negative: %s", name, value)
+	}
+}
+
+// required verifies that a string field is set
+func (v *validator) required(name, value string) {
+	if len(strings.TrimSpace(value)) == 0 {
+		v.errorf("%s is required", name)
+	}
+}
+
+// frameType verifies that an optional device frame type is supported
+func (v *validator) frameType(name string, value device.FrameType) {
+	if len(value) > 0 {
+		if err := value.Validate(); err != nil {
+			v.errorf("%s [%s]: %s", name, value, err)
+		}
+	}
+}
+
+func (v *validator) server(w *server.WebPA) {
+	v.address("primary", w.Primary.Address, true)
+	v.address("alternate", w.Alternate.Address, false)
+	v.address("health", w.Health.Address, false)
+	v.address("pprof", w.Pprof.Address, false)
+	v.address("metric", w.Metric.Address, false)
+	v.nonNegative("health.logInterval", w.Health.LogInterval)
+}
+
+func (v *validator) device(o *device.Options) {
+	v.required("device.manager.deviceNameHeader", o.DeviceNameHeader)
+	v.nonNegative("device.manager.handshakeTimeout", o.HandshakeTimeout)
+	v.nonNegative("device.manager.idlePeriod", o.IdlePeriod)
+	v.nonNegative("device.manager.writeTimeout", o.WriteTimeout)
+	v.nonNegative("device.manager.pingPeriod", o.PingPeriod)
+
+	if o.PingPeriod > 0 && o.IdlePeriod > 0 && o.PingPeriod >= o.IdlePeriod {
+		v.errorf("device.manager.pingPeriod [%s] must be less than idlePeriod [%s]", o.PingPeriod, o.IdlePeriod)
+	}
+
+	for _, expression := range o.AllowedOriginPatterns {
+		if _, err := regexp.Compile(expression); err != nil {
+			v.errorf("device.manager.allowedOriginPatterns [%s]: %s", expression, err)
+		}
+	}
+
+	v.frameType("device.manager.msgpackFrameType", o.MsgpackFrameType)
+	v.frameType("device.manager.jsonFrameType", o.JSONFrameType)
+
+	protocols := make(map[string]bool, len(o.Protocols))
+	for i, protocol := range o.Protocols {
+		name := fmt.Sprintf("device.manager.protocols[%d]", i)
+		v.required(name+".name", protocol.Name)
+		if protocols[protocol.Name] {
+			v.errorf("%s.name [%s]: duplicate protocol", name, protocol.Name)
+		}
+
+		protocols[protocol.Name] = true
+		v.frameType(name+".frameType", protocol.FrameType)
+	}
+
+	v.nonNegative("device.manager.slowConsumerWriteStall", o.SlowConsumerWriteStall)
+	v.nonNegative("device.manager.slowConsumerPeriod", o.SlowConsumerPeriod)
+	switch o.SlowConsumerPolicy {
+	case "", device.CloseSlowConsumer, device.DegradeSlowConsumer:
+	default:
+		v.errorf("device.manager.slowConsumerPolicy [%s]: must be one of %s or %s", o.SlowConsumerPolicy, device.CloseSlowConsumer, device.DegradeSlowConsumer)
+	}
+
+	switch o.QueuePolicy {
+	case "", device.BlockFullQueue, device.FailFullQueue, device.DropOldestFullQueue:
+	default:
+		v.errorf("device.manager.queuePolicy [%s]: must be one of %s, %s, or %s", o.QueuePolicy, device.BlockFullQueue, device.FailFullQueue, device.DropOldestFullQueue)
+	}
+
+	switch o.SignaturePolicy {
+	case "", device.RejectBadSignature, device.FlagBadSignature:
+	default:
+		v.errorf("device.manager.signaturePolicy [%s]: must be one of %s or %s", o.SignaturePolicy, device.RejectBadSignature, device.FlagBadSignature)
+	}
+
+	switch o.DeviceLimitPolicy {
+	case "", device.RejectOverLimit, device.EvictIdleOverLimit:
+	default:
+		v.errorf("device.manager.deviceLimitPolicy [%s]: must be one of %s or %s", o.DeviceLimitPolicy, device.RejectOverLimit, device.EvictIdleOverLimit)
+	}
+
+	if o.HardDeviceLimit > 0 && o.DeviceLimitRecovery >= o.HardDeviceLimit {
+		v.errorf("device.manager.deviceLimitRecovery [%d]: must be less than hardDeviceLimit [%d]", o.DeviceLimitRecovery, o.HardDeviceLimit)
+	}
+
+	if o.HardDeviceLimit > 0 && o.SoftDeviceLimit > o.HardDeviceLimit {
+		v.errorf("device.manager.softDeviceLimit [%d]: must not exceed hardDeviceLimit [%d]", o.SoftDeviceLimit, o.HardDeviceLimit)
+	}
+}
+
+func (v *validator) discovery(o *service.Options) {
+	if len(strings.TrimSpace(o.Connection)) == 0 && len(o.Servers) == 0 {
+		v.errorf("%s: either connection or servers is required", service.DiscoveryKey)
+	}
+
+	v.nonNegative(service.DiscoveryKey+".timeout", o.Timeout)
+	for i, registration := range o.Registrations {
+		v.required(fmt.Sprintf("%s.registrations[%d]", service.DiscoveryKey, i), registration)
+	}
+}
+
+func (v *validator) security(s *Security) {
+	for i, basic := range s.Basic {
+		v.required(fmt.Sprintf("%s.basic[%d]", SecurityKey, i), basic)
+	}
+
+	if s.JWT != nil {
+		if _, err := s.JWT.Keys.URL(); err != nil {
+			v.errorf("%s.jwt.keys: %s", SecurityKey, err)
+		}
+
+		if s.JWT.Claims.ExpLeeway < 0 || s.JWT.Claims.NbfLeeway < 0 {
+			v.errorf("%s.jwt.claims: leeways cannot be negative", SecurityKey)
+		}
+	}
+}
+
+// Validate checks a Config for problems, returning an Errors containing all of them.
+// If the Config is valid, this function returns nil.
+func Validate(c *Config) error {
+	v := new(validator)
+	if c.Server != nil {
+		v.server(c.Server)
+	} else {
+		v.errorf("server configuration is required")
+	}
+
+	if c.Device != nil {
+		v.device(c.Device)
+	}
+
+	if c.Discovery != nil {
+		v.discovery(c.Discovery)
+	}
+
+	if c.Security != nil {
+		v.security(c.Security)
+	}
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+
+	return nil
+}
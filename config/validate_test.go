@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/server"
+	"github.com/Comcast/webpa-common/service"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestErrors(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", Errors{}.Error())
+	assert.Equal("first", Errors{errors.New("first")}.Error())
+	assert.Equal("first; second", Errors{errors.New("first"), errors.New("second")}.Error())
+}
+
+func TestValidatorAddress(t *testing.T) {
+	testData := []struct {
+		address  string
+		required bool
+		valid    bool
+	}{
+		{"", false, true},
+		{"", true, false},
+		{":8080", true, true},
+		{"localhost:0", true, true},
+		{":http", true, true},
+		{"127.0.0.1:65535", true, true},
+		{":65536", true, false},
+		{":-1", true, false},
+		{":nosuchservice", true, false},
+		{"localhost", true, false},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		v := new(validator)
+		v.address("test", record.address, record.required)
+		assert.Equal(t, record.valid, len(v.errors) == 0, "%v", v.errors)
+	}
+}
+
+func TestValidateNoServer(t *testing.T) {
+	err := Validate(new(Config))
+	assert.Len(t, err, 1)
+}
+
+func TestValidate(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		valid  = &Config{
+			Server: &server.WebPA{Primary: server.Basic{Address: ":8080"}},
+			Device: &device.Options{
+				DeviceNameHeader: device.DefaultDeviceNameHeader,
+				PingPeriod:       time.Minute,
+				IdlePeriod:       2 * time.Minute,
+			},
+			Discovery: &service.Options{Connection: "localhost:2181"},
+			Security:  &Security{Basic: []string{"value"}},
+		}
+	)
+
+	assert.Nil(Validate(valid))
+
+	valid.Device.DeviceNameHeader = ""
+	valid.Discovery.Connection = ""
+	valid.Discovery.Timeout = -time.Second
+	valid.Device.MsgpackFrameType = "invalid"
+	valid.Device.AllowedOriginPatterns = []string{`^https://.*\.example\.com$`, "("}
+	valid.Device.SlowConsumerPolicy = "invalid"
+	valid.Device.SignaturePolicy = "invalid"
+	valid.Device.QueuePolicy = "invalid"
+	valid.Device.SoftDeviceLimit = 100
+	valid.Device.HardDeviceLimit = 10
+	valid.Device.DeviceLimitPolicy = "invalid"
+	valid.Device.DeviceLimitRecovery = 10
+	valid.Device.Protocols = []device.Protocol{{Name: "wrp-2"}, {Name: "wrp-2", FrameType: "invalid"}, {}}
+	assert.Len(Validate(valid), 14)
+}
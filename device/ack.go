@@ -0,0 +1,198 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/wrp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// AckMetadataKey is the WRP metadata key that carries the delivery identifier of a message sent with
+	// Request.Acknowledge.  A device acknowledges the message by sending back any message with the same
+	// identifier under this key.
+	AckMetadataKey = "ack"
+
+	DefaultAckTimeout    time.Duration = 10 * time.Second
+	DefaultAckMaxTimeout time.Duration = 2 * time.Minute
+	DefaultAckRetries                  = 3
+
+	// ackIDSize is the count of random bytes in delivery identifiers
+	ackIDSize = 12
+)
+
+// ackPolicy is the retry policy for messages that must be acknowledged by devices
+type ackPolicy struct {
+	timeout    time.Duration
+	maxTimeout time.Duration
+	retries    int
+}
+
+// wait returns how long to wait for the acknowledgement of the given attempt, starting at 1.  The wait
+// doubles with each attempt, up to maxTimeout.
+func (p ackPolicy) wait(attempt int) time.Duration {
+	wait := p.timeout
+	for i := 1; i < attempt && wait < p.maxTimeout; i++ {
+		wait *= 2
+	}
+
+	if wait > p.maxTimeout {
+		wait = p.maxTimeout
+	}
+
+	return wait
+}
+
+// pendingAck is a message that has been written to a device and is awaiting acknowledgement
+type pendingAck struct {
+	envelope *envelope
+	timer    *time.Timer
+}
+
+// acknowledgements is the set of messages that a single device connection is expected to acknowledge
+type acknowledgements struct {
+	lock    sync.Mutex
+	pending map[string]*pendingAck
+}
+
+// await starts waiting for the acknowledgement of an envelope.  If no acknowledgement arrives before the
+// wait elapses, expired is invoked on its own goroutine.
+func (a *acknowledgements) await(e *envelope, wait time.Duration, expired func()) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.pending == nil {
+		a.pending = make(map[string]*pendingAck)
+	}
+
+	p := &pendingAck{envelope: e}
+	p.timer = time.AfterFunc(wait, func() {
+		if a.remove(e.ackID, p) {
+			expired()
+		}
+	})
+
+	a.pending[e.ackID] = p
+}
+
+// remove deletes a pending acknowledgement, returning true if this call removed it
+func (a *acknowledgements) remove(id string, p *pendingAck) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.pending[id] == p {
+		delete(a.pending, id)
+		return true
+	}
+
+	return false
+}
+
+// cancel stops waiting for the acknowledgement of an envelope that could not be written, returning true
+// if the envelope was still pending
+func (a *acknowledgements) cancel(e *envelope) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	p, ok := a.pending[e.ackID]
+	if ok && p.envelope == e {
+		p.timer.Stop()
+		delete(a.pending, e.ackID)
+		return true
+	}
+
+	return false
+}
+
+// acknowledge completes the message with the given delivery identifier.  This method returns false if no
+// such message is awaiting acknowledgement, e.g. because the acknowledgement arrived after the message was
+// retried or abandoned.
+func (a *acknowledgements) acknowledge(id string) bool {
+	a.lock.Lock()
+	p, ok := a.pending[id]
+	if ok {
+		delete(a.pending, id)
+		p.timer.Stop()
+	}
+
+	a.lock.Unlock()
+
+	if ok {
+		close(p.envelope.complete)
+	}
+
+	return ok
+}
+
+// abandon stops waiting for all pending acknowledgements, returning their envelopes in the order
+// they were originally enqueued
+func (a *acknowledgements) abandon() []*envelope {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	abandoned := make([]*envelope, 0, len(a.pending))
+	for id, p := range a.pending {
+		// once deleted, a timer that has already fired cannot claim its envelope
+		p.timer.Stop()
+		delete(a.pending, id)
+		abandoned = append(abandoned, p.envelope)
+	}
+
+	sort.Slice(abandoned, func(i, j int) bool { return abandoned[i].enqueuedAt.Before(abandoned[j].enqueuedAt) })
+	return abandoned
+}
+
+// acknowledgedMessage returns a copy of the given encodable message that carries the envelope's delivery
+// identifier.  As with signedMessage, the original routable determines whether the encodable message is
+// already a private copy.
+func acknowledgedMessage(e *envelope, encodable interface{}) interface{} {
+	message, ok := encodable.(*wrp.Message)
+	if !ok || len(e.ackID) == 0 {
+		return encodable
+	}
+
+	if shared, ok := e.request.Message.(*wrp.Message); ok && shared == message {
+		message = copyMessage(message, 1)
+	}
+
+	message.Metadata[AckMetadataKey] = e.ackID
+	return message
+}
+
+// awaitAck waits for a device to acknowledge an envelope that is about to be written to it
+func (m *manager) awaitAck(d *device, e *envelope) {
+	e.attempts++
+	d.acks.await(e, m.ackPolicy.wait(e.attempts), func() { m.ackExpired(d, e) })
+}
+
+// ackExpired either queues an unacknowledged envelope to be written again or, once its retries are
+// exhausted, fails it with ErrorNotAcknowledged
+func (m *manager) ackExpired(d *device, e *envelope) {
+	if e.request.Context().Err() != nil {
+		// the sender has stopped waiting, so there is no point in retrying
+		return
+	}
+
+	if e.attempts > m.ackPolicy.retries {
+		d.logger.Error("Device [%s] did not acknowledge message %s after %d attempts", d.id, e.ackID, e.attempts)
+		rejectEnvelope(e, ErrorNotAcknowledged)
+		m.dispatch(&Event{
+			Type:    MessageFailed,
+			Device:  d,
+			Message: e.request.Message,
+			Format:  e.request.Format,
+			Error:   ErrorNotAcknowledged,
+		})
+
+		return
+	}
+
+	d.logger.Debug("Retrying unacknowledged message %s to device [%s]", e.ackID, d.id)
+	select {
+	case d.messages[e.request.EffectivePriority()] <- e:
+		d.queueChanged(1)
+	case <-d.shutdown:
+		rejectEnvelope(e, ErrorDeviceClosed)
+	case <-e.request.Context().Done():
+	}
+}
@@ -0,0 +1,267 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestAckPolicy(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		policy = ackPolicy{timeout: time.Second, maxTimeout: 5 * time.Second, retries: 2}
+	)
+
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(expected, policy.wait(attempt+1))
+	}
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Equal(ackPolicy{DefaultAckTimeout, DefaultAckMaxTimeout, DefaultAckRetries}, o.ackPolicy())
+	}
+
+	assert.Equal(
+		ackPolicy{time.Second, time.Minute, 5},
+		(&Options{AckTimeout: time.Second, AckMaxTimeout: time.Minute, AckRetries: 5}).ackPolicy(),
+	)
+
+	// the maximum can never be less than the initial timeout
+	assert.Equal(
+		ackPolicy{10 * time.Minute, 10 * time.Minute, DefaultAckRetries},
+		(&Options{AckTimeout: 10 * time.Minute}).ackPolicy(),
+	)
+}
+
+func newTestAckEnvelope(id string, enqueuedAt time.Time) (*envelope, <-chan error) {
+	complete := make(chan error, 1)
+	return &envelope{
+		request:    &Request{Message: new(wrp.Message)},
+		complete:   complete,
+		enqueuedAt: enqueuedAt,
+		ackID:      id,
+	}, complete
+}
+
+func testAcknowledgementsAcknowledge(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		acks         acknowledgements
+		e, complete  = newTestAckEnvelope("1", time.Now())
+		expiredCount int
+	)
+
+	acks.await(e, time.Hour, func() { expiredCount++ })
+	assert.False(acks.acknowledge("unknown"))
+	assert.True(acks.acknowledge("1"))
+	assert.False(acks.acknowledge("1"))
+
+	select {
+	case err, ok := <-complete:
+		assert.NoError(err)
+		assert.False(ok)
+	default:
+		assert.Fail("The envelope was not completed")
+	}
+
+	assert.Zero(expiredCount)
+	assert.Empty(acks.abandon())
+}
+
+func testAcknowledgementsExpired(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		acks    acknowledgements
+		e, _    = newTestAckEnvelope("1", time.Now())
+		expired = make(chan struct{})
+	)
+
+	acks.await(e, time.Millisecond, func() { close(expired) })
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		assert.Fail("The acknowledgement did not expire")
+	}
+
+	assert.False(acks.acknowledge("1"))
+	assert.False(acks.cancel(e))
+}
+
+func testAcknowledgementsCancel(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		acks   acknowledgements
+		e, _   = newTestAckEnvelope("1", time.Now())
+	)
+
+	acks.await(e, time.Hour, func() { assert.Fail("A cancelled acknowledgement should not expire") })
+	assert.True(acks.cancel(e))
+	assert.False(acks.cancel(e))
+	assert.False(acks.acknowledge("1"))
+}
+
+func testAcknowledgementsAbandon(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		acks     acknowledgements
+		now      = time.Now()
+		expected = []*envelope{}
+	)
+
+	for i, id := range []string{"c", "a", "b"} {
+		e, _ := newTestAckEnvelope(id, now.Add(time.Duration(i)*time.Second))
+		expected = append(expected, e)
+		acks.await(e, time.Hour, func() { assert.Fail("An abandoned acknowledgement should not expire") })
+	}
+
+	assert.Equal(expected, acks.abandon())
+	assert.Empty(acks.abandon())
+	assert.False(acks.acknowledge("a"))
+}
+
+func TestAcknowledgements(t *testing.T) {
+	t.Run("Acknowledge", testAcknowledgementsAcknowledge)
+	t.Run("Expired", testAcknowledgementsExpired)
+	t.Run("Cancel", testAcknowledgementsCancel)
+	t.Run("Abandon", testAcknowledgementsAbandon)
+}
+
+func TestAcknowledgedMessage(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		original = &wrp.Message{Destination: "mac:112233445566", Metadata: map[string]string{"foo": "bar"}}
+		e, _     = newTestAckEnvelope("abc", time.Now())
+	)
+
+	e.request.Message = original
+	acknowledged, ok := acknowledgedMessage(e, original).(*wrp.Message)
+	require.True(ok)
+	assert.False(acknowledged == original)
+	assert.Equal(map[string]string{"foo": "bar", AckMetadataKey: "abc"}, acknowledged.Metadata)
+	assert.Equal(map[string]string{"foo": "bar"}, original.Metadata)
+
+	// an encodable message that is already a copy is modified in place
+	copied := copyMessage(original, 1)
+	assert.True(acknowledgedMessage(e, copied) == copied)
+	assert.Equal("abc", copied.Metadata[AckMetadataKey])
+
+	e.ackID = ""
+	assert.True(acknowledgedMessage(e, original) == original)
+
+	simple := &wrp.SimpleEvent{Destination: "mac:112233445566"}
+	assert.True(acknowledgedMessage(e, simple) == simple)
+}
+
+// readAckMessage reads the next message written to a test device, returning its delivery identifier
+func readAckMessage(t *testing.T, c Connection) string {
+	var frame bytes.Buffer
+	_, err := c.Read(&frame)
+	require.NoError(t, err)
+
+	message := new(wrp.Message)
+	require.NoError(t, wrp.NewDecoderBytes(frame.Bytes(), c.Format()).Decode(message))
+	require.NotEmpty(t, message.Metadata[AckMetadataKey])
+	return message.Metadata[AckMetadataKey]
+}
+
+func TestManagerAcknowledge(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		connected    = make(chan Interface, 1)
+		received     = make(chan *wrp.Message, 1)
+		failed       = make(chan error, 10)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:        logging.TestLogger(t),
+			AckTimeout:    200 * time.Millisecond,
+			AckMaxTimeout: time.Second,
+			AckRetries:    1,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived:
+						received <- event.Message.(*wrp.Message)
+					case MessageFailed:
+						failed <- event.Error
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	send := func(message wrp.Routable) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, err := d.Send(&Request{Message: message, Acknowledge: true, ctx: context.Background()})
+			result <- err
+		}()
+
+		return result
+	}
+
+	write := func(message *wrp.Message) {
+		var encoded []byte
+		require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(message))
+		_, err := c.Write(encoded)
+		require.NoError(err)
+	}
+
+	// an unacknowledged message is written again with the same delivery identifier
+	result := send(&wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566"})
+	ackID := readAckMessage(t, c)
+	assert.Equal(ackID, readAckMessage(t, c))
+
+	write(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Metadata: map[string]string{AckMetadataKey: ackID}})
+	select {
+	case err := <-result:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		require.Fail("Send did not return after the acknowledgement")
+	}
+
+	// a message that is never acknowledged fails once its retries are exhausted
+	result = send(&wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566"})
+	ackID = readAckMessage(t, c)
+	assert.Equal(ackID, readAckMessage(t, c))
+	select {
+	case err := <-result:
+		assert.Equal(ErrorNotAcknowledged, err)
+	case <-time.After(5 * time.Second):
+		require.Fail("Send did not fail")
+	}
+
+	assert.Equal(ErrorNotAcknowledged, <-failed)
+
+	// a message with a payload doubles as an acknowledgement and is delivered as usual
+	write(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Payload: []byte("event"), Metadata: map[string]string{AckMetadataKey: ackID}})
+	select {
+	case message := <-received:
+		assert.Equal([]byte("event"), message.Payload)
+	case <-time.After(5 * time.Second):
+		require.Fail("The message was not received")
+	}
+
+	_, err = d.Send(&Request{Message: &wrp.SimpleEvent{Destination: "mac:112233445566"}, Acknowledge: true})
+	assert.Equal(ErrorAckUnsupported, err)
+
+	c.Close()
+	<-disconnected
+}
@@ -0,0 +1,266 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/health"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMetricsPath = "/metrics"
+	DefaultHealthPath  = "/health"
+	DefaultDevicesPath = "/devices"
+	DefaultDebugPath   = "/devices/debug"
+
+	DefaultHealthInterval time.Duration = 5 * time.Second
+)
+
+// AdminOptions configures the set of components produced by NewAdmin
+type AdminOptions struct {
+	// Device holds the options for the Manager.  These options are copied, so the Metrics,
+	// Health, and Listeners supplied by NewAdmin never leak back into the caller's configuration.
+	// Any Listeners configured here are retained and receive events along with the device list.
+	Device *Options
+
+	// Metrics configures the Registry that backs the Manager's metrics.  If nil, a Registry
+	// with default options is created.
+	Metrics *xmetrics.Options
+
+	// HealthInterval is the interval at which health stats are dispatched.  If nonpositive,
+	// DefaultHealthInterval is used.
+	HealthInterval time.Duration
+
+	// HealthOptions are the initial health stats
+	HealthOptions []health.Option
+
+	// MessageFormat is the format of the WRP messages posted to the Messages handler.  The zero
+	// value is wrp.Msgpack.
+	MessageFormat wrp.Format
+
+	// RefreshInterval is the interval at which the JSON device list is updated.  If nonpositive,
+	// DefaultRefreshInterval is used.
+	RefreshInterval time.Duration
+
+	// MetricsPath, HealthPath, DevicesPath, DebugPath, and InspectPath are where the admin handlers are mounted.
+	// Any path that is unset uses the corresponding Default*Path constant.
+	MetricsPath string
+	HealthPath  string
+	DevicesPath string
+	DebugPath   string
+	InspectPath string
+}
+
+func (o *AdminOptions) device() *Options {
+	device := new(Options)
+	if o != nil && o.Device != nil {
+		*device = *o.Device
+	}
+
+	return device
+}
+
+func (o *AdminOptions) metrics() *xmetrics.Options {
+	if o != nil {
+		return o.Metrics
+	}
+
+	return nil
+}
+
+func (o *AdminOptions) healthInterval() time.Duration {
+	if o != nil && o.HealthInterval > 0 {
+		return o.HealthInterval
+	}
+
+	return DefaultHealthInterval
+}
+
+func (o *AdminOptions) healthOptions() []health.Option {
+	if o != nil {
+		return o.HealthOptions
+	}
+
+	return nil
+}
+
+func (o *AdminOptions) messageFormat() wrp.Format {
+	if o != nil {
+		return o.MessageFormat
+	}
+
+	return wrp.Msgpack
+}
+
+func (o *AdminOptions) refreshInterval() time.Duration {
+	if o != nil {
+		return o.RefreshInterval
+	}
+
+	return 0
+}
+
+func (o *AdminOptions) path(configured, defaultPath string) string {
+	if len(configured) > 0 {
+		return configured
+	}
+
+	return defaultPath
+}
+
+func (o *AdminOptions) metricsPath() string {
+	if o != nil {
+		return o.path(o.MetricsPath, DefaultMetricsPath)
+	}
+
+	return DefaultMetricsPath
+}
+
+func (o *AdminOptions) healthPath() string {
+	if o != nil {
+		return o.path(o.HealthPath, DefaultHealthPath)
+	}
+
+	return DefaultHealthPath
+}
+
+func (o *AdminOptions) devicesPath() string {
+	if o != nil {
+		return o.path(o.DevicesPath, DefaultDevicesPath)
+	}
+
+	return DefaultDevicesPath
+}
+
+func (o *AdminOptions) debugPath() string {
+	if o != nil {
+		return o.path(o.DebugPath, DefaultDebugPath)
+	}
+
+	return DefaultDebugPath
+}
+
+func (o *AdminOptions) inspectPath() string {
+	if o != nil {
+		return strings.TrimSuffix(o.path(o.InspectPath, DefaultInspectPath), "/")
+	}
+
+	return DefaultInspectPath
+}
+
+// Admin is the fully wired set of components for a server that manages device connections.
+// The Connect and Messages handlers are meant for the public API, while Handler serves the
+// administrative endpoints: metrics, health, the connected device list, and device diagnostics.
+//
+// The Health monitor is not started until Run is called, so Run should be called before devices connect.
+type Admin struct {
+	Manager  Manager
+	Registry xmetrics.Registry
+	Health   *health.Health
+	Logger   logging.Logger
+
+	// Connect upgrades device connections using Manager
+	Connect *ConnectHandler
+
+	// Messages routes WRP messages to devices using Manager
+	Messages *MessageHandler
+
+	// Devices serves the JSON list of connected devices
+	Devices *ListHandler
+
+	// Debug serves the diagnostic state of an individual device
+	Debug *DebugHandler
+
+	// Inspect serves the paginated device list, individual devices, and forced disconnection
+	Inspect *InspectHandler
+
+	// Handler is the administrative mux, with the registry's metrics, Health, Devices, Debug,
+	// and Inspect each mounted at their configured paths
+	Handler *http.ServeMux
+
+	listener *ConnectedDeviceListener
+	once     sync.Once
+}
+
+// NewAdmin creates a Manager along with its metrics, health monitor, and HTTP handlers.  This
+// function replaces the boilerplate otherwise needed to assemble a device-centric server.
+func NewAdmin(o *AdminOptions) (*Admin, error) {
+	registry, err := xmetrics.NewRegistry(o.metrics())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		deviceOptions = o.device()
+		logger        = deviceOptions.logger()
+		monitor       = health.New(o.healthInterval(), logger, o.healthOptions()...)
+		listener      = &ConnectedDeviceListener{RefreshInterval: o.refreshInterval()}
+	)
+
+	listenerFunc, updates := listener.Listen()
+	deviceOptions.Metrics = registry
+	deviceOptions.Health = monitor
+	deviceOptions.Listeners = append(append(make([]Listener, 0, len(deviceOptions.Listeners)+1), deviceOptions.Listeners...), listenerFunc)
+
+	manager := NewManager(deviceOptions, nil)
+	admin := &Admin{
+		Manager:  manager,
+		Registry: registry,
+		Health:   monitor,
+		Logger:   logger,
+		Connect: &ConnectHandler{
+			Logger:    logger,
+			Connector: manager,
+		},
+		Messages: &MessageHandler{
+			Logger:   logger,
+			Decoders: wrp.NewDecoderPool(0, o.messageFormat()),
+			Router:   manager,
+		},
+		Devices: new(ListHandler),
+		Debug: &DebugHandler{
+			Logger:   logger,
+			Registry: manager,
+		},
+		Inspect: &InspectHandler{
+			Logger:  logger,
+			Manager: manager,
+		},
+		Handler:  http.NewServeMux(),
+		listener: listener,
+	}
+
+	admin.Devices.Consume(updates)
+	admin.Handler.Handle(o.metricsPath(), registry.Handler())
+	admin.Handler.Handle(o.healthPath(), monitor)
+	admin.Handler.Handle(o.devicesPath(), admin.Devices)
+	admin.Handler.Handle(o.debugPath(), admin.Debug)
+
+	inspectPath := o.inspectPath()
+	admin.Handler.Handle(inspectPath, http.StripPrefix(inspectPath, admin.Inspect))
+	admin.Handler.Handle(inspectPath+"/", http.StripPrefix(inspectPath, admin.Inspect))
+	return admin, nil
+}
+
+// Run starts the health monitor.  When the shutdown channel is closed, the health monitor stops and
+// the device list stops updating.  This method is idempotent.
+func (a *Admin) Run(waitGroup *sync.WaitGroup, shutdown <-chan struct{}) (err error) {
+	a.once.Do(func() {
+		if err = a.Health.Run(waitGroup, shutdown); err != nil {
+			return
+		}
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			<-shutdown
+			a.listener.Stop()
+		}()
+	})
+
+	return
+}
@@ -0,0 +1,191 @@
+package device
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testAdminOptionsDefaults(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*AdminOptions{nil, new(AdminOptions)} {
+		assert.NotNil(o.device())
+		assert.Nil(o.metrics())
+		assert.Equal(DefaultHealthInterval, o.healthInterval())
+		assert.Empty(o.healthOptions())
+		assert.Zero(o.refreshInterval())
+		assert.Equal(DefaultMetricsPath, o.metricsPath())
+		assert.Equal(DefaultHealthPath, o.healthPath())
+		assert.Equal(DefaultDevicesPath, o.devicesPath())
+		assert.Equal(DefaultDebugPath, o.debugPath())
+		assert.Equal(DefaultInspectPath, o.inspectPath())
+	}
+}
+
+func testAdminOptionsCustom(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		deviceOptions = &Options{Listeners: []Listener{func(*Event) {}}}
+		o             = AdminOptions{
+			Device:          deviceOptions,
+			Metrics:         &xmetrics.Options{Namespace: "test"},
+			HealthInterval:  time.Minute,
+			RefreshInterval: time.Hour,
+			MetricsPath:     "/custom/metrics",
+			HealthPath:      "/custom/health",
+			DevicesPath:     "/custom/devices",
+			DebugPath:       "/custom/debug",
+			InspectPath:     "/custom/inspect/",
+		}
+	)
+
+	copied := o.device()
+	assert.False(copied == deviceOptions)
+	assert.Len(copied.Listeners, 1)
+	assert.Equal("test", o.metrics().Namespace)
+	assert.Equal(time.Minute, o.healthInterval())
+	assert.Equal(time.Hour, o.refreshInterval())
+	assert.Equal("/custom/metrics", o.metricsPath())
+	assert.Equal("/custom/health", o.healthPath())
+	assert.Equal("/custom/devices", o.devicesPath())
+	assert.Equal("/custom/debug", o.debugPath())
+	assert.Equal("/custom/inspect", o.inspectPath())
+}
+
+func testNewAdminInvalidMetrics(t *testing.T) {
+	assert := assert.New(t)
+	admin, err := NewAdmin(&AdminOptions{
+		Metrics: &xmetrics.Options{Metrics: []xmetrics.Metric{{Name: "bad", Type: "nosuch"}}},
+	})
+
+	assert.Nil(admin)
+	assert.Error(err)
+}
+
+func testNewAdminHandlers(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		deviceOptions = &Options{Logger: logging.TestLogger(t)}
+		connected     = make(chan *Event, 1)
+		disconnected  = make(chan *Event, 1)
+	)
+
+	deviceOptions.Listeners = []Listener{
+		func(e *Event) {
+			switch e.Type {
+			case Connect:
+				connected <- e
+			case Disconnect:
+				disconnected <- e
+			}
+		},
+	}
+
+	admin, err := NewAdmin(&AdminOptions{
+		Device:          deviceOptions,
+		RefreshInterval: 10 * time.Millisecond,
+	})
+
+	require.NoError(err)
+	require.NotNil(admin)
+	assert.Len(deviceOptions.Listeners, 1)
+	assert.Nil(deviceOptions.Metrics)
+	assert.Nil(deviceOptions.Health)
+
+	var (
+		waitGroup = new(sync.WaitGroup)
+		shutdown  = make(chan struct{})
+	)
+
+	require.NoError(admin.Run(waitGroup, shutdown))
+	require.NoError(admin.Run(waitGroup, shutdown))
+	defer func() {
+		close(shutdown)
+		waitGroup.Wait()
+	}()
+
+	server := httptest.NewServer(admin.Connect)
+	defer server.Close()
+
+	connectURL, err := url.Parse(server.URL)
+	require.NoError(err)
+	connectURL.Scheme = "ws"
+
+	dialer := NewDialer(deviceOptions, nil)
+	connection, _, err := dialer.Dial(connectURL.String(), "mac:112233445566", nil, nil)
+	require.NoError(err)
+	defer func() {
+		connection.Close()
+		select {
+		case <-disconnected:
+		case <-time.After(5 * time.Second):
+			assert.Fail("The device did not disconnect")
+		}
+	}()
+
+	select {
+	case e := <-connected:
+		assert.Equal(ID("mac:112233445566"), e.Device.ID())
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not connect")
+	}
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		admin.Handler.ServeHTTP(response, httptest.NewRequest("GET", target, nil))
+		return response
+	}
+
+	metrics := serve(DefaultMetricsPath)
+	assert.Equal(http.StatusOK, metrics.Code)
+	assert.Contains(metrics.Body.String(), DeviceCount+" 1")
+
+	health := serve(DefaultHealthPath)
+	assert.Equal(http.StatusOK, health.Code)
+
+	var devices struct {
+		Devices []map[string]interface{} `json:"devices"`
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); len(devices.Devices) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		list := serve(DefaultDevicesPath)
+		require.Equal(http.StatusOK, list.Code)
+		require.NoError(json.Unmarshal(list.Body.Bytes(), &devices))
+	}
+
+	assert.Len(devices.Devices, 1)
+
+	debug := serve(DefaultDebugPath + "?" + DebugIDParameter + "=mac:112233445566")
+	assert.Equal(http.StatusOK, debug.Code)
+	assert.Contains(debug.Body.String(), "mac:112233445566")
+
+	inspect := serve(DefaultInspectPath)
+	assert.Equal(http.StatusOK, inspect.Code)
+	assert.Contains(inspect.Body.String(), `"total":1`)
+
+	inspect = serve(DefaultInspectPath + "/mac:112233445566")
+	assert.Equal(http.StatusOK, inspect.Code)
+	assert.Contains(inspect.Body.String(), "mac:112233445566")
+}
+
+func TestAdminOptions(t *testing.T) {
+	t.Run("Defaults", testAdminOptionsDefaults)
+	t.Run("Custom", testAdminOptionsCustom)
+}
+
+func TestNewAdmin(t *testing.T) {
+	t.Run("InvalidMetrics", testNewAdminInvalidMetrics)
+	t.Run("Handlers", testNewAdminHandlers)
+}
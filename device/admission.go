@@ -0,0 +1,118 @@
+package device
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+// Tags are name/value pairs attached to a device connection when it is admitted.  Tags are
+// fixed for the lifetime of the connection.
+type Tags map[string]string
+
+// ConnectRequest describes a websocket upgrade that has passed the Manager's own checks
+// and is about to be admitted
+type ConnectRequest struct {
+	// Request is the HTTP upgrade request
+	Request *http.Request
+
+	// ID is the parsed device name
+	ID ID
+
+	// Convey is the device's decoded convey payload, or nil if the device did not send one or
+	// sent an invalid one that the ConveyPolicy accepted
+	Convey Convey
+
+	// Certificates is the verified chain of the device's TLS client certificate, leaf first, or nil if the
+	// device did not present a client certificate that the server verified
+	Certificates []*x509.Certificate
+
+	// Connected is the count of devices, including duplicates, already connected with this ID.  This count
+	// is advisory, since other connections with the same ID may be admitted concurrently.
+	Connected int
+}
+
+// ConnectAuthorizer is consulted before each websocket upgrade, which allows connections to be rejected
+// before any resources are allocated for them.  A nil error admits the connection, and the returned Tags,
+// which may be nil, are attached to the device.
+//
+// A *Rejection error is written to the device as is, which allows an authorizer to choose the HTTP status
+// and reason.  Any other error rejects the connection with RejectUnauthorized.
+type ConnectAuthorizer interface {
+	AuthorizeConnect(*ConnectRequest) (Tags, error)
+}
+
+// ConnectAuthorizerFunc is a function type that implements ConnectAuthorizer
+type ConnectAuthorizerFunc func(*ConnectRequest) (Tags, error)
+
+func (f ConnectAuthorizerFunc) AuthorizeConnect(request *ConnectRequest) (Tags, error) {
+	return f(request)
+}
+
+// ConnectAuthorizers is an aggregate ConnectAuthorizer.  A connection is admitted only if every authorizer
+// admits it, in order, and the resulting Tags are the union of each authorizer's Tags.  Where authorizers
+// produce the same tag, the later authorizer wins.
+type ConnectAuthorizers []ConnectAuthorizer
+
+func (ca ConnectAuthorizers) AuthorizeConnect(request *ConnectRequest) (Tags, error) {
+	var merged Tags
+	for _, a := range ca {
+		tags, err := a.AuthorizeConnect(request)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, value := range tags {
+			if merged == nil {
+				merged = make(Tags, len(tags))
+			}
+
+			merged[name] = value
+		}
+	}
+
+	return merged, nil
+}
+
+// MaxConnectionsPerID produces a ConnectAuthorizer that rejects a device with RejectDuplicate once max
+// devices with the same ID are connected.  A max of 1 refuses all duplicate connections.
+func MaxConnectionsPerID(max int) ConnectAuthorizer {
+	return ConnectAuthorizerFunc(func(request *ConnectRequest) (Tags, error) {
+		if request.Connected >= max {
+			return nil, newRejection(
+				RejectDuplicate,
+				fmt.Errorf("Device [%s] already has %d connection(s)", request.ID, request.Connected),
+			)
+		}
+
+		return nil, nil
+	})
+}
+
+// authorizeConnect consults this manager's ConnectAuthorizer, if any, returning the Tags for the new device
+func (m *manager) authorizeConnect(response http.ResponseWriter, request *http.Request, id ID, convey Convey, certificates []*x509.Certificate) (Tags, *Rejection) {
+	if m.connectAuthorizer == nil {
+		return nil, nil
+	}
+
+	tags, err := m.connectAuthorizer.AuthorizeConnect(&ConnectRequest{
+		Request:      request,
+		ID:           id,
+		Convey:       convey,
+		Certificates: certificates,
+		Connected:    m.registry.visitID(id, func(*device) {}),
+	})
+
+	if err != nil {
+		rejection, ok := err.(*Rejection)
+		if !ok {
+			rejection = newRejection(RejectUnauthorized, err)
+		}
+
+		m.logger.Error("Device [%s] was not authorized to connect: %s", id, rejection)
+		rejection.WriteResponse(response)
+		return nil, rejection
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,146 @@
+package device
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestConnectAuthorizers(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = &ConnectRequest{ID: ID("mac:112233445566")}
+
+		tagger = func(tags Tags) ConnectAuthorizer {
+			return ConnectAuthorizerFunc(func(actual *ConnectRequest) (Tags, error) {
+				assert.Equal(request, actual)
+				return tags, nil
+			})
+		}
+
+		expectedError = errors.New("expected")
+		failure       = ConnectAuthorizerFunc(func(*ConnectRequest) (Tags, error) { return nil, expectedError })
+	)
+
+	tags, err := ConnectAuthorizers{}.AuthorizeConnect(request)
+	assert.Nil(tags)
+	assert.NoError(err)
+
+	tags, err = ConnectAuthorizers{
+		tagger(Tags{"region": "east", "tier": "gold"}),
+		tagger(nil),
+		tagger(Tags{"tier": "silver"}),
+	}.AuthorizeConnect(request)
+
+	assert.Equal(Tags{"region": "east", "tier": "silver"}, tags)
+	assert.NoError(err)
+
+	tags, err = ConnectAuthorizers{
+		tagger(Tags{"region": "east"}),
+		failure,
+		ConnectAuthorizerFunc(func(*ConnectRequest) (Tags, error) {
+			assert.Fail("Authorizers after a failure should not be consulted")
+			return nil, nil
+		}),
+	}.AuthorizeConnect(request)
+
+	assert.Nil(tags)
+	assert.Equal(expectedError, err)
+}
+
+func TestMaxConnectionsPerID(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		authorizer = MaxConnectionsPerID(2)
+	)
+
+	for _, connected := range []int{0, 1} {
+		tags, err := authorizer.AuthorizeConnect(&ConnectRequest{ID: ID("mac:112233445566"), Connected: connected})
+		assert.Nil(tags)
+		assert.NoError(err)
+	}
+
+	_, err := authorizer.AuthorizeConnect(&ConnectRequest{ID: ID("mac:112233445566"), Connected: 2})
+	reason, ok := RejectionReason(err)
+	assert.True(ok)
+	assert.Equal(RejectDuplicate, reason)
+}
+
+func TestManagerConnectAuthorizer(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{}, 1)
+		requests     = make(chan *ConnectRequest, 10)
+
+		options = &Options{
+			Logger: logging.TestLogger(t),
+			ConnectAuthorizer: ConnectAuthorizers{
+				ConnectAuthorizerFunc(func(request *ConnectRequest) (Tags, error) {
+					requests <- request
+					switch request.Request.Header.Get("X-Test") {
+					case "forbidden":
+						return nil, errors.New("expected")
+					case "throttled":
+						return nil, &Rejection{Reason: RejectUnauthorized, Status: http.StatusTooManyRequests, Err: errors.New("expected")}
+					}
+
+					return Tags{"partner": request.Convey.Model()}, nil
+				}),
+				MaxConnectionsPerID(1),
+			},
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- struct{}{}
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(options, nil)
+		id                    = ID("mac:112233445566")
+	)
+
+	defer server.Close()
+
+	for header, expectedStatus := range map[string]int{"forbidden": http.StatusForbidden, "throttled": http.StatusTooManyRequests} {
+		c, response, err := dialer.Dial(connectURL, id, nil, http.Header{"X-Test": {header}})
+		assert.Nil(c)
+		assert.Error(err)
+		if assert.NotNil(response, header) {
+			assert.Equal(expectedStatus, response.StatusCode, header)
+		}
+
+		request := <-requests
+		assert.Equal(id, request.ID)
+		assert.Zero(request.Connected)
+	}
+
+	c, _, err := dialer.Dial(connectURL, id, Convey{ConveyModelKey: "TG1682G"}, nil)
+	require.NoError(err)
+	d := <-connected
+	<-requests
+	assert.Equal(Tags{"partner": "TG1682G"}, d.Tags())
+
+	// the device is already connected, so a duplicate is refused
+	duplicate, response, err := dialer.Dial(connectURL, id, nil, nil)
+	assert.Nil(duplicate)
+	assert.Error(err)
+	if assert.NotNil(response) {
+		assert.Equal(http.StatusConflict, response.StatusCode)
+	}
+
+	assert.Equal(1, (<-requests).Connected)
+
+	c.Close()
+	<-disconnected
+}
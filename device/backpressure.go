@@ -0,0 +1,95 @@
+package device
+
+// QueuePolicy determines what happens to a message sent to a device whose queue, for the message's
+// priority class, is full
+type QueuePolicy string
+
+const (
+	// BlockFullQueue waits for room in the queue until the request's context is cancelled or the device
+	// is closed.  This is the default.
+	BlockFullQueue QueuePolicy = "block"
+
+	// FailFullQueue rejects the message immediately with ErrorQueueFull
+	FailFullQueue QueuePolicy = "fail"
+
+	// DropOldestFullQueue makes room for the message by discarding the oldest message queued in the same
+	// priority class.  The sender of the discarded message receives ErrorQueueFull.
+	DropOldestFullQueue QueuePolicy = "dropOldest"
+)
+
+// valid tests if this policy is one of the recognized QueuePolicy values
+func (qp QueuePolicy) valid() bool {
+	switch qp {
+	case BlockFullQueue, FailFullQueue, DropOldestFullQueue:
+		return true
+	default:
+		return false
+	}
+}
+
+// queuePolicy returns the policy that applies to a request sent to this device.  A request's own
+// QueuePolicy, if recognized, overrides the policy of the device's manager.
+func (d *device) queuePolicy(request *Request) QueuePolicy {
+	if request.QueuePolicy.valid() {
+		return request.QueuePolicy
+	}
+
+	if d.fullQueuePolicy.valid() {
+		return d.fullQueuePolicy
+	}
+
+	return BlockFullQueue
+}
+
+// queueFull records a message that was rejected or discarded because of a full queue
+func (d *device) queueFull(policy QueuePolicy) {
+	if d.metrics != nil {
+		d.metrics.queueFull(policy)
+	}
+}
+
+// enqueue places an envelope on the queue for its request's priority, applying the request's QueuePolicy
+// if that queue is full.  This method returns nil once the envelope is queued.
+func (d *device) enqueue(e *envelope) error {
+	var (
+		queue  = d.messages[e.request.EffectivePriority()]
+		policy = d.queuePolicy(e.request)
+	)
+
+	for policy != BlockFullQueue {
+		select {
+		case <-e.request.Context().Done():
+			return e.request.Context().Err()
+		case <-d.shutdown:
+			return ErrorDeviceClosed
+		case queue <- e:
+			d.queueChanged(1)
+			return nil
+		default:
+		}
+
+		if policy == FailFullQueue {
+			d.queueFull(FailFullQueue)
+			return ErrorQueueFull
+		}
+
+		// the write pump may have emptied the queue in the meantime, in which case nothing is dropped
+		select {
+		case oldest := <-queue:
+			d.queueChanged(-1)
+			d.queueFull(DropOldestFullQueue)
+			rejectEnvelope(oldest, ErrorQueueFull)
+		default:
+		}
+	}
+
+	select {
+	case <-e.request.Context().Done():
+		return e.request.Context().Err()
+	case <-d.shutdown:
+		return ErrorDeviceClosed
+	case queue <- e:
+		d.queueChanged(1)
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package device
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOptionsQueuePolicy(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options), {QueuePolicy: "unrecognized"}} {
+		assert.Equal(BlockFullQueue, o.queuePolicy())
+	}
+
+	for _, policy := range []QueuePolicy{BlockFullQueue, FailFullQueue, DropOldestFullQueue} {
+		assert.Equal(policy, (&Options{QueuePolicy: policy}).queuePolicy())
+	}
+}
+
+func TestDeviceQueuePolicy(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(ID("mac:112233445566"), Key("test"), nil, 1)
+	)
+
+	assert.Equal(BlockFullQueue, d.queuePolicy(new(Request)))
+	assert.Equal(FailFullQueue, d.queuePolicy(&Request{QueuePolicy: FailFullQueue}))
+
+	d.fullQueuePolicy = DropOldestFullQueue
+	assert.Equal(DropOldestFullQueue, d.queuePolicy(new(Request)))
+	assert.Equal(DropOldestFullQueue, d.queuePolicy(&Request{QueuePolicy: "unrecognized"}))
+	assert.Equal(BlockFullQueue, d.queuePolicy(&Request{QueuePolicy: BlockFullQueue}))
+}
+
+// newFullTestDevice creates a device whose queues hold one message, with the low priority queue already full.
+// The returned channel receives the result of the queued message.
+func newFullTestDevice(metrics *managerMetrics) (*device, *envelope, <-chan error) {
+	var (
+		d        = newDevice(ID("mac:112233445566"), Key("test"), nil, 1)
+		complete = make(chan error, 1)
+		oldest   = &envelope{request: newTestQueueRequest(BlockFullQueue), complete: complete}
+	)
+
+	oldest.request.Message.(*wrp.Message).Destination = "oldest"
+	d.metrics = metrics
+	d.messages[LowPriority] <- oldest
+	return d, oldest, complete
+}
+
+func newTestQueueRequest(policy QueuePolicy) *Request {
+	return &Request{
+		Message:     &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "newest"},
+		Priority:    LowPriority,
+		QueuePolicy: policy,
+		ctx:         context.Background(),
+	}
+}
+
+func testDeviceQueueFullBlock(t *testing.T, metrics *managerMetrics) {
+	var (
+		assert       = assert.New(t)
+		d, _, _      = newFullTestDevice(metrics)
+		ctx, cancel  = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		highPriority = newTestQueueRequest(FailFullQueue)
+		result       = make(chan error, 1)
+	)
+
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, d.sendRequest(newTestQueueRequest(BlockFullQueue).WithContext(ctx)))
+	assert.Len(d.messages[LowPriority], 1)
+
+	// a full queue does not affect the other priority classes
+	highPriority.Priority = HighPriority
+	go func() { result <- d.sendRequest(highPriority) }()
+	select {
+	case e := <-d.messages[HighPriority]:
+		assert.Equal("newest", e.request.Message.(*wrp.Message).Destination)
+		e.complete <- nil
+	case <-time.After(5 * time.Second):
+		assert.Fail("The high priority message was not queued")
+	}
+
+	assert.NoError(<-result)
+}
+
+func testDeviceQueueFullFail(t *testing.T, metrics *managerMetrics) {
+	var (
+		assert       = assert.New(t)
+		d, oldest, _ = newFullTestDevice(metrics)
+	)
+
+	assert.Equal(ErrorQueueFull, d.sendRequest(newTestQueueRequest(FailFullQueue)))
+	if assert.Len(d.messages[LowPriority], 1) {
+		assert.True(oldest == <-d.messages[LowPriority])
+	}
+
+	// a closed device is reported as closed rather than full
+	d.RequestClose()
+	assert.Equal(ErrorDeviceClosed, d.sendRequest(newTestQueueRequest(FailFullQueue)))
+}
+
+func testDeviceQueueFullDropOldest(t *testing.T, metrics *managerMetrics) {
+	var (
+		assert         = assert.New(t)
+		d, _, complete = newFullTestDevice(metrics)
+		result         = make(chan error, 1)
+	)
+
+	d.fullQueuePolicy = DropOldestFullQueue
+	go func() { result <- d.sendRequest(newTestQueueRequest("")) }()
+
+	select {
+	case err := <-complete:
+		assert.Equal(ErrorQueueFull, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("The oldest message was not dropped")
+	}
+
+	select {
+	case e := <-d.messages[LowPriority]:
+		assert.Equal("newest", e.request.Message.(*wrp.Message).Destination)
+		e.complete <- nil
+	case <-time.After(5 * time.Second):
+		assert.Fail("The newest message was not queued")
+	}
+
+	assert.NoError(<-result)
+}
+
+func TestDeviceQueueFull(t *testing.T) {
+	require := require.New(t)
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	metrics := newManagerMetrics(registry)
+	t.Run("Block", func(t *testing.T) { testDeviceQueueFullBlock(t, &metrics) })
+	t.Run("Fail", func(t *testing.T) { testDeviceQueueFullFail(t, &metrics) })
+	t.Run("DropOldest", func(t *testing.T) { testDeviceQueueFullDropOldest(t, &metrics) })
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, response.Body.String(), QueueFullCount+`{action="fail"} 1`)
+	assert.Contains(t, response.Body.String(), QueueFullCount+`{action="dropOldest"} 1`)
+	assert.NotContains(t, response.Body.String(), QueueFullCount+`{action="block"}`)
+}
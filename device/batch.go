@@ -0,0 +1,184 @@
+package device
+
+import (
+	"bytes"
+	"github.com/Comcast/webpa-common/wrp"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultBatchHeader is the HTTP header that a device sets to true in its websocket upgrade request
+	// to indicate that it accepts batch envelopes
+	DefaultBatchHeader = "X-Webpa-Batch"
+
+	// BatchContentType is the content type of a batch envelope.  A batch envelope is a WRP message of
+	// type SimpleEventMessageType, addressed to the device, whose payload is the concatenation of the
+	// batched messages, each encoded in the connection's format.  DecodeBatch unpacks a batch envelope.
+	BatchContentType = "application/vnd.webpa.batch"
+
+	DefaultMaxBatchBytes = 64 * 1024
+)
+
+// batchPolicy describes how a write pump coalesces queued messages into batch envelopes
+type batchPolicy struct {
+	maxSize  int
+	maxBytes int
+	linger   time.Duration
+}
+
+// enabled tests if this policy ever produces batches of more than one message
+func (p batchPolicy) enabled() bool {
+	return p.maxSize > 1
+}
+
+// acceptsBatches tests if a websocket upgrade request indicates that the device accepts batch envelopes
+func acceptsBatches(header http.Header, name string) bool {
+	accepts, _ := strconv.ParseBool(header.Get(name))
+	return accepts
+}
+
+// DecodeBatch decodes the messages carried in a batch envelope, which must have BatchContentType.  This
+// function is intended for clients, such as devices built on a Dialer, that accept batch envelopes.
+func DecodeBatch(envelope *wrp.Message, format wrp.Format) ([]*wrp.Message, error) {
+	if envelope.ContentType != BatchContentType {
+		return nil, ErrorNotBatch
+	}
+
+	var (
+		messages []*wrp.Message
+		reader   = bytes.NewReader(envelope.Payload)
+		decoder  = wrp.NewDecoder(reader, format)
+	)
+
+	for reader.Len() > 0 {
+		message := new(wrp.Message)
+		if err := decoder.Decode(message); err != nil {
+			return messages, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// batchedWrite is an envelope that has been encoded into a batch
+type batchedWrite struct {
+	envelope     *envelope
+	span         trace.Span
+	acknowledged bool
+}
+
+// nextBatched takes the next envelope to add to a batch of the given count and payload size, waiting on
+// the linger timer for more messages to be queued.  This method returns nil once the batch is full, once
+// the linger elapses, or when the device is shutting down.
+func (m *manager) nextBatched(d *device, count, size int, linger **time.Timer) *envelope {
+	if count >= m.batchPolicy.maxSize || size >= m.batchPolicy.maxBytes {
+		return nil
+	}
+
+	next := d.messages.poll()
+	if next == nil {
+		if m.batchPolicy.linger <= 0 {
+			return nil
+		}
+
+		if *linger == nil {
+			*linger = time.NewTimer(m.batchPolicy.linger)
+		}
+
+		select {
+		case <-(*linger).C:
+			return nil
+		case <-d.shutdown:
+			return nil
+		case next = <-d.messages[CriticalPriority]:
+		case next = <-d.messages[HighPriority]:
+		case next = <-d.messages[MediumPriority]:
+		case next = <-d.messages[LowPriority]:
+		}
+	}
+
+	d.queueChanged(-1)
+	return next
+}
+
+// writeBatch writes the first envelope, coalesced with as many other queued envelopes as the batch policy
+// allows, in a single frame.  If only the first envelope is available, it is written by itself rather than in
+// a batch envelope.  A message that cannot be encoded fails by itself, without spoiling the batch.
+//
+// If the frame cannot be written, each batched envelope is failed with the error and the envelopes are returned,
+// so that the write pump dispatches their MessageFailed events.
+func (m *manager) writeBatch(d *device, c Connection, first *envelope, encoder wrp.Encoder) ([]*envelope, error) {
+	var (
+		payload = getBuffer()
+		writes  []batchedWrite
+		linger  *time.Timer
+		start   = time.Now()
+	)
+
+	defer func() {
+		putBuffer(payload)
+		if linger != nil {
+			linger.Stop()
+		}
+	}()
+
+	for next := first; next != nil; next = m.nextBatched(d, len(writes), payload.Len(), &linger) {
+		ctx, span, acknowledged := m.startWrite(d, next, time.Now())
+		mark := payload.Len()
+		if err := m.encodeEnvelope(ctx, d, next, encoder, payload); err != nil {
+			payload.Truncate(mark)
+			m.finishWrite(d, next, span, acknowledged, start, err)
+			m.dispatch(&Event{Type: MessageFailed, Device: d, Message: next.request.Message, Format: next.request.Format, Error: err})
+			continue
+		}
+
+		writes = append(writes, batchedWrite{envelope: next, span: span, acknowledged: acknowledged})
+	}
+
+	if len(writes) == 0 {
+		return nil, nil
+	}
+
+	frame, err := c.NextWriter()
+	if err == nil {
+		written := &byteCounter{WriteCloser: frame}
+		if len(writes) == 1 {
+			_, err = written.Write(payload.Bytes())
+		} else {
+			encoder.Reset(written)
+			err = encoder.Encode(&wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: string(d.id),
+				ContentType: BatchContentType,
+				Payload:     payload.Bytes(),
+			})
+		}
+
+		if err == nil {
+			err = written.Close()
+		} else {
+			// don't hide the original error, but ensure the frame is closed
+			written.Close()
+		}
+
+		if err == nil {
+			d.statistics.wrote(written.count, time.Now())
+			m.metrics.batched(len(writes))
+		}
+	}
+
+	var unsent []*envelope
+	for _, w := range writes {
+		m.finishWrite(d, w.envelope, w.span, w.acknowledged, start, err)
+		if err != nil {
+			unsent = append(unsent, w.envelope)
+		}
+	}
+
+	return unsent, err
+}
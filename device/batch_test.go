@@ -0,0 +1,262 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBatchPolicy(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Equal(batchPolicy{maxBytes: DefaultMaxBatchBytes}, o.batchPolicy())
+		assert.False(o.batchPolicy().enabled())
+		assert.Equal(DefaultBatchHeader, o.batchHeader())
+	}
+
+	o := &Options{MaxBatchSize: 10, MaxBatchBytes: 1024, BatchLinger: time.Millisecond, BatchHeader: "X-Batch"}
+	assert.Equal(batchPolicy{maxSize: 10, maxBytes: 1024, linger: time.Millisecond}, o.batchPolicy())
+	assert.True(o.batchPolicy().enabled())
+	assert.Equal("X-Batch", o.batchHeader())
+
+	assert.False((&Options{MaxBatchSize: 1}).batchPolicy().enabled())
+}
+
+func TestAcceptsBatches(t *testing.T) {
+	assert := assert.New(t)
+	for value, expected := range map[string]bool{"": false, "true": true, "1": true, "false": false, "yes": false} {
+		header := http.Header{}
+		if len(value) > 0 {
+			header.Set(DefaultBatchHeader, value)
+		}
+
+		assert.Equal(expected, acceptsBatches(header, DefaultBatchHeader), "value: %s", value)
+	}
+}
+
+func testDecodeBatch(t *testing.T, format wrp.Format) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		payload  bytes.Buffer
+		expected = []*wrp.Message{
+			{Type: wrp.SimpleEventMessageType, Source: "test", Destination: "mac:112233445566", Payload: []byte("first")},
+			{Type: wrp.SimpleRequestResponseMessageType, Source: "test", Destination: "mac:112233445566", TransactionUUID: "2"},
+			{Type: wrp.SimpleEventMessageType, Source: "test", Destination: "mac:112233445566", Metadata: map[string]string{"foo": "bar"}},
+		}
+	)
+
+	encoder := wrp.NewEncoder(&payload, format)
+	for _, message := range expected {
+		require.NoError(encoder.Encode(message))
+	}
+
+	actual, err := DecodeBatch(&wrp.Message{ContentType: BatchContentType, Payload: payload.Bytes()}, format)
+	require.NoError(err)
+	assert.Equal(expected, actual)
+
+	actual, err = DecodeBatch(&wrp.Message{ContentType: BatchContentType}, format)
+	assert.Empty(actual)
+	assert.NoError(err)
+
+	actual, err = DecodeBatch(&wrp.Message{Payload: payload.Bytes()}, format)
+	assert.Empty(actual)
+	assert.Equal(ErrorNotBatch, err)
+
+	actual, err = DecodeBatch(&wrp.Message{ContentType: BatchContentType, Payload: payload.Bytes()[:payload.Len()-3]}, format)
+	assert.Len(actual, 2)
+	assert.Error(err)
+}
+
+func TestDecodeBatch(t *testing.T) {
+	t.Run("Msgpack", func(t *testing.T) { testDecodeBatch(t, wrp.Msgpack) })
+	t.Run("JSON", func(t *testing.T) { testDecodeBatch(t, wrp.JSON) })
+}
+
+func newTestBatchEnvelope(destination string) *envelope {
+	return &envelope{
+		request:  &Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: destination}},
+		complete: make(chan error, 1),
+	}
+}
+
+func testManagerNextBatchedFull(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		m      = &manager{batchPolicy: batchPolicy{maxSize: 2, maxBytes: 100, linger: time.Hour}}
+		d      = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+		linger *time.Timer
+	)
+
+	d.messages[LowPriority] <- newTestBatchEnvelope("1")
+	assert.Nil(m.nextBatched(d, 2, 0, &linger))
+	assert.Nil(m.nextBatched(d, 1, 100, &linger))
+	assert.Nil(linger)
+
+	next := m.nextBatched(d, 1, 99, &linger)
+	if assert.NotNil(next) {
+		assert.Equal("1", next.request.Message.(*wrp.Message).Destination)
+	}
+
+	assert.Nil(linger)
+}
+
+func testManagerNextBatchedLinger(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		m      = &manager{batchPolicy: batchPolicy{maxSize: 10, maxBytes: 100}}
+		d      = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+		linger *time.Timer
+	)
+
+	// without a linger, only already queued messages are batched
+	assert.Nil(m.nextBatched(d, 1, 0, &linger))
+	assert.Nil(linger)
+
+	m.batchPolicy.linger = 10 * time.Millisecond
+	assert.Nil(m.nextBatched(d, 1, 0, &linger))
+	assert.NotNil(linger)
+
+	// a message queued while lingering is added to the batch
+	linger = nil
+	m.batchPolicy.linger = time.Hour
+	go func() { d.messages[HighPriority] <- newTestBatchEnvelope("2") }()
+	next := m.nextBatched(d, 1, 0, &linger)
+	if assert.NotNil(next) {
+		assert.Equal("2", next.request.Message.(*wrp.Message).Destination)
+	}
+
+	// a device shutting down is not kept waiting
+	d.RequestClose()
+	assert.Nil(m.nextBatched(d, 2, 0, &linger))
+	linger.Stop()
+}
+
+func TestManagerNextBatched(t *testing.T) {
+	t.Run("Full", testManagerNextBatchedFull)
+	t.Run("Linger", testManagerNextBatchedLinger)
+}
+
+// readTestFrame reads the next message written to a test device
+func readTestFrame(t *testing.T, c Connection) *wrp.Message {
+	var frame bytes.Buffer
+	_, err := c.Read(&frame)
+	require.NoError(t, err)
+
+	message := new(wrp.Message)
+	require.NoError(t, wrp.NewDecoderBytes(frame.Bytes(), c.Format()).Decode(message))
+	return message
+}
+
+func TestManagerBatching(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		connected    = make(chan Interface, 2)
+		disconnected = make(chan Interface, 2)
+		options      = &Options{
+			Logger:       logging.TestLogger(t),
+			MaxBatchSize: 3,
+			BatchLinger:  5 * time.Second,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(options, nil)
+	)
+
+	defer server.Close()
+
+	send := func(d Interface, destination string) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, err := d.Send(&Request{
+				Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: destination},
+				ctx:     context.Background(),
+			})
+
+			result <- err
+		}()
+
+		return result
+	}
+
+	// closing waits for the device to disconnect, so that nothing is logged after the test
+	closing := func(c Connection) {
+		c.Close()
+		select {
+		case <-disconnected:
+		case <-time.After(5 * time.Second):
+			require.Fail("The device did not disconnect")
+		}
+	}
+
+	awaitSent := func(results ...<-chan error) {
+		for _, result := range results {
+			select {
+			case err := <-result:
+				assert.NoError(err)
+			case <-time.After(5 * time.Second):
+				require.Fail("Send did not complete")
+			}
+		}
+	}
+
+	t.Run("Batched", func(t *testing.T) {
+		c, _, err := dialer.Dial(connectURL, ID("mac:112233445566"), nil, http.Header{DefaultBatchHeader: []string{"true"}})
+		require.NoError(err)
+		defer closing(c)
+
+		d := <-connected
+		results := []<-chan error{send(d, "mac:112233445566/1"), send(d, "mac:112233445566/2"), send(d, "mac:112233445566/3")}
+
+		// the batch is written as soon as it is full, without waiting out the linger
+		envelope := readTestFrame(t, c)
+		awaitSent(results...)
+		assert.Equal(BatchContentType, envelope.ContentType)
+		assert.Equal("mac:112233445566", envelope.Destination)
+
+		messages, err := DecodeBatch(envelope, c.Format())
+		require.NoError(err)
+		require.Len(messages, 3)
+
+		destinations := make(map[string]bool)
+		for _, message := range messages {
+			destinations[message.Destination] = true
+		}
+
+		assert.Equal(map[string]bool{"mac:112233445566/1": true, "mac:112233445566/2": true, "mac:112233445566/3": true}, destinations)
+		assert.Equal(int64(1), d.Statistics().MessagesSent)
+	})
+
+	t.Run("Unbatched", func(t *testing.T) {
+		c, _, err := dialer.Dial(connectURL, ID("mac:665544332211"), nil, nil)
+		require.NoError(err)
+		defer closing(c)
+
+		d := <-connected
+		results := []<-chan error{send(d, "mac:665544332211/1"), send(d, "mac:665544332211/2")}
+		for i := 0; i < 2; i++ {
+			message := readTestFrame(t, c)
+			assert.Empty(message.ContentType)
+			assert.Contains(message.Destination, "mac:665544332211/")
+		}
+
+		awaitSent(results...)
+	})
+}
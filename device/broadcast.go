@@ -0,0 +1,108 @@
+package device
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultBroadcastConcurrency is the default maximum number of devices a single broadcast sends to at once
+	DefaultBroadcastConcurrency = 100
+)
+
+// BroadcastResult summarizes the outcome of a broadcast
+type BroadcastResult struct {
+	// Attempted is the number of devices the request was sent to
+	Attempted int
+
+	// Succeeded is the number of devices for which Send returned no error
+	Succeeded int
+
+	// Failed is the number of devices for which Send returned an error
+	Failed int
+}
+
+// Broadcaster sends a single request to many devices
+type Broadcaster interface {
+	// Broadcast sends a request to each connected device accepted by the filter, or to every
+	// connected device if the filter is nil.  The request's message is sent as is to each device,
+	// whatever its destination, and any responses are discarded.
+	//
+	// Sends happen concurrently, with bounded parallelism, and this method blocks until every
+	// send has completed.  If the request's context is cancelled, devices that have not yet been
+	// sent to are skipped and the context's error is returned along with the partial result.
+	//
+	// The filter is invoked under the manager's read lock, so it must not call any methods on the Manager.
+	Broadcast(request *Request, filter func(Interface) bool) (BroadcastResult, error)
+
+	// BroadcastTag sends a request to each connected device that has the given tag, in the same manner
+	// as Broadcast.  The devices are found via an index, so this is cheaper than filtering every device.
+	BroadcastTag(request *Request, tag Tag) (BroadcastResult, error)
+}
+
+func (m *manager) Broadcast(request *Request, filter func(Interface) bool) (BroadcastResult, error) {
+	if request == nil || request.Message == nil {
+		return BroadcastResult{}, ErrorMissingMessage
+	}
+
+	var targets []*device
+	m.registry.visitAll(func(d *device) {
+		if filter == nil || filter(d) {
+			targets = append(targets, d)
+		}
+	})
+
+	return m.broadcast(request, targets)
+}
+
+// broadcast sends a request to each of the given devices with bounded parallelism, as described by Broadcast
+func (m *manager) broadcast(request *Request, targets []*device) (BroadcastResult, error) {
+	var (
+		done      = request.Context().Done()
+		slots     = make(chan struct{}, m.broadcastConcurrency)
+		waitGroup = new(sync.WaitGroup)
+
+		attempted, succeeded, failed int64
+		err                          error
+	)
+
+	for _, d := range targets {
+		// check for cancellation first, as a select does not prefer either of its cases
+		if err = request.Context().Err(); err == nil {
+			select {
+			case <-done:
+				err = request.Context().Err()
+			case slots <- struct{}{}:
+			}
+		}
+
+		if err != nil {
+			break
+		}
+
+		attempted++
+		waitGroup.Add(1)
+
+		// each device gets its own copy of the request, as Send is free to stamp it with a transaction context
+		go func(d *device, request *Request) {
+			defer func() {
+				<-slots
+				waitGroup.Done()
+			}()
+
+			if _, sendError := d.Send(request); sendError != nil {
+				atomic.AddInt64(&failed, 1)
+			} else {
+				atomic.AddInt64(&succeeded, 1)
+			}
+		}(d, request.withContext(request.Context()))
+	}
+
+	waitGroup.Wait()
+	m.logger.Debug("Broadcast to %d devices: %d attempted, %d succeeded, %d failed", len(targets), attempted, succeeded, failed)
+	return BroadcastResult{
+		Attempted: int(attempted),
+		Succeeded: int(succeeded),
+		Failed:    int(failed),
+	}, err
+}
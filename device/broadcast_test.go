@@ -0,0 +1,188 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsBroadcastConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options), {BroadcastConcurrency: -1}} {
+		assert.Equal(DefaultBroadcastConcurrency, o.broadcastConcurrency())
+	}
+
+	assert.Equal(17, (&Options{BroadcastConcurrency: 17}).broadcastConcurrency())
+}
+
+func newBroadcastRequest() *Request {
+	return &Request{
+		Message: &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Destination: "event:broadcast",
+		},
+	}
+}
+
+func testManagerBroadcastMissingMessage(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		manager = NewManager(nil, new(mockConnectionFactory))
+	)
+
+	for _, request := range []*Request{nil, new(Request)} {
+		result, err := manager.Broadcast(request, nil)
+		assert.Equal(BroadcastResult{}, result)
+		assert.Equal(ErrorMissingMessage, err)
+	}
+}
+
+func testManagerBroadcastFilter(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		sendError = errors.New("expected send error")
+		stop      = make(chan struct{})
+		manager   = NewManager(&Options{Logger: logging.TestLogger(t)}, new(mockConnectionFactory)).(*manager)
+		sent      = make(map[ID]<-chan *Request)
+	)
+
+	defer close(stop)
+
+	// devices with even IDs succeed, while those with odd IDs fail
+	for i := 0; i < 10; i++ {
+		var err error
+		if i%2 == 1 {
+			err = sendError
+		}
+
+		d := manager.newManagedDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, "")
+		require.NoError(manager.registry.add(d))
+		sent[d.ID()] = completeSends(d, err, stop)
+	}
+
+	request := newBroadcastRequest()
+	result, err := manager.Broadcast(request, nil)
+	assert.NoError(err)
+	assert.Equal(BroadcastResult{Attempted: 10, Succeeded: 5, Failed: 5}, result)
+	for id, requests := range sent {
+		select {
+		case actual := <-requests:
+			assert.True(request.Message == actual.Message)
+		case <-time.After(5 * time.Second):
+			assert.Fail("The request was not sent", "device %s", id)
+		}
+	}
+
+	lowIDs := func(d Interface) bool {
+		return strings.Compare(string(d.ID()), string(IntToMAC(4))) < 0
+	}
+
+	result, err = manager.Broadcast(request, lowIDs)
+	assert.NoError(err)
+	assert.Equal(BroadcastResult{Attempted: 4, Succeeded: 2, Failed: 2}, result)
+	for id, requests := range sent {
+		if lowIDs(&device{id: id}) {
+			select {
+			case <-requests:
+			case <-time.After(5 * time.Second):
+				assert.Fail("The request was not sent", "device %s", id)
+			}
+		} else {
+			select {
+			case <-requests:
+				assert.Fail("The request was sent to a filtered device", "device %s", id)
+			default:
+			}
+		}
+	}
+}
+
+func testManagerBroadcastConcurrency(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		manager = NewManager(&Options{Logger: logging.TestLogger(t), BroadcastConcurrency: 2}, new(mockConnectionFactory)).(*manager)
+		queued  = make(chan *envelope, 5)
+		stop    = make(chan struct{})
+	)
+
+	defer close(stop)
+
+	// each device's simulated write pump hands its envelopes over without completing them
+	for i := 0; i < 5; i++ {
+		d := manager.newManagedDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, "")
+		require.NoError(manager.registry.add(d))
+		go func() {
+			select {
+			case <-stop:
+			case e := <-d.messages[LowPriority]:
+				queued <- e
+			}
+		}()
+	}
+
+	results := make(chan BroadcastResult, 1)
+	go func() {
+		result, err := manager.Broadcast(newBroadcastRequest(), nil)
+		assert.NoError(err)
+		results <- result
+	}()
+
+	for completed := 0; completed < 5; completed++ {
+		var e *envelope
+		select {
+		case e = <-queued:
+		case <-time.After(5 * time.Second):
+			require.Fail("No envelope was queued")
+		}
+
+		if completed < 4 {
+			// with two sends in flight, at most one more device can have been sent to
+			time.Sleep(10 * time.Millisecond)
+			assert.True(len(queued) <= 1)
+		}
+
+		close(e.complete)
+	}
+
+	select {
+	case result := <-results:
+		assert.Equal(BroadcastResult{Attempted: 5, Succeeded: 5}, result)
+	case <-time.After(5 * time.Second):
+		require.Fail("The broadcast did not complete")
+	}
+}
+
+func testManagerBroadcastCancelled(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		manager     = NewManager(&Options{Logger: logging.TestLogger(t)}, new(mockConnectionFactory)).(*manager)
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(manager.registry.add(newDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, 1)))
+	}
+
+	cancel()
+	result, err := manager.Broadcast(newBroadcastRequest().WithContext(ctx), nil)
+	assert.Equal(BroadcastResult{}, result)
+	assert.Equal(context.Canceled, err)
+}
+
+func TestManagerBroadcast(t *testing.T) {
+	t.Run("MissingMessage", testManagerBroadcastMissingMessage)
+	t.Run("Filter", testManagerBroadcastFilter)
+	t.Run("Concurrency", testManagerBroadcastConcurrency)
+	t.Run("Cancelled", testManagerBroadcastCancelled)
+}
@@ -0,0 +1,82 @@
+package device
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize is the largest capacity of a buffer returned to the pool.  Larger buffers, grown
+// by unusually large frames, are left to the garbage collector so that they do not pin memory.
+const maxPooledBufferSize = 64 * 1024
+
+// bufferPool holds the buffers that frames are read into and, for connections with a compression threshold,
+// written from.  The pool is shared by all devices, so the memory for frames is proportional to the count of
+// frames in flight rather than the count of connected devices.
+//
+// Pooling only removes the per-frame allocation of the buffer itself.  Decoding a frame still copies the
+// WRP payload into the Message, and uncompressed frames are encoded directly into the websocket writer
+// without passing through this pool.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBufferSize {
+		b.Reset()
+		bufferPool.Put(b)
+	}
+}
+
+// frameBuffer is the io.ReaderFrom that a read pump reads each frame into.  A buffer is only taken from
+// the pool once a frame actually arrives, so idle devices blocked in Connection.Read hold no buffer.
+//
+// The frame's bytes are valid until release is called.  Bytes that must outlive the frame, such as the
+// Contents of a transaction Response, are kept by calling detach instead, which leaves the buffer to the
+// garbage collector rather than returning it to the pool.  Listeners that keep an Event's Contents must
+// copy them, as the webhook Dispatcher does.
+type frameBuffer struct {
+	buffer *bytes.Buffer
+}
+
+func (fb *frameBuffer) ReadFrom(r io.Reader) (int64, error) {
+	if fb.buffer == nil {
+		fb.buffer = getBuffer()
+	}
+
+	return fb.buffer.ReadFrom(r)
+}
+
+// Bytes returns the frame read by the last ReadFrom
+func (fb *frameBuffer) Bytes() []byte {
+	if fb.buffer == nil {
+		return nil
+	}
+
+	return fb.buffer.Bytes()
+}
+
+func (fb *frameBuffer) Len() int {
+	if fb.buffer == nil {
+		return 0
+	}
+
+	return fb.buffer.Len()
+}
+
+// detach gives up ownership of the current frame, which will not be reused
+func (fb *frameBuffer) detach() {
+	fb.buffer = nil
+}
+
+// release returns the current frame, if any, to the pool.  Any slices of the frame become invalid.
+func (fb *frameBuffer) release() {
+	if fb.buffer != nil {
+		putBuffer(fb.buffer)
+		fb.buffer = nil
+	}
+}
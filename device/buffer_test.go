@@ -0,0 +1,114 @@
+package device
+
+import (
+	"bytes"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFrameBuffer(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		frameBuffer frameBuffer
+	)
+
+	assert.Nil(frameBuffer.Bytes())
+	assert.Zero(frameBuffer.Len())
+	frameBuffer.release()
+
+	count, err := frameBuffer.ReadFrom(bytes.NewReader([]byte("first frame")))
+	require.NoError(err)
+	assert.Equal(int64(11), count)
+	assert.Equal([]byte("first frame"), frameBuffer.Bytes())
+	assert.Equal(11, frameBuffer.Len())
+
+	frameBuffer.release()
+	assert.Nil(frameBuffer.Bytes())
+
+	// a detached frame is never reused
+	_, err = frameBuffer.ReadFrom(bytes.NewReader([]byte("retained")))
+	require.NoError(err)
+	retained := frameBuffer.Bytes()
+	frameBuffer.detach()
+	frameBuffer.release()
+	assert.Zero(frameBuffer.Len())
+
+	for i := 0; i < 10; i++ {
+		_, err = frameBuffer.ReadFrom(bytes.NewReader([]byte("overwrite")))
+		require.NoError(err)
+		frameBuffer.release()
+	}
+
+	assert.Equal([]byte("retained"), retained)
+}
+
+func TestPutBuffer(t *testing.T) {
+	assert := assert.New(t)
+
+	large := bytes.NewBuffer(make([]byte, 0, maxPooledBufferSize+1))
+	large.WriteString("large")
+	putBuffer(large)
+
+	// buffers too large for the pool are left untouched for the garbage collector
+	assert.Equal("large", large.String())
+
+	small := getBuffer()
+	small.WriteString("small")
+	putBuffer(small)
+	assert.Zero(small.Len())
+}
+
+// benchmarkFrame is a typical event frame sent by a device
+func benchmarkFrame(b *testing.B) []byte {
+	var frame []byte
+	err := wrp.NewEncoderBytes(&frame, wrp.Msgpack).Encode(&wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/service",
+		Destination: "event:device-status/mac:112233445566/online",
+		ContentType: "application/json",
+		Payload:     bytes.Repeat([]byte("x"), 2048),
+	})
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return frame
+}
+
+// BenchmarkReadFrame compares reading and decoding frames into a new buffer each time, as read pumps
+// once did, against reading them into a pooled frameBuffer.  Run with -benchmem to compare allocations.
+func BenchmarkReadFrame(b *testing.B) {
+	var (
+		frame   = benchmarkFrame(b)
+		decoder = wrp.NewDecoder(nil, wrp.Msgpack)
+	)
+
+	b.Run("Unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var buffer bytes.Buffer
+			buffer.ReadFrom(bytes.NewReader(frame))
+
+			decoder.ResetBytes(buffer.Bytes())
+			decoder.Decode(new(wrp.Message))
+		}
+	})
+
+	b.Run("Pooled", func(b *testing.B) {
+		var frameBuffer frameBuffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			frameBuffer.release()
+			frameBuffer.ReadFrom(bytes.NewReader(frame))
+
+			decoder.ResetBytes(frameBuffer.Bytes())
+			decoder.Decode(new(wrp.Message))
+		}
+
+		frameBuffer.release()
+	})
+}
@@ -0,0 +1,170 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/health"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DeviceSoftLimitStat is the health stat set to 1 while a manager has more devices than its soft limit
+	DeviceSoftLimitStat health.Stat = "DeviceSoftLimitExceeded"
+
+	// DeviceHardLimitStat is the health stat set to 1 from the time a manager reaches its hard limit
+	// until its device count falls to the recovery level
+	DeviceHardLimitStat health.Stat = "DeviceHardLimitReached"
+
+	// DeviceCountStat is the health stat reported by NewDeviceCountSource
+	DeviceCountStat health.Stat = "DeviceCount"
+
+	SoftLimit = "soft"
+	HardLimit = "hard"
+)
+
+// DeviceLimitPolicy is the action a manager takes when a device connects while the manager is at its hard limit
+type DeviceLimitPolicy string
+
+const (
+	// RejectOverLimit rejects the new device with a 503 and RejectCapacity.  Once the hard limit is reached,
+	// devices are rejected until the device count falls to the recovery level.  This is the default.
+	RejectOverLimit DeviceLimitPolicy = "reject"
+
+	// EvictIdleOverLimit makes room for the new device by disconnecting the connected device that has gone the
+	// longest without sending a frame.  The new device is only rejected if there is no device to evict.
+	EvictIdleOverLimit DeviceLimitPolicy = "evictIdle"
+)
+
+// capacity tracks the devices connected to a manager against the manager's soft and hard limits.
+// A nonpositive limit is not enforced.  Instances are safe for concurrent use.
+//
+// Reaching the hard limit saturates the capacity, and it remains saturated until the count falls to the
+// recovery level.  This hysteresis keeps the limit from flapping when devices connect and disconnect at the limit.
+type capacity struct {
+	soft     int32
+	hard     int32
+	recovery int32
+	policy   DeviceLimitPolicy
+
+	lock      sync.Mutex
+	count     int32
+	overSoft  bool
+	saturated bool
+}
+
+// acquire reserves a slot for a new device.  The first return is false if the hard limit has been
+// reached, in which case no slot was reserved.  The second return is true if this device took the
+// count over the soft limit, and the third is true if this device saturated the capacity.
+//
+// Under RejectOverLimit, no slots are available while the capacity is saturated.  Under EvictIdleOverLimit,
+// slots are available whenever the count is below the hard limit.
+func (c *capacity) acquire() (bool, bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.hard > 0 && (c.count >= c.hard || (c.saturated && c.policy != EvictIdleOverLimit)) {
+		return false, false, false
+	}
+
+	overSoft, saturated := c.add()
+	return true, overSoft, saturated
+}
+
+// admit reserves a slot regardless of the hard limit, for a device that is admitted in place of an
+// evicted device.  The count exceeds the hard limit until the evicted device releases its slot.
+func (c *capacity) admit() (bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.add()
+}
+
+// add increments the count.  This method must be called under the lock.
+func (c *capacity) add() (overSoft, saturated bool) {
+	c.count++
+	if c.soft > 0 && c.count > c.soft && !c.overSoft {
+		c.overSoft, overSoft = true, true
+	}
+
+	if c.hard > 0 && c.count >= c.hard && !c.saturated {
+		c.saturated, saturated = true, true
+	}
+
+	return
+}
+
+// release frees a slot previously reserved with acquire or admit.  The first return is true if the
+// count dropped back to within the soft limit, and the second is true if the capacity recovered from saturation.
+func (c *capacity) release() (bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var withinSoft, recovered bool
+	c.count--
+	if c.overSoft && c.count <= c.soft {
+		c.overSoft, withinSoft = false, true
+	}
+
+	if c.saturated && c.count <= c.recovery {
+		c.saturated, recovered = false, true
+	}
+
+	return withinSoft, recovered
+}
+
+// len returns the number of reserved slots
+func (c *capacity) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return int(c.count)
+}
+
+// lastActivity is when this device last sent a frame, or when it connected if it has not sent any
+func (d *device) lastActivity() time.Time {
+	if lastRead := atomic.LoadInt64(&d.statistics.lastRead); lastRead != 0 {
+		return time.Unix(0, lastRead)
+	}
+
+	return d.connectedAt
+}
+
+// evictIdle closes the open device that has gone the longest without sending a frame, to make room for a new
+// device.  This method returns false if there was no device to evict.  Finding the device visits every
+// connected device, so eviction is only suited to managers that rarely run at their hard limit.
+func (m *manager) evictIdle() bool {
+	for {
+		var (
+			idlest    *device
+			idleSince time.Time
+		)
+
+		m.registry.visitAll(func(d *device) {
+			if d.Closed() {
+				return
+			}
+
+			if since := d.lastActivity(); idlest == nil || since.Before(idleSince) {
+				idlest, idleSince = d, since
+			}
+		})
+
+		if idlest == nil {
+			return false
+		}
+
+		// another connection may have evicted the same device in the meantime
+		if idlest.requestClose() {
+			idlest.logger.Warn("Evicting device [%s], idle since %s, to make room for a new device", idlest.id, idleSince.Format(time.RFC3339))
+			m.metrics.evicted()
+			m.dispatch(&Event{Type: Evicted, Device: idlest})
+			return true
+		}
+	}
+}
+
+// NewDeviceCountSource creates a health source which reports the count of devices connected to a
+// Manager, including duplicates, as DeviceCountStat
+func NewDeviceCountSource(registry Registry) health.Source {
+	return health.SourceFunc(func(stats health.Stats) {
+		stats[DeviceCountStat] = registry.VisitAll(func(Interface) {})
+	})
+}
@@ -0,0 +1,393 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/health"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapacityUnlimited(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		c      = new(capacity)
+	)
+
+	for i := 0; i < 100; i++ {
+		ok, overSoft, saturated := c.acquire()
+		assert.True(ok)
+		assert.False(overSoft)
+		assert.False(saturated)
+	}
+
+	assert.Equal(100, c.len())
+	withinSoft, recovered := c.release()
+	assert.False(withinSoft)
+	assert.False(recovered)
+	assert.Equal(99, c.len())
+}
+
+func TestCapacity(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		c      = &capacity{soft: 2, hard: 3, recovery: 2}
+	)
+
+	ok, overSoft, saturated := c.acquire()
+	assert.True(ok)
+	assert.False(overSoft)
+	assert.False(saturated)
+
+	ok, overSoft, saturated = c.acquire()
+	assert.True(ok)
+	assert.False(overSoft)
+	assert.False(saturated)
+
+	ok, overSoft, saturated = c.acquire()
+	assert.True(ok)
+	assert.True(overSoft)
+	assert.True(saturated)
+
+	ok, overSoft, saturated = c.acquire()
+	assert.False(ok)
+	assert.False(overSoft)
+	assert.False(saturated)
+	assert.Equal(3, c.len())
+
+	withinSoft, recovered := c.release()
+	assert.True(withinSoft)
+	assert.True(recovered)
+	assert.Equal(2, c.len())
+
+	withinSoft, recovered = c.release()
+	assert.False(withinSoft)
+	assert.False(recovered)
+
+	// crossing the soft limit again is reported again
+	ok, _, _ = c.acquire()
+	assert.True(ok)
+	ok, overSoft, _ = c.acquire()
+	assert.True(ok)
+	assert.True(overSoft)
+}
+
+func TestCapacityHysteresis(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		c      = &capacity{hard: 4, recovery: 2, policy: RejectOverLimit}
+	)
+
+	for i := 0; i < 3; i++ {
+		ok, _, saturated := c.acquire()
+		assert.True(ok)
+		assert.False(saturated)
+	}
+
+	ok, _, saturated := c.acquire()
+	assert.True(ok)
+	assert.True(saturated)
+
+	// below the hard limit, but not yet recovered
+	_, recovered := c.release()
+	assert.False(recovered)
+	ok, _, _ = c.acquire()
+	assert.False(ok)
+	assert.Equal(3, c.len())
+
+	_, recovered = c.release()
+	assert.True(recovered)
+	assert.Equal(2, c.len())
+
+	ok, _, saturated = c.acquire()
+	assert.True(ok)
+	assert.False(saturated)
+}
+
+func TestCapacityEviction(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		c      = &capacity{hard: 2, recovery: 1, policy: EvictIdleOverLimit}
+	)
+
+	c.acquire()
+	ok, _, saturated := c.acquire()
+	assert.True(ok)
+	assert.True(saturated)
+
+	ok, _, _ = c.acquire()
+	assert.False(ok)
+
+	// an admitted device can take the count past the hard limit, until the evicted device is released
+	overSoft, saturated := c.admit()
+	assert.False(overSoft)
+	assert.False(saturated)
+	assert.Equal(3, c.len())
+
+	_, recovered := c.release()
+	assert.False(recovered)
+
+	// eviction does not wait for recovery, only for room below the hard limit
+	_, recovered = c.release()
+	assert.True(recovered)
+	ok, _, saturated = c.acquire()
+	assert.True(ok)
+	assert.True(saturated)
+}
+
+func TestOptionsCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options), {SoftDeviceLimit: -1, HardDeviceLimit: -1, DeviceLimitPolicy: "unrecognized"}} {
+		c := o.capacity()
+		assert.Equal(int32(0), c.soft)
+		assert.Equal(int32(0), c.hard)
+		assert.Equal(RejectOverLimit, c.policy)
+		assert.Nil(o.health())
+	}
+
+	monitor := new(statsMonitor)
+	o := &Options{SoftDeviceLimit: 10, HardDeviceLimit: 20, Health: monitor}
+	c := o.capacity()
+	assert.Equal(int32(10), c.soft)
+	assert.Equal(int32(20), c.hard)
+	assert.Equal(int32(19), c.recovery)
+	assert.Equal(monitor, o.health())
+
+	for _, recovery := range []int{-1, 20, 21} {
+		assert.Equal(int32(19), (&Options{HardDeviceLimit: 20, DeviceLimitRecovery: recovery}).capacity().recovery)
+	}
+
+	c = (&Options{HardDeviceLimit: 20, DeviceLimitRecovery: 15, DeviceLimitPolicy: EvictIdleOverLimit}).capacity()
+	assert.Equal(int32(15), c.recovery)
+	assert.Equal(EvictIdleOverLimit, c.policy)
+}
+
+func TestManagerDeviceLimits(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		monitor      = new(statsMonitor)
+		connected    = make(chan Interface, 2)
+		disconnected = make(chan Interface, 2)
+		limits       = make(chan Event, 2)
+		options      = &Options{
+			Logger:          logging.TestLogger(t),
+			Metrics:         registry,
+			Health:          monitor,
+			SoftDeviceLimit: 1,
+			HardDeviceLimit: 2,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					case DeviceLimitReached, DeviceLimitRecovered:
+						limits <- *event
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(options, nil)
+	)
+
+	defer server.Close()
+
+	first, _, err := dialer.Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	<-connected
+	assert.Equal(0, monitor.stat(DeviceSoftLimitStat))
+
+	second, _, err := dialer.Dial(connectURL, ID("mac:112233445567"), nil, nil)
+	require.NoError(err)
+	<-connected
+	assert.Equal(1, monitor.stat(DeviceSoftLimitStat))
+	assert.Equal(1, monitor.stat(DeviceHardLimitStat))
+	if event := <-limits; assert.Equal(DeviceLimitReached, event.Type) {
+		assert.Nil(event.Device)
+		assert.Equal(2, event.DeviceCount)
+	}
+
+	third, response, err := dialer.Dial(connectURL, ID("mac:112233445568"), nil, nil)
+	assert.Nil(third)
+	assert.Error(err)
+	if assert.NotNil(response) {
+		assert.Equal(http.StatusServiceUnavailable, response.StatusCode)
+	}
+
+	first.Close()
+	<-disconnected
+	assert.Equal(0, monitor.stat(DeviceSoftLimitStat))
+	assert.Equal(0, monitor.stat(DeviceHardLimitStat))
+	if event := <-limits; assert.Equal(DeviceLimitRecovered, event.Type) {
+		assert.Equal(1, event.DeviceCount)
+	}
+
+	second.Close()
+	<-disconnected
+
+	metrics := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/", nil))
+	body := metrics.Body.String()
+	assert.True(strings.Contains(body, CapacityLimitCount+`{limit="soft"} 1`), body)
+	assert.True(strings.Contains(body, CapacityLimitCount+`{limit="hard"} 1`), body)
+	assert.True(strings.Contains(body, HandshakeRejectionCount+`{reason="capacity"} 1`), body)
+}
+
+func TestNewDeviceCountSource(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		registry = new(mockRegistry)
+		source   = NewDeviceCountSource(registry)
+		stats    = make(health.Stats)
+	)
+
+	registry.On("VisitAll", mock.AnythingOfType("func(device.Interface)")).Return(17).Once()
+	source.Collect(stats)
+	assert.Equal(health.Stats{DeviceCountStat: 17}, stats)
+	registry.AssertExpectations(t)
+}
+
+func TestManagerEvictIdle(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		manager = NewManager(nil, new(mockConnectionFactory)).(*manager)
+		now     = time.Now()
+	)
+
+	assert.False(manager.evictIdle())
+
+	var devices []*device
+	for i, lastRead := range []time.Duration{0, -time.Minute, -time.Hour, -time.Second} {
+		d := newDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, 1)
+		if lastRead != 0 {
+			d.statistics.read(1, now.Add(lastRead))
+		}
+
+		devices = append(devices, d)
+		assert.NoError(manager.registry.add(d))
+	}
+
+	// a device that has never sent a frame is idle since it connected
+	devices[0].connectedAt = now.Add(-2 * time.Hour)
+
+	for _, expected := range []int{0, 2, 1, 3} {
+		assert.True(manager.evictIdle())
+		for i, d := range devices {
+			if i == expected {
+				assert.True(d.Closed(), "device %d should have been evicted", i)
+			}
+		}
+	}
+
+	// every device is closed, so there is nothing left to evict
+	assert.False(manager.evictIdle())
+}
+
+func TestManagerEvictIdleOverLimit(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 3)
+		disconnected = make(chan Interface, 3)
+		received     = make(chan ID, 1)
+		evicted      = make(chan Interface, 1)
+		options      = &Options{
+			Logger:            logging.TestLogger(t),
+			Metrics:           registry,
+			HardDeviceLimit:   2,
+			DeviceLimitPolicy: EvictIdleOverLimit,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					case MessageReceived:
+						received <- event.Device.ID()
+					case Evicted:
+						evicted <- event.Device
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(options, nil)
+		waitFor               = func(events <-chan Interface, what string) Interface {
+			select {
+			case d := <-events:
+				return d
+			case <-time.After(5 * time.Second):
+				require.Fail("Timed out waiting for " + what)
+				return nil
+			}
+		}
+	)
+
+	defer server.Close()
+
+	idle, _, err := dialer.Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	idleDevice := waitFor(connected, "the idle device to connect")
+
+	active, _, err := dialer.Dial(connectURL, ID("mac:112233445567"), nil, nil)
+	require.NoError(err)
+	waitFor(connected, "the active device to connect")
+
+	var frame []byte
+	require.NoError(wrp.NewEncoderBytes(&frame, wrp.Msgpack).Encode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445567", Destination: "event:test"}))
+	_, err = active.Write(frame)
+	require.NoError(err)
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		require.Fail("The message from the active device was not received")
+	}
+
+	// the manager is at its limit, so the idle device makes room for the new one
+	newest, _, err := dialer.Dial(connectURL, ID("mac:112233445568"), nil, nil)
+	require.NoError(err)
+
+	assert.True(idleDevice == waitFor(evicted, "the eviction"))
+	assert.Equal(ID("mac:112233445568"), waitFor(connected, "the new device to connect").ID())
+	assert.True(idleDevice == waitFor(disconnected, "the evicted device to disconnect"))
+
+	idle.Close()
+	active.Close()
+	newest.Close()
+	waitFor(disconnected, "a device to disconnect")
+	waitFor(disconnected, "a device to disconnect")
+
+	metrics := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/", nil))
+	body := metrics.Body.String()
+	assert.True(strings.Contains(body, EvictedCount+" 1"), body)
+	assert.True(strings.Contains(body, CapacityLimitCount+`{limit="hard"} 1`), body)
+	assert.False(strings.Contains(body, HandshakeRejectionCount+`{reason="capacity"}`), body)
+}
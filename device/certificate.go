@@ -0,0 +1,51 @@
+package device
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+// verifiedChain returns the first verified chain of the client certificate presented with a TLS request,
+// leaf first.  This function returns nil if the request was not made over TLS or if the client did not
+// present a certificate that the server verified.
+func verifiedChain(request *http.Request) []*x509.Certificate {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	return request.TLS.VerifiedChains[0]
+}
+
+// CommonNameMatchesID tests if the subject common name of a client certificate is the given device ID.
+// The common name is parsed as a device name, so any of the forms accepted by ParseID may be used.
+func CommonNameMatchesID(id ID, certificate *x509.Certificate) bool {
+	certificateID, err := ParseID(certificate.Subject.CommonName)
+	return err == nil && certificateID == id
+}
+
+// RequireCertificate produces a ConnectAuthorizer that rejects devices, with RejectUnauthorized, unless they present
+// a verified TLS client certificate.  If match is not nil, it must also return true for the device's ID and the leaf
+// certificate.  CommonNameMatchesID is a typical match.
+//
+// The server must request client certificates for this authorizer to admit any devices, e.g. by setting the
+// ClientAuth of its tls.Config to tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert.
+func RequireCertificate(match func(ID, *x509.Certificate) bool) ConnectAuthorizer {
+	return ConnectAuthorizerFunc(func(request *ConnectRequest) (Tags, error) {
+		if len(request.Certificates) == 0 {
+			return nil, newRejection(
+				RejectUnauthorized,
+				fmt.Errorf("Device [%s] did not present a verified client certificate", request.ID),
+			)
+		}
+
+		if match != nil && !match(request.ID, request.Certificates[0]) {
+			return nil, newRejection(
+				RejectUnauthorized,
+				fmt.Errorf("The client certificate [%s] does not identify device [%s]", request.Certificates[0].Subject, request.ID),
+			)
+		}
+
+		return nil, nil
+	})
+}
@@ -0,0 +1,211 @@
+package device
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a certificate with the given common name.  A nil parent produces a self-signed CA.
+func newTestCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	certificate, err := x509.ParseCertificate(raw)
+	require.NoError(t, err)
+	return certificate, key
+}
+
+func TestVerifiedChain(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		leaf    = &x509.Certificate{Subject: pkix.Name{CommonName: "leaf"}}
+		ca      = &x509.Certificate{Subject: pkix.Name{CommonName: "ca"}}
+		request = httptest.NewRequest("GET", "/", nil)
+	)
+
+	assert.Nil(verifiedChain(request))
+
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	assert.Nil(verifiedChain(request))
+
+	request.TLS.VerifiedChains = [][]*x509.Certificate{{leaf, ca}, {leaf}}
+	assert.Equal([]*x509.Certificate{leaf, ca}, verifiedChain(request))
+}
+
+func TestCommonNameMatchesID(t *testing.T) {
+	assert := assert.New(t)
+	for commonName, expected := range map[string]bool{
+		"mac:112233445566":      true,
+		"MAC:11-22-33-44-55-66": true,
+		"mac:665544332211":      false,
+		"":                      false,
+		"not a device":          false,
+	} {
+		assert.Equal(expected, CommonNameMatchesID(ID("mac:112233445566"), &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}), "common name: %s", commonName)
+	}
+}
+
+func TestRequireCertificate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		leaf    = &x509.Certificate{Subject: pkix.Name{CommonName: "mac:112233445566"}}
+		request = &ConnectRequest{ID: ID("mac:112233445566")}
+	)
+
+	for _, authorizer := range []ConnectAuthorizer{RequireCertificate(nil), RequireCertificate(CommonNameMatchesID)} {
+		request.Certificates = nil
+		tags, err := authorizer.AuthorizeConnect(request)
+		assert.Nil(tags)
+		if rejection, ok := err.(*Rejection); assert.True(ok) {
+			assert.Equal(RejectUnauthorized, rejection.Reason)
+		}
+
+		request.Certificates = []*x509.Certificate{leaf}
+		tags, err = authorizer.AuthorizeConnect(request)
+		assert.Nil(tags)
+		assert.NoError(err)
+	}
+
+	request.ID = ID("mac:665544332211")
+	_, err := RequireCertificate(nil).AuthorizeConnect(request)
+	assert.NoError(err)
+
+	_, err = RequireCertificate(CommonNameMatchesID).AuthorizeConnect(request)
+	if rejection, ok := err.(*Rejection); assert.True(ok) {
+		assert.Equal(RejectUnauthorized, rejection.Reason)
+	}
+}
+
+func TestManagerClientCertificate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		ca, caKey    = newTestCertificate(t, "Test CA", nil, nil)
+		leaf, key    = newTestCertificate(t, "mac:112233445566", ca, caKey)
+		clientCAs    = x509.NewCertPool()
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan Interface, 1)
+		authorizing  = make(chan *ConnectRequest, 3)
+
+		options = &Options{
+			Logger: logging.TestLogger(t),
+			ConnectAuthorizer: ConnectAuthorizers{
+				ConnectAuthorizerFunc(func(request *ConnectRequest) (Tags, error) {
+					authorizing <- request
+					return nil, nil
+				}),
+				RequireCertificate(CommonNameMatchesID),
+			},
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		manager = NewManager(options, nil)
+		server  = httptest.NewUnstartedServer(&ConnectHandler{Logger: options.logger(), Connector: manager})
+	)
+
+	clientCAs.AddCert(ca)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	connectURL, err := url.Parse(server.URL)
+	require.NoError(err)
+	connectURL.Scheme = "wss"
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	dial := func(id ID, certificates ...tls.Certificate) (Connection, *http.Response, error) {
+		return NewDialer(options, &websocket.Dialer{
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs, Certificates: certificates},
+		}).Dial(connectURL.String(), id, nil, nil)
+	}
+
+	clientCertificate := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key}
+
+	t.Run("Verified", func(t *testing.T) {
+		c, _, err := dial(ID("mac:112233445566"), clientCertificate)
+		require.NoError(err)
+
+		request := <-authorizing
+		if assert.Len(request.Certificates, 2) {
+			assert.True(leaf.Equal(request.Certificates[0]))
+			assert.True(ca.Equal(request.Certificates[1]))
+		}
+
+		select {
+		case d := <-connected:
+			if assert.NotNil(d.Certificate()) {
+				assert.True(leaf.Equal(d.Certificate()))
+			}
+		case <-time.After(5 * time.Second):
+			require.Fail("The device did not connect")
+		}
+
+		// wait for the disconnect, so that nothing is logged after the test
+		c.Close()
+		<-disconnected
+	})
+
+	t.Run("WrongID", func(t *testing.T) {
+		_, response, err := dial(ID("mac:665544332211"), clientCertificate)
+		assert.Error(err)
+		if assert.NotNil(response) {
+			assert.Equal(http.StatusForbidden, response.StatusCode)
+		}
+
+		assert.Len((<-authorizing).Certificates, 2)
+	})
+
+	t.Run("NoCertificate", func(t *testing.T) {
+		_, response, err := dial(ID("mac:112233445566"))
+		assert.Error(err)
+		if assert.NotNil(response) {
+			assert.Equal(http.StatusForbidden, response.StatusCode)
+		}
+
+		assert.Empty((<-authorizing).Certificates)
+	})
+}
@@ -0,0 +1,91 @@
+package device
+
+import (
+	"bytes"
+	"compress/flate"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultCompressionLevel is the flate level used for compressed frames when none is configured
+	DefaultCompressionLevel = flate.BestSpeed
+
+	// DefaultCompressionThreshold is the size, in bytes, below which frames are not compressed
+	// when no threshold is configured
+	DefaultCompressionThreshold = 512
+
+	// minimumCompressionLevel is flate.HuffmanOnly, the lowest level gorilla accepts
+	minimumCompressionLevel = -2
+
+	// compressionExtension is the token of the permessage-deflate websocket extension
+	compressionExtension = "permessage-deflate"
+)
+
+// negotiatesCompression tests whether the Sec-Websocket-Extensions header lists permessage-deflate.
+// On the server, the request header is the device's offer.  On a dialer, the response header is the
+// server's acceptance.  Either way, gorilla only enables compression for the connection in that case.
+func negotiatesCompression(header http.Header) bool {
+	for _, value := range header["Sec-Websocket-Extensions"] {
+		for _, extension := range strings.Split(value, ",") {
+			if i := strings.IndexByte(extension, ';'); i >= 0 {
+				extension = extension[:i]
+			}
+
+			if strings.TrimSpace(extension) == compressionExtension {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// configureCompression sets up compression for a new connection on which permessage-deflate was negotiated
+func (c *connection) configureCompression(level, threshold int) error {
+	c.compressionThreshold = threshold
+	return c.webSocket.SetCompressionLevel(level)
+}
+
+// thresholdFrame is the io.WriteCloser returned by connection.NextWriter when compression depends
+// on frame size.  The frame is buffered, then written on Close, compressed only if it is at least as
+// large as the connection's threshold.
+//
+// The buffer is taken from the pool on the first Write and returned on Close.
+type thresholdFrame struct {
+	buffer *bytes.Buffer
+	c      *connection
+}
+
+func (tf *thresholdFrame) Write(p []byte) (int, error) {
+	if tf.buffer == nil {
+		tf.buffer = getBuffer()
+	}
+
+	return tf.buffer.Write(p)
+}
+
+func (tf *thresholdFrame) Close() error {
+	var contents []byte
+	if tf.buffer != nil {
+		contents = tf.buffer.Bytes()
+		defer func() {
+			putBuffer(tf.buffer)
+			tf.buffer = nil
+		}()
+	}
+
+	tf.c.webSocket.EnableWriteCompression(len(contents) >= tf.c.compressionThreshold)
+	frame, err := tf.c.webSocket.NextWriter(tf.c.frameType)
+	if err != nil {
+		return err
+	}
+
+	if _, err = frame.Write(contents); err != nil {
+		// don't hide the original error, but ensure the frame is closed
+		frame.Close()
+		return err
+	}
+
+	return frame.Close()
+}
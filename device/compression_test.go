@@ -0,0 +1,194 @@
+package device
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOptionsCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.False(o.enableCompression())
+		assert.Equal(DefaultCompressionLevel, o.compressionLevel())
+		assert.Equal(DefaultCompressionThreshold, o.compressionThreshold())
+	}
+
+	for _, invalid := range []int{-3, flate.BestCompression + 1} {
+		assert.Equal(DefaultCompressionLevel, (&Options{CompressionLevel: invalid}).compressionLevel())
+	}
+
+	o := &Options{EnableCompression: true, CompressionLevel: flate.HuffmanOnly, CompressionThreshold: 1024}
+	assert.True(o.enableCompression())
+	assert.Equal(flate.HuffmanOnly, o.compressionLevel())
+	assert.Equal(1024, o.compressionThreshold())
+
+	assert.Zero((&Options{CompressionThreshold: -1}).compressionThreshold())
+}
+
+func TestNegotiatesCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, record := range []struct {
+		values   []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{""}, false},
+		{[]string{"x-webkit-deflate-frame"}, false},
+		{[]string{"permessage-deflate"}, true},
+		{[]string{"permessage-deflate; client_max_window_bits"}, true},
+		{[]string{"foo, permessage-deflate; server_no_context_takeover"}, true},
+		{[]string{"foo", " permessage-deflate "}, true},
+	} {
+		header := http.Header{}
+		for _, value := range record.values {
+			header.Add("Sec-Websocket-Extensions", value)
+		}
+
+		assert.Equal(record.expected, negotiatesCompression(header), "%v", record.values)
+	}
+}
+
+// countingConn is a net.Conn that counts the bytes read from the network
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (cc countingConn) Read(data []byte) (int, error) {
+	n, err := cc.Conn.Read(data)
+	atomic.AddInt64(cc.read, int64(n))
+	return n, err
+}
+
+func testManagerCompression(t *testing.T, threshold int, expectCompressed bool) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		received     = make(chan []byte, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:               logging.TestLogger(t),
+			EnableCompression:    true,
+			CompressionThreshold: threshold,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived:
+						received <- append([]byte(nil), event.Message.(*wrp.Message).Payload...)
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+
+		read          int64
+		networkDialer = websocket.Dialer{
+			NetDial: func(network, address string) (net.Conn, error) {
+				c, err := net.Dial(network, address)
+				return countingConn{c, &read}, err
+			},
+		}
+
+		// highly compressible, and well above the threshold
+		payload = bytes.Repeat([]byte("compress me "), 1000)
+	)
+
+	defer server.Close()
+
+	c, response, err := NewDialer(options, &networkDialer).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	assert.True(negotiatesCompression(response.Header))
+	d := <-connected
+
+	before := atomic.LoadInt64(&read)
+	_, err = d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "event:test", Payload: payload}})
+	require.NoError(err)
+
+	var frame bytes.Buffer
+	_, err = c.Read(&frame)
+	require.NoError(err)
+
+	var message wrp.Message
+	require.NoError(wrp.NewDecoderBytes(frame.Bytes(), c.Format()).Decode(&message))
+	assert.Equal(payload, message.Payload)
+
+	if transferred := atomic.LoadInt64(&read) - before; expectCompressed {
+		assert.True(transferred < int64(len(payload)/4), "transferred %d bytes", transferred)
+	} else {
+		assert.True(transferred > int64(len(payload)), "transferred %d bytes", transferred)
+	}
+
+	// frames from the device are decompressed before decoding
+	var encoded []byte
+	require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Payload: payload}))
+	_, err = c.Write(encoded)
+	require.NoError(err)
+
+	select {
+	case actual := <-received:
+		assert.Equal(payload, actual)
+	case <-time.After(5 * time.Second):
+		require.Fail("No message was received")
+	}
+
+	c.Close()
+	<-disconnected
+}
+
+func TestManagerCompression(t *testing.T) {
+	t.Run("Compressed", func(t *testing.T) { testManagerCompression(t, 0, true) })
+	t.Run("CompressAll", func(t *testing.T) { testManagerCompression(t, -1, true) })
+	t.Run("BelowThreshold", func(t *testing.T) { testManagerCompression(t, 100000, false) })
+}
+
+func TestManagerCompressionNotOffered(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:            logging.TestLogger(t),
+			EnableCompression: true,
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == Disconnect {
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	// the device does not offer compression, so the server cannot use it
+	c, response, err := NewDialer(&Options{Logger: logging.TestLogger(t)}, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	assert.False(negotiatesCompression(response.Header))
+	assert.Zero(c.(*connection).compressionThreshold)
+
+	c.Close()
+	<-disconnected
+}
@@ -1,6 +1,7 @@
 package device
 
 import (
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/gorilla/websocket"
 	"io"
 	"net/http"
@@ -9,6 +10,10 @@ import (
 
 const (
 	transferBufferSize = 64
+
+	// MaxCloseReasonLength is the longest reason a websocket close frame can carry, as the
+	// control frame payload is limited to 125 bytes including the 2 byte close code
+	MaxCloseReasonLength = 123
 )
 
 // Connection represents a websocket connection to a WebPA-compatible device.
@@ -22,16 +27,15 @@ const (
 type Connection interface {
 	io.WriteCloser
 
-	// NextReader returns the next binary message frame.  If this method returns an error,
+	// NextReader returns the next message frame.  If this method returns an error,
 	// this connection should be abandoned and closed.  This method is not safe
 	// for concurrent invocation and must not be invoked concurrently with Write.
 	//
-	// NextReader will skip frames if they are not supported by the WRP protocol.  For example,
-	// text frames are not supported and are skipped.  Anytime a frame is skipped, this
-	// method returns a nil reader and a nil error.
+	// NextReader will skip frames if they are not of the configured frame type, which is
+	// binary by default.  Anytime a frame is skipped, this method returns a nil reader and a nil error.
 	NextReader() (io.Reader, error)
 
-	// Read transfers the next binary frame to the given ReaderFrom instance.  If this method
+	// Read transfers the next frame to the given ReaderFrom instance.  If this method
 	// returns an error, this connection should be abandoned and closed.  This method is not safe
 	// for concurrent invocation and must not be invoked concurrently with Write.
 	//
@@ -41,7 +45,7 @@ type Connection interface {
 	// and no frame will have been read.
 	Read(io.ReaderFrom) (bool, error)
 
-	// NextWriter returns a WriteCloser which can be used to construct the next binary frame.
+	// NextWriter returns a WriteCloser which can be used to construct the next frame, using the configured frame type.
 	// It's semantics are equivalent to the gorilla websocket's method of the same name.
 	NextWriter() (io.WriteCloser, error)
 
@@ -58,21 +62,44 @@ type Connection interface {
 	// This method cannot be called concurrently with Write().
 	SetPongCallback(func(string))
 
+	// Format returns the WRP encoding negotiated for this connection during the websocket handshake.
+	// See MsgpackSubprotocol, JSONSubprotocol, and Protocol.
+	Format() wrp.Format
+
+	// Subprotocol returns the websocket subprotocol negotiated during the handshake, or the empty
+	// string if none was negotiated
+	Subprotocol() string
+
 	// SendClose transmits a close frame to the device.  After this method is invoked,
 	// the only method that should be invoked is Close()
 	SendClose() error
+
+	// SendCloseReason is like SendClose, but allows the close code and reason to be specified
+	SendCloseReason(code int, reason string) error
 }
 
 // connection is the internal implementation of Connection
 type connection struct {
 	webSocket    *websocket.Conn
+	format       wrp.Format
+	frameType    int
 	idlePeriod   time.Duration
+	readTimeout  time.Duration
+	idleTimeout  time.Duration
 	writeTimeout time.Duration
+
+	// lastFrame is when the most recent frame header was read, or when the connection was established.
+	// It is only accessed by the goroutine reading from this connection, which also runs the pong handler.
+	lastFrame time.Time
+
+	// compressionThreshold is the smallest frame that is compressed.  It is only positive when
+	// compression was negotiated and depends on frame size.
+	compressionThreshold int
 }
 
 func (c *connection) updateReadDeadline() error {
 	return c.webSocket.SetReadDeadline(
-		time.Now().Add(c.idlePeriod),
+		c.readDeadline(time.Now()),
 	)
 }
 
@@ -122,8 +149,12 @@ func (c *connection) NextReader() (frame io.Reader, err error) {
 
 	var messageType int
 	if messageType, frame, err = c.webSocket.NextReader(); err != nil {
+		err = c.idleError(err)
 		return
-	} else if messageType != websocket.BinaryMessage {
+	} else if err = c.frameStarted(time.Now()); err != nil {
+		frame = nil
+		return
+	} else if messageType != c.frameType {
 		// skip this frame, and allow the caller to take some action
 		frame = nil
 	}
@@ -135,7 +166,7 @@ func (c *connection) Read(target io.ReaderFrom) (frameRead bool, err error) {
 	var frame io.Reader
 	frame, err = c.NextReader()
 	frameRead = (frame != nil)
-	if err == nil {
+	if frameRead && err == nil {
 		_, err = target.ReadFrom(frame)
 	}
 
@@ -147,7 +178,11 @@ func (c *connection) NextWriter() (io.WriteCloser, error) {
 		return nil, err
 	}
 
-	return c.webSocket.NextWriter(websocket.BinaryMessage)
+	if c.compressionThreshold > 0 {
+		return &thresholdFrame{c: c}, nil
+	}
+
+	return c.webSocket.NextWriter(c.frameType)
 }
 
 func (c *connection) Write(message []byte) (count int, err error) {
@@ -160,14 +195,26 @@ func (c *connection) Write(message []byte) (count int, err error) {
 	return frame.Write(message)
 }
 
+func (c *connection) Format() wrp.Format {
+	return c.format
+}
+
+func (c *connection) Subprotocol() string {
+	return c.webSocket.Subprotocol()
+}
+
 func (c *connection) Close() error {
 	return c.webSocket.Close()
 }
 
 func (c *connection) SendClose() error {
+	return c.SendCloseReason(websocket.CloseNormalClosure, "close")
+}
+
+func (c *connection) SendCloseReason(code int, reason string) error {
 	return c.webSocket.WriteControl(
 		websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "close"),
+		websocket.FormatCloseMessage(code, reason),
 		c.nextWriteDeadline(),
 	)
 }
@@ -185,35 +232,81 @@ type ConnectionFactory interface {
 
 // NewConnectionFactory produces a ConnectionFactory instance from a set of Options.
 func NewConnectionFactory(o *Options) ConnectionFactory {
+	var (
+		checkOrigin = o.originChecker()
+		protocols   = newProtocols(o)
+	)
+
 	return &connectionFactory{
 		upgrader: websocket.Upgrader{
-			HandshakeTimeout: o.handshakeTimeout(),
-			ReadBufferSize:   o.readBufferSize(),
-			WriteBufferSize:  o.writeBufferSize(),
-			Subprotocols:     o.subprotocols(),
+			HandshakeTimeout:  o.handshakeTimeout(),
+			ReadBufferSize:    o.readBufferSize(),
+			WriteBufferSize:   o.writeBufferSize(),
+			Subprotocols:      protocols.offered(serverSubprotocols(o.subprotocols())),
+			CheckOrigin:       checkOrigin,
+			Error:             upgradeError,
+			EnableCompression: o.enableCompression(),
 		},
+		checkOrigin:  checkOrigin,
+		protocols:    protocols,
 		idlePeriod:   o.idlePeriod(),
+		readTimeout:  o.readTimeout(),
+		idleTimeout:  o.idleTimeout(),
 		writeTimeout: o.writeTimeout(),
+
+		compressionLevel:     o.compressionLevel(),
+		compressionThreshold: o.compressionThreshold(),
 	}
 }
 
+// upgradeError is the websocket.Upgrader error callback, which writes upgrade failures as Rejections
+func upgradeError(response http.ResponseWriter, request *http.Request, status int, reason error) {
+	(&Rejection{Reason: RejectUpgradeFailed, Status: status, Err: reason}).WriteResponse(response)
+}
+
 // connectionFactory is the default ConnectionFactory implementation
 type connectionFactory struct {
 	upgrader     websocket.Upgrader
+	checkOrigin  OriginChecker
+	protocols    *protocols
 	idlePeriod   time.Duration
+	readTimeout  time.Duration
+	idleTimeout  time.Duration
 	writeTimeout time.Duration
+
+	compressionLevel     int
+	compressionThreshold int
 }
 
 func (cf *connectionFactory) NewConnection(response http.ResponseWriter, request *http.Request, responseHeader http.Header) (Connection, error) {
+	// check the origin ahead of the upgrader, so that origin rejections can be distinguished from other upgrade failures
+	if !cf.checkOrigin(request) {
+		return nil, reject(response, RejectOriginRejected, ErrorOriginRejected)
+	}
+
 	webSocket, err := cf.upgrader.Upgrade(response, request, responseHeader)
 	if err != nil {
-		return nil, err
+		// the upgrader has already written the response via upgradeError
+		return nil, newRejection(RejectUpgradeFailed, err)
 	}
 
+	negotiated := cf.protocols.negotiate(webSocket.Subprotocol())
 	c := &connection{
 		webSocket:    webSocket,
+		format:       negotiated.format,
+		frameType:    negotiated.messageType,
 		idlePeriod:   cf.idlePeriod,
+		readTimeout:  cf.readTimeout,
+		idleTimeout:  cf.idleTimeout,
 		writeTimeout: cf.writeTimeout,
+		lastFrame:    time.Now(),
+	}
+
+	if cf.upgrader.EnableCompression && negotiatesCompression(request.Header) {
+		if err := c.configureCompression(cf.compressionLevel, cf.compressionThreshold); err != nil {
+			webSocket.Close()
+			return nil, err
+		}
 	}
 
 	// initialize the pong callback to the default, which
@@ -233,7 +326,10 @@ type Dialer interface {
 // If an Options is supplied, the appropriate settings will override any gorilla Dialer, e.g. ReadBufferSize.
 func NewDialer(o *Options, d *websocket.Dialer) Dialer {
 	dialer := &dialer{
+		protocols:    newProtocols(o),
 		idlePeriod:   o.idlePeriod(),
+		readTimeout:  o.readTimeout(),
+		idleTimeout:  o.idleTimeout(),
 		writeTimeout: o.writeTimeout(),
 	}
 
@@ -247,11 +343,16 @@ func NewDialer(o *Options, d *websocket.Dialer) Dialer {
 		dialer.webSocketDialer.HandshakeTimeout = o.handshakeTimeout()
 		dialer.webSocketDialer.ReadBufferSize = o.readBufferSize()
 		dialer.webSocketDialer.WriteBufferSize = o.writeBufferSize()
-		dialer.webSocketDialer.Subprotocols = o.subprotocols()
+		dialer.webSocketDialer.Subprotocols = dialer.protocols.offered(o.subprotocols())
+		dialer.webSocketDialer.EnableCompression = o.enableCompression()
 	}
 
 	dialer.deviceNameHeader = o.deviceNameHeader()
 	dialer.conveyHeader = o.conveyHeader()
+	dialer.conveyCodec = o.conveyCodec()
+	dialer.conveyCompressionThreshold = o.conveyCompressionThreshold()
+	dialer.compressionLevel = o.compressionLevel()
+	dialer.compressionThreshold = o.compressionThreshold()
 	return dialer
 }
 
@@ -260,8 +361,41 @@ type dialer struct {
 	webSocketDialer  websocket.Dialer
 	deviceNameHeader string
 	conveyHeader     string
+	protocols        *protocols
 	idlePeriod       time.Duration
+	readTimeout      time.Duration
+	idleTimeout      time.Duration
 	writeTimeout     time.Duration
+
+	conveyCodec                ConveyCodec
+	conveyCompressionThreshold int
+	compressionLevel           int
+	compressionThreshold       int
+}
+
+// encodeConvey produces the header value for a convey, compressing it if it is too large
+func (d *dialer) encodeConvey(convey Convey) (string, error) {
+	conveyCodec := d.conveyCodec
+	if conveyCodec == nil {
+		conveyCodec = JSONConveyCodec
+	}
+
+	encoded, err := conveyCodec.Encode(convey)
+	if err != nil || d.conveyCompressionThreshold < 1 || len(encoded) <= d.conveyCompressionThreshold {
+		return encoded, err
+	}
+
+	compressing, ok := conveyCodec.(compressingConveyCodec)
+	if !ok {
+		return encoded, nil
+	}
+
+	compressed, err := compressing.EncodeCompressed(convey)
+	if err != nil || len(compressed) >= len(encoded) {
+		return encoded, nil
+	}
+
+	return compressed, nil
 }
 
 func (d *dialer) Dial(URL string, id ID, convey Convey, extra http.Header) (Connection, *http.Response, error) {
@@ -272,7 +406,7 @@ func (d *dialer) Dial(URL string, id ID, convey Convey, extra http.Header) (Conn
 
 	requestHeader.Set(d.deviceNameHeader, string(id))
 	if len(convey) > 0 {
-		encoded, err := EncodeConvey(convey, nil)
+		encoded, err := d.encodeConvey(convey)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -285,10 +419,23 @@ func (d *dialer) Dial(URL string, id ID, convey Convey, extra http.Header) (Conn
 		return nil, response, err
 	}
 
+	negotiated := d.protocols.negotiate(webSocket.Subprotocol())
 	c := &connection{
 		webSocket:    webSocket,
+		format:       negotiated.format,
+		frameType:    negotiated.messageType,
 		idlePeriod:   d.idlePeriod,
+		readTimeout:  d.readTimeout,
+		idleTimeout:  d.idleTimeout,
 		writeTimeout: d.writeTimeout,
+		lastFrame:    time.Now(),
+	}
+
+	if d.webSocketDialer.EnableCompression && negotiatesCompression(response.Header) {
+		if err := c.configureCompression(d.compressionLevel, d.compressionThreshold); err != nil {
+			webSocket.Close()
+			return nil, response, err
+		}
 	}
 
 	// initialize the pong callback to the default, which
@@ -1,12 +1,42 @@
 package device
 
 import (
-	"bytes"
 	"encoding/base64"
+	"fmt"
 	"github.com/ugorji/go/codec"
 	"reflect"
 )
 
+const (
+	// GzipConveyPrefix marks an on-the-wire convey value as gzip-compressed JSON.  The prefix
+	// cannot appear in any base64 alphabet, so uncompressed values are never mistaken for compressed ones.
+	GzipConveyPrefix = "gzip:"
+
+	// ConveyClassInvalid is the ConveyFailureCount class for convey values that could not be parsed
+	ConveyClassInvalid = "invalid"
+
+	// ConveyClassTooLarge is the ConveyFailureCount class for convey values longer than the configured maximum
+	ConveyClassTooLarge = "too_large"
+)
+
+// ConveyPolicy determines what happens when a device connects with a convey value that is
+// too large or cannot be parsed
+type ConveyPolicy string
+
+const (
+	// RejectInvalidConvey rejects the connection with RejectBadConvey.  This is the default.
+	RejectInvalidConvey ConveyPolicy = "reject"
+
+	// FlagInvalidConvey accepts the connection with a nil Convey.  The failure is available
+	// from the device's ConveyError method.
+	FlagInvalidConvey ConveyPolicy = "flag"
+
+	// RawInvalidConvey is like FlagInvalidConvey, except that an unparseable convey value is
+	// retained as is and available from the device's RawConvey method.  Convey values that are
+	// too large are never retained.
+	RawInvalidConvey ConveyPolicy = "raw"
+)
+
 var (
 	conveyHandle codec.Handle = &codec.JsonHandle{
 		BasicHandle: codec.BasicHandle{
@@ -25,39 +55,47 @@ type Convey map[string]interface{}
 
 // ParseConvey decodes a value using the supplied encoding and then unmarshals
 // the result as a Convey map.  If encoding is nil, base64.StdEncoding is used.
+//
+// Values beginning with GzipConveyPrefix are decompressed after base64 decoding,
+// so both the plain and compressed forms are handled transparently.
 func ParseConvey(value string, encoding *base64.Encoding) (Convey, error) {
-	if encoding == nil {
-		encoding = base64.StdEncoding
+	return NewJSONConveyCodec(encoding).Decode(value)
+}
+
+// parseConvey applies the configured size limit to a convey value from a connecting device before
+// parsing it.  The returned string is the raw value the device retains, which is empty when the
+// value was discarded.  A non-nil error indicates a failure that the convey policy must handle.
+func (m *manager) parseConvey(value string) (Convey, string, error) {
+	if m.maxConveySize > 0 && len(value) > m.maxConveySize {
+		m.metrics.conveyFailure(ConveyClassTooLarge, m.conveyPolicy)
+		return nil, "", ErrorConveyTooLarge
 	}
 
-	input := bytes.NewBufferString(value)
-	decoder := codec.NewDecoder(
-		base64.NewDecoder(encoding, input),
-		conveyHandle,
-	)
+	convey, err := m.conveyCodec.Decode(value)
+	if err != nil {
+		class := ConveyClassInvalid
+		if _, ok := err.(*ConveyValidationError); ok {
+			class = ConveyClassSchema
+		}
 
-	var convey Convey
-	if err := decoder.Decode(&convey); err != nil {
-		return nil, err
+		m.metrics.conveyFailure(class, m.conveyPolicy)
+		err = fmt.Errorf("Bad convey value [%s]: %s", value, err)
+		if m.conveyPolicy != RawInvalidConvey {
+			return nil, "", err
+		}
 	}
 
-	return convey, nil
+	return convey, value, err
 }
 
 // EncodeConvey transforms a Convey map into its on-the-wire representation,
 // using the supplied encoding.  If encoding == nil, base64.StdEncoding is used.
 func EncodeConvey(convey Convey, encoding *base64.Encoding) (string, error) {
-	if encoding == nil {
-		encoding = base64.StdEncoding
-	}
-
-	output := new(bytes.Buffer)
-	base64 := base64.NewEncoder(encoding, output)
-	encoder := codec.NewEncoder(base64, conveyHandle)
-	if err := encoder.Encode(convey); err != nil {
-		return "", err
-	}
+	return NewJSONConveyCodec(encoding).Encode(convey)
+}
 
-	base64.Close()
-	return output.String(), nil
+// EncodeCompressedConvey is like EncodeConvey, except that the JSON is gzip-compressed
+// before base64 encoding and the result is marked with GzipConveyPrefix.
+func EncodeCompressedConvey(convey Convey, encoding *base64.Encoding) (string, error) {
+	return newBase64ConveyCodec(conveyHandle, encoding).EncodeCompressed(convey)
 }
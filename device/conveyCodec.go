@@ -0,0 +1,295 @@
+package device
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// ConveyClassSchema is the ConveyFailureCount class for convey values that were parsed but failed validation
+	ConveyClassSchema = "schema"
+)
+
+var (
+	conveyMsgpackHandle codec.Handle = &codec.MsgpackHandle{
+		BasicHandle: codec.BasicHandle{
+			DecodeOptions: codec.DecodeOptions{
+				MapType: reflect.TypeOf(map[string]interface{}(nil)),
+			},
+		},
+		RawToString: true,
+	}
+
+	// JSONConveyCodec is the default ConveyCodec.  It encodes conveys as base64-encoded JSON, using base64.StdEncoding.
+	JSONConveyCodec = NewJSONConveyCodec(nil)
+
+	// MsgpackConveyCodec encodes conveys as base64-encoded Msgpack, using base64.StdEncoding
+	MsgpackConveyCodec = NewMsgpackConveyCodec(nil)
+)
+
+// ConveyCodec translates between Convey maps and their on-the-wire representation, e.g. the value of
+// the convey header sent by a connecting device
+type ConveyCodec interface {
+	// Decode parses an on-the-wire convey value
+	Decode(value string) (Convey, error)
+
+	// Encode produces the on-the-wire representation of a convey
+	Encode(convey Convey) (string, error)
+}
+
+// compressingConveyCodec is implemented by ConveyCodecs that can produce a gzip-compressed representation,
+// marked with GzipConveyPrefix.  Dialers use this to honor Options.ConveyCompressionThreshold.
+type compressingConveyCodec interface {
+	EncodeCompressed(convey Convey) (string, error)
+}
+
+// base64ConveyCodec is the ConveyCodec for conveys serialized by a ugorji codec and encoded as base64
+type base64ConveyCodec struct {
+	handle   codec.Handle
+	encoding *base64.Encoding
+}
+
+// NewJSONConveyCodec produces a ConveyCodec for base64-encoded JSON conveys.  If encoding is nil,
+// base64.StdEncoding is used.
+//
+// Values beginning with GzipConveyPrefix are decompressed after base64 decoding, so both the plain
+// and compressed forms are handled transparently.
+func NewJSONConveyCodec(encoding *base64.Encoding) ConveyCodec {
+	return newBase64ConveyCodec(conveyHandle, encoding)
+}
+
+// NewMsgpackConveyCodec produces a ConveyCodec for base64-encoded Msgpack conveys, which are more compact than
+// JSON.  If encoding is nil, base64.StdEncoding is used.  As with NewJSONConveyCodec, compressed values are
+// handled transparently.
+func NewMsgpackConveyCodec(encoding *base64.Encoding) ConveyCodec {
+	return newBase64ConveyCodec(conveyMsgpackHandle, encoding)
+}
+
+func newBase64ConveyCodec(handle codec.Handle, encoding *base64.Encoding) *base64ConveyCodec {
+	if encoding == nil {
+		encoding = base64.StdEncoding
+	}
+
+	return &base64ConveyCodec{handle, encoding}
+}
+
+func (bcc *base64ConveyCodec) Decode(value string) (Convey, error) {
+	var source io.Reader
+	if strings.HasPrefix(value, GzipConveyPrefix) {
+		gzipReader, err := gzip.NewReader(
+			base64.NewDecoder(bcc.encoding, strings.NewReader(value[len(GzipConveyPrefix):])),
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer gzipReader.Close()
+		source = gzipReader
+	} else {
+		source = base64.NewDecoder(bcc.encoding, strings.NewReader(value))
+	}
+
+	var convey Convey
+	if err := codec.NewDecoder(source, bcc.handle).Decode(&convey); err != nil {
+		return nil, err
+	}
+
+	return convey, nil
+}
+
+func (bcc *base64ConveyCodec) Encode(convey Convey) (string, error) {
+	output := new(bytes.Buffer)
+	base64 := base64.NewEncoder(bcc.encoding, output)
+	if err := codec.NewEncoder(base64, bcc.handle).Encode(convey); err != nil {
+		return "", err
+	}
+
+	base64.Close()
+	return output.String(), nil
+}
+
+func (bcc *base64ConveyCodec) EncodeCompressed(convey Convey) (string, error) {
+	output := bytes.NewBufferString(GzipConveyPrefix)
+	base64 := base64.NewEncoder(bcc.encoding, output)
+	gzipWriter := gzip.NewWriter(base64)
+	if err := codec.NewEncoder(gzipWriter, bcc.handle).Encode(convey); err != nil {
+		return "", err
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return "", err
+	}
+
+	base64.Close()
+	return output.String(), nil
+}
+
+// ConveyValidator checks a decoded convey, returning a non-nil error if the convey is not acceptable
+type ConveyValidator interface {
+	Validate(convey Convey) error
+}
+
+// ConveyValidatorFunc is a function type that implements ConveyValidator
+type ConveyValidatorFunc func(Convey) error
+
+func (cvf ConveyValidatorFunc) Validate(convey Convey) error {
+	return cvf(convey)
+}
+
+// ConveyValidationError is the error returned by a validating ConveyCodec for a convey that was
+// decoded, but which failed validation
+type ConveyValidationError struct {
+	Err error
+}
+
+func (cve *ConveyValidationError) Error() string {
+	return fmt.Sprintf("Invalid convey: %s", cve.Err)
+}
+
+// validatingConveyCodec is a ConveyCodec decorator that validates conveys
+type validatingConveyCodec struct {
+	ConveyCodec
+	validator ConveyValidator
+}
+
+// NewValidatingConveyCodec decorates a ConveyCodec so that each convey must pass the given validator,
+// both when decoded and when encoded.  Validation failures are returned as *ConveyValidationError.
+//
+// Configuring this codec in Options.ConveyCodec rejects, or flags, devices that connect with malformed
+// conveys as dictated by Options.ConveyPolicy.
+func NewValidatingConveyCodec(delegate ConveyCodec, validator ConveyValidator) ConveyCodec {
+	return &validatingConveyCodec{delegate, validator}
+}
+
+func (vcc *validatingConveyCodec) validate(convey Convey) error {
+	if err := vcc.validator.Validate(convey); err != nil {
+		return &ConveyValidationError{err}
+	}
+
+	return nil
+}
+
+func (vcc *validatingConveyCodec) Decode(value string) (Convey, error) {
+	convey, err := vcc.ConveyCodec.Decode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vcc.validate(convey); err != nil {
+		return nil, err
+	}
+
+	return convey, nil
+}
+
+func (vcc *validatingConveyCodec) Encode(convey Convey) (string, error) {
+	if err := vcc.validate(convey); err != nil {
+		return "", err
+	}
+
+	return vcc.ConveyCodec.Encode(convey)
+}
+
+func (vcc *validatingConveyCodec) EncodeCompressed(convey Convey) (string, error) {
+	if compressing, ok := vcc.ConveyCodec.(compressingConveyCodec); ok {
+		if err := vcc.validate(convey); err != nil {
+			return "", err
+		}
+
+		return compressing.EncodeCompressed(convey)
+	}
+
+	return vcc.Encode(convey)
+}
+
+// ConveyKind is the kind of value a convey property holds, as with JSON schema types
+type ConveyKind string
+
+const (
+	ConveyString  ConveyKind = "string"
+	ConveyNumber  ConveyKind = "number"
+	ConveyBoolean ConveyKind = "boolean"
+	ConveyObject  ConveyKind = "object"
+	ConveyArray   ConveyKind = "array"
+)
+
+// conveyKindOf determines the ConveyKind of a decoded convey value, returning the empty string
+// for null or unrecognized values
+func conveyKindOf(value interface{}) ConveyKind {
+	switch value.(type) {
+	case string:
+		return ConveyString
+	case bool:
+		return ConveyBoolean
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return ConveyNumber
+	case map[string]interface{}, Convey:
+		return ConveyObject
+	case []interface{}:
+		return ConveyArray
+	default:
+		return ""
+	}
+}
+
+// ConveySchema is a ConveyValidator that checks the top-level properties of a convey
+type ConveySchema struct {
+	// Required lists the properties every convey must have
+	Required []string
+
+	// Properties maps property names onto the kinds of values they must hold, when present
+	Properties map[string]ConveyKind
+
+	// Strict disallows any property not listed in Properties or Required
+	Strict bool
+}
+
+func (cs *ConveySchema) Validate(convey Convey) error {
+	for _, name := range cs.Required {
+		if _, ok := convey[name]; !ok {
+			return fmt.Errorf("Missing required property [%s]", name)
+		}
+	}
+
+	// check properties in a consistent order, so that the reported error is deterministic
+	names := make([]string, 0, len(convey))
+	for name := range convey {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		expected, ok := cs.Properties[name]
+		if !ok {
+			if cs.Strict && !cs.requires(name) {
+				return fmt.Errorf("Unexpected property [%s]", name)
+			}
+
+			continue
+		}
+
+		if actual := conveyKindOf(convey[name]); actual != expected {
+			return fmt.Errorf("Property [%s] must be of kind %s", name, expected)
+		}
+	}
+
+	return nil
+}
+
+func (cs *ConveySchema) requires(name string) bool {
+	for _, required := range cs.Required {
+		if required == name {
+			return true
+		}
+	}
+
+	return false
+}
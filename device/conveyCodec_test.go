@@ -0,0 +1,269 @@
+package device
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConveyCodecRoundTrip(t *testing.T, conveyCodec ConveyCodec) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		original = Convey{
+			"string": "value",
+			"flag":   true,
+			"nested": map[string]interface{}{"key": "value"},
+			"list":   []interface{}{"first", "second"},
+			"number": 123,
+		}
+	)
+
+	encoded, err := conveyCodec.Encode(original)
+	require.NoError(err)
+
+	decoded, err := conveyCodec.Decode(encoded)
+	require.NoError(err)
+	assert.Equal("value", decoded["string"])
+	assert.Equal(true, decoded["flag"])
+	assert.Equal(map[string]interface{}{"key": "value"}, decoded["nested"])
+	assert.Equal([]interface{}{"first", "second"}, decoded["list"])
+	assert.Equal(ConveyNumber, conveyKindOf(decoded["number"]))
+
+	compressing, ok := conveyCodec.(compressingConveyCodec)
+	require.True(ok)
+	compressed, err := compressing.EncodeCompressed(original)
+	require.NoError(err)
+	assert.True(strings.HasPrefix(compressed, GzipConveyPrefix))
+
+	decompressed, err := conveyCodec.Decode(compressed)
+	require.NoError(err)
+	assert.Equal(decoded, decompressed)
+
+	for _, invalid := range []string{"this is not valid", GzipConveyPrefix + "this is not valid"} {
+		convey, err := conveyCodec.Decode(invalid)
+		assert.Nil(convey)
+		assert.Error(err)
+	}
+}
+
+func TestConveyCodec(t *testing.T) {
+	t.Run("JSON", func(t *testing.T) { testConveyCodecRoundTrip(t, JSONConveyCodec) })
+	t.Run("JSONURLEncoding", func(t *testing.T) { testConveyCodecRoundTrip(t, NewJSONConveyCodec(base64.URLEncoding)) })
+	t.Run("Msgpack", func(t *testing.T) { testConveyCodecRoundTrip(t, MsgpackConveyCodec) })
+	t.Run("MsgpackURLEncoding", func(t *testing.T) { testConveyCodecRoundTrip(t, NewMsgpackConveyCodec(base64.URLEncoding)) })
+}
+
+func TestJSONConveyCodecCompatibility(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		convey  = Convey{"foo": "bar"}
+	)
+
+	encoded, err := EncodeConvey(convey, nil)
+	require.NoError(err)
+	decoded, err := JSONConveyCodec.Decode(encoded)
+	assert.Equal(convey, decoded)
+	assert.NoError(err)
+
+	encoded, err = JSONConveyCodec.Encode(convey)
+	require.NoError(err)
+	decoded, err = ParseConvey(encoded, nil)
+	assert.Equal(convey, decoded)
+	assert.NoError(err)
+}
+
+func TestConveySchema(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		schema = &ConveySchema{
+			Required: []string{"hw-model", "fw-name"},
+			Properties: map[string]ConveyKind{
+				"hw-model":      ConveyString,
+				"fw-name":       ConveyString,
+				"boot-time":     ConveyNumber,
+				"webpa-enabled": ConveyBoolean,
+				"interfaces":    ConveyArray,
+				"location":      ConveyObject,
+			},
+		}
+
+		valid = Convey{
+			"hw-model":      "model",
+			"fw-name":       "firmware",
+			"boot-time":     int64(1234567),
+			"webpa-enabled": true,
+			"interfaces":    []interface{}{"erouter0"},
+			"location":      map[string]interface{}{"lat": 1.5},
+			"extra":         "allowed",
+		}
+	)
+
+	assert.NoError(schema.Validate(valid))
+	assert.NoError(schema.Validate(Convey{"hw-model": "model", "fw-name": "firmware"}))
+
+	for _, invalid := range []Convey{
+		nil,
+		{"hw-model": "model"},
+		{"hw-model": 123, "fw-name": "firmware"},
+		{"hw-model": "model", "fw-name": "firmware", "boot-time": "yesterday"},
+		{"hw-model": "model", "fw-name": "firmware", "webpa-enabled": "true"},
+		{"hw-model": "model", "fw-name": "firmware", "interfaces": "erouter0"},
+		{"hw-model": "model", "fw-name": "firmware", "location": nil},
+	} {
+		assert.Error(schema.Validate(invalid), "%v", invalid)
+	}
+
+	schema.Strict = true
+	assert.Error(schema.Validate(valid))
+	assert.NoError(schema.Validate(Convey{"hw-model": "model", "fw-name": "firmware", "boot-time": 1.0}))
+
+	// required properties need not have a kind to be allowed by a strict schema
+	assert.NoError((&ConveySchema{Required: []string{"foo"}, Strict: true}).Validate(Convey{"foo": nil}))
+}
+
+func TestValidatingConveyCodec(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		expectedError = errors.New("expected")
+
+		conveyCodec = NewValidatingConveyCodec(
+			JSONConveyCodec,
+			ConveyValidatorFunc(func(c Convey) error {
+				if _, ok := c["valid"]; !ok {
+					return expectedError
+				}
+
+				return nil
+			}),
+		)
+	)
+
+	encoded, err := conveyCodec.Encode(Convey{"valid": true})
+	require.NoError(err)
+	decoded, err := conveyCodec.Decode(encoded)
+	assert.Equal(Convey{"valid": true}, decoded)
+	assert.NoError(err)
+
+	compressed, err := conveyCodec.(compressingConveyCodec).EncodeCompressed(Convey{"valid": true})
+	require.NoError(err)
+	assert.True(strings.HasPrefix(compressed, GzipConveyPrefix))
+
+	invalid, err := JSONConveyCodec.Encode(Convey{"foo": "bar"})
+	require.NoError(err)
+	decoded, err = conveyCodec.Decode(invalid)
+	assert.Nil(decoded)
+	if validationError, ok := err.(*ConveyValidationError); assert.True(ok) {
+		assert.Equal(expectedError, validationError.Err)
+		assert.Contains(validationError.Error(), expectedError.Error())
+	}
+
+	encoded, err = conveyCodec.Encode(Convey{"foo": "bar"})
+	assert.Empty(encoded)
+	assert.IsType(new(ConveyValidationError), err)
+
+	encoded, err = conveyCodec.(compressingConveyCodec).EncodeCompressed(Convey{"foo": "bar"})
+	assert.Empty(encoded)
+	assert.IsType(new(ConveyValidationError), err)
+
+	// decoding failures are not validation failures
+	decoded, err = conveyCodec.Decode("this is not valid")
+	assert.Nil(decoded)
+	assert.Error(err)
+	_, isValidationError := err.(*ConveyValidationError)
+	assert.False(isValidationError)
+}
+
+func TestOptionsConveyCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Equal(JSONConveyCodec, o.conveyCodec())
+	}
+
+	assert.Equal(MsgpackConveyCodec, (&Options{ConveyCodec: MsgpackConveyCodec}).conveyCodec())
+}
+
+func TestManagerParseConveySchema(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	m := NewManager(&Options{
+		Logger:      logging.TestLogger(t),
+		Metrics:     registry,
+		ConveyCodec: NewValidatingConveyCodec(JSONConveyCodec, &ConveySchema{Required: []string{"fw-name"}}),
+	}, nil).(*manager)
+
+	valid, _ := EncodeConvey(Convey{"fw-name": "firmware"}, nil)
+	convey, raw, err := m.parseConvey(valid)
+	assert.Equal(Convey{"fw-name": "firmware"}, convey)
+	assert.Equal(valid, raw)
+	assert.NoError(err)
+
+	invalid, _ := EncodeConvey(Convey{"foo": "bar"}, nil)
+	convey, raw, err = m.parseConvey(invalid)
+	assert.Nil(convey)
+	assert.Empty(raw)
+	assert.Error(err)
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(response.Body.String(), ConveyFailureCount+`{action="`+string(RejectInvalidConvey)+`",class="`+ConveyClassSchema+`"} 1`)
+}
+
+func TestManagerConnectMsgpackConvey(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:      logging.TestLogger(t),
+			ConveyCodec: MsgpackConveyCodec,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), Convey{"fw-name": "firmware"}, nil)
+	require.NoError(err)
+
+	select {
+	case d := <-connected:
+		assert.Equal(Convey{"fw-name": "firmware"}, d.Convey())
+		assert.NoError(d.ConveyError())
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not connect")
+	}
+
+	c.Close()
+	<-disconnected
+}
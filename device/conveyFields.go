@@ -0,0 +1,227 @@
+package device
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The canonical convey properties.  Where a device sends these properties, the typed accessors on
+// Convey and ConveyFields should be used rather than reading the raw map.
+const (
+	ConveySchemaVersionKey       = "schema-version"
+	ConveyFirmwareVersionKey     = "fw-name"
+	ConveyModelKey               = "hw-model"
+	ConveyManufacturerKey        = "hw-manufacturer"
+	ConveySerialNumberKey        = "hw-serial-number"
+	ConveyLastRebootReasonKey    = "hw-last-reboot-reason"
+	ConveyBootTimeKey            = "boot-time"
+	ConveyProtocolKey            = "webpa-protocol"
+	ConveyInterfaceUsedKey       = "webpa-interface-used"
+	ConveyLastReconnectReasonKey = "webpa-last-reconnect-reason"
+
+	// CurrentConveySchemaVersion is the newest convey schema understood by this package.  Conveys that do
+	// not carry a ConveySchemaVersionKey are version 1.
+	CurrentConveySchemaVersion = 1
+)
+
+// CanonicalConveySchema returns a ConveySchema that checks the kinds of the canonical convey properties,
+// suitable for NewValidatingConveyCodec.  No properties are required, and other properties are allowed.
+func CanonicalConveySchema() *ConveySchema {
+	return &ConveySchema{
+		Properties: map[string]ConveyKind{
+			ConveySchemaVersionKey:       ConveyNumber,
+			ConveyFirmwareVersionKey:     ConveyString,
+			ConveyModelKey:               ConveyString,
+			ConveyManufacturerKey:        ConveyString,
+			ConveySerialNumberKey:        ConveyString,
+			ConveyLastRebootReasonKey:    ConveyString,
+			ConveyBootTimeKey:            ConveyNumber,
+			ConveyProtocolKey:            ConveyString,
+			ConveyInterfaceUsedKey:       ConveyString,
+			ConveyLastReconnectReasonKey: ConveyString,
+		},
+	}
+}
+
+// ConveyFields holds the canonical properties of a Convey.  The zero value of each field means that the
+// device did not send the property, or sent a value that could not be interpreted.
+type ConveyFields struct {
+	// SchemaVersion is the version of the convey schema the device sent, which may be newer than
+	// CurrentConveySchemaVersion.  Newer conveys are decoded with the current schema.
+	SchemaVersion int
+
+	FirmwareVersion     string
+	Model               string
+	Manufacturer        string
+	SerialNumber        string
+	LastRebootReason    string
+	BootTime            time.Time
+	Protocol            string
+	InterfaceUsed       string
+	LastReconnectReason string
+}
+
+// Convey produces the Convey map for these fields, e.g. for a Dialer.  Empty fields are omitted.
+func (cf ConveyFields) Convey() Convey {
+	convey := make(Convey, 10)
+	if cf.SchemaVersion > 0 {
+		convey[ConveySchemaVersionKey] = cf.SchemaVersion
+	}
+
+	for key, value := range map[string]string{
+		ConveyFirmwareVersionKey:     cf.FirmwareVersion,
+		ConveyModelKey:               cf.Model,
+		ConveyManufacturerKey:        cf.Manufacturer,
+		ConveySerialNumberKey:        cf.SerialNumber,
+		ConveyLastRebootReasonKey:    cf.LastRebootReason,
+		ConveyProtocolKey:            cf.Protocol,
+		ConveyInterfaceUsedKey:       cf.InterfaceUsed,
+		ConveyLastReconnectReasonKey: cf.LastReconnectReason,
+	} {
+		if len(value) > 0 {
+			convey[key] = value
+		}
+	}
+
+	if !cf.BootTime.IsZero() {
+		convey[ConveyBootTimeKey] = cf.BootTime.Unix()
+	}
+
+	return convey
+}
+
+// Fields decodes the canonical properties of this Convey.  Decoding is lenient: strings are accepted
+// for numeric properties and vice versa, and a property whose value cannot be interpreted is left empty.
+func (c Convey) Fields() ConveyFields {
+	return ConveyFields{
+		SchemaVersion:       c.SchemaVersion(),
+		FirmwareVersion:     c.FirmwareVersion(),
+		Model:               c.Model(),
+		Manufacturer:        c.Manufacturer(),
+		SerialNumber:        c.SerialNumber(),
+		LastRebootReason:    c.LastRebootReason(),
+		BootTime:            c.BootTime(),
+		Protocol:            c.Protocol(),
+		InterfaceUsed:       c.InterfaceUsed(),
+		LastReconnectReason: c.LastReconnectReason(),
+	}
+}
+
+// SchemaVersion returns the convey schema version, which is 1 if the device did not send one
+func (c Convey) SchemaVersion() int {
+	if version, ok := conveyInt(c[ConveySchemaVersionKey]); ok && version > 0 && version <= math.MaxInt32 {
+		return int(version)
+	}
+
+	return 1
+}
+
+func (c Convey) FirmwareVersion() string     { return conveyString(c[ConveyFirmwareVersionKey]) }
+func (c Convey) Model() string               { return conveyString(c[ConveyModelKey]) }
+func (c Convey) Manufacturer() string        { return conveyString(c[ConveyManufacturerKey]) }
+func (c Convey) SerialNumber() string        { return conveyString(c[ConveySerialNumberKey]) }
+func (c Convey) LastRebootReason() string    { return conveyString(c[ConveyLastRebootReasonKey]) }
+func (c Convey) Protocol() string            { return conveyString(c[ConveyProtocolKey]) }
+func (c Convey) InterfaceUsed() string       { return conveyString(c[ConveyInterfaceUsedKey]) }
+func (c Convey) LastReconnectReason() string { return conveyString(c[ConveyLastReconnectReasonKey]) }
+
+// BootTime returns the time the device last booted.  Devices send this as seconds since the epoch, but
+// RFC 3339 timestamps are also accepted.  The zero time is returned if the boot time is absent or invalid.
+func (c Convey) BootTime() time.Time {
+	value := c[ConveyBootTimeKey]
+	if seconds, ok := conveyInt(value); ok && seconds > 0 {
+		return time.Unix(seconds, 0).UTC()
+	}
+
+	if text, ok := value.(string); ok {
+		if parsed, err := time.Parse(time.RFC3339, text); err == nil {
+			return parsed
+		}
+	}
+
+	return time.Time{}
+}
+
+// conveyString leniently converts a decoded convey value into a string.  Numbers and booleans are
+// formatted, while objects, arrays, and null produce the empty string.
+func conveyString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	if i, ok := conveyInt(value); ok {
+		return strconv.FormatInt(i, 10)
+	}
+
+	return ""
+}
+
+// conveyInt leniently converts a decoded convey value into an integer.  Integral floating point values
+// and numeric strings are accepted.
+func conveyInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return conveyUint(uint64(v))
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return conveyUint(v)
+	case float32:
+		return conveyFloat(float64(v))
+	case float64:
+		return conveyFloat(v)
+	case json.Number:
+		return conveyInt(string(v))
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i, true
+		}
+
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return conveyFloat(f)
+		}
+	}
+
+	return 0, false
+}
+
+func conveyUint(v uint64) (int64, bool) {
+	if v > math.MaxInt64 {
+		return 0, false
+	}
+
+	return int64(v), true
+}
+
+func conveyFloat(v float64) (int64, bool) {
+	if v != math.Trunc(v) || v > math.MaxInt64 || v < math.MinInt64 {
+		return 0, false
+	}
+
+	return int64(v), true
+}
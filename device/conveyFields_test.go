@@ -0,0 +1,109 @@
+package device
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestConveyFields(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		bootTime = time.Unix(1500000000, 0).UTC()
+		expected = ConveyFields{
+			SchemaVersion:       1,
+			FirmwareVersion:     "TG1682_2.1p7s1_PROD_sey",
+			Model:               "TG1682G",
+			Manufacturer:        "ARRIS Group, Inc.",
+			SerialNumber:        "123456789",
+			LastRebootReason:    "unknown",
+			BootTime:            bootTime,
+			Protocol:            "PARODUS-2.0",
+			InterfaceUsed:       "erouter0",
+			LastReconnectReason: "webpa_process_starts",
+		}
+	)
+
+	// round trip through the on-the-wire form, which is how devices send conveys
+	encoded, err := EncodeConvey(expected.Convey(), nil)
+	require.NoError(err)
+	convey, err := ParseConvey(encoded, nil)
+	require.NoError(err)
+
+	assert.Equal(expected, convey.Fields())
+	assert.Equal("TG1682_2.1p7s1_PROD_sey", convey.FirmwareVersion())
+	assert.Equal("TG1682G", convey.Model())
+	assert.Equal(bootTime, convey.BootTime())
+	assert.NoError(CanonicalConveySchema().Validate(convey))
+
+	// empty fields are omitted
+	assert.Equal(Convey{ConveyModelKey: "TG1682G"}, ConveyFields{Model: "TG1682G"}.Convey())
+}
+
+func TestConveyFieldsEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, convey := range []Convey{nil, {}} {
+		assert.Equal(ConveyFields{SchemaVersion: 1}, convey.Fields())
+		assert.Empty(convey.FirmwareVersion())
+		assert.True(convey.BootTime().IsZero())
+	}
+}
+
+func TestConveyFieldsLenient(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		convey  Convey
+	)
+
+	require.NoError(json.Unmarshal(
+		[]byte(`{
+			"schema-version": "3",
+			"fw-name": 2.5,
+			"hw-model": 1234,
+			"hw-manufacturer": true,
+			"hw-serial-number": {"nested": "object"},
+			"hw-last-reboot-reason": null,
+			"boot-time": "1500000000"
+		}`),
+		&convey,
+	))
+
+	fields := convey.Fields()
+	assert.Equal(3, fields.SchemaVersion)
+	assert.Equal("2.5", fields.FirmwareVersion)
+	assert.Equal("1234", fields.Model)
+	assert.Equal("true", fields.Manufacturer)
+	assert.Empty(fields.SerialNumber)
+	assert.Empty(fields.LastRebootReason)
+	assert.Equal(time.Unix(1500000000, 0).UTC(), fields.BootTime)
+
+	testData := []struct {
+		value    interface{}
+		expected time.Time
+	}{
+		{int64(1500000000), time.Unix(1500000000, 0).UTC()},
+		{uint32(1500000000), time.Unix(1500000000, 0).UTC()},
+		{float64(1500000000), time.Unix(1500000000, 0).UTC()},
+		{json.Number("1500000000"), time.Unix(1500000000, 0).UTC()},
+		{"2017-07-14T02:40:00Z", time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)},
+		{1500000000.5, time.Time{}},
+		{uint64(1) << 63, time.Time{}},
+		{-5, time.Time{}},
+		{"yesterday", time.Time{}},
+		{[]interface{}{1}, time.Time{}},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		assert.Equal(record.expected, Convey{ConveyBootTimeKey: record.value}.BootTime())
+	}
+
+	for _, invalid := range []interface{}{0, -1, "x", 1.5} {
+		assert.Equal(1, Convey{ConveySchemaVersionKey: invalid}.SchemaVersion())
+	}
+}
@@ -3,8 +3,15 @@ package device
 import (
 	"encoding/base64"
 	"encoding/json"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -74,3 +81,197 @@ func TestConvey(t *testing.T) {
 		}
 	}
 }
+
+func TestCompressedConvey(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, record := range conveyTestData {
+		t.Logf("%v", record)
+
+		for _, conveyEncoding := range conveyEncodings {
+			t.Logf("%v", conveyEncoding)
+
+			encoded, err := EncodeCompressedConvey(record.convey, conveyEncoding.encoding)
+			if !assert.Nil(err) {
+				continue
+			}
+
+			t.Logf("encoded: %s", encoded)
+			assert.True(strings.HasPrefix(encoded, GzipConveyPrefix))
+			actualConvey, err := ParseConvey(encoded, conveyEncoding.encoding)
+			if !assert.Nil(err) {
+				continue
+			}
+
+			actualJSON, err := json.Marshal(actualConvey)
+			if !assert.Nil(err) {
+				continue
+			}
+
+			assert.JSONEq(record.expectedJSON, string(actualJSON))
+		}
+	}
+}
+
+func TestParseCompressedConveyInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, value := range []string{
+		GzipConveyPrefix + "this is not valid",
+		GzipConveyPrefix + base64.StdEncoding.EncodeToString([]byte("this is not gzip")),
+	} {
+		convey, err := ParseConvey(value, nil)
+		assert.Empty(convey)
+		assert.Error(err)
+	}
+}
+
+func TestDialerEncodeConvey(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		large  = Convey{"payload": strings.Repeat("abcdefgh", 100)}
+		small  = Convey{"foo": "bar"}
+	)
+
+	plain, err := (&dialer{}).encodeConvey(large)
+	assert.NoError(err)
+	assert.False(strings.HasPrefix(plain, GzipConveyPrefix))
+
+	compressed, err := (&dialer{conveyCompressionThreshold: 100}).encodeConvey(large)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(compressed, GzipConveyPrefix))
+	assert.True(len(compressed) < len(plain))
+
+	uncompressed, err := (&dialer{conveyCompressionThreshold: 100}).encodeConvey(small)
+	assert.NoError(err)
+	assert.False(strings.HasPrefix(uncompressed, GzipConveyPrefix))
+
+	actual, err := ParseConvey(compressed, nil)
+	assert.NoError(err)
+	assert.Equal(large["payload"], actual["payload"])
+}
+
+func testManagerParseConvey(t *testing.T, policy ConveyPolicy) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		valid, _ = EncodeConvey(Convey{"foo": "bar"}, nil)
+		large, _ = EncodeConvey(Convey{"payload": strings.Repeat("abcdefgh", 100)}, nil)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	m := NewManager(&Options{
+		Logger:        logging.TestLogger(t),
+		Metrics:       registry,
+		MaxConveySize: 100,
+		ConveyPolicy:  policy,
+	}, nil).(*manager)
+
+	convey, raw, err := m.parseConvey(valid)
+	assert.Equal(Convey{"foo": "bar"}, convey)
+	assert.Equal(valid, raw)
+	assert.NoError(err)
+
+	convey, raw, err = m.parseConvey(large)
+	assert.Nil(convey)
+	assert.Empty(raw)
+	assert.Equal(ErrorConveyTooLarge, err)
+
+	convey, raw, err = m.parseConvey("this is not valid")
+	assert.Nil(convey)
+	assert.Error(err)
+	if policy == RawInvalidConvey {
+		assert.Equal("this is not valid", raw)
+	} else {
+		assert.Empty(raw)
+	}
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.Contains(body, ConveyFailureCount+`{action="`+string(policy)+`",class="`+ConveyClassInvalid+`"} 1`)
+	assert.Contains(body, ConveyFailureCount+`{action="`+string(policy)+`",class="`+ConveyClassTooLarge+`"} 1`)
+}
+
+func testManagerConnectInvalidConvey(t *testing.T, policy ConveyPolicy) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{}, 1)
+
+		options = &Options{
+			Logger:       logging.TestLogger(t),
+			ConveyPolicy: policy,
+			Listeners: []Listener{
+				func(e *Event) {
+					switch e.Type {
+					case Connect:
+						connected <- e.Device
+					case Disconnect:
+						disconnected <- struct{}{}
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	dialer := NewDialer(options, nil)
+	connection, _, err := dialer.Dial(
+		connectURL,
+		"mac:112233445566",
+		nil,
+		http.Header{DefaultConveyHeader: []string{"this is not valid"}},
+	)
+
+	require.NoError(err)
+
+	var d Interface
+	select {
+	case d = <-connected:
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not connect")
+	}
+
+	assert.Nil(d.Convey())
+	assert.Error(d.ConveyError())
+
+	var marshaled map[string]interface{}
+	require.NoError(json.Unmarshal([]byte(d.String()), &marshaled))
+	assert.Equal(d.ConveyError().Error(), marshaled["conveyError"])
+	if policy == RawInvalidConvey {
+		assert.Equal("this is not valid", d.RawConvey())
+		assert.Equal("this is not valid", marshaled["convey"])
+	} else {
+		assert.Empty(d.RawConvey())
+		assert.Nil(marshaled["convey"])
+	}
+
+	connection.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		assert.Fail("The device did not disconnect")
+	}
+}
+
+func TestConveyPolicy(t *testing.T) {
+	t.Run("ParseConvey", func(t *testing.T) {
+		for _, policy := range []ConveyPolicy{RejectInvalidConvey, FlagInvalidConvey, RawInvalidConvey} {
+			t.Run(string(policy), func(t *testing.T) { testManagerParseConvey(t, policy) })
+		}
+	})
+
+	t.Run("Connect", func(t *testing.T) {
+		t.Run("Flag", func(t *testing.T) { testManagerConnectInvalidConvey(t, FlagInvalidConvey) })
+		t.Run("Raw", func(t *testing.T) { testManagerConnectInvalidConvey(t, RawInvalidConvey) })
+	})
+}
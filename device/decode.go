@@ -0,0 +1,96 @@
+package device
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DecodeErrorEmpty classifies frames that had no content at all
+	DecodeErrorEmpty = "empty"
+
+	// DecodeErrorTruncated classifies frames that ended before a complete WRP message was read
+	DecodeErrorTruncated = "truncated"
+
+	// DecodeErrorMalformed classifies frames that were not valid WRP messages
+	DecodeErrorMalformed = "malformed"
+
+	// DecodeFailureCloseReason is the text of the websocket close frame sent to quarantined devices
+	DecodeFailureCloseReason = "decode failure"
+
+	// decodeFailureSamples is the number of recent decode failures retained for each device
+	decodeFailureSamples = 5
+
+	// decodeFailureSampleSize is the maximum number of bytes retained from each offending frame
+	decodeFailureSampleSize = 256
+)
+
+// DecodeFailure describes a single frame from a device that could not be decoded
+type DecodeFailure struct {
+	// Time is when the frame was read
+	Time time.Time
+
+	// Class is one of the DecodeError* constants
+	Class string
+
+	// Error is the text of the decoding error
+	Error string
+
+	// Sample holds the leading bytes of the offending frame
+	Sample []byte
+}
+
+// classifyDecodeError determines the DecodeError* class of a failure to decode the given frame
+func classifyDecodeError(frame []byte, err error) string {
+	switch {
+	case len(frame) == 0:
+		return DecodeErrorEmpty
+	case err == io.EOF || err == io.ErrUnexpectedEOF || strings.Contains(err.Error(), "EOF"):
+		return DecodeErrorTruncated
+	default:
+		return DecodeErrorMalformed
+	}
+}
+
+// decodeFailures tracks the decode failures for a single device.  Instances are safe for concurrent use.
+type decodeFailures struct {
+	lock    sync.Mutex
+	count   int
+	samples []DecodeFailure
+}
+
+// record notes a decode failure, returning its class and the total count of failures so far
+func (df *decodeFailures) record(now time.Time, frame []byte, err error) (string, int) {
+	sample := frame
+	if len(sample) > decodeFailureSampleSize {
+		sample = sample[:decodeFailureSampleSize]
+	}
+
+	failure := DecodeFailure{
+		Time:   now,
+		Class:  classifyDecodeError(frame, err),
+		Error:  err.Error(),
+		Sample: append([]byte(nil), sample...),
+	}
+
+	df.lock.Lock()
+	defer df.lock.Unlock()
+
+	df.count++
+	if len(df.samples) >= decodeFailureSamples {
+		copy(df.samples, df.samples[1:])
+		df.samples = df.samples[:len(df.samples)-1]
+	}
+
+	df.samples = append(df.samples, failure)
+	return failure.Class, df.count
+}
+
+// snapshot returns the total count of failures and a copy of the recent samples, oldest first
+func (df *decodeFailures) snapshot() (int, []DecodeFailure) {
+	df.lock.Lock()
+	defer df.lock.Unlock()
+	return df.count, append([]DecodeFailure(nil), df.samples...)
+}
@@ -0,0 +1,155 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyDecodeError(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		truncated []byte
+	)
+
+	require.NoError(t, wrp.NewEncoderBytes(&truncated, wrp.Msgpack).Encode(&wrp.SimpleEvent{Destination: "mac:112233445566"}))
+	truncated = truncated[:len(truncated)/2]
+	truncatedError := wrp.NewDecoderBytes(truncated, wrp.Msgpack).Decode(new(wrp.Message))
+	require.Error(t, truncatedError)
+
+	testData := []struct {
+		frame    []byte
+		err      error
+		expected string
+	}{
+		{nil, errors.New("empty"), DecodeErrorEmpty},
+		{[]byte{}, io.EOF, DecodeErrorEmpty},
+		{[]byte("x"), io.EOF, DecodeErrorTruncated},
+		{[]byte("x"), io.ErrUnexpectedEOF, DecodeErrorTruncated},
+		{truncated, truncatedError, DecodeErrorTruncated},
+		{[]byte("x"), errors.New("something else"), DecodeErrorMalformed},
+	}
+
+	for i, record := range testData {
+		assert.Equal(record.expected, classifyDecodeError(record.frame, record.err), fmt.Sprintf("#%d", i))
+	}
+}
+
+func TestDecodeFailures(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		now      = time.Now()
+		failures decodeFailures
+	)
+
+	count, samples := failures.snapshot()
+	assert.Equal(0, count)
+	assert.Empty(samples)
+
+	large := make([]byte, 2*decodeFailureSampleSize)
+	class, count := failures.record(now, large, errors.New("expected"))
+	assert.Equal(DecodeErrorMalformed, class)
+	assert.Equal(1, count)
+
+	count, samples = failures.snapshot()
+	assert.Equal(1, count)
+	if assert.Len(samples, 1) {
+		assert.Equal(now, samples[0].Time)
+		assert.Equal("expected", samples[0].Error)
+		assert.Len(samples[0].Sample, decodeFailureSampleSize)
+	}
+
+	for i := 0; i < 2*decodeFailureSamples; i++ {
+		failures.record(now.Add(time.Duration(i+1)*time.Second), []byte{byte(i)}, errors.New("expected"))
+	}
+
+	count, samples = failures.snapshot()
+	assert.Equal(2*decodeFailureSamples+1, count)
+	if assert.Len(samples, decodeFailureSamples) {
+		assert.Equal([]byte{byte(decodeFailureSamples)}, samples[0].Sample)
+		assert.Equal([]byte{byte(2*decodeFailureSamples - 1)}, samples[decodeFailureSamples-1].Sample)
+	}
+}
+
+func TestManagerDecodeFailureQuarantine(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan *Event, 1)
+		options      = &Options{
+			Logger:                 logging.TestLogger(t),
+			Metrics:                registry,
+			DecodeFailureThreshold: 2,
+			InstanceID:             "east/http://talaria-1:8080",
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						copied := *event
+						disconnected <- &copied
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	defer c.Close()
+	d := <-connected
+
+	for _, frame := range []string{"", "this is not msgpack"} {
+		_, err := c.Write([]byte(frame))
+		require.NoError(err)
+	}
+
+	for {
+		if _, err = c.NextReader(); err != nil {
+			break
+		}
+	}
+
+	assert.True(websocket.IsCloseError(err, websocket.CloseInvalidFramePayloadData), err.Error())
+	if closeError, ok := err.(*websocket.CloseError); assert.True(ok) {
+		assert.Equal(DecodeFailureCloseReason+"; instance=east/http://talaria-1:8080", closeError.Text)
+	}
+
+	event := <-disconnected
+	assert.Equal(ErrorDecodeFailure, event.Error)
+
+	count, samples := d.DecodeFailures()
+	assert.Equal(2, count)
+	if assert.Len(samples, 2) {
+		assert.Equal(DecodeErrorEmpty, samples[0].Class)
+		assert.Equal([]byte("this is not msgpack"), samples[1].Sample)
+	}
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, DecodeErrorCount+`{class="empty"} 1`), body)
+}
@@ -2,7 +2,12 @@ package device
 
 import (
 	"bytes"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/ugorji/go/codec"
 	"sync/atomic"
 	"time"
 )
@@ -20,8 +25,14 @@ var (
 // The write pump goroutine will use the complete channel to communicate the result
 // of the write operation.
 type envelope struct {
-	request  *Request
-	complete chan<- error
+	request    *Request
+	complete   chan<- error
+	enqueuedAt time.Time
+
+	// ackID is the delivery identifier of a request that must be acknowledged, and attempts is the
+	// count of times the request has been written while awaiting that acknowledgement
+	ackID    string
+	attempts int
 }
 
 // Interface is the core type for this package.  It provides
@@ -56,15 +67,70 @@ type Interface interface {
 	// Key returns the current unique key for this device.
 	Key() Key
 
-	// Convey returns the payload to convey with each web-bound request
+	// Convey returns the payload to convey with each web-bound request.  The canonical properties of
+	// the payload are available from its typed accessors, e.g. Convey().FirmwareVersion().
 	Convey() Convey
 
-	// ConnectedAt returns the time at which this device connected to the system
+	// RawConvey returns the convey value exactly as this device sent it when connecting.  This is
+	// empty if the device sent no convey, or if the value was discarded under the ConveyPolicy.
+	RawConvey() string
+
+	// ConveyError returns the reason this device's convey value was not accepted, or nil if the
+	// convey was valid.  This is only set for devices accepted under FlagInvalidConvey or RawInvalidConvey.
+	ConveyError() error
+
+	// ConnectedAt returns the time at which this device connected to the system.  This is a wall clock
+	// time, so durations should be obtained via ConnectionDuration instead.
 	ConnectedAt() time.Time
 
+	// ConnectionDuration returns how long this device has been connected, measured with the monotonic
+	// clock so that it is unaffected by adjustments to the system time.  Once a device is closed, this
+	// is the duration from connection until the close was requested.
+	ConnectionDuration() time.Duration
+
+	// Format returns the WRP encoding negotiated with this device when it connected
+	Format() wrp.Format
+
+	// Logger returns the logger for this device, which attaches the device's ID, key, and remote address to
+	// every entry.  The Manager writes all logs about this device through this logger, and listeners can do
+	// the same, so that the entries for a single device can be found among those of every other device.
+	Logger() logging.Logger
+
+	// Protocol returns the websocket subprotocol negotiated with this device when it connected, such as
+	// the Name of one of the Manager's Protocols.  Devices that negotiated no subprotocol return the empty string.
+	Protocol() string
+
 	// Pending returns the count of pending messages for this device
 	Pending() int
 
+	// MaxQueueLatency returns the longest time any recent message spent queued for this device
+	// before being written to its websocket
+	MaxQueueLatency() time.Duration
+
+	// DecodeFailures returns the total count of frames from this device that could not be decoded,
+	// along with samples of the most recent such frames, oldest first
+	DecodeFailures() (int, []DecodeFailure)
+
+	// PendingTransactions returns the transactions sent to this device that are still awaiting
+	// a response, oldest first
+	PendingTransactions() []PendingTransaction
+
+	// Statistics returns a snapshot of the traffic counters for this device's connection
+	Statistics() Statistics
+
+	// Tags returns the tags the Manager attached to this device when it connected, from its ConnectAuthorizer
+	// and ConveyTags.  The returned map must not be modified.
+	Tags() Tags
+
+	// Certificate returns the TLS client certificate this device presented when it connected, or nil if the
+	// device did not present a certificate that the server verified.  The returned certificate must not be modified.
+	Certificate() *x509.Certificate
+
+	// SessionID returns the identifier of the session this device belongs to.  Successive connections
+	// that resume the same session, using the token issued by the Manager, share the same SessionID.
+	// This is empty if the Manager does not issue sessions.
+	SessionID() string
+
 	// RequestClose posts a request for this device to be disconnected.  This method
 	// is asynchronous and idempotent.
 	RequestClose()
@@ -94,24 +160,78 @@ type device struct {
 	key atomic.Value
 
 	convey      Convey
+	rawConvey   string
+	conveyError error
+
+	// tags are assigned by the ConnectAuthorizer when this device is admitted
+	tags Tags
+
+	// certificates is the verified chain of the device's TLS client certificate, leaf first
+	certificates []*x509.Certificate
+
+	// connectedAt retains the monotonic clock reading taken at connection time.  It must never
+	// be replaced by a value with the reading stripped, e.g. via UTC() or Round(0).
 	connectedAt time.Time
+	format      wrp.Format
+	protocol    string
 
-	state int32
+	// logger is the device's child of the manager's logger
+	logger logging.Logger
+
+	// batches indicates that the write pump coalesces queued messages into batch envelopes for this device
+	batches bool
+
+	state    int32
+	degraded int32
+
+	// closedAfter is the connection duration, in nanoseconds, at the time the close was requested
+	closedAfter int64
 
 	shutdown     chan struct{}
-	messages     chan *envelope
+	messages     messageQueue
 	transactions *Transactions
+	idempotency  *idempotencyCache
+	queueLatency recentMax
+	decodeErrors decodeFailures
+	statistics   statistics
+
+	// metrics are the metrics of the manager that owns this device, or nil if there is no manager
+	metrics *managerMetrics
+
+	// fullQueuePolicy is the manager's QueuePolicy, which applies to requests that do not set their own
+	fullQueuePolicy QueuePolicy
+
+	// transactionTracer is the manager's hook around each Send, or nil if there is no manager
+	transactionTracer TransactionTracer
+
+	// session is shared with any other connections that resume the same session, or nil if sessions are disabled
+	session *session
+
+	// acks holds the messages written to this device that are awaiting acknowledgement
+	acks acknowledgements
 }
 
+// newDevice creates a device whose priority classes all have the same queue size
 func newDevice(id ID, initialKey Key, convey Convey, queueSize int) *device {
+	var sizes [priorityClasses]int
+	for p := range sizes {
+		sizes[p] = queueSize
+	}
+
+	return newDeviceWithQueue(id, initialKey, convey, newMessageQueue(sizes))
+}
+
+func newDeviceWithQueue(id ID, initialKey Key, convey Convey, messages messageQueue) *device {
 	d := &device{
 		id:           id,
 		convey:       convey,
 		connectedAt:  time.Now(),
 		state:        stateOpen,
 		shutdown:     make(chan struct{}),
-		messages:     make(chan *envelope, queueSize),
+		messages:     messages,
 		transactions: NewTransactions(),
+		queueLatency: recentMax{window: queueLatencyWindow},
+		logger:       logging.DefaultLogger(),
 	}
 
 	d.updateKey(initialKey)
@@ -123,27 +243,53 @@ func newDevice(id ID, initialKey Key, convey Convey, queueSize int) *device {
 func (d *device) MarshalJSON() ([]byte, error) {
 	conveyJSON := nullConvey
 	if d.convey != nil {
-		if decoded, conveyError := EncodeConvey(d.convey, nil); conveyError != nil {
+		var encoded []byte
+		if err := codec.NewEncoderBytes(&encoded, conveyHandle).Encode(d.convey); err == nil {
+			conveyJSON = encoded
+		} else {
 			// just dump the error text into the convey property,
 			// so at least it can be viewed
-			conveyJSON = []byte(fmt.Sprintf(`"%s"`, decoded))
+			conveyJSON = jsonString(err.Error())
 		}
+	} else if len(d.rawConvey) > 0 {
+		// an unparseable convey is reported as the raw string
+		conveyJSON = jsonString(d.rawConvey)
 	}
 
 	output := new(bytes.Buffer)
 	fmt.Fprintf(
 		output,
-		`{"id": "%s", "key": "%s", "connectedAt": "%s", "closed": %t, "convey": %s}`,
+		`{"id": "%s", "key": "%s", "connectedAt": "%s", "connectionDuration": "%s", "closed": %t, "convey": %s`,
 		d.id,
 		d.Key(),
 		d.connectedAt.Format(time.RFC3339),
+		d.ConnectionDuration().Truncate(time.Second),
 		d.Closed(),
 		conveyJSON,
 	)
 
+	if d.conveyError != nil {
+		fmt.Fprintf(output, `, "conveyError": %s`, jsonString(d.conveyError.Error()))
+	}
+
+	if len(d.protocol) > 0 {
+		fmt.Fprintf(output, `, "protocol": %s`, jsonString(d.protocol))
+	}
+
+	if d.session != nil {
+		fmt.Fprintf(output, `, "sessionId": "%s"`, d.session.id)
+	}
+
+	output.WriteString("}")
 	return output.Bytes(), nil
 }
 
+// jsonString produces the JSON string literal for an arbitrary value
+func jsonString(value string) []byte {
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
+
 // String returns the JSON representation of this device
 func (d *device) String() string {
 	data, _ := d.MarshalJSON()
@@ -151,9 +297,18 @@ func (d *device) String() string {
 }
 
 func (d *device) RequestClose() {
+	d.requestClose()
+}
+
+// requestClose closes this device if it is open, returning true if this call closed it
+func (d *device) requestClose() bool {
 	if atomic.CompareAndSwapInt32(&d.state, stateOpen, stateClosed) {
+		atomic.StoreInt64(&d.closedAfter, int64(time.Since(d.connectedAt)))
 		close(d.shutdown)
+		return true
 	}
+
+	return false
 }
 
 func (d *device) ID() ID {
@@ -172,40 +327,165 @@ func (d *device) Convey() Convey {
 	return d.convey
 }
 
+func (d *device) RawConvey() string {
+	return d.rawConvey
+}
+
+func (d *device) ConveyError() error {
+	return d.conveyError
+}
+
 func (d *device) ConnectedAt() time.Time {
 	return d.connectedAt
 }
 
+func (d *device) ConnectionDuration() time.Duration {
+	if d.Closed() {
+		// the duration may not be stored yet if the close was requested concurrently
+		if closedAfter := atomic.LoadInt64(&d.closedAfter); closedAfter > 0 {
+			return time.Duration(closedAfter)
+		}
+	}
+
+	return time.Since(d.connectedAt)
+}
+
+func (d *device) Format() wrp.Format {
+	return d.format
+}
+
+func (d *device) Logger() logging.Logger {
+	return d.logger
+}
+
+func (d *device) Protocol() string {
+	return d.protocol
+}
+
 func (d *device) Pending() int {
-	return len(d.messages)
+	return d.messages.len()
+}
+
+func (d *device) MaxQueueLatency() time.Duration {
+	return d.queueLatency.value(time.Now())
+}
+
+func (d *device) DecodeFailures() (int, []DecodeFailure) {
+	return d.decodeErrors.snapshot()
+}
+
+func (d *device) PendingTransactions() []PendingTransaction {
+	return d.transactions.Pending()
+}
+
+func (d *device) Statistics() Statistics {
+	return d.statistics.snapshot()
+}
+
+func (d *device) Tags() Tags {
+	return d.tags
+}
+
+func (d *device) Certificate() *x509.Certificate {
+	if len(d.certificates) > 0 {
+		return d.certificates[0]
+	}
+
+	return nil
+}
+
+func (d *device) SessionID() string {
+	if d.session != nil {
+		return d.session.id
+	}
+
+	return ""
+}
+
+// abandoned returns a channel that is closed once requests sent to this device can no longer complete.
+// When this device belongs to a session, requests outlive the connection until the session expires.
+func (d *device) abandoned() <-chan struct{} {
+	if d.session != nil {
+		return d.session.ended
+	}
+
+	return d.shutdown
 }
 
 func (d *device) Closed() bool {
 	return atomic.LoadInt32(&d.state) != stateOpen
 }
 
+// setDegraded updates whether this device is a degraded slow consumer, returning true
+// if the value changed
+func (d *device) setDegraded(degraded bool) bool {
+	var newValue int32
+	if degraded {
+		newValue = 1
+	}
+
+	return atomic.SwapInt32(&d.degraded, newValue) != newValue
+}
+
+// queueChanged records a change in the number of messages queued for this device
+func (d *device) queueChanged(delta int) {
+	if d.metrics != nil {
+		d.metrics.queueChanged(delta)
+	}
+}
+
+// drainQueue removes every envelope queued for this device without blocking, highest priority first
+func (d *device) drainQueue() (drained []*envelope) {
+	for e := d.messages.poll(); e != nil; e = d.messages.poll() {
+		d.queueChanged(-1)
+		drained = append(drained, e)
+	}
+
+	return
+}
+
+// isDegraded tests whether this device is currently rejecting low-priority messages
+func (d *device) isDegraded() bool {
+	return atomic.LoadInt32(&d.degraded) != 0
+}
+
 // sendRequest attempts to enqueue the given request for the write pump that is
 // servicing this device.  This method honors the request context's cancellation semantics.
+// If the request's queue is full, the request's QueuePolicy determines whether this method waits.
 //
 // This function returns when either (1) the write pump has attempted to send the message to
-// the device, or (2) the request's context has been cancelled, which includes timing out.
+// the device, or (2) the request's context has been cancelled, which includes timing out.  A request
+// that must be acknowledged is not complete until the device acknowledges it or its retries are exhausted.
 func (d *device) sendRequest(request *Request) error {
+	if d.isDegraded() && len(request.Message.TransactionKey()) == 0 {
+		return ErrorSlowConsumer
+	}
+
 	var (
 		done     = request.Context().Done()
 		complete = make(chan error, 1)
 		envelope = &envelope{
-			request,
-			complete,
+			request:    request,
+			complete:   complete,
+			enqueuedAt: time.Now(),
 		}
 	)
 
+	if request.Acknowledge {
+		// the delivery identifier can only be carried in the metadata of a *wrp.Message
+		if _, ok := request.Message.(*wrp.Message); !ok {
+			return ErrorAckUnsupported
+		}
+
+		var err error
+		if envelope.ackID, err = randomString(ackIDSize); err != nil {
+			return err
+		}
+	}
+
 	// attempt to enqueue the message
-	select {
-	case <-done:
-		return request.Context().Err()
-	case <-d.shutdown:
-		return ErrorDeviceClosed
-	case d.messages <- envelope:
+	if err := d.enqueue(envelope); err != nil {
+		return err
 	}
 
 	// once enqueued, wait until the context is cancelled
@@ -213,7 +493,7 @@ func (d *device) sendRequest(request *Request) error {
 	select {
 	case <-done:
 		return request.Context().Err()
-	case <-d.shutdown:
+	case <-d.abandoned():
 		return ErrorDeviceClosed
 	case err := <-complete:
 		return err
@@ -221,36 +501,76 @@ func (d *device) sendRequest(request *Request) error {
 }
 
 // awaitResponse waits for the read pump to acquire a response that corresponds to the
-// request's transaction key.  The result channel will receive the response from the
-// read pump.
-func (d *device) awaitResponse(request *Request, result <-chan *Response) (*Response, error) {
+// request's transaction key.  The pending transaction's result channel will receive the
+// response from the read pump, unless the transaction expires first.
+func (d *device) awaitResponse(request *Request, pending *pendingTransaction) (*Response, error) {
 	select {
 	case <-request.Context().Done():
 		return nil, request.Context().Err()
-	case <-d.shutdown:
+	case <-d.abandoned():
 		return nil, ErrorDeviceClosed
-	case response := <-result:
-		if response == nil {
-			return nil, ErrorTransactionCancelled
+	case <-pending.expired:
+		return nil, ErrorTransactionTimeout
+	case response := <-pending.result:
+		if response != nil {
+			return response, nil
 		}
 
-		return response, nil
+		// an expired transaction's result is closed after its expired channel
+		select {
+		case <-pending.expired:
+			return nil, ErrorTransactionTimeout
+		default:
+			return nil, ErrorTransactionCancelled
+		}
 	}
 }
 
-func (d *device) Send(request *Request) (*Response, error) {
+func (d *device) Send(request *Request) (response *Response, err error) {
 	if d.Closed() {
 		return nil, ErrorDeviceClosed
 	}
 
+	if d.transactionTracer != nil {
+		ctx := d.transactionTracer.StartTransaction(request.Context(), d, request)
+		request = request.withContext(ctx)
+		defer func() { d.transactionTracer.FinishTransaction(ctx, d, request, response, err) }()
+	}
+
+	if len(request.IdempotencyKey) == 0 || d.idempotency == nil {
+		return d.send(request)
+	}
+
+	outcome, owner, err := d.idempotency.acquire(request.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	} else if owner {
+		response, err = d.send(request)
+		d.idempotency.complete(outcome, response, err)
+		return response, err
+	}
+
+	// a request with this key has already been sent, or is currently in flight
+	select {
+	case <-request.Context().Done():
+		return nil, request.Context().Err()
+	case <-d.abandoned():
+		return nil, ErrorDeviceClosed
+	case <-outcome.done:
+		return outcome.response, outcome.err
+	}
+}
+
+// send performs the actual work of Send, without regard to idempotency
+func (d *device) send(request *Request) (*Response, error) {
 	var (
 		transactionKey = request.Message.TransactionKey()
-		result         <-chan *Response
+		pending        *pendingTransaction
 	)
 
 	if len(transactionKey) > 0 {
 		var err error
-		if result, err = d.transactions.Register(transactionKey); err != nil {
+		if pending, err = d.transactions.register(transactionKey, d.transactions.ttl); err != nil {
 			// if a transaction key cannot be registered, we don't want to proceed.
 			// this indicates some larger problem, most often a duplicate transaction key.
 			return nil, err
@@ -264,10 +584,22 @@ func (d *device) Send(request *Request) (*Response, error) {
 		return nil, err
 	}
 
-	if result == nil {
+	if pending == nil {
 		// if there is no pending transaction, we're done
 		return nil, nil
 	}
 
-	return d.awaitResponse(request, result)
+	response, err := d.awaitResponse(request, pending)
+	if err == nil {
+		if d.metrics != nil {
+			d.metrics.transactionCompleted(time.Since(pending.registeredAt))
+		}
+
+		// a device that does not propagate trace context still answers within the request's trace
+		if len(response.TraceID) == 0 {
+			response.TraceID, response.SpanID = request.TraceID(), request.SpanID()
+		}
+	}
+
+	return response, err
 }
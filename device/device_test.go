@@ -3,6 +3,8 @@ package device
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -120,3 +122,123 @@ func TestDevice(t *testing.T) {
 		assert.Error(err)
 	}
 }
+
+func TestDeviceConnectionDuration(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		device  = newDevice(ID("mac:112233445566"), Key("key"), nil, 1)
+	)
+
+	// simulate a long-lived connection
+	device.connectedAt = device.connectedAt.Add(-90 * time.Minute)
+
+	assert.True(device.ConnectionDuration() >= 90*time.Minute)
+	assert.True(device.ConnectionDuration() < 91*time.Minute)
+
+	var output map[string]interface{}
+	require.NoError(json.Unmarshal([]byte(device.String()), &output))
+	assert.Equal("1h30m0s", output["connectionDuration"])
+
+	device.RequestClose()
+	closedDuration := device.ConnectionDuration()
+	assert.True(closedDuration >= 90*time.Minute)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(closedDuration, device.ConnectionDuration())
+}
+
+func TestDeviceMarshalJSONConvey(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		device  = newDevice(ID("mac:112233445566"), Key("key"), Convey{"foo": "bar"}, 1)
+	)
+
+	var output map[string]interface{}
+	require.NoError(json.Unmarshal([]byte(device.String()), &output))
+	assert.Equal(map[string]interface{}{"foo": "bar"}, output["convey"])
+	assert.NotContains(output, "conveyError")
+
+	device.convey = nil
+	device.rawConvey = `"quoted" \ raw`
+	device.conveyError = errors.New(`"quoted" \ error`)
+
+	output = nil
+	require.NoError(json.Unmarshal([]byte(device.String()), &output))
+	assert.Equal(`"quoted" \ raw`, output["convey"])
+	assert.Equal(`"quoted" \ error`, output["conveyError"])
+}
+
+func TestDeviceMarshalJSONProtocol(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		device  = newDevice(ID("mac:112233445566"), Key("key"), nil, 1)
+	)
+
+	var output map[string]interface{}
+	require.NoError(json.Unmarshal([]byte(device.String()), &output))
+	assert.NotContains(output, "protocol")
+
+	device.protocol = "wrp-2"
+	output = nil
+	require.NoError(json.Unmarshal([]byte(device.String()), &output))
+	assert.Equal("wrp-2", output["protocol"])
+}
+
+func TestDeviceSendSharedRequest(t *testing.T) {
+	const deviceCount = 5
+
+	type contextKey struct{}
+
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(&Options{Logger: logging.TestLogger(t)}, new(mockConnectionFactory)).(*manager)
+		stop    = make(chan struct{})
+		ctx     = context.WithValue(context.Background(), contextKey{}, "caller")
+		request = (&Request{
+			Message: &wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "test",
+				Destination: "mac:112233445566/service",
+			},
+		}).WithContext(ctx)
+
+		devices = make([]*device, deviceCount)
+		sent    = make([]<-chan *Request, deviceCount)
+		results = make(chan error, deviceCount)
+	)
+
+	defer close(stop)
+	require.NotNil(m.transactionTracer)
+	for i := range devices {
+		devices[i] = m.newManagedDevice(ID("mac:112233445566"), Key(string(rune('a'+i))), nil, "")
+		sent[i] = completeSends(devices[i], nil, stop)
+	}
+
+	for _, d := range devices {
+		go func(d *device) {
+			_, err := d.Send(request)
+			results <- err
+		}(d)
+	}
+
+	for range devices {
+		assert.NoError(<-results)
+	}
+
+	// the caller's request is left untouched, and each device sees its own copy
+	assert.True(ctx == request.Context())
+	for i := range devices {
+		select {
+		case actual := <-sent[i]:
+			assert.False(request == actual)
+			assert.True(request.Message == actual.Message)
+			assert.Equal("caller", actual.Context().Value(contextKey{}))
+		case <-time.After(5 * time.Second):
+			assert.Fail("The request was not sent")
+		}
+	}
+}
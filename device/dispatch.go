@@ -0,0 +1,122 @@
+package device
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// DispatchPolicy is the strategy for routing a request whose destination ID is shared by more than one
+// connected device, which usually happens when a device is cloned or reconnects before its old connection
+// is cleaned up.  The default, RejectDuplicates, fails such requests with ErrorNonUniqueID.
+//
+// Implementations must be safe for concurrent use, as a Manager's policy is shared by all routing goroutines.
+type DispatchPolicy interface {
+	// Dispatch selects the devices that receive a request.  The candidates are every device connected with
+	// the request's ID, ordered by connection time, oldest first.  There are always at least two candidates,
+	// as a lone device always receives its requests.
+	//
+	// When more than one device is selected, the request is sent to each of them concurrently, and the first
+	// successful response is returned.  Selecting no devices fails the route with ErrorNonUniqueID.
+	Dispatch(request *Request, candidates []Interface) ([]Interface, error)
+}
+
+// DispatchPolicyFunc is a function type that implements DispatchPolicy
+type DispatchPolicyFunc func(*Request, []Interface) ([]Interface, error)
+
+func (f DispatchPolicyFunc) Dispatch(request *Request, candidates []Interface) ([]Interface, error) {
+	return f(request, candidates)
+}
+
+var (
+	// RejectDuplicates is the default DispatchPolicy, which refuses to route requests to duplicate devices
+	RejectDuplicates DispatchPolicy = DispatchPolicyFunc(func(*Request, []Interface) ([]Interface, error) {
+		return nil, ErrorNonUniqueID
+	})
+
+	// NewestConnectionWins is a DispatchPolicy that routes requests to the most recently connected device
+	NewestConnectionWins DispatchPolicy = DispatchPolicyFunc(func(_ *Request, candidates []Interface) ([]Interface, error) {
+		return candidates[len(candidates)-1:], nil
+	})
+
+	// BroadcastDuplicates is a DispatchPolicy that routes requests to every device with the destination ID
+	BroadcastDuplicates DispatchPolicy = DispatchPolicyFunc(func(_ *Request, candidates []Interface) ([]Interface, error) {
+		return candidates, nil
+	})
+)
+
+// roundRobin is the DispatchPolicy returned by NewRoundRobin
+type roundRobin struct {
+	next uint32
+}
+
+// NewRoundRobin creates a DispatchPolicy that routes successive requests to each duplicate device in turn.
+// The rotation is shared by all IDs, so it only evens out the load across duplicates on average.
+func NewRoundRobin() DispatchPolicy {
+	return new(roundRobin)
+}
+
+func (rr *roundRobin) Dispatch(_ *Request, candidates []Interface) ([]Interface, error) {
+	selected := int((atomic.AddUint32(&rr.next, 1) - 1) % uint32(len(candidates)))
+	return candidates[selected : selected+1], nil
+}
+
+// byConnectedAt sorts devices by connection time, oldest first.  Devices that connected at the same
+// instant are ordered by key, so that the order is always deterministic.
+type byConnectedAt []Interface
+
+func (b byConnectedAt) Len() int      { return len(b) }
+func (b byConnectedAt) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byConnectedAt) Less(i, j int) bool {
+	if left, right := b[i].ConnectedAt(), b[j].ConnectedAt(); !left.Equal(right) {
+		return left.Before(right)
+	}
+
+	return b[i].Key() < b[j].Key()
+}
+
+// dispatchResult is the outcome of sending a request to one of several selected devices
+type dispatchResult struct {
+	response *Response
+	err      error
+}
+
+// dispatchDuplicates routes a request to the duplicate devices with its destination ID using the configured DispatchPolicy
+func (m *manager) dispatchDuplicates(request *Request, duplicates []*device) (*Response, error) {
+	candidates := make([]Interface, len(duplicates))
+	for i, d := range duplicates {
+		candidates[i] = d
+	}
+
+	sort.Sort(byConnectedAt(candidates))
+	selected, err := m.dispatchPolicy.Dispatch(request, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(selected) {
+	case 0:
+		return nil, ErrorNonUniqueID
+	case 1:
+		return selected[0].Send(request)
+	}
+
+	// each duplicate gets its own copy of the request, as Send is free to stamp it with a transaction context
+	results := make(chan dispatchResult, len(selected))
+	for _, d := range selected {
+		go func(d Interface, request *Request) {
+			response, err := d.Send(request)
+			results <- dispatchResult{response, err}
+		}(d, request.withContext(request.Context()))
+	}
+
+	for remaining := len(selected); remaining > 0; remaining-- {
+		result := <-results
+		if result.err == nil {
+			return result.response, nil
+		}
+
+		err = result.err
+	}
+
+	return nil, err
+}
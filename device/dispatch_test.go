@@ -0,0 +1,263 @@
+package device
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+	"time"
+)
+
+func testCandidates(count int) []Interface {
+	candidates := make([]Interface, count)
+	for i := range candidates {
+		candidates[i] = new(mockDevice)
+	}
+
+	return candidates
+}
+
+func TestRejectDuplicates(t *testing.T) {
+	assert := assert.New(t)
+	selected, err := RejectDuplicates.Dispatch(new(Request), testCandidates(2))
+	assert.Empty(selected)
+	assert.Equal(ErrorNonUniqueID, err)
+}
+
+func TestNewestConnectionWins(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		candidates = testCandidates(3)
+	)
+
+	selected, err := NewestConnectionWins.Dispatch(new(Request), candidates)
+	assert.Equal([]Interface{candidates[2]}, selected)
+	assert.NoError(err)
+}
+
+func TestBroadcastDuplicates(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		candidates = testCandidates(3)
+	)
+
+	selected, err := BroadcastDuplicates.Dispatch(new(Request), candidates)
+	assert.Equal(candidates, selected)
+	assert.NoError(err)
+}
+
+func TestRoundRobin(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		policy     = NewRoundRobin()
+		candidates = testCandidates(3)
+	)
+
+	for repeat := 0; repeat < 7; repeat++ {
+		selected, err := policy.Dispatch(new(Request), candidates)
+		assert.Equal([]Interface{candidates[repeat%3]}, selected)
+		assert.NoError(err)
+	}
+
+	// the rotation continues across differently sized candidate lists
+	selected, err := policy.Dispatch(new(Request), candidates[:2])
+	assert.Equal([]Interface{candidates[1]}, selected)
+	assert.NoError(err)
+}
+
+func TestByConnectedAt(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		now    = time.Now()
+		oldest = new(mockDevice)
+		first  = new(mockDevice)
+		second = new(mockDevice)
+	)
+
+	oldest.On("ConnectedAt").Return(now.Add(-time.Minute))
+	oldest.On("Key").Return(Key("zzz"))
+	first.On("ConnectedAt").Return(now)
+	first.On("Key").Return(Key("aaa"))
+	second.On("ConnectedAt").Return(now)
+	second.On("Key").Return(Key("bbb"))
+
+	devices := []Interface{second, first, oldest}
+	sort.Sort(byConnectedAt(devices))
+	assert.Equal([]Interface{oldest, first, second}, devices)
+}
+
+func TestOptionsDispatchPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		_, err := o.dispatchPolicy().Dispatch(new(Request), testCandidates(2))
+		assert.Equal(ErrorNonUniqueID, err)
+	}
+
+	candidates := testCandidates(2)
+	selected, err := (&Options{DispatchPolicy: BroadcastDuplicates}).dispatchPolicy().Dispatch(new(Request), candidates)
+	assert.Equal(candidates, selected)
+	assert.NoError(err)
+}
+
+// completeSends acts as the write pump for a device that is not connected, completing each message
+// sent to the device with the given error.  Each completed request is forwarded to the returned channel.
+func completeSends(d *device, err error, stop <-chan struct{}) <-chan *Request {
+	sent := make(chan *Request, 10)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-d.messages[LowPriority]:
+				e.complete <- err
+				sent <- e.request
+			}
+		}
+	}()
+
+	return sent
+}
+
+func testManagerRouteDuplicates(t *testing.T, policy DispatchPolicy, sendErrors []error, expectSent []bool, expectedError error) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		request = &Request{
+			Message: &wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566",
+			},
+		}
+
+		now     = time.Now()
+		stop    = make(chan struct{})
+		sent    = make([]<-chan *Request, len(sendErrors))
+		manager = NewManager(&Options{DispatchPolicy: policy}, new(mockConnectionFactory)).(*manager)
+	)
+
+	defer close(stop)
+
+	// add the devices newest first, to verify that candidates are ordered by connection time
+	for i := len(sendErrors) - 1; i >= 0; i-- {
+		d := manager.newManagedDevice(ID("mac:112233445566"), Key(string('a'+rune(i))), nil, "")
+		d.connectedAt = now.Add(time.Duration(i) * time.Second)
+		manager.registry.add(d)
+		sent[i] = completeSends(d, sendErrors[i], stop)
+	}
+
+	response, err := manager.Route(request)
+	assert.Nil(response)
+	assert.Equal(expectedError, err)
+	assert.Nil(request.ctx, "The caller's request should be left untouched")
+
+	for i, expected := range expectSent {
+		if expected {
+			select {
+			case actual := <-sent[i]:
+				assert.True(request.Message == actual.Message)
+			case <-time.After(5 * time.Second):
+				require.Fail("The request was not sent to the expected device", "device %d", i)
+			}
+		} else {
+			select {
+			case <-sent[i]:
+				assert.Fail("The request was sent to an unexpected device", "device %d", i)
+			default:
+			}
+		}
+	}
+}
+
+func testManagerRouteDuplicatesSharedRequest(t *testing.T) {
+	const (
+		duplicateCount = 3
+		routeCount     = 5
+	)
+
+	var (
+		assert  = assert.New(t)
+		request = &Request{
+			Message: &wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566",
+			},
+		}
+
+		stop    = make(chan struct{})
+		manager = NewManager(&Options{DispatchPolicy: BroadcastDuplicates}, new(mockConnectionFactory)).(*manager)
+		results = make(chan error, routeCount)
+	)
+
+	defer close(stop)
+	for i := 0; i < duplicateCount; i++ {
+		d := manager.newManagedDevice(ID("mac:112233445566"), Key(string('a'+rune(i))), nil, "")
+		manager.registry.add(d)
+		completeSends(d, nil, stop)
+	}
+
+	// concurrent routes of the same request fan out to each duplicate at once
+	for i := 0; i < routeCount; i++ {
+		go func() {
+			_, err := manager.Route(request)
+			results <- err
+		}()
+	}
+
+	for i := 0; i < routeCount; i++ {
+		select {
+		case err := <-results:
+			assert.NoError(err)
+		case <-time.After(5 * time.Second):
+			assert.Fail("The request was not routed")
+			return
+		}
+	}
+
+	assert.Nil(request.ctx, "The caller's request should be left untouched")
+}
+
+func TestManagerRouteDuplicates(t *testing.T) {
+	var (
+		sendError   = errors.New("expected send error")
+		policyError = errors.New("expected policy error")
+	)
+
+	t.Run("Reject", func(t *testing.T) {
+		testManagerRouteDuplicates(t, nil, []error{nil, nil}, []bool{false, false}, ErrorNonUniqueID)
+	})
+
+	t.Run("Newest", func(t *testing.T) {
+		testManagerRouteDuplicates(t, NewestConnectionWins, []error{nil, nil, nil}, []bool{false, false, true}, nil)
+	})
+
+	t.Run("Oldest", func(t *testing.T) {
+		oldest := DispatchPolicyFunc(func(_ *Request, candidates []Interface) ([]Interface, error) {
+			return candidates[:1], nil
+		})
+
+		testManagerRouteDuplicates(t, oldest, []error{nil, nil, nil}, []bool{true, false, false}, nil)
+	})
+
+	t.Run("Broadcast", func(t *testing.T) {
+		testManagerRouteDuplicates(t, BroadcastDuplicates, []error{sendError, nil}, []bool{true, true}, nil)
+	})
+
+	t.Run("BroadcastFailure", func(t *testing.T) {
+		testManagerRouteDuplicates(t, BroadcastDuplicates, []error{sendError, sendError}, []bool{true, true}, sendError)
+	})
+
+	t.Run("BroadcastSharedRequest", testManagerRouteDuplicatesSharedRequest)
+
+	t.Run("EmptySelection", func(t *testing.T) {
+		empty := DispatchPolicyFunc(func(*Request, []Interface) ([]Interface, error) { return nil, nil })
+		testManagerRouteDuplicates(t, empty, []error{nil, nil}, []bool{false, false}, ErrorNonUniqueID)
+	})
+
+	t.Run("PolicyError", func(t *testing.T) {
+		failing := DispatchPolicyFunc(func(*Request, []Interface) ([]Interface, error) { return nil, policyError })
+		testManagerRouteDuplicates(t, failing, []error{nil, nil}, []bool{false, false}, policyError)
+	})
+}
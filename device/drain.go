@@ -0,0 +1,99 @@
+package device
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// minimumDrainInterval is the shortest time a drain waits between disconnections.  Rates faster
+	// than one device per minimumDrainInterval are achieved by disconnecting devices in batches.
+	minimumDrainInterval = 10 * time.Millisecond
+)
+
+// Drainer disconnects devices gradually
+type Drainer interface {
+	// Drain disconnects every device that is connected when this method is called, at a rate of
+	// devices per second.  A nonpositive rate disconnects all devices at once.  Spreading disconnections
+	// out keeps devices from reconnecting to other nodes all at the same time.
+	//
+	// Each disconnection dispatches a DrainProgress event.  This method blocks until all devices are
+	// disconnected or the context is cancelled, returning the count of devices disconnected along
+	// with the context's error, if any.  Devices that disconnect on their own during the drain are skipped.
+	//
+	// A drain does not prevent new connections.  Close the Options.Gate first to keep devices from
+	// reconnecting to this node.
+	Drain(ctx context.Context, rate int) (int, error)
+}
+
+// drainSchedule computes the interval between drain batches and the count of devices in each batch
+// for the given rate.  A zero interval means that all devices are disconnected in a single batch.
+func drainSchedule(rate int) (time.Duration, int) {
+	if rate <= 0 {
+		return 0, 0
+	}
+
+	batch := 1
+	if interval := time.Second / time.Duration(rate); interval >= minimumDrainInterval {
+		return interval, batch
+	}
+
+	batchesPerSecond := int(time.Second / minimumDrainInterval)
+	batch = (rate + batchesPerSecond - 1) / batchesPerSecond
+	return time.Second * time.Duration(batch) / time.Duration(rate), batch
+}
+
+func (m *manager) Drain(ctx context.Context, rate int) (int, error) {
+	var targets []*device
+	m.registry.visitAll(func(d *device) {
+		targets = append(targets, d)
+	})
+
+	interval, batch := drainSchedule(rate)
+	m.logger.Info("Draining %d devices at %d per second", len(targets), rate)
+
+	var (
+		ticks <-chan time.Time
+		event = Event{Type: DrainProgress, DrainTotal: len(targets)}
+	)
+
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	} else {
+		batch = len(targets)
+	}
+
+	for next := 0; next < len(targets); {
+		// check for cancellation first, as a select does not prefer either of its cases
+		if err := ctx.Err(); err != nil {
+			m.logger.Info("Drain cancelled after disconnecting %d of %d devices: %s", event.Drained, len(targets), err)
+			return event.Drained, err
+		}
+
+		// the first batch is disconnected immediately
+		if next > 0 {
+			select {
+			case <-ctx.Done():
+				continue
+			case <-ticks:
+			}
+		}
+
+		for end := next + batch; next < end && next < len(targets); next++ {
+			d := targets[next]
+			if d.Closed() {
+				continue
+			}
+
+			d.RequestClose()
+			event.Device = d
+			event.Drained++
+			m.dispatch(&event)
+		}
+	}
+
+	m.logger.Info("Drain complete: disconnected %d of %d devices", event.Drained, len(targets))
+	return event.Drained, nil
+}
@@ -0,0 +1,147 @@
+package device
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDrainSchedule(t *testing.T) {
+	assert := assert.New(t)
+	for _, record := range []struct {
+		rate             int
+		expectedInterval time.Duration
+		expectedBatch    int
+	}{
+		{-1, 0, 0},
+		{0, 0, 0},
+		{1, time.Second, 1},
+		{4, 250 * time.Millisecond, 1},
+		{100, 10 * time.Millisecond, 1},
+		{150, time.Second * 2 / 150, 2},
+		{1000, 10 * time.Millisecond, 10},
+		{1000000, 10 * time.Millisecond, 10000},
+	} {
+		interval, batch := drainSchedule(record.rate)
+		assert.Equal(record.expectedInterval, interval, "rate %d", record.rate)
+		assert.Equal(record.expectedBatch, batch, "rate %d", record.rate)
+	}
+}
+
+// newDrainManager creates a manager with the given number of registered devices.  Each DrainProgress
+// event is copied to the returned channel.
+func newDrainManager(t *testing.T, deviceCount int, listeners ...Listener) (*manager, []*device, <-chan Event) {
+	events := make(chan Event, deviceCount)
+	listeners = append(listeners, func(event *Event) {
+		if event.Type == DrainProgress {
+			events <- *event
+		}
+	})
+
+	var (
+		m       = NewManager(&Options{Logger: logging.TestLogger(t), Listeners: listeners}, new(mockConnectionFactory)).(*manager)
+		devices = make([]*device, deviceCount)
+	)
+
+	for i := range devices {
+		devices[i] = newDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, 1)
+		require.NoError(t, m.registry.add(devices[i]))
+	}
+
+	return m, devices, events
+}
+
+func testManagerDrainAll(t *testing.T) {
+	var (
+		assert             = assert.New(t)
+		m, devices, events = newDrainManager(t, 5)
+	)
+
+	devices[2].RequestClose()
+	drained, err := m.Drain(context.Background(), 0)
+	assert.Equal(4, drained)
+	assert.NoError(err)
+
+	for _, d := range devices {
+		assert.True(d.Closed())
+	}
+
+	for expected := 1; expected <= 4; expected++ {
+		event := <-events
+		assert.Equal(expected, event.Drained)
+		assert.Equal(5, event.DrainTotal)
+		assert.NotEqual(devices[2], event.Device)
+	}
+
+	assert.Empty(events)
+}
+
+func testManagerDrainRate(t *testing.T) {
+	var (
+		assert             = assert.New(t)
+		m, devices, events = newDrainManager(t, 5)
+		start              = time.Now()
+	)
+
+	drained, err := m.Drain(context.Background(), 100)
+	assert.Equal(5, drained)
+	assert.NoError(err)
+
+	// the first device is disconnected immediately, and each subsequent one waits for the ticker
+	assert.True(time.Since(start) >= 4*10*time.Millisecond)
+	for _, d := range devices {
+		assert.True(d.Closed())
+	}
+
+	assert.Len(events, 5)
+}
+
+func testManagerDrainCancelled(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		ctx, cancel = context.WithCancel(context.Background())
+
+		m, devices, events = newDrainManager(t, 3, func(event *Event) {
+			if event.Type == DrainProgress {
+				cancel()
+			}
+		})
+	)
+
+	drained, err := m.Drain(ctx, 1)
+	assert.Equal(1, drained)
+	assert.Equal(context.Canceled, err)
+
+	closed := 0
+	for _, d := range devices {
+		if d.Closed() {
+			closed++
+		}
+	}
+
+	assert.Equal(1, closed)
+	assert.Len(events, 1)
+}
+
+func testManagerDrainEmpty(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		m, _, events = newDrainManager(t, 0)
+	)
+
+	drained, err := m.Drain(context.Background(), 10)
+	assert.Zero(drained)
+	assert.NoError(err)
+	assert.Empty(events)
+}
+
+func TestManagerDrain(t *testing.T) {
+	t.Run("All", testManagerDrainAll)
+	t.Run("Rate", testManagerDrainRate)
+	t.Run("Cancelled", testManagerDrainCancelled)
+	t.Run("Empty", testManagerDrainEmpty)
+}
@@ -6,14 +6,31 @@ import (
 
 var (
 	ErrorInvalidDeviceName            = errors.New("Invalid device name")
+	ErrorMissingDeviceName            = errors.New("No device name was supplied")
 	ErrorDeviceNotFound               = errors.New("The device does not exist")
 	ErrorNonUniqueID                  = errors.New("More than once device with that identifier is connected")
 	ErrorDuplicateKey                 = errors.New("That key is a duplicate")
+	ErrorInvalidKey                   = errors.New("That key is invalid")
 	ErrorInvalidTransactionKey        = errors.New("Transaction keys must be non-empty strings")
 	ErrorNoSuchTransactionKey         = errors.New("That transaction key is not registered")
 	ErrorTransactionAlreadyRegistered = errors.New("That transaction is already registered")
 	ErrorTransactionCancelled         = errors.New("The transaction has been cancelled")
+	ErrorTransactionTimeout           = errors.New("The transaction expired before the device responded")
 	ErrorResponseNoContents           = errors.New("The response has no contents")
 	ErrorDeviceBusy                   = errors.New("That device is busy")
 	ErrorDeviceClosed                 = errors.New("That device has been closed")
+	ErrorInvalidFrameType             = errors.New("Frame types must be either binary or text")
+	ErrorOriginRejected               = errors.New("The request origin is not allowed")
+	ErrorSlowConsumer                 = errors.New("The device is not keeping up with its messages")
+	ErrorDecodeFailure                = errors.New("The device sent too many frames that could not be decoded")
+	ErrorMessageSpooled               = errors.New("The device is not connected, and the message has been spooled for delivery")
+	ErrorDeviceLimit                  = errors.New("The server has reached its device limit")
+	ErrorMissingMessage               = errors.New("A device request requires a WRP message")
+	ErrorConveyTooLarge               = errors.New("The convey value exceeds the maximum size")
+	ErrorRateLimited                  = errors.New("The device is sending messages faster than its rate limit")
+	ErrorNotAcknowledged              = errors.New("The device did not acknowledge the message")
+	ErrorAckUnsupported               = errors.New("Only WRP messages of type *wrp.Message can be acknowledged")
+	ErrorNotBatch                     = errors.New("That message is not a batch envelope")
+	ErrorQueueFull                    = errors.New("The device's message queue is full")
+	ErrorIdempotencyCacheFull         = errors.New("Too many requests with idempotency keys are in flight for that device")
 )
@@ -0,0 +1,42 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+)
+
+// FrameType is the kind of websocket frame used to carry WRP messages
+type FrameType string
+
+const (
+	BinaryFrame FrameType = "binary"
+	TextFrame   FrameType = "text"
+)
+
+// DefaultFrameType returns the conventional frame type for a message encoding:
+// binary frames for Msgpack and text frames for JSON.
+func DefaultFrameType(f wrp.Format) FrameType {
+	if f == wrp.JSON {
+		return TextFrame
+	}
+
+	return BinaryFrame
+}
+
+// Validate checks that this frame type is one of the supported values
+func (ft FrameType) Validate() error {
+	_, err := ft.messageType()
+	return err
+}
+
+// messageType returns the gorilla websocket message type for this frame type
+func (ft FrameType) messageType() (int, error) {
+	switch ft {
+	case BinaryFrame:
+		return websocket.BinaryMessage, nil
+	case TextFrame:
+		return websocket.TextMessage, nil
+	default:
+		return 0, ErrorInvalidFrameType
+	}
+}
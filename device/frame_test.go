@@ -0,0 +1,110 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultFrameType(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(BinaryFrame, DefaultFrameType(wrp.Msgpack))
+	assert.Equal(TextFrame, DefaultFrameType(wrp.JSON))
+}
+
+func TestFrameTypeValidate(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(BinaryFrame.Validate())
+	assert.NoError(TextFrame.Validate())
+	assert.Equal(ErrorInvalidFrameType, FrameType("").Validate())
+	assert.Equal(ErrorInvalidFrameType, FrameType("ping").Validate())
+}
+
+func TestOptionsFrameType(t *testing.T) {
+	testData := []struct {
+		options         *Options
+		expectedMsgpack int
+		expectedJSON    int
+	}{
+		{nil, websocket.BinaryMessage, websocket.TextMessage},
+		{new(Options), websocket.BinaryMessage, websocket.TextMessage},
+		{&Options{MsgpackFrameType: "invalid", JSONFrameType: "invalid"}, websocket.BinaryMessage, websocket.TextMessage},
+		{&Options{MsgpackFrameType: TextFrame, JSONFrameType: BinaryFrame}, websocket.TextMessage, websocket.BinaryMessage},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		assert.Equal(t, record.expectedMsgpack, record.options.frameType(wrp.Msgpack))
+		assert.Equal(t, record.expectedJSON, record.options.frameType(wrp.JSON))
+	}
+}
+
+func TestUnexpectedFrameType(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan wrp.Routable, 1)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	options := &Options{
+		Logger:           logging.TestLogger(t),
+		Metrics:          registry,
+		MsgpackFrameType: TextFrame,
+		Listeners: []Listener{
+			func(event *Event) {
+				if event.Type == MessageReceived {
+					received <- event.Message
+				}
+			},
+		},
+	}
+
+	_, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	defer c.Close()
+
+	var frame []byte
+	require.NoError(
+		wrp.NewEncoderBytes(&frame, wrp.Msgpack).Encode(
+			&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "test", Destination: "unexpected"},
+		),
+	)
+
+	// a binary frame is not the configured type, so the server should skip it
+	require.NoError(c.(*connection).webSocket.WriteMessage(websocket.BinaryMessage, frame))
+
+	frame = nil
+	require.NoError(
+		wrp.NewEncoderBytes(&frame, wrp.Msgpack).Encode(
+			&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "test", Destination: "expected"},
+		),
+	)
+
+	_, err = c.Write(frame)
+	require.NoError(err)
+
+	select {
+	case message := <-received:
+		assert.Equal("expected", message.To())
+	case <-time.After(5 * time.Second):
+		assert.Fail("No message was received")
+	}
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, UnexpectedFrameCount+" 1"), body)
+}
@@ -3,6 +3,9 @@ package device
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Comcast/webpa-common/bookkeeping"
 	"github.com/Comcast/webpa-common/httperror"
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/wrp"
@@ -40,6 +43,15 @@ type MessageHandler struct {
 	// Timeout is the optional timeout for all operations through this handler.
 	// If this field is unset or is nonpositive, DefaultMessageTimeout is used instead.
 	Timeout time.Duration
+
+	// IdempotencyKeyHeader is the HTTP header carrying the optional idempotency key for each request.
+	// If not supplied, DefaultIdempotencyKeyHeader is used.
+	IdempotencyKeyHeader string
+
+	// Validator is the optional policy applied to each decoded WRP message before it is routed.  Messages
+	// that fail validation are answered with http.StatusBadRequest.  If not supplied, any message that
+	// decodes is routed.
+	Validator wrp.Validator
 }
 
 func (mh *MessageHandler) logger() logging.Logger {
@@ -50,6 +62,14 @@ func (mh *MessageHandler) logger() logging.Logger {
 	return logging.DefaultLogger()
 }
 
+func (mh *MessageHandler) idempotencyKeyHeader() string {
+	if len(mh.IdempotencyKeyHeader) > 0 {
+		return mh.IdempotencyKeyHeader
+	}
+
+	return DefaultIdempotencyKeyHeader
+}
+
 // createContext creates the Context object for routing operations.
 // This method will never return nils.  There will always be a timeout on the
 // returned context, which means there will always be a cancel function too.
@@ -67,6 +87,9 @@ func (mh *MessageHandler) decodeRequest(ctx context.Context, httpRequest *http.R
 	deviceRequest, err = DecodeRequest(httpRequest.Body, mh.Decoders)
 	if err == nil {
 		deviceRequest = deviceRequest.WithContext(ctx)
+		deviceRequest.IdempotencyKey = httpRequest.Header.Get(mh.idempotencyKeyHeader())
+		bookkeeping.SetDestination(ctx, deviceRequest.Message.To())
+		bookkeeping.SetTransactionUUID(ctx, deviceRequest.Message.TransactionKey())
 	}
 
 	return
@@ -78,6 +101,7 @@ func (mh *MessageHandler) ServeHTTP(httpResponse http.ResponseWriter, httpReques
 
 	deviceRequest, err := mh.decodeRequest(ctx, httpRequest)
 	if err != nil {
+		bookkeeping.SetError(ctx, err)
 		httperror.Formatf(
 			httpResponse,
 			http.StatusBadRequest,
@@ -88,8 +112,27 @@ func (mh *MessageHandler) ServeHTTP(httpResponse http.ResponseWriter, httpReques
 		return
 	}
 
+	// DecodeRequest always produces a *wrp.Message
+	if message, ok := deviceRequest.Message.(*wrp.Message); ok && mh.Validator != nil {
+		if err := mh.Validator.Validate(message); err != nil {
+			bookkeeping.SetError(ctx, err)
+			httperror.Formatf(
+				httpResponse,
+				http.StatusBadRequest,
+				"Invalid WRP message: %s",
+				err,
+			)
+
+			return
+		}
+	}
+
 	// deviceRequest carries the context through the routing infrastructure
-	if deviceResponse, err := mh.Router.Route(deviceRequest); err != nil {
+	if deviceResponse, err := mh.Router.Route(deviceRequest); err == ErrorMessageSpooled {
+		// the message will be delivered when the device reconnects
+		httpResponse.WriteHeader(http.StatusAccepted)
+	} else if err != nil {
+		bookkeeping.SetError(ctx, err)
 		code := http.StatusInternalServerError
 		switch err {
 		case ErrorInvalidDeviceName:
@@ -195,6 +238,12 @@ func (l *ConnectedDeviceListener) onDeviceEvent(e *Event) {
 		defer l.lock.Unlock()
 		l.changeCount++
 		delete(l.devices, e.Device.Key())
+	case KeyChanged:
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.changeCount++
+		delete(l.devices, e.PreviousKey)
+		l.devices[e.Device.Key()] = []byte(e.Device.String())
 	}
 }
 
@@ -309,3 +358,111 @@ func (lh *ListHandler) ServeHTTP(response http.ResponseWriter, request *http.Req
 		response.WriteHeader(http.StatusServiceUnavailable)
 	}
 }
+
+const (
+	// DebugIDParameter is the query parameter that selects the device ID reported by DebugHandler
+	DebugIDParameter = "id"
+)
+
+// debugTransaction is the JSON representation of a PendingTransaction
+type debugTransaction struct {
+	Key          string    `json:"key"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	Age          string    `json:"age"`
+}
+
+// debugDecodeFailure is the JSON representation of a DecodeFailure
+type debugDecodeFailure struct {
+	Time   time.Time `json:"time"`
+	Class  string    `json:"class"`
+	Error  string    `json:"error"`
+	Sample string    `json:"sample"`
+}
+
+// debugDevice is the JSON representation of a single device's diagnostic state
+type debugDevice struct {
+	ID                 ID                 `json:"id"`
+	Key                Key                `json:"key"`
+	ConnectedAt        time.Time          `json:"connectedAt"`
+	ConnectionDuration string             `json:"connectionDuration"`
+	Pending            int                `json:"pending"`
+	MaxQueueLatency    string             `json:"maxQueueLatency"`
+	Transactions       []debugTransaction `json:"transactions"`
+
+	DecodeFailureCount int                  `json:"decodeFailureCount"`
+	DecodeFailures     []debugDecodeFailure `json:"decodeFailures"`
+
+	Statistics Statistics `json:"statistics"`
+}
+
+// DebugHandler is an HTTP handler that reports diagnostic state, such as in-flight transactions,
+// for the devices with a given ID.  The ID is supplied via the DebugIDParameter query parameter.
+type DebugHandler struct {
+	Logger   logging.Logger
+	Registry Registry
+}
+
+func (dh *DebugHandler) logger() logging.Logger {
+	if dh.Logger != nil {
+		return dh.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+func (dh *DebugHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	id, err := ParseID(request.URL.Query().Get(DebugIDParameter))
+	if err != nil {
+		httperror.Formatf(response, http.StatusBadRequest, "Invalid device id: %s", err)
+		return
+	}
+
+	devices := make([]debugDevice, 0, 1)
+	dh.Registry.VisitIf(
+		func(candidate ID) bool { return candidate == id },
+		func(d Interface) {
+			pending := d.PendingTransactions()
+			transactions := make([]debugTransaction, len(pending))
+			for i, p := range pending {
+				transactions[i] = debugTransaction{p.Key, p.RegisteredAt, p.Age.String()}
+			}
+
+			failureCount, failures := d.DecodeFailures()
+			decodeFailures := make([]debugDecodeFailure, len(failures))
+			for i, f := range failures {
+				decodeFailures[i] = debugDecodeFailure{f.Time, f.Class, f.Error, hex.EncodeToString(f.Sample)}
+			}
+
+			devices = append(devices, debugDevice{
+				ID:                 d.ID(),
+				Key:                d.Key(),
+				ConnectedAt:        d.ConnectedAt(),
+				ConnectionDuration: d.ConnectionDuration().String(),
+				Pending:            d.Pending(),
+				MaxQueueLatency:    d.MaxQueueLatency().String(),
+				Transactions:       transactions,
+
+				DecodeFailureCount: failureCount,
+				DecodeFailures:     decodeFailures,
+
+				Statistics: d.Statistics(),
+			})
+		},
+	)
+
+	if len(devices) == 0 {
+		httperror.Formatf(response, http.StatusNotFound, "No such device: %s", id)
+		return
+	}
+
+	output, err := json.Marshal(map[string][]debugDevice{"devices": devices})
+	if err != nil {
+		dh.logger().Error("Unable to marshal debug output for [%s]: %s", id, err)
+		httperror.Formatf(response, http.StatusInternalServerError, "Unable to marshal debug output: %s", err)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	response.Write(output)
+}
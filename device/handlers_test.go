@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"github.com/Comcast/webpa-common/bookkeeping"
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
@@ -107,6 +108,51 @@ func testMessageHandlerServeHTTPDecodeError(t *testing.T) {
 	router.AssertExpectations(t)
 }
 
+func testMessageHandlerServeHTTPValidation(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		setupEncoders = wrp.NewEncoderPool(1, wrp.Msgpack)
+		router        = new(mockRouter)
+		handler       = MessageHandler{
+			Router:    router,
+			Decoders:  wrp.NewDecoderPool(1, wrp.Msgpack),
+			Validator: wrp.Validators{wrp.NewRequiredFieldsValidator(), wrp.NewMaxPayloadValidator(10)},
+		}
+	)
+
+	for _, invalid := range []*wrp.Message{
+		{Type: wrp.SimpleEventMessageType, Destination: "mac:123412341234"},
+		{Type: wrp.SimpleEventMessageType, Source: "test.com", Destination: "mac:123412341234", Payload: []byte("this payload is too large")},
+	} {
+		var requestContents []byte
+		require.NoError(setupEncoders.EncodeBytes(&requestContents, invalid))
+
+		var (
+			response           = httptest.NewRecorder()
+			actualResponseBody map[string]interface{}
+		)
+
+		handler.ServeHTTP(response, httptest.NewRequest("POST", "/foo", bytes.NewReader(requestContents)))
+		assert.Equal(http.StatusBadRequest, response.Code)
+		assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+		require.NoError(json.Unmarshal(response.Body.Bytes(), &actualResponseBody))
+		assert.Contains(actualResponseBody["message"], "Invalid WRP message")
+	}
+
+	// a valid message is routed
+	var requestContents []byte
+	require.NoError(setupEncoders.EncodeBytes(&requestContents, &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "test.com", Destination: "mac:123412341234"}))
+	router.On("Route", mock.MatchedBy(func(candidate *Request) bool { return candidate.Message != nil })).Once().Return(nil, nil)
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("POST", "/foo", bytes.NewReader(requestContents)))
+	assert.Equal(http.StatusOK, response.Code)
+
+	router.AssertExpectations(t)
+}
+
 func testMessageHandlerServeHTTPRouteError(t *testing.T, routeError error, expectedCode int) {
 	var (
 		assert  = assert.New(t)
@@ -155,6 +201,54 @@ func testMessageHandlerServeHTTPRouteError(t *testing.T, routeError error, expec
 	router.AssertExpectations(t)
 }
 
+func testMessageHandlerServeHTTPBookkeeping(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		message = &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "test.com",
+			Destination:     "mac:123412341234",
+			TransactionUUID: "test-transaction",
+		}
+
+		setupEncoders   = wrp.NewEncoderPool(1, wrp.Msgpack)
+		requestContents []byte
+	)
+
+	require.NoError(setupEncoders.EncodeBytes(&requestContents, message))
+
+	var (
+		response = httptest.NewRecorder()
+		request  = httptest.NewRequest("POST", "/foo", bytes.NewReader(requestContents))
+		records  []bookkeeping.Record
+
+		router     = new(mockRouter)
+		bookkeeper = bookkeeping.Bookkeeper{
+			Sink: bookkeeping.SinkFunc(func(r bookkeeping.Record) { records = append(records, r) }),
+		}
+
+		handler = bookkeeper.Then(&MessageHandler{
+			Router:   router,
+			Decoders: wrp.NewDecoderPool(1, wrp.Msgpack),
+		})
+	)
+
+	router.On("Route", mock.AnythingOfType("*device.Request")).Once().Return(nil, ErrorDeviceNotFound)
+
+	handler.ServeHTTP(response, request)
+	assert.Equal(http.StatusNotFound, response.Code)
+	require.Len(records, 1)
+	assert.Equal("mac:123412341234", records[0].Destination)
+	assert.Equal("test-transaction", records[0].TransactionUUID)
+	assert.Equal(ErrorDeviceNotFound.Error(), records[0].Error)
+	assert.Equal(http.StatusNotFound, records[0].StatusCode)
+	assert.Equal(bookkeeping.OutcomeClientError, records[0].Outcome)
+
+	router.AssertExpectations(t)
+}
+
 func testMessageHandlerServeHTTPEvent(t *testing.T, requestFormat wrp.Format) {
 	var (
 		assert  = assert.New(t)
@@ -346,6 +440,73 @@ func testMessageHandlerServeHTTPEncodeError(t *testing.T) {
 	router.AssertExpectations(t)
 }
 
+func testMessageHandlerServeHTTPSpooled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		setupEncoders   = wrp.NewEncoderPool(1, wrp.Msgpack)
+		requestContents []byte
+	)
+
+	require.NoError(setupEncoders.EncodeBytes(&requestContents, &wrp.SimpleEvent{Destination: "mac:123412341234"}))
+
+	var (
+		response = httptest.NewRecorder()
+		request  = httptest.NewRequest("POST", "/foo", bytes.NewReader(requestContents))
+
+		router  = new(mockRouter)
+		handler = MessageHandler{
+			Router:   router,
+			Decoders: wrp.NewDecoderPool(1, wrp.Msgpack),
+		}
+	)
+
+	router.On("Route", mock.AnythingOfType("*device.Request")).Once().Return(nil, ErrorMessageSpooled)
+	handler.ServeHTTP(response, request)
+	assert.Equal(http.StatusAccepted, response.Code)
+	assert.Equal(0, response.Body.Len())
+
+	router.AssertExpectations(t)
+}
+
+func testMessageHandlerServeHTTPIdempotencyKey(t *testing.T, header string) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		setupEncoders   = wrp.NewEncoderPool(1, wrp.Msgpack)
+		requestContents []byte
+	)
+
+	require.NoError(setupEncoders.EncodeBytes(&requestContents, &wrp.SimpleEvent{Destination: "mac:123412341234"}))
+
+	var (
+		response = httptest.NewRecorder()
+		request  = httptest.NewRequest("POST", "/foo", bytes.NewReader(requestContents))
+
+		router  = new(mockRouter)
+		handler = MessageHandler{
+			Router:               router,
+			Decoders:             wrp.NewDecoderPool(1, wrp.Msgpack),
+			IdempotencyKeyHeader: header,
+		}
+	)
+
+	request.Header.Set(handler.idempotencyKeyHeader(), "retry-me")
+	router.On(
+		"Route",
+		mock.MatchedBy(func(candidate *Request) bool {
+			return candidate.IdempotencyKey == "retry-me"
+		}),
+	).Once().Return(nil, nil)
+
+	handler.ServeHTTP(response, request)
+	assert.Equal(http.StatusOK, response.Code)
+
+	router.AssertExpectations(t)
+}
+
 func TestMessageHandler(t *testing.T) {
 	t.Run("Logger", testMessageHandlerLogger)
 	t.Run("CreateContext", func(t *testing.T) {
@@ -356,6 +517,7 @@ func TestMessageHandler(t *testing.T) {
 	t.Run("ServeHTTP", func(t *testing.T) {
 		t.Run("DecodeError", testMessageHandlerServeHTTPDecodeError)
 		t.Run("EncodeError", testMessageHandlerServeHTTPEncodeError)
+		t.Run("Validation", testMessageHandlerServeHTTPValidation)
 
 		t.Run("RouteError", func(t *testing.T) {
 			testMessageHandlerServeHTTPRouteError(t, ErrorInvalidDeviceName, http.StatusBadRequest)
@@ -366,6 +528,14 @@ func TestMessageHandler(t *testing.T) {
 			testMessageHandlerServeHTTPRouteError(t, errors.New("random error"), http.StatusInternalServerError)
 		})
 
+		t.Run("Spooled", testMessageHandlerServeHTTPSpooled)
+		t.Run("Bookkeeping", testMessageHandlerServeHTTPBookkeeping)
+
+		t.Run("IdempotencyKey", func(t *testing.T) {
+			testMessageHandlerServeHTTPIdempotencyKey(t, "")
+			testMessageHandlerServeHTTPIdempotencyKey(t, "X-Custom-Idempotency-Key")
+		})
+
 		t.Run("Event", func(t *testing.T) {
 			for _, requestFormat := range []wrp.Format{wrp.Msgpack, wrp.JSON} {
 				testMessageHandlerServeHTTPEvent(t, requestFormat)
@@ -581,7 +751,15 @@ func testConnectedDeviceListenerListen(t *testing.T) {
 	refreshC <- time.Now()
 	expectDeviceListUpdate(assert, updates, "B")
 
-	deviceListener(&Event{Type: Disconnect, Device: deviceB})
+	// a rotated key replaces the device's previous entry
+	rotated := new(mockDevice)
+	rotated.On("Key").Return(Key("C"))
+	rotated.On("String").Return(`{"id": "B", "key": "C"}`)
+	deviceListener(&Event{Type: KeyChanged, Device: rotated, PreviousKey: Key("B")})
+	refreshC <- time.Now()
+	expectDeviceListUpdate(assert, updates, "C")
+
+	deviceListener(&Event{Type: Disconnect, Device: rotated})
 	refreshC <- time.Now()
 	expectDeviceListUpdate(assert, updates)
 
@@ -673,3 +851,126 @@ func TestListHandler(t *testing.T) {
 		t.Run("WhileConsuming", testListHandlerServeHTTPWhileConsuming)
 	})
 }
+
+func testDebugHandlerBadID(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		registry = new(mockRegistry)
+		handler  = DebugHandler{Logger: logging.TestLogger(t), Registry: registry}
+		response = httptest.NewRecorder()
+	)
+
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/?id=invalid", nil))
+	assert.Equal(http.StatusBadRequest, response.Code)
+	registry.AssertExpectations(t)
+}
+
+func testDebugHandlerNotFound(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		registry = new(mockRegistry)
+		handler  = DebugHandler{Logger: logging.TestLogger(t), Registry: registry}
+		response = httptest.NewRecorder()
+	)
+
+	registry.On("VisitIf", mock.AnythingOfType("func(device.ID) bool"), mock.AnythingOfType("func(device.Interface)")).Return(0).Once()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/?id=mac:112233445566", nil))
+	assert.Equal(http.StatusNotFound, response.Code)
+	registry.AssertExpectations(t)
+}
+
+func testDebugHandlerSuccess(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		connectedAt = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+		device      = new(mockDevice)
+		registry    = new(mockRegistry)
+		handler     = DebugHandler{Logger: logging.TestLogger(t), Registry: registry}
+		response    = httptest.NewRecorder()
+	)
+
+	device.On("ID").Return(ID("mac:112233445566"))
+	device.On("Key").Return(Key("key"))
+	device.On("ConnectedAt").Return(connectedAt)
+	device.On("ConnectionDuration").Return(90 * time.Minute)
+	device.On("Pending").Return(2)
+	device.On("MaxQueueLatency").Return(250 * time.Millisecond)
+	device.On("DecodeFailures").Return(3, []DecodeFailure{
+		{Time: connectedAt.Add(time.Minute), Class: DecodeErrorMalformed, Error: "bad", Sample: []byte{0xde, 0xad}},
+	})
+	device.On("PendingTransactions").Return([]PendingTransaction{
+		{Key: "stuck", RegisteredAt: connectedAt.Add(time.Second), Age: 90 * time.Second},
+	})
+	device.On("Statistics").Return(Statistics{
+		MessagesSent:     5,
+		MessagesReceived: 4,
+		BytesSent:        500,
+		BytesReceived:    400,
+		SendErrors:       1,
+		LastRead:         connectedAt.Add(2 * time.Minute),
+		RTT:              85 * time.Millisecond,
+		LastRTT:          120 * time.Millisecond,
+	})
+
+	registry.On("VisitIf", mock.AnythingOfType("func(device.ID) bool"), mock.AnythingOfType("func(device.Interface)")).
+		Run(func(arguments mock.Arguments) {
+			filter, visitor := arguments.Get(0).(func(ID) bool), arguments.Get(1).(func(Interface))
+			assert.True(filter(ID("mac:112233445566")))
+			assert.False(filter(ID("mac:665544332211")))
+			visitor(device)
+		}).
+		Return(1).
+		Once()
+
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/?id=mac:112233445566", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+
+	var output map[string][]map[string]interface{}
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &output))
+	require.Len(output["devices"], 1)
+
+	actual := output["devices"][0]
+	assert.Equal("mac:112233445566", actual["id"])
+	assert.Equal("key", actual["key"])
+	assert.Equal("1h30m0s", actual["connectionDuration"])
+	assert.Equal(float64(2), actual["pending"])
+	assert.Equal("250ms", actual["maxQueueLatency"])
+	assert.Equal(float64(3), actual["decodeFailureCount"])
+	assert.Equal(
+		[]interface{}{
+			map[string]interface{}{"time": "2017-03-01T12:01:00Z", "class": DecodeErrorMalformed, "error": "bad", "sample": "dead"},
+		},
+		actual["decodeFailures"],
+	)
+	assert.Equal(
+		[]interface{}{
+			map[string]interface{}{"key": "stuck", "registeredAt": "2017-03-01T12:00:01Z", "age": "1m30s"},
+		},
+		actual["transactions"],
+	)
+	assert.Equal(
+		map[string]interface{}{
+			"messagesSent":     float64(5),
+			"messagesReceived": float64(4),
+			"bytesSent":        float64(500),
+			"bytesReceived":    float64(400),
+			"sendErrors":       float64(1),
+			"lastRead":         "2017-03-01T12:02:00Z",
+			"lastWrite":        "0001-01-01T00:00:00Z",
+			"rtt":              "85ms",
+			"lastRTT":          "120ms",
+		},
+		actual["statistics"],
+	)
+
+	device.AssertExpectations(t)
+	registry.AssertExpectations(t)
+}
+
+func TestDebugHandler(t *testing.T) {
+	t.Run("BadID", testDebugHandlerBadID)
+	t.Run("NotFound", testDebugHandlerNotFound)
+	t.Run("Success", testDebugHandlerSuccess)
+}
@@ -0,0 +1,30 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/wrp"
+	"time"
+)
+
+// recordsHop tests whether the given recorder will append a hop to a routable message
+func recordsHop(recorder *wrp.HopRecorder, message wrp.Routable) bool {
+	m, ok := message.(*wrp.Message)
+	return ok && recorder.Records(m)
+}
+
+// recordedMessage returns a copy of the given encodable message with a hop appended to its spans.
+// If the message is not a *wrp.Message or the recorder does not record it, it is returned as is.
+// As with signedMessage, the original routable determines whether the encodable message is already
+// a private copy.
+func recordedMessage(recorder *wrp.HopRecorder, original wrp.Routable, encodable interface{}, start time.Time) interface{} {
+	message, ok := encodable.(*wrp.Message)
+	if !ok || !recorder.Records(message) {
+		return encodable
+	}
+
+	if shared, ok := original.(*wrp.Message); ok && shared == message {
+		message = copyMessage(message, 0)
+	}
+
+	recorder.Record(message, start, wrp.HopStatusOK)
+	return message
+}
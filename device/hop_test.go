@@ -0,0 +1,133 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestOptionsHopRecorder(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Nil(o.hopRecorder())
+	}
+
+	recorder := new(wrp.HopRecorder)
+	assert.True(recorder == (&Options{HopRecorder: recorder}).hopRecorder())
+}
+
+func TestRecordedMessage(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		recorder = &wrp.HopRecorder{Name: "test"}
+		original = new(wrp.Message).SetIncludeSpans(true)
+	)
+
+	assert.True(recordsHop(recorder, original))
+	assert.False(recordsHop(nil, original))
+	assert.False(recordsHop(recorder, new(wrp.Message)))
+	assert.False(recordsHop(recorder, new(wrp.SimpleEvent)))
+
+	// the caller's message is never modified
+	recorded, ok := recordedMessage(recorder, original, original, time.Now()).(*wrp.Message)
+	if assert.True(ok) {
+		assert.True(recorded != original)
+		assert.Len(recorded.Spans, 1)
+		assert.Len(original.Spans, 0)
+	}
+
+	// a private copy is recorded in place
+	copied := copyMessage(original, 0)
+	assert.True(recordedMessage(recorder, original, copied, time.Now()) == copied)
+	assert.Len(copied.Spans, 1)
+
+	// messages the recorder does not record are returned as is
+	unrecorded := new(wrp.Message)
+	assert.True(recordedMessage(recorder, unrecorded, unrecorded, time.Now()) == unrecorded)
+}
+
+func TestManagerHops(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		received     = make(chan *Event, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:      logging.TestLogger(t),
+			HopRecorder: &wrp.HopRecorder{Name: "talaria-1.device"},
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived:
+						copied := *event
+						received <- &copied
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	// outbound messages carry a hop, even when the caller supplied contents
+	original := new(wrp.Message).SetIncludeSpans(true)
+	original.Type = wrp.SimpleEventMessageType
+	original.Destination = "mac:112233445566"
+
+	var contents []byte
+	require.NoError(wrp.NewEncoderBytes(&contents, c.Format()).Encode(original))
+	_, err = d.Send(&Request{Message: original, Format: c.Format(), Contents: contents, ctx: context.Background()})
+	require.NoError(err)
+	assert.Len(original.Spans, 0)
+
+	var frame bytes.Buffer
+	_, err = c.Read(&frame)
+	require.NoError(err)
+
+	outbound := new(wrp.Message)
+	require.NoError(wrp.NewDecoderBytes(frame.Bytes(), c.Format()).Decode(outbound))
+	require.Len(outbound.Spans, 1)
+	hop, err := wrp.ParseHop(outbound.Spans[0])
+	require.NoError(err)
+	assert.Equal("talaria-1.device", hop.Name)
+	assert.Equal(wrp.HopStatusOK, hop.Status)
+
+	// inbound messages get the next hop, in both the message and the raw contents
+	outbound.Source = "mac:112233445566"
+	var encoded []byte
+	require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(outbound))
+	_, err = c.Write(encoded)
+	require.NoError(err)
+
+	inbound := <-received
+	message := inbound.Message.(*wrp.Message)
+	require.Len(message.Spans, 2)
+	hop, err = wrp.ParseHop(message.Spans[1])
+	require.NoError(err)
+	assert.Equal("talaria-1.device", hop.Parent)
+	assert.Equal("talaria-1.device", hop.Name)
+
+	decoded := new(wrp.Message)
+	require.NoError(wrp.NewDecoderBytes(inbound.Contents, c.Format()).Decode(decoded))
+	assert.Equal(message.Spans, decoded.Spans)
+
+	c.Close()
+	<-disconnected
+}
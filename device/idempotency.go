@@ -0,0 +1,129 @@
+package device
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultIdempotencyKeyHeader               = "X-Webpa-Idempotency-Key"
+	DefaultIdempotencyTTL       time.Duration = 5 * time.Minute
+	DefaultIdempotencyCacheSize               = 100
+)
+
+// idempotentOutcome is the result of sending a request with a given idempotency key.
+// The done channel is closed once response and err are available.
+type idempotentOutcome struct {
+	key      string
+	expires  time.Time
+	done     chan struct{}
+	response *Response
+	err      error
+}
+
+// completed tests if the send that owns this outcome has finished
+func (io *idempotentOutcome) completed() bool {
+	select {
+	case <-io.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// idempotencyCache is a small, bounded TTL cache of the outcomes of recently sent requests,
+// keyed by Request.IdempotencyKey.  Instances are safe for concurrent use.
+type idempotencyCache struct {
+	lock     sync.Mutex
+	ttl      time.Duration
+	size     int
+	now      func() time.Time
+	outcomes map[string]*list.Element
+	order    *list.List
+}
+
+func newIdempotencyCache(ttl time.Duration, size int) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:      ttl,
+		size:     size,
+		now:      time.Now,
+		outcomes: make(map[string]*list.Element, size),
+		order:    list.New(),
+	}
+}
+
+// removeElement drops an outcome from the cache.  The lock must be held.
+func (ic *idempotencyCache) removeElement(e *list.Element) {
+	delete(ic.outcomes, e.Value.(*idempotentOutcome).key)
+	ic.order.Remove(e)
+}
+
+// evict makes room for one more outcome by dropping the oldest completed outcomes.  Outcomes still in
+// flight are never evicted, as that would let a retry resend a request that has not finished.  This method
+// returns false if the cache is full of outcomes in flight.  The lock must be held.
+func (ic *idempotencyCache) evict() bool {
+	for e := ic.order.Front(); e != nil && ic.order.Len() >= ic.size; {
+		next := e.Next()
+		if e.Value.(*idempotentOutcome).completed() {
+			ic.removeElement(e)
+		}
+
+		e = next
+	}
+
+	return ic.order.Len() < ic.size
+}
+
+// acquire returns the outcome for the given key, along with whether the caller owns that outcome.
+// An owner must invoke complete once the send finishes.  Non-owners must wait on the outcome's done channel.
+// If there is no outcome for the key and no room for one, ErrorIdempotencyCacheFull is returned.
+func (ic *idempotencyCache) acquire(key string) (*idempotentOutcome, bool, error) {
+	ic.lock.Lock()
+	defer ic.lock.Unlock()
+
+	now := ic.now()
+	if e, ok := ic.outcomes[key]; ok {
+		if now.Before(e.Value.(*idempotentOutcome).expires) {
+			return e.Value.(*idempotentOutcome), false, nil
+		}
+
+		ic.removeElement(e)
+	}
+
+	if !ic.evict() {
+		return nil, false, ErrorIdempotencyCacheFull
+	}
+
+	outcome := &idempotentOutcome{
+		key:     key,
+		expires: now.Add(ic.ttl),
+		done:    make(chan struct{}),
+	}
+
+	ic.outcomes[key] = ic.order.PushBack(outcome)
+	return outcome, true, nil
+}
+
+// complete records the result of a send.  Failed sends are removed from the cache,
+// so that a caller's retry will actually resend the request.
+func (ic *idempotencyCache) complete(outcome *idempotentOutcome, response *Response, err error) {
+	outcome.response, outcome.err = response, err
+	if err != nil {
+		ic.lock.Lock()
+		if e, ok := ic.outcomes[outcome.key]; ok && e.Value == outcome {
+			ic.removeElement(e)
+		}
+
+		ic.lock.Unlock()
+	}
+
+	close(outcome.done)
+}
+
+// Len returns the count of outcomes currently cached, including any that have expired but not been evicted
+func (ic *idempotencyCache) Len() int {
+	ic.lock.Lock()
+	defer ic.lock.Unlock()
+	return ic.order.Len()
+}
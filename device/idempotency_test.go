@@ -0,0 +1,159 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		now    = time.Now()
+		cache  = newIdempotencyCache(time.Minute, 2)
+	)
+
+	cache.now = func() time.Time { return now }
+
+	first, owner, err := cache.acquire("first")
+	assert.True(owner)
+	assert.NoError(err)
+	duplicate, owner, err := cache.acquire("first")
+	assert.False(owner)
+	assert.NoError(err)
+	assert.True(first == duplicate)
+
+	expectedResponse := new(Response)
+	cache.complete(first, expectedResponse, nil)
+	<-duplicate.done
+	assert.True(expectedResponse == duplicate.response)
+	assert.NoError(duplicate.err)
+
+	// failures are not remembered
+	failed, owner, err := cache.acquire("failed")
+	assert.True(owner)
+	assert.NoError(err)
+	cache.complete(failed, nil, errors.New("expected"))
+	assert.Equal(1, cache.Len())
+	retried, owner, err := cache.acquire("failed")
+	assert.True(owner)
+	assert.NoError(err)
+	assert.Equal(2, cache.Len())
+	cache.complete(retried, nil, nil)
+
+	// the oldest outcome is evicted when the cache is full
+	third, owner, err := cache.acquire("third")
+	assert.True(owner)
+	assert.NoError(err)
+	assert.Equal(2, cache.Len())
+	cache.complete(third, nil, nil)
+	_, owner, err = cache.acquire("first")
+	assert.True(owner)
+	assert.NoError(err)
+
+	// expired outcomes are replaced
+	now = now.Add(time.Minute)
+	_, owner, err = cache.acquire("third")
+	assert.True(owner)
+	assert.NoError(err)
+}
+
+func TestIdempotencyCacheInFlight(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cache  = newIdempotencyCache(time.Minute, 2)
+	)
+
+	oldest, owner, err := cache.acquire("oldest")
+	assert.True(owner)
+	assert.NoError(err)
+	completed, owner, err := cache.acquire("completed")
+	assert.True(owner)
+	assert.NoError(err)
+	cache.complete(completed, nil, nil)
+
+	// the completed outcome is evicted, even though the outcome in flight is older
+	_, owner, err = cache.acquire("newest")
+	assert.True(owner)
+	assert.NoError(err)
+	duplicate, owner, err := cache.acquire("oldest")
+	assert.False(owner)
+	assert.NoError(err)
+	assert.True(oldest == duplicate)
+
+	// with every outcome in flight, new keys are turned away rather than evicting a send in progress
+	outcome, owner, err := cache.acquire("rejected")
+	assert.Nil(outcome)
+	assert.False(owner)
+	assert.Equal(ErrorIdempotencyCacheFull, err)
+	assert.Equal(2, cache.Len())
+
+	cache.complete(oldest, nil, nil)
+	_, owner, err = cache.acquire("rejected")
+	assert.True(owner)
+	assert.NoError(err)
+	_, owner, err = cache.acquire("newest")
+	assert.False(owner)
+	assert.NoError(err)
+}
+
+func TestOptionsIdempotency(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Equal(DefaultIdempotencyTTL, o.idempotencyTTL())
+		assert.Equal(DefaultIdempotencyCacheSize, o.idempotencyCacheSize())
+	}
+
+	o := &Options{IdempotencyTTL: -1, IdempotencyCacheSize: 12}
+	assert.Equal(time.Duration(-1), o.idempotencyTTL())
+	assert.Equal(12, o.idempotencyCacheSize())
+}
+
+func TestDeviceSendIdempotent(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(ID("mac:112233445566"), Key("key"), nil, 10)
+		writes = make(chan *Request, 10)
+
+		pumpWait = new(sync.WaitGroup)
+	)
+
+	d.idempotency = newIdempotencyCache(time.Minute, 10)
+
+	// simulate a write pump
+	pumpWait.Add(1)
+	go func() {
+		defer pumpWait.Done()
+		for e := range d.messages[LowPriority] {
+			writes <- e.request
+			close(e.complete)
+		}
+	}()
+
+	for _, key := range []string{"key1", "key1", "key2", "", ""} {
+		response, err := d.Send(&Request{
+			Message:        &wrp.SimpleEvent{Destination: "mac:112233445566/service"},
+			IdempotencyKey: key,
+			ctx:            context.Background(),
+		})
+
+		assert.Nil(response)
+		assert.NoError(err)
+	}
+
+	close(d.messages[LowPriority])
+	pumpWait.Wait()
+	close(writes)
+
+	var keys []string
+	for request := range writes {
+		keys = append(keys, request.IdempotencyKey)
+	}
+
+	assert.Equal([]string{"key1", "key2", "", ""}, keys)
+}
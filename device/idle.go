@@ -0,0 +1,50 @@
+package device
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// IdleCloseReason is the text of the websocket close frame sent to devices disconnected by Options.IdleTimeout
+	IdleCloseReason = "idle timeout"
+)
+
+var (
+	// ErrorIdleTimeout is the read pump error for a device that sent no frames within Options.IdleTimeout
+	ErrorIdleTimeout = errors.New("The device has been idle too long")
+)
+
+// readDeadline computes the deadline for the next frame header.  Pongs extend the idlePeriod, but when
+// an idleTimeout is set the deadline never moves past that timeout as measured from the last frame.
+func (c *connection) readDeadline(now time.Time) time.Time {
+	deadline := now.Add(c.idlePeriod)
+	if c.idleTimeout > 0 {
+		if idle := c.lastFrame.Add(c.idleTimeout); idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+
+	return deadline
+}
+
+// frameStarted records the arrival of a frame header, and limits the time allowed to read the rest of
+// the frame to the readTimeout, if one is set
+func (c *connection) frameStarted(now time.Time) error {
+	c.lastFrame = now
+	if c.readTimeout > 0 {
+		return c.webSocket.SetReadDeadline(now.Add(c.readTimeout))
+	}
+
+	return nil
+}
+
+// idleError translates a read timeout into ErrorIdleTimeout, when the idleTimeout is what expired
+func (c *connection) idleError(err error) error {
+	if netError, ok := err.(net.Error); ok && netError.Timeout() && c.idleTimeout > 0 && time.Since(c.lastFrame) >= c.idleTimeout {
+		return ErrorIdleTimeout
+	}
+
+	return err
+}
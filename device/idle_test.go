@@ -0,0 +1,128 @@
+package device
+
+import (
+	"bytes"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionReadDeadline(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		now    = time.Now()
+		c      = &connection{idlePeriod: time.Minute, lastFrame: now.Add(-time.Hour)}
+	)
+
+	assert.Equal(now.Add(time.Minute), c.readDeadline(now))
+
+	c.idleTimeout = 2 * time.Hour
+	assert.Equal(now.Add(time.Minute), c.readDeadline(now))
+
+	// pongs cannot extend the deadline past the idle timeout
+	c.idleTimeout = time.Hour + 30*time.Second
+	assert.Equal(now.Add(30*time.Second), c.readDeadline(now))
+}
+
+// newDisconnectServer starts a websocket server that reports each device's disconnection error
+func newDisconnectServer(t *testing.T, o *Options) (chan error, func(), string) {
+	disconnected := make(chan error, 1)
+	o.Logger = logging.TestLogger(t)
+	o.Listeners = []Listener{
+		func(event *Event) {
+			if event.Type == Disconnect {
+				disconnected <- event.Error
+			}
+		},
+	}
+
+	_, server, connectURL := startWebsocketServer(o)
+	return disconnected, server.Close, connectURL
+}
+
+func TestManagerIdleTimeout(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		disconnected, closeServer, connectURL = newDisconnectServer(t, &Options{
+			PingPeriod:  20 * time.Millisecond,
+			IdleTimeout: 200 * time.Millisecond,
+		})
+	)
+
+	defer closeServer()
+
+	c, _, err := NewDialer(nil, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	defer c.Close()
+
+	// reading from the device answers the server's pings, which does not count as activity
+	readError := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := c.Read(new(bytes.Buffer)); err != nil {
+				readError <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-disconnected:
+		assert.Equal(ErrorIdleTimeout, err)
+	case <-time.After(5 * time.Second):
+		require.Fail("The idle device was not disconnected")
+	}
+
+	err = <-readError
+	if closeError, ok := err.(*websocket.CloseError); assert.True(ok, "%v", err) {
+		assert.Equal(websocket.ClosePolicyViolation, closeError.Code)
+		assert.Equal(IdleCloseReason, closeError.Text)
+	}
+}
+
+func TestManagerReadTimeout(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		disconnected, closeServer, connectURL = newDisconnectServer(t, &Options{
+			ReadTimeout: 100 * time.Millisecond,
+		})
+
+		conn          net.Conn
+		networkDialer = websocket.Dialer{
+			NetDial: func(network, address string) (net.Conn, error) {
+				var err error
+				conn, err = net.Dial(network, address)
+				return conn, err
+			},
+		}
+	)
+
+	defer closeServer()
+
+	c, _, err := NewDialer(nil, &networkDialer).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	defer c.Close()
+
+	// a masked binary frame header that promises 100 bytes, followed by only a few of them
+	_, err = conn.Write([]byte{0x82, 0x80 | 100, 1, 2, 3, 4, 'p', 'a', 'r', 't'})
+	require.NoError(err)
+
+	select {
+	case err := <-disconnected:
+		if netError, ok := err.(net.Error); assert.True(ok, "%v", err) {
+			assert.True(netError.Timeout())
+		}
+
+		assert.NotEqual(ErrorIdleTimeout, err)
+	case <-time.After(5 * time.Second):
+		require.Fail("The stalled device was not disconnected")
+	}
+}
@@ -0,0 +1,279 @@
+package device
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/httperror"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/ugorji/go/codec"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultInspectPath  = "/inspect/devices"
+	DefaultInspectLimit = 100
+	MaxInspectLimit     = 1000
+
+	// InspectOffsetParameter and InspectLimitParameter select a page of the device list served by InspectHandler
+	InspectOffsetParameter = "offset"
+	InspectLimitParameter  = "limit"
+
+	// InspectIDParameter filters the device list served by InspectHandler to the IDs beginning with its value
+	InspectIDParameter = "id"
+
+	// InspectKeyParameter restricts a disconnection by InspectHandler to the single device with the given key
+	InspectKeyParameter = "key"
+)
+
+// inspectedDevice is the JSON representation of a device reported by InspectHandler
+type inspectedDevice struct {
+	ID                 ID                 `json:"id"`
+	Key                Key                `json:"key"`
+	ConnectedAt        time.Time          `json:"connectedAt"`
+	ConnectionDuration string             `json:"connectionDuration"`
+	Closed             bool               `json:"closed"`
+	SessionID          string             `json:"sessionId,omitempty"`
+	Tags               Tags               `json:"tags,omitempty"`
+	Convey             json.RawMessage    `json:"convey"`
+	ConveyError        string             `json:"conveyError,omitempty"`
+	Pending            int                `json:"pending"`
+	Transactions       []debugTransaction `json:"transactions"`
+	Statistics         Statistics         `json:"statistics"`
+}
+
+func newInspectedDevice(d Interface) inspectedDevice {
+	var (
+		pending                      = d.PendingTransactions()
+		transactions                 = make([]debugTransaction, len(pending))
+		convey       json.RawMessage = nullConvey
+	)
+
+	for i, p := range pending {
+		transactions[i] = debugTransaction{p.Key, p.RegisteredAt, p.Age.String()}
+	}
+
+	if c := d.Convey(); c != nil {
+		var encoded []byte
+		if err := codec.NewEncoderBytes(&encoded, conveyHandle).Encode(c); err == nil {
+			convey = encoded
+		} else {
+			convey = jsonString(err.Error())
+		}
+	} else if raw := d.RawConvey(); len(raw) > 0 {
+		convey = jsonString(raw)
+	}
+
+	inspected := inspectedDevice{
+		ID:                 d.ID(),
+		Key:                d.Key(),
+		ConnectedAt:        d.ConnectedAt(),
+		ConnectionDuration: d.ConnectionDuration().String(),
+		Closed:             d.Closed(),
+		SessionID:          d.SessionID(),
+		Tags:               d.Tags(),
+		Convey:             convey,
+		Pending:            d.Pending(),
+		Transactions:       transactions,
+		Statistics:         d.Statistics(),
+	}
+
+	if err := d.ConveyError(); err != nil {
+		inspected.ConveyError = err.Error()
+	}
+
+	return inspected
+}
+
+// inspectedList is the JSON representation of a page of the device list
+type inspectedList struct {
+	Total   int               `json:"total"`
+	Offset  int               `json:"offset"`
+	Limit   int               `json:"limit"`
+	Devices []inspectedDevice `json:"devices"`
+}
+
+// InspectHandler is the administrative API for examining and disconnecting the devices of a Manager.
+// It expects to be mounted with its path prefix stripped, e.g. via http.StripPrefix, and serves:
+//
+//	GET    /      a page of connected devices, sorted by ID and key.  The offset and limit query parameters
+//	              select the page, and the id query parameter filters the devices to IDs with that prefix.
+//	GET    /{id}  every device connected with the given ID
+//	DELETE /{id}  disconnects every device with the given ID, or only the one with the key query parameter
+//
+// Unlike ListHandler, the list is computed on each request rather than on an interval.
+type InspectHandler struct {
+	Logger  logging.Logger
+	Manager Manager
+
+	// DefaultLimit is the page size used when none is requested.  If nonpositive, DefaultInspectLimit is used.
+	DefaultLimit int
+}
+
+func (ih *InspectHandler) logger() logging.Logger {
+	if ih.Logger != nil {
+		return ih.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+func (ih *InspectHandler) defaultLimit() int {
+	if ih.DefaultLimit > 0 {
+		return ih.DefaultLimit
+	}
+
+	return DefaultInspectLimit
+}
+
+func (ih *InspectHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	path := strings.Trim(request.URL.Path, "/")
+	if len(path) == 0 {
+		if request.Method != http.MethodGet {
+			response.Header().Set("Allow", http.MethodGet)
+			httperror.Formatf(response, http.StatusMethodNotAllowed, "Unsupported method: %s", request.Method)
+			return
+		}
+
+		ih.list(response, request)
+		return
+	}
+
+	id, err := ParseID(path)
+	if err != nil {
+		httperror.Formatf(response, http.StatusBadRequest, "Invalid device id: %s", err)
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		ih.get(response, id)
+	case http.MethodDelete:
+		ih.disconnect(response, request, id)
+	default:
+		response.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+		httperror.Formatf(response, http.StatusMethodNotAllowed, "Unsupported method: %s", request.Method)
+	}
+}
+
+// intParameter returns the value of an optional, nonnegative integer query parameter.  If the value
+// is invalid, an error response is written and this function returns false.
+func intParameter(response http.ResponseWriter, request *http.Request, name string, defaultValue int) (int, bool) {
+	value := request.URL.Query().Get(name)
+	if len(value) == 0 {
+		return defaultValue, true
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		httperror.Formatf(response, http.StatusBadRequest, "Invalid %s: %s", name, value)
+		return 0, false
+	}
+
+	return parsed, true
+}
+
+func (ih *InspectHandler) list(response http.ResponseWriter, request *http.Request) {
+	offset, ok := intParameter(response, request, InspectOffsetParameter, 0)
+	if !ok {
+		return
+	}
+
+	limit, ok := intParameter(response, request, InspectLimitParameter, ih.defaultLimit())
+	if !ok {
+		return
+	}
+
+	if limit == 0 || limit > MaxInspectLimit {
+		limit = MaxInspectLimit
+	}
+
+	var (
+		prefix  = request.URL.Query().Get(InspectIDParameter)
+		matched []Interface
+	)
+
+	ih.Manager.VisitIf(
+		func(id ID) bool { return strings.HasPrefix(string(id), prefix) },
+		func(d Interface) { matched = append(matched, d) },
+	)
+
+	// a stable order lets successive pages be requested while devices come and go
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].ID() == matched[j].ID() {
+			return matched[i].Key() < matched[j].Key()
+		}
+
+		return matched[i].ID() < matched[j].ID()
+	})
+
+	page := inspectedList{
+		Total:   len(matched),
+		Offset:  offset,
+		Limit:   limit,
+		Devices: make([]inspectedDevice, 0, limit),
+	}
+
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		page.Devices = append(page.Devices, newInspectedDevice(matched[i]))
+	}
+
+	ih.writeJSON(response, page)
+}
+
+func (ih *InspectHandler) get(response http.ResponseWriter, id ID) {
+	devices := make([]inspectedDevice, 0, 1)
+	ih.Manager.VisitIf(
+		func(candidate ID) bool { return candidate == id },
+		func(d Interface) { devices = append(devices, newInspectedDevice(d)) },
+	)
+
+	if len(devices) == 0 {
+		httperror.Formatf(response, http.StatusNotFound, "No such device: %s", id)
+		return
+	}
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Key < devices[j].Key })
+	ih.writeJSON(response, map[string][]inspectedDevice{"devices": devices})
+}
+
+func (ih *InspectHandler) disconnect(response http.ResponseWriter, request *http.Request, id ID) {
+	var count int
+	if key := Key(request.URL.Query().Get(InspectKeyParameter)); len(key) > 0 {
+		// the key must belong to the device in the path
+		ih.Manager.VisitIf(
+			func(candidate ID) bool { return candidate == id },
+			func(d Interface) {
+				if d.Key() == key {
+					d.RequestClose()
+					count++
+				}
+			},
+		)
+	} else {
+		count = ih.Manager.Disconnect(id)
+	}
+
+	if count == 0 {
+		httperror.Formatf(response, http.StatusNotFound, "No such device: %s", id)
+		return
+	}
+
+	ih.logger().Info("Disconnected %d device(s) with id [%s] via the admin API", count, id)
+	ih.writeJSON(response, map[string]int{"disconnected": count})
+}
+
+func (ih *InspectHandler) writeJSON(response http.ResponseWriter, value interface{}) {
+	output, err := json.Marshal(value)
+	if err != nil {
+		ih.logger().Error("Unable to marshal inspection output: %s", err)
+		httperror.Formatf(response, http.StatusInternalServerError, "Unable to marshal inspection output: %s", err)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	response.Write(output)
+}
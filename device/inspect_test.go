@@ -0,0 +1,160 @@
+package device
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// newTestInspectHandler creates an InspectHandler for a manager with devices mac:000000000000 through
+// mac:000000000009, plus a duplicate of mac:000000000005
+func newTestInspectHandler(t *testing.T) (*InspectHandler, *manager) {
+	m := NewManager(&Options{Logger: logging.TestLogger(t)}, nil).(*manager)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, m.registry.add(newDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), Convey{"index": i}, 1)))
+	}
+
+	require.NoError(t, m.registry.add(newDevice(IntToMAC(5), Key("duplicate"), nil, 1)))
+	return &InspectHandler{Logger: logging.TestLogger(t), Manager: m}, m
+}
+
+func serveInspect(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+	return response
+}
+
+func testInspectHandlerList(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		handler, _ = newTestInspectHandler(t)
+		page       inspectedList
+	)
+
+	response := serveInspect(handler, "GET", "/")
+	require.Equal(http.StatusOK, response.Code)
+	assert.Equal("application/json", response.Header().Get("Content-Type"))
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &page))
+	assert.Equal(11, page.Total)
+	assert.Equal(DefaultInspectLimit, page.Limit)
+	assert.Len(page.Devices, 11)
+	assert.Equal(IntToMAC(0), page.Devices[0].ID)
+	assert.JSONEq(`{"index": 0}`, string(page.Devices[0].Convey))
+
+	// pages are sorted by ID, then key
+	response = serveInspect(handler, "GET", "/?offset=5&limit=3")
+	require.Equal(http.StatusOK, response.Code)
+	page = inspectedList{}
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &page))
+	assert.Equal(11, page.Total)
+	assert.Equal(5, page.Offset)
+	assert.Equal(3, page.Limit)
+	require.Len(page.Devices, 3)
+	assert.Equal([]Key{"5", "duplicate", "6"}, []Key{page.Devices[0].Key, page.Devices[1].Key, page.Devices[2].Key})
+
+	response = serveInspect(handler, "GET", "/?offset=100")
+	require.Equal(http.StatusOK, response.Code)
+	page = inspectedList{}
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &page))
+	assert.Equal(11, page.Total)
+	assert.Empty(page.Devices)
+
+	response = serveInspect(handler, "GET", "/?id=mac:00000000000&limit=0")
+	require.Equal(http.StatusOK, response.Code)
+	page = inspectedList{}
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &page))
+	assert.Equal(11, page.Total)
+	assert.Equal(MaxInspectLimit, page.Limit)
+
+	response = serveInspect(handler, "GET", "/?id=mac:000000000005")
+	require.Equal(http.StatusOK, response.Code)
+	page = inspectedList{}
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &page))
+	assert.Equal(2, page.Total)
+
+	for _, query := range []string{"offset=-1", "offset=x", "limit=-5", "limit=x"} {
+		response = serveInspect(handler, "GET", "/?"+query)
+		assert.Equal(http.StatusBadRequest, response.Code, query)
+	}
+
+	response = serveInspect(handler, "DELETE", "/")
+	assert.Equal(http.StatusMethodNotAllowed, response.Code)
+	assert.Equal(http.MethodGet, response.Header().Get("Allow"))
+}
+
+func testInspectHandlerGet(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		handler, _ = newTestInspectHandler(t)
+		result     map[string][]inspectedDevice
+	)
+
+	response := serveInspect(handler, "GET", "/mac:000000000005")
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &result))
+	require.Len(result["devices"], 2)
+	assert.Equal(Key("5"), result["devices"][0].Key)
+	assert.Equal(Key("duplicate"), result["devices"][1].Key)
+	assert.Equal("null", string(result["devices"][1].Convey))
+	assert.False(result["devices"][0].Closed)
+
+	response = serveInspect(handler, "GET", "/mac:0000000000ff")
+	assert.Equal(http.StatusNotFound, response.Code)
+
+	response = serveInspect(handler, "GET", "/invalid")
+	assert.Equal(http.StatusBadRequest, response.Code)
+
+	response = serveInspect(handler, "PUT", "/mac:000000000005")
+	assert.Equal(http.StatusMethodNotAllowed, response.Code)
+}
+
+func testInspectHandlerDisconnect(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		handler, m = newTestInspectHandler(t)
+		result     map[string]int
+		closed     = func(id ID) (keys []Key) {
+			for _, d := range m.registry.devices(id) {
+				if d.Closed() {
+					keys = append(keys, d.Key())
+				}
+			}
+
+			return
+		}
+	)
+
+	// the key must belong to the device in the path
+	response := serveInspect(handler, "DELETE", "/mac:000000000004?key=duplicate")
+	assert.Equal(http.StatusNotFound, response.Code)
+	assert.Empty(closed(IntToMAC(4)))
+
+	response = serveInspect(handler, "DELETE", "/mac:000000000005?key=duplicate")
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(1, result["disconnected"])
+	assert.Equal([]Key{"duplicate"}, closed(IntToMAC(5)))
+
+	response = serveInspect(handler, "DELETE", "/mac:000000000007")
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(1, result["disconnected"])
+	assert.Equal([]Key{"7"}, closed(IntToMAC(7)))
+
+	response = serveInspect(handler, "DELETE", "/mac:0000000000ff")
+	assert.Equal(http.StatusNotFound, response.Code)
+}
+
+func TestInspectHandler(t *testing.T) {
+	t.Run("List", testInspectHandlerList)
+	t.Run("Get", testInspectHandlerGet)
+	t.Run("Disconnect", testInspectHandlerDisconnect)
+}
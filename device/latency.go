@@ -0,0 +1,61 @@
+package device
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// queueLatencyWindow is the span of time over which each device reports its maximum queueing delay
+	queueLatencyWindow time.Duration = time.Minute
+)
+
+// recentMax tracks the maximum of a duration observed over a sliding pair of windows.  The reported
+// value covers at least the last window's worth of observations, and at most two windows.
+// Instances are safe for concurrent use.
+type recentMax struct {
+	lock     sync.Mutex
+	window   time.Duration
+	start    time.Time
+	current  time.Duration
+	previous time.Duration
+}
+
+// roll advances the windows, if necessary.  The lock must be held.
+func (rm *recentMax) roll(now time.Time) {
+	elapsed := now.Sub(rm.start)
+	if elapsed < rm.window {
+		return
+	}
+
+	if elapsed < 2*rm.window {
+		rm.previous = rm.current
+	} else {
+		rm.previous = 0
+	}
+
+	rm.current = 0
+	rm.start = now
+}
+
+func (rm *recentMax) observe(now time.Time, value time.Duration) {
+	rm.lock.Lock()
+	rm.roll(now)
+	if value > rm.current {
+		rm.current = value
+	}
+
+	rm.lock.Unlock()
+}
+
+func (rm *recentMax) value(now time.Time) time.Duration {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	rm.roll(now)
+	if rm.previous > rm.current {
+		return rm.previous
+	}
+
+	return rm.current
+}
@@ -0,0 +1,31 @@
+package device
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRecentMax(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		start  = time.Now()
+		rm     = recentMax{window: time.Minute}
+	)
+
+	assert.Equal(time.Duration(0), rm.value(start))
+
+	rm.observe(start, 2*time.Second)
+	rm.observe(start.Add(time.Second), time.Second)
+	assert.Equal(2*time.Second, rm.value(start.Add(time.Second)))
+
+	// the previous window's maximum is still reported
+	rm.observe(start.Add(time.Minute), 500*time.Millisecond)
+	assert.Equal(2*time.Second, rm.value(start.Add(time.Minute+time.Second)))
+
+	// the original maximum ages out
+	assert.Equal(500*time.Millisecond, rm.value(start.Add(2*time.Minute)))
+
+	// after two windows with no observations, nothing is reported
+	assert.Equal(time.Duration(0), rm.value(start.Add(5*time.Minute)))
+}
@@ -2,6 +2,7 @@ package device
 
 import (
 	"github.com/Comcast/webpa-common/wrp"
+	"time"
 )
 
 // EventType is the type of device-related event
@@ -39,6 +40,30 @@ const (
 	// Pong occurs when a device has responded to a ping
 	Pong
 
+	// RateLimited indicates that a frame from a device exceeded the device's rate limit.  The frame
+	// is not decoded, so the Message and Contents fields are not set.  The Error field is ErrorRateLimited.
+	RateLimited
+
+	// DrainProgress indicates that a Manager.Drain has requested that the event's Device disconnect.
+	// The Drained and DrainTotal fields report the progress of the drain.
+	DrainProgress
+
+	// KeyChanged indicates that Manager.RotateKey changed the event's Device's routing Key.  The
+	// PreviousKey field holds the Key the device had before the rotation.
+	KeyChanged
+
+	// DeviceLimitReached indicates that a Manager has reached its HardDeviceLimit.  The DeviceCount field holds
+	// the count of connected devices.  This event concerns the Manager as a whole, so the Device field is not set.
+	DeviceLimitReached
+
+	// DeviceLimitRecovered indicates that the device count of a Manager which had reached its HardDeviceLimit has
+	// fallen to its DeviceLimitRecovery.  As with DeviceLimitReached, only the DeviceCount field is set.
+	DeviceLimitRecovered
+
+	// Evicted indicates that the event's Device was disconnected, under the EvictIdleOverLimit policy, to make
+	// room for a new device.  A Disconnect event follows once the device's connection is closed.
+	Evicted
+
 	InvalidEventString string = "!!INVALID DEVICE EVENT TYPE!!"
 )
 
@@ -65,6 +90,18 @@ func (et EventType) String() string {
 		return "TransactionBroken"
 	case Pong:
 		return "Pong"
+	case RateLimited:
+		return "RateLimited"
+	case DrainProgress:
+		return "DrainProgress"
+	case KeyChanged:
+		return "KeyChanged"
+	case DeviceLimitReached:
+		return "DeviceLimitReached"
+	case DeviceLimitRecovered:
+		return "DeviceLimitRecovered"
+	case Evicted:
+		return "Evicted"
 	default:
 		return InvalidEventString
 	}
@@ -79,7 +116,7 @@ type Event struct {
 	Type EventType
 
 	// Device refers to the device, possibly disconnected, for which this event is being set.
-	// This field is always set.
+	// This field is always set, except for DeviceLimitReached and DeviceLimitRecovered events.
 	Device Interface
 
 	// Message is the WRP message relevant to this event.  This field is only set for
@@ -98,16 +135,42 @@ type Event struct {
 	//
 	// Never assume that it is safe to use this byte slice outside the listener invocation.  Make
 	// a copy if this byte slice is needed by other goroutines or if it needs to be part of a long-lived
-	// data structure.
+	// data structure.  For messages received from a device, this is the frame read from the websocket,
+	// whose buffer is reused for other frames once the listeners return.
 	Contents []byte
 
 	// Error is the error which occurred during an attempt to send a message.  This field is only populated
 	// for MessageFailed events when there was an actual error.  For MessageFailed events that indicate a
 	// device was disconnected with enqueued messages, this field will be nil.
+	//
+	// For MessageReceived and TransactionComplete events, this field holds the signature verification
+	// error of a message delivered under the FlagBadSignature policy.
 	Error error
 
 	// Data is the pong data associated with this event.  This field is only set for a Pong event.
 	Data string
+
+	// RTT is the round-trip time of the ping answered by a Pong event.  This field is zero for any
+	// other event, and for a pong that does not answer a ping sent by the Manager.
+	RTT time.Duration
+
+	// Replayed indicates a synthetic Connect event sent to a Listener added via Subscriber.Subscribe,
+	// for a device that was already connected when the subscription was made
+	Replayed bool
+
+	// Drained is the count of devices a Manager.Drain has disconnected so far, including this event's
+	// Device.  DrainTotal is the count of devices that were connected when the drain started.  These
+	// fields are only set for DrainProgress events.
+	Drained    int
+	DrainTotal int
+
+	// PreviousKey is the routing Key the Device had before a rotation.  This field is only set for
+	// KeyChanged events.
+	PreviousKey Key
+
+	// DeviceCount is the count of devices connected to the Manager.  This field is only set for
+	// DeviceLimitReached and DeviceLimitRecovered events.
+	DeviceCount int
 }
 
 // Clear resets all fields in this Event.  This is most often in preparation to reuse the Event instance.
@@ -119,6 +182,12 @@ func (e *Event) Clear() {
 	e.Contents = nil
 	e.Error = nil
 	e.Data = emptyString
+	e.RTT = 0
+	e.Replayed = false
+	e.Drained = 0
+	e.DrainTotal = 0
+	e.PreviousKey = invalidKey
+	e.DeviceCount = 0
 }
 
 // Listener is an event sink.  Listeners should never modify events and should never
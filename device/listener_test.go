@@ -5,6 +5,7 @@ import (
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func testEventString(t *testing.T) {
@@ -20,6 +21,12 @@ func testEventString(t *testing.T) {
 			TransactionComplete,
 			TransactionBroken,
 			Pong,
+			RateLimited,
+			DrainProgress,
+			KeyChanged,
+			DeviceLimitReached,
+			DeviceLimitRecovered,
+			Evicted,
 		}
 	)
 
@@ -44,6 +51,10 @@ func testEventClear(t *testing.T, event Event) {
 	assert.Nil(event.Contents)
 	assert.Nil(event.Error)
 	assert.Empty(event.Data)
+	assert.False(event.Replayed)
+	assert.Zero(event.Drained)
+	assert.Zero(event.DrainTotal)
+	assert.Zero(event.DeviceCount)
 }
 
 func TestEvent(t *testing.T) {
@@ -86,6 +97,22 @@ func TestEvent(t *testing.T) {
 				Type:   Pong,
 				Device: device,
 				Data:   "some pong data",
+				RTT:    15 * time.Millisecond,
+			},
+			Event{
+				Type:       DrainProgress,
+				Device:     device,
+				Drained:    3,
+				DrainTotal: 10,
+			},
+			Event{
+				Type:        KeyChanged,
+				Device:      device,
+				PreviousKey: Key("previous"),
+			},
+			Event{
+				Type:        DeviceLimitReached,
+				DeviceCount: 100,
 			},
 		}
 	)
@@ -0,0 +1,27 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+)
+
+const (
+	// DeviceIDLogKey is the key of the log field, attached by each device's Logger, that holds the device's ID
+	DeviceIDLogKey = "deviceID"
+
+	// DeviceKeyLogKey is the key of the log field that holds the device's current Key
+	DeviceKeyLogKey = "deviceKey"
+
+	// RemoteAddrLogKey is the key of the log field that holds the remote address of the device's connection
+	RemoteAddrLogKey = "remoteAddr"
+)
+
+// newDeviceLogger creates the child logger for a device, which attaches the device's identity to every entry.
+// The key is computed only when an entry is written, so it stays accurate when the device's key is rotated.
+func newDeviceLogger(parent logging.Logger, d *device, remoteAddr string) logging.Logger {
+	return logging.With(
+		parent,
+		DeviceIDLogKey, d.id,
+		DeviceKeyLogKey, logging.Lazy(func() interface{} { return d.Key() }),
+		RemoteAddrLogKey, remoteAddr,
+	)
+}
@@ -0,0 +1,125 @@
+package device
+
+import (
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logCapture is a logging.RecordSink that keeps every record it receives
+type logCapture struct {
+	lock    sync.Mutex
+	records []logging.Record
+}
+
+func (lc *logCapture) WriteRecord(r logging.Record) error {
+	lc.lock.Lock()
+	lc.records = append(lc.records, r)
+	lc.lock.Unlock()
+	return nil
+}
+
+// find returns the fields, formatted as text, of the first record with the given message
+func (lc *logCapture) find(message string) (map[string]string, bool) {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	for _, r := range lc.records {
+		if r.Message == message {
+			fields := make(map[string]string, len(r.Fields))
+			for _, f := range r.Fields {
+				fields[f.Key] = fmt.Sprint(f.Value)
+			}
+
+			return fields, true
+		}
+	}
+
+	return nil, false
+}
+
+func TestDeviceDefaultLogger(t *testing.T) {
+	assert.NotNil(t, newDevice(ID("mac:112233445566"), Key("key"), nil, 1).Logger())
+}
+
+func TestNewDeviceLogger(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		capture = new(logCapture)
+		d       = newDevice(ID("mac:112233445566"), Key("initial"), nil, 1)
+		logger  = newDeviceLogger(logging.NewStructuredLogger(logging.DebugLevel, capture), d, "127.0.0.1:1234")
+	)
+
+	logger.Info("first")
+	fields, ok := capture.find("first")
+	require.True(ok)
+	assert.Equal(map[string]string{DeviceIDLogKey: "mac:112233445566", DeviceKeyLogKey: "initial", RemoteAddrLogKey: "127.0.0.1:1234"}, fields)
+
+	// the key is current as of each entry
+	d.updateKey(Key("rotated"))
+	logger.Error("second: %s", "printf")
+	fields, ok = capture.find("second: printf")
+	require.True(ok)
+	assert.Equal("rotated", fields[DeviceKeyLogKey])
+}
+
+func TestManagerDeviceLogger(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		capture = new(logCapture)
+
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger: logging.NewStructuredLogger(logging.DebugLevel, capture),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						event.Device.Logger().Info("listener")
+						connected <- event.Device
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		id                    = ID("mac:112233445566")
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, id, nil, nil)
+	require.NoError(err)
+
+	var d Interface
+	select {
+	case d = <-connected:
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not connect")
+	}
+
+	c.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not disconnect")
+	}
+
+	for _, message := range []string{"listener", fmt.Sprintf("readPump(%s)", id), fmt.Sprintf("writePump(%s)", id)} {
+		fields, ok := capture.find(message)
+		if assert.True(ok, "message: %s", message) {
+			assert.Equal(string(id), fields[DeviceIDLogKey], "message: %s", message)
+			assert.Equal(string(d.Key()), fields[DeviceKeyLogKey], "message: %s", message)
+			assert.NotEmpty(fields[RemoteAddrLogKey], "message: %s", message)
+		}
+	}
+}
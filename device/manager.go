@@ -1,11 +1,18 @@
 package device
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"github.com/Comcast/webpa-common/httperror"
+	"github.com/Comcast/webpa-common/gate"
+	"github.com/Comcast/webpa-common/health"
 	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/secure"
+	"github.com/Comcast/webpa-common/spool"
+	"github.com/Comcast/webpa-common/tracing"
 	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
 	"sync"
@@ -17,7 +24,8 @@ import (
 // for explicit disconnection.
 type Connector interface {
 	// Connect upgrade an HTTP connection to a websocket and begins concurrent
-	// managment of the device.
+	// managment of the device.  If the upgrade is refused, the returned error
+	// is a *Rejection and the response has already been written.
 	Connect(http.ResponseWriter, *http.Request, http.Header) (Interface, error)
 
 	// Disconnect disconnects all devices (including duplicates) which connected
@@ -66,14 +74,27 @@ type Registry interface {
 	// No methods on this Manager should be called from within the visitor function, or
 	// a deadlock will likely occur.
 	VisitAll(func(Interface)) int
+
+	// VisitTag applies the given visitor function to each device that has the given tag, which is
+	// maintained in an index as devices connect and disconnect.  Devices are tagged by the Manager's
+	// ConnectAuthorizer and ConveyTags.
+	//
+	// No methods on this Manager should be called from within the visitor function, or
+	// a deadlock will likely occur.
+	VisitTag(Tag, func(Interface)) int
 }
 
 // Manager supplies a hub for connecting and disconnecting devices as well as
 // an access point for obtaining device metadata.
 type Manager interface {
 	Connector
+	Drainer
+	KeyRotator
 	Router
+	Broadcaster
 	Registry
+	Subscriber
+	RPCRegistry
 }
 
 // NewManager constructs a Manager from a set of options.  A ConnectionFactory will be
@@ -83,21 +104,77 @@ func NewManager(o *Options, cf ConnectionFactory) Manager {
 		cf = NewConnectionFactory(o)
 	}
 
+	missingDeviceNameError := ErrorMissingDeviceName
+	if o == nil || len(o.DeviceNameSources) == 0 {
+		missingDeviceNameError = fmt.Errorf("Missing header: %s", o.deviceNameHeader())
+	}
+
 	m := &manager{
 		logger: o.logger(),
 
-		deviceNameHeader:             o.deviceNameHeader(),
-		missingDeviceNameHeaderError: fmt.Errorf("Missing header: %s", o.deviceNameHeader()),
+		deviceNameSource:       o.deviceNameSource(),
+		missingDeviceNameError: missingDeviceNameError,
 
-		conveyHeader: o.conveyHeader(),
+		conveySource:  o.conveySource(),
+		maxConveySize: o.maxConveySize(),
+		conveyPolicy:  o.conveyPolicy(),
+		conveyCodec:   o.conveyCodec(),
 
-		connectionFactory:      cf,
-		keyFunc:                o.keyFunc(),
-		registry:               newRegistry(o.initialCapacity()),
-		deviceMessageQueueSize: o.deviceMessageQueueSize(),
-		pingPeriod:             o.pingPeriod(),
+		connectionFactory: cf,
+		keyFunc:           o.keyFunc(),
+		connectAuthorizer: o.connectAuthorizer(),
+		conveyTagKeys:     o.conveyTags(),
+		registry:          newShardedRegistry(o.initialCapacity(), o.registryShards()),
+		messageQueueSizes: o.priorityQueueSizes(),
+		queuePolicy:       o.queuePolicy(),
+		pingPeriod:        o.pingPeriod(),
 
 		listeners: o.listeners(),
+		metrics:   newManagerMetrics(o.metricsProvider()),
+		tracer:    o.tracerProvider().Tracer(TracerName),
+		gate:      o.gate(),
+		backoff:   o.backoff(),
+
+		transactionTracer: o.transactionTracer(),
+
+		messageSpool:           o.spool(),
+		queueDrainHandler:      o.queueDrainHandler(),
+		decodeFailureThreshold: o.decodeFailureThreshold(),
+
+		sessionGracePeriod: o.sessionGracePeriod(),
+		sessionHeader:      o.sessionHeader(),
+		sessions:           make(map[string]*session),
+
+		idempotencyTTL:       o.idempotencyTTL(),
+		idempotencyCacheSize: o.idempotencyCacheSize(),
+
+		transactionTTL:           o.transactionTTL(),
+		transactionSweepInterval: o.transactionSweepInterval(),
+
+		newSlowConsumerDetector: o.slowConsumerDetector,
+		slowConsumerPolicy:      o.slowConsumerPolicy(),
+
+		rateLimiterFactory: o.rateLimiterFactory(),
+		rateLimitPolicy:    o.rateLimitPolicy(),
+
+		signer:          o.signer(),
+		verifier:        o.verifier(),
+		signaturePolicy: o.signaturePolicy(),
+		validator:       o.messageValidator(),
+
+		capacity: o.capacity(),
+		health:   o.health(),
+
+		responseRouter: o.responseRouter(),
+		dispatchPolicy: o.dispatchPolicy(),
+		hopRecorder:    o.hopRecorder(),
+		instanceID:     o.instanceID(),
+		rpcHandlers:    newRPCHandlers(o.rpcHandlers()),
+
+		broadcastConcurrency: o.broadcastConcurrency(),
+		ackPolicy:            o.ackPolicy(),
+		batchPolicy:          o.batchPolicy(),
+		batchHeader:          o.batchHeader(),
 	}
 
 	return m
@@ -107,104 +184,266 @@ func NewManager(o *Options, cf ConnectionFactory) Manager {
 type manager struct {
 	logger logging.Logger
 
-	deviceNameHeader             string
-	missingDeviceNameHeaderError error
+	deviceNameSource       Source
+	missingDeviceNameError error
 
-	conveyHeader string
+	conveySource  Source
+	maxConveySize int
+	conveyPolicy  ConveyPolicy
+	conveyCodec   ConveyCodec
 
 	connectionFactory ConnectionFactory
 	keyFunc           KeyFunc
+	connectAuthorizer ConnectAuthorizer
+	conveyTagKeys     []string
 
-	lock     sync.RWMutex
-	registry *registry
+	registry *shardedRegistry
 
-	deviceMessageQueueSize int
-	pingPeriod             time.Duration
+	messageQueueSizes [priorityClasses]int
+	queuePolicy       QueuePolicy
+	pingPeriod        time.Duration
 
 	listeners []Listener
+	metrics   managerMetrics
+	tracer    trace.Tracer
+	gate      gate.Interface
+	backoff   gate.BackoffPolicy
+
+	transactionTracer TransactionTracer
+
+	subscriptionLock sync.RWMutex
+	subscriptions    []*subscription
+
+	messageSpool           spool.Interface
+	queueDrainHandler      QueueDrainHandler
+	decodeFailureThreshold int
+
+	sessionGracePeriod time.Duration
+	sessionHeader      string
+	sessionLock        sync.Mutex
+	sessions           map[string]*session
+
+	idempotencyTTL       time.Duration
+	idempotencyCacheSize int
+
+	transactionTTL           time.Duration
+	transactionSweepInterval time.Duration
+
+	newSlowConsumerDetector func() *slowConsumerDetector
+	slowConsumerPolicy      SlowConsumerPolicy
+
+	rateLimiterFactory RateLimiterFactory
+	rateLimitPolicy    RateLimitPolicy
+
+	signer          *secure.MessageSigner
+	verifier        *secure.MessageVerifier
+	signaturePolicy SignaturePolicy
+	validator       wrp.Validator
+
+	capacity *capacity
+	health   health.Monitor
+
+	responseRouter ResponseRouter
+	dispatchPolicy DispatchPolicy
+	hopRecorder    *wrp.HopRecorder
+	instanceID     string
+	rpcHandlers    *rpcHandlers
+
+	broadcastConcurrency int
+	ackPolicy            ackPolicy
+	batchPolicy          batchPolicy
+	batchHeader          string
 }
 
 func (m *manager) Connect(response http.ResponseWriter, request *http.Request, responseHeader http.Header) (Interface, error) {
 	m.logger.Debug("Connect(%s, %v)", request.URL, request.Header)
-	deviceName := request.Header.Get(m.deviceNameHeader)
-	if len(deviceName) == 0 {
-		httperror.Format(
-			response,
-			http.StatusBadRequest,
-			m.missingDeviceNameHeaderError,
-		)
+	start := time.Now()
+	d, reason, err := m.connect(response, request, responseHeader)
+	m.metrics.handshake(time.Since(start), reason)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil, m.missingDeviceNameHeaderError
+	return d, nil
+}
+
+// connect performs the actual work of Connect.  When the connection is rejected,
+// the returned reason identifies why, for instrumentation, and the error is a *Rejection.
+func (m *manager) connect(response http.ResponseWriter, request *http.Request, responseHeader http.Header) (*device, RejectReason, error) {
+	if status := m.gate.Status(); !status.Open {
+		reason := status.Reason
+		if len(reason) == 0 {
+			reason = gate.DefaultReason
+		}
+
+		return nil, RejectGateClosed, m.rejectOverload(response, request, RejectGateClosed, fmt.Errorf("%s: %s", gate.ErrorClosed, reason))
+	}
+
+	deviceName, ok := m.deviceNameSource(request)
+	if !ok {
+		return nil, RejectBadID, reject(response, RejectBadID, m.missingDeviceNameError)
 	}
 
 	id, err := ParseID(deviceName)
 	if err != nil {
-		badDeviceNameError := fmt.Errorf("Bad device name: %s", err)
-		httperror.Format(
-			response,
-			http.StatusBadRequest,
-			badDeviceNameError,
-		)
-
-		return nil, badDeviceNameError
+		return nil, RejectBadID, reject(response, RejectBadID, fmt.Errorf("Bad device name: %s", err))
 	}
 
-	var convey Convey
-	if rawConvey := request.Header.Get(m.conveyHeader); len(rawConvey) > 0 {
-		convey, err = ParseConvey(rawConvey, nil)
-		if err != nil {
-			badConveyError := fmt.Errorf("Bad convey value [%s]: %s", rawConvey, err)
-			httperror.Format(
-				response,
-				http.StatusBadRequest,
-				badConveyError,
-			)
+	var (
+		convey      Convey
+		rawConvey   string
+		conveyError error
+	)
+
+	if value, ok := m.conveySource(request); ok {
+		if convey, rawConvey, conveyError = m.parseConvey(value); conveyError != nil {
+			if m.conveyPolicy == RejectInvalidConvey {
+				return nil, RejectBadConvey, reject(response, RejectBadConvey, conveyError)
+			}
 
-			return nil, badConveyError
+			m.logger.Warn("Accepting device [%s] with convey failure: %s", id, conveyError)
 		}
 	}
 
+	certificates := verifiedChain(request)
+	tags, rejection := m.authorizeConnect(response, request, id, convey, certificates)
+	if rejection != nil {
+		return nil, rejection.Reason, rejection
+	}
+
 	var initialKey Key
 	if initialKey, err = m.keyFunc(id, convey, request); err != nil {
-		keyError := fmt.Errorf("Unable to obtain key for device [%s]: %s", id, err)
-		httperror.Format(
-			response,
-			http.StatusBadRequest,
-			keyError,
-		)
+		return nil, RejectKeyError, reject(response, RejectKeyError, fmt.Errorf("Unable to obtain key for device [%s]: %s", id, err))
+	}
 
-		return nil, keyError
+	if !m.acquireCapacity() {
+		return nil, RejectCapacity, m.rejectOverload(response, request, RejectCapacity, ErrorDeviceLimit)
 	}
 
+	issued, responseHeader := m.issueSessionToken(id, responseHeader)
 	c, err := m.connectionFactory.NewConnection(response, request, responseHeader)
 	if err != nil {
-		return nil, err
+		m.releaseCapacity()
+
+		// the connection factory is responsible for writing the response
+		if rejection, ok := err.(*Rejection); ok {
+			return nil, rejection.Reason, rejection
+		}
+
+		return nil, RejectUpgradeFailed, newRejection(RejectUpgradeFailed, err)
+	}
+
+	d := m.newManagedDevice(id, initialKey, convey, request.RemoteAddr)
+	d.format = c.Format()
+	d.protocol = c.Subprotocol()
+	d.rawConvey, d.conveyError = rawConvey, conveyError
+	d.tags = m.tagDevice(convey, tags)
+	d.certificates = certificates
+	d.batches = m.batchPolicy.enabled() && acceptsBatches(request.Header, m.batchHeader)
+
+	// this makes the device addressable via this manager.  a device that cannot be registered could never
+	// be routed to, so it is closed before its pumps start.
+	if err := m.registry.add(d); err != nil {
+		m.releaseCapacity()
+		d.RequestClose()
+		if closeError := c.Close(); closeError != nil {
+			d.logger.Error("Error closing connection for device [%s]: %s", d.id, closeError)
+		}
+
+		d.logger.Error("Unable to register device [%s]: %s", d.id, err)
+		return nil, RejectKeyError, newRejection(RejectKeyError, fmt.Errorf("Unable to register device [%s]: %s", id, err))
+	}
+
+	var overflow []*envelope
+	if issued != nil {
+		_, overflow = m.attachSession(d, request.Header.Get(m.sessionHeader), issued)
 	}
 
-	d := newDevice(id, initialKey, convey, m.deviceMessageQueueSize)
 	closeOnce := new(sync.Once)
 	go m.readPump(d, c, closeOnce)
 	go m.writePump(d, c, closeOnce)
+	if m.transactionTTL > 0 {
+		go m.sweepTransactions(d)
+	}
 
-	return d, nil
+	m.failEnvelopes(d, overflow, ErrorDeviceBusy)
+	return d, "", nil
 }
 
-func (m *manager) dispatch(e *Event) {
-	for _, listener := range m.listeners {
-		listener(e)
+// rejectOverload writes a Rejection for a connection refused due to overload, including
+// any retry hints from the configured backoff policy
+func (m *manager) rejectOverload(response http.ResponseWriter, request *http.Request, reason RejectReason, err error) *Rejection {
+	rejection := newRejection(reason, err)
+	if m.backoff != nil {
+		rejection.Backoff = m.backoff.Backoff(request)
 	}
+
+	rejection.WriteResponse(response)
+	return rejection
 }
 
-func (m *manager) whenWriteLocked(when func()) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	when()
+// acquireCapacity reserves room for a new device, returning false if this manager is at its hard limit
+// and no device could be evicted to make room
+func (m *manager) acquireCapacity() bool {
+	ok, overSoft, saturated := m.capacity.acquire()
+	if !ok {
+		m.metrics.capacityLimit(HardLimit)
+		if m.capacity.policy != EvictIdleOverLimit || !m.evictIdle() {
+			m.logger.Error("Rejecting device: hard limit of %d devices reached", m.capacity.hard)
+			return false
+		}
+
+		overSoft, saturated = m.capacity.admit()
+	}
+
+	if overSoft {
+		m.logger.Warn("Device count %d exceeds the soft limit of %d", m.capacity.len(), m.capacity.soft)
+		m.metrics.capacityLimit(SoftLimit)
+		m.setLimitStat(DeviceSoftLimitStat, 1)
+	}
+
+	if saturated {
+		count := m.capacity.len()
+		m.logger.Warn("Device count %d has reached the hard limit of %d", count, m.capacity.hard)
+		m.setLimitStat(DeviceHardLimitStat, 1)
+		m.dispatch(&Event{Type: DeviceLimitReached, DeviceCount: count})
+	}
+
+	return true
 }
 
-func (m *manager) whenReadLocked(when func()) {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	when()
+// releaseCapacity frees the room reserved for a device that has disconnected or failed to connect
+func (m *manager) releaseCapacity() {
+	withinSoft, recovered := m.capacity.release()
+	if withinSoft {
+		m.logger.Info("Device count %d is back within the soft limit of %d", m.capacity.len(), m.capacity.soft)
+		m.setLimitStat(DeviceSoftLimitStat, 0)
+	}
+
+	if recovered {
+		count := m.capacity.len()
+		m.logger.Info("Device count %d has recovered from the hard limit of %d", count, m.capacity.hard)
+		m.setLimitStat(DeviceHardLimitStat, 0)
+		m.dispatch(&Event{Type: DeviceLimitRecovered, DeviceCount: count})
+	}
+}
+
+func (m *manager) setLimitStat(stat health.Stat, value int) {
+	if m.health != nil {
+		m.health.SendEvent(func(stats health.Stats) {
+			stats[stat] = value
+		})
+	}
+}
+
+func (m *manager) dispatch(e *Event) {
+	for _, listener := range m.listeners {
+		listener(e)
+	}
+
+	for _, s := range m.currentSubscriptions() {
+		s.deliver(e)
+	}
 }
 
 // pumpClose handles the proper shutdown and logging of a device's pumps.
@@ -215,39 +454,79 @@ func (m *manager) whenReadLocked(when func()) {
 // dispatches message failed events for any messages that were waiting to be delivered
 // at the time of pump closure.
 func (m *manager) pumpClose(d *device, c Connection, pumpError error) {
-	m.logger.Debug("pumpClose(%s, %s)", d.id, pumpError)
+	d.logger.Debug("pumpClose(%s, %s)", d.id, pumpError)
 
 	// always request a close, to ensure that the write goroutine is
 	// shutdown and to signal to other goroutines that the device is closed
 	d.RequestClose()
 
 	if pumpError != nil {
-		m.logger.Error("Device [%s] pump encountered error: %s", d.id, pumpError)
+		d.logger.Error("Device [%s] pump encountered error: %s", d.id, pumpError)
 	}
 
 	if closeError := c.Close(); closeError != nil {
-		m.logger.Error("Error closing connection for device [%s]: %s", d.id, closeError)
+		d.logger.Error("Error closing connection for device [%s]: %s", d.id, closeError)
 	}
 
+	// release capacity before notifying listeners, so that the slot is available by the time they run
+	m.releaseCapacity()
+
 	m.dispatch(
 		&Event{
 			Type:   Disconnect,
 			Device: d,
+			Error:  pumpError,
 		},
 	)
 }
 
+// pingData produces the application data of the pings sent to a device.  Devices echo this data in their pongs.
+func pingData(id ID) string {
+	return fmt.Sprintf("ping[%s]", id)
+}
+
+// newManagedDevice creates a device configured with this manager's queues, policies, and hooks.  The
+// attributes that depend on the device's connection, e.g. its format, are left to the caller.
+func (m *manager) newManagedDevice(id ID, key Key, convey Convey, remoteAddr string) *device {
+	d := newDeviceWithQueue(id, key, convey, newMessageQueue(m.messageQueueSizes))
+	d.logger = newDeviceLogger(m.logger, d, remoteAddr)
+	d.metrics = &m.metrics
+	d.fullQueuePolicy = m.queuePolicy
+	d.transactionTracer = m.transactionTracer
+	if m.idempotencyTTL > 0 {
+		d.idempotency = newIdempotencyCache(m.idempotencyTTL, m.idempotencyCacheSize)
+	}
+
+	if m.transactionTTL > 0 {
+		d.transactions = NewTransactionsWithTTL(m.transactionTTL)
+	}
+
+	d.transactions.OnOrphan(func(response *Response) {
+		d.logger.Debug("Device [%s] responded after transaction [%s] was cancelled or expired", d.id, response.TransactionKey())
+		m.metrics.orphanedResponse()
+	})
+
+	return d
+}
+
 // pongCallbackFor creates a callback that delegates to this Manager's Listeners
-// for the given device.
+// for the given device.  Pongs that echo this Manager's ping also record the device's round-trip time.
 func (m *manager) pongCallbackFor(d *device) func(string) {
-	// reuse the same event instance to ease gc pressure
-	event := new(Event)
+	var (
+		// reuse the same event instance to ease gc pressure
+		event = new(Event)
+		ping  = pingData(d.id)
+	)
 
 	return func(data string) {
 		event.Clear()
 		event.Type = Pong
 		event.Device = d
 		event.Data = data
+		if data == ping {
+			event.RTT, _ = d.statistics.ponged(time.Now())
+		}
+
 		m.dispatch(event)
 	}
 }
@@ -255,27 +534,71 @@ func (m *manager) pongCallbackFor(d *device) func(string) {
 // readPump is the goroutine which handles the stream of WRP messages from a device.
 // This goroutine exits when any error occurs on the connection.
 func (m *manager) readPump(d *device, c Connection, closeOnce *sync.Once) {
-	m.logger.Debug("readPump(%s)", d.id)
+	d.logger.Debug("readPump(%s)", d.id)
 
 	var (
 		frameRead bool
 		readError error
 		event     Event // reuse the same event as a carrier of data to listeners
-		decoder   = wrp.NewDecoder(nil, wrp.Msgpack)
+		decoder   = wrp.NewDecoder(nil, d.format)
+		limiter   RateLimiter
+
+		// frameBuffer is reused for each frame, and only holds a pooled buffer while a frame is processed
+		frameBuffer frameBuffer
 	)
 
+	if m.rateLimiterFactory != nil {
+		limiter = m.rateLimiterFactory(d)
+	}
+
 	// all the read pump has to do is ensure the device and the connection are closed
 	// it is the write pump's responsibility to do further cleanup
 	defer closeOnce.Do(func() { m.pumpClose(d, c, readError) })
+	defer frameBuffer.release()
 	c.SetPongCallback(m.pongCallbackFor(d))
 
 	for {
-		var frameBuffer bytes.Buffer
+		// listeners are invoked synchronously and may not retain the previous frame, so it is safe to reuse
+		frameBuffer.release()
 		frameRead, readError = c.Read(&frameBuffer)
-		if readError != nil {
+		readAt := time.Now()
+		if readError == ErrorIdleTimeout {
+			d.logger.Error("Disconnecting device [%s]: %s", d.id, readError)
+			if err := c.SendCloseReason(websocket.ClosePolicyViolation, m.closeReason(IdleCloseReason)); err != nil {
+				d.logger.Error("Unable to send close frame to idle device [%s]: %s", d.id, err)
+			}
+
+			return
+		} else if readError != nil {
 			return
 		} else if !frameRead {
-			m.logger.Warn("Skipping frame from device [%s]", d.id)
+			d.logger.Warn("Skipping frame of an unexpected type from device [%s]", d.id)
+			m.metrics.unexpectedFrameType()
+			continue
+		}
+
+		d.statistics.read(frameBuffer.Len(), readAt)
+
+		// throttle before decoding, so that abusive devices cost as little as possible
+		if limiter != nil && !limiter.Allow(readAt) {
+			m.metrics.rateLimited(m.rateLimitPolicy)
+			event.Clear()
+			event.Type = RateLimited
+			event.Device = d
+			event.Error = ErrorRateLimited
+			m.dispatch(&event)
+
+			if m.rateLimitPolicy == CloseRateLimited {
+				d.logger.Error("Disconnecting device [%s]: %s", d.id, ErrorRateLimited)
+				if err := c.SendCloseReason(websocket.ClosePolicyViolation, m.closeReason(RateLimitCloseReason)); err != nil {
+					d.logger.Error("Unable to send close frame to rate limited device [%s]: %s", d.id, err)
+				}
+
+				readError = ErrorRateLimited
+				return
+			}
+
+			d.logger.Debug("Dropping frame from device [%s]: %s", d.id, ErrorRateLimited)
 			continue
 		}
 
@@ -286,28 +609,102 @@ func (m *manager) readPump(d *device, c Connection, closeOnce *sync.Once) {
 
 		decoder.ResetBytes(rawFrame)
 		if decodeError := decoder.Decode(message); decodeError != nil {
-			// malformed WRP messages are allowed: the read pump will keep on chugging
-			m.logger.Error("Skipping malformed frame from device [%s]: %s", d.id, decodeError)
+			class, count := d.decodeErrors.record(time.Now(), rawFrame, decodeError)
+			m.metrics.decodeError(class)
+
+			if m.decodeFailureThreshold > 0 && count >= m.decodeFailureThreshold {
+				d.logger.Error("Quarantining device [%s] after %d frames that could not be decoded", d.id, count)
+				if err := c.SendCloseReason(websocket.CloseInvalidFramePayloadData, m.closeReason(DecodeFailureCloseReason)); err != nil {
+					d.logger.Error("Unable to send close frame to quarantined device [%s]: %s", d.id, err)
+				}
+
+				readError = ErrorDecodeFailure
+				return
+			}
+
+			// otherwise, malformed WRP messages are allowed: the read pump will keep on chugging
+			d.logger.Error("Skipping %s frame from device [%s]: %s", class, d.id, decodeError)
+			continue
+		}
+
+		if !m.validate(d, message) {
 			continue
 		}
 
+		// continue any trace the device propagated back to us
+		_, span := m.tracer.Start(
+			tracing.ExtractMessage(context.Background(), message),
+			ReadSpan,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(deviceAttributes(d.id, message.TransactionKey())...),
+			trace.WithAttributes(attribute.Int64(MessageTypeKey, int64(message.Type))),
+		)
+
+		span.End()
+
+		var signatureError error
+		if m.verifier != nil {
+			if signatureError = m.verifier.Verify(message); signatureError != nil {
+				m.metrics.signatureFailure(m.signaturePolicy)
+				if m.signaturePolicy == RejectBadSignature {
+					d.logger.Error("Dropping message from device [%s]: %s", d.id, signatureError)
+					continue
+				}
+
+				d.logger.Warn("Delivering message from device [%s] despite signature failure: %s", d.id, signatureError)
+			}
+		}
+
+		framed := true
+		if m.hopRecorder.Record(message, readAt, wrp.HopStatusOK) {
+			// keep the raw frame consistent with the message handed to listeners and transactions
+			var encoded []byte
+			if err := wrp.NewEncoderBytes(&encoded, d.format).Encode(message); err != nil {
+				d.logger.Error("Unable to encode hop for message from device [%s]: %s", d.id, err)
+			} else {
+				rawFrame, framed = encoded, false
+			}
+		}
+
+		if ackID, ok := message.Metadata[AckMetadataKey]; ok {
+			if !d.acks.acknowledge(ackID) {
+				d.logger.Debug("Ignoring acknowledgement of unknown message %s from device [%s]", ackID, d.id)
+			}
+
+			// a bare acknowledgement is consumed here, but any other message, such as a transaction
+			// response, doubles as an acknowledgement and is handled as usual
+			if len(message.Payload) == 0 && len(message.TransactionKey()) == 0 {
+				continue
+			}
+		}
+
 		event.Clear()
 		event.Device = d
 		event.Message = message
-		event.Format = wrp.Msgpack
+		event.Format = d.format
 		event.Contents = rawFrame
+		event.Error = signatureError
 
-		// update any waiting transaction
-		if transactionKey := message.TransactionKey(); len(transactionKey) > 0 {
-			err := d.transactions.Complete(
-				transactionKey,
-				&Response{
-					Device:   d,
-					Message:  message,
-					Format:   wrp.Msgpack,
-					Contents: rawFrame,
-				},
-			)
+		if handler, ok := m.rpcHandlers.find(message.Destination); ok {
+			// server-bound requests are handled here, even when they carry a transaction key
+			go m.serveRPC(d, handler, message)
+			event.Type = MessageReceived
+		} else if transactionKey := message.TransactionKey(); len(transactionKey) > 0 {
+			// the response outlives this frame, so it takes ownership of the frame's buffer
+			if framed {
+				frameBuffer.detach()
+			}
+
+			// update any waiting transaction
+			response := &Response{
+				Device:   d,
+				Message:  message,
+				Format:   d.format,
+				Contents: rawFrame,
+			}
+
+			response.TraceID, response.SpanID = messageSpanIDs(message)
+			err := m.responseRouter.RouteResponse(d.transactions, response)
 
 			if err != nil {
 				m.logger.Error("Error while completing transaction: %s", err)
@@ -328,26 +725,28 @@ func (m *manager) readPump(d *device, c Connection, closeOnce *sync.Once) {
 // this goroutine exits when either an explicit shutdown is requested or any
 // error occurs on the connection.
 func (m *manager) writePump(d *device, c Connection, closeOnce *sync.Once) {
-	m.logger.Debug("writePump(%s)", d.id)
-
-	// this makes this device addressable via the enclosing Manager:
-	m.whenWriteLocked(func() {
-		m.registry.add(d)
-	})
+	d.logger.Debug("writePump(%s)", d.id)
+	m.metrics.connected()
 
 	var (
 		// we'll reuse this event instance
 		event = Event{Type: Connect, Device: d}
 
+		unsent      []*envelope
 		envelope    *envelope
 		frame       io.WriteCloser
-		encoder     = wrp.NewEncoder(nil, wrp.Msgpack)
+		encoder     = wrp.NewEncoder(nil, d.format)
 		writeError  error
-		pingMessage = []byte(fmt.Sprintf("ping[%s]", d.id))
+		pingMessage = []byte(pingData(d.id))
 		pingTicker  = time.NewTicker(m.pingPeriod)
+		detector    = m.newSlowConsumerDetector()
+		writeStart  time.Time
 	)
 
 	m.dispatch(&event)
+	if m.messageSpool != nil {
+		go m.replaySpool(d)
+	}
 
 	// cleanup: we not only ensure that the device and connection are closed but also
 	// ensure that any messages that were waiting and/or failed are dispatched to
@@ -356,62 +755,122 @@ func (m *manager) writePump(d *device, c Connection, closeOnce *sync.Once) {
 		pingTicker.Stop()
 		closeOnce.Do(func() { m.pumpClose(d, c, writeError) })
 
-		m.whenWriteLocked(func() {
-			m.registry.removeOne(d)
-		})
+		m.registry.removeOne(d)
+
+		m.metrics.disconnected()
+
+		// collect the messages that were never delivered, for any QueueDrainHandler
+		var undelivered []*Request
 
-		// notify listener of any message that just now failed
+		// notify listener of any message that just now failed, including every message of a failed batch.
 		// any writeError is passed via this event
 		if envelope != nil {
+			unsent = append(unsent, envelope)
+		}
+
+		for _, failed := range unsent {
+			undelivered = append(undelivered, failed.request)
 			event.Clear()
 			event.Type = MessageFailed
 			event.Device = d
-			event.Message = envelope.request.Message
-			event.Format = envelope.request.Format
+			event.Message = failed.request.Message
+			event.Format = failed.request.Format
 			event.Error = writeError
 			m.dispatch(&event)
 		}
 
 		// drain the messages, dispatching them as message failed events.  we never close
-		// the message channel, so just drain until a receive would block.
+		// the message queue, so just drain until it is empty.
 		//
 		// Nil is passed explicitly as the error to indicate that these messages failed due
 		// to the device disconnecting, not due to an actual I/O error.
-		for {
-			select {
-			case undeliverable := <-d.messages:
-				event.Clear()
-				event.Type = MessageFailed
-				event.Device = d
-				event.Message = undeliverable.request.Message
-				event.Format = undeliverable.request.Format
-				m.dispatch(&event)
-			default:
-				break
+		// messages still awaiting acknowledgement were never confirmed, so they are undelivered as well
+		queued := append(d.acks.abandon(), d.drainQueue()...)
+
+		// a session keeps the queued messages for the device's next connection, if it can
+		if d.session != nil {
+			queued = m.detachSession(d, queued)
+			for _, undeliverable := range queued {
+				rejectEnvelope(undeliverable, ErrorDeviceBusy)
 			}
 		}
+
+		for _, undeliverable := range queued {
+			undelivered = append(undelivered, undeliverable.request)
+			event.Clear()
+			event.Type = MessageFailed
+			event.Device = d
+			event.Message = undeliverable.request.Message
+			event.Format = undeliverable.request.Format
+			m.dispatch(&event)
+		}
+
+		if m.queueDrainHandler != nil && len(undelivered) > 0 {
+			m.queueDrainHandler(d, undelivered)
+		}
 	}()
 
+	ping := func() error {
+		// the ping is recorded before it is written, as its pong can arrive before Ping returns
+		d.statistics.pinged(time.Now())
+		err := c.Ping(pingMessage)
+		if err == nil && detector != nil {
+			err = m.checkSlowConsumer(d, c, detector, 0)
+		}
+
+		return err
+	}
+
 	for writeError == nil {
 		envelope = nil
 
+		// shutdown and pings take precedence over queued messages
 		select {
 		case <-d.shutdown:
 			writeError = c.SendClose()
 			return
 
-		case envelope = <-d.messages:
-			if frame, writeError = c.NextWriter(); writeError == nil {
-				if envelope.request.Format != wrp.Msgpack || len(envelope.request.Contents) == 0 {
-					// if the request was in a format other than Msgpack, or if the caller did not pass
-					// Contents, then do the encoding here.
-					encoder.Reset(frame)
-					writeError = encoder.Encode(envelope.request.Message)
-				} else {
-					// we have Msgpack-formatted Contents
-					_, writeError = frame.Write(envelope.request.Contents)
-				}
+		case <-pingTicker.C:
+			writeError = ping()
+			continue
+
+		default:
+		}
 
+		// take the highest priority message that is already queued, if any.  otherwise,
+		// wait for the next message of any priority.
+		if envelope = d.messages.poll(); envelope == nil {
+			select {
+			case <-d.shutdown:
+				writeError = c.SendClose()
+				return
+
+			case <-pingTicker.C:
+				writeError = ping()
+				continue
+
+			case envelope = <-d.messages[CriticalPriority]:
+			case envelope = <-d.messages[HighPriority]:
+			case envelope = <-d.messages[MediumPriority]:
+			case envelope = <-d.messages[LowPriority]:
+			}
+		}
+
+		d.queueChanged(-1)
+
+		writeStart = time.Now()
+		if d.batches {
+			// the batch takes over the envelope, including failing it if the frame cannot be written
+			unsent, writeError = m.writeBatch(d, c, envelope, encoder)
+			envelope = nil
+		} else {
+			ctx, span, acknowledged := m.startWrite(d, envelope, writeStart)
+
+			written := byteCounter{}
+			if frame, writeError = c.NextWriter(); writeError == nil {
+				written.WriteCloser = frame
+				frame = &written
+				writeError = m.encodeEnvelope(ctx, d, envelope, encoder, frame)
 				if writeError == nil {
 					writeError = frame.Close()
 				} else {
@@ -420,16 +879,142 @@ func (m *manager) writePump(d *device, c Connection, closeOnce *sync.Once) {
 				}
 			}
 
-			if writeError != nil {
-				envelope.complete <- writeError
+			if writeError == nil {
+				d.statistics.wrote(written.count, time.Now())
 			}
 
-			close(envelope.complete)
+			m.finishWrite(d, envelope, span, acknowledged, writeStart, writeError)
+		}
 
-		case <-pingTicker.C:
-			writeError = c.Ping(pingMessage)
+		if writeError == nil && detector != nil {
+			envelope = nil
+			writeError = m.checkSlowConsumer(d, c, detector, time.Since(writeStart))
+		}
+	}
+}
+
+// startWrite begins writing an envelope taken from the device's queue, starting its span, recording its
+// queue latency, and waiting for its acknowledgement if one is required.  The returned flag indicates
+// whether the envelope must be acknowledged.
+func (m *manager) startWrite(d *device, e *envelope, writeStart time.Time) (context.Context, trace.Span, bool) {
+	ctx, span := m.tracer.Start(
+		e.request.Context(),
+		WriteSpan,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(deviceAttributes(d.id, e.request.Message.TransactionKey())...),
+	)
+
+	queueLatency := writeStart.Sub(e.enqueuedAt)
+	m.metrics.queued(queueLatency)
+	d.queueLatency.observe(writeStart, queueLatency)
+
+	// an acknowledgement can arrive as soon as the frame is written, so start waiting for it first
+	acknowledged := len(e.ackID) > 0
+	if acknowledged {
+		m.awaitAck(d, e)
+	}
+
+	return ctx, span, acknowledged
+}
+
+// encodeEnvelope writes the message of an envelope to the given writer in the device's format
+func (m *manager) encodeEnvelope(ctx context.Context, d *device, e *envelope, encoder wrp.Encoder, output io.Writer) error {
+	if e.request.Format != d.format || len(e.request.Contents) == 0 ||
+		m.signs(e.request.Message) || recordsHop(m.hopRecorder, e.request.Message) ||
+		traces(ctx, e.request.Message) || len(e.ackID) > 0 {
+		// if the request was in a format other than the one negotiated with the device,
+		// if the caller did not pass Contents, or if the message must be signed, carry a hop,
+		// carry trace context, or carry a delivery identifier, then do the encoding here.
+		encodable := tracedMessage(ctx, e.request.Message)
+		encodable = recordedMessage(m.hopRecorder, e.request.Message, encodable, e.enqueuedAt)
+		encodable = acknowledgedMessage(e, encodable)
+		if m.signer != nil {
+			encodable = signedMessage(m.signer, e.request.Message, encodable)
+		}
+
+		encoder.Reset(output)
+		return encoder.Encode(encodable)
+	}
+
+	// we have Contents in the device's format
+	_, err := output.Write(e.request.Contents)
+	return err
+}
+
+// finishWrite completes an envelope once the frame carrying it has been written, or has failed
+func (m *manager) finishWrite(d *device, e *envelope, span trace.Span, acknowledged bool, writeStart time.Time, err error) {
+	endSpan(span, err)
+	if err != nil {
+		d.statistics.sendFailed()
+		if !acknowledged || d.acks.cancel(e) {
+			rejectEnvelope(e, err)
 		}
+
+		return
+	}
+
+	m.metrics.sent(time.Since(writeStart))
+
+	// a message that must be acknowledged is completed by the read pump or by its retries instead
+	if !acknowledged {
+		close(e.complete)
+	}
+}
+
+// signs tests whether the given message will be signed before being written to a device
+func (m *manager) signs(message wrp.Routable) bool {
+	if m.signer == nil {
+		return false
+	}
+
+	_, ok := message.(*wrp.Message)
+	return ok
+}
+
+// checkSlowConsumer samples a device for slowness and applies this manager's slow consumer policy.
+// If the device should be disconnected, this method returns an error that ends the write pump.
+func (m *manager) checkSlowConsumer(d *device, c Connection, detector *slowConsumerDetector, stall time.Duration) error {
+	slow := detector.observe(time.Now(), d.messages.len(), stall)
+	if m.slowConsumerPolicy == DegradeSlowConsumer {
+		if d.setDegraded(slow) {
+			if slow {
+				d.logger.Warn("Device [%s] is a slow consumer and will only receive transactions", d.id)
+				m.metrics.slowConsumer(DegradeSlowConsumer)
+			} else {
+				d.logger.Info("Device [%s] is no longer a slow consumer", d.id)
+			}
+		}
+
+		return nil
+	}
+
+	if !slow {
+		return nil
+	}
+
+	d.logger.Warn("Closing device [%s] as a slow consumer", d.id)
+	m.metrics.slowConsumer(CloseSlowConsumer)
+	if err := c.SendCloseReason(websocket.ClosePolicyViolation, m.closeReason(SlowConsumerCloseReason)); err != nil {
+		d.logger.Error("Unable to send close frame to slow consumer [%s]: %s", d.id, err)
+	}
+
+	return ErrorSlowConsumer
+}
+
+// closeReason produces the reason text of a close frame, appending this manager's instance ID if one
+// is configured.  Websocket close reasons are limited in length, so the instance ID is omitted if it
+// does not fit.
+func (m *manager) closeReason(reason string) string {
+	if len(m.instanceID) == 0 {
+		return reason
+	}
+
+	hinted := reason + "; instance=" + m.instanceID
+	if len(hinted) > MaxCloseReasonLength {
+		return reason
 	}
+
+	return hinted
 }
 
 // requestClose is a convenient, internal visitor
@@ -446,77 +1031,79 @@ func (m *manager) wrapVisitor(delegate func(Interface)) func(*device) {
 	}
 }
 
-func (m *manager) Disconnect(id ID) (count int) {
+func (m *manager) Disconnect(id ID) int {
 	m.logger.Debug("Disconnect(%s)", id)
-
-	m.whenReadLocked(func() {
-		count = m.registry.visitID(id, m.requestClose)
-	})
-
-	return
+	return m.registry.visitID(id, m.requestClose)
 }
 
-func (m *manager) DisconnectOne(key Key) (count int) {
+func (m *manager) DisconnectOne(key Key) int {
 	m.logger.Debug("DisconnectOne(%s)", key)
-
-	m.whenReadLocked(func() {
-		count = m.registry.visitKey(key, m.requestClose)
-	})
-
-	return
+	return m.registry.visitKey(key, m.requestClose)
 }
 
-func (m *manager) DisconnectIf(filter func(ID) bool) (count int) {
+func (m *manager) DisconnectIf(filter func(ID) bool) int {
 	m.logger.Debug("DisconnectIf()")
-
-	m.whenReadLocked(func() {
-		count = m.registry.visitIf(filter, m.requestClose)
-	})
-
-	return count
+	return m.registry.visitIf(filter, m.requestClose)
 }
 
-func (m *manager) VisitIf(filter func(ID) bool, visitor func(Interface)) (count int) {
+func (m *manager) VisitIf(filter func(ID) bool, visitor func(Interface)) int {
 	m.logger.Debug("VisitIf")
-
-	m.whenReadLocked(func() {
-		count = m.registry.visitIf(filter, m.wrapVisitor(visitor))
-	})
-
-	return
+	return m.registry.visitIf(filter, m.wrapVisitor(visitor))
 }
 
-func (m *manager) VisitAll(visitor func(Interface)) (count int) {
+func (m *manager) VisitAll(visitor func(Interface)) int {
 	m.logger.Debug("VisitAll")
+	return m.registry.visitAll(m.wrapVisitor(visitor))
+}
 
-	m.whenReadLocked(func() {
-		count = m.registry.visitAll(m.wrapVisitor(visitor))
-	})
-
-	return
+func (m *manager) HandleRPC(service string, handler RPCHandler) {
+	m.rpcHandlers.HandleRPC(service, handler)
 }
 
-func (m *manager) Route(request *Request) (*Response, error) {
+func (m *manager) Route(request *Request) (response *Response, err error) {
 	var (
-		count            int
-		d                *device
-		destination, err = request.ID()
+		devices     []*device
+		destination ID
 	)
 
-	if err != nil {
-		return nil, err
+	if destination, err = request.ID(); err != nil {
+		return
 	}
 
-	m.whenReadLocked(func() {
-		count = m.registry.visitID(destination, func(e *device) { d = e })
-	})
+	ctx, span := m.tracer.Start(
+		request.Context(),
+		RouteSpan,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(deviceAttributes(destination, request.Message.TransactionKey())...),
+	)
 
-	switch count {
+	defer func() { endSpan(span, err) }()
+	request = request.withContext(ctx)
+
+	devices = m.registry.devices(destination)
+
+	switch len(devices) {
 	case 0:
-		return nil, ErrorDeviceNotFound
+		err = m.spoolRequest(destination, request)
 	case 1:
-		return d.Send(request)
+		response, err = devices[0].Send(request)
 	default:
-		return nil, ErrorNonUniqueID
+		response, err = m.dispatchDuplicates(request, devices)
+	}
+
+	return
+}
+
+// tracedMessage returns the message to encode for a device.  When the message is a *wrp.Message,
+// a copy carrying the trace context of ctx is returned so that the device can continue the trace.
+// The caller's message is never modified.
+func tracedMessage(ctx context.Context, message wrp.Routable) interface{} {
+	original, ok := message.(*wrp.Message)
+	if !ok || !trace.SpanContextFromContext(ctx).IsValid() {
+		return message
 	}
+
+	copied := copyMessage(original, 1)
+	tracing.InjectMessage(ctx, copied)
+	return copied
 }
@@ -3,12 +3,14 @@ package device
 import (
 	"errors"
 	"fmt"
+	"github.com/Comcast/webpa-common/gate"
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -158,6 +160,67 @@ func testManagerConnectKeyError(t *testing.T) {
 	assert.Equal(response.Code, http.StatusBadRequest)
 }
 
+func testManagerConnectDuplicateKey(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		connected    = make(chan Interface, 2)
+		disconnected = make(chan Interface, 2)
+		options      = &Options{
+			Logger:         logging.TestLogger(t),
+			RegistryShards: 16,
+			KeyFunc: func(ID, Convey, *http.Request) (Key, error) {
+				return Key("shared"), nil
+			},
+			Listeners: []Listener{
+				func(e *Event) {
+					switch e.Type {
+					case Connect:
+						connected <- e.Device
+					case Disconnect:
+						disconnected <- e.Device
+					}
+				},
+			},
+		}
+
+		m, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(options, nil)
+
+		firstID  = ID("mac:112233445566")
+		secondID = ID("mac:112233445567")
+	)
+
+	defer server.Close()
+	registry := m.(*manager).registry
+	assert.False(registry.shard(firstID) == registry.shard(secondID), "The devices should be in different shards")
+
+	first, _, err := dialer.Dial(connectURL, firstID, nil, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(firstID, (<-connected).ID())
+
+	// keys are unique across shards, so the second device is closed without ever being connected
+	second, _, err := dialer.Dial(connectURL, secondID, nil, nil)
+	if assert.NoError(err) {
+		_, err = second.NextReader()
+		assert.Error(err)
+		second.Close()
+	}
+
+	var owners []ID
+	assert.Equal(1, registry.visitKey(Key("shared"), func(d *device) { owners = append(owners, d.ID()) }))
+	assert.Equal([]ID{firstID}, owners)
+	assert.Equal(1, registry.len())
+	assert.Empty(connected)
+
+	first.Close()
+	assert.Equal(firstID, (<-disconnected).ID())
+	assert.Empty(disconnected)
+}
+
 func testManagerConnectConnectionFactoryError(t *testing.T) {
 	var (
 		assert  = assert.New(t)
@@ -183,7 +246,68 @@ func testManagerConnectConnectionFactoryError(t *testing.T) {
 	request.Header.Set(DefaultDeviceNameHeader, "mac:123412341234")
 	device, actualError := manager.Connect(response, request, responseHeader)
 	assert.Nil(device)
-	assert.Equal(expectedError, actualError)
+	if rejection, ok := actualError.(*Rejection); assert.True(ok) {
+		assert.Equal(RejectUpgradeFailed, rejection.Reason)
+		assert.Equal(expectedError, rejection.Err)
+	}
+
+	connectionFactory.AssertExpectations(t)
+}
+
+func testManagerConnectGateClosed(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		options = &Options{
+			Logger: logging.TestLogger(t),
+			Gate:   gate.New(false),
+		}
+
+		connectionFactory = new(mockConnectionFactory)
+		manager           = NewManager(options, connectionFactory)
+		response          = httptest.NewRecorder()
+		request           = httptest.NewRequest("POST", "http://localhost.com", nil)
+	)
+
+	options.Gate.Close("maintenance")
+	request.Header.Set(DefaultDeviceNameHeader, "mac:123412341234")
+	device, err := manager.Connect(response, request, nil)
+	assert.Nil(device)
+	reason, ok := RejectionReason(err)
+	assert.True(ok)
+	assert.Equal(RejectGateClosed, reason)
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Contains(response.Body.String(), "maintenance")
+	assert.Contains(response.Body.String(), `"reason":"gate_closed"`)
+
+	connectionFactory.AssertExpectations(t)
+}
+
+func testManagerConnectGateClosedBackoff(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		options = &Options{
+			Logger:  logging.TestLogger(t),
+			Gate:    gate.New(false),
+			Backoff: gate.FixedBackoff(45 * time.Second),
+		}
+
+		connectionFactory = new(mockConnectionFactory)
+		manager           = NewManager(options, connectionFactory)
+		response          = httptest.NewRecorder()
+		request           = httptest.NewRequest("POST", "http://localhost.com", nil)
+	)
+
+	request.Header.Set(DefaultDeviceNameHeader, "mac:123412341234")
+	device, err := manager.Connect(response, request, nil)
+	assert.Nil(device)
+	if rejection, ok := err.(*Rejection); assert.True(ok) {
+		assert.Equal(RejectGateClosed, rejection.Reason)
+		assert.Equal(45*time.Second, rejection.Backoff.RetryAfter)
+	}
+
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Equal("45", response.HeaderMap.Get("Retry-After"))
+	assert.Contains(response.Body.String(), `"retryAfter":45`)
 
 	connectionFactory.AssertExpectations(t)
 }
@@ -281,6 +405,41 @@ func testManagerPongCallbackFor(t *testing.T) {
 	pongCallback := manager.pongCallbackFor(expectedDevice)
 	pongCallback(expectedData)
 	assert.True(listenerCalled)
+	assert.Zero(expectedDevice.Statistics().LastRTT)
+}
+
+func testManagerPongCallbackForRTT(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(ID("mac:112233445566"), Key("expected"), nil, 1)
+		rtts   []time.Duration
+
+		manager = &manager{
+			logger: logging.TestLogger(t),
+			listeners: []Listener{
+				func(event *Event) {
+					rtts = append(rtts, event.RTT)
+				},
+			},
+		}
+
+		pongCallback = manager.pongCallbackFor(d)
+	)
+
+	// only a pong echoing the ping answers it
+	d.statistics.pinged(time.Now().Add(-time.Second))
+	pongCallback("unrelated pong data")
+	pongCallback(pingData(d.id))
+	pongCallback(pingData(d.id))
+
+	if assert.Len(rtts, 3) {
+		assert.Zero(rtts[0])
+		assert.True(rtts[1] >= time.Second)
+		assert.Zero(rtts[2])
+	}
+
+	assert.Equal(rtts[1], d.Statistics().LastRTT)
+	assert.Equal(rtts[1], d.Statistics().RTT)
 }
 
 func testManagerDisconnect(t *testing.T) {
@@ -519,6 +678,7 @@ func testManagerPingPong(t *testing.T) {
 					case Connect:
 						connectWait.Done()
 					case Pong:
+						assert.True(event.RTT > 0)
 						pongs <- event.Device
 					}
 				},
@@ -560,6 +720,7 @@ func testManagerPingPong(t *testing.T) {
 		for pongedDevices.len() < testConnectionCount {
 			select {
 			case ponged := <-pongs:
+				assert.True(ponged.Statistics().RTT > 0)
 				pongedDevices.add(ponged)
 			case <-timeout:
 				assert.Fail("Not all devices responded to pings within the timeout")
@@ -570,13 +731,30 @@ func testManagerPingPong(t *testing.T) {
 	pongWait.Wait()
 }
 
+func testManagerCloseReason(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewManager(nil, nil).(*manager)
+	assert.Equal(SlowConsumerCloseReason, m.closeReason(SlowConsumerCloseReason))
+
+	m = NewManager(&Options{InstanceID: "east/http://talaria-1:8080"}, nil).(*manager)
+	assert.Equal(SlowConsumerCloseReason+"; instance=east/http://talaria-1:8080", m.closeReason(SlowConsumerCloseReason))
+
+	// instance IDs that do not fit in a close frame are omitted
+	m = NewManager(&Options{InstanceID: strings.Repeat("x", MaxCloseReasonLength)}, nil).(*manager)
+	assert.Equal(SlowConsumerCloseReason, m.closeReason(SlowConsumerCloseReason))
+}
+
 func TestManager(t *testing.T) {
 	t.Run("Connect", func(t *testing.T) {
 		t.Run("MissingDeviceNameHeader", testManagerConnectMissingDeviceNameHeader)
 		t.Run("BadDeviceNameHeader", testManagerConnectBadDeviceNameHeader)
 		t.Run("BadConveyHeader", testManagerConnectBadConveyHeader)
 		t.Run("KeyError", testManagerConnectKeyError)
+		t.Run("DuplicateKey", testManagerConnectDuplicateKey)
 		t.Run("ConnectionFactoryError", testManagerConnectConnectionFactoryError)
+		t.Run("GateClosed", testManagerConnectGateClosed)
+		t.Run("GateClosedBackoff", testManagerConnectGateClosedBackoff)
 		t.Run("Visit", testManagerConnectVisit)
 	})
 
@@ -591,5 +769,7 @@ func TestManager(t *testing.T) {
 	t.Run("DisconnectIf", testManagerDisconnectIf)
 
 	t.Run("PongCallbackFor", testManagerPongCallbackFor)
+	t.Run("PongCallbackForRTT", testManagerPongCallbackForRTT)
 	t.Run("PingPong", testManagerPingPong)
+	t.Run("CloseReason", testManagerCloseReason)
 }
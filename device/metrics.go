@@ -0,0 +1,260 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/xmetrics"
+	"time"
+)
+
+const (
+	// DeviceCount is the gauge of devices currently connected to a manager
+	DeviceCount = "device_count"
+
+	// ConnectCount is the counter of device connections
+	ConnectCount = "device_connects_total"
+
+	// DisconnectCount is the counter of device disconnections
+	DisconnectCount = "device_disconnects_total"
+
+	// UnexpectedFrameCount is the counter of frames skipped because they were not of the configured frame type
+	UnexpectedFrameCount = "device_unexpected_frames_total"
+
+	// HandshakeDuration is the histogram of the time taken to accept or reject a websocket upgrade
+	HandshakeDuration = "device_handshake_duration_seconds"
+
+	// HandshakeRejectionCount is the counter of rejected websocket upgrades
+	HandshakeRejectionCount = "device_handshake_rejections_total"
+
+	// QueueLatency is the histogram of the time messages spend queued for a device before being written
+	QueueLatency = "device_queue_latency_seconds"
+
+	// QueueDepth is the gauge of messages queued for all devices connected to a manager
+	QueueDepth = "device_queue_depth"
+
+	// SendDuration is the histogram of the time taken to write a message to a device's connection
+	SendDuration = "device_send_duration_seconds"
+
+	// BatchSize is the histogram of the count of messages written to devices in each batch envelope
+	BatchSize = "device_batch_size"
+
+	// TransactionDuration is the histogram of the time between sending a transactional message and
+	// receiving the device's response
+	TransactionDuration = "device_transaction_duration_seconds"
+
+	// DecodeErrorCount is the counter of frames from devices that could not be decoded
+	DecodeErrorCount = "device_decode_errors_total"
+
+	// ClassLabel holds the DecodeError* class for DecodeErrorCount, or the ConveyClass* class for ConveyFailureCount
+	ClassLabel = "class"
+
+	// SlowConsumerCount is the counter of actions taken against slow consumers
+	SlowConsumerCount = "device_slow_consumers_total"
+
+	// QueueFullCount is the counter of messages rejected or discarded because a device's queue was full
+	QueueFullCount = "device_queue_full_total"
+
+	// CapacityLimitCount is the counter of device connections that exceeded the soft or hard device limit
+	CapacityLimitCount = "device_capacity_limits_total"
+
+	// LimitLabel holds SoftLimit or HardLimit for CapacityLimitCount
+	LimitLabel = "limit"
+
+	// EvictedCount is the counter of devices disconnected under the EvictIdleOverLimit policy
+	EvictedCount = "device_evicted_total"
+
+	// InvalidMessageCount is the counter of inbound messages dropped because they failed the MessageValidator
+	InvalidMessageCount = "device_invalid_messages_total"
+
+	// SignatureFailureCount is the counter of inbound messages that failed signature verification
+	SignatureFailureCount = "device_signature_failures_total"
+
+	// ConveyFailureCount is the counter of connecting devices whose convey values were too large or unparseable
+	ConveyFailureCount = "device_convey_failures_total"
+
+	// TransactionExpiredCount is the counter of transactions that expired before their devices responded
+	TransactionExpiredCount = "device_transactions_expired_total"
+
+	// OrphanedResponseCount is the counter of responses from devices that arrived after their transactions
+	// were cancelled or expired
+	OrphanedResponseCount = "device_orphaned_responses_total"
+
+	// RateLimitedCount is the counter of frames from devices that exceeded their rate limits
+	RateLimitedCount = "device_rate_limited_total"
+
+	// EventDroppedCount is the counter of events that could not be sent to a full Manager.SubscribeChannel channel
+	EventDroppedCount = "device_events_dropped_total"
+
+	// EventLabel holds the EventType of the event for EventDroppedCount
+	EventLabel = "event"
+
+	// ActionLabel holds the SlowConsumerPolicy applied for SlowConsumerCount, the SignaturePolicy
+	// applied for SignatureFailureCount, the ConveyPolicy applied for ConveyFailureCount, the
+	// RateLimitPolicy applied for RateLimitedCount, or the QueuePolicy applied for QueueFullCount
+	ActionLabel = "action"
+
+	// OutcomeLabel distinguishes accepted from rejected handshakes in HandshakeDuration
+	OutcomeLabel = "outcome"
+
+	// ReasonLabel holds the Reject* value for HandshakeRejectionCount
+	ReasonLabel = "reason"
+
+	OutcomeAccepted = "accepted"
+	OutcomeRejected = "rejected"
+)
+
+// managerMetrics holds the connection metrics for a manager
+type managerMetrics struct {
+	deviceCount     xmetrics.Gauge
+	connectCount    xmetrics.Counter
+	disconnectCount xmetrics.Counter
+	unexpectedFrame xmetrics.Counter
+	queueLatency    xmetrics.Histogram
+	queueDepth      xmetrics.Gauge
+	sendDuration    xmetrics.Histogram
+	batchSize       xmetrics.Histogram
+	decodeErrors    xmetrics.Counter
+
+	handshakeDuration   xmetrics.Histogram
+	handshakeRejections xmetrics.Counter
+
+	slowConsumers     xmetrics.Counter
+	fullQueues        xmetrics.Counter
+	signatureFailures xmetrics.Counter
+	invalidMessages   xmetrics.Counter
+	capacityLimits    xmetrics.Counter
+	evictions         xmetrics.Counter
+	conveyFailures    xmetrics.Counter
+	rateLimitedFrames xmetrics.Counter
+
+	transactionsExpired xmetrics.Counter
+	orphanedResponses   xmetrics.Counter
+	transactionDuration xmetrics.Histogram
+	eventsDropped       xmetrics.Counter
+}
+
+func newManagerMetrics(provider xmetrics.Provider) managerMetrics {
+	return managerMetrics{
+		deviceCount:     provider.NewGauge(DeviceCount),
+		connectCount:    provider.NewCounter(ConnectCount),
+		disconnectCount: provider.NewCounter(DisconnectCount),
+		unexpectedFrame: provider.NewCounter(UnexpectedFrameCount),
+		queueLatency:    provider.NewHistogram(QueueLatency),
+		queueDepth:      provider.NewGauge(QueueDepth),
+		sendDuration:    provider.NewHistogram(SendDuration),
+		batchSize:       provider.NewHistogram(BatchSize),
+		decodeErrors:    provider.NewCounter(DecodeErrorCount, ClassLabel),
+
+		handshakeDuration:   provider.NewHistogram(HandshakeDuration, OutcomeLabel),
+		handshakeRejections: provider.NewCounter(HandshakeRejectionCount, ReasonLabel),
+
+		slowConsumers:     provider.NewCounter(SlowConsumerCount, ActionLabel),
+		fullQueues:        provider.NewCounter(QueueFullCount, ActionLabel),
+		signatureFailures: provider.NewCounter(SignatureFailureCount, ActionLabel),
+		invalidMessages:   provider.NewCounter(InvalidMessageCount),
+		capacityLimits:    provider.NewCounter(CapacityLimitCount, LimitLabel),
+		evictions:         provider.NewCounter(EvictedCount),
+		conveyFailures:    provider.NewCounter(ConveyFailureCount, ClassLabel, ActionLabel),
+		rateLimitedFrames: provider.NewCounter(RateLimitedCount, ActionLabel),
+
+		transactionsExpired: provider.NewCounter(TransactionExpiredCount),
+		orphanedResponses:   provider.NewCounter(OrphanedResponseCount),
+		transactionDuration: provider.NewHistogram(TransactionDuration),
+		eventsDropped:       provider.NewCounter(EventDroppedCount, EventLabel),
+	}
+}
+
+func (mm managerMetrics) connected() {
+	mm.connectCount.Add(1.0)
+	mm.deviceCount.Add(1.0)
+}
+
+func (mm managerMetrics) disconnected() {
+	mm.disconnectCount.Add(1.0)
+	mm.deviceCount.Add(-1.0)
+}
+
+func (mm managerMetrics) unexpectedFrameType() {
+	mm.unexpectedFrame.Add(1.0)
+}
+
+func (mm managerMetrics) queued(latency time.Duration) {
+	mm.queueLatency.Observe(latency.Seconds())
+}
+
+// queueChanged adjusts the queue depth by the number of messages enqueued, or dequeued if negative
+func (mm managerMetrics) queueChanged(delta int) {
+	mm.queueDepth.Add(float64(delta))
+}
+
+func (mm managerMetrics) sent(duration time.Duration) {
+	mm.sendDuration.Observe(duration.Seconds())
+}
+
+// batched records a batch envelope written to a device.  Frames that carry a single message are not batches.
+func (mm managerMetrics) batched(count int) {
+	if count > 1 {
+		mm.batchSize.Observe(float64(count))
+	}
+}
+
+func (mm managerMetrics) decodeError(class string) {
+	mm.decodeErrors.With(class).Add(1.0)
+}
+
+// handshake records a completed upgrade attempt.  An empty reason indicates the device was accepted.
+func (mm managerMetrics) handshake(duration time.Duration, reason RejectReason) {
+	if len(reason) == 0 {
+		mm.handshakeDuration.With(OutcomeAccepted).Observe(duration.Seconds())
+		return
+	}
+
+	mm.handshakeDuration.With(OutcomeRejected).Observe(duration.Seconds())
+	mm.handshakeRejections.With(string(reason)).Add(1.0)
+}
+
+func (mm managerMetrics) slowConsumer(policy SlowConsumerPolicy) {
+	mm.slowConsumers.With(string(policy)).Add(1.0)
+}
+
+func (mm managerMetrics) queueFull(policy QueuePolicy) {
+	mm.fullQueues.With(string(policy)).Add(1.0)
+}
+
+func (mm managerMetrics) signatureFailure(policy SignaturePolicy) {
+	mm.signatureFailures.With(string(policy)).Add(1.0)
+}
+
+func (mm managerMetrics) invalidMessage() {
+	mm.invalidMessages.Add(1.0)
+}
+
+func (mm managerMetrics) capacityLimit(limit string) {
+	mm.capacityLimits.With(limit).Add(1.0)
+}
+
+func (mm managerMetrics) evicted() {
+	mm.evictions.Add(1.0)
+}
+
+func (mm managerMetrics) conveyFailure(class string, policy ConveyPolicy) {
+	mm.conveyFailures.With(class, string(policy)).Add(1.0)
+}
+
+func (mm managerMetrics) rateLimited(policy RateLimitPolicy) {
+	mm.rateLimitedFrames.With(string(policy)).Add(1.0)
+}
+
+func (mm managerMetrics) transactionExpired(count int) {
+	mm.transactionsExpired.Add(float64(count))
+}
+
+func (mm managerMetrics) orphanedResponse() {
+	mm.orphanedResponses.Add(1.0)
+}
+
+func (mm managerMetrics) transactionCompleted(duration time.Duration) {
+	mm.transactionDuration.Observe(duration.Seconds())
+}
+
+func (mm managerMetrics) eventDropped(eventType EventType) {
+	mm.eventsDropped.With(eventType.String()).Add(1.0)
+}
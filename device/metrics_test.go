@@ -0,0 +1,351 @@
+package device
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/gate"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestManagerMetrics(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		require        = require.New(t)
+		connectWait    = new(sync.WaitGroup)
+		disconnectWait = new(sync.WaitGroup)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	connectWait.Add(testConnectionCount)
+	disconnectWait.Add(testConnectionCount)
+	options := &Options{
+		Logger:  logging.TestLogger(t),
+		Metrics: registry,
+		Listeners: []Listener{
+			func(event *Event) {
+				switch event.Type {
+				case Connect:
+					connectWait.Done()
+				case Disconnect:
+					disconnectWait.Done()
+				}
+			},
+		},
+	}
+
+	_, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	dialer := NewDialer(options, nil)
+	testDevices := connectTestDevices(t, assert, dialer, connectURL)
+	connectWait.Wait()
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+
+	assert.True(strings.Contains(body, DeviceCount+" 7"), body)
+	assert.True(strings.Contains(body, ConnectCount+" 7"), body)
+
+	closeTestDevices(assert, testDevices)
+	disconnectWait.Wait()
+}
+
+func TestManagerMetricsDisconnected(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	metrics := newManagerMetrics(registry)
+	metrics.connected()
+	metrics.connected()
+	metrics.disconnected()
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+
+	assert.True(strings.Contains(body, DeviceCount+" 1"), body)
+	assert.True(strings.Contains(body, ConnectCount+" 2"), body)
+	assert.True(strings.Contains(body, DisconnectCount+" 1"), body)
+}
+
+func TestManagerHandshakeMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:  logging.TestLogger(t),
+			Metrics: registry,
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == Disconnect {
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	// bad device name
+	manager.Connect(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+
+	// bad convey
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(DefaultDeviceNameHeader, "mac:112233445566")
+	request.Header.Set(DefaultConveyHeader, "this is not valid")
+	manager.Connect(httptest.NewRecorder(), request, nil)
+
+	// cross-origin requests are rejected by default
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(DefaultDeviceNameHeader, "mac:112233445566")
+	request.Header.Set("Origin", "http://somewhere.else.com")
+	response := httptest.NewRecorder()
+	d, err := manager.Connect(response, request, nil)
+	assert.Nil(d)
+	if rejection, ok := err.(*Rejection); assert.True(ok) {
+		assert.Equal(RejectOriginRejected, rejection.Reason)
+		assert.Equal(ErrorOriginRejected, rejection.Err)
+	}
+
+	assert.Equal(http.StatusForbidden, response.Code)
+
+	// not a websocket upgrade
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(DefaultDeviceNameHeader, "mac:112233445566")
+	manager.Connect(httptest.NewRecorder(), request, nil)
+
+	// an actual device
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	c.Close()
+	<-disconnected
+
+	response = httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+
+	for _, reason := range []RejectReason{RejectBadID, RejectBadConvey, RejectOriginRejected, RejectUpgradeFailed} {
+		assert.True(strings.Contains(body, HandshakeRejectionCount+`{reason="`+string(reason)+`"} 1`), body)
+	}
+
+	assert.True(strings.Contains(body, HandshakeDuration+`_count{outcome="accepted"} 1`), body)
+	assert.True(strings.Contains(body, HandshakeDuration+`_count{outcome="rejected"} 4`), body)
+}
+
+func TestManagerHandshakeMetricsGateClosed(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	manager := NewManager(&Options{Logger: logging.TestLogger(t), Metrics: registry, Gate: gate.New(false)}, nil)
+	manager.Connect(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, HandshakeRejectionCount+`{reason="`+string(RejectGateClosed)+`"} 1`), body)
+}
+
+func TestManagerQueueLatencyMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:  logging.TestLogger(t),
+			Metrics: registry,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	_, err = d.Send(&Request{Message: &wrp.SimpleEvent{Destination: "mac:112233445566/service"}})
+	assert.NoError(err)
+	assert.True(d.MaxQueueLatency() > 0)
+
+	c.Close()
+	<-disconnected
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, QueueLatency+"_count 1"), body)
+}
+
+func TestManagerSendMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:  logging.TestLogger(t),
+			Metrics: registry,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	// the device answers each request it receives
+	go func() {
+		for {
+			frame, err := c.NextReader()
+			if err != nil {
+				return
+			}
+
+			var message wrp.Message
+			if err := wrp.NewDecoder(frame, c.Format()).Decode(&message); err != nil {
+				return
+			}
+
+			var encoded []byte
+			wrp.NewEncoderBytes(&encoded, c.Format()).Encode(&wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "mac:112233445566",
+				Destination:     message.Source,
+				TransactionUUID: message.TransactionUUID,
+			})
+
+			c.Write(encoded)
+		}
+	}()
+
+	response, err := d.Send(&Request{Message: &wrp.SimpleRequestResponse{
+		Source:          "service",
+		Destination:     "mac:112233445566/service",
+		TransactionUUID: "transaction",
+	}})
+
+	require.NoError(err)
+	require.NotNil(response)
+	assert.Equal("transaction", response.TransactionKey())
+
+	c.Close()
+	<-disconnected
+
+	metrics := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/", nil))
+	body := metrics.Body.String()
+	assert.True(strings.Contains(body, QueueDepth+" 0"), body)
+	assert.True(strings.Contains(body, SendDuration+"_count 1"), body)
+	assert.True(strings.Contains(body, TransactionDuration+"_count 1"), body)
+}
+
+func TestManagerQueueDepthMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		metrics = newManagerMetrics(registry)
+		d       = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+		sent    = new(sync.WaitGroup)
+	)
+
+	d.metrics = &metrics
+	sent.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer sent.Done()
+			d.sendRequest(&Request{Message: &wrp.SimpleEvent{Destination: "mac:112233445566/service"}})
+		}()
+	}
+
+	for d.messages.len() < 2 {
+		runtime.Gosched()
+	}
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.True(strings.Contains(response.Body.String(), QueueDepth+" 2"), response.Body.String())
+
+	// a device that disconnects drains its queue
+	m := NewManager(&Options{Logger: logging.TestLogger(t)}, nil).(*manager)
+	m.writePump(d, &failingWriter{err: errors.New("expected")}, new(sync.Once))
+	sent.Wait()
+
+	response = httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.True(strings.Contains(response.Body.String(), QueueDepth+" 0"), response.Body.String())
+}
@@ -1,9 +1,14 @@
 package device
 
 import (
+	"crypto/x509"
+	"github.com/Comcast/webpa-common/health"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -36,14 +41,71 @@ func (m *mockDevice) Convey() Convey {
 	return m.Called().Get(0).(Convey)
 }
 
+func (m *mockDevice) RawConvey() string {
+	return m.Called().String(0)
+}
+
+func (m *mockDevice) ConveyError() error {
+	return m.Called().Error(0)
+}
+
 func (m *mockDevice) ConnectedAt() time.Time {
 	return m.Called().Get(0).(time.Time)
 }
 
+func (m *mockDevice) ConnectionDuration() time.Duration {
+	return m.Called().Get(0).(time.Duration)
+}
+
+func (m *mockDevice) Format() wrp.Format {
+	return m.Called().Get(0).(wrp.Format)
+}
+
+func (m *mockDevice) Logger() logging.Logger {
+	return m.Called().Get(0).(logging.Logger)
+}
+
+func (m *mockDevice) Protocol() string {
+	return m.Called().String(0)
+}
+
 func (m *mockDevice) Pending() int {
 	return m.Called().Int(0)
 }
 
+func (m *mockDevice) MaxQueueLatency() time.Duration {
+	return m.Called().Get(0).(time.Duration)
+}
+
+func (m *mockDevice) DecodeFailures() (int, []DecodeFailure) {
+	arguments := m.Called()
+	failures, _ := arguments.Get(1).([]DecodeFailure)
+	return arguments.Int(0), failures
+}
+
+func (m *mockDevice) PendingTransactions() []PendingTransaction {
+	pending, _ := m.Called().Get(0).([]PendingTransaction)
+	return pending
+}
+
+func (m *mockDevice) Statistics() Statistics {
+	return m.Called().Get(0).(Statistics)
+}
+
+func (m *mockDevice) Tags() Tags {
+	tags, _ := m.Called().Get(0).(Tags)
+	return tags
+}
+
+func (m *mockDevice) Certificate() *x509.Certificate {
+	certificate, _ := m.Called().Get(0).(*x509.Certificate)
+	return certificate
+}
+
+func (m *mockDevice) SessionID() string {
+	return m.Called().String(0)
+}
+
 func (m *mockDevice) RequestClose() {
 	m.Called()
 }
@@ -130,6 +192,22 @@ func expectsDevices(devices ...*device) deviceSet {
 	return result
 }
 
+type mockRegistry struct {
+	mock.Mock
+}
+
+func (m *mockRegistry) VisitIf(filter func(ID) bool, visitor func(Interface)) int {
+	return m.Called(filter, visitor).Int(0)
+}
+
+func (m *mockRegistry) VisitAll(visitor func(Interface)) int {
+	return m.Called(visitor).Int(0)
+}
+
+func (m *mockRegistry) VisitTag(tag Tag, visitor func(Interface)) int {
+	return m.Called(tag, visitor).Int(0)
+}
+
 type mockRouter struct {
 	mock.Mock
 }
@@ -161,3 +239,28 @@ func (m *mockConnector) DisconnectOne(key Key) int {
 func (m *mockConnector) DisconnectIf(predicate func(ID) bool) int {
 	return m.Called(predicate).Int(0)
 }
+
+// statsMonitor is a health.Monitor that applies events synchronously to its own Stats
+type statsMonitor struct {
+	lock  sync.Mutex
+	stats health.Stats
+}
+
+func (m *statsMonitor) SendEvent(f health.HealthFunc) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stats == nil {
+		m.stats = make(health.Stats)
+	}
+
+	f(m.stats)
+}
+
+func (m *statsMonitor) ServeHTTP(http.ResponseWriter, *http.Request) {
+}
+
+func (m *statsMonitor) stat(s health.Stat) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stats[s]
+}
@@ -1,7 +1,17 @@
 package device
 
 import (
+	"compress/flate"
+	"github.com/Comcast/webpa-common/gate"
+	"github.com/Comcast/webpa-common/health"
 	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/secure"
+	"github.com/Comcast/webpa-common/spool"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"regexp"
 	"time"
 )
 
@@ -17,6 +27,7 @@ const (
 	DefaultDecoderPoolSize        = 1000
 	DefaultEncoderPoolSize        = 1000
 	DefaultInitialCapacity        = 100000
+	DefaultRegistryShards         = 64
 	DefaultReadBufferSize         = 4096
 	DefaultWriteBufferSize        = 4096
 	DefaultDeviceMessageQueueSize = 100
@@ -34,6 +45,51 @@ type Options struct {
 	// If not specified, DefaultConveyHeader is used.
 	ConveyHeader string
 
+	// ConveyCompressionThreshold is the size, in bytes, of an encoded convey value above which
+	// dialers send the gzip-compressed form instead.  If not supplied, conveys are never compressed,
+	// which is required when connecting to servers that do not understand compressed conveys.
+	ConveyCompressionThreshold int
+
+	// DeviceNameSources is the ordered fallback chain used to obtain the device name when a device
+	// connects.  If not supplied, only the DeviceNameHeader is consulted.
+	DeviceNameSources []Source
+
+	// ConveySources is the ordered fallback chain used to obtain the convey value when a device
+	// connects.  If not supplied, only the ConveyHeader is consulted.
+	ConveySources []Source
+
+	// MaxConveySize is the maximum length, in bytes, of the encoded convey value a device may
+	// connect with.  Longer values are handled according to ConveyPolicy.  If not positive, there
+	// is no limit.
+	MaxConveySize int
+
+	// ConveyPolicy is the action taken for devices that connect with convey values that are too
+	// large or cannot be parsed.  If not supplied or unrecognized, RejectInvalidConvey is used.
+	ConveyPolicy ConveyPolicy
+
+	// ConveyCodec translates convey values between their on-the-wire form and Convey maps, both for devices
+	// connecting to a Manager and for Dialers.  Use NewValidatingConveyCodec to reject malformed conveys at
+	// connection time.  If not supplied, JSONConveyCodec is used.
+	ConveyCodec ConveyCodec
+
+	// ConveyTags are the convey keys whose values are attached to connecting devices as Tags of the same name,
+	// e.g. ConveyModelKey and ConveyFirmwareVersionKey for model and firmware cohorts.  Keys whose values are
+	// missing or are not scalars produce no tag.  Tags from the ConnectAuthorizer take precedence.
+	ConveyTags []string
+
+	// CheckOrigin is the policy applied to the Origin header during websocket upgrades.  If set,
+	// AllowedOrigins and AllowedOriginPatterns are ignored.
+	CheckOrigin OriginChecker
+
+	// AllowedOrigins is the allowlist of exact Origin values accepted during websocket upgrades,
+	// e.g. for browser-based simulators.  Requests without an Origin header are always accepted.
+	AllowedOrigins []string
+
+	// AllowedOriginPatterns are regular expressions for Origin values accepted during websocket upgrades.
+	// These are combined with AllowedOrigins, and a request matching either is accepted.  If neither
+	// is supplied and CheckOrigin is nil, SameOrigin is used.
+	AllowedOriginPatterns []string
+
 	// HandshakeTimeout is the optional websocket handshake timeout.  If not supplied,
 	// the internal gorilla default is used.
 	HandshakeTimeout time.Duration
@@ -50,6 +106,11 @@ type Options struct {
 	// registered devices.  If not supplied, DefaultInitialCapacity is used.
 	InitialCapacity int
 
+	// RegistryShards is the number of independently locked buckets that the internal registry of devices
+	// is divided into.  Connects, disconnects, and routing for devices in different buckets do not contend
+	// with one another.  If not supplied, DefaultRegistryShards is used.
+	RegistryShards int
+
 	// ReadBufferSize is the optional size of websocket read buffers.  If not supplied,
 	// the internal gorilla default is used.
 	ReadBufferSize int
@@ -58,13 +119,109 @@ type Options struct {
 	// the internal gorilla default is used.
 	WriteBufferSize int
 
-	// Subprotocols is the optional slice of websocket subprotocols to use.
+	// EnableCompression turns on the permessage-deflate websocket extension.  Servers accept the extension
+	// when a device offers it, and dialers offer it to servers.  Frames are only compressed on connections
+	// where both ends support the extension.
+	EnableCompression bool
+
+	// CompressionLevel is the flate level of compressed frames, from -2 (flate.HuffmanOnly) through
+	// 9 (flate.BestCompression).  If not supplied or invalid, DefaultCompressionLevel is used.
+	// flate.NoCompression cannot be configured, as it is the zero value.
+	CompressionLevel int
+
+	// CompressionThreshold is the size, in bytes, below which frames are written uncompressed, because
+	// compressing small frames costs more than it saves.  If not supplied, DefaultCompressionThreshold is used.
+	// A negative value compresses every frame.
+	CompressionThreshold int
+
+	// Subprotocols is the optional slice of websocket subprotocols to use.  Servers always accept
+	// MsgpackSubprotocol and JSONSubprotocol in addition to these.  Dialers offer exactly these
+	// subprotocols, so include JSONSubprotocol to request JSON-encoded messages.
 	Subprotocols []string
 
-	// DeviceMessageQueueSize is the capacity of the channel which stores messages waiting
-	// to be transmitted to a device.  If not supplied, DefaultDeviceMessageQueueSize is used.
+	// Protocols are the versioned device protocols, in order of preference, that servers accept and dialers
+	// offer ahead of Subprotocols.  A connection that negotiates one of these protocols uses its encoding and
+	// frame type.  Devices that offer none of them use the encoding subprotocols as usual.
+	Protocols []Protocol
+
+	// MsgpackFrameType is the websocket frame type used to write and accept Msgpack-encoded messages.
+	// Frames of any other type are skipped.  If not supplied or invalid, DefaultFrameType(wrp.Msgpack) is used.
+	MsgpackFrameType FrameType
+
+	// JSONFrameType is the websocket frame type used to write and accept JSON-encoded messages.
+	// If not supplied or invalid, DefaultFrameType(wrp.JSON) is used.
+	JSONFrameType FrameType
+
+	// DeviceMessageQueueSize is the capacity of each of the channels, one per Priority, which store
+	// messages waiting to be transmitted to a device.  If not supplied, DefaultDeviceMessageQueueSize is used.
 	DeviceMessageQueueSize int
 
+	// PriorityQueueSizes optionally sizes the queues of individual priority classes.  Each device has one
+	// queue per priority class, and classes missing from this map, or with nonpositive sizes, use
+	// DeviceMessageQueueSize.
+	PriorityQueueSizes map[Priority]int
+
+	// QueuePolicy determines what happens to a message sent to a device whose queue is full.  Each Request
+	// may override this policy.  If not supplied or unrecognized, BlockFullQueue is used.
+	QueuePolicy QueuePolicy
+
+	// SlowConsumerQueueThreshold is the number of queued messages at or above which a device is
+	// considered backed up.  If not supplied, queue occupancy is not used to detect slow consumers.
+	SlowConsumerQueueThreshold int
+
+	// SlowConsumerWriteStall is the duration at or above which a single write to a device is
+	// considered stalled.  If not supplied, write stalls are not used to detect slow consumers.
+	SlowConsumerWriteStall time.Duration
+
+	// SlowConsumerPeriod is how long a device must continuously exceed either threshold before
+	// SlowConsumerPolicy is applied.  If not supplied, DefaultSlowConsumerPeriod is used.
+	SlowConsumerPeriod time.Duration
+
+	// SlowConsumerPolicy is the action taken against slow consumers.  If not supplied or
+	// unrecognized, CloseSlowConsumer is used.
+	SlowConsumerPolicy SlowConsumerPolicy
+
+	// MaxMessagesPerSecond is the average rate at which each device may send messages.  Frames read
+	// beyond this rate are handled according to RateLimitPolicy.  If not positive, and if no
+	// RateLimiterFactory is supplied, devices are not rate limited.
+	MaxMessagesPerSecond float64
+
+	// MessageBurst is the number of messages a device may send at once before MaxMessagesPerSecond
+	// applies.  If not positive, MaxMessagesPerSecond rounded up is used.
+	MessageBurst int
+
+	// RateLimiterFactory is the optional source of each device's RateLimiter.  If supplied, this
+	// factory is used instead of the token bucket described by MaxMessagesPerSecond and MessageBurst.
+	RateLimiterFactory RateLimiterFactory
+
+	// RateLimitPolicy is the action taken for devices that exceed their rate limit.  If not supplied
+	// or unrecognized, DropRateLimited is used.
+	RateLimitPolicy RateLimitPolicy
+
+	// DecodeFailureThreshold is the number of undecodable frames after which a device is quarantined,
+	// i.e. disconnected with a "decode failure" close reason.  If not supplied, devices are never quarantined
+	// for sending undecodable frames.
+	DecodeFailureThreshold int
+
+	// IdempotencyTTL is how long each device remembers the outcome of a request carrying an idempotency key.
+	// If not supplied, DefaultIdempotencyTTL is used.  If negative, idempotency keys are ignored.
+	IdempotencyTTL time.Duration
+
+	// IdempotencyCacheSize is the maximum number of idempotency keys remembered for each device.
+	// Requests still in flight are never forgotten, so when every remembered key is in flight, requests
+	// with new keys fail with ErrorIdempotencyCacheFull.  If not supplied, DefaultIdempotencyCacheSize is used.
+	IdempotencyCacheSize int
+
+	// TransactionTTL is how long a request waits for its device's response before Send returns
+	// ErrorTransactionTimeout, whatever the request's context.  If not supplied, requests wait on
+	// their contexts alone.
+	TransactionTTL time.Duration
+
+	// TransactionSweepInterval is how often each device's expired transactions are removed, so
+	// a transaction can outlive TransactionTTL by up to this interval.  If not supplied,
+	// DefaultTransactionSweepInterval is used.
+	TransactionSweepInterval time.Duration
+
 	// PingPeriod is the time between pings sent to each device
 	PingPeriod time.Duration
 
@@ -72,6 +229,16 @@ type Options struct {
 	// with no traffic coming from the device.  If not supplied, DefaultIdlePeriod is used.
 	IdlePeriod time.Duration
 
+	// ReadTimeout is the time allowed to read the remainder of a frame once its header has arrived, which
+	// guards against devices that stall partway through a frame.  If not positive, a frame may take as long
+	// as the IdlePeriod allows.
+	ReadTimeout time.Duration
+
+	// IdleTimeout is the length of time a device may go without sending any frames before it is disconnected.
+	// Unlike IdlePeriod, this timeout is not extended by pongs, so it closes devices that answer pings but
+	// are otherwise stale.  If not positive, no idle timeout is enforced.
+	IdleTimeout time.Duration
+
 	// WriteTimeout is the write timeout for each device's websocket.  If not supplied,
 	// DefaultWriteTimeout is used.
 	WriteTimeout time.Duration
@@ -83,9 +250,149 @@ type Options struct {
 	// If this value is nil, then UUIDKeyFunc is used along with crypto/rand's Reader.
 	KeyFunc KeyFunc
 
+	// ConnectAuthorizer is the optional hook consulted before each websocket upgrade, which can reject
+	// connections or tag them.  If not supplied, all devices that pass the other checks are admitted.
+	ConnectAuthorizer ConnectAuthorizer
+
 	// Logger is the output sink for log messages.  If not supplied, log output
 	// is sent to logging.DefaultLogger().
 	Logger logging.Logger
+
+	// Metrics is the provider for device connection metrics.  If not supplied,
+	// metrics are discarded.  An xmetrics.Registry exposes these metrics to Prometheus.
+	Metrics xmetrics.Provider
+
+	// TracerProvider is the source of spans for routing and device pumps.  If not supplied,
+	// no spans are recorded.
+	TracerProvider trace.TracerProvider
+
+	// TransactionTracer is the hook invoked around each Send to a device, which allows spans from other
+	// tracing APIs to be started and finished.  If not supplied, each Send is covered by a TransactionSpan
+	// from TracerProvider.
+	TransactionTracer TransactionTracer
+
+	// Gate is the traffic gate consulted when devices connect.  While the gate is closed,
+	// connection attempts are rejected with a 503.  If not supplied, connections are always allowed.
+	Gate gate.Interface
+
+	// Backoff is the optional policy that computes retry hints for connections rejected due to
+	// overload, such as while the Gate is closed.  If not supplied, rejections carry no hints.
+	Backoff gate.BackoffPolicy
+
+	// Spool is the optional persistent store for messages routed to devices that are not connected.
+	// Spooled messages are replayed when the device reconnects.  If not supplied, such messages
+	// are rejected with ErrorDeviceNotFound.
+	Spool spool.Interface
+
+	// QueueDrainHandler is the optional hook that receives the messages still queued for a device when it
+	// disconnects.  MessageFailed events are dispatched for these messages regardless.  Use SpoolQueueDrainHandler
+	// to replay them when the device reconnects.  If not supplied, such messages are discarded.
+	QueueDrainHandler QueueDrainHandler
+
+	// SessionGracePeriod is how long the session of a disconnected device is kept, so that a reconnecting
+	// device presenting its session token gets back its pending transactions and queued messages.  If not
+	// supplied, sessions are disabled and no session tokens are issued.
+	SessionGracePeriod time.Duration
+
+	// SessionHeader is the HTTP header carrying session tokens, in both the websocket upgrade
+	// response and a reconnecting device's request.  If not supplied, DefaultSessionHeader is used.
+	SessionHeader string
+
+	// Signer is the optional HMAC signer applied to each WRP message sent to devices.  If not
+	// supplied, outbound messages are not signed.
+	Signer *secure.MessageSigner
+
+	// Verifier is the optional HMAC verifier applied to each WRP message received from devices.
+	// If not supplied, inbound signatures are not checked.
+	Verifier *secure.MessageVerifier
+
+	// SignaturePolicy is the action taken for inbound messages that fail verification.  If not
+	// supplied or unrecognized, RejectBadSignature is used.
+	SignaturePolicy SignaturePolicy
+
+	// MessageValidator is the optional policy applied to each WRP message received from devices, e.g.
+	// wrp.Validators composed of the validators in the wrp package.  Messages that fail validation are
+	// logged, counted, and dropped before they reach listeners or transactions.  If not supplied, any
+	// message that decodes is accepted.
+	MessageValidator wrp.Validator
+
+	// SoftDeviceLimit is the number of connected devices above which a manager logs warnings, counts
+	// a metric, and sets DeviceSoftLimitStat in Health.  Devices are still accepted.  If not positive,
+	// there is no soft limit.
+	SoftDeviceLimit int
+
+	// HardDeviceLimit is the maximum number of connected devices.  What happens to devices that connect
+	// beyond this limit depends on DeviceLimitPolicy.  If not positive, there is no hard limit.
+	HardDeviceLimit int
+
+	// DeviceLimitPolicy is the action taken when a device connects to a manager at its HardDeviceLimit.
+	// If not supplied or unrecognized, RejectOverLimit is used.
+	DeviceLimitPolicy DeviceLimitPolicy
+
+	// DeviceLimitRecovery is the device count to which a manager that reached its HardDeviceLimit must fall
+	// before it has recovered.  Until then, RejectOverLimit rejects new devices.  Reaching the limit and
+	// recovering from it each dispatch an event and update DeviceHardLimitStat in Health, so autoscaling can
+	// react.  If not positive, or not less than HardDeviceLimit, HardDeviceLimit - 1 is used.
+	DeviceLimitRecovery int
+
+	// Health is the optional monitor that receives DeviceSoftLimitStat and DeviceHardLimitStat
+	Health health.Monitor
+
+	// ResponseRouter matches responses read from devices with waiting transactions.
+	// If not supplied, LocalResponseRouter is used.
+	ResponseRouter ResponseRouter
+
+	// DispatchPolicy selects which devices receive a request when more than one connected device
+	// has the request's destination ID.  If not supplied, RejectDuplicates is used.
+	DispatchPolicy DispatchPolicy
+
+	// BroadcastConcurrency is the maximum number of devices that a single Broadcast sends to at once.
+	// If not positive, DefaultBroadcastConcurrency is used.
+	BroadcastConcurrency int
+
+	// HopRecorder is the optional recorder that appends a hop to the spans of each WRP message
+	// read from or written to a device.  If not supplied, no hops are recorded.
+	HopRecorder *wrp.HopRecorder
+
+	// InstanceID identifies this server instance, typically from service.Options.Identity.  When set,
+	// it is appended to the reason of the close frames sent to devices so that devices and operators
+	// can tell which instance closed a connection.
+	InstanceID string
+
+	// RPCHandlers holds the initial handlers for server-bound requests from devices, keyed by
+	// destination service.  More handlers can be registered with Manager.HandleRPC.
+	RPCHandlers map[string]RPCHandler
+
+	// AckTimeout is how long the first write of a message sent with Request.Acknowledge waits for the
+	// device's acknowledgement before the message is written again.  Each retry waits twice as long as
+	// the one before, up to AckMaxTimeout.  If not positive, DefaultAckTimeout is used.
+	AckTimeout time.Duration
+
+	// AckMaxTimeout is the longest wait for any single acknowledgement.  If not positive,
+	// DefaultAckMaxTimeout is used.
+	AckMaxTimeout time.Duration
+
+	// AckRetries is the number of times an unacknowledged message is written again before Send fails
+	// with ErrorNotAcknowledged.  If not positive, DefaultAckRetries is used.
+	AckRetries int
+
+	// MaxBatchSize is the largest count of queued messages that a write pump coalesces into a single batch
+	// envelope, for devices that set the BatchHeader to true when connecting.  If this value is less than 2,
+	// batching is disabled and every message is written in its own frame.
+	MaxBatchSize int
+
+	// MaxBatchBytes is the payload size at which a batch is written even if it holds fewer than MaxBatchSize
+	// messages, so a batch exceeds this size by at most one message.  If not positive, DefaultMaxBatchBytes is used.
+	MaxBatchBytes int
+
+	// BatchLinger is how long a write pump waits for more messages to be queued before writing a batch
+	// that is not yet full.  If not positive, only the messages already queued are batched, so batching adds
+	// no latency.
+	BatchLinger time.Duration
+
+	// BatchHeader is the HTTP header that a device sets to true to accept batch envelopes.  If not
+	// supplied, DefaultBatchHeader is used.
+	BatchHeader string
 }
 
 func (o *Options) deviceNameHeader() string {
@@ -104,6 +411,205 @@ func (o *Options) conveyHeader() string {
 	return DefaultConveyHeader
 }
 
+func (o *Options) deviceNameSource() Source {
+	if o != nil && len(o.DeviceNameSources) > 0 {
+		return FirstOf(o.DeviceNameSources...)
+	}
+
+	return HeaderSource(o.deviceNameHeader())
+}
+
+func (o *Options) conveySource() Source {
+	if o != nil && len(o.ConveySources) > 0 {
+		return FirstOf(o.ConveySources...)
+	}
+
+	return HeaderSource(o.conveyHeader())
+}
+
+func (o *Options) originChecker() OriginChecker {
+	if o == nil {
+		return SameOrigin
+	}
+
+	if o.CheckOrigin != nil {
+		return o.CheckOrigin
+	}
+
+	var checkers []OriginChecker
+	if len(o.AllowedOrigins) > 0 {
+		checkers = append(checkers, AllowOrigins(o.AllowedOrigins...))
+	}
+
+	if len(o.AllowedOriginPatterns) > 0 {
+		patterns := make([]*regexp.Regexp, 0, len(o.AllowedOriginPatterns))
+		for _, expression := range o.AllowedOriginPatterns {
+			if pattern, err := regexp.Compile(expression); err != nil {
+				o.logger().Error("Ignoring invalid origin pattern [%s]: %s", expression, err)
+			} else {
+				patterns = append(patterns, pattern)
+			}
+		}
+
+		checkers = append(checkers, MatchOrigins(patterns...))
+	}
+
+	if len(checkers) == 0 {
+		return SameOrigin
+	}
+
+	return AnyOf(checkers...)
+}
+
+// slowConsumerDetector creates a new detector for a device's write pump.  If no thresholds
+// are configured, this method returns nil.
+func (o *Options) slowConsumerDetector() *slowConsumerDetector {
+	if o == nil || (o.SlowConsumerQueueThreshold < 1 && o.SlowConsumerWriteStall <= 0) {
+		return nil
+	}
+
+	period := o.SlowConsumerPeriod
+	if period <= 0 {
+		period = DefaultSlowConsumerPeriod
+	}
+
+	return &slowConsumerDetector{
+		queueThreshold: o.SlowConsumerQueueThreshold,
+		writeStall:     o.SlowConsumerWriteStall,
+		period:         period,
+	}
+}
+
+func (o *Options) slowConsumerPolicy() SlowConsumerPolicy {
+	if o != nil && o.SlowConsumerPolicy == DegradeSlowConsumer {
+		return DegradeSlowConsumer
+	}
+
+	return CloseSlowConsumer
+}
+
+func (o *Options) rateLimiterFactory() RateLimiterFactory {
+	if o == nil {
+		return nil
+	}
+
+	if o.RateLimiterFactory != nil {
+		return o.RateLimiterFactory
+	}
+
+	if o.MaxMessagesPerSecond > 0 {
+		rate, burst := o.MaxMessagesPerSecond, o.MessageBurst
+		return func(Interface) RateLimiter {
+			return NewTokenBucket(rate, burst)
+		}
+	}
+
+	return nil
+}
+
+func (o *Options) rateLimitPolicy() RateLimitPolicy {
+	if o != nil && o.RateLimitPolicy == CloseRateLimited {
+		return CloseRateLimited
+	}
+
+	return DropRateLimited
+}
+
+func (o *Options) decodeFailureThreshold() int {
+	if o != nil && o.DecodeFailureThreshold > 0 {
+		return o.DecodeFailureThreshold
+	}
+
+	return 0
+}
+
+func (o *Options) idempotencyTTL() time.Duration {
+	if o != nil && o.IdempotencyTTL != 0 {
+		return o.IdempotencyTTL
+	}
+
+	return DefaultIdempotencyTTL
+}
+
+func (o *Options) transactionTTL() time.Duration {
+	if o != nil && o.TransactionTTL > 0 {
+		return o.TransactionTTL
+	}
+
+	return 0
+}
+
+func (o *Options) transactionSweepInterval() time.Duration {
+	if o != nil && o.TransactionSweepInterval > 0 {
+		return o.TransactionSweepInterval
+	}
+
+	return DefaultTransactionSweepInterval
+}
+
+func (o *Options) idempotencyCacheSize() int {
+	if o != nil && o.IdempotencyCacheSize > 0 {
+		return o.IdempotencyCacheSize
+	}
+
+	return DefaultIdempotencyCacheSize
+}
+
+func (o *Options) maxConveySize() int {
+	if o != nil && o.MaxConveySize > 0 {
+		return o.MaxConveySize
+	}
+
+	return 0
+}
+
+func (o *Options) conveyCodec() ConveyCodec {
+	if o != nil && o.ConveyCodec != nil {
+		return o.ConveyCodec
+	}
+
+	return JSONConveyCodec
+}
+
+func (o *Options) conveyPolicy() ConveyPolicy {
+	if o != nil {
+		switch o.ConveyPolicy {
+		case FlagInvalidConvey, RawInvalidConvey:
+			return o.ConveyPolicy
+		}
+	}
+
+	return RejectInvalidConvey
+}
+
+func (o *Options) conveyCompressionThreshold() int {
+	if o != nil && o.ConveyCompressionThreshold > 0 {
+		return o.ConveyCompressionThreshold
+	}
+
+	return 0
+}
+
+// frameType returns the websocket message type for the given encoding
+func (o *Options) frameType(f wrp.Format) int {
+	var configured FrameType
+	if o != nil {
+		switch f {
+		case wrp.Msgpack:
+			configured = o.MsgpackFrameType
+		case wrp.JSON:
+			configured = o.JSONFrameType
+		}
+	}
+
+	if messageType, err := configured.messageType(); err == nil {
+		return messageType
+	}
+
+	messageType, _ := DefaultFrameType(f).messageType()
+	return messageType
+}
+
 func (o *Options) deviceMessageQueueSize() int {
 	if o != nil && o.DeviceMessageQueueSize > 0 {
 		return o.DeviceMessageQueueSize
@@ -112,6 +618,31 @@ func (o *Options) deviceMessageQueueSize() int {
 	return DefaultDeviceMessageQueueSize
 }
 
+func (o *Options) priorityQueueSize(p Priority) int {
+	if o != nil && o.PriorityQueueSizes[p] > 0 {
+		return o.PriorityQueueSizes[p]
+	}
+
+	return o.deviceMessageQueueSize()
+}
+
+// priorityQueueSizes returns the queue size of every priority class, indexed by Priority
+func (o *Options) priorityQueueSizes() (sizes [priorityClasses]int) {
+	for p := LowPriority; p < priorityClasses; p++ {
+		sizes[p] = o.priorityQueueSize(p)
+	}
+
+	return
+}
+
+func (o *Options) queuePolicy() QueuePolicy {
+	if o != nil && o.QueuePolicy.valid() {
+		return o.QueuePolicy
+	}
+
+	return BlockFullQueue
+}
+
 func (o *Options) handshakeTimeout() time.Duration {
 	if o != nil && o.HandshakeTimeout > 0 {
 		return o.HandshakeTimeout
@@ -144,6 +675,14 @@ func (o *Options) initialCapacity() int {
 	return DefaultInitialCapacity
 }
 
+func (o *Options) registryShards() int {
+	if o != nil && o.RegistryShards > 0 {
+		return o.RegistryShards
+	}
+
+	return DefaultRegistryShards
+}
+
 func (o *Options) idlePeriod() time.Duration {
 	if o != nil && o.IdlePeriod > 0 {
 		return o.IdlePeriod
@@ -152,6 +691,22 @@ func (o *Options) idlePeriod() time.Duration {
 	return DefaultIdlePeriod
 }
 
+func (o *Options) readTimeout() time.Duration {
+	if o != nil && o.ReadTimeout > 0 {
+		return o.ReadTimeout
+	}
+
+	return 0
+}
+
+func (o *Options) idleTimeout() time.Duration {
+	if o != nil && o.IdleTimeout > 0 {
+		return o.IdleTimeout
+	}
+
+	return 0
+}
+
 func (o *Options) pingPeriod() time.Duration {
 	if o != nil && o.PingPeriod > 0 {
 		return o.PingPeriod
@@ -184,6 +739,28 @@ func (o *Options) writeBufferSize() int {
 	return DefaultWriteBufferSize
 }
 
+func (o *Options) enableCompression() bool {
+	return o != nil && o.EnableCompression
+}
+
+func (o *Options) compressionLevel() int {
+	if o != nil && o.CompressionLevel != 0 && o.CompressionLevel >= minimumCompressionLevel && o.CompressionLevel <= flate.BestCompression {
+		return o.CompressionLevel
+	}
+
+	return DefaultCompressionLevel
+}
+
+func (o *Options) compressionThreshold() int {
+	if o == nil || o.CompressionThreshold == 0 {
+		return DefaultCompressionThreshold
+	} else if o.CompressionThreshold < 0 {
+		return 0
+	}
+
+	return o.CompressionThreshold
+}
+
 func (o *Options) subprotocols() (subprotocols []string) {
 	if o != nil && len(o.Subprotocols) > 0 {
 		subprotocols = make([]string, len(o.Subprotocols))
@@ -193,6 +770,15 @@ func (o *Options) subprotocols() (subprotocols []string) {
 	return
 }
 
+func (o *Options) protocols() (protocols []Protocol) {
+	if o != nil && len(o.Protocols) > 0 {
+		protocols = make([]Protocol, len(o.Protocols))
+		copy(protocols, o.Protocols)
+	}
+
+	return
+}
+
 func (o *Options) keyFunc() KeyFunc {
 	if o != nil && o.KeyFunc != nil {
 		return o.KeyFunc
@@ -216,3 +802,255 @@ func (o *Options) listeners() []Listener {
 
 	return nil
 }
+
+func (o *Options) metricsProvider() xmetrics.Provider {
+	if o != nil && o.Metrics != nil {
+		return o.Metrics
+	}
+
+	return xmetrics.NewDiscardProvider()
+}
+
+func (o *Options) tracerProvider() trace.TracerProvider {
+	if o != nil && o.TracerProvider != nil {
+		return o.TracerProvider
+	}
+
+	return noop.NewTracerProvider()
+}
+
+func (o *Options) transactionTracer() TransactionTracer {
+	if o != nil && o.TransactionTracer != nil {
+		return o.TransactionTracer
+	}
+
+	return NewTransactionTracer(o.tracerProvider().Tracer(TracerName))
+}
+
+func (o *Options) conveyTags() []string {
+	if o != nil {
+		return o.ConveyTags
+	}
+
+	return nil
+}
+
+func (o *Options) connectAuthorizer() ConnectAuthorizer {
+	if o != nil {
+		return o.ConnectAuthorizer
+	}
+
+	return nil
+}
+
+func (o *Options) gate() gate.Interface {
+	if o != nil && o.Gate != nil {
+		return o.Gate
+	}
+
+	return gate.New(true)
+}
+
+func (o *Options) backoff() gate.BackoffPolicy {
+	if o != nil {
+		return o.Backoff
+	}
+
+	return nil
+}
+
+func (o *Options) signer() *secure.MessageSigner {
+	if o != nil {
+		return o.Signer
+	}
+
+	return nil
+}
+
+func (o *Options) verifier() *secure.MessageVerifier {
+	if o != nil {
+		return o.Verifier
+	}
+
+	return nil
+}
+
+func (o *Options) messageValidator() wrp.Validator {
+	if o != nil {
+		return o.MessageValidator
+	}
+
+	return nil
+}
+
+func (o *Options) signaturePolicy() SignaturePolicy {
+	if o != nil && o.SignaturePolicy == FlagBadSignature {
+		return FlagBadSignature
+	}
+
+	return RejectBadSignature
+}
+
+func (o *Options) capacity() *capacity {
+	c := new(capacity)
+	if o != nil {
+		if o.SoftDeviceLimit > 0 {
+			c.soft = int32(o.SoftDeviceLimit)
+		}
+
+		if o.HardDeviceLimit > 0 {
+			c.hard = int32(o.HardDeviceLimit)
+			c.recovery = c.hard - 1
+			if o.DeviceLimitRecovery > 0 && o.DeviceLimitRecovery < o.HardDeviceLimit {
+				c.recovery = int32(o.DeviceLimitRecovery)
+			}
+		}
+
+		if o.DeviceLimitPolicy == EvictIdleOverLimit {
+			c.policy = EvictIdleOverLimit
+		}
+	}
+
+	if len(c.policy) == 0 {
+		c.policy = RejectOverLimit
+	}
+
+	return c
+}
+
+func (o *Options) health() health.Monitor {
+	if o != nil {
+		return o.Health
+	}
+
+	return nil
+}
+
+func (o *Options) responseRouter() ResponseRouter {
+	if o != nil && o.ResponseRouter != nil {
+		return o.ResponseRouter
+	}
+
+	return LocalResponseRouter
+}
+
+func (o *Options) broadcastConcurrency() int {
+	if o != nil && o.BroadcastConcurrency > 0 {
+		return o.BroadcastConcurrency
+	}
+
+	return DefaultBroadcastConcurrency
+}
+
+func (o *Options) dispatchPolicy() DispatchPolicy {
+	if o != nil && o.DispatchPolicy != nil {
+		return o.DispatchPolicy
+	}
+
+	return RejectDuplicates
+}
+
+func (o *Options) hopRecorder() *wrp.HopRecorder {
+	if o != nil {
+		return o.HopRecorder
+	}
+
+	return nil
+}
+
+func (o *Options) instanceID() string {
+	if o != nil {
+		return o.InstanceID
+	}
+
+	return ""
+}
+
+func (o *Options) rpcHandlers() map[string]RPCHandler {
+	if o != nil {
+		return o.RPCHandlers
+	}
+
+	return nil
+}
+
+func (o *Options) spool() spool.Interface {
+	if o != nil {
+		return o.Spool
+	}
+
+	return nil
+}
+
+func (o *Options) queueDrainHandler() QueueDrainHandler {
+	if o != nil {
+		return o.QueueDrainHandler
+	}
+
+	return nil
+}
+
+func (o *Options) sessionGracePeriod() time.Duration {
+	if o != nil && o.SessionGracePeriod > 0 {
+		return o.SessionGracePeriod
+	}
+
+	return 0
+}
+
+func (o *Options) sessionHeader() string {
+	if o != nil && len(o.SessionHeader) > 0 {
+		return o.SessionHeader
+	}
+
+	return DefaultSessionHeader
+}
+
+func (o *Options) ackPolicy() ackPolicy {
+	policy := ackPolicy{
+		timeout:    DefaultAckTimeout,
+		maxTimeout: DefaultAckMaxTimeout,
+		retries:    DefaultAckRetries,
+	}
+
+	if o != nil {
+		if o.AckTimeout > 0 {
+			policy.timeout = o.AckTimeout
+		}
+
+		if o.AckMaxTimeout > 0 {
+			policy.maxTimeout = o.AckMaxTimeout
+		}
+
+		if o.AckRetries > 0 {
+			policy.retries = o.AckRetries
+		}
+	}
+
+	if policy.maxTimeout < policy.timeout {
+		policy.maxTimeout = policy.timeout
+	}
+
+	return policy
+}
+
+func (o *Options) batchPolicy() batchPolicy {
+	policy := batchPolicy{maxBytes: DefaultMaxBatchBytes}
+	if o != nil {
+		policy.maxSize = o.MaxBatchSize
+		policy.linger = o.BatchLinger
+		if o.MaxBatchBytes > 0 {
+			policy.maxBytes = o.MaxBatchBytes
+		}
+	}
+
+	return policy
+}
+
+func (o *Options) batchHeader() string {
+	if o != nil && len(o.BatchHeader) > 0 {
+		return o.BatchHeader
+	}
+
+	return DefaultBatchHeader
+}
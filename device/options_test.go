@@ -16,12 +16,17 @@ func TestOptionsDefault(t *testing.T) {
 
 		assert.Equal(DefaultDeviceNameHeader, o.deviceNameHeader())
 		assert.Equal(DefaultConveyHeader, o.conveyHeader())
+		assert.Equal(0, o.conveyCompressionThreshold())
+		assert.Equal(0, o.maxConveySize())
+		assert.Equal(RejectInvalidConvey, o.conveyPolicy())
 		assert.Equal(DefaultDeviceMessageQueueSize, o.deviceMessageQueueSize())
 		assert.Equal(DefaultHandshakeTimeout, o.handshakeTimeout())
 		assert.Equal(DefaultDecoderPoolSize, o.decoderPoolSize())
 		assert.Equal(DefaultEncoderPoolSize, o.encoderPoolSize())
 		assert.Equal(DefaultInitialCapacity, o.initialCapacity())
 		assert.Equal(DefaultIdlePeriod, o.idlePeriod())
+		assert.Zero(o.readTimeout())
+		assert.Zero(o.idleTimeout())
 		assert.Equal(DefaultPingPeriod, o.pingPeriod())
 		assert.Equal(DefaultWriteTimeout, o.writeTimeout())
 		assert.Equal(DefaultReadBufferSize, o.readBufferSize())
@@ -44,33 +49,44 @@ func TestOptions(t *testing.T) {
 		}
 
 		o = Options{
-			DeviceNameHeader:       "X-TestOptions-Device-Name",
-			ConveyHeader:           "X-TestOptions-Convey",
-			HandshakeTimeout:       DefaultHandshakeTimeout + 12377123*time.Second,
-			DecoderPoolSize:        672393,
-			EncoderPoolSize:        1034571,
-			InitialCapacity:        DefaultInitialCapacity + 4719,
-			ReadBufferSize:         DefaultReadBufferSize + 48729,
-			WriteBufferSize:        DefaultWriteBufferSize + 926,
-			Subprotocols:           []string{"foobar"},
-			DeviceMessageQueueSize: DefaultDeviceMessageQueueSize + 287342,
-			IdlePeriod:             DefaultIdlePeriod + 3472*time.Minute,
-			PingPeriod:             DefaultPingPeriod + 384*time.Millisecond,
-			WriteTimeout:           DefaultWriteTimeout + 327193*time.Second,
-			KeyFunc:                expectedKeyFunc,
-			Logger:                 expectedLogger,
-			Listeners:              []Listener{func(*Event) {}},
+			DeviceNameHeader:           "X-TestOptions-Device-Name",
+			ConveyHeader:               "X-TestOptions-Convey",
+			ConveyCompressionThreshold: 1024,
+			MaxConveySize:              4096,
+			ConveyPolicy:               RawInvalidConvey,
+			HandshakeTimeout:           DefaultHandshakeTimeout + 12377123*time.Second,
+			DecoderPoolSize:            672393,
+			EncoderPoolSize:            1034571,
+			InitialCapacity:            DefaultInitialCapacity + 4719,
+			ReadBufferSize:             DefaultReadBufferSize + 48729,
+			WriteBufferSize:            DefaultWriteBufferSize + 926,
+			Subprotocols:               []string{"foobar"},
+			DeviceMessageQueueSize:     DefaultDeviceMessageQueueSize + 287342,
+			IdlePeriod:                 DefaultIdlePeriod + 3472*time.Minute,
+			ReadTimeout:                17 * time.Second,
+			IdleTimeout:                DefaultIdlePeriod + 12*time.Minute,
+			PingPeriod:                 DefaultPingPeriod + 384*time.Millisecond,
+			WriteTimeout:               DefaultWriteTimeout + 327193*time.Second,
+			KeyFunc:                    expectedKeyFunc,
+			Logger:                     expectedLogger,
+			Listeners:                  []Listener{func(*Event) {}},
 		}
 	)
 
 	assert.Equal(o.DeviceNameHeader, o.deviceNameHeader())
 	assert.Equal(o.ConveyHeader, o.conveyHeader())
+	assert.Equal(o.ConveyCompressionThreshold, o.conveyCompressionThreshold())
+	assert.Equal(o.MaxConveySize, o.maxConveySize())
+	assert.Equal(o.ConveyPolicy, o.conveyPolicy())
+	assert.Equal(RejectInvalidConvey, (&Options{ConveyPolicy: "unrecognized"}).conveyPolicy())
 	assert.Equal(o.DeviceMessageQueueSize, o.deviceMessageQueueSize())
 	assert.Equal(o.HandshakeTimeout, o.handshakeTimeout())
 	assert.Equal(o.DecoderPoolSize, o.decoderPoolSize())
 	assert.Equal(o.EncoderPoolSize, o.encoderPoolSize())
 	assert.Equal(o.InitialCapacity, o.initialCapacity())
 	assert.Equal(o.IdlePeriod, o.idlePeriod())
+	assert.Equal(o.ReadTimeout, o.readTimeout())
+	assert.Equal(o.IdleTimeout, o.idleTimeout())
 	assert.Equal(o.PingPeriod, o.pingPeriod())
 	assert.Equal(o.WriteTimeout, o.writeTimeout())
 	assert.Equal(o.ReadBufferSize, o.readBufferSize())
@@ -0,0 +1,76 @@
+package device
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// OriginChecker is the policy applied to the Origin header of websocket upgrade requests.
+// It returns true if the upgrade may proceed.
+type OriginChecker func(*http.Request) bool
+
+// SameOrigin is the default OriginChecker.  It allows requests without an Origin header,
+// which is how devices normally connect, and requests whose Origin host matches the request host.
+func SameOrigin(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == request.Host
+}
+
+// AnyOrigin is an OriginChecker that allows every request.  It is only appropriate for development.
+func AnyOrigin(*http.Request) bool {
+	return true
+}
+
+// AllowOrigins produces an OriginChecker that allows requests without an Origin header along with
+// requests whose Origin exactly matches one of the given values, e.g. https://simulator.example.com:8443.
+// Comparisons are case-insensitive.
+func AllowOrigins(origins ...string) OriginChecker {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.ToLower(o)] = true
+	}
+
+	return func(request *http.Request) bool {
+		origin := request.Header.Get("Origin")
+		return len(origin) == 0 || allowed[strings.ToLower(origin)]
+	}
+}
+
+// MatchOrigins produces an OriginChecker that allows requests without an Origin header along with
+// requests whose Origin matches any of the given regular expressions.
+func MatchOrigins(patterns ...*regexp.Regexp) OriginChecker {
+	return func(request *http.Request) bool {
+		origin := request.Header.Get("Origin")
+		if len(origin) == 0 {
+			return true
+		}
+
+		for _, p := range patterns {
+			if p.MatchString(origin) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// AnyOf produces an OriginChecker that allows a request if any of the given checkers allow it
+func AnyOf(checkers ...OriginChecker) OriginChecker {
+	return func(request *http.Request) bool {
+		for _, c := range checkers {
+			if c(request) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
@@ -0,0 +1,128 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func newOriginRequest(origin string) *http.Request {
+	request := httptest.NewRequest("GET", "http://device.example.com/connect", nil)
+	if len(origin) > 0 {
+		request.Header.Set("Origin", origin)
+	}
+
+	return request
+}
+
+func TestSameOrigin(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(SameOrigin(newOriginRequest("")))
+	assert.True(SameOrigin(newOriginRequest("https://device.example.com")))
+	assert.False(SameOrigin(newOriginRequest("https://simulator.example.com")))
+	assert.False(SameOrigin(newOriginRequest("%zz")))
+}
+
+func TestAnyOrigin(t *testing.T) {
+	assert.True(t, AnyOrigin(newOriginRequest("https://anywhere.com")))
+}
+
+func TestAllowOrigins(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		checker = AllowOrigins("https://Simulator.example.com", "http://localhost:3000")
+	)
+
+	assert.True(checker(newOriginRequest("")))
+	assert.True(checker(newOriginRequest("https://simulator.example.com")))
+	assert.True(checker(newOriginRequest("http://localhost:3000")))
+	assert.False(checker(newOriginRequest("http://localhost:3001")))
+	assert.False(checker(newOriginRequest("https://device.example.com")))
+}
+
+func TestMatchOrigins(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		checker = MatchOrigins(regexp.MustCompile(`^https://[a-z]+\.dev\.example\.com$`))
+	)
+
+	assert.True(checker(newOriginRequest("")))
+	assert.True(checker(newOriginRequest("https://simulator.dev.example.com")))
+	assert.False(checker(newOriginRequest("https://simulator.prod.example.com")))
+	assert.False(MatchOrigins()(newOriginRequest("https://simulator.dev.example.com")))
+}
+
+func TestAnyOf(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		checker = AnyOf(AllowOrigins("https://a.com"), MatchOrigins(regexp.MustCompile(`^https://b\.`)))
+	)
+
+	assert.True(checker(newOriginRequest("https://a.com")))
+	assert.True(checker(newOriginRequest("https://b.com")))
+	assert.False(checker(newOriginRequest("https://c.com")))
+	assert.False(AnyOf()(newOriginRequest("")))
+}
+
+func TestOptionsOriginChecker(t *testing.T) {
+	testData := []struct {
+		options  *Options
+		origin   string
+		expected bool
+	}{
+		{nil, "https://device.example.com", true},
+		{nil, "https://simulator.example.com", false},
+		{new(Options), "https://simulator.example.com", false},
+		{&Options{CheckOrigin: AnyOrigin, AllowedOrigins: []string{"https://nowhere.com"}}, "https://simulator.example.com", true},
+		{&Options{AllowedOrigins: []string{"https://simulator.example.com"}}, "https://simulator.example.com", true},
+		{&Options{AllowedOrigins: []string{"https://simulator.example.com"}}, "https://device.example.com", false},
+		{&Options{AllowedOriginPatterns: []string{`\.example\.com$`}}, "https://simulator.example.com", true},
+		{&Options{AllowedOriginPatterns: []string{"(", `\.example\.com$`}, Logger: logging.DefaultLogger()}, "https://simulator.example.com", true},
+		{&Options{AllowedOrigins: []string{"https://a.com"}, AllowedOriginPatterns: []string{`^https://b\.`}}, "https://b.com", true},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		assert.Equal(t, record.expected, record.options.originChecker()(newOriginRequest(record.origin)))
+	}
+}
+
+func TestManagerConnectAllowedOrigin(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		disconnected = make(chan struct{})
+
+		options = &Options{
+			Logger:         logging.TestLogger(t),
+			AllowedOrigins: []string{"https://simulator.example.com"},
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == Disconnect {
+						close(disconnected)
+					}
+				},
+			},
+		}
+	)
+
+	_, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	dialer := NewDialer(options, nil)
+	c, response, err := dialer.Dial(connectURL, IntToMAC(0x112233445566), nil, http.Header{"Origin": {"https://rogue.example.com"}})
+	assert.Nil(c)
+	assert.Error(err)
+	if assert.NotNil(response) {
+		assert.Equal(http.StatusForbidden, response.StatusCode)
+	}
+
+	c, _, err = dialer.Dial(connectURL, IntToMAC(0x112233445566), nil, http.Header{"Origin": {"https://simulator.example.com"}})
+	require.NoError(err)
+	c.Close()
+	<-disconnected
+}
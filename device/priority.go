@@ -0,0 +1,97 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// Priority is the class of a message waiting to be written to a device.  Each class has its own queue,
+// and whenever a device's write pump is ready for another message, it takes the oldest message
+// of the highest class that has any queued.  Bulk traffic can therefore never delay critical messages
+// by more than the single write already in progress.
+type Priority int
+
+const (
+	// DefaultPriority means that a Request's priority is derived from the quality of service of its
+	// message.  See QOSPriority.
+	DefaultPriority Priority = iota
+
+	LowPriority
+	MediumPriority
+	HighPriority
+	CriticalPriority
+
+	// priorityClasses is the number of Priority values, including DefaultPriority.  It is used to
+	// size arrays indexed by Priority.
+	priorityClasses
+)
+
+func (p Priority) String() string {
+	switch p {
+	case DefaultPriority:
+		return "default"
+	case LowPriority:
+		return "low"
+	case MediumPriority:
+		return "medium"
+	case HighPriority:
+		return "high"
+	case CriticalPriority:
+		return "critical"
+	default:
+		return "invalid"
+	}
+}
+
+// QOSPriority returns the Priority for a WRP quality of service value.  Values outside the range of
+// valid QOS values are clamped to that range.
+func QOSPriority(qos wrp.QOSValue) Priority {
+	switch {
+	case qos >= wrp.QOSCriticalValue:
+		return CriticalPriority
+	case qos >= wrp.QOSHighValue:
+		return HighPriority
+	case qos >= wrp.QOSMediumValue:
+		return MediumPriority
+	default:
+		return LowPriority
+	}
+}
+
+// messageQueue holds the messages waiting to be written to a device, with one channel for each
+// Priority other than DefaultPriority.  Queue channels are never closed.
+type messageQueue [priorityClasses]chan *envelope
+
+// newMessageQueue creates a messageQueue with the given channel size for each priority class.
+// The size for DefaultPriority is ignored.
+func newMessageQueue(sizes [priorityClasses]int) messageQueue {
+	var q messageQueue
+	for p := LowPriority; p < priorityClasses; p++ {
+		q[p] = make(chan *envelope, sizes[p])
+	}
+
+	return q
+}
+
+// poll returns the next envelope, highest priority first, without blocking.  If no envelopes are
+// queued, this method returns nil.
+func (q messageQueue) poll() *envelope {
+	for p := CriticalPriority; p > DefaultPriority; p-- {
+		select {
+		case e := <-q[p]:
+			return e
+		default:
+		}
+	}
+
+	return nil
+}
+
+// len returns the total number of envelopes queued across all priority classes
+func (q messageQueue) len() int {
+	total := 0
+	for p := LowPriority; p < priorityClasses; p++ {
+		total += len(q[p])
+	}
+
+	return total
+}
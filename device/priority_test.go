@@ -0,0 +1,225 @@
+package device
+
+import (
+	"bytes"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityString(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("default", DefaultPriority.String())
+	assert.Equal("low", LowPriority.String())
+	assert.Equal("medium", MediumPriority.String())
+	assert.Equal("high", HighPriority.String())
+	assert.Equal("critical", CriticalPriority.String())
+	assert.Equal("invalid", priorityClasses.String())
+	assert.Equal("invalid", Priority(-1).String())
+}
+
+func TestQOSPriority(t *testing.T) {
+	assert := assert.New(t)
+	for qos, expected := range map[wrp.QOSValue]Priority{
+		-1:                        LowPriority,
+		wrp.QOSLowValue:           LowPriority,
+		wrp.QOSMediumValue - 1:    LowPriority,
+		wrp.QOSMediumValue:        MediumPriority,
+		wrp.QOSHighValue - 1:      MediumPriority,
+		wrp.QOSHighValue:          HighPriority,
+		wrp.QOSCriticalValue - 1:  HighPriority,
+		wrp.QOSCriticalValue:      CriticalPriority,
+		wrp.QOSMaxValue:           CriticalPriority,
+		wrp.QOSMaxValue + 1000000: CriticalPriority,
+	} {
+		assert.Equal(expected, QOSPriority(qos), "qos %d", qos)
+	}
+}
+
+func TestRequestEffectivePriority(t *testing.T) {
+	assert := assert.New(t)
+	for _, record := range []struct {
+		request  Request
+		expected Priority
+	}{
+		{Request{Message: new(wrp.Message)}, LowPriority},
+		{Request{Message: new(wrp.SimpleEvent)}, LowPriority},
+		{Request{Message: new(wrp.SimpleEvent), Priority: HighPriority}, HighPriority},
+		{Request{Message: &wrp.Message{QualityOfService: wrp.QOSCriticalValue}}, CriticalPriority},
+		{Request{Message: &wrp.Message{QualityOfService: wrp.QOSMediumValue}}, MediumPriority},
+		{Request{Message: &wrp.Message{QualityOfService: wrp.QOSCriticalValue}, Priority: LowPriority}, LowPriority},
+		{Request{Message: &wrp.Message{QualityOfService: wrp.QOSHighValue}, Priority: priorityClasses}, HighPriority},
+	} {
+		assert.Equal(record.expected, record.request.EffectivePriority())
+	}
+}
+
+func TestOptionsPriorityQueueSize(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		for p := LowPriority; p < priorityClasses; p++ {
+			assert.Equal(DefaultDeviceMessageQueueSize, o.priorityQueueSize(p))
+		}
+	}
+
+	o := &Options{
+		DeviceMessageQueueSize: 50,
+		PriorityQueueSizes:     map[Priority]int{CriticalPriority: 5, LowPriority: 500, HighPriority: -1},
+	}
+
+	assert.Equal([priorityClasses]int{0, 500, 50, 50, 5}, o.priorityQueueSizes())
+}
+
+func TestMessageQueue(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		q      = newMessageQueue([priorityClasses]int{0, 3, 2, 2, 1})
+	)
+
+	assert.Nil(q[DefaultPriority])
+	assert.Equal(3, cap(q[LowPriority]))
+	assert.Equal(2, cap(q[MediumPriority]))
+	assert.Equal(2, cap(q[HighPriority]))
+	assert.Equal(1, cap(q[CriticalPriority]))
+
+	assert.Zero(q.len())
+	assert.Nil(q.poll())
+
+	var (
+		low1     = new(envelope)
+		low2     = new(envelope)
+		medium   = new(envelope)
+		critical = new(envelope)
+	)
+
+	q[LowPriority] <- low1
+	q[MediumPriority] <- medium
+	q[LowPriority] <- low2
+	q[CriticalPriority] <- critical
+	assert.Equal(4, q.len())
+
+	for _, expected := range []*envelope{critical, medium, low1, low2} {
+		assert.True(expected == q.poll())
+	}
+
+	assert.Zero(q.len())
+	assert.Nil(q.poll())
+}
+
+func TestDeviceSendPriority(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		d       = newDevice(ID("mac:112233445566"), Key("test"), nil, 1)
+		request = &Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, QualityOfService: wrp.QOSHighValue}}
+		result  = make(chan error, 1)
+	)
+
+	go func() {
+		_, err := d.Send(request)
+		result <- err
+	}()
+
+	select {
+	case e := <-d.messages[HighPriority]:
+		assert.True(request == e.request)
+		close(e.complete)
+	case <-time.After(5 * time.Second):
+		require.Fail("The request was not queued")
+	}
+
+	assert.NoError(<-result)
+	assert.Zero(d.Pending())
+}
+
+// frameRecorder is a Connection that records each frame written through NextWriter.  Only the
+// methods used by a write pump are implemented.
+type frameRecorder struct {
+	Connection
+	frames chan []byte
+}
+
+type recordedFrame struct {
+	bytes.Buffer
+	frames chan<- []byte
+}
+
+func (rf *recordedFrame) Close() error {
+	rf.frames <- rf.Bytes()
+	return nil
+}
+
+func (fr *frameRecorder) NextWriter() (io.WriteCloser, error) {
+	return &recordedFrame{frames: fr.frames}, nil
+}
+
+func (fr *frameRecorder) SendClose() error {
+	return nil
+}
+
+func (fr *frameRecorder) Close() error {
+	return nil
+}
+
+func TestManagerWritePumpPriority(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		disconnected = make(chan struct{})
+
+		m = NewManager(
+			&Options{
+				Logger: logging.TestLogger(t),
+				Listeners: []Listener{
+					func(event *Event) {
+						if event.Type == Disconnect {
+							close(disconnected)
+						}
+					},
+				},
+			},
+			nil,
+		).(*manager)
+
+		d          = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+		connection = &frameRecorder{frames: make(chan []byte, 10)}
+		pumpDone   = make(chan struct{})
+	)
+
+	// queue everything before the write pump starts, so that the writes reflect only priority
+	for _, request := range []*Request{
+		{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "low1"}},
+		{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "medium", QualityOfService: wrp.QOSMediumValue}},
+		{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "low2"}},
+		{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "critical"}, Priority: CriticalPriority},
+		{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "high", QualityOfService: wrp.QOSHighValue}},
+	} {
+		d.messages[request.EffectivePriority()] <- &envelope{request: request, complete: make(chan error, 1), enqueuedAt: time.Now()}
+	}
+
+	go func() {
+		defer close(pumpDone)
+		m.writePump(d, connection, new(sync.Once))
+	}()
+
+	for _, expected := range []string{"critical", "high", "medium", "low1", "low2"} {
+		select {
+		case frame := <-connection.frames:
+			var message wrp.Message
+			require.NoError(wrp.NewDecoderBytes(frame, wrp.Msgpack).Decode(&message))
+			assert.Equal(expected, message.Destination)
+		case <-time.After(5 * time.Second):
+			require.Fail("No frame was written", "expected %s", expected)
+		}
+	}
+
+	d.RequestClose()
+	<-pumpDone
+	<-disconnected
+}
@@ -0,0 +1,78 @@
+package device
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimitPolicy determines what happens to a device that sends messages faster than its rate limit allows
+type RateLimitPolicy string
+
+const (
+	// DropRateLimited discards each message that exceeds the rate limit.  This is the default.
+	DropRateLimited RateLimitPolicy = "drop"
+
+	// CloseRateLimited disconnects a device the first time it exceeds the rate limit, with a
+	// RateLimitCloseReason close reason
+	CloseRateLimited RateLimitPolicy = "close"
+
+	// RateLimitCloseReason is the text of the websocket close frame sent to devices disconnected by CloseRateLimited
+	RateLimitCloseReason = "rate limit exceeded"
+)
+
+// RateLimiter throttles the messages sent by a single device.  Each connected device has its own
+// RateLimiter, which is only ever used by that device's read pump.  Implementations therefore need not
+// be safe for concurrent use.
+type RateLimiter interface {
+	// Allow is invoked for each frame read from the device, with the time the frame was read.
+	// This method returns false if the frame exceeds the device's allowed rate.
+	Allow(now time.Time) bool
+}
+
+// RateLimiterFunc is a function type that implements RateLimiter
+type RateLimiterFunc func(time.Time) bool
+
+func (f RateLimiterFunc) Allow(now time.Time) bool {
+	return f(now)
+}
+
+// RateLimiterFactory creates the RateLimiter for a newly connected device.  A factory may return nil
+// to leave a device unlimited.
+type RateLimiterFactory func(Interface) RateLimiter
+
+// tokenBucket is the default RateLimiter
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a RateLimiter which allows rate messages per second on average, with bursts of up to
+// burst messages at once.  If burst is not positive, the burst is rate rounded up, with a minimum of 1.
+func NewTokenBucket(rate float64, burst int) RateLimiter {
+	capacity := float64(burst)
+	if burst < 1 {
+		capacity = math.Max(1.0, math.Ceil(rate))
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  capacity,
+		tokens: capacity,
+	}
+}
+
+func (tb *tokenBucket) Allow(now time.Time) bool {
+	if !tb.last.IsZero() {
+		tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	}
+
+	tb.last = now
+	if tb.tokens < 1.0 {
+		return false
+	}
+
+	tb.tokens--
+	return true
+}
@@ -0,0 +1,193 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		limiter = NewTokenBucket(2.0, 3)
+		start   = time.Now()
+	)
+
+	for repeat := 0; repeat < 3; repeat++ {
+		assert.True(limiter.Allow(start))
+	}
+
+	assert.False(limiter.Allow(start))
+
+	assert.True(limiter.Allow(start.Add(500 * time.Millisecond)))
+	assert.False(limiter.Allow(start.Add(500 * time.Millisecond)))
+
+	// a long idle period never accumulates more than the burst
+	later := start.Add(time.Hour)
+	for repeat := 0; repeat < 3; repeat++ {
+		assert.True(limiter.Allow(later))
+	}
+
+	assert.False(limiter.Allow(later))
+}
+
+func TestNewTokenBucketDefaultBurst(t *testing.T) {
+	assert := assert.New(t)
+	for rate, expectedBurst := range map[float64]float64{0.5: 1.0, 1.0: 1.0, 2.5: 3.0, 10.0: 10.0} {
+		limiter := NewTokenBucket(rate, 0).(*tokenBucket)
+		assert.Equal(expectedBurst, limiter.burst)
+		assert.Equal(expectedBurst, limiter.tokens)
+	}
+}
+
+func TestOptionsRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Nil(o.rateLimiterFactory())
+		assert.Equal(DropRateLimited, o.rateLimitPolicy())
+	}
+
+	if factory := (&Options{MaxMessagesPerSecond: 5.0, MessageBurst: 7}).rateLimiterFactory(); assert.NotNil(factory) {
+		limiter, ok := factory(new(mockDevice)).(*tokenBucket)
+		if assert.True(ok) {
+			assert.Equal(5.0, limiter.rate)
+			assert.Equal(7.0, limiter.burst)
+		}
+	}
+
+	custom := RateLimiterFunc(func(time.Time) bool { return false })
+	if factory := (&Options{MaxMessagesPerSecond: 5.0, RateLimiterFactory: func(Interface) RateLimiter { return custom }}).rateLimiterFactory(); assert.NotNil(factory) {
+		_, ok := factory(new(mockDevice)).(RateLimiterFunc)
+		assert.True(ok)
+	}
+
+	assert.Equal(CloseRateLimited, (&Options{RateLimitPolicy: CloseRateLimited}).rateLimitPolicy())
+	assert.Equal(DropRateLimited, (&Options{RateLimitPolicy: "unrecognized"}).rateLimitPolicy())
+}
+
+// allowFirst produces a factory whose limiters allow only the first count frames from each device
+func allowFirst(count int) RateLimiterFactory {
+	return func(Interface) RateLimiter {
+		allowed := 0
+		return RateLimiterFunc(func(time.Time) bool {
+			allowed++
+			return allowed <= count
+		})
+	}
+}
+
+func testManagerRateLimit(t *testing.T, policy RateLimitPolicy) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		received     = make(chan *Event, 3)
+		limited      = make(chan *Event, 3)
+		disconnected = make(chan *Event, 1)
+
+		options = &Options{
+			Logger:             logging.TestLogger(t),
+			Metrics:            registry,
+			RateLimiterFactory: allowFirst(1),
+			RateLimitPolicy:    policy,
+			Listeners: []Listener{
+				func(event *Event) {
+					copied := *event
+					switch event.Type {
+					case MessageReceived:
+						received <- &copied
+					case RateLimited:
+						limited <- &copied
+					case Disconnect:
+						disconnected <- &copied
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		frame                 []byte
+	)
+
+	defer server.Close()
+	require.NoError(wrp.NewEncoderBytes(&frame, wrp.Msgpack).Encode(&wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566",
+		Destination: "event:test",
+	}))
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	defer c.Close()
+
+	for repeat := 0; repeat < 3; repeat++ {
+		_, err := c.Write(frame)
+		require.NoError(err)
+	}
+
+	select {
+	case event := <-received:
+		assert.Equal("event:test", event.Message.(*wrp.Message).Destination)
+	case <-time.After(5 * time.Second):
+		require.Fail("The first message was not received")
+	}
+
+	expectedLimited := 2
+	if policy == CloseRateLimited {
+		expectedLimited = 1
+	}
+
+	for repeat := 0; repeat < expectedLimited; repeat++ {
+		select {
+		case event := <-limited:
+			assert.Equal(ErrorRateLimited, event.Error)
+			assert.Nil(event.Message)
+			assert.Equal(IntToMAC(0x112233445566), event.Device.ID())
+		case <-time.After(5 * time.Second):
+			require.Fail("The rate limited event was not dispatched")
+		}
+	}
+
+	if policy == CloseRateLimited {
+		for {
+			if _, err = c.NextReader(); err != nil {
+				break
+			}
+		}
+
+		assert.True(websocket.IsCloseError(err, websocket.ClosePolicyViolation), err.Error())
+		if closeError, ok := err.(*websocket.CloseError); assert.True(ok) {
+			assert.Equal(RateLimitCloseReason, closeError.Text)
+		}
+
+		event := <-disconnected
+		assert.Equal(ErrorRateLimited, event.Error)
+	}
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(response.Body.String(), RateLimitedCount+`{action="`+string(policy)+`"} `+strconv.Itoa(expectedLimited))
+
+	if policy == DropRateLimited {
+		c.Close()
+		<-disconnected
+	}
+}
+
+func TestManagerRateLimit(t *testing.T) {
+	t.Run("Drop", func(t *testing.T) { testManagerRateLimit(t, DropRateLimited) })
+	t.Run("Close", func(t *testing.T) { testManagerRateLimit(t, CloseRateLimited) })
+}
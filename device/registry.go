@@ -1,5 +1,9 @@
 package device
 
+import (
+	"sync"
+)
+
 // idMap stores devices keyed by their canonical ID.  Multiple devices are
 // allowed to have the same ID.
 type idMap map[ID]map[*device]bool
@@ -61,12 +65,14 @@ func (m keyMap) remove(k Key) bool {
 type registry struct {
 	ids  idMap
 	keys keyMap
+	tags tagMap
 }
 
 func newRegistry(initialCapacity int) *registry {
 	return &registry{
 		ids:  make(idMap, initialCapacity),
 		keys: make(keyMap, initialCapacity),
+		tags: make(tagMap),
 	}
 }
 
@@ -102,6 +108,15 @@ func (r *registry) visitKey(k Key, visitor func(*device)) int {
 	return 0
 }
 
+func (r *registry) visitTag(tag Tag, visitor func(*device)) int {
+	members := r.tags[tag]
+	for d := range members {
+		visitor(d)
+	}
+
+	return len(members)
+}
+
 func (r *registry) visitIf(filter func(ID) bool, visitor func(*device)) (count int) {
 	for id, duplicates := range r.ids {
 		if filter(id) {
@@ -131,16 +146,22 @@ func (r *registry) add(d *device) error {
 	}
 
 	r.ids.add(d.id, d)
+	r.tags.add(d)
 	return nil
 }
 
 func (r *registry) removeOne(d *device) bool {
+	// only the device registered under a Key may remove it, so a device that was never
+	// added cannot remove another device's entry
 	k := d.Key()
-	if !r.keys.remove(k) {
+	if r.keys[k] != d {
 		return false
 	}
 
+	delete(r.keys, k)
+
 	r.ids.removeOne(d)
+	r.tags.remove(d)
 	return true
 }
 
@@ -148,7 +169,236 @@ func (r *registry) removeAll(id ID) (removed []*device) {
 	removed = r.ids.removeAll(id)
 	for _, d := range removed {
 		r.keys.remove(d.Key())
+		r.tags.remove(d)
+	}
+
+	return
+}
+
+// registryShard is a registry along with the lock that guards it
+type registryShard struct {
+	lock sync.RWMutex
+	registry
+}
+
+// keyShard is one partition of the index that keeps routing Keys unique across all registryShards
+type keyShard struct {
+	lock sync.Mutex
+	keys keyMap
+}
+
+// release removes a Key from this shard, but only if it still belongs to the given device
+func (ks *keyShard) release(k Key, d *device) {
+	if ks.keys[k] == d {
+		delete(ks.keys, k)
+	}
+}
+
+// shardedRegistry divides devices among independently locked registries by device ID.  All duplicates of
+// an ID are in the same shard, so operations on a single ID only take that shard's lock.  A shardedRegistry
+// is safe for concurrent access.
+//
+// Since a Key does not determine a device's ID, Keys are also indexed across all shards by keyShards, which
+// are partitioned by Key.  A registryShard's lock is always taken before any keyShard's lock, and multiple
+// keyShards are locked in index order, so the two kinds of locks cannot deadlock.
+//
+// Visitors are invoked while a shard's read lock is held, so a visitor must not add or remove devices.
+type shardedRegistry struct {
+	shards    []registryShard
+	keyShards []keyShard
+}
+
+func newShardedRegistry(initialCapacity, shardCount int) *shardedRegistry {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+
+	r := &shardedRegistry{
+		shards:    make([]registryShard, shardCount),
+		keyShards: make([]keyShard, shardCount),
+	}
+
+	for i := range r.shards {
+		r.shards[i].registry = *newRegistry(initialCapacity/shardCount + 1)
+		r.keyShards[i].keys = make(keyMap, initialCapacity/shardCount+1)
+	}
+
+	return r
+}
+
+// shardIndex computes FNV-1a inline, so that locating a shard does not allocate
+func shardIndex(v string, shardCount int) int {
+	if shardCount == 1 {
+		return 0
+	}
+
+	h := uint32(2166136261)
+	for i := 0; i < len(v); i++ {
+		h ^= uint32(v[i])
+		h *= 16777619
+	}
+
+	return int(h % uint32(shardCount))
+}
+
+// shard returns the shard that holds the devices with the given ID
+func (r *shardedRegistry) shard(id ID) *registryShard {
+	return &r.shards[shardIndex(string(id), len(r.shards))]
+}
+
+// keyShard returns the keyShard that indexes the given Key
+func (r *shardedRegistry) keyShard(k Key) *keyShard {
+	return &r.keyShards[shardIndex(string(k), len(r.keyShards))]
+}
+
+// add registers a device, returning ErrorDuplicateKey if its Key belongs to any other device
+func (r *shardedRegistry) add(d *device) error {
+	s := r.shard(d.id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	k := d.Key()
+	ks := r.keyShard(k)
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	if err := ks.keys.add(k, d); err != nil {
+		return err
+	}
+
+	if err := s.add(d); err != nil {
+		delete(ks.keys, k)
+		return err
+	}
+
+	return nil
+}
+
+func (r *shardedRegistry) removeOne(d *device) bool {
+	s := r.shard(d.id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.removeOne(d) {
+		return false
+	}
+
+	r.releaseKey(d)
+	return true
+}
+
+func (r *shardedRegistry) removeAll(id ID) []*device {
+	s := r.shard(id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	removed := s.removeAll(id)
+	for _, d := range removed {
+		r.releaseKey(d)
+	}
+
+	return removed
+}
+
+// releaseKey removes a device's Key from the index.  The caller must hold the write lock of the device's shard.
+func (r *shardedRegistry) releaseKey(d *device) {
+	k := d.Key()
+	ks := r.keyShard(k)
+	ks.lock.Lock()
+	ks.release(k, d)
+	ks.lock.Unlock()
+}
+
+func (r *shardedRegistry) devices(id ID) []*device {
+	s := r.shard(id)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.devices(id)
+}
+
+func (r *shardedRegistry) visitID(id ID, visitor func(*device)) int {
+	s := r.shard(id)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.visitID(id, visitor)
+}
+
+// visitKey searches each shard in turn, since a routing key does not determine a device's ID
+func (r *shardedRegistry) visitKey(k Key, visitor func(*device)) int {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		count := s.visitKey(k, visitor)
+		s.lock.RUnlock()
+
+		if count > 0 {
+			return count
+		}
+	}
+
+	return 0
+}
+
+// visitTag visits the devices with a tag one shard at a time, with the same semantics as visitIf
+func (r *shardedRegistry) visitTag(tag Tag, visitor func(*device)) (count int) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		count += s.visitTag(tag, visitor)
+		s.lock.RUnlock()
+	}
+
+	return
+}
+
+// visitIf visits matching devices one shard at a time, so devices may be added or removed in other
+// shards while the visit is in progress
+func (r *shardedRegistry) visitIf(filter func(ID) bool, visitor func(*device)) (count int) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		count += s.visitIf(filter, visitor)
+		s.lock.RUnlock()
+	}
+
+	return
+}
+
+// visitAll visits every device one shard at a time, with the same semantics as visitIf
+func (r *shardedRegistry) visitAll(visitor func(*device)) (count int) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		count += s.visitAll(visitor)
+		s.lock.RUnlock()
+	}
+
+	return
+}
+
+// len returns the total count of devices across all shards
+func (r *shardedRegistry) len() (count int) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		count += len(s.keys)
+		s.lock.RUnlock()
 	}
 
 	return
 }
+
+// whenFrozen locks every shard for reading, in order, then executes a function with all of the registered
+// devices.  No device can be added or removed until the function returns.  Because add and removeOne only
+// ever hold a single registryShard's lock, acquiring every shard's lock in order cannot deadlock.
+func (r *shardedRegistry) whenFrozen(when func([]*device)) {
+	var devices []*device
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		s.visitAll(func(d *device) { devices = append(devices, d) })
+	}
+
+	when(devices)
+}
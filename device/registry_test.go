@@ -2,7 +2,11 @@ package device
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var (
@@ -243,3 +247,135 @@ func TestRegistryRemoveAll(t *testing.T) {
 		assert.Equal(record.expectVisitAll, actualVisitAll)
 	}
 }
+
+func testShardedRegistry(t *testing.T, shardCount int) {
+	var (
+		assert   = assert.New(t)
+		registry = newShardedRegistry(1000, shardCount)
+		all      = expectsDevices(singleDevice, doubleDevice1, doubleDevice2, manyDevice1, manyDevice2, manyDevice3, manyDevice4, manyDevice5)
+	)
+
+	for d := range all {
+		assert.NoError(registry.add(d))
+	}
+
+	assert.Equal(ErrorDuplicateKey, registry.add(singleDevice))
+	assert.Equal(len(all), registry.len())
+
+	// keys are unique across every shard, and a device that was never added cannot remove another's key
+	impostor := newDevice(nosuchID, singleDevice.Key(), nil, 1)
+	assert.Equal(ErrorDuplicateKey, registry.add(impostor))
+	assert.False(registry.removeOne(impostor))
+	assert.Equal(len(all), registry.len())
+
+	visited := deviceSet{}
+	assert.Equal(1, registry.visitKey(singleDevice.Key(), visited.registryCapture()))
+	assert.Equal(expectsDevices(singleDevice), visited)
+
+	for _, id := range []ID{nosuchID, singleID, doubleID, manyID} {
+		visited := deviceSet{}
+		assert.Equal(len(registry.devices(id)), registry.visitID(id, visited.registryCapture()))
+		visited.assertSameID(assert, id)
+	}
+
+	visited = deviceSet{}
+	assert.Equal(1, registry.visitKey(doubleKey2, visited.registryCapture()))
+	assert.Equal(expectsDevices(doubleDevice2), visited)
+	assert.Zero(registry.visitKey(nosuchKey, visited.registryCapture()))
+
+	visited = deviceSet{}
+	assert.Equal(5, registry.visitIf(func(id ID) bool { return id == manyID }, visited.registryCapture()))
+	assert.Equal(expectsDevices(manyDevice1, manyDevice2, manyDevice3, manyDevice4, manyDevice5), visited)
+
+	visited = deviceSet{}
+	assert.Equal(len(all), registry.visitAll(visited.registryCapture()))
+	assert.Equal(all, visited)
+
+	assert.True(registry.removeOne(doubleDevice1))
+	assert.False(registry.removeOne(doubleDevice1))
+	assert.Len(registry.removeAll(manyID), 5)
+	assert.Equal(2, registry.len())
+}
+
+func TestShardedRegistry(t *testing.T) {
+	for _, shardCount := range []int{0, 1, 3, DefaultRegistryShards} {
+		t.Run(strconv.Itoa(shardCount), func(t *testing.T) { testShardedRegistry(t, shardCount) })
+	}
+}
+
+func TestShardedRegistryDistribution(t *testing.T) {
+	const deviceCount = 10000
+
+	var (
+		assert   = assert.New(t)
+		registry = newShardedRegistry(deviceCount, 16)
+	)
+
+	for i := 0; i < deviceCount; i++ {
+		registry.add(newDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, 1))
+	}
+
+	for i := range registry.shards {
+		assert.InDelta(deviceCount/16, len(registry.shards[i].keys), deviceCount/16/4, "shard %d", i)
+	}
+}
+
+func TestShardedRegistryWhenFrozen(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		registry = newShardedRegistry(100, 4)
+		added    int32
+		done     = make(chan struct{})
+	)
+
+	require.NoError(registry.add(singleDevice))
+	registry.whenFrozen(func(devices []*device) {
+		assert.Equal([]*device{singleDevice}, devices)
+
+		go func() {
+			defer close(done)
+			registry.add(doubleDevice1)
+			atomic.StoreInt32(&added, 1)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Zero(atomic.LoadInt32(&added), "No device should be added while the registry is frozen")
+	})
+
+	<-done
+	assert.Equal(2, registry.len())
+}
+
+func benchmarkShardedRegistry(b *testing.B, shardCount int) {
+	const deviceCount = 4096
+
+	var (
+		registry = newShardedRegistry(deviceCount, shardCount)
+		devices  = make([]*device, deviceCount)
+		next     uint64
+	)
+
+	for i := range devices {
+		devices[i] = newDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, 1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			d := devices[atomic.AddUint64(&next, 1)%deviceCount]
+			registry.add(d)
+			registry.devices(d.id)
+			registry.removeOne(d)
+		}
+	})
+}
+
+// BenchmarkShardedRegistry measures the Connect, Route, and Disconnect paths of the registry under
+// parallel load.  Run with -cpu to compare how each shard count scales.
+func BenchmarkShardedRegistry(b *testing.B) {
+	for _, shardCount := range []int{1, DefaultRegistryShards} {
+		b.Run(strconv.Itoa(shardCount), func(b *testing.B) { benchmarkShardedRegistry(b, shardCount) })
+	}
+}
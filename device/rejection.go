@@ -0,0 +1,125 @@
+package device
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/gate"
+	"net/http"
+)
+
+// RejectReason is the machine-readable category of a refused websocket upgrade.  Each reason
+// maps onto a default HTTP status, is reported in the JSON body sent to the device, and is
+// the ReasonLabel value of HandshakeRejectionCount.
+type RejectReason string
+
+const (
+	RejectBadID          RejectReason = "bad_id"
+	RejectBadConvey      RejectReason = "bad_convey"
+	RejectKeyError       RejectReason = "key_error"
+	RejectUnauthorized   RejectReason = "unauthorized"
+	RejectDuplicate      RejectReason = "duplicate"
+	RejectGateClosed     RejectReason = "gate_closed"
+	RejectCapacity       RejectReason = "capacity"
+	RejectOriginRejected RejectReason = "origin_rejected"
+	RejectUpgradeFailed  RejectReason = "upgrade_failed"
+)
+
+var rejectStatusCodes = map[RejectReason]int{
+	RejectBadID:          http.StatusBadRequest,
+	RejectBadConvey:      http.StatusBadRequest,
+	RejectKeyError:       http.StatusBadRequest,
+	RejectUnauthorized:   http.StatusForbidden,
+	RejectDuplicate:      http.StatusConflict,
+	RejectGateClosed:     http.StatusServiceUnavailable,
+	RejectCapacity:       http.StatusServiceUnavailable,
+	RejectOriginRejected: http.StatusForbidden,
+	RejectUpgradeFailed:  http.StatusBadRequest,
+}
+
+// StatusCode returns the default HTTP status for this reason.  Unrecognized reasons map to 400.
+func (r RejectReason) StatusCode() int {
+	if code, ok := rejectStatusCodes[r]; ok {
+		return code
+	}
+
+	return http.StatusBadRequest
+}
+
+// Rejection is the error returned by Manager.Connect when a websocket upgrade is refused.
+// The underlying cause is available as Err.
+type Rejection struct {
+	// Reason categorizes this rejection
+	Reason RejectReason
+
+	// Status is the HTTP status sent to the device.  If zero, Reason.StatusCode() is used.
+	Status int
+
+	// Err is the cause of this rejection
+	Err error
+
+	// Backoff holds the optional retry hints sent with overload rejections
+	Backoff gate.Backoff
+}
+
+// newRejection is a convenience for creating a Rejection with the reason's default status
+func newRejection(reason RejectReason, err error) *Rejection {
+	return &Rejection{Reason: reason, Err: err}
+}
+
+func (r *Rejection) Error() string {
+	return r.Err.Error()
+}
+
+// StatusCode returns the HTTP status sent to the device for this rejection
+func (r *Rejection) StatusCode() int {
+	if r.Status > 0 {
+		return r.Status
+	}
+
+	return r.Reason.StatusCode()
+}
+
+// rejectionBody is the JSON body written for a Rejection
+type rejectionBody struct {
+	Code       int          `json:"code"`
+	Reason     RejectReason `json:"reason"`
+	Message    string       `json:"message"`
+	RetryAfter int          `json:"retryAfter,omitempty"`
+	Alternate  string       `json:"alternate,omitempty"`
+}
+
+// WriteResponse writes this rejection to an HTTP response as a JSON object of the form
+// {"code": 400, "reason": "bad_id", "message": "..."}.  The code and message members match
+// the bodies produced by the httperror package.  When Backoff has values, the Retry-After header
+// is set and the body carries retryAfter and alternate members.
+func (r *Rejection) WriteResponse(response http.ResponseWriter) {
+	code := r.StatusCode()
+	body, _ := json.Marshal(rejectionBody{
+		Code:       code,
+		Reason:     r.Reason,
+		Message:    r.Error(),
+		RetryAfter: r.Backoff.Seconds(),
+		Alternate:  r.Backoff.Alternate,
+	})
+
+	r.Backoff.WriteHeader(response.Header())
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(code)
+	response.Write(body)
+}
+
+// reject writes a Rejection to the response and returns it
+func reject(response http.ResponseWriter, reason RejectReason, err error) *Rejection {
+	rejection := newRejection(reason, err)
+	rejection.WriteResponse(response)
+	return rejection
+}
+
+// RejectionReason extracts the RejectReason from an error returned by Manager.Connect.
+// If the error is not a Rejection, this function returns false.
+func RejectionReason(err error) (RejectReason, bool) {
+	if rejection, ok := err.(*Rejection); ok {
+		return rejection.Reason, true
+	}
+
+	return "", false
+}
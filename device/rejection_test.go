@@ -0,0 +1,75 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectReasonStatusCode(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		testData = map[RejectReason]int{
+			RejectBadID:             http.StatusBadRequest,
+			RejectBadConvey:         http.StatusBadRequest,
+			RejectKeyError:          http.StatusBadRequest,
+			RejectUnauthorized:      http.StatusForbidden,
+			RejectDuplicate:         http.StatusConflict,
+			RejectGateClosed:        http.StatusServiceUnavailable,
+			RejectCapacity:          http.StatusServiceUnavailable,
+			RejectOriginRejected:    http.StatusForbidden,
+			RejectUpgradeFailed:     http.StatusBadRequest,
+			RejectReason("unknown"): http.StatusBadRequest,
+		}
+	)
+
+	for reason, expected := range testData {
+		assert.Equal(expected, reason.StatusCode(), string(reason))
+	}
+}
+
+func TestRejectionWriteResponse(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		response = httptest.NewRecorder()
+		cause    = errors.New(`a "quoted" message`)
+		body     map[string]interface{}
+	)
+
+	rejection := reject(response, RejectCapacity, cause)
+	require.NotNil(rejection)
+	assert.Equal(RejectCapacity, rejection.Reason)
+	assert.Equal(cause, rejection.Err)
+	assert.Equal(cause.Error(), rejection.Error())
+	assert.Equal(http.StatusServiceUnavailable, rejection.StatusCode())
+
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(float64(http.StatusServiceUnavailable), body["code"])
+	assert.Equal("capacity", body["reason"])
+	assert.Equal(cause.Error(), body["message"])
+
+	response = httptest.NewRecorder()
+	upgradeError(response, httptest.NewRequest("POST", "/", nil), http.StatusMethodNotAllowed, cause)
+	assert.Equal(http.StatusMethodNotAllowed, response.Code)
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal("upgrade_failed", body["reason"])
+}
+
+func TestRejectionReason(t *testing.T) {
+	assert := assert.New(t)
+
+	reason, ok := RejectionReason(newRejection(RejectBadID, ErrorMissingDeviceName))
+	assert.Equal(RejectBadID, reason)
+	assert.True(ok)
+
+	reason, ok = RejectionReason(ErrorMissingDeviceName)
+	assert.Equal(RejectReason(""), reason)
+	assert.False(ok)
+}
@@ -0,0 +1,38 @@
+package device
+
+// ResponseRouter is the strategy for matching responses read from devices with the goroutines
+// waiting on their transactions.  The default, LocalResponseRouter, completes transactions registered
+// on this server.  Deployments where a device's transactions can originate on other servers can supply
+// a router that forwards responses for transactions that are not pending locally.
+//
+// Implementations must be safe for concurrent use, as each device's read pump invokes its router.
+type ResponseRouter interface {
+	// RouteResponse delivers a response from a device.  The transactions that are pending for the
+	// device which sent the response are supplied, and response.Device refers to that device.
+	// A nil error indicates that the transaction was completed, and a TransactionComplete event
+	// is dispatched.  Otherwise, a TransactionBroken event carrying the error is dispatched.
+	RouteResponse(transactions *Transactions, response *Response) error
+}
+
+// ResponseRouterFunc is a function type that implements ResponseRouter
+type ResponseRouterFunc func(*Transactions, *Response) error
+
+func (f ResponseRouterFunc) RouteResponse(transactions *Transactions, response *Response) error {
+	return f(transactions, response)
+}
+
+// LocalResponseRouter is the default ResponseRouter, which completes transactions that were
+// registered by this server.  Responses for any other transaction produce ErrorNoSuchTransactionKey.
+// Cross-node routers will usually try this router first:
+//
+//	ResponseRouterFunc(func(transactions *Transactions, response *Response) error {
+//	    err := LocalResponseRouter.RouteResponse(transactions, response)
+//	    if err == ErrorNoSuchTransactionKey {
+//	        err = forward(response)
+//	    }
+//
+//	    return err
+//	})
+var LocalResponseRouter ResponseRouter = ResponseRouterFunc(func(transactions *Transactions, response *Response) error {
+	return transactions.Complete(response.TransactionKey(), response)
+})
@@ -0,0 +1,123 @@
+package device
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLocalResponseRouter(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		transactions = NewTransactions()
+		response     = &Response{Message: &wrp.Message{TransactionUUID: "local"}}
+	)
+
+	result, err := transactions.Register("local")
+	require.NoError(err)
+
+	assert.NoError(LocalResponseRouter.RouteResponse(transactions, response))
+	assert.Equal(response, <-result)
+
+	assert.Equal(
+		ErrorNoSuchTransactionKey,
+		LocalResponseRouter.RouteResponse(transactions, &Response{Message: &wrp.Message{TransactionUUID: "remote"}}),
+	)
+}
+
+func TestOptionsResponseRouter(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		called   = false
+		expected = ResponseRouterFunc(func(*Transactions, *Response) error {
+			called = true
+			return nil
+		})
+	)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Equal(ErrorInvalidTransactionKey, o.responseRouter().RouteResponse(NewTransactions(), &Response{Message: new(wrp.Message)}))
+	}
+
+	assert.NoError((&Options{ResponseRouter: expected}).responseRouter().RouteResponse(nil, nil))
+	assert.True(called)
+}
+
+func TestManagerResponseRouter(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		expectedError = errors.New("expected")
+
+		routed       = make(chan *Response, 2)
+		connected    = make(chan Interface, 1)
+		events       = make(chan Event, 2)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			ResponseRouter: ResponseRouterFunc(func(transactions *Transactions, response *Response) error {
+				routed <- response
+				if response.TransactionKey() == "forwarded" {
+					return nil
+				}
+
+				return expectedError
+			}),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case TransactionComplete, TransactionBroken:
+						events <- *event
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	for _, transactionKey := range []string{"forwarded", "failed"} {
+		var encoded []byte
+		require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(&wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "mac:112233445566",
+			TransactionUUID: transactionKey,
+		}))
+
+		_, err = c.Write(encoded)
+		require.NoError(err)
+	}
+
+	forwarded := <-routed
+	assert.Equal("forwarded", forwarded.TransactionKey())
+	assert.Equal(d, forwarded.Device)
+	assert.NotEmpty(forwarded.Contents)
+
+	complete := <-events
+	assert.Equal(TransactionComplete, complete.Type)
+	assert.NoError(complete.Error)
+
+	failed := <-routed
+	assert.Equal("failed", failed.TransactionKey())
+
+	broken := <-events
+	assert.Equal(TransactionBroken, broken.Type)
+	assert.Equal(expectedError, broken.Error)
+
+	c.Close()
+	<-disconnected
+}
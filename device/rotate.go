@@ -0,0 +1,153 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+)
+
+// KeyRotator changes the routing Keys of connected devices
+type KeyRotator interface {
+	// RotateKey atomically replaces the routing Keys of every device connected with the given ID, then
+	// dispatches a KeyChanged event for each device whose Key changed.  The PreviousKey field of each event
+	// holds the replaced Key.  A Send or Route that races with a rotation finds each device under either its
+	// old Key or its new one, never both and never neither.
+	//
+	// Keys are unique to devices, so duplicate connections of an ID cannot share a single Key.  The oldest
+	// connection receives newKey, and each later duplicate receives newKey suffixed with its position in
+	// connection order, e.g. "newKey.1".  ErrorDuplicateKey is returned, and no Key is changed, if any of these
+	// Keys belongs to a device with another ID.  ErrorDeviceNotFound is returned if no device is connected with
+	// the ID, and ErrorInvalidKey if newKey is empty.  A device that already has its rotated Key is left alone.
+	RotateKey(id ID, newKey Key) error
+}
+
+// rotatedKey returns the Key given to the duplicate at a position in connection order
+func rotatedKey(newKey Key, position int) Key {
+	if position == 0 {
+		return newKey
+	}
+
+	return Key(fmt.Sprintf("%s.%d", newKey, position))
+}
+
+// keyRotation records a single device's change of Key
+type keyRotation struct {
+	device      *device
+	previousKey Key
+}
+
+// rotateKey replaces the Keys of all the devices with the given ID, returning a keyRotation for each
+// device whose Key changed.
+//
+// The write lock of the ID's shard is held throughout, along with the locks of every keyShard that indexes
+// either an old or a new Key.  Those keyShards are locked in index order, so rotations cannot deadlock.
+func (r *shardedRegistry) rotateKey(id ID, newKey Key) ([]keyRotation, error) {
+	s := r.shard(id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	duplicates := s.ids[id]
+	if len(duplicates) == 0 {
+		return nil, ErrorDeviceNotFound
+	}
+
+	ordered := make([]Interface, 0, len(duplicates))
+	for d := range duplicates {
+		ordered = append(ordered, d)
+	}
+
+	sort.Sort(byConnectedAt(ordered))
+
+	var (
+		rotations = make([]keyRotation, 0, len(ordered))
+		newKeys   = make([]Key, 0, len(ordered))
+		keys      = make([]Key, 0, 2*len(ordered))
+	)
+
+	for position, candidate := range ordered {
+		d, k := candidate.(*device), rotatedKey(newKey, position)
+		if previousKey := d.Key(); previousKey != k {
+			rotations = append(rotations, keyRotation{device: d, previousKey: previousKey})
+			newKeys = append(newKeys, k)
+			keys = append(keys, previousKey, k)
+		}
+	}
+
+	if len(rotations) == 0 {
+		return nil, nil
+	}
+
+	unlock := r.lockKeyShards(keys)
+	defer unlock()
+
+	// a new Key may already belong to one of the duplicates, since the duplicates trade Keys all at once
+	for _, k := range newKeys {
+		if owner, ok := r.keyShard(k).keys[k]; ok && !duplicates[owner] {
+			return nil, ErrorDuplicateKey
+		}
+	}
+
+	// every previous Key is released before any new Key is assigned, so that duplicates can trade Keys
+	for _, rotation := range rotations {
+		if s.keys[rotation.previousKey] == rotation.device {
+			delete(s.keys, rotation.previousKey)
+		}
+
+		r.keyShard(rotation.previousKey).release(rotation.previousKey, rotation.device)
+	}
+
+	// the keys change while the write lock is held, so visitors see consistent keys
+	for i, rotation := range rotations {
+		k := newKeys[i]
+		s.keys[k] = rotation.device
+		r.keyShard(k).keys[k] = rotation.device
+		rotation.device.updateKey(k)
+	}
+
+	return rotations, nil
+}
+
+// lockKeyShards locks the keyShards that index the given Keys, in index order, returning a function
+// that unlocks them
+func (r *shardedRegistry) lockKeyShards(keys []Key) func() {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, k := range keys {
+		if i := shardIndex(string(k), len(r.keyShards)); !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		r.keyShards[i].lock.Lock()
+	}
+
+	return func() {
+		for _, i := range indexes {
+			r.keyShards[i].lock.Unlock()
+		}
+	}
+}
+
+func (m *manager) RotateKey(id ID, newKey Key) error {
+	if newKey == invalidKey {
+		return ErrorInvalidKey
+	}
+
+	rotations, err := m.registry.rotateKey(id, newKey)
+	if err != nil {
+		return err
+	}
+
+	for _, rotation := range rotations {
+		m.logger.Info("Rotated key of device [%s] from %s to %s", id, rotation.previousKey, rotation.device.Key())
+		m.dispatch(&Event{
+			Type:        KeyChanged,
+			Device:      rotation.device,
+			PreviousKey: rotation.previousKey,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManagerRotateKey(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		events  []Event
+
+		manager = NewManager(
+			&Options{
+				Logger:         logging.TestLogger(t),
+				RegistryShards: 4,
+				Listeners: []Listener{
+					func(e *Event) { events = append(events, *e) },
+				},
+			},
+			new(mockConnectionFactory),
+		).(*manager)
+
+		id     = ID("mac:112233445566")
+		d      = newDevice(id, Key("original"), nil, 1)
+		others = make([]*device, 10)
+	)
+
+	require.NoError(manager.registry.add(d))
+	for i := range others {
+		others[i] = newDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, 1)
+		require.NoError(manager.registry.add(others[i]))
+	}
+
+	assert.Equal(ErrorInvalidKey, manager.RotateKey(id, ""))
+	assert.Equal(ErrorDeviceNotFound, manager.RotateKey(ID("mac:ffffffffffff"), Key("new")))
+
+	// keys already used by other devices are refused, whichever shard those devices are in
+	for i := range others {
+		assert.Equal(ErrorDuplicateKey, manager.RotateKey(id, Key(strconv.Itoa(i))))
+	}
+
+	assert.Equal(Key("original"), d.Key())
+	assert.Empty(events)
+
+	require.NoError(manager.RotateKey(id, Key("new")))
+	assert.Equal(Key("new"), d.Key())
+	require.Len(events, 1)
+	assert.Equal(KeyChanged, events[0].Type)
+	assert.True(events[0].Device == d)
+	assert.Equal(Key("original"), events[0].PreviousKey)
+
+	assert.Zero(manager.registry.visitKey(Key("original"), func(*device) {}))
+	assert.Equal(1, manager.registry.visitKey(Key("new"), func(visited *device) { assert.True(visited == d) }))
+
+	// rotating to the current key does nothing
+	require.NoError(manager.RotateKey(id, Key("new")))
+	assert.Len(events, 1)
+
+	// the rotated key is used when the device is removed
+	assert.Len(manager.registry.removeAll(id), 1)
+	assert.Zero(manager.registry.visitKey(Key("new"), func(*device) {}))
+}
+
+func TestManagerRotateKeyDuplicates(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		events  []Event
+
+		manager = NewManager(
+			&Options{
+				Logger:         logging.TestLogger(t),
+				RegistryShards: 4,
+				Listeners: []Listener{
+					func(e *Event) { events = append(events, *e) },
+				},
+			},
+			new(mockConnectionFactory),
+		).(*manager)
+
+		id         = ID("mac:112233445566")
+		now        = time.Now()
+		duplicates = make([]*device, 3)
+		other      = newDevice(ID("mac:ffffffffffff"), Key("taken.2"), nil, 1)
+	)
+
+	// add the duplicates newest first, to verify that keys are assigned in connection order
+	for i := len(duplicates) - 1; i >= 0; i-- {
+		duplicates[i] = newDevice(id, Key("original."+strconv.Itoa(i)), nil, 1)
+		duplicates[i].connectedAt = now.Add(time.Duration(i) * time.Second)
+		require.NoError(manager.registry.add(duplicates[i]))
+	}
+
+	require.NoError(manager.registry.add(other))
+
+	// no key changes if any rotated key belongs to a device with another ID
+	assert.Equal(ErrorDuplicateKey, manager.RotateKey(id, Key("taken")))
+	for i, d := range duplicates {
+		assert.Equal(Key("original."+strconv.Itoa(i)), d.Key())
+	}
+
+	assert.Empty(events)
+
+	require.NoError(manager.RotateKey(id, Key("new")))
+	require.Len(events, len(duplicates))
+	for i, d := range duplicates {
+		expectedKey := Key("new")
+		if i > 0 {
+			expectedKey = Key("new." + strconv.Itoa(i))
+		}
+
+		assert.Equal(expectedKey, d.Key())
+		assert.Equal(KeyChanged, events[i].Type)
+		assert.True(events[i].Device == d)
+		assert.Equal(Key("original."+strconv.Itoa(i)), events[i].PreviousKey)
+
+		assert.Zero(manager.registry.visitKey(Key("original."+strconv.Itoa(i)), func(*device) {}))
+		assert.Equal(1, manager.registry.visitKey(expectedKey, func(visited *device) { assert.True(visited == d) }))
+	}
+
+	// once the oldest duplicate disconnects, the others shift down and trade keys in a single rotation
+	require.True(manager.registry.removeOne(duplicates[0]))
+	events = nil
+	require.NoError(manager.RotateKey(id, Key("new")))
+	require.Len(events, 2)
+	assert.Equal(Key("new"), duplicates[1].Key())
+	assert.Equal(Key("new.1"), duplicates[2].Key())
+	assert.Equal(Key("new.1"), events[0].PreviousKey)
+	assert.Equal(Key("new.2"), events[1].PreviousKey)
+
+	assert.Zero(manager.registry.visitKey(Key("new.2"), func(*device) {}))
+	assert.Equal(3, manager.registry.len())
+
+	// the other device keeps its key, and nothing changes for keys already in place
+	events = nil
+	require.NoError(manager.RotateKey(id, Key("new")))
+	assert.Empty(events)
+	assert.Equal(Key("taken.2"), other.Key())
+	assert.Equal(ErrorDuplicateKey, manager.registry.add(newDevice(ID("mac:000000000001"), Key("new.1"), nil, 1)))
+}
+
+// TestManagerRotateKeyConcurrentLookup ensures that a lookup racing with rotations always finds
+// the device under exactly one of its keys
+func TestManagerRotateKeyConcurrentLookup(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		manager = NewManager(&Options{Logger: logging.TestLogger(t)}, new(mockConnectionFactory)).(*manager)
+		id      = ID("mac:112233445566")
+		d       = newDevice(id, Key("0"), nil, 1)
+
+		waitGroup sync.WaitGroup
+		done      = make(chan struct{})
+	)
+
+	require.NoError(manager.registry.add(d))
+	shard := manager.registry.shard(id)
+
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		for i := 1; i <= 1000; i++ {
+			assert.NoError(manager.RotateKey(id, Key(strconv.Itoa(i%2))))
+		}
+
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			waitGroup.Wait()
+			return
+		default:
+		}
+
+		shard.lock.RLock()
+		_, even := shard.keys[Key("0")]
+		_, odd := shard.keys[Key("1")]
+		current := d.Key()
+		shard.lock.RUnlock()
+
+		assert.True(even != odd)
+		if even {
+			assert.Equal(Key("0"), current)
+		} else {
+			assert.Equal(Key("1"), current)
+		}
+	}
+}
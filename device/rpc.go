@@ -0,0 +1,148 @@
+package device
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/wrp"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RPCHandler services a server-bound request sent by a device, i.e. a message whose destination
+// is a service registered with a Manager rather than another device.  If the handler returns a
+// message, that message is written back to the device over the same connection.
+//
+// Handlers are invoked on their own goroutine, so they may block.  The context is cancelled when
+// the device disconnects.  Handlers must not modify the request, which is also delivered to listeners.
+type RPCHandler interface {
+	ServeRPC(ctx context.Context, device Interface, request *wrp.Message) (*wrp.Message, error)
+}
+
+// RPCHandlerFunc is a function type that implements RPCHandler
+type RPCHandlerFunc func(context.Context, Interface, *wrp.Message) (*wrp.Message, error)
+
+func (f RPCHandlerFunc) ServeRPC(ctx context.Context, device Interface, request *wrp.Message) (*wrp.Message, error) {
+	return f(ctx, device, request)
+}
+
+// RPCRegistry maps WRP destination services onto the handlers for requests that devices send to them
+type RPCRegistry interface {
+	// HandleRPC registers a handler for a destination service, such as dns:server/api.  A message
+	// is handled by the handler registered for its entire destination or, failing that, for the
+	// longest prefix of its destination that ends before a slash.  For example, a handler for
+	// dns:server/api receives messages sent to dns:server/api/v2/status.
+	//
+	// A nil handler removes any handler registered for the service.
+	HandleRPC(service string, handler RPCHandler)
+}
+
+// rpcHandlers is the internal RPCRegistry implementation
+type rpcHandlers struct {
+	lock     sync.RWMutex
+	handlers map[string]RPCHandler
+}
+
+func newRPCHandlers(initial map[string]RPCHandler) *rpcHandlers {
+	r := &rpcHandlers{handlers: make(map[string]RPCHandler, len(initial))}
+	for service, handler := range initial {
+		r.HandleRPC(service, handler)
+	}
+
+	return r
+}
+
+func (r *rpcHandlers) HandleRPC(service string, handler RPCHandler) {
+	r.lock.Lock()
+	if handler != nil {
+		r.handlers[service] = handler
+	} else {
+		delete(r.handlers, service)
+	}
+
+	r.lock.Unlock()
+}
+
+// find locates the handler for a destination
+func (r *rpcHandlers) find(destination string) (RPCHandler, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.handlers) == 0 || len(destination) == 0 {
+		return nil, false
+	}
+
+	for {
+		if handler, ok := r.handlers[destination]; ok {
+			return handler, true
+		}
+
+		slash := strings.LastIndexByte(destination, '/')
+		if slash < 0 {
+			return nil, false
+		}
+
+		destination = destination[:slash]
+	}
+}
+
+// rpcResponse fills in the routing information a handler omitted from its response
+func rpcResponse(request, response *wrp.Message) *wrp.Message {
+	if len(response.Destination) == 0 {
+		response.Destination = request.Source
+	}
+
+	if len(response.Source) == 0 {
+		response.Source = request.Destination
+	}
+
+	if len(response.TransactionUUID) == 0 {
+		response.TransactionUUID = request.TransactionUUID
+	}
+
+	return response
+}
+
+// rpcErrorResponse produces the response sent to a device when a handler fails a request that
+// expects a response
+func rpcErrorResponse(request *wrp.Message, err error) *wrp.Message {
+	response := &wrp.Message{
+		Type:        request.Type,
+		ContentType: "text/plain",
+		Payload:     []byte(err.Error()),
+	}
+
+	response.SetStatus(http.StatusInternalServerError)
+	return rpcResponse(request, response)
+}
+
+// serveRPC invokes a handler for a request read from a device, writing any response back to the device
+func (m *manager) serveRPC(d *device, handler RPCHandler, request *wrp.Message) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	response, err := handler.ServeRPC(ctx, d, request)
+	if err != nil {
+		d.logger.Error("RPC handler for [%s] failed on request from device [%s]: %s", request.Destination, d.id, err)
+		if len(request.TransactionUUID) == 0 {
+			return
+		}
+
+		response = rpcErrorResponse(request, err)
+	}
+
+	if response == nil {
+		return
+	}
+
+	// the response is not a new transaction, so it bypasses transaction registration
+	if err := d.sendRequest(&Request{Message: rpcResponse(request, response), ctx: ctx}); err != nil {
+		d.logger.Error("Unable to send RPC response for [%s] to device [%s]: %s", request.Destination, d.id, err)
+	}
+}
@@ -0,0 +1,199 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestRPCHandlers(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		api    = RPCHandlerFunc(func(context.Context, Interface, *wrp.Message) (*wrp.Message, error) { return nil, errors.New("api") })
+		status = RPCHandlerFunc(func(context.Context, Interface, *wrp.Message) (*wrp.Message, error) { return nil, errors.New("status") })
+
+		r = newRPCHandlers(map[string]RPCHandler{"dns:server/api": api})
+	)
+
+	r.HandleRPC("dns:server/api/v2/status", status)
+
+	testData := []struct {
+		destination string
+		expected    RPCHandler
+	}{
+		{"", nil},
+		{"dns:server", nil},
+		{"dns:server/other", nil},
+		{"dns:server/apis", nil},
+		{"dns:server/api", api},
+		{"dns:server/api/v2", api},
+		{"dns:server/api/v2/status", status},
+		{"dns:server/api/v2/status/detail", status},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		actual, ok := r.find(record.destination)
+		assert.Equal(record.expected != nil, ok)
+		if ok {
+			assert.Equal(
+				handlerResult(record.expected),
+				handlerResult(actual),
+			)
+		}
+	}
+
+	r.HandleRPC("dns:server/api/v2/status", nil)
+	actual, ok := r.find("dns:server/api/v2/status")
+	assert.True(ok)
+	assert.Equal(handlerResult(api), handlerResult(actual))
+}
+
+// handlerResult identifies a test handler by its error, since functions cannot be compared
+func handlerResult(handler RPCHandler) string {
+	_, err := handler.ServeRPC(context.Background(), nil, nil)
+	return err.Error()
+}
+
+func TestRPCResponse(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "mac:112233445566",
+			Destination:     "dns:server/api",
+			TransactionUUID: "uuid",
+		}
+	)
+
+	response := rpcResponse(request, &wrp.Message{Type: wrp.SimpleRequestResponseMessageType})
+	assert.Equal("mac:112233445566", response.Destination)
+	assert.Equal("dns:server/api", response.Source)
+	assert.Equal("uuid", response.TransactionUUID)
+
+	response = rpcResponse(request, &wrp.Message{Source: "dns:other", Destination: "mac:ffffffffffff", TransactionUUID: "other"})
+	assert.Equal("mac:ffffffffffff", response.Destination)
+	assert.Equal("dns:other", response.Source)
+	assert.Equal("other", response.TransactionUUID)
+
+	response = rpcErrorResponse(request, errors.New("expected"))
+	assert.Equal(wrp.SimpleRequestResponseMessageType, response.Type)
+	assert.Equal("mac:112233445566", response.Destination)
+	assert.Equal("uuid", response.TransactionUUID)
+	if assert.NotNil(response.Status) {
+		assert.Equal(int64(http.StatusInternalServerError), *response.Status)
+	}
+
+	assert.Equal("expected", string(response.Payload))
+}
+
+func TestManagerRPC(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		received     = make(chan EventType, 3)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			RPCHandlers: map[string]RPCHandler{
+				"dns:server/api": RPCHandlerFunc(func(ctx context.Context, d Interface, request *wrp.Message) (*wrp.Message, error) {
+					if string(request.Payload) == "fail" {
+						return nil, errors.New("expected")
+					}
+
+					return &wrp.Message{
+						Type:    wrp.SimpleRequestResponseMessageType,
+						Payload: []byte("hello, " + string(d.ID())),
+					}, nil
+				}),
+			},
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived, TransactionBroken:
+						received <- event.Type
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	<-connected
+
+	exchange := func(request *wrp.Message) *wrp.Message {
+		var encoded []byte
+		require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(request))
+		_, err := c.Write(encoded)
+		require.NoError(err)
+
+		var frame bytes.Buffer
+		_, err = c.Read(&frame)
+		require.NoError(err)
+
+		response := new(wrp.Message)
+		require.NoError(wrp.NewDecoderBytes(frame.Bytes(), c.Format()).Decode(response))
+		return response
+	}
+
+	response := exchange(&wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "mac:112233445566",
+		Destination:     "dns:server/api/v2/hello",
+		TransactionUUID: "first",
+	})
+
+	assert.Equal(MessageReceived, <-received)
+	assert.Equal("mac:112233445566", response.Destination)
+	assert.Equal("dns:server/api/v2/hello", response.Source)
+	assert.Equal("first", response.TransactionUUID)
+	assert.Equal("hello, mac:112233445566", string(response.Payload))
+
+	response = exchange(&wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "mac:112233445566",
+		Destination:     "dns:server/api",
+		TransactionUUID: "second",
+		Payload:         []byte("fail"),
+	})
+
+	assert.Equal(MessageReceived, <-received)
+	assert.Equal("second", response.TransactionUUID)
+	if assert.NotNil(response.Status) {
+		assert.Equal(int64(http.StatusInternalServerError), *response.Status)
+	}
+
+	// once removed, requests for the service are treated like any other message
+	manager.HandleRPC("dns:server/api", nil)
+	var encoded []byte
+	require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(&wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "mac:112233445566",
+		Destination:     "dns:server/api",
+		TransactionUUID: "third",
+	}))
+
+	_, err = c.Write(encoded)
+	require.NoError(err)
+	assert.Equal(TransactionBroken, <-received)
+
+	c.Close()
+	<-disconnected
+}
@@ -0,0 +1,248 @@
+package device
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionHeader is the HTTP header that carries session tokens.  A token is issued in this header
+	// of each websocket upgrade response, and a reconnecting device sends it back in its upgrade request.
+	DefaultSessionHeader = "X-Webpa-Session"
+
+	// sessionIDSize and sessionTokenSize are the counts of random bytes in session identifiers and tokens
+	sessionIDSize    = 12
+	sessionTokenSize = 24
+)
+
+// randomString produces the URL-safe encoding of size random bytes
+func randomString(size int) (string, error) {
+	raw := make([]byte, size)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// session is the state shared by successive connections from the same device.  Pending transactions
+// belong to the session rather than to a connection, and messages still queued when a connection ends are
+// parked until the device resumes the session or the session expires.
+type session struct {
+	id           string
+	deviceID     ID
+	transactions *Transactions
+
+	// ended is closed once this session expires, which fails any request still waiting on it
+	ended chan struct{}
+
+	lock    sync.Mutex
+	token   string
+	expired bool
+	timer   *time.Timer
+	parked  []*envelope
+
+	// current is the connected device that owns this session, or nil while the device is disconnected.
+	// last is the most recent device, whether connected or not.
+	current *device
+	last    *device
+}
+
+// requeue moves envelopes onto a device's queue without blocking, returning those that did not fit
+func requeue(d *device, envelopes []*envelope) (overflow []*envelope) {
+	for _, e := range envelopes {
+		select {
+		case d.messages[e.request.EffectivePriority()] <- e:
+			d.queueChanged(1)
+		default:
+			overflow = append(overflow, e)
+		}
+	}
+
+	return
+}
+
+// rejectEnvelope completes an envelope that will never be written, so that its sender stops waiting
+func rejectEnvelope(e *envelope, err error) {
+	e.complete <- err
+	close(e.complete)
+}
+
+// newSession creates a session that has not yet been attached to any device
+func newSession(id, token string) *session {
+	return &session{
+		id:    id,
+		ended: make(chan struct{}),
+		token: token,
+	}
+}
+
+// issueSessionToken creates the session for a websocket upgrade, along with the response header carrying
+// its token.  The session is created before the upgrade response is written, so a device is never issued
+// a token for a session that does not exist.  The given header is not modified.  If sessions are disabled
+// or the session cannot be created, the returned session is nil and the header is returned as is.
+func (m *manager) issueSessionToken(id ID, responseHeader http.Header) (*session, http.Header) {
+	if m.sessionGracePeriod <= 0 {
+		return nil, responseHeader
+	}
+
+	sessionID, err := randomString(sessionIDSize)
+	if err != nil {
+		m.logger.Error("Unable to start a session for device [%s]: %s", id, err)
+		return nil, responseHeader
+	}
+
+	token, err := randomString(sessionTokenSize)
+	if err != nil {
+		m.logger.Error("Unable to issue a session token to device [%s]: %s", id, err)
+		return nil, responseHeader
+	}
+
+	issued := make(http.Header, len(responseHeader)+1)
+	for name, values := range responseHeader {
+		issued[name] = values
+	}
+
+	issued.Set(m.sessionHeader, token)
+	return newSession(sessionID, token), issued
+}
+
+// attachSession associates a newly connected device with a session that it can later resume with the token
+// of the issued session.  If token identifies an unexpired session of the same device, that session is resumed:
+// its transactions are shared and its parked messages are queued for the device.  Otherwise, the issued session
+// is started.
+//
+// This method returns true if a session was resumed, along with any parked envelopes that no longer fit
+// in the device's queue.
+func (m *manager) attachSession(d *device, token string, issued *session) (bool, []*envelope) {
+	m.sessionLock.Lock()
+	defer m.sessionLock.Unlock()
+
+	if s, ok := m.sessions[token]; ok {
+		s.lock.Lock()
+		if !s.expired && s.deviceID == d.id {
+			if s.timer != nil {
+				s.timer.Stop()
+				s.timer = nil
+			}
+
+			delete(m.sessions, token)
+			m.sessions[issued.token] = s
+			s.token = issued.token
+
+			previous := s.current
+			s.current, s.last = d, d
+			d.session, d.transactions = s, s.transactions
+
+			parked := s.parked
+			s.parked = nil
+			overflow := requeue(d, parked)
+			s.lock.Unlock()
+
+			// a session can be resumed before its previous connection is known to be dead
+			if previous != nil {
+				previous.RequestClose()
+			}
+
+			d.logger.Info("Device [%s] resumed session %s with %d parked messages", d.id, s.id, len(parked))
+			return true, overflow
+		}
+
+		s.lock.Unlock()
+		d.logger.Warn("Device [%s] presented a session token that cannot be resumed", d.id)
+	}
+
+	issued.deviceID = d.id
+	issued.transactions = d.transactions
+	issued.current, issued.last = d, d
+
+	m.sessions[issued.token] = issued
+	d.session = issued
+	return false, nil
+}
+
+// detachSession ends a device connection's part in its session.  Messages that were still queued for the
+// device are moved to the device that has already resumed the session, if any, or are parked until the
+// device reconnects within the grace period.  The envelopes that could not be kept are returned.
+func (m *manager) detachSession(d *device, queued []*envelope) []*envelope {
+	s := d.session
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// envelopes can be requeued to this device by a previous connection until this lock is held
+	queued = append(queued, d.drainQueue()...)
+
+	switch {
+	case s.current == d:
+		s.current = nil
+		s.timer = time.AfterFunc(m.sessionGracePeriod, func() { m.expireSession(s) })
+	case s.current != nil:
+		// a newer connection has already resumed this session
+		return requeue(s.current, queued)
+	case s.expired:
+		return queued
+	}
+
+	// this connection may have been superseded by one that has itself since disconnected
+	s.parked = append(s.parked, queued...)
+	return nil
+}
+
+// expireSession ends a session that was not resumed within the grace period.  Its parked messages fail.
+func (m *manager) expireSession(s *session) {
+	s.lock.Lock()
+	if s.expired || s.current != nil {
+		// the session was resumed just as the timer fired
+		s.lock.Unlock()
+		return
+	}
+
+	s.expired = true
+	parked := s.parked
+	s.parked = nil
+	close(s.ended)
+	s.lock.Unlock()
+
+	m.sessionLock.Lock()
+	if m.sessions[s.token] == s {
+		delete(m.sessions, s.token)
+	}
+
+	m.sessionLock.Unlock()
+
+	m.logger.Debug("Session %s of device [%s] expired with %d parked messages", s.id, s.deviceID, len(parked))
+	m.failEnvelopes(s.last, parked, ErrorDeviceClosed)
+}
+
+// failEnvelopes rejects envelopes that were held for a session, dispatching a MessageFailed event for each
+// and passing the requests to any QueueDrainHandler
+func (m *manager) failEnvelopes(d *device, envelopes []*envelope, err error) {
+	if len(envelopes) == 0 {
+		return
+	}
+
+	var (
+		event       Event
+		undelivered = make([]*Request, 0, len(envelopes))
+	)
+
+	for _, e := range envelopes {
+		rejectEnvelope(e, err)
+		undelivered = append(undelivered, e.request)
+
+		event.Clear()
+		event.Type = MessageFailed
+		event.Device = d
+		event.Message = e.request.Message
+		event.Format = e.request.Format
+		event.Error = err
+		m.dispatch(&event)
+	}
+
+	if m.queueDrainHandler != nil {
+		m.queueDrainHandler(d, undelivered)
+	}
+}
@@ -0,0 +1,317 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestEnvelope creates an envelope for a simple event, as though it had been queued by Send
+func newTestEnvelope(destination string) (*envelope, <-chan error) {
+	complete := make(chan error, 1)
+	return &envelope{
+		request: &Request{
+			Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: destination},
+			Format:  wrp.Msgpack,
+		},
+		complete:   complete,
+		enqueuedAt: time.Now(),
+	}, complete
+}
+
+func TestIssueSessionToken(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		id      = IntToMAC(0x112233445566)
+		header  = http.Header{"X-Test": {"value"}}
+	)
+
+	disabled := NewManager(&Options{Logger: logging.TestLogger(t)}, nil).(*manager)
+	issued, responseHeader := disabled.issueSessionToken(id, header)
+	assert.Nil(issued)
+	assert.Equal(header, responseHeader)
+
+	m := NewManager(&Options{Logger: logging.TestLogger(t), SessionGracePeriod: time.Minute}, nil).(*manager)
+	issued, responseHeader = m.issueSessionToken(id, header)
+	require.NotNil(issued)
+	assert.NotEmpty(issued.id)
+	assert.NotEmpty(issued.token)
+	assert.Equal(issued.token, responseHeader.Get(DefaultSessionHeader))
+	assert.Equal("value", responseHeader.Get("X-Test"))
+	assert.Empty(header.Get(DefaultSessionHeader))
+
+	// the issued session can be resumed once it is attached
+	d := newDevice(id, Key("issued"), nil, 10)
+	resumed, _ := m.attachSession(d, "", issued)
+	assert.False(resumed)
+	assert.Equal(issued.id, d.SessionID())
+	assert.True(m.sessions[issued.token] == issued)
+}
+
+func TestSessionResume(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		id      = IntToMAC(0x112233445566)
+
+		drained []*Request
+		m       = NewManager(&Options{
+			Logger:             logging.TestLogger(t),
+			SessionGracePeriod: time.Minute,
+			QueueDrainHandler:  func(d Interface, undelivered []*Request) { drained = append(drained, undelivered...) },
+		}, nil).(*manager)
+
+		first         = newDevice(id, Key("first"), nil, 10)
+		queued, wait  = newTestEnvelope("event:queued")
+		overflow, bad = newTestEnvelope("event:overflow")
+	)
+
+	resumed, rejected := m.attachSession(first, "", newSession("session1", "token1"))
+	assert.False(resumed)
+	assert.Empty(rejected)
+	require.NotNil(first.session)
+	assert.NotEmpty(first.SessionID())
+	assert.Contains(first.String(), first.SessionID())
+
+	first.messages[LowPriority] <- queued
+	first.messages[LowPriority] <- overflow
+	assert.Empty(m.detachSession(first, nil))
+	assert.Zero(first.messages.len())
+
+	// nothing fails while the session is parked
+	select {
+	case <-first.abandoned():
+		assert.Fail("The session should not have ended")
+	default:
+	}
+
+	// only the same device can resume a session
+	other := newDevice(IntToMAC(0xAABBCCDDEEFF), Key("other"), nil, 10)
+	resumed, _ = m.attachSession(other, "token1", newSession("session2", "token2"))
+	assert.False(resumed)
+	assert.NotEqual(first.SessionID(), other.SessionID())
+
+	// the resuming device's queue only holds one of the parked messages
+	second := newDevice(id, Key("second"), nil, 1)
+	resumed, rejected = m.attachSession(second, "token1", newSession("session3", "token3"))
+	assert.True(resumed)
+	assert.Equal(first.SessionID(), second.SessionID())
+	assert.True(first.transactions == second.transactions)
+	assert.Equal([]*envelope{overflow}, rejected)
+	assert.Equal(queued, second.messages.poll())
+
+	m.failEnvelopes(second, rejected, ErrorDeviceBusy)
+	assert.Equal(ErrorDeviceBusy, <-bad)
+	assert.Equal([]*Request{overflow.request}, drained)
+	assert.Empty(wait)
+
+	// a token can only be used once
+	third := newDevice(id, Key("third"), nil, 10)
+	resumed, _ = m.attachSession(third, "token1", newSession("session4", "token4"))
+	assert.False(resumed)
+	assert.NotEqual(second.SessionID(), third.SessionID())
+}
+
+func TestSessionSuperseded(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		id     = IntToMAC(0x112233445566)
+		m      = NewManager(&Options{Logger: logging.TestLogger(t), SessionGracePeriod: time.Minute}, nil).(*manager)
+
+		first  = newDevice(id, Key("first"), nil, 10)
+		second = newDevice(id, Key("second"), nil, 10)
+		queued = &envelope{request: &Request{Message: new(wrp.Message)}}
+	)
+
+	m.attachSession(first, "", newSession("session1", "token1"))
+
+	// resuming a session before the previous connection has ended closes that connection
+	resumed, _ := m.attachSession(second, "token1", newSession("session2", "token2"))
+	assert.True(resumed)
+	assert.True(first.Closed())
+	assert.False(second.Closed())
+
+	// messages still queued for the previous connection move to the current one
+	first.messages[LowPriority] <- queued
+	assert.Empty(m.detachSession(first, nil))
+	assert.Equal(queued, second.messages.poll())
+	assert.Empty(first.session.parked)
+}
+
+func TestSessionExpire(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		id      = IntToMAC(0x112233445566)
+
+		drained = make(chan []*Request, 1)
+		m       = NewManager(&Options{
+			Logger:             logging.TestLogger(t),
+			SessionGracePeriod: 50 * time.Millisecond,
+			QueueDrainHandler:  func(d Interface, undelivered []*Request) { drained <- undelivered },
+		}, nil).(*manager)
+
+		d              = newDevice(id, Key("expire"), nil, 10)
+		queued, result = newTestEnvelope("event:queued")
+	)
+
+	m.attachSession(d, "", newSession("session1", "token1"))
+	d.messages[LowPriority] <- queued
+	m.detachSession(d, nil)
+
+	select {
+	case undelivered := <-drained:
+		assert.Equal([]*Request{queued.request}, undelivered)
+	case <-time.After(5 * time.Second):
+		require.Fail("The session did not expire")
+	}
+
+	assert.Equal(ErrorDeviceClosed, <-result)
+	select {
+	case <-d.abandoned():
+	default:
+		assert.Fail("Requests should be abandoned once the session expires")
+	}
+
+	resumed, _ := m.attachSession(newDevice(id, Key("late"), nil, 10), "token1", newSession("session2", "token2"))
+	assert.False(resumed)
+}
+
+func TestManagerSessions(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		id      = IntToMAC(0x112233445566)
+
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan Interface, 1)
+		options      = &Options{
+			Logger:             logging.TestLogger(t),
+			SessionGracePeriod: time.Minute,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(options, nil)
+	)
+
+	defer server.Close()
+
+	c, response, err := dialer.Dial(connectURL, id, nil, nil)
+	require.NoError(err)
+	token := response.Header.Get(DefaultSessionHeader)
+	require.NotEmpty(token)
+
+	first := <-connected
+	require.NotEmpty(first.SessionID())
+
+	results := make(chan error, 1)
+	go func() {
+		response, err := first.Send(&Request{
+			Message: &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "service",
+				Destination:     "mac:112233445566/service",
+				TransactionUUID: "resumed",
+			},
+			Format: wrp.Msgpack,
+		})
+
+		if err == nil && response.Message.TransactionUUID != "resumed" {
+			err = ErrorTransactionCancelled
+		}
+
+		results <- err
+	}()
+
+	// the device receives the request, but disconnects before answering it
+	frame, err := c.NextReader()
+	require.NoError(err)
+	require.NotNil(frame)
+
+	request := new(wrp.Message)
+	require.NoError(wrp.NewDecoder(frame, c.Format()).Decode(request))
+	require.NoError(c.Close())
+	assert.Equal(first, <-disconnected)
+
+	c, response, err = dialer.Dial(connectURL, id, nil, http.Header{DefaultSessionHeader: {token}})
+	require.NoError(err)
+	defer func() {
+		c.Close()
+		<-disconnected
+	}()
+	assert.NotEmpty(response.Header.Get(DefaultSessionHeader))
+	assert.NotEqual(token, response.Header.Get(DefaultSessionHeader))
+
+	second := <-connected
+	assert.Equal(first.SessionID(), second.SessionID())
+
+	// the answer arrives over the new connection
+	var encoded []byte
+	require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(&wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          request.Destination,
+		Destination:     request.Source,
+		TransactionUUID: request.TransactionUUID,
+	}))
+
+	_, err = c.Write(encoded)
+	require.NoError(err)
+
+	select {
+	case err := <-results:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("The pending transaction was not restored")
+	}
+}
+
+func TestManagerSessionsDisabled(t *testing.T) {
+	var (
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan Interface, 1)
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, response, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	defer func() {
+		c.Close()
+		<-disconnected
+	}()
+
+	require.Empty(response.Header.Get(DefaultSessionHeader))
+	require.Empty((<-connected).SessionID())
+}
@@ -0,0 +1,47 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/secure"
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// SignaturePolicy determines what happens to inbound messages whose signatures cannot be verified
+type SignaturePolicy string
+
+const (
+	// RejectBadSignature drops messages that fail verification.  This is the default.
+	RejectBadSignature SignaturePolicy = "reject"
+
+	// FlagBadSignature delivers messages that fail verification, with the verification
+	// error in the Error field of the dispatched Event
+	FlagBadSignature SignaturePolicy = "flag"
+)
+
+// copyMessage produces a shallow copy of a WRP message with its own metadata map, so that
+// metadata can be added without affecting the original
+func copyMessage(original *wrp.Message, extraMetadata int) *wrp.Message {
+	copied := *original
+	copied.Metadata = make(map[string]string, len(original.Metadata)+extraMetadata)
+	for key, value := range original.Metadata {
+		copied.Metadata[key] = value
+	}
+
+	return &copied
+}
+
+// signedMessage returns a signed copy of the given encodable message.  If the message
+// is not a *wrp.Message, it cannot be signed and is returned as is.  The original routable
+// is used to determine whether the encodable message is already a private copy.
+func signedMessage(signer *secure.MessageSigner, original wrp.Routable, encodable interface{}) interface{} {
+	message, ok := encodable.(*wrp.Message)
+	if !ok {
+		return encodable
+	}
+
+	if shared, ok := original.(*wrp.Message); ok && shared == message {
+		message = copyMessage(message, 2)
+	}
+
+	signer.Sign(message)
+	return message
+}
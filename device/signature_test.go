@@ -0,0 +1,156 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/secure"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOptionsSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Nil(o.signer())
+		assert.Nil(o.verifier())
+		assert.Equal(RejectBadSignature, o.signaturePolicy())
+	}
+
+	o := &Options{
+		Signer:          new(secure.MessageSigner),
+		Verifier:        new(secure.MessageVerifier),
+		SignaturePolicy: FlagBadSignature,
+	}
+
+	assert.Equal(o.Signer, o.signer())
+	assert.Equal(o.Verifier, o.verifier())
+	assert.Equal(FlagBadSignature, o.signaturePolicy())
+	assert.Equal(RejectBadSignature, (&Options{SignaturePolicy: "unrecognized"}).signaturePolicy())
+}
+
+func TestSignedMessage(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		signer   = &secure.MessageSigner{KeyId: "current", Secret: []byte("secret")}
+		original = &wrp.Message{Type: wrp.SimpleEventMessageType, Payload: []byte("hello")}
+	)
+
+	// the caller's message is never modified
+	signed, ok := signedMessage(signer, original, original).(*wrp.Message)
+	if assert.True(ok) {
+		assert.True(signed != original)
+		assert.NotEmpty(signed.Metadata[secure.SignatureMetadataKey])
+		assert.Empty(original.Metadata)
+	}
+
+	// a private copy is signed in place
+	copied := copyMessage(original, 2)
+	assert.True(signedMessage(signer, original, copied) == copied)
+	assert.NotEmpty(copied.Metadata[secure.SignatureMetadataKey])
+
+	// other routables cannot be signed
+	event := &wrp.SimpleEvent{Destination: "mac:112233445566"}
+	assert.True(signedMessage(signer, event, event) == event)
+}
+
+func testManagerSignature(t *testing.T, policy SignaturePolicy) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		secret  = []byte("secret")
+		signer  = &secure.MessageSigner{KeyId: "current", Secret: secret}
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 1)
+		received     = make(chan *Event, 3)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:          logging.TestLogger(t),
+			Metrics:         registry,
+			Signer:          signer,
+			Verifier:        &secure.MessageVerifier{Keys: secure.HMACKeys{"current": secret}},
+			SignaturePolicy: policy,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived:
+						copied := *event
+						received <- &copied
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	// outbound messages are signed
+	original := &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566", Payload: []byte("outbound")}
+	_, err = d.Send(&Request{Message: original, ctx: context.Background()})
+	require.NoError(err)
+	assert.Empty(original.Metadata)
+
+	var frame bytes.Buffer
+	_, err = c.Read(&frame)
+	require.NoError(err)
+
+	outbound := new(wrp.Message)
+	require.NoError(wrp.NewDecoderBytes(frame.Bytes(), c.Format()).Decode(outbound))
+	assert.Equal("outbound", string(outbound.Payload))
+	assert.NoError(options.Verifier.Verify(outbound))
+
+	// inbound messages: one validly signed, one unsigned
+	signed := &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Payload: []byte("signed")}
+	signer.Sign(signed)
+	unsigned := &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Payload: []byte("unsigned")}
+
+	for _, message := range []*wrp.Message{unsigned, signed} {
+		var encoded []byte
+		require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(message))
+		_, err = c.Write(encoded)
+		require.NoError(err)
+	}
+
+	if policy == FlagBadSignature {
+		flagged := <-received
+		assert.Equal(secure.ErrorMissingSignature, flagged.Error)
+	}
+
+	accepted := <-received
+	assert.NoError(accepted.Error)
+	assert.Equal("signed", string(accepted.Message.(*wrp.Message).Payload))
+
+	c.Close()
+	<-disconnected
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, SignatureFailureCount+`{action="`+string(policy)+`"} 1`), body)
+}
+
+func TestManagerSignature(t *testing.T) {
+	t.Run("Reject", func(t *testing.T) { testManagerSignature(t, RejectBadSignature) })
+	t.Run("Flag", func(t *testing.T) { testManagerSignature(t, FlagBadSignature) })
+}
@@ -0,0 +1,51 @@
+package device
+
+import (
+	"time"
+)
+
+// SlowConsumerPolicy determines what happens to a device that is consistently unable to keep up with its traffic
+type SlowConsumerPolicy string
+
+const (
+	// CloseSlowConsumer disconnects slow devices with a "slow consumer" close reason.  This is the default.
+	CloseSlowConsumer SlowConsumerPolicy = "close"
+
+	// DegradeSlowConsumer keeps slow devices connected but rejects their low-priority messages, i.e. those
+	// that do not start a transaction, with ErrorSlowConsumer until the device catches up.
+	DegradeSlowConsumer SlowConsumerPolicy = "degrade"
+
+	DefaultSlowConsumerPeriod time.Duration = 30 * time.Second
+
+	// SlowConsumerCloseReason is the text of the websocket close frame sent to evicted devices
+	SlowConsumerCloseReason = "slow consumer"
+)
+
+// slowConsumerDetector tracks how long a device has continuously exceeded its thresholds.
+// Instances are owned by a single write pump and are not safe for concurrent use.
+type slowConsumerDetector struct {
+	queueThreshold int
+	writeStall     time.Duration
+	period         time.Duration
+
+	since time.Time
+}
+
+// observe records a sample of the device's queue length along with the duration of the
+// most recent write, or zero if no write took place.  This method returns true if the
+// device has been over its thresholds for at least the configured period.
+func (scd *slowConsumerDetector) observe(now time.Time, queued int, stall time.Duration) bool {
+	over := (scd.queueThreshold > 0 && queued >= scd.queueThreshold) ||
+		(scd.writeStall > 0 && stall >= scd.writeStall)
+
+	if !over {
+		scd.since = time.Time{}
+		return false
+	}
+
+	if scd.since.IsZero() {
+		scd.since = now
+	}
+
+	return now.Sub(scd.since) >= scd.period
+}
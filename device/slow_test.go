@@ -0,0 +1,159 @@
+package device
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowConsumerDetector(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		start    = time.Now()
+		detector = slowConsumerDetector{queueThreshold: 10, writeStall: time.Second, period: time.Minute}
+	)
+
+	assert.False(detector.observe(start, 0, 0))
+	assert.False(detector.observe(start, 10, 0))
+	assert.False(detector.observe(start.Add(30*time.Second), 5, 2*time.Second))
+	assert.True(detector.observe(start.Add(time.Minute), 12, 0))
+	assert.True(detector.observe(start.Add(2*time.Minute), 0, time.Second))
+
+	// recovery resets the period
+	assert.False(detector.observe(start.Add(3*time.Minute), 0, 0))
+	assert.False(detector.observe(start.Add(4*time.Minute), 10, 0))
+	assert.True(detector.observe(start.Add(5*time.Minute), 10, 0))
+}
+
+func TestOptionsSlowConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Nil(o.slowConsumerDetector())
+		assert.Equal(CloseSlowConsumer, o.slowConsumerPolicy())
+	}
+
+	o := &Options{SlowConsumerQueueThreshold: 50, SlowConsumerPolicy: "unrecognized"}
+	assert.Equal(CloseSlowConsumer, o.slowConsumerPolicy())
+	if detector := o.slowConsumerDetector(); assert.NotNil(detector) {
+		assert.Equal(50, detector.queueThreshold)
+		assert.Equal(time.Duration(0), detector.writeStall)
+		assert.Equal(DefaultSlowConsumerPeriod, detector.period)
+	}
+
+	o = &Options{SlowConsumerWriteStall: time.Second, SlowConsumerPeriod: time.Minute, SlowConsumerPolicy: DegradeSlowConsumer}
+	assert.Equal(DegradeSlowConsumer, o.slowConsumerPolicy())
+	if detector := o.slowConsumerDetector(); assert.NotNil(detector) {
+		assert.Equal(0, detector.queueThreshold)
+		assert.Equal(time.Second, detector.writeStall)
+		assert.Equal(time.Minute, detector.period)
+	}
+}
+
+func TestDeviceDegraded(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		d       = newDevice(ID("mac:112233445566"), Key("key"), nil, 1)
+	)
+
+	assert.False(d.isDegraded())
+	assert.False(d.setDegraded(false))
+	assert.True(d.setDegraded(true))
+	assert.False(d.setDegraded(true))
+	assert.True(d.isDegraded())
+
+	response, err := d.Send(&Request{
+		Message: &wrp.SimpleEvent{Destination: "mac:112233445566/service"},
+		ctx:     context.Background(),
+	})
+
+	assert.Nil(response)
+	assert.Equal(ErrorSlowConsumer, err)
+
+	// transactions are still enqueued while degraded
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = d.Send(&Request{
+		Message: &wrp.SimpleRequestResponse{Destination: "mac:112233445566/service", TransactionUUID: "transaction"},
+		ctx:     ctx,
+	})
+
+	require.Error(err)
+	assert.NotEqual(ErrorSlowConsumer, err)
+
+	assert.True(d.setDegraded(false))
+	assert.False(d.isDegraded())
+}
+
+func TestManagerSlowConsumerClose(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:                 logging.TestLogger(t),
+			Metrics:                registry,
+			SlowConsumerWriteStall: time.Nanosecond,
+			SlowConsumerPeriod:     time.Nanosecond,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	defer c.Close()
+	d := <-connected
+
+	// the first write starts the stall period, and the second exceeds it
+	for i := 0; i < 2; i++ {
+		_, err := d.Send(&Request{Message: &wrp.SimpleEvent{Destination: "mac:112233445566/service"}})
+		assert.NoError(err)
+	}
+
+	for {
+		if _, err = c.NextReader(); err != nil {
+			break
+		}
+	}
+
+	assert.True(websocket.IsCloseError(err, websocket.ClosePolicyViolation), err.Error())
+	if closeError, ok := err.(*websocket.CloseError); assert.True(ok) {
+		assert.Equal(SlowConsumerCloseReason, closeError.Text)
+	}
+
+	<-disconnected
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, SlowConsumerCount+`{action="close"} 1`), body)
+}
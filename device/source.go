@@ -0,0 +1,85 @@
+package device
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Source extracts a raw connection parameter, such as the device name or convey, from an
+// upgrade request.  The boolean return indicates whether the request supplied the parameter.
+type Source func(*http.Request) (string, bool)
+
+// HeaderSource produces a Source that reads the given HTTP header
+func HeaderSource(name string) Source {
+	return func(request *http.Request) (string, bool) {
+		value := request.Header.Get(name)
+		return value, len(value) > 0
+	}
+}
+
+// QuerySource produces a Source that reads the given URL query parameter
+func QuerySource(name string) Source {
+	return func(request *http.Request) (string, bool) {
+		value := request.URL.Query().Get(name)
+		return value, len(value) > 0
+	}
+}
+
+// PathSegmentSource produces a Source that reads a segment of the URL path.  Segments are
+// numbered from zero, and a negative index counts backward from the last segment.  For example,
+// for the path /api/v2/device/mac:112233445566, index -1 and index 3 both yield the device name.
+func PathSegmentSource(index int) Source {
+	return func(request *http.Request) (string, bool) {
+		var (
+			segments = strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+			position = index
+		)
+
+		if position < 0 {
+			position += len(segments)
+		}
+
+		if position < 0 || position >= len(segments) || len(segments[position]) == 0 {
+			return "", false
+		}
+
+		return segments[position], true
+	}
+}
+
+// TLSSANSource is a Source that reads the first subject alternative name from the client's
+// TLS certificate.  URI SANs, e.g. mac:112233445566, are preferred over DNS SANs.
+func TLSSANSource(request *http.Request) (string, bool) {
+	if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	certificate := request.TLS.PeerCertificates[0]
+	for _, uri := range certificate.URIs {
+		if value := uri.String(); len(value) > 0 {
+			return value, true
+		}
+	}
+
+	for _, name := range certificate.DNSNames {
+		if len(name) > 0 {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// FirstOf produces a Source that consults each of the given sources in order, returning the
+// first value supplied.  This allows different client generations to present a parameter differently.
+func FirstOf(sources ...Source) Source {
+	return func(request *http.Request) (string, bool) {
+		for _, s := range sources {
+			if value, ok := s(request); ok {
+				return value, true
+			}
+		}
+
+		return "", false
+	}
+}
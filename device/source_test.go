@@ -0,0 +1,194 @@
+package device
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHeaderSource(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		source  = HeaderSource("X-Test")
+		request = httptest.NewRequest("GET", "/", nil)
+	)
+
+	value, ok := source(request)
+	assert.Equal("", value)
+	assert.False(ok)
+
+	request.Header.Set("X-Test", "value")
+	value, ok = source(request)
+	assert.Equal("value", value)
+	assert.True(ok)
+}
+
+func TestQuerySource(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		source = QuerySource("id")
+	)
+
+	value, ok := source(httptest.NewRequest("GET", "/?other=1", nil))
+	assert.Equal("", value)
+	assert.False(ok)
+
+	value, ok = source(httptest.NewRequest("GET", "/?id=mac:112233445566", nil))
+	assert.Equal("mac:112233445566", value)
+	assert.True(ok)
+}
+
+func TestPathSegmentSource(t *testing.T) {
+	testData := []struct {
+		path          string
+		index         int
+		expectedValue string
+		expectedOK    bool
+	}{
+		{"/api/v2/device/mac:112233445566", 3, "mac:112233445566", true},
+		{"/api/v2/device/mac:112233445566", -1, "mac:112233445566", true},
+		{"/api/v2/device/mac:112233445566/", -1, "mac:112233445566", true},
+		{"/api/v2/device/mac:112233445566", 0, "api", true},
+		{"/api/v2/device/mac:112233445566", -4, "api", true},
+		{"/api/v2/device/mac:112233445566", 4, "", false},
+		{"/api/v2/device/mac:112233445566", -5, "", false},
+		{"/", 0, "", false},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		value, ok := PathSegmentSource(record.index)(httptest.NewRequest("GET", record.path, nil))
+		assert.Equal(t, record.expectedValue, value)
+		assert.Equal(t, record.expectedOK, ok)
+	}
+}
+
+func TestTLSSANSource(t *testing.T) {
+	uri, err := url.Parse("mac:112233445566")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testData := []struct {
+		state         *tls.ConnectionState
+		expectedValue string
+		expectedOK    bool
+	}{
+		{nil, "", false},
+		{&tls.ConnectionState{}, "", false},
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}, "", false},
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{{DNSNames: []string{"device.example.com"}}}}, "device.example.com", true},
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{{DNSNames: []string{"device.example.com"}, URIs: []*url.URL{uri}}}}, "mac:112233445566", true},
+	}
+
+	for _, record := range testData {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.TLS = record.state
+
+		value, ok := TLSSANSource(request)
+		assert.Equal(t, record.expectedValue, value)
+		assert.Equal(t, record.expectedOK, ok)
+	}
+}
+
+func TestFirstOf(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		source = FirstOf(HeaderSource("X-Test"), QuerySource("id"), PathSegmentSource(-1))
+	)
+
+	request := httptest.NewRequest("GET", "/device/fromPath?id=fromQuery", nil)
+	request.Header.Set("X-Test", "fromHeader")
+	value, ok := source(request)
+	assert.Equal("fromHeader", value)
+	assert.True(ok)
+
+	value, ok = source(httptest.NewRequest("GET", "/device/fromPath?id=fromQuery", nil))
+	assert.Equal("fromQuery", value)
+	assert.True(ok)
+
+	value, ok = source(httptest.NewRequest("GET", "/device/fromPath", nil))
+	assert.Equal("fromPath", value)
+	assert.True(ok)
+
+	value, ok = FirstOf()(httptest.NewRequest("GET", "/", nil))
+	assert.Equal("", value)
+	assert.False(ok)
+}
+
+func TestOptionsSources(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = httptest.NewRequest("GET", "/?id=fromQuery&convey=conveyQuery", nil)
+	)
+
+	request.Header.Set(DefaultDeviceNameHeader, "fromHeader")
+	request.Header.Set(DefaultConveyHeader, "conveyHeader")
+
+	for _, o := range []*Options{nil, new(Options)} {
+		value, _ := o.deviceNameSource()(request)
+		assert.Equal("fromHeader", value)
+
+		value, _ = o.conveySource()(request)
+		assert.Equal("conveyHeader", value)
+	}
+
+	o := &Options{
+		DeviceNameSources: []Source{QuerySource("id")},
+		ConveySources:     []Source{QuerySource("convey")},
+	}
+
+	value, _ := o.deviceNameSource()(request)
+	assert.Equal("fromQuery", value)
+
+	value, _ = o.conveySource()(request)
+	assert.Equal("conveyQuery", value)
+}
+
+func TestManagerConnectSources(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		options = &Options{
+			DeviceNameSources: []Source{HeaderSource(DefaultDeviceNameHeader), PathSegmentSource(-1)},
+			ConveySources:     []Source{QuerySource("convey")},
+		}
+
+		connectionFactory = new(mockConnectionFactory)
+		manager           = NewManager(options, connectionFactory)
+		expectedError     = &url.Error{Op: "expected"}
+	)
+
+	// missing device name
+	response := httptest.NewRecorder()
+	device, err := manager.Connect(response, httptest.NewRequest("GET", "/", nil), nil)
+	assert.Nil(device)
+	if rejection, ok := err.(*Rejection); assert.True(ok) {
+		assert.Equal(RejectBadID, rejection.Reason)
+		assert.Equal(ErrorMissingDeviceName, rejection.Err)
+	}
+
+	assert.Equal(http.StatusBadRequest, response.Code)
+
+	// bad convey from the query
+	response = httptest.NewRecorder()
+	device, err = manager.Connect(response, httptest.NewRequest("GET", "/connect/mac:112233445566?convey=invalid", nil), nil)
+	assert.Nil(device)
+	assert.Error(err)
+	assert.Equal(http.StatusBadRequest, response.Code)
+
+	// the device name from the path is used to get as far as the connection factory
+	response = httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/connect/mac:112233445566", nil)
+	connectionFactory.On("NewConnection", response, request, http.Header(nil)).Once().Return(nil, expectedError)
+	device, err = manager.Connect(response, request, nil)
+	assert.Nil(device)
+	if rejection, ok := err.(*Rejection); assert.True(ok) {
+		assert.Equal(expectedError, rejection.Err)
+	}
+
+	connectionFactory.AssertExpectations(t)
+}
@@ -0,0 +1,125 @@
+package device
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/spool"
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// QueueDrainHandler receives the requests that were still queued for a device when it disconnected, in the
+// order they would have been sent.  This includes any request whose write failed as the device disconnected,
+// so a message may have reached the device anyway.  The handler runs on the device's write goroutine, which
+// cannot finish cleaning up the device until the handler returns.
+type QueueDrainHandler func(Interface, []*Request)
+
+// spoolRecord produces the Msgpack record that spools a request
+func spoolRecord(request *Request) ([]byte, error) {
+	if request.Format == wrp.Msgpack && len(request.Contents) > 0 {
+		return request.Contents, nil
+	}
+
+	var record []byte
+	err := wrp.NewEncoderBytes(&record, wrp.Msgpack).Encode(request.Message)
+	return record, err
+}
+
+// SpoolQueueDrainHandler produces a QueueDrainHandler that appends undelivered requests to a spool.  Configure
+// the same spool as Options.Spool, so that the requests are replayed when the device reconnects.  As with
+// requests routed to disconnected devices, requests that started transactions are not spooled, since their
+// senders have already been told the device disconnected.
+func SpoolQueueDrainHandler(s spool.Interface, logger logging.Logger) QueueDrainHandler {
+	if logger == nil {
+		logger = logging.DefaultLogger()
+	}
+
+	return func(d Interface, undelivered []*Request) {
+		spooled := 0
+		for _, request := range undelivered {
+			if len(request.Message.TransactionKey()) > 0 {
+				continue
+			}
+
+			record, err := spoolRecord(request)
+			if err != nil {
+				logger.Error("Unable to encode message for spooling to [%s]: %s", d.ID(), err)
+				continue
+			}
+
+			if err := s.Append(string(d.ID()), record); err != nil {
+				logger.Error("Unable to spool message for [%s]: %s", d.ID(), err)
+				continue
+			}
+
+			spooled++
+		}
+
+		if spooled > 0 {
+			logger.Info("Spooled %d undelivered messages for [%s]", spooled, d.ID())
+		}
+	}
+}
+
+// spoolRequest persists a request for a device that is not currently connected, so that it can
+// be replayed when the device reconnects.  Only requests that do not start a transaction are spooled,
+// since nobody would be waiting for the response.  This method returns ErrorMessageSpooled if the
+// request was spooled, and ErrorDeviceNotFound if it was not.
+func (m *manager) spoolRequest(destination ID, request *Request) error {
+	if m.messageSpool == nil || len(request.Message.TransactionKey()) > 0 {
+		return ErrorDeviceNotFound
+	}
+
+	record, err := spoolRecord(request)
+	if err != nil {
+		m.logger.Error("Unable to encode message for spooling to [%s]: %s", destination, err)
+		return ErrorDeviceNotFound
+	}
+
+	if err := m.messageSpool.Append(string(destination), record); err != nil {
+		m.logger.Error("Unable to spool message for [%s]: %s", destination, err)
+		return ErrorDeviceNotFound
+	}
+
+	return ErrorMessageSpooled
+}
+
+// replaySpool sends any spooled messages to a newly connected device, oldest first.  If the device
+// disconnects before all messages are sent, the remaining messages are spooled again.
+func (m *manager) replaySpool(d *device) {
+	records, err := m.messageSpool.Drain(string(d.id))
+	if err != nil {
+		d.logger.Error("Unable to read all spooled messages for [%s]: %s", d.id, err)
+	}
+
+	if len(records) == 0 {
+		return
+	}
+
+	d.logger.Info("Replaying %d spooled messages to [%s]", len(records), d.id)
+	for i, record := range records {
+		message := new(wrp.Message)
+		if err := wrp.NewDecoderBytes(record, wrp.Msgpack).Decode(message); err != nil {
+			d.logger.Error("Discarding corrupt spooled message for [%s]: %s", d.id, err)
+			continue
+		}
+
+		_, err := d.Send(
+			(&Request{
+				Message:  message,
+				Format:   wrp.Msgpack,
+				Contents: record,
+			}).WithContext(context.Background()),
+		)
+
+		if err != nil {
+			d.logger.Error("Unable to replay spooled messages to [%s]: %s", d.id, err)
+			for _, remaining := range records[i:] {
+				if err := m.messageSpool.Append(string(d.id), remaining); err != nil {
+					d.logger.Error("Unable to spool message for [%s]: %s", d.id, err)
+				}
+			}
+
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package device
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/spool"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManagerSpool(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	directory, err := ioutil.TempDir("", "device-spool")
+	require.NoError(err)
+	defer os.RemoveAll(directory)
+
+	messageSpool, err := spool.NewDisk(&spool.Options{Directory: directory, Logger: logging.TestLogger(t)})
+	require.NoError(err)
+
+	var (
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			Spool:  messageSpool,
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == Disconnect {
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	// transactions are never spooled, since nothing would receive the response
+	response, err := manager.Route(&Request{
+		Message: &wrp.SimpleRequestResponse{Destination: "mac:112233445566/service", TransactionUUID: "transaction"},
+	})
+
+	assert.Nil(response)
+	assert.Equal(ErrorDeviceNotFound, err)
+
+	for _, payload := range []string{"first", "second"} {
+		response, err := manager.Route(&Request{
+			Message: &wrp.SimpleEvent{Destination: "mac:112233445566", Payload: []byte(payload)},
+			Format:  wrp.JSON,
+		})
+
+		assert.Nil(response)
+		assert.Equal(ErrorMessageSpooled, err)
+	}
+
+	assert.Equal(2, messageSpool.Len())
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+
+	for _, expected := range []string{"first", "second"} {
+		frame, err := c.NextReader()
+		require.NoError(err)
+
+		var message wrp.Message
+		require.NoError(wrp.NewDecoder(frame, wrp.Msgpack).Decode(&message))
+		assert.Equal(wrp.SimpleEventMessageType, message.Type)
+		assert.Equal("mac:112233445566", message.Destination)
+		assert.Equal(expected, string(message.Payload))
+	}
+
+	c.Close()
+	<-disconnected
+	assert.Equal(0, messageSpool.Len())
+}
+
+func TestManagerQueueDrainHandler(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		drained = make(chan []*Request, 1)
+		m       = NewManager(
+			&Options{
+				Logger: logging.TestLogger(t),
+				QueueDrainHandler: func(d Interface, undelivered []*Request) {
+					assert.Equal(ID("mac:112233445566"), d.ID())
+					drained <- undelivered
+				},
+			},
+			nil,
+		).(*manager)
+
+		d        = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+		requests = []*Request{
+			{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "low"}},
+			{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "critical"}, Priority: CriticalPriority},
+			{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "high", QualityOfService: wrp.QOSHighValue}},
+		}
+	)
+
+	for _, request := range requests {
+		d.messages[request.EffectivePriority()] <- &envelope{request: request, complete: make(chan error, 1), enqueuedAt: time.Now()}
+	}
+
+	// the first message fails to write, and the rest are still queued when the device disconnects
+	m.writePump(d, &failingWriter{err: errors.New("expected")}, new(sync.Once))
+
+	select {
+	case undelivered := <-drained:
+		require.Len(undelivered, 3)
+		assert.True(requests[1] == undelivered[0])
+		assert.True(requests[2] == undelivered[1])
+		assert.True(requests[0] == undelivered[2])
+	default:
+		assert.Fail("The queue drain handler was not called")
+	}
+
+	// nothing was queued, so the handler is not called
+	d = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+	d.RequestClose()
+	m.writePump(d, &frameRecorder{frames: make(chan []byte, 1)}, new(sync.Once))
+	assert.Empty(drained)
+}
+
+func TestSpoolQueueDrainHandler(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	directory, err := ioutil.TempDir("", "device-spool")
+	require.NoError(err)
+	defer os.RemoveAll(directory)
+
+	messageSpool, err := spool.NewDisk(&spool.Options{Directory: directory, Logger: logging.TestLogger(t)})
+	require.NoError(err)
+
+	var (
+		d       = newDevice(ID("mac:112233445566"), Key("test"), nil, 1)
+		handler = SpoolQueueDrainHandler(messageSpool, logging.TestLogger(t))
+
+		encoded []byte
+		first   = &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566", Payload: []byte("first")}
+	)
+
+	require.NoError(wrp.NewEncoderBytes(&encoded, wrp.Msgpack).Encode(first))
+	handler(d, []*Request{
+		{Message: first, Format: wrp.Msgpack, Contents: encoded},
+		{Message: &wrp.SimpleRequestResponse{Destination: "mac:112233445566/service", TransactionUUID: "transaction"}},
+		{Message: &wrp.SimpleEvent{Destination: "mac:112233445566", Payload: []byte("second")}, Format: wrp.JSON},
+	})
+
+	records, err := messageSpool.Drain("mac:112233445566")
+	require.NoError(err)
+	require.Len(records, 2)
+	assert.Equal(encoded, records[0])
+
+	for i, expected := range []string{"first", "second"} {
+		var message wrp.Message
+		require.NoError(wrp.NewDecoderBytes(records[i], wrp.Msgpack).Decode(&message))
+		assert.Equal(wrp.SimpleEventMessageType, message.Type)
+		assert.Equal(expected, string(message.Payload))
+	}
+
+	// a nil logger is allowed
+	assert.NotNil(SpoolQueueDrainHandler(messageSpool, nil))
+}
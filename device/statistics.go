@@ -0,0 +1,193 @@
+package device
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// rttSmoothing is the weight, as a reciprocal, that each new round-trip time has in a device's
+	// smoothed RTT.  This is the same smoothing that TCP applies to its own round-trip estimate.
+	rttSmoothing = 8
+)
+
+// Statistics is a snapshot of the traffic counters of a single device
+type Statistics struct {
+	// MessagesSent is the number of frames successfully written to the device
+	MessagesSent int64 `json:"messagesSent"`
+
+	// MessagesReceived is the number of frames read from the device, including frames that were
+	// later dropped, e.g. because they could not be decoded
+	MessagesReceived int64 `json:"messagesReceived"`
+
+	// BytesSent is the total size of the frames successfully written to the device
+	BytesSent int64 `json:"bytesSent"`
+
+	// BytesReceived is the total size of the frames read from the device
+	BytesReceived int64 `json:"bytesReceived"`
+
+	// SendErrors is the number of frames that could not be written to the device
+	SendErrors int64 `json:"sendErrors"`
+
+	// LastRead is when the most recent frame was read from the device.  It is the zero time
+	// if no frame has been read.
+	LastRead time.Time `json:"lastRead"`
+
+	// LastWrite is when the most recent frame was written to the device.  It is the zero time
+	// if no frame has been written.
+	LastWrite time.Time `json:"lastWrite"`
+
+	// RTT is the rolling estimate of the round-trip time of pings sent to the device, and LastRTT is the
+	// most recent round-trip time measured.  Both are zero until the device answers a ping.
+	RTT     time.Duration `json:"rtt"`
+	LastRTT time.Duration `json:"lastRTT"`
+}
+
+// statisticsCounters has the same fields as Statistics, without its JSON methods
+type statisticsCounters Statistics
+
+// statisticsJSON is the JSON representation of Statistics, which holds the round-trip times as
+// duration strings, e.g. "85ms", like the other durations reported by the device handlers
+type statisticsJSON struct {
+	statisticsCounters
+	RTT     string `json:"rtt"`
+	LastRTT string `json:"lastRTT"`
+}
+
+// MarshalJSON encodes these statistics as a statisticsJSON
+func (s Statistics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(statisticsJSON{
+		statisticsCounters: statisticsCounters(s),
+		RTT:                s.RTT.String(),
+		LastRTT:            s.LastRTT.String(),
+	})
+}
+
+// UnmarshalJSON decodes the output of MarshalJSON
+func (s *Statistics) UnmarshalJSON(data []byte) error {
+	var decoded statisticsJSON
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	*s = Statistics(decoded.statisticsCounters)
+	if s.RTT, err = parseRTT(decoded.RTT); err == nil {
+		s.LastRTT, err = parseRTT(decoded.LastRTT)
+	}
+
+	return err
+}
+
+// parseRTT parses a round-trip time from its JSON representation, where an absent value means zero
+func parseRTT(value string) (time.Duration, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// statistics holds the live counters behind a device's Statistics.  The read and write pumps update
+// these counters concurrently, so every field is accessed atomically.  The timestamps are stored as
+// UnixNano values, with 0 meaning that no frame has been read or written.  Likewise, pingSent is 0 when
+// no ping is awaiting its pong.
+type statistics struct {
+	messagesSent     int64
+	messagesReceived int64
+	bytesSent        int64
+	bytesReceived    int64
+	sendErrors       int64
+	lastRead         int64
+	lastWrite        int64
+	pingSent         int64
+	rtt              int64
+	lastRTT          int64
+}
+
+// read records a frame read from the device
+func (s *statistics) read(size int, at time.Time) {
+	atomic.AddInt64(&s.messagesReceived, 1)
+	atomic.AddInt64(&s.bytesReceived, int64(size))
+	atomic.StoreInt64(&s.lastRead, at.UnixNano())
+}
+
+// wrote records a frame successfully written to the device
+func (s *statistics) wrote(size int, at time.Time) {
+	atomic.AddInt64(&s.messagesSent, 1)
+	atomic.AddInt64(&s.bytesSent, int64(size))
+	atomic.StoreInt64(&s.lastWrite, at.UnixNano())
+}
+
+// sendFailed records a frame that could not be written to the device
+func (s *statistics) sendFailed() {
+	atomic.AddInt64(&s.sendErrors, 1)
+}
+
+// pinged records a ping sent to the device.  A ping sent before the previous one was answered replaces it,
+// so that a lost pong does not inflate the next round-trip time.
+func (s *statistics) pinged(at time.Time) {
+	atomic.StoreInt64(&s.pingSent, at.UnixNano())
+}
+
+// ponged records a pong answering the outstanding ping, returning the round-trip time.  If no ping is
+// outstanding, e.g. for a duplicate or unsolicited pong, this method returns false.  Pongs are only
+// handled by the read pump, so there is never more than one goroutine updating the RTT.
+func (s *statistics) ponged(at time.Time) (time.Duration, bool) {
+	sent := atomic.SwapInt64(&s.pingSent, 0)
+	if sent == 0 {
+		return 0, false
+	}
+
+	rtt := at.UnixNano() - sent
+	if rtt < 0 {
+		rtt = 0
+	}
+
+	smoothed := atomic.LoadInt64(&s.rtt)
+	if smoothed == 0 {
+		smoothed = rtt
+	} else {
+		smoothed += (rtt - smoothed) / rttSmoothing
+	}
+
+	atomic.StoreInt64(&s.lastRTT, rtt)
+	atomic.StoreInt64(&s.rtt, smoothed)
+	return time.Duration(rtt), true
+}
+
+func unixNanoTime(value int64) time.Time {
+	if value == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, value)
+}
+
+func (s *statistics) snapshot() Statistics {
+	return Statistics{
+		MessagesSent:     atomic.LoadInt64(&s.messagesSent),
+		MessagesReceived: atomic.LoadInt64(&s.messagesReceived),
+		BytesSent:        atomic.LoadInt64(&s.bytesSent),
+		BytesReceived:    atomic.LoadInt64(&s.bytesReceived),
+		SendErrors:       atomic.LoadInt64(&s.sendErrors),
+		LastRead:         unixNanoTime(atomic.LoadInt64(&s.lastRead)),
+		LastWrite:        unixNanoTime(atomic.LoadInt64(&s.lastWrite)),
+		RTT:              time.Duration(atomic.LoadInt64(&s.rtt)),
+		LastRTT:          time.Duration(atomic.LoadInt64(&s.lastRTT)),
+	}
+}
+
+// byteCounter is an io.WriteCloser that counts the bytes written through it to a frame
+type byteCounter struct {
+	io.WriteCloser
+	count int
+}
+
+func (bc *byteCounter) Write(data []byte) (int, error) {
+	n, err := bc.WriteCloser.Write(data)
+	bc.count += n
+	return n, err
+}
@@ -0,0 +1,275 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatistics(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		now    = time.Now()
+		s      statistics
+	)
+
+	assert.Equal(Statistics{}, s.snapshot())
+	assert.True(s.snapshot().LastRead.IsZero())
+	assert.True(s.snapshot().LastWrite.IsZero())
+
+	s.read(10, now)
+	s.read(15, now.Add(time.Second))
+	s.wrote(100, now.Add(2*time.Second))
+	s.sendFailed()
+	s.sendFailed()
+
+	actual := s.snapshot()
+	assert.Equal(int64(1), actual.MessagesSent)
+	assert.Equal(int64(2), actual.MessagesReceived)
+	assert.Equal(int64(100), actual.BytesSent)
+	assert.Equal(int64(25), actual.BytesReceived)
+	assert.Equal(int64(2), actual.SendErrors)
+	assert.True(now.Add(time.Second).Equal(actual.LastRead))
+	assert.True(now.Add(2 * time.Second).Equal(actual.LastWrite))
+}
+
+func TestStatisticsRTT(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		now    = time.Now()
+		s      statistics
+	)
+
+	// a pong without an outstanding ping is not measured
+	rtt, ok := s.ponged(now)
+	assert.False(ok)
+	assert.Zero(rtt)
+
+	s.pinged(now)
+	rtt, ok = s.ponged(now.Add(80 * time.Millisecond))
+	assert.True(ok)
+	assert.Equal(80*time.Millisecond, rtt)
+	assert.Equal(80*time.Millisecond, s.snapshot().RTT)
+	assert.Equal(80*time.Millisecond, s.snapshot().LastRTT)
+
+	// a duplicate pong is not measured
+	_, ok = s.ponged(now.Add(time.Second))
+	assert.False(ok)
+
+	// later round-trip times are smoothed
+	s.pinged(now.Add(time.Minute))
+	rtt, ok = s.ponged(now.Add(time.Minute + 160*time.Millisecond))
+	assert.True(ok)
+	assert.Equal(160*time.Millisecond, rtt)
+	assert.Equal(90*time.Millisecond, s.snapshot().RTT)
+	assert.Equal(160*time.Millisecond, s.snapshot().LastRTT)
+
+	// a newer ping replaces one that was never answered
+	s.pinged(now.Add(2 * time.Minute))
+	s.pinged(now.Add(3 * time.Minute))
+	rtt, ok = s.ponged(now.Add(3*time.Minute + 10*time.Millisecond))
+	assert.True(ok)
+	assert.Equal(10*time.Millisecond, rtt)
+	assert.Equal(80*time.Millisecond, s.snapshot().RTT)
+
+	// the clock going backwards produces no negative round-trip time
+	s.pinged(now.Add(time.Hour))
+	rtt, ok = s.ponged(now)
+	assert.True(ok)
+	assert.Zero(rtt)
+}
+
+func TestStatisticsJSON(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		expected = Statistics{
+			MessagesSent: 5,
+			SendErrors:   1,
+			LastRead:     time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+			RTT:          85 * time.Millisecond,
+			LastRTT:      120 * time.Millisecond,
+		}
+	)
+
+	data, err := json.Marshal(expected)
+	require.NoError(err)
+	assert.Contains(string(data), `"rtt":"85ms"`)
+	assert.Contains(string(data), `"lastRTT":"120ms"`)
+
+	var actual Statistics
+	require.NoError(json.Unmarshal(data, &actual))
+	assert.True(expected.LastRead.Equal(actual.LastRead))
+	actual.LastRead = expected.LastRead
+	assert.Equal(expected, actual)
+
+	assert.Error(json.Unmarshal([]byte(`{"rtt": "not a duration"}`), &actual))
+	assert.Error(json.Unmarshal([]byte(`{"rtt": 12}`), &actual))
+}
+
+func TestByteCounter(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		output  = new(recordedFrame)
+		counter = byteCounter{WriteCloser: output}
+	)
+
+	n, err := counter.Write([]byte("hello"))
+	assert.Equal(5, n)
+	assert.NoError(err)
+
+	n, err = io.WriteString(&counter, ", world")
+	assert.Equal(7, n)
+	assert.NoError(err)
+
+	assert.Equal(12, counter.count)
+	assert.Equal("hello, world", output.String())
+}
+
+// failingWriter is a Connection whose frames can never be written
+type failingWriter struct {
+	Connection
+	err error
+}
+
+func (fw *failingWriter) NextWriter() (io.WriteCloser, error) {
+	return nil, fw.err
+}
+
+func (fw *failingWriter) Close() error {
+	return nil
+}
+
+func testManagerWritePumpStatisticsSuccess(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		m          = NewManager(&Options{Logger: logging.TestLogger(t)}, nil).(*manager)
+		d          = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+		connection = &frameRecorder{frames: make(chan []byte, 10)}
+		pumpDone   = make(chan struct{})
+		start      = time.Now()
+	)
+
+	go func() {
+		defer close(pumpDone)
+		m.writePump(d, connection, new(sync.Once))
+	}()
+
+	var expectedBytes int64
+	for _, contents := range [][]byte{[]byte("first"), []byte("second message")} {
+		_, err := d.Send(&Request{Message: &wrp.SimpleEvent{Destination: "event:test"}, Format: d.format, Contents: contents})
+		require.NoError(err)
+
+		select {
+		case frame := <-connection.frames:
+			assert.Equal(contents, frame)
+			expectedBytes += int64(len(frame))
+		case <-time.After(5 * time.Second):
+			require.Fail("No frame was written")
+		}
+	}
+
+	actual := d.Statistics()
+	assert.Equal(int64(2), actual.MessagesSent)
+	assert.Equal(expectedBytes, actual.BytesSent)
+	assert.Zero(actual.SendErrors)
+	assert.False(actual.LastWrite.Before(start))
+	assert.True(actual.LastRead.IsZero())
+
+	d.RequestClose()
+	<-pumpDone
+}
+
+func testManagerWritePumpStatisticsError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		m             = NewManager(&Options{Logger: logging.TestLogger(t)}, nil).(*manager)
+		d             = newDevice(ID("mac:112233445566"), Key("test"), nil, 10)
+		pumpDone      = make(chan struct{})
+	)
+
+	go func() {
+		defer close(pumpDone)
+		m.writePump(d, &failingWriter{err: expectedError}, new(sync.Once))
+	}()
+
+	_, err := d.Send(&Request{Message: &wrp.SimpleEvent{Destination: "event:test"}})
+	assert.Equal(expectedError, err)
+	<-pumpDone
+
+	actual := d.Statistics()
+	assert.Zero(actual.MessagesSent)
+	assert.Zero(actual.BytesSent)
+	assert.Equal(int64(1), actual.SendErrors)
+	assert.True(actual.LastWrite.IsZero())
+}
+
+func TestManagerWritePumpStatistics(t *testing.T) {
+	t.Run("Success", testManagerWritePumpStatisticsSuccess)
+	t.Run("Error", testManagerWritePumpStatisticsError)
+}
+
+func TestManagerReadPumpStatistics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		received     = make(chan struct{}, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived:
+						received <- struct{}{}
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		start                 = time.Now()
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	var frame bytes.Buffer
+	require.NoError(wrp.NewEncoder(&frame, c.Format()).Encode(&wrp.SimpleEvent{Source: "mac:112233445566", Destination: "event:test"}))
+	_, err = c.Write(frame.Bytes())
+	require.NoError(err)
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		require.Fail("No message was received")
+	}
+
+	actual := d.Statistics()
+	assert.Equal(int64(1), actual.MessagesReceived)
+	assert.Equal(int64(frame.Len()), actual.BytesReceived)
+	assert.False(actual.LastRead.Before(start))
+	assert.Zero(actual.MessagesSent)
+
+	c.Close()
+	<-disconnected
+}
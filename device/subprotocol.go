@@ -0,0 +1,131 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+const (
+	// MsgpackSubprotocol is the websocket subprotocol a client offers to exchange Msgpack WRP messages
+	MsgpackSubprotocol = "wrp.msgpack"
+
+	// JSONSubprotocol is the websocket subprotocol a client offers to exchange JSON WRP messages
+	JSONSubprotocol = "wrp.json"
+)
+
+// SubprotocolFormat returns the WRP encoding negotiated by a websocket subprotocol.  Connections that
+// negotiated no subprotocol, or one unrelated to encoding, use Msgpack.  The boolean return
+// indicates whether the subprotocol selected the encoding.
+func SubprotocolFormat(subprotocol string) (wrp.Format, bool) {
+	switch subprotocol {
+	case MsgpackSubprotocol:
+		return wrp.Msgpack, true
+	case JSONSubprotocol:
+		return wrp.JSON, true
+	default:
+		return wrp.Msgpack, false
+	}
+}
+
+// FormatSubprotocol returns the websocket subprotocol that negotiates the given WRP encoding
+func FormatSubprotocol(f wrp.Format) string {
+	if f == wrp.JSON {
+		return JSONSubprotocol
+	}
+
+	return MsgpackSubprotocol
+}
+
+// serverSubprotocols returns the subprotocols a server will accept, in order of preference.
+// The configured subprotocols come first, followed by the encoding subprotocols not already present.
+func serverSubprotocols(configured []string) []string {
+	subprotocols := configured
+	for _, candidate := range []string{MsgpackSubprotocol, JSONSubprotocol} {
+		present := false
+		for _, s := range configured {
+			if s == candidate {
+				present = true
+				break
+			}
+		}
+
+		if !present {
+			subprotocols = append(subprotocols, candidate)
+		}
+	}
+
+	return subprotocols
+}
+
+// Protocol is a versioned device protocol, such as "wrp-2", that a device negotiates as a websocket
+// subprotocol.  The protocol determines how the pumps encode and decode that device's messages, so a new
+// frame format can be rolled out to devices that offer it while devices that do not keep the default.
+type Protocol struct {
+	// Name is the websocket subprotocol that negotiates this protocol
+	Name string
+
+	// Format is the WRP encoding of messages exchanged under this protocol
+	Format wrp.Format
+
+	// FrameType is the websocket frame type used to write and accept messages under this protocol.
+	// If not supplied or invalid, the frame type configured for Format is used.
+	FrameType FrameType
+}
+
+// negotiated is the encoding and websocket message type used by a connection
+type negotiated struct {
+	format      wrp.Format
+	messageType int
+}
+
+// protocols holds the encoding and frame type selected by each subprotocol a connection can negotiate
+type protocols struct {
+	names     []string
+	versioned map[string]negotiated
+	formats   map[wrp.Format]int
+}
+
+func newProtocols(o *Options) *protocols {
+	p := &protocols{
+		versioned: make(map[string]negotiated),
+		formats: map[wrp.Format]int{
+			wrp.Msgpack: o.frameType(wrp.Msgpack),
+			wrp.JSON:    o.frameType(wrp.JSON),
+		},
+	}
+
+	for _, protocol := range o.protocols() {
+		if _, duplicate := p.versioned[protocol.Name]; duplicate || len(protocol.Name) == 0 {
+			continue
+		}
+
+		messageType, err := protocol.FrameType.messageType()
+		if err != nil {
+			messageType = p.formats[protocol.Format]
+		}
+
+		p.names = append(p.names, protocol.Name)
+		p.versioned[protocol.Name] = negotiated{format: protocol.Format, messageType: messageType}
+	}
+
+	return p
+}
+
+// offered returns the configured protocols, in order of preference, followed by the given subprotocols
+func (p *protocols) offered(subprotocols []string) []string {
+	if len(p.names) == 0 {
+		return subprotocols
+	}
+
+	return append(append(make([]string, 0, len(p.names)+len(subprotocols)), p.names...), subprotocols...)
+}
+
+// negotiate returns the encoding and websocket message type for the subprotocol selected during a handshake.
+// Subprotocols other than the configured protocols select an encoding as described by SubprotocolFormat.
+func (p *protocols) negotiate(subprotocol string) negotiated {
+	if n, ok := p.versioned[subprotocol]; ok {
+		return n
+	}
+
+	format, _ := SubprotocolFormat(subprotocol)
+	return negotiated{format: format, messageType: p.formats[format]}
+}
@@ -0,0 +1,213 @@
+package device
+
+import (
+	"bytes"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSubprotocolFormat(t *testing.T) {
+	testData := []struct {
+		subprotocol    string
+		expectedFormat wrp.Format
+		expectedOK     bool
+	}{
+		{MsgpackSubprotocol, wrp.Msgpack, true},
+		{JSONSubprotocol, wrp.JSON, true},
+		{"", wrp.Msgpack, false},
+		{"foobar", wrp.Msgpack, false},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		format, ok := SubprotocolFormat(record.subprotocol)
+		assert.Equal(t, record.expectedFormat, format)
+		assert.Equal(t, record.expectedOK, ok)
+	}
+}
+
+func TestFormatSubprotocol(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(MsgpackSubprotocol, FormatSubprotocol(wrp.Msgpack))
+	assert.Equal(JSONSubprotocol, FormatSubprotocol(wrp.JSON))
+}
+
+func TestServerSubprotocols(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{MsgpackSubprotocol, JSONSubprotocol}, serverSubprotocols(nil))
+	assert.Equal([]string{"foobar", MsgpackSubprotocol, JSONSubprotocol}, serverSubprotocols([]string{"foobar"}))
+	assert.Equal([]string{JSONSubprotocol, MsgpackSubprotocol}, serverSubprotocols([]string{JSONSubprotocol}))
+}
+
+func testSubprotocolNegotiation(t *testing.T, protocols []Protocol, client *Options, expectedProtocol string, expectedFormat wrp.Format) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		connected    = make(chan Interface, 1)
+		received     = make(chan *Event, 1)
+		disconnected = make(chan struct{})
+
+		options = &Options{
+			Logger:    logging.TestLogger(t),
+			Protocols: protocols,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived:
+						received <- &Event{Format: event.Format, Message: event.Message}
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+	)
+
+	manager, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	c, _, err := NewDialer(client, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	defer func() {
+		c.Close()
+		<-disconnected
+	}()
+
+	assert.Equal(expectedFormat, c.Format())
+	assert.Equal(expectedProtocol, c.Subprotocol())
+
+	select {
+	case d := <-connected:
+		assert.Equal(expectedFormat, d.Format())
+		assert.Equal(expectedProtocol, d.Protocol())
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not connect")
+	}
+
+	// device to server
+	var frame []byte
+	require.NoError(
+		wrp.NewEncoderBytes(&frame, expectedFormat).Encode(
+			&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Destination: "upstream"},
+		),
+	)
+
+	_, err = c.Write(frame)
+	require.NoError(err)
+
+	select {
+	case event := <-received:
+		assert.Equal(expectedFormat, event.Format)
+		assert.Equal("upstream", event.Message.To())
+	case <-time.After(5 * time.Second):
+		require.Fail("No message was received")
+	}
+
+	// server to device
+	go manager.Route(&Request{
+		Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "test", Destination: "mac:112233445566"},
+		Format:  wrp.Msgpack,
+	})
+
+	var (
+		buffer  bytes.Buffer
+		message wrp.Message
+	)
+
+	frameRead, err := c.Read(&buffer)
+	require.NoError(err)
+	require.True(frameRead)
+	require.NoError(wrp.NewDecoderBytes(buffer.Bytes(), expectedFormat).Decode(&message))
+	assert.Equal("mac:112233445566", message.Destination)
+}
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	var (
+		versioned = []Protocol{{Name: "wrp-2", Format: wrp.JSON, FrameType: BinaryFrame}, {Name: "wrp-1", Format: wrp.Msgpack}}
+		v1        = []Protocol{{Name: "wrp-1", Format: wrp.Msgpack}}
+	)
+
+	t.Run("Default", func(t *testing.T) { testSubprotocolNegotiation(t, nil, nil, "", wrp.Msgpack) })
+	t.Run("Msgpack", func(t *testing.T) {
+		testSubprotocolNegotiation(t, nil, &Options{Subprotocols: []string{MsgpackSubprotocol}}, MsgpackSubprotocol, wrp.Msgpack)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		testSubprotocolNegotiation(t, nil, &Options{Subprotocols: []string{JSONSubprotocol}}, JSONSubprotocol, wrp.JSON)
+	})
+
+	t.Run("Preference", func(t *testing.T) {
+		testSubprotocolNegotiation(t, nil, &Options{Subprotocols: []string{"foobar", JSONSubprotocol, MsgpackSubprotocol}}, MsgpackSubprotocol, wrp.Msgpack)
+	})
+
+	t.Run("Versioned", func(t *testing.T) {
+		testSubprotocolNegotiation(t, versioned, &Options{Protocols: versioned}, "wrp-2", wrp.JSON)
+	})
+
+	t.Run("OlderVersion", func(t *testing.T) {
+		testSubprotocolNegotiation(t, versioned, &Options{Protocols: v1}, "wrp-1", wrp.Msgpack)
+	})
+
+	t.Run("UnversionedDevice", func(t *testing.T) {
+		testSubprotocolNegotiation(t, versioned, &Options{Subprotocols: []string{JSONSubprotocol}}, JSONSubprotocol, wrp.JSON)
+	})
+
+	t.Run("UnversionedServer", func(t *testing.T) {
+		testSubprotocolNegotiation(t, nil, &Options{Protocols: versioned}, "", wrp.Msgpack)
+	})
+}
+
+func TestOptionsProtocols(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Nil(o.protocols())
+	}
+
+	configured := []Protocol{{Name: "wrp-2", Format: wrp.JSON}}
+	o := &Options{Protocols: configured}
+	assert.Equal(configured, o.protocols())
+
+	o.protocols()[0].Name = "modified"
+	assert.Equal("wrp-2", o.Protocols[0].Name)
+}
+
+func TestProtocols(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		p      = newProtocols(&Options{
+			JSONFrameType: BinaryFrame,
+			Protocols: []Protocol{
+				{Name: "wrp-3", Format: wrp.Msgpack, FrameType: TextFrame},
+				{Name: ""},
+				{Name: "wrp-2", Format: wrp.JSON, FrameType: "invalid"},
+				{Name: "wrp-3", Format: wrp.JSON},
+			},
+		})
+	)
+
+	assert.Equal([]string{"wrp-3", "wrp-2", JSONSubprotocol}, p.offered([]string{JSONSubprotocol}))
+	assert.Equal([]string{JSONSubprotocol}, newProtocols(nil).offered([]string{JSONSubprotocol}))
+
+	testData := []struct {
+		subprotocol string
+		expected    negotiated
+	}{
+		{"wrp-3", negotiated{format: wrp.Msgpack, messageType: websocket.TextMessage}},
+		{"wrp-2", negotiated{format: wrp.JSON, messageType: websocket.BinaryMessage}},
+		{JSONSubprotocol, negotiated{format: wrp.JSON, messageType: websocket.BinaryMessage}},
+		{MsgpackSubprotocol, negotiated{format: wrp.Msgpack, messageType: websocket.BinaryMessage}},
+		{"", negotiated{format: wrp.Msgpack, messageType: websocket.BinaryMessage}},
+		{"wrp-4", negotiated{format: wrp.Msgpack, messageType: websocket.BinaryMessage}},
+	}
+
+	for _, record := range testData {
+		assert.Equal(record.expected, p.negotiate(record.subprotocol), "subprotocol: %s", record.subprotocol)
+	}
+}
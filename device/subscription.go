@@ -0,0 +1,264 @@
+package device
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReplayRate is the number of replayed Connect events sent per second when a Replay
+	// does not specify a rate
+	DefaultReplayRate = 1000
+)
+
+// Replay configures the synthetic Connect events sent to a newly subscribed Listener, one for
+// each device that is connected at the time of the subscription
+type Replay struct {
+	// Rate is the maximum number of replayed events sent per second.  Replayed events are paced,
+	// so that subscribing on a heavily loaded server does not produce a burst of events.
+	// If not positive, DefaultReplayRate is used.
+	Rate int
+
+	// Done is an optional function invoked once every connected device has been replayed,
+	// or the subscription was cancelled first.  It is passed the number of events replayed.
+	Done func(int)
+}
+
+func (r *Replay) interval() time.Duration {
+	if r != nil && r.Rate > 0 {
+		return time.Second / time.Duration(r.Rate)
+	}
+
+	return time.Second / DefaultReplayRate
+}
+
+// Subscriber is the strategy interface for attaching Listeners to a Manager's event feed after
+// the Manager has been created.  Listeners supplied via Options receive events for the lifetime of the Manager.
+type Subscriber interface {
+	// Subscribe adds a Listener which receives every subsequent device event.  If replay is non-nil,
+	// the Listener is also sent a Connect event, with Replayed set, for each device connected at the time
+	// of the call.  Replayed events are delivered from another goroutine, interleaved with live events, and a
+	// device that disconnects before its turn in the replay is skipped.
+	//
+	// The returned function cancels the subscription, including any replay still in progress.  It is idempotent.
+	Subscribe(listener Listener, replay *Replay) func()
+
+	// SubscribeChannel sends a copy of each subsequent device event to a channel.  If any types are supplied,
+	// only events of those types are sent.  Each copy has its own Contents, so events may be retained by the
+	// receiver, but the Message must still be treated as read-only.
+	//
+	// Events are never allowed to block the Manager.  When the channel is full, the event is dropped and
+	// counted by the EventDroppedCount metric, so the channel should be buffered.  The returned function
+	// cancels the subscription.  It is idempotent, and the channel is never closed.
+	SubscribeChannel(events chan<- Event, types ...EventType) func()
+}
+
+// subscription is a dynamically attached Listener.  During a replay, it serializes replayed and
+// live events so that each device in the snapshot gets exactly one Connect event, and so that no
+// replayed Connect can follow that device's Disconnect.
+type subscription struct {
+	listener Listener
+
+	// delivery serializes calls to the listener.  It is never taken by cancel, so a listener
+	// may cancel its own subscription.
+	delivery sync.Mutex
+
+	// lock guards the state below, and is never held while the listener runs
+	lock sync.Mutex
+
+	// snapshot holds the devices connected at the time of the subscription, mapped onto whether
+	// a Connect event, replayed or live, has been delivered for that device
+	snapshot  map[Interface]bool
+	cancelled bool
+	done      chan struct{}
+}
+
+// deliver sends a live event to this subscription's listener
+func (s *subscription) deliver(e *Event) {
+	s.delivery.Lock()
+	defer s.delivery.Unlock()
+
+	if s.acceptLive(e) {
+		s.listener(e)
+	}
+}
+
+// acceptLive updates the snapshot for a live event, returning true if the event should be delivered
+func (s *subscription) acceptLive(e *Event) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cancelled {
+		return false
+	}
+
+	if delivered, ok := s.snapshot[e.Device]; ok {
+		switch e.Type {
+		case Connect:
+			// a device is added to the registry before its Connect event is dispatched, so a device
+			// in the snapshot may still produce a live Connect event
+			s.snapshot[e.Device] = true
+			if delivered {
+				delete(s.snapshot, e.Device)
+				return false
+			}
+
+		case Disconnect:
+			delete(s.snapshot, e.Device)
+		}
+	}
+
+	return true
+}
+
+// replayOne sends a synthetic Connect event for a device, unless the device has closed or its
+// Connect event has already been delivered
+func (s *subscription) replayOne(d *device) bool {
+	s.delivery.Lock()
+	defer s.delivery.Unlock()
+
+	if !s.acceptReplay(d) {
+		return false
+	}
+
+	s.listener(&Event{Type: Connect, Device: d, Replayed: true})
+	return true
+}
+
+// acceptReplay updates the snapshot for a replayed Connect event, returning true if the event should be delivered
+func (s *subscription) acceptReplay(d *device) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if delivered, ok := s.snapshot[d]; s.cancelled || !ok || delivered || d.Closed() {
+		return false
+	}
+
+	s.snapshot[d] = true
+	return true
+}
+
+// replay paces synthetic Connect events for a snapshot of connected devices
+func (s *subscription) replay(devices []*device, replay *Replay) {
+	count := 0
+	defer func() {
+		if replay.Done != nil {
+			replay.Done(count)
+		}
+	}()
+
+	ticker := time.NewTicker(replay.interval())
+	defer ticker.Stop()
+
+	for i, d := range devices {
+		if i > 0 {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+		}
+
+		if s.replayOne(d) {
+			count++
+		}
+	}
+}
+
+func (s *subscription) cancel() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.cancelled {
+		s.cancelled = true
+		close(s.done)
+	}
+}
+
+func (m *manager) Subscribe(listener Listener, replay *Replay) func() {
+	s := &subscription{
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+
+	var devices []*device
+	// freezing the registry prevents devices from being added while the snapshot is taken, so every
+	// device is either part of the snapshot or has its Connect event dispatched to this subscription
+	m.registry.whenFrozen(func(registered []*device) {
+		if replay != nil {
+			devices = registered
+			s.snapshot = make(map[Interface]bool, len(registered))
+			for _, d := range registered {
+				s.snapshot[d] = false
+			}
+		}
+
+		m.subscriptionLock.Lock()
+		subscriptions := make([]*subscription, len(m.subscriptions), len(m.subscriptions)+1)
+		copy(subscriptions, m.subscriptions)
+		m.subscriptions = append(subscriptions, s)
+		m.subscriptionLock.Unlock()
+	})
+
+	if replay != nil {
+		go s.replay(devices, replay)
+	}
+
+	return func() {
+		m.unsubscribe(s)
+	}
+}
+
+func (m *manager) SubscribeChannel(events chan<- Event, types ...EventType) func() {
+	var accept map[EventType]bool
+	if len(types) > 0 {
+		accept = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			accept[t] = true
+		}
+	}
+
+	return m.Subscribe(
+		func(e *Event) {
+			if accept != nil && !accept[e.Type] {
+				return
+			}
+
+			copied := *e
+			if e.Contents != nil {
+				copied.Contents = append([]byte(nil), e.Contents...)
+			}
+
+			select {
+			case events <- copied:
+			default:
+				m.metrics.eventDropped(e.Type)
+			}
+		},
+		nil,
+	)
+}
+
+// unsubscribe removes a subscription from this manager's event feed
+func (m *manager) unsubscribe(s *subscription) {
+	s.cancel()
+
+	m.subscriptionLock.Lock()
+	defer m.subscriptionLock.Unlock()
+
+	subscriptions := make([]*subscription, 0, len(m.subscriptions))
+	for _, candidate := range m.subscriptions {
+		if candidate != s {
+			subscriptions = append(subscriptions, candidate)
+		}
+	}
+
+	m.subscriptions = subscriptions
+}
+
+// currentSubscriptions returns the subscriptions that should receive a live event
+func (m *manager) currentSubscriptions() []*subscription {
+	m.subscriptionLock.RLock()
+	defer m.subscriptionLock.RUnlock()
+	return m.subscriptions
+}
@@ -0,0 +1,383 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReplayInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Second/DefaultReplayRate, (*Replay)(nil).interval())
+	assert.Equal(time.Second/DefaultReplayRate, new(Replay).interval())
+	assert.Equal(time.Second/DefaultReplayRate, (&Replay{Rate: -1}).interval())
+	assert.Equal(100*time.Millisecond, (&Replay{Rate: 10}).interval())
+}
+
+func TestSubscriptionReplayOne(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		replayed = newDevice(ID("mac:112233445566"), Key("1"), nil, 1)
+		live     = newDevice(ID("mac:112233445567"), Key("2"), nil, 1)
+		closed   = newDevice(ID("mac:112233445568"), Key("3"), nil, 1)
+		unknown  = newDevice(ID("mac:112233445569"), Key("4"), nil, 1)
+
+		events []Event
+		s      = &subscription{
+			listener: func(e *Event) { events = append(events, *e) },
+			snapshot: map[Interface]bool{replayed: false, live: false, closed: false},
+			done:     make(chan struct{}),
+		}
+	)
+
+	closed.RequestClose()
+
+	assert.True(s.replayOne(replayed))
+	assert.False(s.replayOne(replayed))
+
+	// a live Connect for a replayed device is a duplicate
+	s.deliver(&Event{Type: Connect, Device: replayed})
+
+	// a live Connect before the device's turn in the replay suppresses the replay
+	s.deliver(&Event{Type: Connect, Device: live})
+	assert.False(s.replayOne(live))
+
+	assert.False(s.replayOne(closed))
+	assert.False(s.replayOne(unknown))
+
+	if assert.Len(events, 2) {
+		assert.Equal(Connect, events[0].Type)
+		assert.Equal(replayed, events[0].Device)
+		assert.True(events[0].Replayed)
+
+		assert.Equal(Connect, events[1].Type)
+		assert.Equal(live, events[1].Device)
+		assert.False(events[1].Replayed)
+	}
+
+	// a Disconnect removes the device from the snapshot
+	s.deliver(&Event{Type: Disconnect, Device: live})
+	assert.Len(events, 3)
+	assert.NotContains(s.snapshot, live)
+
+	s.cancel()
+	s.cancel()
+	s.deliver(&Event{Type: Disconnect, Device: replayed})
+	assert.Len(events, 3)
+}
+
+func TestSubscriptionReplayOneAfterDisconnect(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(ID("mac:112233445566"), Key("1"), nil, 1)
+
+		events []Event
+		s      = &subscription{
+			listener: func(e *Event) { events = append(events, *e) },
+			snapshot: map[Interface]bool{d: false},
+			done:     make(chan struct{}),
+		}
+	)
+
+	s.deliver(&Event{Type: Disconnect, Device: d})
+	assert.False(s.replayOne(d))
+	if assert.Len(events, 1) {
+		assert.Equal(Disconnect, events[0].Type)
+	}
+}
+
+func TestSubscriptionCancelFromListener(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		replayed = newDevice(ID("mac:112233445566"), Key("1"), nil, 1)
+		live     = newDevice(ID("mac:112233445567"), Key("2"), nil, 1)
+
+		events []Event
+		s      *subscription
+	)
+
+	s = &subscription{
+		listener: func(e *Event) {
+			events = append(events, *e)
+			s.cancel()
+		},
+		snapshot: map[Interface]bool{replayed: false, live: false},
+		done:     make(chan struct{}),
+	}
+
+	assert.True(s.replayOne(replayed))
+	assert.False(s.replayOne(live))
+	s.deliver(&Event{Type: Connect, Device: live})
+
+	if assert.Len(events, 1) {
+		assert.Equal(replayed, events[0].Device)
+		assert.True(events[0].Replayed)
+	}
+}
+
+func TestManagerSubscribeCancelFromListener(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 10)
+		disconnected = make(chan Interface, 10)
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = NewDialer(options, nil)
+
+		events      = make(chan Event, 10)
+		unsubscribe = make(chan func(), 1)
+	)
+
+	defer server.Close()
+
+	// the listener cancels its own subscription from the event dispatch path
+	unsubscribe <- manager.Subscribe(
+		func(e *Event) {
+			events <- *e
+			cancel := <-unsubscribe
+			cancel()
+			unsubscribe <- cancel
+		},
+		nil,
+	)
+
+	connection, _, err := dialer.Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		require.Fail("The Connect event was not dispatched")
+	}
+
+	connection.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		require.Fail("The Disconnect event was not dispatched")
+	}
+
+	if assert.Len(events, 1) {
+		e := <-events
+		assert.Equal(Connect, e.Type)
+		assert.Equal(ID("mac:112233445566"), e.Device.ID())
+	}
+}
+
+func TestManagerSubscribe(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 10)
+		disconnected = make(chan Interface, 10)
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = NewDialer(options, nil)
+
+		ids = []ID{ID("mac:112233445566"), ID("mac:112233445567"), ID("mac:112233445568")}
+	)
+
+	defer server.Close()
+
+	var connections []Connection
+	for _, id := range ids {
+		connection, _, err := dialer.Dial(connectURL, id, nil, nil)
+		require.NoError(err)
+		connections = append(connections, connection)
+		<-connected
+	}
+
+	var (
+		events   = make(chan Event, 10)
+		replayed = make(chan int, 1)
+
+		unsubscribe = manager.Subscribe(
+			func(e *Event) { events <- *e },
+			&Replay{Rate: 100, Done: func(count int) { replayed <- count }},
+		)
+	)
+
+	select {
+	case count := <-replayed:
+		assert.Equal(len(ids), count)
+	case <-time.After(5 * time.Second):
+		assert.Fail("The replay did not complete")
+	}
+
+	replayedIDs := make(map[ID]bool, len(ids))
+	for i := 0; i < len(ids); i++ {
+		e := <-events
+		assert.Equal(Connect, e.Type)
+		assert.True(e.Replayed)
+		replayedIDs[e.Device.ID()] = true
+	}
+
+	assert.Len(replayedIDs, len(ids))
+	for _, id := range ids {
+		assert.True(replayedIDs[id])
+	}
+
+	// subsequent events are live
+	connection, _, err := dialer.Dial(connectURL, ID("mac:112233445569"), nil, nil)
+	require.NoError(err)
+	connections = append(connections, connection)
+	<-connected
+
+	e := <-events
+	assert.Equal(Connect, e.Type)
+	assert.False(e.Replayed)
+	assert.Equal(ID("mac:112233445569"), e.Device.ID())
+
+	unsubscribe()
+	unsubscribe()
+
+	for _, connection := range connections {
+		connection.Close()
+		<-disconnected
+	}
+
+	assert.Empty(events)
+}
+
+func TestManagerSubscribeWithoutReplay(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 10)
+		disconnected = make(chan Interface, 10)
+		options      = &Options{
+			Logger: logging.TestLogger(t),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = NewDialer(options, nil)
+	)
+
+	defer server.Close()
+
+	first, _, err := dialer.Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	<-connected
+
+	events := make(chan Event, 10)
+	unsubscribe := manager.Subscribe(func(e *Event) { events <- *e }, nil)
+	defer unsubscribe()
+
+	first.Close()
+	<-disconnected
+
+	e := <-events
+	assert.Equal(Disconnect, e.Type)
+	assert.False(e.Replayed)
+	assert.Equal(ID("mac:112233445566"), e.Device.ID())
+	assert.Empty(events)
+}
+
+func TestManagerSubscribeChannel(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		m = NewManager(&Options{Logger: logging.TestLogger(t), Metrics: registry}, new(mockConnectionFactory)).(*manager)
+		d = newDevice(ID("mac:112233445566"), Key("1"), nil, 1)
+
+		all         = make(chan Event, 10)
+		filtered    = make(chan Event, 10)
+		full        = make(chan Event)
+		unsubscribe = []func(){
+			m.SubscribeChannel(all),
+			m.SubscribeChannel(filtered, Connect, Disconnect),
+			m.SubscribeChannel(full, Pong),
+		}
+
+		contents = []byte("contents")
+		event    = Event{Type: MessageReceived, Device: d, Contents: contents}
+	)
+
+	m.dispatch(&Event{Type: Connect, Device: d})
+	m.dispatch(&event)
+	m.dispatch(&Event{Type: Pong, Device: d, Data: "pong"})
+
+	// the infrastructure is free to reuse an event's contents once dispatched
+	copy(contents, "modified")
+
+	e := <-all
+	assert.Equal(Connect, e.Type)
+	assert.Equal(d, e.Device)
+
+	e = <-all
+	assert.Equal(MessageReceived, e.Type)
+	assert.Equal([]byte("contents"), e.Contents)
+
+	e = <-all
+	assert.Equal(Pong, e.Type)
+	assert.Equal("pong", e.Data)
+
+	assert.Equal(Connect, (<-filtered).Type)
+	assert.Empty(filtered)
+
+	for _, f := range unsubscribe {
+		f()
+		f()
+	}
+
+	m.dispatch(&Event{Type: Disconnect, Device: d})
+	assert.Empty(all)
+	assert.Empty(filtered)
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(response.Body.String(), EventDroppedCount+`{event="Pong"} 1`)
+}
@@ -0,0 +1,84 @@
+package device
+
+// Tag is a single name/value pair from a device's Tags, which identifies a group of devices such as
+// a cohort of a particular model or firmware version
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// tagMap stores devices keyed by each of their tags, as a secondary index of a registry
+type tagMap map[Tag]map[*device]bool
+
+func (m tagMap) add(d *device) {
+	for name, value := range d.tags {
+		tag := Tag{Name: name, Value: value}
+		if members, ok := m[tag]; ok {
+			members[d] = true
+		} else {
+			m[tag] = map[*device]bool{d: true}
+		}
+	}
+}
+
+func (m tagMap) remove(d *device) {
+	for name, value := range d.tags {
+		tag := Tag{Name: name, Value: value}
+		if members, ok := m[tag]; ok {
+			delete(members, d)
+			if len(members) == 0 {
+				delete(m, tag)
+			}
+		}
+	}
+}
+
+// conveyTags produces tags from the values of the given convey keys.  Each tag has the same name as its
+// convey key, and keys whose values are missing or are not scalars produce no tag.
+func conveyTags(convey Convey, keys []string) Tags {
+	var tags Tags
+	for _, key := range keys {
+		if value := conveyString(convey[key]); len(value) > 0 {
+			if tags == nil {
+				tags = make(Tags, len(keys))
+			}
+
+			tags[key] = value
+		}
+	}
+
+	return tags
+}
+
+// tagDevice combines the tags taken from a device's convey with those attached by the ConnectAuthorizer.
+// Where both produce the same tag, the authorizer wins.
+func (m *manager) tagDevice(convey Convey, authorized Tags) Tags {
+	tags := conveyTags(convey, m.conveyTagKeys)
+	if tags == nil {
+		return authorized
+	}
+
+	for name, value := range authorized {
+		tags[name] = value
+	}
+
+	return tags
+}
+
+func (m *manager) VisitTag(tag Tag, visitor func(Interface)) int {
+	m.logger.Debug("VisitTag(%s=%s)", tag.Name, tag.Value)
+	return m.registry.visitTag(tag, m.wrapVisitor(visitor))
+}
+
+func (m *manager) BroadcastTag(request *Request, tag Tag) (BroadcastResult, error) {
+	if request == nil || request.Message == nil {
+		return BroadcastResult{}, ErrorMissingMessage
+	}
+
+	var targets []*device
+	m.registry.visitTag(tag, func(d *device) {
+		targets = append(targets, d)
+	})
+
+	return m.broadcast(request, targets)
+}
@@ -0,0 +1,270 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOptionsConveyTags(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Empty(o.conveyTags())
+	}
+
+	assert.Equal([]string{ConveyModelKey}, (&Options{ConveyTags: []string{ConveyModelKey}}).conveyTags())
+}
+
+func TestConveyTags(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		convey = Convey{
+			ConveyModelKey:           "TG1682G",
+			ConveyFirmwareVersionKey: "TG1682_3.1",
+			"boot-time":              1503584159,
+			"interfaces":             []interface{}{"erouter0"},
+		}
+	)
+
+	assert.Nil(conveyTags(convey, nil))
+	assert.Nil(conveyTags(convey, []string{"nosuch", "interfaces"}))
+	assert.Nil(conveyTags(nil, []string{ConveyModelKey}))
+	assert.Equal(
+		Tags{ConveyModelKey: "TG1682G", ConveyFirmwareVersionKey: "TG1682_3.1", "boot-time": "1503584159"},
+		conveyTags(convey, []string{ConveyModelKey, ConveyFirmwareVersionKey, "boot-time", "nosuch"}),
+	)
+}
+
+func TestManagerTagDevice(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		convey   = Convey{ConveyModelKey: "TG1682G", ConveyFirmwareVersionKey: "TG1682_3.1"}
+		untagged = NewManager(nil, nil).(*manager)
+		tagging  = NewManager(&Options{ConveyTags: []string{ConveyModelKey, ConveyFirmwareVersionKey}}, nil).(*manager)
+	)
+
+	assert.Nil(untagged.tagDevice(convey, nil))
+	assert.Equal(Tags{"partner": "comcast"}, untagged.tagDevice(convey, Tags{"partner": "comcast"}))
+	assert.Nil(tagging.tagDevice(nil, nil))
+
+	assert.Equal(
+		Tags{ConveyModelKey: "TG1682G", ConveyFirmwareVersionKey: "TG1682_3.1"},
+		tagging.tagDevice(convey, nil),
+	)
+
+	// the authorizer's tags win
+	assert.Equal(
+		Tags{ConveyModelKey: "TG1682G", ConveyFirmwareVersionKey: "override", "partner": "comcast"},
+		tagging.tagDevice(convey, Tags{ConveyFirmwareVersionKey: "override", "partner": "comcast"}),
+	)
+}
+
+// newTaggedDevice creates a test device with the given tags
+func newTaggedDevice(id ID, key Key, tags Tags) *device {
+	d := newDevice(id, key, nil, 1)
+	d.tags = tags
+	return d
+}
+
+// tagCapture returns a visitor that records the devices it visits in this set
+func (s deviceSet) tagCapture() func(*device) {
+	return func(d *device) {
+		s[d] = true
+	}
+}
+
+func TestRegistryVisitTag(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		registry = newRegistry(10)
+
+		cohort  = Tag{Name: ConveyModelKey, Value: "TG1682G"}
+		partner = Tag{Name: "partner", Value: "comcast"}
+
+		first    = newTaggedDevice(ID("mac:112233445566"), Key("first"), Tags{cohort.Name: cohort.Value, partner.Name: partner.Value})
+		second   = newTaggedDevice(ID("mac:112233445566"), Key("second"), Tags{cohort.Name: cohort.Value})
+		third    = newTaggedDevice(ID("mac:665544332211"), Key("third"), Tags{partner.Name: partner.Value})
+		untagged = newTaggedDevice(ID("mac:665544332211"), Key("untagged"), nil)
+	)
+
+	for _, d := range []*device{first, second, third, untagged} {
+		assert.NoError(registry.add(d))
+	}
+
+	visited := deviceSet{}
+	assert.Equal(2, registry.visitTag(cohort, visited.tagCapture()))
+	assert.Equal(deviceSet{first: true, second: true}, visited)
+
+	visited = deviceSet{}
+	assert.Equal(2, registry.visitTag(partner, visited.tagCapture()))
+	assert.Equal(deviceSet{first: true, third: true}, visited)
+
+	assert.Zero(registry.visitTag(Tag{Name: ConveyModelKey, Value: "nosuch"}, visited.tagCapture()))
+
+	// a duplicate key leaves the index alone
+	assert.Error(registry.add(newTaggedDevice(ID("mac:112233445566"), Key("first"), Tags{"duplicate": "true"})))
+	assert.Zero(registry.visitTag(Tag{Name: "duplicate", Value: "true"}, visited.tagCapture()))
+
+	assert.True(registry.removeOne(first))
+	visited = deviceSet{}
+	assert.Equal(1, registry.visitTag(cohort, visited.tagCapture()))
+	assert.Equal(deviceSet{second: true}, visited)
+
+	assert.Len(registry.removeAll(ID("mac:665544332211")), 2)
+	assert.Zero(registry.visitTag(partner, visited.tagCapture()))
+
+	assert.True(registry.removeOne(second))
+	assert.Empty(registry.tags)
+}
+
+func TestShardedRegistryVisitTag(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		registry = newShardedRegistry(100, 4)
+		even     = Tag{Name: "parity", Value: "even"}
+		expected = deviceSet{}
+	)
+
+	for i := 0; i < 100; i++ {
+		parity := "odd"
+		if i%2 == 0 {
+			parity = "even"
+		}
+
+		d := newTaggedDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), Tags{"parity": parity})
+		assert.NoError(registry.add(d))
+		if i%2 == 0 {
+			expected[d] = true
+		}
+	}
+
+	visited := deviceSet{}
+	assert.Equal(50, registry.visitTag(even, visited.tagCapture()))
+	assert.Equal(expected, visited)
+}
+
+func TestManagerBroadcastTag(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		stop    = make(chan struct{})
+		manager = NewManager(&Options{Logger: logging.TestLogger(t)}, new(mockConnectionFactory)).(*manager)
+		cohort  = Tag{Name: ConveyModelKey, Value: "TG1682G"}
+		sent    = make(map[ID]<-chan *Request)
+	)
+
+	defer close(stop)
+
+	result, err := manager.BroadcastTag(nil, cohort)
+	assert.Equal(BroadcastResult{}, result)
+	assert.Equal(ErrorMissingMessage, err)
+
+	// only devices with even IDs belong to the cohort
+	for i := 0; i < 6; i++ {
+		var tags Tags
+		if i%2 == 0 {
+			tags = Tags{cohort.Name: cohort.Value}
+		}
+
+		d := manager.newManagedDevice(IntToMAC(uint64(i)), Key(strconv.Itoa(i)), nil, "")
+		d.tags = tags
+		require.NoError(manager.registry.add(d))
+		sent[d.ID()] = completeSends(d, nil, stop)
+	}
+
+	request := newBroadcastRequest()
+	result, err = manager.BroadcastTag(request, cohort)
+	assert.NoError(err)
+	assert.Equal(BroadcastResult{Attempted: 3, Succeeded: 3}, result)
+	for i := 0; i < 6; i++ {
+		requests := sent[IntToMAC(uint64(i))]
+		if i%2 == 0 {
+			select {
+			case actual := <-requests:
+				assert.True(request.Message == actual.Message)
+			case <-time.After(5 * time.Second):
+				assert.Fail("The request was not sent", "device %d", i)
+			}
+		} else {
+			select {
+			case <-requests:
+				assert.Fail("The request was sent to a device outside the cohort", "device %d", i)
+			default:
+			}
+		}
+	}
+
+	result, err = manager.BroadcastTag(request, Tag{Name: ConveyModelKey, Value: "nosuch"})
+	assert.NoError(err)
+	assert.Equal(BroadcastResult{}, result)
+}
+
+func TestManagerVisitTag(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan Interface, 1)
+		options      = &Options{
+			Logger:     logging.TestLogger(t),
+			ConveyTags: []string{ConveyModelKey},
+			ConnectAuthorizer: ConnectAuthorizerFunc(func(request *ConnectRequest) (Tags, error) {
+				return Tags{"partner": "comcast"}, nil
+			}),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						disconnected <- event.Device
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = NewDialer(options, nil)
+		cohort                      = Tag{Name: ConveyModelKey, Value: "TG1682G"}
+		partner                     = Tag{Name: "partner", Value: "comcast"}
+	)
+
+	defer server.Close()
+
+	c, _, err := dialer.Dial(connectURL, ID("mac:112233445566"), Convey{ConveyModelKey: cohort.Value}, nil)
+	require.NoError(err)
+
+	var d Interface
+	select {
+	case d = <-connected:
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not connect")
+	}
+
+	assert.Equal(Tags{cohort.Name: cohort.Value, partner.Name: partner.Value}, d.Tags())
+	for _, tag := range []Tag{cohort, partner} {
+		var visited []ID
+		assert.Equal(1, manager.VisitTag(tag, func(v Interface) { visited = append(visited, v.ID()) }), "tag: %v", tag)
+		assert.Equal([]ID{ID("mac:112233445566")}, visited, "tag: %v", tag)
+	}
+
+	assert.Zero(manager.VisitTag(Tag{Name: ConveyModelKey, Value: "nosuch"}, func(Interface) {}))
+
+	c.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		require.Fail("The device did not disconnect")
+	}
+
+	// the Disconnect event can be dispatched slightly before the device leaves the registry
+	for deadline := time.Now().Add(5 * time.Second); manager.VisitTag(cohort, func(Interface) {}) > 0; time.Sleep(10 * time.Millisecond) {
+		require.True(time.Now().Before(deadline), "The device was not removed from the tag index")
+	}
+
+	assert.Zero(manager.VisitTag(partner, func(Interface) {}))
+}
@@ -0,0 +1,111 @@
+package device
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/tracing"
+	"github.com/Comcast/webpa-common/wrp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName is the name of the tracer used by device managers
+	TracerName = "github.com/Comcast/webpa-common/device"
+
+	// RouteSpan is the span covering a Route call, including any wait for a response
+	RouteSpan = "device.Route"
+
+	// WriteSpan is the span covering the write of a single message to a device's websocket
+	WriteSpan = "device.write"
+
+	// TransactionSpan is the span covering a single Send to a device, from queueing the request
+	// until the device's response, if any, arrives
+	TransactionSpan = "device.transaction"
+
+	// ReadSpan is the span marking the receipt of a single message from a device's websocket
+	ReadSpan = "device.read"
+
+	DeviceIDKey       = "device.id"
+	TransactionKeyKey = "wrp.transaction_key"
+	MessageTypeKey    = "wrp.msg_type"
+)
+
+// deviceAttributes returns the common span attributes for a device and message
+func deviceAttributes(id ID, transactionKey string) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{attribute.String(DeviceIDKey, string(id))}
+	if len(transactionKey) > 0 {
+		attributes = append(attributes, attribute.String(TransactionKeyKey, transactionKey))
+	}
+
+	return attributes
+}
+
+// endSpan records any error on the given span, then ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// spanIDs returns the hex-encoded trace and span identifiers of a span context.  Both are empty
+// if the span context is invalid.
+func spanIDs(spanContext trace.SpanContext) (traceID, spanID string) {
+	if spanContext.IsValid() {
+		traceID, spanID = spanContext.TraceID().String(), spanContext.SpanID().String()
+	}
+
+	return
+}
+
+// messageSpanIDs returns the trace and span identifiers propagated in the metadata of a message, if any
+func messageSpanIDs(message *wrp.Message) (traceID, spanID string) {
+	return spanIDs(trace.SpanContextFromContext(tracing.ExtractMessage(context.Background(), message)))
+}
+
+// traces tests whether a message must be encoded with the trace context of ctx
+func traces(ctx context.Context, message wrp.Routable) bool {
+	_, ok := message.(*wrp.Message)
+	return ok && trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// TransactionTracer is a hook that starts and finishes a span, or any other unit of tracing, around each
+// Send to a device.  Implementations can adapt OpenTracing or other tracing APIs.
+type TransactionTracer interface {
+	// StartTransaction is called before a request is queued for a device.  The returned context is carried by a copy of the
+	// request, so an OpenTelemetry span that it carries becomes the parent of the write span and
+	// is propagated to the device in the message metadata.
+	StartTransaction(ctx context.Context, device Interface, request *Request) context.Context
+
+	// FinishTransaction is called with the outcome of Send and the context returned by StartTransaction.
+	// The response is nil if Send failed or if the request had no transaction key.
+	FinishTransaction(ctx context.Context, device Interface, request *Request, response *Response, err error)
+}
+
+// NewTransactionTracer produces the default TransactionTracer, which records a TransactionSpan
+// for each Send using the given tracer
+func NewTransactionTracer(tracer trace.Tracer) TransactionTracer {
+	return spanTransactionTracer{tracer}
+}
+
+type spanTransactionTracer struct {
+	tracer trace.Tracer
+}
+
+func (t spanTransactionTracer) StartTransaction(ctx context.Context, device Interface, request *Request) context.Context {
+	ctx, _ = t.tracer.Start(
+		ctx,
+		TransactionSpan,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(deviceAttributes(device.ID(), request.TransactionKey())...),
+	)
+
+	return ctx
+}
+
+func (t spanTransactionTracer) FinishTransaction(ctx context.Context, device Interface, request *Request, response *Response, err error) {
+	endSpan(trace.SpanFromContext(ctx), err)
+}
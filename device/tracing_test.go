@@ -0,0 +1,374 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"testing"
+)
+
+func TestDeviceAttributes(t *testing.T) {
+	assert := assert.New(t)
+	id := IntToMAC(0xDEADBEEF)
+
+	assert.Equal(
+		[]attribute.KeyValue{attribute.String(DeviceIDKey, string(id))},
+		deviceAttributes(id, ""),
+	)
+
+	assert.Equal(
+		[]attribute.KeyValue{
+			attribute.String(DeviceIDKey, string(id)),
+			attribute.String(TransactionKeyKey, "abc"),
+		},
+		deviceAttributes(id, "abc"),
+	)
+}
+
+func TestEndSpan(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		recorder = tracetest.NewSpanRecorder()
+		tracer   = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(TracerName)
+	)
+
+	_, success := tracer.Start(context.Background(), "success")
+	endSpan(success, nil)
+
+	_, failure := tracer.Start(context.Background(), "failure")
+	endSpan(failure, errors.New("expected"))
+
+	spans := recorder.Ended()
+	require.Len(spans, 2)
+	assert.Equal(codes.Unset, spans[0].Status().Code)
+	assert.Equal(codes.Error, spans[1].Status().Code)
+	assert.Equal("expected", spans[1].Status().Description)
+}
+
+func TestTracedMessage(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		recorder = tracetest.NewSpanRecorder()
+		tracer   = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(TracerName)
+
+		original = &wrp.Message{
+			Type:     wrp.SimpleRequestResponseMessageType,
+			Metadata: map[string]string{"key": "value"},
+		}
+
+		simple = &wrp.SimpleEvent{Destination: "mac:112233445566"}
+	)
+
+	// no span, so nothing is injected
+	assert.True(original == tracedMessage(context.Background(), original))
+
+	ctx, span := tracer.Start(context.Background(), "test")
+	defer span.End()
+
+	// only *wrp.Message can carry trace context
+	assert.True(simple == tracedMessage(ctx, simple))
+
+	traced, ok := tracedMessage(ctx, original).(*wrp.Message)
+	require.True(ok)
+	assert.False(original == traced)
+	assert.Equal(map[string]string{"key": "value"}, original.Metadata)
+	assert.Equal("value", traced.Metadata["key"])
+	assert.NotEqual("", traced.Metadata["traceparent"])
+}
+
+func TestManagerRouteSpan(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		recorder = tracetest.NewSpanRecorder()
+		manager  = NewManager(
+			&Options{
+				TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+			},
+			nil,
+		)
+
+		request = &Request{
+			Message: &wrp.SimpleRequestResponse{
+				Destination:     "mac:112233445566",
+				TransactionUUID: "route-span",
+			},
+		}
+	)
+
+	response, err := manager.Route(request)
+	assert.Nil(response)
+	assert.Equal(ErrorDeviceNotFound, err)
+
+	spans := recorder.Ended()
+	require.Len(spans, 1)
+	assert.Equal(RouteSpan, spans[0].Name())
+	assert.Equal(codes.Error, spans[0].Status().Code)
+	assert.Contains(spans[0].Attributes(), attribute.String(TransactionKeyKey, "route-span"))
+	assert.False(trace.SpanContextFromContext(request.Context()).IsValid(), "The caller's request should be left untouched")
+}
+
+func TestSpanIDs(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		spanContext = trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+			SpanID:  trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+		})
+	)
+
+	traceID, spanID := spanIDs(trace.SpanContext{})
+	assert.Empty(traceID)
+	assert.Empty(spanID)
+
+	traceID, spanID = spanIDs(spanContext)
+	assert.Equal("0102030405060708090a0b0c0d0e0f10", traceID)
+	assert.Equal("0102030405060708", spanID)
+}
+
+func TestTransactionTracer(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		recorder = tracetest.NewSpanRecorder()
+		tracer   = NewTransactionTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(TracerName))
+		device   = new(mockDevice)
+		request  = &Request{
+			Message: &wrp.SimpleRequestResponse{
+				Destination:     "mac:112233445566",
+				TransactionUUID: "transaction-span",
+			},
+		}
+	)
+
+	device.On("ID").Return(ID("mac:112233445566"))
+
+	ctx := tracer.StartTransaction(context.Background(), device, request)
+	assert.True(trace.SpanContextFromContext(ctx).IsValid())
+	tracer.FinishTransaction(ctx, device, request, nil, errors.New("expected"))
+
+	spans := recorder.Ended()
+	require.Len(spans, 1)
+	assert.Equal(TransactionSpan, spans[0].Name())
+	assert.Equal(trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(codes.Error, spans[0].Status().Code)
+	assert.Contains(spans[0].Attributes(), attribute.String(DeviceIDKey, "mac:112233445566"))
+	assert.Contains(spans[0].Attributes(), attribute.String(TransactionKeyKey, "transaction-span"))
+	assert.Equal(spans[0].SpanContext(), trace.SpanContextFromContext(ctx))
+	device.AssertExpectations(t)
+}
+
+// answerTransactions responds to each request with a transaction key that a device connection receives.
+// If propagate is true, each response carries the metadata, and thus the trace context, of its request.
+// The requests are sent on the returned channel.
+func answerTransactions(c Connection, propagate bool) <-chan *wrp.Message {
+	received := make(chan *wrp.Message, 10)
+	go func() {
+		defer close(received)
+		for {
+			frame, err := c.NextReader()
+			if err != nil {
+				return
+			} else if frame == nil {
+				continue
+			}
+
+			message := new(wrp.Message)
+			if err := wrp.NewDecoder(frame, c.Format()).Decode(message); err != nil {
+				return
+			}
+
+			received <- message
+			response := &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          message.Destination,
+				Destination:     message.Source,
+				TransactionUUID: message.TransactionUUID,
+			}
+
+			if propagate {
+				response.Metadata = message.Metadata
+			}
+
+			var encoded []byte
+			wrp.NewEncoderBytes(&encoded, c.Format()).Encode(response)
+			c.Write(encoded)
+		}
+	}()
+
+	return received
+}
+
+func TestManagerTransactionSpan(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		recorder = tracetest.NewSpanRecorder()
+
+		connected    = make(chan Interface, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:         logging.TestLogger(t),
+			TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+
+	d := <-connected
+	received := answerTransactions(c, false)
+
+	// contents that are already encoded in the device's format must still carry the trace
+	message := &wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "service",
+		Destination:     "mac:112233445566/service",
+		TransactionUUID: "transaction-span",
+	}
+
+	var contents []byte
+	require.NoError(wrp.NewEncoderBytes(&contents, c.Format()).Encode(message))
+
+	request := &Request{Message: message, Format: c.Format(), Contents: contents}
+	response, err := d.Send(request)
+	require.NoError(err)
+	require.NotNil(response)
+	assert.Empty(message.Metadata)
+
+	deviceRequest := <-received
+	assert.NotEmpty(deviceRequest.Metadata["traceparent"])
+
+	// the transaction span is carried by a copy of the request, leaving the caller's request untouched
+	assert.False(trace.SpanContextFromContext(request.Context()).IsValid())
+
+	var transactionSpan, writeSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case TransactionSpan:
+			transactionSpan = span
+		case WriteSpan:
+			writeSpan = span
+		}
+	}
+
+	require.NotNil(transactionSpan)
+	require.NotNil(writeSpan)
+
+	// the device did not propagate a trace, so the response belongs to the transaction span
+	transaction := transactionSpan.SpanContext()
+	assert.Equal(transaction.TraceID().String(), response.TraceID)
+	assert.Equal(transaction.SpanID().String(), response.SpanID)
+	assert.Equal(transaction.SpanID(), writeSpan.Parent().SpanID())
+
+	c.Close()
+	<-disconnected
+}
+
+// testTransactionTracer is a TransactionTracer that starts a fixed span context and records each outcome
+type testTransactionTracer struct {
+	spanContext trace.SpanContext
+
+	lock      sync.Mutex
+	started   int
+	responses []*Response
+	errors    []error
+}
+
+func (t *testTransactionTracer) StartTransaction(ctx context.Context, device Interface, request *Request) context.Context {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.started++
+	return trace.ContextWithSpanContext(ctx, t.spanContext)
+}
+
+func (t *testTransactionTracer) FinishTransaction(ctx context.Context, device Interface, request *Request, response *Response, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.responses = append(t.responses, response)
+	t.errors = append(t.errors, err)
+}
+
+func TestManagerTransactionTracer(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		tracer  = &testTransactionTracer{
+			spanContext: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+				SpanID:     trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+				TraceFlags: trace.FlagsSampled,
+			}),
+		}
+
+		connected = make(chan Interface, 1)
+		options   = &Options{
+			Logger:            logging.TestLogger(t),
+			TransactionTracer: tracer,
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == Connect {
+						connected <- event.Device
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, IntToMAC(0x112233445566), nil, nil)
+	require.NoError(err)
+	defer c.Close()
+
+	d := <-connected
+	received := answerTransactions(c, true)
+
+	response, err := d.Send(&Request{Message: &wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "service",
+		Destination:     "mac:112233445566/service",
+		TransactionUUID: "transaction-tracer",
+	}})
+
+	require.NoError(err)
+	require.NotNil(response)
+	<-received
+
+	// the device propagated the trace, so the response carries it
+	assert.Equal(tracer.spanContext.TraceID().String(), response.TraceID)
+	assert.Equal(tracer.spanContext.SpanID().String(), response.SpanID)
+
+	tracer.lock.Lock()
+	defer tracer.lock.Unlock()
+	assert.Equal(1, tracer.started)
+	assert.Equal([]*Response{response}, tracer.responses)
+	assert.Equal([]error{nil}, tracer.errors)
+}
@@ -3,11 +3,25 @@ package device
 import (
 	"context"
 	"github.com/Comcast/webpa-common/httperror"
+	"github.com/Comcast/webpa-common/tracing"
 	"github.com/Comcast/webpa-common/wrp"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultTransactionSweepInterval is the default time between sweeps of each device's expired transactions
+	DefaultTransactionSweepInterval = time.Second
+
+	// OrphanCapacity is the count of cancelled or expired transactions that a Transactions with an
+	// OnOrphan hook remembers.  Late responses to older transactions are not recognized as orphans.
+	OrphanCapacity = 100
 )
 
 // Request represents a single device Request, carrying routing information and message contents.
@@ -24,11 +38,55 @@ type Request struct {
 	// then Routing will be encoded prior to sending to devices.
 	Contents []byte
 
+	// IdempotencyKey is the optional, caller-supplied key identifying this request across retries.
+	// When set, a device that has recently sent a request with the same key returns that request's
+	// outcome rather than sending the message again.
+	IdempotencyKey string
+
+	// Priority is the class of the queue this request waits in before being written to the device.
+	// If DefaultPriority or invalid, the priority is derived from the message's quality of service,
+	// and messages that carry no quality of service are LowPriority.
+	Priority Priority
+
+	// Acknowledge requests that the device acknowledge receipt of the message, which is independent of any
+	// response to the message's transaction.  The message is written again, with exponential backoff, until
+	// it is acknowledged or the Manager's AckRetries are exhausted, in which case Send returns
+	// ErrorNotAcknowledged.  Only *wrp.Message messages can be acknowledged.  See AckMetadataKey.
+	Acknowledge bool
+
+	// QueuePolicy determines what happens if the device's queue for this request's priority is full.
+	// If not supplied or unrecognized, the Manager's QueuePolicy is used.
+	QueuePolicy QueuePolicy
+
 	// ctx is the API context for this request, which can be nil.  Normally, it's best to
 	// set this to context.Background() if no cancellation semantics are desired.
 	ctx context.Context
 }
 
+// NewRequest creates a device Request for a WRP message that is not associated with any context.
+// This function returns an error if message is nil or if its destination is not a valid device ID.
+func NewRequest(message wrp.Routable) (*Request, error) {
+	return NewRequestWithContext(context.Background(), message)
+}
+
+// NewRequestWithContext is like NewRequest, but associates the given context with the returned
+// Request.  As with net/http.NewRequestWithContext, it is an error to pass a nil context.
+func NewRequestWithContext(ctx context.Context, message wrp.Routable) (*Request, error) {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	if message == nil {
+		return nil, ErrorMissingMessage
+	}
+
+	if _, err := ParseID(message.To()); err != nil {
+		return nil, err
+	}
+
+	return &Request{Message: message, ctx: ctx}, nil
+}
+
 // Context returns the context.Context object associated with this Request.
 // This method never returns nil.  If no context is associated with this Request,
 // this method returns context.Background().
@@ -53,11 +111,78 @@ func (r *Request) WithContext(ctx context.Context) *Request {
 	return r
 }
 
+// withContext returns a shallow copy of this Request that carries the given context.  Unlike WithContext,
+// this leaves the original Request untouched, so that a Request shared by concurrent sends, e.g. a broadcast,
+// never has its context changed out from under another device.
+func (r *Request) withContext(ctx context.Context) *Request {
+	copied := *r
+	copied.ctx = ctx
+	return &copied
+}
+
 // ID parses the Routing.To() value into a device identifier.
 func (r *Request) ID() (ID, error) {
 	return ParseID(r.Message.To())
 }
 
+// Source returns the originator of this Request's message
+func (r *Request) Source() string {
+	return r.Message.From()
+}
+
+// TransactionKey returns the transaction key of this Request's message.  Requests with
+// a transaction key wait for the device's response.
+func (r *Request) TransactionKey() string {
+	return r.Message.TransactionKey()
+}
+
+// EffectivePriority returns the priority class that this Request is queued under
+func (r *Request) EffectivePriority() Priority {
+	if r.Priority > DefaultPriority && r.Priority < priorityClasses {
+		return r.Priority
+	}
+
+	if message, ok := r.Message.(*wrp.Message); ok {
+		return QOSPriority(message.QualityOfService)
+	}
+
+	return LowPriority
+}
+
+// Deadline returns the deadline of this Request's context, if any
+func (r *Request) Deadline() (time.Time, bool) {
+	return r.Context().Deadline()
+}
+
+// spanContext returns the span context of this Request.  The span is taken from the Request's context
+// or, failing that, from the trace context in the message metadata.
+func (r *Request) spanContext() trace.SpanContext {
+	spanContext := trace.SpanContextFromContext(r.Context())
+	if !spanContext.IsValid() {
+		if message, ok := r.Message.(*wrp.Message); ok {
+			spanContext = trace.SpanContextFromContext(tracing.ExtractMessage(context.Background(), message))
+		}
+	}
+
+	return spanContext
+}
+
+// TraceID returns the trace identifier of this Request.  The trace is taken from the span in
+// the Request's context or, failing that, from the trace context in the message metadata.
+// If neither is present, this method returns the empty string.
+func (r *Request) TraceID() string {
+	traceID, _ := spanIDs(r.spanContext())
+	return traceID
+}
+
+// SpanID returns the identifier of the span under which this Request is sent, from the same sources
+// as TraceID.  Once a Manager or device has started its spans, this is the innermost of them.
+// If there is no span, this method returns the empty string.
+func (r *Request) SpanID() string {
+	_, spanID := spanIDs(r.spanContext())
+	return spanID
+}
+
 // DecodeRequest decodes a WRP source into a device Request.  Typically, this is used
 // to produce a device Request from an http.Request.
 //
@@ -94,6 +219,41 @@ type Response struct {
 
 	// Contents is the encoded form of Message, formatted in Format
 	Contents []byte
+
+	// TraceID and SpanID identify the span under which the device produced this response, as propagated
+	// in the response's metadata.  If the device did not propagate a trace, they identify the span of the
+	// corresponding Request instead.  Both are empty if there is no trace at all.
+	TraceID string
+	SpanID  string
+}
+
+// NewResponse creates a Response for a message received from a device, with Contents
+// holding the message encoded in the given format
+func NewResponse(device Interface, message *wrp.Message, format wrp.Format) (*Response, error) {
+	var contents []byte
+	if err := wrp.NewEncoderBytes(&contents, format).Encode(message); err != nil {
+		return nil, err
+	}
+
+	response := &Response{
+		Device:   device,
+		Message:  message,
+		Format:   format,
+		Contents: contents,
+	}
+
+	response.TraceID, response.SpanID = messageSpanIDs(message)
+	return response, nil
+}
+
+// TransactionKey returns the transaction key of this Response's message, or the empty string
+// if this Response has no message
+func (r *Response) TransactionKey() string {
+	if r.Message == nil {
+		return ""
+	}
+
+	return r.Message.TransactionKey()
 }
 
 // EncodeResponse writes out a device transaction Response to an http Response.
@@ -130,16 +290,93 @@ func EncodeResponse(output http.ResponseWriter, response *Response, pool *wrp.En
 	return
 }
 
+// PendingTransaction describes a transaction that has been registered but not yet completed or cancelled
+type PendingTransaction struct {
+	// Key is the transaction key, i.e. the transaction_uuid of the request
+	Key string
+
+	// RegisteredAt is the time at which this transaction was registered
+	RegisteredAt time.Time
+
+	// Age is how long this transaction had been pending when it was reported
+	Age time.Duration
+}
+
+// pendingTransaction is the internal bookkeeping for a registered transaction
+type pendingTransaction struct {
+	result       chan *Response
+	registeredAt time.Time
+
+	// expiresAt is when the sweeper removes this transaction, or the zero time if it never expires
+	expiresAt time.Time
+
+	// expired is closed, before result, when this transaction is removed by the sweeper
+	expired chan struct{}
+}
+
+// abandonedTransactions remembers the keys of the most recently cancelled or expired transactions, up to
+// OrphanCapacity keys.  Instances are not safe for concurrent use.
+type abandonedTransactions struct {
+	ring []string
+	next int
+
+	// positions maps each remembered key to its position in the ring
+	positions map[string]int
+}
+
+func (a *abandonedTransactions) add(key string) {
+	if a.ring == nil {
+		a.ring = make([]string, OrphanCapacity)
+		a.positions = make(map[string]int, OrphanCapacity)
+	}
+
+	// the oldest key is forgotten, unless it has since been abandoned again
+	if oldest := a.ring[a.next]; a.positions[oldest] == a.next {
+		delete(a.positions, oldest)
+	}
+
+	a.ring[a.next] = key
+	a.positions[key] = a.next
+	a.next = (a.next + 1) % len(a.ring)
+}
+
+// remove forgets a key, returning true if the key was remembered
+func (a *abandonedTransactions) remove(key string) bool {
+	if _, ok := a.positions[key]; ok {
+		delete(a.positions, key)
+		return true
+	}
+
+	return false
+}
+
 // Transactions represents a set of pending transactions.  Instances are safe for
 // concurrent access.
 type Transactions struct {
-	lock    sync.RWMutex
-	pending map[string]chan *Response
+	lock         sync.RWMutex
+	now          func() time.Time
+	ttl          time.Duration
+	pending      map[string]*pendingTransaction
+	expiredCount int64
+
+	onOrphan  func(*Response)
+	abandoned abandonedTransactions
 }
 
+// NewTransactions creates a Transactions whose transactions never expire
 func NewTransactions() *Transactions {
+	return NewTransactionsWithTTL(0)
+}
+
+// NewTransactionsWithTTL creates a Transactions whose transactions expire, by default, after the given
+// time-to-live.  A nonpositive ttl means that transactions do not expire unless registered via RegisterWithTTL.
+//
+// Expired transactions are only removed by Sweep, which the owner of a Transactions must call periodically.
+func NewTransactionsWithTTL(ttl time.Duration) *Transactions {
 	return &Transactions{
-		pending: make(map[string]chan *Response, 1000),
+		now:     time.Now,
+		ttl:     ttl,
+		pending: make(map[string]*pendingTransaction, 1000),
 	}
 }
 
@@ -168,11 +405,39 @@ func (t *Transactions) Keys() []string {
 	return keys
 }
 
+// Pending returns a snapshot of the pending transactions, oldest first
+func (t *Transactions) Pending() []PendingTransaction {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	var (
+		now     = t.now()
+		pending = make([]PendingTransaction, 0, len(t.pending))
+	)
+
+	for key, value := range t.pending {
+		pending = append(pending, PendingTransaction{
+			Key:          key,
+			RegisteredAt: value.registeredAt,
+			Age:          now.Sub(value.registeredAt),
+		})
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].RegisteredAt.Before(pending[j].RegisteredAt)
+	})
+
+	return pending
+}
+
 // Complete dispatches the given response to the appropriate channel returned from Register
 // and removes the transaction from the internal pending set.  This method is intended for
 // goroutines that are servicing queues of messages, e.g. the read pump of a Manager.  Such goroutines
 // use this method to indicate that a transaction is complete.
 //
+// If the transaction is not pending, this method returns ErrorNoSuchTransactionKey.  When that transaction was
+// recently cancelled or expired, the response is also passed to the OnOrphan hook, if any.
+//
 // If this method is passed a nil response, it panics.
 func (t *Transactions) Complete(transactionKey string, response *Response) error {
 	if len(transactionKey) == 0 {
@@ -182,16 +447,22 @@ func (t *Transactions) Complete(transactionKey string, response *Response) error
 	}
 
 	t.lock.Lock()
-	result, ok := t.pending[transactionKey]
+	value, ok := t.pending[transactionKey]
 	delete(t.pending, transactionKey)
+	onOrphan := t.onOrphan
+	orphaned := !ok && onOrphan != nil && t.abandoned.remove(transactionKey)
 	t.lock.Unlock()
 
+	if orphaned {
+		onOrphan(response)
+	}
+
 	if !ok {
 		return ErrorNoSuchTransactionKey
 	}
 
-	result <- response
-	close(result)
+	value.result <- response
+	close(value.result)
 	return nil
 }
 
@@ -203,12 +474,31 @@ func (t *Transactions) Complete(transactionKey string, response *Response) error
 // are cleaned up.
 func (t *Transactions) Cancel(transactionKey string) {
 	t.lock.Lock()
-	result, ok := t.pending[transactionKey]
+	value, ok := t.pending[transactionKey]
 	delete(t.pending, transactionKey)
+	if ok && t.onOrphan != nil {
+		t.abandoned.add(transactionKey)
+	}
+
 	t.lock.Unlock()
 
 	if ok {
-		close(result)
+		close(value.result)
+	}
+}
+
+// OnOrphan sets the hook invoked by Complete with each response for a transaction that had already been
+// cancelled or expired, such as a response that a device sent after the requester stopped waiting.  The hook
+// is invoked on the goroutine that calls Complete, such as a device's read pump, so it should not block.
+// Only transactions that are cancelled or expired after the hook is set, and at most OrphanCapacity of
+// the most recent of those, are recognized.  A nil hook stops recognizing orphaned responses.
+func (t *Transactions) OnOrphan(hook func(*Response)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.onOrphan = hook
+	if hook == nil {
+		t.abandoned = abandonedTransactions{}
 	}
 }
 
@@ -222,8 +512,27 @@ func (t *Transactions) Cancel(transactionKey string) {
 // instance expressly does not allow that case.
 //
 // The returned channel will either receive a non-nil response from some code calling Complete, or will
-// see a channel closure (nil Response) from some code calling Cancel.
+// see a channel closure (nil Response) from some code calling Cancel or from Sweep expiring the transaction.
+//
+// The transaction expires after this instance's time-to-live, if one was set via NewTransactionsWithTTL.
 func (t *Transactions) Register(transactionKey string) (<-chan *Response, error) {
+	return t.RegisterWithTTL(transactionKey, t.ttl)
+}
+
+// RegisterWithTTL is like Register, but the transaction expires after the given time-to-live instead of this
+// instance's.  A nonpositive ttl means that the transaction never expires.
+func (t *Transactions) RegisterWithTTL(transactionKey string, ttl time.Duration) (<-chan *Response, error) {
+	value, err := t.register(transactionKey, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.result, nil
+}
+
+// register performs the work of RegisterWithTTL, returning the internal bookkeeping so that
+// callers within this package can tell an expiration apart from a cancellation
+func (t *Transactions) register(transactionKey string, ttl time.Duration) (*pendingTransaction, error) {
 	if len(transactionKey) == 0 {
 		return nil, ErrorInvalidTransactionKey
 	}
@@ -235,7 +544,67 @@ func (t *Transactions) Register(transactionKey string) (<-chan *Response, error)
 		return nil, ErrorTransactionAlreadyRegistered
 	}
 
-	result := make(chan *Response, 1)
-	t.pending[transactionKey] = result
-	return result, nil
+	value := &pendingTransaction{
+		result:       make(chan *Response, 1),
+		registeredAt: t.now(),
+		expired:      make(chan struct{}),
+	}
+
+	if ttl > 0 {
+		value.expiresAt = value.registeredAt.Add(ttl)
+	}
+
+	t.pending[transactionKey] = value
+	return value, nil
+}
+
+// Sweep removes every pending transaction whose time-to-live has elapsed, closing the channels returned
+// by Register as Cancel does.  This method returns the count of transactions that expired.
+func (t *Transactions) Sweep() int {
+	var expired []*pendingTransaction
+
+	t.lock.Lock()
+	now := t.now()
+	for key, value := range t.pending {
+		if !value.expiresAt.IsZero() && !now.Before(value.expiresAt) {
+			expired = append(expired, value)
+			delete(t.pending, key)
+			if t.onOrphan != nil {
+				t.abandoned.add(key)
+			}
+		}
+	}
+
+	t.lock.Unlock()
+
+	for _, value := range expired {
+		close(value.expired)
+		close(value.result)
+	}
+
+	atomic.AddInt64(&t.expiredCount, int64(len(expired)))
+	return len(expired)
+}
+
+// Expired returns the total count of transactions that have been removed by Sweep
+func (t *Transactions) Expired() int {
+	return int(atomic.LoadInt64(&t.expiredCount))
+}
+
+// sweepTransactions periodically removes a device's expired transactions, until the device is closed
+func (m *manager) sweepTransactions(d *device) {
+	ticker := time.NewTicker(m.transactionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.shutdown:
+			return
+		case <-ticker.C:
+			if expired := d.transactions.Sweep(); expired > 0 {
+				d.logger.Debug("Expired %d transactions for device [%s]", expired, d.id)
+				m.metrics.transactionExpired(expired)
+			}
+		}
+	}
 }
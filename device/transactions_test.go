@@ -5,13 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/tracing"
 	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func testRequestContext(t *testing.T) {
@@ -61,9 +68,178 @@ func testRequestID(t *testing.T) {
 	assert.Error(err)
 }
 
+func testRequestAccessors(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = &Request{
+			Message: &wrp.Message{
+				Source:          "test.com",
+				Destination:     "mac:123412341234",
+				TransactionUUID: "transaction",
+			},
+		}
+	)
+
+	assert.Equal("test.com", request.Source())
+	assert.Equal("transaction", request.TransactionKey())
+
+	deadline, ok := request.Deadline()
+	assert.True(deadline.IsZero())
+	assert.False(ok)
+
+	expectedDeadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), expectedDeadline)
+	defer cancel()
+
+	deadline, ok = request.WithContext(ctx).Deadline()
+	assert.Equal(expectedDeadline, deadline)
+	assert.True(ok)
+}
+
+func testRequestTraceID(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		spanContext = trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+			SpanID:     trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			TraceFlags: trace.FlagsSampled,
+		})
+
+		traced = trace.ContextWithSpanContext(context.Background(), spanContext)
+	)
+
+	request, err := NewRequest(&wrp.Message{Destination: "mac:123412341234"})
+	require.NoError(err)
+	assert.Empty(request.TraceID())
+	assert.Empty(request.SpanID())
+
+	request.WithContext(traced)
+	assert.Equal(spanContext.TraceID().String(), request.TraceID())
+	assert.Equal(spanContext.SpanID().String(), request.SpanID())
+
+	// the trace can also come from the message metadata
+	message := &wrp.Message{Destination: "mac:123412341234"}
+	tracing.InjectMessage(traced, message)
+	request, err = NewRequest(message)
+	require.NoError(err)
+	assert.Equal(spanContext.TraceID().String(), request.TraceID())
+	assert.Equal(spanContext.SpanID().String(), request.SpanID())
+
+	// messages without metadata have no trace
+	request, err = NewRequest(&wrp.SimpleEvent{Destination: "mac:123412341234"})
+	require.NoError(err)
+	assert.Empty(request.TraceID())
+	assert.Empty(request.SpanID())
+}
+
 func TestRequest(t *testing.T) {
 	t.Run("Context", testRequestContext)
 	t.Run("ID", testRequestID)
+	t.Run("Accessors", testRequestAccessors)
+	t.Run("TraceID", testRequestTraceID)
+}
+
+func TestNewRequest(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = &wrp.Message{Destination: "mac:123412341234"}
+	)
+
+	request, err := NewRequest(message)
+	assert.NoError(err)
+	if assert.NotNil(request) {
+		assert.Equal(message, request.Message)
+		assert.Equal(context.Background(), request.Context())
+	}
+
+	request, err = NewRequest(nil)
+	assert.Nil(request)
+	assert.Equal(ErrorMissingMessage, err)
+
+	request, err = NewRequest(&wrp.Message{Destination: "this is not a valid device ID"})
+	assert.Nil(request)
+	assert.Error(err)
+}
+
+func TestNewRequestWithContext(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = &wrp.Message{Destination: "mac:123412341234"}
+		ctx     = context.WithValue(context.Background(), "foo", "bar")
+	)
+
+	request, err := NewRequestWithContext(ctx, message)
+	assert.NoError(err)
+	if assert.NotNil(request) {
+		assert.Equal(message, request.Message)
+		assert.Equal(ctx, request.Context())
+	}
+
+	assert.Panics(func() {
+		NewRequestWithContext(nil, message)
+	})
+}
+
+func TestNewResponse(t *testing.T) {
+	for _, format := range []wrp.Format{wrp.Msgpack, wrp.JSON} {
+		t.Run(format.String(), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				device  = new(mockDevice)
+				message = &wrp.Message{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					Source:          "mac:123412341234",
+					Destination:     "test.com",
+					TransactionUUID: "transaction",
+				}
+			)
+
+			response, err := NewResponse(device, message, format)
+			require.NoError(err)
+			require.NotNil(response)
+
+			assert.Equal(device, response.Device)
+			assert.Equal(message, response.Message)
+			assert.Equal(format, response.Format)
+			assert.Equal("transaction", response.TransactionKey())
+			assert.Empty(response.TraceID)
+			assert.Empty(response.SpanID)
+
+			decoded := new(wrp.Message)
+			require.NoError(wrp.NewDecoderBytes(response.Contents, format).Decode(decoded))
+			assert.Equal(*message, *decoded)
+		})
+	}
+
+	assert.Empty(t, new(Response).TransactionKey())
+}
+
+func TestNewResponseTraced(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		spanContext = trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+			SpanID:     trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			TraceFlags: trace.FlagsSampled,
+		})
+
+		message = &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "mac:123412341234",
+			Destination:     "test.com",
+			TransactionUUID: "transaction",
+		}
+	)
+
+	tracing.InjectMessage(trace.ContextWithSpanContext(context.Background(), spanContext), message)
+	response, err := NewResponse(new(mockDevice), message, wrp.Msgpack)
+	require.NoError(err)
+	require.NotNil(response)
+	assert.Equal(spanContext.TraceID().String(), response.TraceID)
+	assert.Equal(spanContext.SpanID().String(), response.SpanID)
 }
 
 func testDecodeRequest(t *testing.T, message wrp.Routable, format wrp.Format) {
@@ -417,6 +593,161 @@ func testTransactionsCancellation(t *testing.T) {
 	<-finished
 }
 
+func testTransactionsPending(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		now          = time.Now()
+		transactions = NewTransactions()
+	)
+
+	assert.Empty(transactions.Pending())
+
+	transactions.now = func() time.Time { return now }
+	transactions.Register("second")
+	transactions.now = func() time.Time { return now.Add(-time.Minute) }
+	transactions.Register("first")
+	transactions.now = func() time.Time { return now.Add(time.Second) }
+
+	assert.Equal(
+		[]PendingTransaction{
+			{Key: "first", RegisteredAt: now.Add(-time.Minute), Age: time.Minute + time.Second},
+			{Key: "second", RegisteredAt: now, Age: time.Second},
+		},
+		transactions.Pending(),
+	)
+
+	transactions.Cancel("first")
+	assert.Equal(
+		[]PendingTransaction{{Key: "second", RegisteredAt: now, Age: time.Second}},
+		transactions.Pending(),
+	)
+}
+
+func testTransactionsSweep(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		now          = time.Now()
+		transactions = NewTransactionsWithTTL(time.Minute)
+	)
+
+	transactions.now = func() time.Time { return now }
+	defaultTTL, err := transactions.Register("default")
+	require.NoError(err)
+	shortTTL, err := transactions.RegisterWithTTL("short", 10*time.Second)
+	require.NoError(err)
+	_, err = transactions.RegisterWithTTL("forever", 0)
+	require.NoError(err)
+
+	assert.Zero(transactions.Sweep())
+	assert.Zero(transactions.Expired())
+
+	transactions.now = func() time.Time { return now.Add(10 * time.Second) }
+	assert.Equal(1, transactions.Sweep())
+	assert.Nil(<-shortTTL)
+	assert.Equal(1, transactions.Expired())
+	keys := transactions.Keys()
+	sort.Strings(keys)
+	assert.Equal([]string{"default", "forever"}, keys)
+
+	transactions.now = func() time.Time { return now.Add(time.Hour) }
+	assert.Equal(1, transactions.Sweep())
+	assert.Nil(<-defaultTTL)
+	assert.Equal(2, transactions.Expired())
+	assert.Equal([]string{"forever"}, transactions.Keys())
+
+	// expired transactions can no longer be completed
+	assert.Equal(ErrorNoSuchTransactionKey, transactions.Complete("default", new(Response)))
+}
+
+func testTransactionsNoTTL(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		now          = time.Now()
+		transactions = NewTransactions()
+	)
+
+	transactions.now = func() time.Time { return now }
+	_, err := transactions.Register("test")
+	require.NoError(err)
+
+	transactions.now = func() time.Time { return now.Add(24 * time.Hour) }
+	assert.Zero(transactions.Sweep())
+	assert.Zero(transactions.Expired())
+	assert.Equal(1, transactions.Len())
+}
+
+func testTransactionsOrphan(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		now          = time.Now()
+		transactions = NewTransactionsWithTTL(time.Minute)
+		orphans      []*Response
+	)
+
+	transactions.now = func() time.Time { return now }
+	transactions.Register("before")
+	transactions.Cancel("before")
+
+	transactions.OnOrphan(func(response *Response) { orphans = append(orphans, response) })
+	for _, key := range []string{"cancelled", "expired"} {
+		_, err := transactions.Register(key)
+		require.NoError(err)
+	}
+
+	for _, key := range []string{"completed", "pending"} {
+		_, err := transactions.RegisterWithTTL(key, 0)
+		require.NoError(err)
+	}
+
+	transactions.Cancel("cancelled")
+	transactions.now = func() time.Time { return now.Add(time.Hour) }
+	assert.Equal(1, transactions.Sweep())
+
+	completed := new(Response)
+	assert.NoError(transactions.Complete("completed", completed))
+	transactions.Cancel("completed")
+
+	late := &Response{Contents: []byte("late")}
+	assert.Equal(ErrorNoSuchTransactionKey, transactions.Complete("cancelled", late))
+	assert.Equal(ErrorNoSuchTransactionKey, transactions.Complete("expired", late))
+	assert.Equal([]*Response{late, late}, orphans)
+
+	// only the first late response to an abandoned transaction is an orphan, and neither completed
+	// transactions, unknown transactions, nor those abandoned before the hook was set are recognized
+	orphans = nil
+	for _, key := range []string{"cancelled", "completed", "unknown", "before"} {
+		assert.Equal(ErrorNoSuchTransactionKey, transactions.Complete(key, late))
+	}
+
+	assert.Empty(orphans)
+	assert.NoError(transactions.Complete("pending", completed))
+
+	// only the most recently abandoned transactions are remembered
+	for i := 0; i <= OrphanCapacity; i++ {
+		key := strconv.Itoa(i)
+		transactions.Register(key)
+		transactions.Cancel(key)
+	}
+
+	assert.Equal(ErrorNoSuchTransactionKey, transactions.Complete("0", late))
+	assert.Empty(orphans)
+	for i := 1; i <= OrphanCapacity; i++ {
+		assert.Equal(ErrorNoSuchTransactionKey, transactions.Complete(strconv.Itoa(i), late))
+	}
+
+	assert.Len(orphans, OrphanCapacity)
+
+	// a nil hook forgets the abandoned transactions
+	transactions.Register("cleared")
+	transactions.Cancel("cleared")
+	transactions.OnOrphan(nil)
+	transactions.Complete("cleared", late)
+	assert.Len(orphans, OrphanCapacity)
+}
+
 func TestTransactions(t *testing.T) {
 	t.Run("InitialState", testTransactionsInitialState)
 
@@ -433,4 +764,128 @@ func TestTransactions(t *testing.T) {
 
 	t.Run("Lifecycle", testTransactionsLifecycle)
 	t.Run("Cancellation", testTransactionsCancellation)
+	t.Run("Pending", testTransactionsPending)
+	t.Run("Sweep", testTransactionsSweep)
+	t.Run("NoTTL", testTransactionsNoTTL)
+	t.Run("Orphan", testTransactionsOrphan)
+}
+
+func TestOptionsTransactionTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options), {TransactionTTL: -1, TransactionSweepInterval: -1}} {
+		assert.Zero(o.transactionTTL())
+		assert.Equal(DefaultTransactionSweepInterval, o.transactionSweepInterval())
+	}
+
+	o := &Options{TransactionTTL: time.Minute, TransactionSweepInterval: 5 * time.Second}
+	assert.Equal(time.Minute, o.transactionTTL())
+	assert.Equal(5*time.Second, o.transactionSweepInterval())
+}
+
+func TestDeviceSendTransactionTimeout(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		stop    = make(chan struct{})
+		d       = newDevice(ID("mac:112233445566"), Key("test"), nil, 1)
+		result  = make(chan error, 1)
+	)
+
+	defer close(stop)
+	d.transactions = NewTransactionsWithTTL(time.Nanosecond)
+	sent := completeSends(d, nil, stop)
+
+	go func() {
+		_, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "test"}})
+		result <- err
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		require.Fail("The request was not sent")
+	}
+
+	assert.Equal(1, d.transactions.Sweep())
+	select {
+	case err := <-result:
+		assert.Equal(ErrorTransactionTimeout, err)
+	case <-time.After(5 * time.Second):
+		require.Fail("Send did not return")
+	}
+
+	assert.Zero(d.transactions.Len())
+}
+
+func TestManagerTransactionTTL(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 1)
+		broken       = make(chan error, 1)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:                   logging.TestLogger(t),
+			Metrics:                  registry,
+			TransactionTTL:           50 * time.Millisecond,
+			TransactionSweepInterval: 10 * time.Millisecond,
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case TransactionBroken:
+						broken <- event.Error
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	d := <-connected
+
+	// the device never responds, and the request's context never expires
+	start := time.Now()
+	_, err = d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "test"}})
+	assert.Equal(ErrorTransactionTimeout, err)
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+	assert.Empty(d.PendingTransactions())
+
+	// a response after the transaction expired is counted as an orphan
+	var frame []byte
+	require.NoError(wrp.NewEncoderBytes(&frame, c.Format()).Encode(
+		&wrp.Message{Type: wrp.SimpleRequestResponseMessageType, Source: "mac:112233445566", TransactionUUID: "test"},
+	))
+
+	_, err = c.Write(frame)
+	require.NoError(err)
+	select {
+	case err := <-broken:
+		assert.Equal(ErrorNoSuchTransactionKey, err)
+	case <-time.After(5 * time.Second):
+		require.Fail("The late response was not received")
+	}
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(response.Body.String(), TransactionExpiredCount+" 1")
+	assert.Contains(response.Body.String(), OrphanedResponseCount+" 1")
+
+	c.Close()
+	<-disconnected
 }
@@ -0,0 +1,21 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// validate applies the MessageValidator, if any, to a message read from a device.  This method returns
+// false if the message is invalid, in which case it has already been logged and counted and must be dropped.
+func (m *manager) validate(d *device, message *wrp.Message) bool {
+	if m.validator == nil {
+		return true
+	}
+
+	if err := m.validator.Validate(message); err != nil {
+		m.metrics.invalidMessage()
+		d.logger.Error("Dropping invalid message from device [%s]: %s", d.id, err)
+		return false
+	}
+
+	return true
+}
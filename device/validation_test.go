@@ -0,0 +1,92 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsMessageValidator(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Nil(o.messageValidator())
+	}
+
+	validator := wrp.NewRequiredFieldsValidator()
+	assert.NotNil((&Options{MessageValidator: validator}).messageValidator())
+}
+
+func TestManagerMessageValidator(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	var (
+		connected    = make(chan Interface, 1)
+		received     = make(chan *wrp.Message, 2)
+		disconnected = make(chan struct{})
+		options      = &Options{
+			Logger:           logging.TestLogger(t),
+			Metrics:          registry,
+			MessageValidator: wrp.Validators{wrp.NewRequiredFieldsValidator(), wrp.NewMaxPayloadValidator(10)},
+			Listeners: []Listener{
+				func(event *Event) {
+					switch event.Type {
+					case Connect:
+						connected <- event.Device
+					case MessageReceived:
+						received <- event.Message.(*wrp.Message)
+					case Disconnect:
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	c, _, err := NewDialer(options, nil).Dial(connectURL, ID("mac:112233445566"), nil, nil)
+	require.NoError(err)
+	<-connected
+
+	// only the last message is valid
+	for _, message := range []*wrp.Message{
+		{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566"},
+		{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Destination: "event:test", Payload: []byte("this payload is too large")},
+		{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Destination: "event:test", Payload: []byte("valid")},
+	} {
+		var encoded []byte
+		require.NoError(wrp.NewEncoderBytes(&encoded, c.Format()).Encode(message))
+		_, err = c.Write(encoded)
+		require.NoError(err)
+	}
+
+	select {
+	case message := <-received:
+		assert.Equal("valid", string(message.Payload))
+	case <-time.After(5 * time.Second):
+		require.Fail("The valid message was not received")
+	}
+
+	c.Close()
+	<-disconnected
+	assert.Empty(received)
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, InvalidMessageCount+" 2"), body)
+}
@@ -0,0 +1,7 @@
+/*
+Package fact provides typed accessors for the cross-cutting values that WebPA components
+carry around in a context.Context.  Each value has a Set function that produces a new
+context and a getter that returns the value along with whether it was present.  Callers
+never need to know the key or perform type assertions on context values themselves.
+*/
+package fact
@@ -0,0 +1,118 @@
+package fact
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/SermoDigital/jose/jwt"
+)
+
+// contextKey is the unexported key type for all values managed by this package.  Using
+// a distinct type guarantees that no other package can collide with these keys.
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	deviceIDKey
+	conveyKey
+	claimsKey
+	principalKey
+	requestIDKey
+	methodKey
+	pathKey
+)
+
+// SetLogger returns a new context carrying the given logger
+func SetLogger(ctx context.Context, logger logging.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the logger associated with the context.  If no logger has been
+// set, logging.DefaultLogger() is returned so that callers can always log.
+func Logger(ctx context.Context) logging.Logger {
+	if logger, ok := ctx.Value(loggerKey).(logging.Logger); ok && logger != nil {
+		return logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+// SetDeviceID returns a new context carrying the given device identifier.  A device.ID
+// can be passed by converting it to a string.
+func SetDeviceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, deviceIDKey, id)
+}
+
+// DeviceID returns the device identifier associated with the context, if any
+func DeviceID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(deviceIDKey).(string)
+	return id, ok
+}
+
+// SetConvey returns a new context carrying the given convey map.  A device.Convey may be
+// passed as is.
+func SetConvey(ctx context.Context, convey map[string]interface{}) context.Context {
+	return context.WithValue(ctx, conveyKey, convey)
+}
+
+// Convey returns the device convey map associated with the context, if any
+func Convey(ctx context.Context) (map[string]interface{}, bool) {
+	convey, ok := ctx.Value(conveyKey).(map[string]interface{})
+	return convey, ok
+}
+
+// SetClaims returns a new context carrying the claims of an authenticated token
+func SetClaims(ctx context.Context, claims jwt.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// Claims returns the claims of the token that authenticated the request, if any.  Nil claims
+// stored in the context are treated as missing.
+func Claims(ctx context.Context) (jwt.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(jwt.Claims)
+	return claims, ok && claims != nil
+}
+
+// SetPrincipal returns a new context carrying the given principal, which is typically
+// the subject of an authenticated token.
+func SetPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// Principal returns the principal associated with the context, if any
+func Principal(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey).(string)
+	return principal, ok
+}
+
+// SetRequestID returns a new context carrying the given request identifier
+func SetRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the request identifier associated with the context, if any
+func RequestID(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey).(string)
+	return requestID, ok
+}
+
+// SetMethod returns a new context carrying the HTTP method of the request being authorized
+func SetMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, methodKey, method)
+}
+
+// Method returns the HTTP method associated with the context, if any
+func Method(ctx context.Context) (string, bool) {
+	method, ok := ctx.Value(methodKey).(string)
+	return method, ok
+}
+
+// SetPath returns a new context carrying the URL path of the request being authorized
+func SetPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, pathKey, path)
+}
+
+// Path returns the URL path associated with the context, if any
+func Path(ctx context.Context) (string, bool) {
+	path, ok := ctx.Value(pathKey).(string)
+	return path, ok
+}
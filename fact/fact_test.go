@@ -0,0 +1,140 @@
+package fact
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(logging.DefaultLogger(), Logger(context.Background()))
+
+	expected := logging.TestLogger(t)
+	assert.Equal(expected, Logger(SetLogger(context.Background(), expected)))
+	assert.Equal(logging.DefaultLogger(), Logger(SetLogger(context.Background(), nil)))
+}
+
+func TestDeviceID(t *testing.T) {
+	assert := assert.New(t)
+
+	id, ok := DeviceID(context.Background())
+	assert.Empty(id)
+	assert.False(ok)
+
+	id, ok = DeviceID(SetDeviceID(context.Background(), "mac:112233445566"))
+	assert.Equal("mac:112233445566", id)
+	assert.True(ok)
+
+	// a plain string under some other key must not be mistaken for a device ID
+	id, ok = DeviceID(context.WithValue(context.Background(), "deviceID", "mac:112233445566"))
+	assert.Empty(id)
+	assert.False(ok)
+}
+
+func TestConvey(t *testing.T) {
+	assert := assert.New(t)
+
+	convey, ok := Convey(context.Background())
+	assert.Nil(convey)
+	assert.False(ok)
+
+	expected := map[string]interface{}{"foo": "bar"}
+	convey, ok = Convey(SetConvey(context.Background(), expected))
+	assert.Equal(expected, convey)
+	assert.True(ok)
+}
+
+func TestClaims(t *testing.T) {
+	assert := assert.New(t)
+
+	claims, ok := Claims(context.Background())
+	assert.Nil(claims)
+	assert.False(ok)
+
+	claims, ok = Claims(SetClaims(context.Background(), nil))
+	assert.Nil(claims)
+	assert.False(ok)
+
+	claims, ok = Claims(SetClaims(context.Background(), jwt.Claims{"sub": "test"}))
+	assert.Equal(jwt.Claims{"sub": "test"}, claims)
+	assert.True(ok)
+}
+
+func TestPrincipal(t *testing.T) {
+	assert := assert.New(t)
+
+	principal, ok := Principal(context.Background())
+	assert.Empty(principal)
+	assert.False(ok)
+
+	principal, ok = Principal(SetPrincipal(context.Background(), "joe"))
+	assert.Equal("joe", principal)
+	assert.True(ok)
+}
+
+func TestRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	requestID, ok := RequestID(context.Background())
+	assert.Empty(requestID)
+	assert.False(ok)
+
+	requestID, ok = RequestID(SetRequestID(context.Background(), "abc-123"))
+	assert.Equal("abc-123", requestID)
+	assert.True(ok)
+}
+
+func TestMethod(t *testing.T) {
+	assert := assert.New(t)
+
+	method, ok := Method(context.Background())
+	assert.Empty(method)
+	assert.False(ok)
+
+	method, ok = Method(SetMethod(context.Background(), "POST"))
+	assert.Equal("POST", method)
+	assert.True(ok)
+
+	// the untyped keys that callers used to set must not be picked up
+	method, ok = Method(context.WithValue(context.Background(), "method", "POST"))
+	assert.Empty(method)
+	assert.False(ok)
+}
+
+func TestPath(t *testing.T) {
+	assert := assert.New(t)
+
+	path, ok := Path(context.Background())
+	assert.Empty(path)
+	assert.False(ok)
+
+	path, ok = Path(SetPath(context.Background(), "/api/v2/device"))
+	assert.Equal("/api/v2/device", path)
+	assert.True(ok)
+
+	path, ok = Path(context.WithValue(context.Background(), "path", "/api/v2/device"))
+	assert.Empty(path)
+	assert.False(ok)
+}
+
+func TestKeysDoNotCollide(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := SetPrincipal(SetRequestID(context.Background(), "request"), "principal")
+	ctx = SetPath(SetMethod(SetDeviceID(ctx, "mac:112233445566"), "GET"), "/api")
+
+	requestID, _ := RequestID(ctx)
+	principal, _ := Principal(ctx)
+	id, _ := DeviceID(ctx)
+	method, _ := Method(ctx)
+	path, _ := Path(ctx)
+	assert.Equal("request", requestID)
+	assert.Equal("principal", principal)
+	assert.Equal("mac:112233445566", id)
+	assert.Equal("GET", method)
+	assert.Equal("/api", path)
+}
@@ -0,0 +1,52 @@
+package gate
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff is a hint sent along with an overload rejection to shape client retry behavior
+type Backoff struct {
+	// RetryAfter is the suggested delay before the client retries.  It is sent as the
+	// Retry-After header, rounded up to whole seconds.  If not positive, no delay is suggested.
+	RetryAfter time.Duration
+
+	// Alternate is an optional endpoint the client may try instead of this server
+	Alternate string
+}
+
+// Seconds returns RetryAfter rounded up to whole seconds, or zero if no delay is suggested
+func (b Backoff) Seconds() int {
+	if b.RetryAfter <= 0 {
+		return 0
+	}
+
+	return int((b.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// WriteHeader sets the Retry-After header when a delay is suggested
+func (b Backoff) WriteHeader(header http.Header) {
+	if seconds := b.Seconds(); seconds > 0 {
+		header.Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
+
+// BackoffPolicy computes the Backoff hint for work rejected due to overload
+type BackoffPolicy interface {
+	Backoff(*http.Request) Backoff
+}
+
+// BackoffPolicyFunc is a function type that implements BackoffPolicy
+type BackoffPolicyFunc func(*http.Request) Backoff
+
+func (f BackoffPolicyFunc) Backoff(request *http.Request) Backoff {
+	return f(request)
+}
+
+// FixedBackoff is a BackoffPolicy that always suggests the same delay and no alternate endpoint
+type FixedBackoff time.Duration
+
+func (f FixedBackoff) Backoff(*http.Request) Backoff {
+	return Backoff{RetryAfter: time.Duration(f)}
+}
@@ -0,0 +1,57 @@
+package gate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackoffSeconds(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		testData = []struct {
+			retryAfter time.Duration
+			expected   int
+		}{
+			{0, 0},
+			{-time.Second, 0},
+			{time.Millisecond, 1},
+			{time.Second, 1},
+			{1500 * time.Millisecond, 2},
+			{time.Minute, 60},
+		}
+	)
+
+	for _, record := range testData {
+		assert.Equal(record.expected, Backoff{RetryAfter: record.retryAfter}.Seconds(), record.retryAfter.String())
+	}
+}
+
+func TestBackoffWriteHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	header := make(http.Header)
+	Backoff{}.WriteHeader(header)
+	assert.Empty(header.Get("Retry-After"))
+
+	Backoff{RetryAfter: 90 * time.Second}.WriteHeader(header)
+	assert.Equal("90", header.Get("Retry-After"))
+}
+
+func TestBackoffPolicies(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = httptest.NewRequest("GET", "/", nil)
+	)
+
+	assert.Equal(Backoff{RetryAfter: time.Minute}, FixedBackoff(time.Minute).Backoff(request))
+
+	policy := BackoffPolicyFunc(func(actual *http.Request) Backoff {
+		assert.Equal(request, actual)
+		return Backoff{Alternate: "http://alternate.com"}
+	})
+
+	assert.Equal(Backoff{Alternate: "http://alternate.com"}, policy.Backoff(request))
+}
@@ -0,0 +1,8 @@
+/*
+Package gate provides a global traffic gate.  While a gate is closed, servers reject new inbound
+work with a 503 and the reason the gate was closed.  Work already in progress is not affected.
+
+Gates are typically closed by operators, through the admin Handler, for maintenance or emergency
+load shedding.
+*/
+package gate
@@ -0,0 +1,104 @@
+package gate
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultReason = "The server is not accepting new traffic"
+)
+
+var (
+	ErrorClosed = errors.New("The gate is closed")
+)
+
+// Status is a snapshot of a gate's state
+type Status struct {
+	// Open indicates whether the gate is accepting new traffic
+	Open bool `json:"open"`
+
+	// Reason is the operator-supplied explanation for why the gate was closed.
+	// This field is empty when the gate is open.
+	Reason string `json:"reason,omitempty"`
+
+	// Since is the time at which the gate last changed state
+	Since time.Time `json:"since"`
+}
+
+// reason returns the explanation for rejected work, substituting DefaultReason when necessary
+func (s Status) reason() string {
+	if len(s.Reason) > 0 {
+		return s.Reason
+	}
+
+	return DefaultReason
+}
+
+// Interface represents a traffic gate.  Implementations are safe for concurrent use.
+type Interface interface {
+	// Status returns a snapshot of this gate's current state
+	Status() Status
+
+	// IsOpen is a convenience for Status().Open
+	IsOpen() bool
+
+	// Open allows new traffic.  This method returns true if the gate changed state.
+	Open() bool
+
+	// Close rejects new traffic for the given reason.  This method returns true if the gate
+	// changed state.  Closing an already closed gate updates the reason but is not a state change.
+	Close(reason string) bool
+}
+
+// New constructs a gate in the given initial state
+func New(open bool) Interface {
+	g := &gate{now: time.Now}
+	g.status.Store(Status{Open: open, Since: g.now()})
+	return g
+}
+
+// gate is the internal Interface implementation.  Reads are lock-free, while
+// writes are serialized so that state transitions are reported accurately.
+type gate struct {
+	lock   sync.Mutex
+	status atomic.Value
+	now    func() time.Time
+}
+
+func (g *gate) Status() Status {
+	return g.status.Load().(Status)
+}
+
+func (g *gate) IsOpen() bool {
+	return g.Status().Open
+}
+
+func (g *gate) Open() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.Status().Open {
+		return false
+	}
+
+	g.status.Store(Status{Open: true, Since: g.now()})
+	return true
+}
+
+func (g *gate) Close(reason string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	current := g.Status()
+	if !current.Open {
+		current.Reason = reason
+		g.status.Store(current)
+		return false
+	}
+
+	g.status.Store(Status{Open: false, Reason: reason, Since: g.now()})
+	return true
+}
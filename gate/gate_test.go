@@ -0,0 +1,81 @@
+package gate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	for _, open := range []bool{true, false} {
+		g := New(open)
+		assert.Equal(open, g.IsOpen())
+
+		status := g.Status()
+		assert.Equal(open, status.Open)
+		assert.Equal("", status.Reason)
+		assert.False(status.Since.IsZero())
+	}
+}
+
+func TestGate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		g       = New(true).(*gate)
+		current = time.Unix(1500000000, 0)
+	)
+
+	g.now = func() time.Time { return current }
+
+	assert.False(g.Open())
+	assert.True(g.IsOpen())
+
+	assert.True(g.Close("maintenance"))
+	assert.Equal(Status{Open: false, Reason: "maintenance", Since: current}, g.Status())
+
+	closedAt := current
+	current = current.Add(time.Minute)
+	assert.False(g.Close("emergency"))
+	assert.Equal(Status{Open: false, Reason: "emergency", Since: closedAt}, g.Status())
+
+	assert.True(g.Open())
+	assert.Equal(Status{Open: true, Since: current}, g.Status())
+}
+
+func TestGateConcurrency(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		g       = New(true)
+		changes = make(chan bool, 100)
+		wait    = new(sync.WaitGroup)
+	)
+
+	for i := 0; i < cap(changes); i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			changes <- g.Close("concurrent")
+		}()
+	}
+
+	wait.Wait()
+	close(changes)
+
+	count := 0
+	for changed := range changes {
+		if changed {
+			count++
+		}
+	}
+
+	assert.Equal(1, count)
+	assert.False(g.IsOpen())
+}
+
+func TestStatusReason(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(DefaultReason, Status{}.reason())
+	assert.Equal("custom", Status{Reason: "custom"}.reason())
+}
@@ -0,0 +1,80 @@
+package gate
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/httperror"
+	"github.com/Comcast/webpa-common/logging"
+	"net/http"
+	"strconv"
+)
+
+const (
+	OpenParameter   = "open"
+	ReasonParameter = "reason"
+)
+
+// Handler is the admin endpoint for a gate.  A GET returns the gate's Status as JSON.
+// A PUT or POST changes the gate's state using the open and reason form parameters,
+// e.g. PUT /gate?open=false&reason=maintenance, and returns the resulting Status.
+type Handler struct {
+	// Gate is the traffic gate being administered.  This field is required.
+	Gate Interface
+
+	// Logger receives a message for each state change.  If nil, logging.DefaultLogger() is used.
+	Logger logging.Logger
+}
+
+func (h *Handler) logger() logging.Logger {
+	if h.Logger != nil {
+		return h.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+func (h *Handler) writeStatus(response http.ResponseWriter) {
+	data, err := json.Marshal(h.Gate.Status())
+	if err != nil {
+		httperror.Formatf(response, http.StatusInternalServerError, "Unable to marshal gate status: %s", err)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(data)
+}
+
+func (h *Handler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		h.writeStatus(response)
+
+	case http.MethodPut, http.MethodPost:
+		if err := request.ParseForm(); err != nil {
+			httperror.Formatf(response, http.StatusBadRequest, "Unable to parse form: %s", err)
+			return
+		}
+
+		open, err := strconv.ParseBool(request.Form.Get(OpenParameter))
+		if err != nil {
+			httperror.Formatf(response, http.StatusBadRequest, "Invalid %s parameter: %s", OpenParameter, err)
+			return
+		}
+
+		if open {
+			if h.Gate.Open() {
+				h.logger().Info("Gate opened by %s", request.RemoteAddr)
+			}
+		} else {
+			reason := request.Form.Get(ReasonParameter)
+			if h.Gate.Close(reason) {
+				h.logger().Info("Gate closed by %s: %s", request.RemoteAddr, reason)
+			}
+		}
+
+		h.writeStatus(response)
+
+	default:
+		response.Header().Set("Allow", "GET, PUT, POST")
+		httperror.Formatf(response, http.StatusMethodNotAllowed, "Method %s is not allowed", request.Method)
+	}
+}
@@ -0,0 +1,78 @@
+package gate
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerGet(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		g        = New(false)
+		handler  = Handler{Gate: g, Logger: logging.TestLogger(t)}
+		response = httptest.NewRecorder()
+		actual   Status
+	)
+
+	g.Close("maintenance")
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/gate", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("application/json", response.Header().Get("Content-Type"))
+
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &actual))
+	assert.False(actual.Open)
+	assert.Equal("maintenance", actual.Reason)
+}
+
+func TestHandlerUpdate(t *testing.T) {
+	testData := []struct {
+		method         string
+		target         string
+		body           string
+		expectedCode   int
+		expectedOpen   bool
+		expectedReason string
+	}{
+		{"PUT", "/gate?open=false&reason=maintenance", "", http.StatusOK, false, "maintenance"},
+		{"POST", "/gate", "open=false&reason=emergency", http.StatusOK, false, "emergency"},
+		{"PUT", "/gate?open=true", "", http.StatusOK, true, ""},
+		{"PUT", "/gate?open=true&reason=ignored", "", http.StatusOK, true, ""},
+		{"PUT", "/gate", "", http.StatusBadRequest, true, ""},
+		{"PUT", "/gate?open=notabool", "", http.StatusBadRequest, true, ""},
+		{"DELETE", "/gate", "", http.StatusMethodNotAllowed, true, ""},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+
+		var (
+			assert   = assert.New(t)
+			g        = New(true)
+			handler  = Handler{Gate: g, Logger: logging.TestLogger(t)}
+			response = httptest.NewRecorder()
+			request  = httptest.NewRequest(record.method, record.target, strings.NewReader(record.body))
+		)
+
+		if len(record.body) > 0 {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+
+		handler.ServeHTTP(response, request)
+		assert.Equal(record.expectedCode, response.Code)
+		assert.Equal(record.expectedOpen, g.IsOpen())
+		assert.Equal(record.expectedReason, g.Status().Reason)
+
+		if record.expectedCode == http.StatusOK {
+			var actual Status
+			assert.NoError(json.Unmarshal(response.Body.Bytes(), &actual))
+			assert.Equal(record.expectedOpen, actual.Open)
+		}
+	}
+}
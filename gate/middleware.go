@@ -0,0 +1,74 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// rejectionBody is the JSON body written for work refused by a closed gate
+type rejectionBody struct {
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
+	Alternate  string `json:"alternate,omitempty"`
+}
+
+// Reject writes the standard response for work refused by a closed gate:  a 503 with the
+// gate's reason in a JSON body.  This function is exported so that other packages, such as
+// device, can reject work in exactly the same way.
+func Reject(response http.ResponseWriter, status Status) {
+	RejectWithBackoff(response, status, Backoff{})
+}
+
+// RejectWithBackoff is like Reject, but includes a backoff hint.  The Retry-After header is
+// set when a delay is suggested, and the JSON body carries retryAfter and alternate members
+// when they have values.
+func RejectWithBackoff(response http.ResponseWriter, status Status, backoff Backoff) {
+	body, _ := json.Marshal(rejectionBody{
+		Code:       http.StatusServiceUnavailable,
+		Message:    status.reason(),
+		RetryAfter: backoff.Seconds(),
+		Alternate:  backoff.Alternate,
+	})
+
+	backoff.WriteHeader(response.Header())
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusServiceUnavailable)
+	response.Write(body)
+}
+
+// Middleware is an Alice-style decorator that rejects requests while its gate is closed
+type Middleware struct {
+	// Gate is the traffic gate consulted for each request.  If nil, requests are never rejected.
+	Gate Interface
+
+	// Backoff is the optional policy used to compute retry hints for rejected requests.
+	// If nil, rejections carry no hints.
+	Backoff BackoffPolicy
+}
+
+// Then decorates the delegate so that it only receives requests while the gate is open
+func (m *Middleware) Then(delegate http.Handler) http.Handler {
+	if m == nil || m.Gate == nil {
+		return delegate
+	}
+
+	var (
+		g      = m.Gate
+		policy = m.Backoff
+	)
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if status := g.Status(); !status.Open {
+			var backoff Backoff
+			if policy != nil {
+				backoff = policy.Backoff(request)
+			}
+
+			RejectWithBackoff(response, status, backoff)
+			return
+		}
+
+		delegate.ServeHTTP(response, request)
+	})
+}
@@ -0,0 +1,85 @@
+package gate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareNoGate(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		delegate = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	)
+
+	for _, m := range []*Middleware{nil, new(Middleware)} {
+		assert.NotNil(m.Then(delegate))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		g          = New(true)
+		middleware = Middleware{Gate: g}
+		called     = 0
+
+		handler = middleware.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			called++
+			response.WriteHeader(http.StatusAccepted)
+		}))
+	)
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusAccepted, response.Code)
+	assert.Equal(1, called)
+
+	g.Close("maintenance")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.JSONEq(`{"code": 503, "message": "maintenance"}`, response.Body.String())
+	assert.Equal(1, called)
+
+	g.Close("")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Contains(response.Body.String(), DefaultReason)
+
+	g.Open()
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusAccepted, response.Code)
+	assert.Equal(2, called)
+}
+
+func TestMiddlewareBackoff(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		g          = New(false)
+		middleware = Middleware{
+			Gate: g,
+			Backoff: BackoffPolicyFunc(func(*http.Request) Backoff {
+				return Backoff{RetryAfter: 30 * time.Second, Alternate: "http://alternate.com"}
+			}),
+		}
+
+		handler = middleware.Then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			assert.Fail("The delegate should not have been called")
+		}))
+	)
+
+	g.Close("overloaded")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Equal("30", response.HeaderMap.Get("Retry-After"))
+	assert.JSONEq(
+		`{"code": 503, "message": "overloaded", "retryAfter": 30, "alternate": "http://alternate.com"}`,
+		response.Body.String(),
+	)
+}
@@ -0,0 +1,272 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/logging"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAggregationInterval = 15 * time.Second
+)
+
+// Status is the overall condition of a server, as determined by an Aggregator's thresholds
+type Status string
+
+const (
+	StatusOK       Status = "OK"
+	StatusWarn     Status = "WARN"
+	StatusCritical Status = "CRITICAL"
+)
+
+// severity orders statuses from best to worst
+var severity = map[Status]int{
+	StatusOK:       0,
+	StatusWarn:     1,
+	StatusCritical: 2,
+}
+
+// worse returns the more severe of two statuses
+func worse(left, right Status) Status {
+	if severity[right] > severity[left] {
+		return right
+	}
+
+	return left
+}
+
+// Source contributes stats to each aggregation, e.g. the count of connected devices or of
+// discovered service endpoints.  Collect is invoked on the Aggregator's goroutine, and should
+// return quickly.
+type Source interface {
+	Collect(Stats)
+}
+
+// SourceFunc is a function type that implements Source
+type SourceFunc func(Stats)
+
+func (f SourceFunc) Collect(stats Stats) {
+	f(stats)
+}
+
+// Threshold determines the Status of a single Stat.  A value at or beyond Critical is StatusCritical,
+// otherwise a value at or beyond Warn is StatusWarn.  Set Warn equal to Critical for a stat that has
+// no warning level.
+type Threshold struct {
+	Warn     int
+	Critical int
+
+	// Below reverses the comparisons, for stats where low values are unhealthy, such as a count of endpoints
+	Below bool
+}
+
+// status returns the Status of the given value under this threshold
+func (t Threshold) status(value int) Status {
+	beyond := func(limit int) bool {
+		if t.Below {
+			return value <= limit
+		}
+
+		return value >= limit
+	}
+
+	switch {
+	case beyond(t.Critical):
+		return StatusCritical
+	case beyond(t.Warn):
+		return StatusWarn
+	default:
+		return StatusOK
+	}
+}
+
+// Violation describes a stat whose value crossed its threshold
+type Violation struct {
+	Stat   Stat   `json:"stat"`
+	Value  int    `json:"value"`
+	Status Status `json:"status"`
+}
+
+// Report is the outcome of one aggregation
+type Report struct {
+	// Status is the most severe status of any stat with a threshold, or StatusOK if there are no violations
+	Status Status `json:"status"`
+
+	// Time is when the aggregation ran
+	Time time.Time `json:"time"`
+
+	// Stats holds the values collected from every source
+	Stats Stats `json:"stats"`
+
+	// Violations lists the stats that crossed their thresholds, ordered by stat
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// Aggregator periodically collects stats from a set of Sources and classifies them against thresholds,
+// producing a Report that is served as JSON.  The collected stats are also published to a Monitor, so
+// that they appear alongside the stats a Health tracks itself.
+type Aggregator struct {
+	monitor  Monitor
+	logger   logging.Logger
+	interval time.Duration
+
+	lock       sync.Mutex
+	sources    []Source
+	thresholds map[Stat]Threshold
+	report     *Report
+	once       sync.Once
+}
+
+// NewAggregator creates an Aggregator that publishes to the given Monitor.  If interval is not positive,
+// DefaultAggregationInterval is used.  The Monitor may be nil, in which case the stats are only available
+// via Report and ServeHTTP.
+func NewAggregator(monitor Monitor, logger logging.Logger, interval time.Duration) *Aggregator {
+	if logger == nil {
+		logger = logging.DefaultLogger()
+	}
+
+	if interval <= 0 {
+		interval = DefaultAggregationInterval
+	}
+
+	return &Aggregator{
+		monitor:    monitor,
+		logger:     logger,
+		interval:   interval,
+		thresholds: make(map[Stat]Threshold),
+	}
+}
+
+// Register adds a Source to this aggregator.  Sources are collected in the order they were registered,
+// so a later source overwrites any stat reported by an earlier one.
+func (a *Aggregator) Register(source Source) {
+	a.lock.Lock()
+	a.sources = append(a.sources, source)
+	a.lock.Unlock()
+}
+
+// SetThreshold establishes the Threshold for a stat, replacing any previous threshold for that stat.
+// A stat that no source reports is never in violation.
+func (a *Aggregator) SetThreshold(stat Stat, threshold Threshold) {
+	a.lock.Lock()
+	a.thresholds[stat] = threshold
+	a.lock.Unlock()
+}
+
+// collect invokes a single source.  A misbehaving source must not prevent the others from being collected.
+func (a *Aggregator) collect(source Source, stats Stats) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.ReportPanic(a.logger, "Health source panicked", r)
+		}
+	}()
+
+	source.Collect(stats)
+}
+
+// Aggregate collects every registered source once, publishing and returning the resulting Report
+func (a *Aggregator) Aggregate() *Report {
+	a.lock.Lock()
+	sources := append([]Source(nil), a.sources...)
+	thresholds := make(map[Stat]Threshold, len(a.thresholds))
+	for stat, threshold := range a.thresholds {
+		thresholds[stat] = threshold
+	}
+
+	a.lock.Unlock()
+
+	report := &Report{
+		Status: StatusOK,
+		Time:   time.Now(),
+		Stats:  make(Stats),
+	}
+
+	for _, source := range sources {
+		a.collect(source, report.Stats)
+	}
+
+	for stat, threshold := range thresholds {
+		value, ok := report.Stats[stat]
+		if !ok {
+			continue
+		}
+
+		if status := threshold.status(value); status != StatusOK {
+			report.Violations = append(report.Violations, Violation{Stat: stat, Value: value, Status: status})
+			report.Status = worse(report.Status, status)
+		}
+	}
+
+	sort.Slice(report.Violations, func(i, j int) bool { return report.Violations[i].Stat < report.Violations[j].Stat })
+	for _, v := range report.Violations {
+		a.logger.Warn("Health stat %s is %s with value %d", v.Stat, v.Status, v.Value)
+	}
+
+	if a.monitor != nil {
+		a.monitor.SendEvent(report.Stats.Clone().Set)
+	}
+
+	a.lock.Lock()
+	a.report = report
+	a.lock.Unlock()
+	return report
+}
+
+// Report returns the most recent Report, or nil if no aggregation has run yet.  The returned
+// Report must not be modified.
+func (a *Aggregator) Report() *Report {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.report
+}
+
+// Run starts aggregating at this aggregator's interval, beginning immediately.  This method is
+// idempotent:  once an Aggregator is Run, it cannot be Run again.
+func (a *Aggregator) Run(waitGroup *sync.WaitGroup, shutdown <-chan struct{}) error {
+	a.once.Do(func() {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			ticker := time.NewTicker(a.interval)
+			defer ticker.Stop()
+
+			a.Aggregate()
+			for {
+				select {
+				case <-shutdown:
+					return
+				case <-ticker.C:
+					a.Aggregate()
+				}
+			}
+		}()
+	})
+
+	return nil
+}
+
+// ServeHTTP writes the most recent Report as JSON, aggregating first if no aggregation has run yet.
+// The response status is 503 while the Report's Status is StatusCritical, and 200 otherwise.
+func (a *Aggregator) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	report := a.Report()
+	if report == nil {
+		report = a.Aggregate()
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		a.logger.Error("Could not marshal health report: %s", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	if report.Status == StatusCritical {
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	response.Write(data)
+}
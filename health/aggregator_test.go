@@ -0,0 +1,175 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThreshold(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		testData = []struct {
+			threshold Threshold
+			value     int
+			expected  Status
+		}{
+			{Threshold{Warn: 10, Critical: 20}, 0, StatusOK},
+			{Threshold{Warn: 10, Critical: 20}, 10, StatusWarn},
+			{Threshold{Warn: 10, Critical: 20}, 19, StatusWarn},
+			{Threshold{Warn: 10, Critical: 20}, 20, StatusCritical},
+			{Threshold{Warn: 20, Critical: 20}, 19, StatusOK},
+			{Threshold{Warn: 20, Critical: 20}, 25, StatusCritical},
+			{Threshold{Warn: 5, Critical: 1, Below: true}, 10, StatusOK},
+			{Threshold{Warn: 5, Critical: 1, Below: true}, 5, StatusWarn},
+			{Threshold{Warn: 5, Critical: 1, Below: true}, 0, StatusCritical},
+		}
+	)
+
+	for _, record := range testData {
+		assert.Equal(record.expected, record.threshold.status(record.value), "%#v", record)
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		monitor    = &recordingMonitor{stats: make(Stats)}
+		aggregator = NewAggregator(monitor, logging.TestLogger(t), 0)
+		devices    = 100
+	)
+
+	assert.Equal(DefaultAggregationInterval, aggregator.interval)
+	assert.Nil(aggregator.Report())
+
+	aggregator.Register(SourceFunc(func(stats Stats) { stats["Devices"] = devices }))
+	aggregator.Register(SourceFunc(func(Stats) { panic("the other sources should still be collected") }))
+	aggregator.Register(SourceFunc(func(stats Stats) { stats["Endpoints"] = 3 }))
+	aggregator.SetThreshold("Devices", Threshold{Warn: 200, Critical: 500})
+	aggregator.SetThreshold("Endpoints", Threshold{Warn: 2, Critical: 0, Below: true})
+	aggregator.SetThreshold("Missing", Threshold{Warn: 0, Critical: 0})
+
+	report := aggregator.Aggregate()
+	require.NotNil(report)
+	assert.Equal(StatusOK, report.Status)
+	assert.Equal(Stats{"Devices": 100, "Endpoints": 3}, report.Stats)
+	assert.Empty(report.Violations)
+	assert.Equal(report, aggregator.Report())
+	assert.Equal(Stats{"Devices": 100, "Endpoints": 3}, monitor.Stats())
+
+	devices = 300
+	report = aggregator.Aggregate()
+	assert.Equal(StatusWarn, report.Status)
+	assert.Equal([]Violation{{Stat: "Devices", Value: 300, Status: StatusWarn}}, report.Violations)
+
+	devices = 600
+	aggregator.SetThreshold("Endpoints", Threshold{Warn: 5, Critical: 3, Below: true})
+	report = aggregator.Aggregate()
+	assert.Equal(StatusCritical, report.Status)
+	assert.Equal(
+		[]Violation{
+			{Stat: "Devices", Value: 600, Status: StatusCritical},
+			{Stat: "Endpoints", Value: 3, Status: StatusCritical},
+		},
+		report.Violations,
+	)
+
+	assert.Equal(Stats{"Devices": 600, "Endpoints": 3}, monitor.Stats())
+}
+
+func TestAggregatorServeHTTP(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		aggregator = NewAggregator(nil, logging.TestLogger(t), time.Minute)
+		value      = 1
+	)
+
+	aggregator.Register(SourceFunc(func(stats Stats) { stats["Value"] = value }))
+	aggregator.SetThreshold("Value", Threshold{Warn: 5, Critical: 10})
+
+	// the first request aggregates, since nothing has run yet
+	response := httptest.NewRecorder()
+	aggregator.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+
+	var report Report
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &report))
+	assert.Equal(StatusOK, report.Status)
+	assert.Equal(Stats{"Value": 1}, report.Stats)
+
+	// subsequent requests serve the most recent report
+	value = 10
+	response = httptest.NewRecorder()
+	aggregator.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusOK, response.Code)
+
+	aggregator.Aggregate()
+	response = httptest.NewRecorder()
+	aggregator.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+
+	report = Report{}
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &report))
+	assert.Equal(StatusCritical, report.Status)
+	assert.Equal([]Violation{{Stat: "Value", Value: 10, Status: StatusCritical}}, report.Violations)
+}
+
+func TestAggregatorRun(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		aggregator = NewAggregator(nil, logging.TestLogger(t), time.Millisecond)
+		collected  = make(chan struct{}, 10)
+
+		waitGroup = new(sync.WaitGroup)
+		shutdown  = make(chan struct{})
+	)
+
+	aggregator.Register(SourceFunc(func(Stats) {
+		select {
+		case collected <- struct{}{}:
+		default:
+		}
+	}))
+
+	assert.NoError(aggregator.Run(waitGroup, shutdown))
+	assert.NoError(aggregator.Run(waitGroup, shutdown))
+	for i := 0; i < 3; i++ {
+		select {
+		case <-collected:
+		case <-time.After(5 * time.Second):
+			assert.Fail("The aggregator did not run")
+		}
+	}
+
+	close(shutdown)
+	waitGroup.Wait()
+}
+
+func TestCheckRegistryCollect(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		registry = NewCheckRegistry(nil, logging.TestLogger(t), 0)
+		stats    = make(Stats)
+	)
+
+	registry.Register("Passing", CheckFunc(func(context.Context) error { return nil }), 0)
+	registry.Register("Failing", CheckFunc(func(context.Context) error { return errors.New("expected") }), 0)
+
+	registry.Collect(stats)
+	assert.Empty(stats)
+
+	registry.RunChecks(context.Background())
+	registry.Collect(stats)
+	assert.Equal(Stats{"Passing": CheckPassed, "Failing": CheckFailed}, stats)
+}
@@ -0,0 +1,279 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCheckInterval = 30 * time.Second
+	DefaultCheckTimeout  = 5 * time.Second
+
+	// CheckPassed and CheckFailed are the values of a check's Stat
+	CheckPassed = 1
+	CheckFailed = 0
+)
+
+var (
+	ErrorCheckTimeout = errors.New("The health check did not complete in time")
+)
+
+// Check tests a single dependency, such as a Zookeeper session or a Redis server.  Implementations
+// should honor the context's deadline, but a Check that ignores it is still abandoned once its timeout elapses.
+type Check interface {
+	Check(context.Context) error
+}
+
+// CheckFunc is a function type that implements Check
+type CheckFunc func(context.Context) error
+
+func (f CheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HTTPCheck is a Check which issues a request to a URL.  Any response with a status
+// below 400 passes the check.
+type HTTPCheck struct {
+	// URL is the location that is requested
+	URL string
+
+	// Method is the HTTP method used.  If unset, HEAD is used.
+	Method string
+
+	// Header holds any additional headers sent with the request
+	Header http.Header
+
+	// Client is the HTTP client used to send the request.  If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (hc *HTTPCheck) Check(ctx context.Context) error {
+	method := hc.Method
+	if len(method) == 0 {
+		method = "HEAD"
+	}
+
+	request, err := http.NewRequest(method, hc.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	for name, values := range hc.Header {
+		request.Header[name] = values
+	}
+
+	client := hc.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	response.Body.Close()
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("%s returned status %d", hc.URL, response.StatusCode)
+	}
+
+	return nil
+}
+
+// registeredCheck is a Check along with its per-check configuration
+type registeredCheck struct {
+	check   Check
+	timeout time.Duration
+}
+
+// CheckRegistry periodically runs a set of Checks, publishing each outcome to a Monitor as a Stat with
+// the value CheckPassed or CheckFailed.  Each check is run with its own timeout, and all checks are run
+// concurrently so that one unresponsive dependency does not delay the others.
+type CheckRegistry struct {
+	monitor  Monitor
+	logger   logging.Logger
+	interval time.Duration
+
+	lock    sync.Mutex
+	checks  map[Stat]registeredCheck
+	results map[Stat]error
+	once    sync.Once
+}
+
+// NewCheckRegistry creates a CheckRegistry that publishes to the given Monitor.  If interval is
+// not positive, DefaultCheckInterval is used.  The Monitor may be nil, in which case outcomes
+// are only available via Results.
+func NewCheckRegistry(monitor Monitor, logger logging.Logger, interval time.Duration) *CheckRegistry {
+	if logger == nil {
+		logger = logging.DefaultLogger()
+	}
+
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+
+	return &CheckRegistry{
+		monitor:  monitor,
+		logger:   logger,
+		interval: interval,
+		checks:   make(map[Stat]registeredCheck),
+		results:  make(map[Stat]error),
+	}
+}
+
+// Register adds a Check under the given Stat, replacing any check previously registered with
+// that Stat.  If timeout is not positive, DefaultCheckTimeout is used.
+func (r *CheckRegistry) Register(stat Stat, check Check, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+
+	r.lock.Lock()
+	r.checks[stat] = registeredCheck{check: check, timeout: timeout}
+	r.lock.Unlock()
+}
+
+// checkOutcome is the result of running one registered check
+type checkOutcome struct {
+	stat Stat
+	err  error
+}
+
+// runCheck executes a single check, abandoning it if it does not complete within its timeout
+func runCheck(ctx context.Context, rc registeredCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result <- fmt.Errorf("The health check panicked: %v", r)
+			}
+		}()
+
+		result <- rc.check.Check(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ErrorCheckTimeout
+	}
+}
+
+// RunChecks runs every registered check once, publishing and returning the outcomes.
+// A nil error in the returned map indicates that the check passed.
+func (r *CheckRegistry) RunChecks(ctx context.Context) map[Stat]error {
+	r.lock.Lock()
+	checks := make(map[Stat]registeredCheck, len(r.checks))
+	for stat, rc := range r.checks {
+		checks[stat] = rc
+	}
+
+	r.lock.Unlock()
+
+	var (
+		waitGroup sync.WaitGroup
+		outcomes  = make(chan checkOutcome, len(checks))
+	)
+
+	for stat, rc := range checks {
+		waitGroup.Add(1)
+		go func(stat Stat, rc registeredCheck) {
+			defer waitGroup.Done()
+			outcomes <- checkOutcome{stat, runCheck(ctx, rc)}
+		}(stat, rc)
+	}
+
+	waitGroup.Wait()
+	close(outcomes)
+
+	results := make(map[Stat]error, len(checks))
+	for outcome := range outcomes {
+		results[outcome.stat] = outcome.err
+		value := CheckPassed
+		if outcome.err != nil {
+			value = CheckFailed
+			r.logger.Error("Health check %s failed: %s", outcome.stat, outcome.err)
+		}
+
+		if r.monitor != nil {
+			r.monitor.SendEvent(Set(outcome.stat, value))
+		}
+	}
+
+	r.lock.Lock()
+	for stat, err := range results {
+		r.results[stat] = err
+	}
+
+	r.lock.Unlock()
+	return results
+}
+
+// Results returns the most recent outcome of each check that has run at least once
+func (r *CheckRegistry) Results() map[Stat]error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	results := make(map[Stat]error, len(r.results))
+	for stat, err := range r.results {
+		results[stat] = err
+	}
+
+	return results
+}
+
+// Collect reports the most recent outcome of each check as CheckPassed or CheckFailed, which allows
+// a CheckRegistry to be registered as a Source with an Aggregator
+func (r *CheckRegistry) Collect(stats Stats) {
+	for stat, err := range r.Results() {
+		if err != nil {
+			stats[stat] = CheckFailed
+		} else {
+			stats[stat] = CheckPassed
+		}
+	}
+}
+
+// Run starts running checks at this registry's interval, beginning immediately.  This method is
+// idempotent:  once a CheckRegistry is Run, it cannot be Run again.
+func (r *CheckRegistry) Run(waitGroup *sync.WaitGroup, shutdown <-chan struct{}) error {
+	r.once.Do(func() {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			ticker := time.NewTicker(r.interval)
+			defer ticker.Stop()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				select {
+				case <-shutdown:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			r.RunChecks(ctx)
+			for {
+				select {
+				case <-shutdown:
+					return
+				case <-ticker.C:
+					r.RunChecks(ctx)
+				}
+			}
+		}()
+	})
+
+	return nil
+}
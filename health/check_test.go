@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMonitor is a Monitor that applies each event to its own Stats
+type recordingMonitor struct {
+	lock  sync.Mutex
+	stats Stats
+}
+
+func (m *recordingMonitor) SendEvent(healthFunc HealthFunc) {
+	m.lock.Lock()
+	healthFunc(m.stats)
+	m.lock.Unlock()
+}
+
+func (m *recordingMonitor) ServeHTTP(http.ResponseWriter, *http.Request) {
+}
+
+func (m *recordingMonitor) Stats() Stats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stats.Clone()
+}
+
+func TestCheckFunc(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		check         = CheckFunc(func(context.Context) error { return expectedError })
+	)
+
+	assert.Equal(expectedError, check.Check(context.Background()))
+}
+
+func TestHTTPCheck(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		methods = make(chan string, 2)
+		headers = make(chan string, 2)
+		status  = http.StatusOK
+
+		server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			methods <- request.Method
+			headers <- request.Header.Get("X-Test")
+			response.WriteHeader(status)
+		}))
+	)
+
+	defer server.Close()
+	check := &HTTPCheck{URL: server.URL, Header: http.Header{"X-Test": {"value"}}}
+	assert.NoError(check.Check(context.Background()))
+	assert.Equal("HEAD", <-methods)
+	assert.Equal("value", <-headers)
+
+	status = http.StatusServiceUnavailable
+	check.Method = "GET"
+	assert.Error(check.Check(context.Background()))
+	assert.Equal("GET", <-methods)
+	<-headers
+
+	assert.Error((&HTTPCheck{URL: "http://invalid url"}).Check(context.Background()))
+}
+
+func TestCheckRegistryRunChecks(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		monitor       = &recordingMonitor{stats: make(Stats)}
+		registry      = NewCheckRegistry(monitor, logging.TestLogger(t), 0)
+		release       = make(chan struct{})
+	)
+
+	defer close(release)
+	assert.Equal(DefaultCheckInterval, registry.interval)
+
+	registry.Register("Passing", CheckFunc(func(context.Context) error { return nil }), 0)
+	registry.Register("Failing", CheckFunc(func(context.Context) error { return expectedError }), 0)
+	registry.Register("Panicking", CheckFunc(func(context.Context) error { panic("expected") }), 0)
+
+	// this check ignores its context, so it must be abandoned by the registry
+	registry.Register("Hanging", CheckFunc(func(context.Context) error { <-release; return nil }), 50*time.Millisecond)
+
+	assert.Empty(registry.Results())
+	results := registry.RunChecks(context.Background())
+	assert.Len(results, 4)
+	assert.NoError(results["Passing"])
+	assert.Equal(expectedError, results["Failing"])
+	assert.Error(results["Panicking"])
+	assert.Equal(ErrorCheckTimeout, results["Hanging"])
+	assert.Equal(results, registry.Results())
+
+	assert.Equal(
+		Stats{"Passing": CheckPassed, "Failing": CheckFailed, "Panicking": CheckFailed, "Hanging": CheckFailed},
+		monitor.Stats(),
+	)
+}
+
+func TestCheckRegistryRun(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		monitor   = &recordingMonitor{stats: make(Stats)}
+		registry  = NewCheckRegistry(monitor, nil, 10*time.Millisecond)
+		calls     = make(chan struct{}, 10)
+		waitGroup = new(sync.WaitGroup)
+		shutdown  = make(chan struct{})
+	)
+
+	registry.Register("Counted", CheckFunc(func(context.Context) error {
+		select {
+		case calls <- struct{}{}:
+		default:
+		}
+
+		return nil
+	}), time.Second)
+
+	require.NoError(registry.Run(waitGroup, shutdown))
+	require.NoError(registry.Run(waitGroup, shutdown))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			require.Fail("The check was not run")
+		}
+	}
+
+	close(shutdown)
+	waitGroup.Wait()
+	assert.Equal(CheckPassed, monitor.Stats()["Counted"])
+}
@@ -56,7 +56,7 @@ func (h *Health) RequestTracker(delegate http.Handler) http.Handler {
 
 		defer func() {
 			if r := recover(); r != nil {
-				h.log.Error("Delegate handler panicked: %s", r)
+				logging.ReportPanic(h.log, "Delegate handler panicked", r)
 
 				// TODO: Probably need an error stat instead of just "denied"
 				h.SendEvent(Inc(TotalRequestsDenied, 1))
@@ -0,0 +1,86 @@
+package httppool
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+var (
+	ErrorBodyNotReplayable = errors.New("The request body cannot be replayed")
+)
+
+// BodyFunc produces a stream containing a request body.  Each invocation must return a reader
+// positioned at the start of the body, so that the request can be resent.  A BodyFunc that cannot
+// do this should return ErrorBodyNotReplayable from the second and subsequent invocations.
+type BodyFunc func() (io.ReadCloser, error)
+
+// ReaderBody produces a BodyFunc that streams the given reader exactly once.  Requests using
+// this body cannot be retried.  If the reader is an io.Closer, it is closed along with the request body.
+func ReaderBody(reader io.Reader) BodyFunc {
+	var once sync.Once
+	return func() (body io.ReadCloser, err error) {
+		err = ErrorBodyNotReplayable
+		once.Do(func() {
+			body, err = asReadCloser(reader), nil
+		})
+
+		return
+	}
+}
+
+// ReadSeekerBody produces a BodyFunc that rewinds the given stream to its beginning for each
+// attempt to send a request.  The stream must not be used concurrently by anything else.
+func ReadSeekerBody(stream io.ReadSeeker) BodyFunc {
+	return func() (io.ReadCloser, error) {
+		if _, err := stream.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		return ioutil.NopCloser(stream), nil
+	}
+}
+
+// asReadCloser returns the given reader as an io.ReadCloser, without hiding any Close method it has
+func asReadCloser(reader io.Reader) io.ReadCloser {
+	if readCloser, ok := reader.(io.ReadCloser); ok {
+		return readCloser
+	}
+
+	return ioutil.NopCloser(reader)
+}
+
+// StreamTask produces a Task whose request body is streamed from the given BodyFunc at the time the
+// request is sent, rather than being buffered up front.  The request's GetBody is set from the BodyFunc,
+// so that the request can be resent after transport errors or redirects.
+//
+// If contentLength is negative, the length of the body is unknown and the request is sent chunked.
+// The header, which may be nil, is copied into each request.
+func StreamTask(method, url string, header http.Header, contentLength int64, body BodyFunc, consumer Consumer) Task {
+	return func() (*http.Request, Consumer, error) {
+		request, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		request.Body, err = body()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		request.GetBody = func() (io.ReadCloser, error) { return body() }
+		if contentLength < 0 {
+			request.ContentLength = -1
+		} else {
+			request.ContentLength = contentLength
+		}
+
+		for name, values := range header {
+			request.Header[name] = append([]string(nil), values...)
+		}
+
+		return request, consumer, nil
+	}
+}
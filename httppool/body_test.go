@@ -0,0 +1,105 @@
+package httppool
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (ct *closeTracker) Close() error {
+	ct.closed = true
+	return nil
+}
+
+func TestReaderBody(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		source  = &closeTracker{Reader: strings.NewReader("streamed")}
+		body    = ReaderBody(source)
+	)
+
+	first, err := body()
+	require.NoError(err)
+	contents, err := ioutil.ReadAll(first)
+	assert.NoError(err)
+	assert.Equal("streamed", string(contents))
+	assert.NoError(first.Close())
+	assert.True(source.closed)
+
+	second, err := body()
+	assert.Nil(second)
+	assert.Equal(ErrorBodyNotReplayable, err)
+}
+
+func TestReadSeekerBody(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		body   = ReadSeekerBody(bytes.NewReader([]byte("replayable")))
+	)
+
+	for i := 0; i < 3; i++ {
+		reader, err := body()
+		if assert.NoError(err) {
+			contents, err := ioutil.ReadAll(reader)
+			assert.NoError(err)
+			assert.Equal("replayable", string(contents))
+		}
+	}
+}
+
+func TestStreamTask(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		consumer = Consumer(func(*http.Response, *http.Request) {})
+		header   = http.Header{"Content-Type": {"application/msgpack"}}
+	)
+
+	for _, length := range []int64{-1, 10} {
+		task := StreamTask("POST", "http://example.com/api", header, length, ReadSeekerBody(bytes.NewReader([]byte("replayable"))), consumer)
+		request, actualConsumer, err := task()
+		require.NoError(err)
+		require.NotNil(request)
+		assert.NotNil(actualConsumer)
+		assert.Equal(length, request.ContentLength)
+		assert.Equal("application/msgpack", request.Header.Get("Content-Type"))
+
+		contents, err := ioutil.ReadAll(request.Body)
+		assert.NoError(err)
+		assert.Equal("replayable", string(contents))
+
+		require.NotNil(request.GetBody)
+		replayed, err := request.GetBody()
+		require.NoError(err)
+		contents, err = ioutil.ReadAll(replayed)
+		assert.NoError(err)
+		assert.Equal("replayable", string(contents))
+	}
+}
+
+func TestStreamTaskErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	request, consumer, err := StreamTask("POST", "%%", nil, -1, ReaderBody(strings.NewReader("")), nil)()
+	assert.Nil(request)
+	assert.Nil(consumer)
+	assert.Error(err)
+
+	expectedError := errors.New("expected")
+	request, consumer, err = StreamTask("POST", "http://example.com", nil, -1, func() (io.ReadCloser, error) { return nil, expectedError }, nil)()
+	assert.Nil(request)
+	assert.Nil(consumer)
+	assert.Equal(expectedError, err)
+}
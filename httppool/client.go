@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -45,6 +46,10 @@ type Client struct {
 	// Each Dispatcher will use a distinct copy created with Start() is called.
 	Listeners []Listener
 
+	// Metrics is the optional provider used to record task events.  If supplied, each Dispatcher
+	// created by Start records metrics labeled with this client's name.
+	Metrics xmetrics.Provider
+
 	// Logger is the logging strategy used by this client.  If not supplied, all output will
 	// go to the console.
 	Logger logging.Logger
@@ -60,6 +65,11 @@ type Client struct {
 	// Period is the interval between requests on EACH worker.  If this
 	// value is zero or negative, the workers will not be rate-limited.
 	Period time.Duration
+
+	// Retries is the number of times a request is resent after a transport error.  A request with
+	// a body is only resent if that body can be replayed via GetBody, as with StreamTask.
+	// If this value is zero or negative, requests are never resent.
+	Retries int
 }
 
 func (client *Client) name() string {
@@ -86,6 +96,14 @@ func (client *Client) workers() int {
 	return DefaultWorkers
 }
 
+func (client *Client) retries() int {
+	if client.Retries > 0 {
+		return client.Retries
+	}
+
+	return 0
+}
+
 func (client *Client) logger() logging.Logger {
 	if client.Logger != nil {
 		return client.Logger
@@ -119,11 +137,16 @@ func (client *Client) Start() (dispatcher DispatchCloser) {
 		copy(listeners, client.Listeners)
 	}
 
+	if client.Metrics != nil {
+		listeners = append(listeners, newMetricsListener(name, client.Metrics))
+	}
+
 	if client.Period > 0 {
 		limited := &limitedClientDispatcher{
 			pooledDispatcher: pooledDispatcher{
 				name:      name,
 				handler:   client.handler(),
+				retries:   client.retries(),
 				listeners: listeners,
 				logger:    logger,
 				tasks:     make(chan Task, client.queueSize()),
@@ -138,6 +161,7 @@ func (client *Client) Start() (dispatcher DispatchCloser) {
 			pooledDispatcher: pooledDispatcher{
 				name:      name,
 				handler:   client.handler(),
+				retries:   client.retries(),
 				listeners: listeners,
 				logger:    logger,
 				tasks:     make(chan Task, client.queueSize()),
@@ -192,6 +216,7 @@ type pooledDispatcher struct {
 	state     int32
 	name      string
 	handler   transactionHandler
+	retries   int
 	logger    logging.Logger
 	listeners []Listener
 	tasks     chan Task
@@ -296,7 +321,7 @@ func (pooled *pooledDispatcher) handleTask(context *workerContext, task Task) {
 		return
 	}
 
-	response, err := pooled.handler.Do(request)
+	response, err := pooled.do(context, request)
 	if response != nil && response.Body != nil {
 		defer func() {
 			// if the consumer already cleaned things up, CopyBuffer will return EOF
@@ -319,6 +344,38 @@ func (pooled *pooledDispatcher) handleTask(context *workerContext, task Task) {
 	}
 }
 
+// do executes the HTTP transaction, resending the request after transport errors
+// as many times as this dispatcher is configured to retry
+func (pooled *pooledDispatcher) do(context *workerContext, request *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := pooled.handler.Do(request)
+		if err == nil || attempt >= pooled.retries {
+			return response, err
+		}
+
+		if request.Body != nil {
+			if request.GetBody == nil {
+				pooled.logger.Error("%s[%d] cannot retry a request whose body cannot be replayed", pooled.name, context.id)
+				return response, err
+			}
+
+			body, bodyError := request.GetBody()
+			if bodyError != nil {
+				pooled.logger.Error("%s[%d] unable to replay request body: %s", pooled.name, context.id, bodyError)
+				return response, err
+			}
+
+			request.Body = body
+		}
+
+		if response != nil && response.Body != nil {
+			response.Body.Close()
+		}
+
+		pooled.logger.Debug("%s[%d] retrying after HTTP transaction error: %s", pooled.name, context.id, err)
+	}
+}
+
 // unlimitedClientDispatcher is a DispatchCloser that provides
 // access to a pool of goroutines that is not rate limited.
 type unlimitedClientDispatcher struct {
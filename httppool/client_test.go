@@ -7,8 +7,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -88,6 +90,13 @@ func TestClientNonDefaults(t *testing.T) {
 	}
 }
 
+func TestClientRetries(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, (&Client{}).retries())
+	assert.Equal(0, (&Client{Retries: -1}).retries())
+	assert.Equal(3, (&Client{Retries: 3}).retries())
+}
+
 func TestClientDispatcherUsingSend(t *testing.T) {
 	assert := assert.New(t)
 
@@ -600,3 +609,54 @@ func TestOfferUsingListenerWhenTransactionError(t *testing.T) {
 	mockListener.AssertExpectations(t)
 	mockTransactionHandler.AssertExpectations(t)
 }
+
+func TestHandleTaskRetries(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		mockConsumer = &mockConsumer{expectsCalled: true}
+		bodies       []string
+		task         = StreamTask("POST", "http://example.com", nil, -1, ReadSeekerBody(strings.NewReader("retried")), mockConsumer.Consumer)
+		response     = &http.Response{StatusCode: 200}
+	)
+
+	dispatcher, mockTransactionHandler, workerContext := newPooledDispatcher(1)
+	defer dispatcher.Close()
+	dispatcher.retries = 2
+
+	recordBody := func(arguments mock.Arguments) {
+		contents, _ := ioutil.ReadAll(arguments.Get(0).(*http.Request).Body)
+		bodies = append(bodies, string(contents))
+	}
+
+	mockTransactionHandler.On("Do", mock.AnythingOfType("*http.Request")).Run(recordBody).Return(nil, transactionError).Twice()
+	mockTransactionHandler.On("Do", mock.AnythingOfType("*http.Request")).Run(recordBody).Return(response, nil).Once()
+
+	mockConsumer.expectsResponse = response
+	dispatcher.handleTask(workerContext, func() (*http.Request, Consumer, error) {
+		request, consumer, err := task()
+		mockConsumer.expectsRequest = request
+		return request, consumer, err
+	})
+
+	assert.Equal([]string{"retried", "retried", "retried"}, bodies)
+	mockConsumer.AssertExpectations(t)
+	mockTransactionHandler.AssertExpectations(t)
+}
+
+func TestHandleTaskRetriesExhausted(t *testing.T) {
+	var (
+		mockConsumer = &mockConsumer{}
+		task         = StreamTask("POST", "http://example.com", nil, -1, ReaderBody(strings.NewReader("once")), mockConsumer.Consumer)
+	)
+
+	dispatcher, mockTransactionHandler, workerContext := newPooledDispatcher(1)
+	defer dispatcher.Close()
+	dispatcher.retries = 5
+
+	// the body cannot be replayed, so only one attempt is made
+	mockTransactionHandler.On("Do", mock.AnythingOfType("*http.Request")).Return(nil, transactionError).Once()
+
+	dispatcher.handleTask(workerContext, task)
+	mockConsumer.AssertExpectations(t)
+	mockTransactionHandler.AssertExpectations(t)
+}
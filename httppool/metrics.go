@@ -0,0 +1,65 @@
+package httppool
+
+import (
+	"github.com/Comcast/webpa-common/xmetrics"
+)
+
+const (
+	// TaskCount is the counter of task events, labeled by pool name and event
+	TaskCount = "httppool_tasks_total"
+
+	// QueueDepth is the gauge of tasks waiting for a worker, labeled by pool name
+	QueueDepth = "httppool_queue_depth"
+
+	// ActiveTasks is the gauge of tasks currently being processed, labeled by pool name
+	ActiveTasks = "httppool_active_tasks"
+
+	PoolLabel  = "pool"
+	EventLabel = "event"
+
+	EventQueued   = "queued"
+	EventRejected = "rejected"
+	EventStarted  = "started"
+	EventFinished = "finished"
+	EventFailed   = "failed"
+)
+
+// metricsListener is a Listener that records task events into metrics
+type metricsListener struct {
+	tasks      xmetrics.Counter
+	queueDepth xmetrics.Gauge
+	active     xmetrics.Gauge
+}
+
+func newMetricsListener(name string, provider xmetrics.Provider) *metricsListener {
+	return &metricsListener{
+		tasks:      provider.NewCounter(TaskCount, PoolLabel, EventLabel).With(name),
+		queueDepth: provider.NewGauge(QueueDepth, PoolLabel).With(name),
+		active:     provider.NewGauge(ActiveTasks, PoolLabel).With(name),
+	}
+}
+
+func (ml *metricsListener) On(e Event) {
+	switch e.Type() {
+	case EventTypeQueue:
+		ml.tasks.With(EventQueued).Add(1.0)
+		ml.queueDepth.Add(1.0)
+
+	case EventTypeReject:
+		ml.tasks.With(EventRejected).Add(1.0)
+
+	case EventTypeStart:
+		ml.tasks.With(EventStarted).Add(1.0)
+		ml.queueDepth.Add(-1.0)
+		ml.active.Add(1.0)
+
+	case EventTypeFinish:
+		if e.Err() != nil {
+			ml.tasks.With(EventFailed).Add(1.0)
+		} else {
+			ml.tasks.With(EventFinished).Add(1.0)
+		}
+
+		ml.active.Add(-1.0)
+	}
+}
@@ -0,0 +1,92 @@
+package httppool
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsListener(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	listener := newMetricsListener("test", registry)
+	listener.On(&event{eventType: EventTypeQueue})
+	listener.On(&event{eventType: EventTypeQueue})
+	listener.On(&event{eventType: EventTypeReject})
+	listener.On(&event{eventType: EventTypeStart})
+	listener.On(&event{eventType: EventTypeFinish, eventError: errors.New("expected")})
+	listener.On(&event{eventType: EventTypeStart})
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	require.Equal(http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	for _, expected := range []string{
+		`httppool_tasks_total{event="queued",pool="test"} 2`,
+		`httppool_tasks_total{event="rejected",pool="test"} 1`,
+		`httppool_tasks_total{event="started",pool="test"} 2`,
+		`httppool_tasks_total{event="failed",pool="test"} 1`,
+		`httppool_queue_depth{pool="test"} 0`,
+		`httppool_active_tasks{pool="test"} 1`,
+	} {
+		assert.True(strings.Contains(body, expected), expected)
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		handler = &mockTransactionHandler{}
+		request = MustNewRequest("GET", "http://example.com")
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	handler.On("Do", request).Return(&http.Response{StatusCode: 200}, nil).Once()
+
+	var (
+		finished = make(chan struct{})
+		client   = Client{
+			Name:      "metrics",
+			Handler:   handler,
+			Logger:    testLogger,
+			Workers:   1,
+			Metrics:   registry,
+			Listeners: []Listener{finishListener(finished)},
+		}
+
+		dispatcher = client.Start()
+	)
+
+	require.NoError(dispatcher.Send(RequestTask(request, nil)))
+	<-finished
+	dispatcher.Close()
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.True(strings.Contains(response.Body.String(), `httppool_tasks_total{event="queued",pool="metrics"} 1`))
+	handler.AssertExpectations(t)
+}
+
+// finishListener signals a channel when a task finishes
+type finishListener chan struct{}
+
+func (f finishListener) On(e Event) {
+	if e.Type() == EventTypeFinish {
+		close(f)
+	}
+}
@@ -1,5 +1,17 @@
 /*
 Package logging provides a common Logger interface together with some infrastructure code.
 Integrations with other logging frameworks are provided in subpackages.
+
+Parameters that are expensive to compute can be wrapped in a Lazy, such as with LazyJSON or LazySprintf,
+so that they are only computed when a log entry is actually formatted.
+
+Every Logger accepts structured calls as well as printf-style calls.  A message with no formatting verbs that
+is followed by alternating keys and values is structured:
+
+	logger.Error("Unable to connect", "deviceID", id, "err", err)
+
+A StructuredLogger produces leveled Records with these fields and writes them to a RecordSink, such as
+NewJSONSink or NewSyslogSink.  The other loggers write the fields after the message in key=value form.
+With attaches fields to every entry of any Logger.
 */
 package logging
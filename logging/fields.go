@@ -0,0 +1,201 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Field is a single key-value pair attached to a structured log record
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// String produces the key=value form of this field, as written by the text based loggers
+func (f Field) String() string {
+	return fmt.Sprintf("%s=%v", f.Key, f.Value)
+}
+
+// hasVerbs tests if a format string contains any formatting directives.  An escaped percent sign, %%,
+// is not a directive.
+func hasVerbs(format string) bool {
+	for i := 0; i < len(format)-1; i++ {
+		if format[i] == '%' {
+			if format[i+1] != '%' {
+				return true
+			}
+
+			i++
+		}
+	}
+
+	return false
+}
+
+// keyValues converts alternating keys and values into Fields.  If there is an odd number of
+// parameters or any key is not a string, this function returns false.
+func keyValues(parameters []interface{}) ([]Field, bool) {
+	if len(parameters)%2 != 0 {
+		return nil, false
+	}
+
+	fields := make([]Field, 0, len(parameters)/2)
+	for i := 0; i < len(parameters); i += 2 {
+		key, ok := parameters[i].(string)
+		if !ok {
+			return nil, false
+		}
+
+		fields = append(fields, Field{Key: key, Value: parameters[i+1]})
+	}
+
+	return fields, true
+}
+
+// splitParameters interprets the parameters passed to a Logger method.  The first parameter is the
+// message, which may be a string or a fmt.Stringer.  A message with no formatting verbs that is followed
+// by alternating string keys and values is a structured call:
+//
+//	logger.Error("Unable to connect", "deviceID", id, "err", err)
+//
+// Any other call is printf-style, and the returned fields are nil.  None of the remaining parameters
+// are formatted, so Lazy values are not computed.
+func splitParameters(parameters []interface{}) (format string, fields []Field) {
+	if len(parameters) == 0 {
+		return
+	}
+
+	var ok bool
+	if format, ok = parameters[0].(string); !ok {
+		if stringer, ok := parameters[0].(fmt.Stringer); ok {
+			format = stringer.String()
+		} else {
+			format = fmt.Sprintf("%v", parameters[0])
+		}
+	}
+
+	if len(parameters) > 1 && !hasVerbs(format) {
+		fields, _ = keyValues(parameters[1:])
+	}
+
+	return
+}
+
+// parseParameters interprets the parameters passed to a Logger method as with splitParameters, and
+// produces the formatted message.  For printf-style calls, the remaining parameters are formatted into
+// the message.
+func parseParameters(parameters []interface{}) (format, message string, fields []Field) {
+	format, fields = splitParameters(parameters)
+	switch {
+	case fields != nil:
+		// the message has no verbs, so only escaped percent signs need to be unescaped
+		message = strings.Replace(format, "%%", "%", -1)
+	case len(parameters) > 0:
+		message = fmt.Sprintf(format, parameters[1:]...)
+	}
+
+	return
+}
+
+// appendFields writes fields in key=value form, each preceded by a space
+func appendFields(buffer *bytes.Buffer, fields []Field) {
+	for _, f := range fields {
+		buffer.WriteRune(' ')
+		buffer.WriteString(f.String())
+	}
+}
+
+// PrintfParameters converts the parameters of a structured Logger call into the equivalent printf-style
+// parameters, with each field appended to the message in key=value form.  Printf-style parameters are
+// returned as is.  Adapters onto logging frameworks that only understand printf-style calls use this
+// function so that structured call sites produce readable output.
+func PrintfParameters(parameters []interface{}) []interface{} {
+	format, fields := splitParameters(parameters)
+	if len(fields) == 0 {
+		return parameters
+	}
+
+	var (
+		buffer    bytes.Buffer
+		converted = make([]interface{}, 1, len(fields)+1)
+	)
+
+	buffer.WriteString(format)
+	for _, f := range fields {
+		buffer.WriteString(" %s")
+		converted = append(converted, f)
+	}
+
+	converted[0] = buffer.String()
+	return converted
+}
+
+// contextLogger is the Logger returned by With for Loggers that are not StructuredLoggers
+type contextLogger struct {
+	Logger
+	fields []Field
+}
+
+func (c *contextLogger) withFields(parameters []interface{}) []interface{} {
+	format, fields := splitParameters(parameters)
+	if fields == nil && len(parameters) > 0 {
+		// a printf-style call: the context fields are formatted after the message
+		converted := make([]interface{}, 0, len(parameters)+1)
+		converted = append(converted, format+"%s")
+		converted = append(converted, parameters[1:]...)
+		return append(converted, fieldList(c.fields))
+	}
+
+	combined := make([]interface{}, 0, len(parameters)+2*len(c.fields))
+	if len(parameters) == 0 {
+		combined = append(combined, "")
+	} else {
+		combined = append(combined, parameters[0])
+	}
+
+	for _, f := range c.fields {
+		combined = append(combined, f.Key, f.Value)
+	}
+
+	for _, f := range fields {
+		combined = append(combined, f.Key, f.Value)
+	}
+
+	return combined
+}
+
+func (c *contextLogger) Trace(parameters ...interface{}) { c.Logger.Trace(c.withFields(parameters)...) }
+func (c *contextLogger) Debug(parameters ...interface{}) { c.Logger.Debug(c.withFields(parameters)...) }
+func (c *contextLogger) Info(parameters ...interface{})  { c.Logger.Info(c.withFields(parameters)...) }
+func (c *contextLogger) Warn(parameters ...interface{})  { c.Logger.Warn(c.withFields(parameters)...) }
+func (c *contextLogger) Error(parameters ...interface{}) { c.Logger.Error(c.withFields(parameters)...) }
+
+// fieldList formats a list of fields as they are appended to a text message
+type fieldList []Field
+
+func (fl fieldList) String() string {
+	var buffer bytes.Buffer
+	appendFields(&buffer, fl)
+	return buffer.String()
+}
+
+// With returns a Logger that attaches the given alternating keys and values to every entry.  If the
+// logger is a *StructuredLogger, the fields are attached to each Record.  Any other Logger receives
+// structured calls that carry the fields, which the built-in loggers write in key=value form.
+func With(logger Logger, keyvals ...interface{}) Logger {
+	fields, ok := keyValues(keyvals)
+	if !ok {
+		fields = []Field{{Key: "fields", Value: keyvals}}
+	}
+
+	switch l := logger.(type) {
+	case *StructuredLogger:
+		return l.with(fields)
+	case *contextLogger:
+		combined := make([]Field, 0, len(l.fields)+len(fields))
+		return &contextLogger{Logger: l.Logger, fields: append(append(combined, l.fields...), fields...)}
+	default:
+		return &contextLogger{Logger: logger, fields: fields}
+	}
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHasVerbs(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(hasVerbs(""))
+	assert.False(hasVerbs("no verbs"))
+	assert.False(hasVerbs("100%% escaped"))
+	assert.False(hasVerbs("trailing %"))
+	assert.True(hasVerbs("a %s verb"))
+	assert.True(hasVerbs("%%%d"))
+}
+
+func TestParseParameters(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		expected = errors.New("expected")
+	)
+
+	format, message, fields := parseParameters(nil)
+	assert.Empty(format)
+	assert.Empty(message)
+	assert.Nil(fields)
+
+	_, message, fields = parseParameters([]interface{}{"Unable to connect", "deviceID", "mac:112233445566", "err", expected})
+	assert.Equal("Unable to connect", message)
+	assert.Equal([]Field{{"deviceID", "mac:112233445566"}, {"err", expected}}, fields)
+
+	// printf-style calls have no fields
+	_, message, fields = parseParameters([]interface{}{"Unable to connect %s: %s", "mac:112233445566", expected})
+	assert.Equal("Unable to connect mac:112233445566: expected", message)
+	assert.Nil(fields)
+
+	_, message, fields = parseParameters([]interface{}{"Odd parameters", "key"})
+	assert.Equal("Odd parameters%!(EXTRA string=key)", message)
+	assert.Nil(fields)
+
+	_, message, fields = parseParameters([]interface{}{"Non-string key", 1, 2})
+	assert.Contains(message, "EXTRA")
+	assert.Nil(fields)
+
+	_, message, fields = parseParameters([]interface{}{testStringer{"a stringer"}, "key", "value"})
+	assert.Equal("a stringer", message)
+	assert.Equal([]Field{{"key", "value"}}, fields)
+}
+
+func TestPrintfParameters(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		calls  = 0
+		lazy   = Lazy(func() interface{} { calls++; return "lazy" })
+	)
+
+	printf := []interface{}{"a %s message", "printf"}
+	assert.Equal(printf, PrintfParameters(printf))
+
+	converted := PrintfParameters([]interface{}{"100%% structured", "key", "value", "lazy", lazy})
+	assert.Zero(calls, "Lazy values should not be computed until formatted")
+	assert.Equal("100%% structured %s %s", converted[0])
+
+	var output bytes.Buffer
+	(&LoggerWriter{&output}).Info(converted...)
+	assert.Equal(infoLevel+"100% structured key=value lazy=lazy\n", output.String())
+	assert.Equal(1, calls)
+}
+
+func TestWith(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		output bytes.Buffer
+		logger = With(&LoggerWriter{&output}, "deviceID", "mac:112233445566")
+	)
+
+	logger.Info("structured", "count", 3)
+	assert.Equal(infoLevel+"structured deviceID=mac:112233445566 count=3\n", output.String())
+
+	output.Reset()
+	logger.Error("printf-style %d%%", 100)
+	assert.Equal(errorLevel+"printf-style 100% deviceID=mac:112233445566\n", output.String())
+
+	output.Reset()
+	logger.Debug()
+	assert.Equal(debugLevel+" deviceID=mac:112233445566\n", output.String())
+
+	// fields accumulate
+	output.Reset()
+	With(logger, "session", "abc").Warn("nested")
+	assert.Equal(warnLevel+"nested deviceID=mac:112233445566 session=abc\n", output.String())
+
+	output.Reset()
+	With(&LoggerWriter{&output}, "odd").Info("message")
+	assert.Equal(infoLevel+"message fields=[odd]\n", output.String())
+}
@@ -19,6 +19,25 @@ type adapter struct {
 	logger.Logger
 }
 
+// Structured calls, e.g. logger.Error("message", "key", value), are converted to printf-style calls
+// since go-log only understands format strings
+
+func (a adapter) Trace(parameters ...interface{}) {
+	a.Logger.Trace(logging.PrintfParameters(parameters)...)
+}
+func (a adapter) Debug(parameters ...interface{}) {
+	a.Logger.Debug(logging.PrintfParameters(parameters)...)
+}
+func (a adapter) Info(parameters ...interface{}) {
+	a.Logger.Info(logging.PrintfParameters(parameters)...)
+}
+func (a adapter) Warn(parameters ...interface{}) {
+	a.Logger.Warn(logging.PrintfParameters(parameters)...)
+}
+func (a adapter) Error(parameters ...interface{}) {
+	a.Logger.Error(logging.PrintfParameters(parameters)...)
+}
+
 func (a adapter) Printf(format string, parameters ...interface{}) {
 	if !a.Enabled()[levels.INFO] {
 		return
@@ -39,6 +58,10 @@ type LoggerFactory struct {
 	Pattern   Pattern `json:"pattern"`
 	MaxSize   int64   `json:"maxSize"`
 	MaxBackup int     `json:"maxBackup"`
+
+	// ErrorSink optionally forwards Error-level entries, and panics reported via
+	// logging.ReportPanic, to an external error tracker
+	ErrorSink *logging.HTTPSinkFactory `json:"errorSink,omitempty"`
 }
 
 var _ logging.LoggerFactory = (*LoggerFactory)(nil)
@@ -87,6 +110,15 @@ func (factory *LoggerFactory) NewLogger(name string) (logging.Logger, error) {
 		adapter.SetLevel(levels.StringToLogLevels[factory.Level])
 		adapter.SetAppender(appender)
 
+		if factory.ErrorSink != nil {
+			sink, _, err := factory.ErrorSink.NewSink(name)
+			if err != nil {
+				return nil, err
+			}
+
+			return &logging.SinkLogger{Logger: adapter, Sink: sink}, nil
+		}
+
 		return adapter, nil
 	}
 }
@@ -1,6 +1,7 @@
 package golog
 
 import (
+	"github.com/Comcast/webpa-common/logging"
 	"github.com/ian-kent/go-log/appenders"
 	"github.com/ian-kent/go-log/layout"
 	"github.com/ian-kent/go-log/levels"
@@ -20,3 +21,21 @@ func TestCaller(t *testing.T) {
 	// the caller is clearly not this file
 	gologger.Error("test")
 }
+
+func TestLazyFilteredLevel(t *testing.T) {
+	var (
+		calls   = 0
+		factory = LoggerFactory{Level: "INFO"}
+	)
+
+	logger, err := factory.NewLogger("test")
+	if err != nil {
+		t.Fatalf("Unable to create logger: %s", err)
+	}
+
+	logger.Debug("filtered: %s", logging.Lazy(func() interface{} { calls++; return "value" }))
+	logger.Debug("filtered", "value", logging.Lazy(func() interface{} { calls++; return "value" }))
+	if calls != 0 {
+		t.Errorf("Lazy value computed %d times for a filtered level", calls)
+	}
+}
@@ -0,0 +1,249 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSinkQueueSize = 100
+	DefaultSinkTimeout   = 10 * time.Second
+)
+
+var (
+	ErrorSinkURLRequired = errors.New("An error sink URL is required")
+)
+
+// HTTPSinkFactory is the JSON configuration for an HTTPSink
+type HTTPSinkFactory struct {
+	// URL is the endpoint that receives each event via POST, e.g. a Sentry store URL
+	URL string `json:"url"`
+
+	// Header holds any extra HTTP headers sent with each event, such as X-Sentry-Auth
+	Header http.Header `json:"header,omitempty"`
+
+	// QueueSize is the number of events buffered for delivery.  Events reported while the
+	// queue is full are dropped.  If not positive, DefaultSinkQueueSize is used.
+	QueueSize int `json:"queueSize"`
+
+	// Timeout is the HTTP client timeout, in a form accepted by time.ParseDuration.
+	// If not supplied, DefaultSinkTimeout is used.
+	Timeout string `json:"timeout,omitempty"`
+
+	// RateLimit is the number of events per fingerprint sent in each RatePeriod.
+	// If not positive, events are not rate limited.
+	RateLimit int `json:"rateLimit"`
+
+	// RatePeriod is the rate limiting window, in a form accepted by time.ParseDuration.
+	// If not supplied, one minute is used.
+	RatePeriod string `json:"ratePeriod,omitempty"`
+}
+
+func parseOptionalDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// NewSink creates the Sink described by this factory.  The logger name is sent with each event.
+// The returned HTTPSink has already been started, and is wrapped in a RateLimitedSink when a
+// RateLimit is configured.
+func (f *HTTPSinkFactory) NewSink(name string) (Sink, *HTTPSink, error) {
+	if len(f.URL) == 0 {
+		return nil, nil, ErrorSinkURLRequired
+	}
+
+	timeout, err := parseOptionalDuration(f.Timeout, DefaultSinkTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ratePeriod, err := parseOptionalDuration(f.RatePeriod, time.Minute)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	queueSize := f.QueueSize
+	if queueSize < 1 {
+		queueSize = DefaultSinkQueueSize
+	}
+
+	httpSink := NewHTTPSink(name, f.URL, f.Header, &http.Client{Timeout: timeout}, queueSize)
+	if f.RateLimit > 0 {
+		return &RateLimitedSink{Sink: httpSink, Limit: f.RateLimit, Period: ratePeriod}, httpSink, nil
+	}
+
+	return httpSink, httpSink, nil
+}
+
+// httpEvent is the JSON body posted for each entry.  Its members follow the Sentry event format.
+type httpEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Platform    string            `json:"platform"`
+	Message     string            `json:"message"`
+	Fingerprint []string          `json:"fingerprint"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// newEventID produces a random, 32 character hexadecimal event identifier
+func newEventID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// HTTPSink is a Sink that posts entries as JSON to an HTTP error tracker.  Entries are delivered
+// by a single background goroutine, so Report never blocks on I/O.
+type HTTPSink struct {
+	name   string
+	url    string
+	header http.Header
+	client *http.Client
+
+	events   chan httpEvent
+	shutdown chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	lock    sync.Mutex
+	dropped int
+	failed  int
+}
+
+// NewHTTPSink creates and starts an HTTPSink.  If client is nil, http.DefaultClient is used.
+func NewHTTPSink(name, url string, header http.Header, client *http.Client, queueSize int) *HTTPSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if queueSize < 1 {
+		queueSize = DefaultSinkQueueSize
+	}
+
+	s := &HTTPSink{
+		name:     name,
+		url:      url,
+		header:   header,
+		client:   client,
+		events:   make(chan httpEvent, queueSize),
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+// Report queues an entry for delivery.  If the queue is full or this sink has been stopped,
+// the entry is dropped.
+func (s *HTTPSink) Report(e Entry) {
+	event := httpEvent{
+		EventID:     newEventID(),
+		Timestamp:   e.Time.UTC().Format("2006-01-02T15:04:05"),
+		Level:       "error",
+		Logger:      s.name,
+		Platform:    "go",
+		Message:     e.Message,
+		Fingerprint: []string{e.Fingerprint},
+	}
+
+	if e.Panic {
+		event.Level = "fatal"
+		event.Extra = map[string]string{"stack": string(e.Stack)}
+	}
+
+	select {
+	case <-s.shutdown:
+		s.drop()
+		return
+	default:
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		s.drop()
+	}
+}
+
+func (s *HTTPSink) drop() {
+	s.lock.Lock()
+	s.dropped++
+	s.lock.Unlock()
+}
+
+// run is the delivery goroutine
+func (s *HTTPSink) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case event := <-s.events:
+			if err := s.post(event); err != nil {
+				s.lock.Lock()
+				s.failed++
+				s.lock.Unlock()
+			}
+		}
+	}
+}
+
+func (s *HTTPSink) post(event httpEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for name, values := range s.header {
+		request.Header[name] = values
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("Error sink returned status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// Stats returns the number of entries dropped because the queue was full or the sink was stopped,
+// along with the number of entries the error tracker failed to accept
+func (s *HTTPSink) Stats() (dropped int, failed int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dropped, s.failed
+}
+
+// Stop halts delivery and waits for the background goroutine to exit.  Entries still queued
+// are discarded.  This method is idempotent.
+func (s *HTTPSink) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.shutdown)
+	})
+
+	<-s.done
+}
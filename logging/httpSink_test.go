@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSinkFactoryNewSink(t *testing.T) {
+	var testData = []struct {
+		factory     HTTPSinkFactory
+		rateLimited bool
+		expectError bool
+	}{
+		{HTTPSinkFactory{}, false, true},
+		{HTTPSinkFactory{URL: "http://localhost", Timeout: "not a duration"}, false, true},
+		{HTTPSinkFactory{URL: "http://localhost", RatePeriod: "not a duration"}, false, true},
+		{HTTPSinkFactory{URL: "http://localhost"}, false, false},
+		{HTTPSinkFactory{URL: "http://localhost", QueueSize: 5, Timeout: "1s"}, false, false},
+		{HTTPSinkFactory{URL: "http://localhost", RateLimit: 10, RatePeriod: "30s"}, true, false},
+	}
+
+	for i, record := range testData {
+		t.Logf("%d: %#v", i, record)
+		assert := assert.New(t)
+
+		sink, httpSink, err := record.factory.NewSink("test")
+		if record.expectError {
+			assert.Nil(sink)
+			assert.Nil(httpSink)
+			assert.Error(err)
+			continue
+		}
+
+		require.NotNil(t, httpSink)
+		assert.NoError(err)
+		if rateLimited, ok := sink.(*RateLimitedSink); record.rateLimited && assert.True(ok) {
+			assert.Equal(record.factory.RateLimit, rateLimited.Limit)
+			assert.Equal(30*time.Second, rateLimited.Period)
+		} else if !record.rateLimited {
+			assert.Equal(httpSink, sink)
+		}
+
+		httpSink.Stop()
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan httpEvent, 2)
+		headers  = make(chan http.Header, 2)
+
+		server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			var event httpEvent
+			assert.Equal("POST", request.Method)
+			assert.NoError(json.NewDecoder(request.Body).Decode(&event))
+			headers <- request.Header
+			received <- event
+		}))
+	)
+
+	defer server.Close()
+	sink := NewHTTPSink("test", server.URL, http.Header{"X-Sentry-Auth": {"Sentry sentry_key=abc"}}, nil, 0)
+	defer sink.Stop()
+
+	now := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+	sink.Report(Entry{Time: now, Message: "error message", Fingerprint: "1234"})
+	sink.Report(Entry{Time: now, Message: "panic message", Fingerprint: "5678", Panic: true, Stack: []byte("stack")})
+
+	for _, expected := range []httpEvent{
+		{Timestamp: "2017-06-01T12:30:00", Level: "error", Logger: "test", Platform: "go", Message: "error message", Fingerprint: []string{"1234"}},
+		{Timestamp: "2017-06-01T12:30:00", Level: "fatal", Logger: "test", Platform: "go", Message: "panic message", Fingerprint: []string{"5678"}, Extra: map[string]string{"stack": "stack"}},
+	} {
+		select {
+		case header := <-headers:
+			assert.Equal("Sentry sentry_key=abc", header.Get("X-Sentry-Auth"))
+			assert.Equal("application/json", header.Get("Content-Type"))
+		case <-time.After(5 * time.Second):
+			require.Fail("No event was posted")
+		}
+
+		actual := <-received
+		assert.Len(actual.EventID, 32)
+		actual.EventID = ""
+		assert.Equal(expected, actual)
+	}
+
+	dropped, failed := sink.Stats()
+	assert.Zero(dropped)
+	assert.Zero(failed)
+}
+
+func TestHTTPSinkFailure(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		posted = make(chan struct{}, 1)
+
+		server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			response.WriteHeader(http.StatusInternalServerError)
+			posted <- struct{}{}
+		}))
+	)
+
+	defer server.Close()
+	sink := NewHTTPSink("test", server.URL, nil, nil, 1)
+	sink.Report(Entry{Time: time.Now(), Message: "error message"})
+
+	select {
+	case <-posted:
+	case <-time.After(5 * time.Second):
+		assert.Fail("No event was posted")
+	}
+
+	// Stop waits for the delivery goroutine, so the failure has been counted
+	sink.Stop()
+	dropped, failed := sink.Stats()
+	assert.Zero(dropped)
+	assert.Equal(1, failed)
+}
+
+func TestHTTPSinkStopped(t *testing.T) {
+	assert := assert.New(t)
+	sink := NewHTTPSink("test", "http://localhost", nil, nil, 1)
+	sink.Stop()
+	sink.Stop()
+
+	sink.Report(Entry{Time: time.Now(), Message: "error message"})
+	dropped, failed := sink.Stats()
+	assert.Equal(1, dropped)
+	assert.Zero(failed)
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// jsonSink is the RecordSink returned by NewJSONSink
+type jsonSink struct {
+	lock   sync.Mutex
+	output io.Writer
+}
+
+// NewJSONSink produces a RecordSink that writes each record as a single line of JSON.  The time, level,
+// and message are written under the "ts", "level", and "msg" keys, followed by the record's fields.
+func NewJSONSink(output io.Writer) RecordSink {
+	return &jsonSink{output: output}
+}
+
+// fieldValue produces the value of a field that is marshalled to JSON.  Errors, fmt.Stringers, and
+// values which cannot be marshalled are written as their text.
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case Lazy:
+		return fieldValue(v.value())
+	case fmt.Stringer:
+		return v.String()
+	case json.Marshaler:
+		return v
+	}
+
+	if _, err := json.Marshal(value); err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return value
+}
+
+func (s *jsonSink) WriteRecord(r Record) error {
+	// an object is built by hand, rather than from a map, so that keys keep their order
+	buffer := make([]byte, 0, 128)
+	appendPair := func(key string, value interface{}) error {
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		if len(buffer) > 1 {
+			buffer = append(buffer, ',')
+		}
+
+		buffer = append(append(append(buffer, encodedKey...), ':'), encodedValue...)
+		return nil
+	}
+
+	buffer = append(buffer, '{')
+	appendPair("ts", r.Time.UTC().Format(time.RFC3339Nano))
+	appendPair("level", r.Level.String())
+	appendPair("msg", r.Message)
+	for _, f := range r.Fields {
+		if err := appendPair(f.Key, fieldValue(f.Value)); err != nil {
+			return err
+		}
+	}
+
+	buffer = append(buffer, '}', '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.output.Write(buffer)
+	return err
+}
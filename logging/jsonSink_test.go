@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONSink(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		output  bytes.Buffer
+		sink    = NewJSONSink(&output)
+		now     = time.Date(2017, 3, 14, 15, 9, 26, 0, time.UTC)
+	)
+
+	require.NoError(sink.WriteRecord(Record{
+		Time:    now,
+		Level:   ErrorLevel,
+		Message: "Unable to connect",
+		Fields: []Field{
+			{"deviceID", "mac:112233445566"},
+			{"err", errors.New("expected")},
+			{"stringer", testStringer{"stringer"}},
+			{"lazy", Lazy(func() interface{} { return 12 })},
+			{"count", 3},
+			{"func", func() {}},
+			{"nil", nil},
+		},
+	}))
+
+	assert.Equal(
+		`{"ts":"2017-03-14T15:09:26Z","level":"error","msg":"Unable to connect","deviceID":"mac:112233445566",`+
+			`"err":"expected","stringer":"stringer","lazy":12,"count":3,"func":"`,
+		output.String()[:strings.LastIndex(output.String(), `"func":"`)+len(`"func":"`)],
+	)
+
+	assert.True(strings.HasSuffix(output.String(), "\",\"nil\":null}\n"))
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Lazy defers the computation of a log parameter until the log entry is actually formatted.  Loggers only
+// format entries for enabled levels, so expensive values such as large endpoint lists or marshalled devices
+// cost nothing when their level is filtered out:
+//
+//	logger.Debug("Updated endpoints: %s", logging.Lazy(func() interface{} { return strings.Join(endpoints, ",") }))
+//
+// The computed value is formatted with the same verb and flags as the Lazy itself.  Lazy also implements
+// fmt.Stringer, so it may be used as the first, format parameter of a Logger method.
+type Lazy func() interface{}
+
+// formatDirective reconstructs the directive, e.g. %-10.2f, that produced a call to fmt.Formatter.Format
+func formatDirective(state fmt.State, verb rune) string {
+	directive := []byte{'%'}
+	for _, flag := range "+-# 0" {
+		if state.Flag(int(flag)) {
+			directive = append(directive, byte(flag))
+		}
+	}
+
+	if width, ok := state.Width(); ok {
+		directive = strconv.AppendInt(directive, int64(width), 10)
+	}
+
+	if precision, ok := state.Precision(); ok {
+		directive = append(directive, '.')
+		directive = strconv.AppendInt(directive, int64(precision), 10)
+	}
+
+	return string(append(directive, string(verb)...))
+}
+
+func (l Lazy) value() interface{} {
+	if l == nil {
+		return nil
+	}
+
+	return l()
+}
+
+func (l Lazy) Format(state fmt.State, verb rune) {
+	fmt.Fprintf(state, formatDirective(state, verb), l.value())
+}
+
+func (l Lazy) String() string {
+	return fmt.Sprint(l.value())
+}
+
+// LazyJSON produces a Lazy that marshals a value to JSON only when formatted.  If the value cannot be
+// marshalled, the error text is formatted instead.
+func LazyJSON(value interface{}) Lazy {
+	return func() interface{} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprintf("<JSON error: %s>", err)
+		}
+
+		return string(data)
+	}
+}
+
+// LazySprintf produces a Lazy that invokes fmt.Sprintf only when formatted
+func LazySprintf(format string, parameters ...interface{}) Lazy {
+	return func() interface{} {
+		return fmt.Sprintf(format, parameters...)
+	}
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLazyFormat(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		lazy   = Lazy(func() interface{} { return 3.14159 })
+	)
+
+	assert.Equal("3.14159", fmt.Sprintf("%v", lazy))
+	assert.Equal("3.14", fmt.Sprintf("%.2f", lazy))
+	assert.Equal("+3.1      ", fmt.Sprintf("%+-10.1f", lazy))
+	assert.Equal("3.14159", lazy.String())
+
+	var nilLazy Lazy
+	assert.Equal("<nil>", fmt.Sprintf("%v", nilLazy))
+	assert.Equal("<nil>", nilLazy.String())
+}
+
+func TestLazyDeferred(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		calls  = 0
+		lazy   = Lazy(func() interface{} { calls++; return "value" })
+	)
+
+	// nothing is computed until the lazy value is formatted
+	parameters := []interface{}{"Value: %s", lazy}
+	assert.Equal(0, calls)
+
+	var output bytes.Buffer
+	logger := &LoggerWriter{&output}
+	logger.Info(parameters...)
+	assert.Equal(1, calls)
+	assert.Equal(infoLevel+"Value: value\n", output.String())
+
+	// a lazy value can also be the format
+	output.Reset()
+	logger.Debug(LazySprintf("%d devices", 5))
+	assert.Equal(debugLevel+"5 devices\n", output.String())
+}
+
+func TestLazyJSON(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(`{"id":"mac:112233445566"}`, fmt.Sprintf("%s", LazyJSON(map[string]string{"id": "mac:112233445566"})))
+	assert.Contains(fmt.Sprintf("%s", LazyJSON(make(chan int))), "JSON error")
+}
@@ -69,20 +69,18 @@ func (l *LoggerWriter) logf(level, format string, parameters []interface{}) {
 	}
 }
 
+// formatf writes a Logger call, which may be printf-style or structured.  The fields of a structured
+// call are written after the message in key=value form.
 func (l *LoggerWriter) formatf(level string, parameters []interface{}) {
-	if len(parameters) > 0 {
-		format, ok := parameters[0].(string)
-		if !ok {
-			if stringer, ok := parameters[0].(fmt.Stringer); ok {
-				format = stringer.String()
-			} else {
-				format = fmt.Sprintf("%v", parameters[0])
-			}
-		}
-
-		l.logf(level, format, parameters[1:])
-	} else {
-		l.logf(level, "", parameters)
+	_, message, fields := parseParameters(parameters)
+
+	var buffer bytes.Buffer
+	buffer.WriteString(level)
+	buffer.WriteString(message)
+	appendFields(&buffer, fields)
+	buffer.WriteRune('\n')
+	if _, err := l.Write(buffer.Bytes()); err != nil {
+		panic(err)
 	}
 }
 
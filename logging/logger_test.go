@@ -50,6 +50,14 @@ func TestLoggerWriterUsingParameters(t *testing.T) {
 			[]interface{}{-1234, "rawk! I shouldn't be!"},
 			"-1234%!(EXTRA string=rawk! I shouldn't be!)",
 		},
+		{
+			[]interface{}{"a structured message", "deviceID", "mac:112233445566", "count", 3},
+			"a structured message deviceID=mac:112233445566 count=3",
+		},
+		{
+			[]interface{}{"100%% structured", "key", "value"},
+			"100% structured key=value",
+		},
 	}
 
 	var output bytes.Buffer
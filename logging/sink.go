@@ -0,0 +1,178 @@
+package logging
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Entry is an error-level log entry forwarded to a Sink
+type Entry struct {
+	// Time is when the entry was logged
+	Time time.Time
+
+	// Message is the fully formatted log message
+	Message string
+
+	// Fingerprint groups entries that were logged from the same place.  It is derived
+	// from the unformatted message, so entries that differ only in their parameters share
+	// a fingerprint.
+	Fingerprint string
+
+	// Panic indicates that this entry was produced by recovering from a panic
+	Panic bool
+
+	// Stack is the stack trace of the goroutine that recovered a panic.  This field
+	// is only set when Panic is true.
+	Stack []byte
+}
+
+// Sink receives error entries for forwarding to an external error tracker.  Implementations
+// must be safe for concurrent use and must not block the caller for long.
+type Sink interface {
+	Report(Entry)
+}
+
+// SinkFunc is a function type that implements Sink
+type SinkFunc func(Entry)
+
+func (f SinkFunc) Report(e Entry) {
+	f(e)
+}
+
+// Fingerprint computes the Entry fingerprint for a format string
+func Fingerprint(format string) string {
+	hash := sha1.Sum([]byte(format))
+	return hex.EncodeToString(hash[:8])
+}
+
+// formatParameters produces the format and formatted message for a set of Logger parameters,
+// using the same rules as LoggerWriter
+func formatParameters(parameters []interface{}) (string, string) {
+	format, message, fields := parseParameters(parameters)
+	if len(fields) > 0 {
+		message += fieldList(fields).String()
+	}
+
+	return format, message
+}
+
+// SinkLogger decorates a Logger so that every Error entry is also reported to a Sink.
+// All other levels go only to the decorated Logger.
+type SinkLogger struct {
+	Logger
+
+	// Sink receives each Error entry.  If nil, entries are only logged.
+	Sink Sink
+}
+
+func (s *SinkLogger) Error(parameters ...interface{}) {
+	s.Logger.Error(parameters...)
+	if s.Sink != nil {
+		format, message := formatParameters(parameters)
+		s.Sink.Report(Entry{
+			Time:        time.Now(),
+			Message:     message,
+			Fingerprint: Fingerprint(format),
+		})
+	}
+}
+
+// Printf delegates to the decorated Logger's Printf method, if it has one, or to Info otherwise
+func (s *SinkLogger) Printf(format string, parameters ...interface{}) {
+	if printer, ok := s.Logger.(interface {
+		Printf(string, ...interface{})
+	}); ok {
+		printer.Printf(format, parameters...)
+		return
+	}
+
+	PrintLogger{s.Logger}.Printf(format, parameters...)
+}
+
+// ReportPanic logs a recovered panic value at the Error level.  If the logger is a *SinkLogger,
+// the panic is also reported to its Sink along with the current stack.  Use this function
+// from within a deferred recover:
+//
+//	defer func() {
+//	    if r := recover(); r != nil {
+//	        logging.ReportPanic(logger, "Handler panicked", r)
+//	    }
+//	}()
+func ReportPanic(logger Logger, message string, recovered interface{}) {
+	sinkLogger, ok := logger.(*SinkLogger)
+	if !ok || sinkLogger.Sink == nil {
+		logger.Error("%s: %v", message, recovered)
+		return
+	}
+
+	sinkLogger.Logger.Error("%s: %v", message, recovered)
+	sinkLogger.Sink.Report(Entry{
+		Time:        time.Now(),
+		Message:     fmt.Sprintf("%s: %v", message, recovered),
+		Fingerprint: Fingerprint(message),
+		Panic:       true,
+		Stack:       debug.Stack(),
+	})
+}
+
+// RateLimitedSink decorates a Sink so that at most Limit entries with any given fingerprint
+// are forwarded in each Period.  Excess entries are dropped.
+type RateLimitedSink struct {
+	// Sink receives the entries that are within the limit
+	Sink Sink
+
+	// Limit is the number of entries allowed per fingerprint per period.  If not positive,
+	// no entries are dropped.
+	Limit int
+
+	// Period is the length of each rate limiting window.  If not positive, one minute is used.
+	Period time.Duration
+
+	lock        sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+	dropped     int
+}
+
+func (r *RateLimitedSink) period() time.Duration {
+	if r.Period > 0 {
+		return r.Period
+	}
+
+	return time.Minute
+}
+
+func (r *RateLimitedSink) allow(e Entry) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.counts == nil || e.Time.Sub(r.windowStart) >= r.period() {
+		r.windowStart = e.Time
+		r.counts = make(map[string]int)
+	}
+
+	if r.counts[e.Fingerprint] >= r.Limit {
+		r.dropped++
+		return false
+	}
+
+	r.counts[e.Fingerprint]++
+	return true
+}
+
+func (r *RateLimitedSink) Report(e Entry) {
+	if r.Limit < 1 || r.allow(e) {
+		r.Sink.Report(e)
+	}
+}
+
+// Dropped returns the total number of entries this sink has refused to forward
+func (r *RateLimitedSink) Dropped() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.dropped
+}
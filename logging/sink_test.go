@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink is a Sink that stores every entry it receives
+type recordingSink struct {
+	lock    sync.Mutex
+	entries []Entry
+}
+
+func (r *recordingSink) Report(e Entry) {
+	r.lock.Lock()
+	r.entries = append(r.entries, e)
+	r.lock.Unlock()
+}
+
+func (r *recordingSink) Entries() []Entry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
+
+func TestFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Len(Fingerprint("a format: %s"), 16)
+	assert.Equal(Fingerprint("a format: %s"), Fingerprint("a format: %s"))
+	assert.NotEqual(Fingerprint("a format: %s"), Fingerprint("another format: %s"))
+}
+
+func TestSinkFunc(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		reported []Entry
+		sink     = SinkFunc(func(e Entry) { reported = append(reported, e) })
+	)
+
+	sink.Report(Entry{Message: "test"})
+	assert.Equal([]Entry{{Message: "test"}}, reported)
+}
+
+func TestSinkLogger(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		output bytes.Buffer
+		sink   = new(recordingSink)
+		logger = &SinkLogger{Logger: &LoggerWriter{&output}, Sink: sink}
+	)
+
+	logger.Debug("debug message")
+	logger.Info("info message")
+	logger.Warn("warn message")
+	logger.Printf("printf message %d", 1)
+	assert.Empty(sink.Entries())
+
+	logger.Error("error message %d", 1)
+	logger.Error("error message %d", 2)
+
+	entries := sink.Entries()
+	if assert.Len(entries, 2) {
+		assert.Equal("error message 1", entries[0].Message)
+		assert.Equal("error message 2", entries[1].Message)
+		assert.Equal(Fingerprint("error message %d"), entries[0].Fingerprint)
+		assert.Equal(entries[0].Fingerprint, entries[1].Fingerprint)
+		assert.False(entries[0].Panic)
+		assert.False(entries[0].Time.IsZero())
+	}
+
+	for _, expected := range []string{"debug message", "info message", "warn message", "printf message 1", "error message 1", "error message 2"} {
+		assert.Contains(output.String(), expected)
+	}
+}
+
+func TestSinkLoggerNilSink(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		output bytes.Buffer
+		logger = &SinkLogger{Logger: &LoggerWriter{&output}}
+	)
+
+	logger.Error("error message")
+	assert.Contains(output.String(), "error message")
+}
+
+func TestReportPanic(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		output bytes.Buffer
+		sink   = new(recordingSink)
+		logger = &SinkLogger{Logger: &LoggerWriter{&output}, Sink: sink}
+	)
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ReportPanic(logger, "Test panicked", r)
+			}
+		}()
+
+		panic("expected")
+	}()
+
+	assert.Contains(output.String(), "Test panicked: expected")
+	entries := sink.Entries()
+	if assert.Len(entries, 1) {
+		assert.Equal("Test panicked: expected", entries[0].Message)
+		assert.Equal(Fingerprint("Test panicked"), entries[0].Fingerprint)
+		assert.True(entries[0].Panic)
+		assert.True(strings.Contains(string(entries[0].Stack), "TestReportPanic"))
+	}
+}
+
+func TestReportPanicPlainLogger(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		output bytes.Buffer
+	)
+
+	ReportPanic(&LoggerWriter{&output}, "Test panicked", "expected")
+	assert.Contains(output.String(), "Test panicked: expected")
+}
+
+func TestRateLimitedSink(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		sink    = new(recordingSink)
+		now     = time.Now()
+		limited = &RateLimitedSink{Sink: sink, Limit: 2, Period: time.Minute}
+	)
+
+	for i := 0; i < 5; i++ {
+		limited.Report(Entry{Time: now, Fingerprint: "first"})
+	}
+
+	limited.Report(Entry{Time: now, Fingerprint: "second"})
+	assert.Len(sink.Entries(), 3)
+	assert.Equal(3, limited.Dropped())
+
+	// a new window resets the counts
+	limited.Report(Entry{Time: now.Add(time.Minute), Fingerprint: "first"})
+	assert.Len(sink.Entries(), 4)
+	assert.Equal(3, limited.Dropped())
+}
+
+func TestRateLimitedSinkNoLimit(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		sink    = new(recordingSink)
+		limited = &RateLimitedSink{Sink: sink}
+	)
+
+	for i := 0; i < 10; i++ {
+		limited.Report(Entry{Time: time.Now(), Fingerprint: "first"})
+	}
+
+	assert.Len(sink.Entries(), 10)
+	assert.Zero(limited.Dropped())
+}
@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Level is the severity of a log record
+type Level int
+
+const (
+	TraceLevel Level = iota
+	DebugLevel
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var (
+	ErrorInvalidLevel = errors.New("Invalid log level")
+
+	levelNames = [...]string{"trace", "debug", "info", "warn", "error"}
+)
+
+func (l Level) String() string {
+	if l >= TraceLevel && l <= ErrorLevel {
+		return levelNames[l]
+	}
+
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel parses the name of a level, ignoring case.  An empty name is InfoLevel.
+func ParseLevel(name string) (Level, error) {
+	if len(name) == 0 {
+		return InfoLevel, nil
+	}
+
+	for l, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(l), nil
+		}
+	}
+
+	return InfoLevel, ErrorInvalidLevel
+}
+
+// Record is a single structured log entry
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// RecordSink receives the records produced by a StructuredLogger.  Implementations must be safe
+// for concurrent use.
+type RecordSink interface {
+	WriteRecord(Record) error
+}
+
+// RecordSinkFunc is a function type that implements RecordSink
+type RecordSinkFunc func(Record) error
+
+func (f RecordSinkFunc) WriteRecord(r Record) error {
+	return f(r)
+}
+
+// StructuredLogger is a leveled Logger that produces Records with key-value fields.  It accepts both
+// structured calls, e.g. logger.Error("Unable to connect", "deviceID", id), and the printf-style calls
+// made throughout this library, so it can be supplied anywhere a Logger is configured.
+type StructuredLogger struct {
+	level  Level
+	sink   RecordSink
+	fields []Field
+}
+
+// NewStructuredLogger creates a StructuredLogger that writes records at or above the given level to
+// a sink.  If the sink is nil, records are written to os.Stdout as JSON.
+func NewStructuredLogger(level Level, sink RecordSink) *StructuredLogger {
+	if sink == nil {
+		sink = NewJSONSink(os.Stdout)
+	}
+
+	return &StructuredLogger{level: level, sink: sink}
+}
+
+// with returns a copy of this logger that adds fields to every record
+func (l *StructuredLogger) with(fields []Field) *StructuredLogger {
+	combined := make([]Field, 0, len(l.fields)+len(fields))
+	return &StructuredLogger{
+		level:  l.level,
+		sink:   l.sink,
+		fields: append(append(combined, l.fields...), fields...),
+	}
+}
+
+// With returns a copy of this logger that adds the given alternating keys and values to every record
+func (l *StructuredLogger) With(keyvals ...interface{}) *StructuredLogger {
+	return With(l, keyvals...).(*StructuredLogger)
+}
+
+// Enabled tests if records at the given level are written by this logger
+func (l *StructuredLogger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Log writes a record with the given message and alternating keys and values, if the level is enabled
+func (l *StructuredLogger) Log(level Level, message string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields, ok := keyValues(keyvals)
+	if !ok {
+		fields = []Field{{Key: "fields", Value: keyvals}}
+	}
+
+	l.write(level, message, fields)
+}
+
+func (l *StructuredLogger) write(level Level, message string, fields []Field) {
+	if len(l.fields) > 0 {
+		combined := make([]Field, 0, len(l.fields)+len(fields))
+		fields = append(append(combined, l.fields...), fields...)
+	}
+
+	if err := l.sink.WriteRecord(Record{Time: time.Now(), Level: level, Message: message, Fields: fields}); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write log record: %s\n", err)
+	}
+}
+
+func (l *StructuredLogger) logParameters(level Level, parameters []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	_, message, fields := parseParameters(parameters)
+	l.write(level, message, fields)
+}
+
+func (l *StructuredLogger) Trace(parameters ...interface{}) { l.logParameters(TraceLevel, parameters) }
+func (l *StructuredLogger) Debug(parameters ...interface{}) { l.logParameters(DebugLevel, parameters) }
+func (l *StructuredLogger) Info(parameters ...interface{})  { l.logParameters(InfoLevel, parameters) }
+func (l *StructuredLogger) Warn(parameters ...interface{})  { l.logParameters(WarnLevel, parameters) }
+func (l *StructuredLogger) Error(parameters ...interface{}) { l.logParameters(ErrorLevel, parameters) }
+
+func (l *StructuredLogger) Printf(format string, parameters ...interface{}) {
+	if l.Enabled(InfoLevel) {
+		l.write(InfoLevel, fmt.Sprintf(format, parameters...), nil)
+	}
+}
+
+// StructuredLoggerFactory is the JSON configuration for StructuredLoggers.  Each logger carries a
+// "logger" field with its name.
+type StructuredLoggerFactory struct {
+	// Level is the minimum level written, e.g. "debug".  If not supplied, "info" is used.
+	Level string `json:"level"`
+
+	// Format is either "json", for JSON records written to Output, or "syslog".  If not supplied, "json" is used.
+	Format string `json:"format"`
+
+	// SyslogTag is the tag of syslog messages.  If not supplied, the logger name is used.
+	SyslogTag string `json:"syslogTag,omitempty"`
+
+	// Output is where JSON records are written.  If not supplied, os.Stdout is used.
+	Output io.Writer `json:"-"`
+}
+
+var _ LoggerFactory = (*StructuredLoggerFactory)(nil)
+
+func (f *StructuredLoggerFactory) NewLogger(name string) (Logger, error) {
+	level, err := ParseLevel(f.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var sink RecordSink
+	switch strings.ToLower(f.Format) {
+	case "", "json":
+		if f.Output != nil {
+			sink = NewJSONSink(f.Output)
+		}
+	case "syslog":
+		tag := f.SyslogTag
+		if len(tag) == 0 {
+			tag = name
+		}
+
+		if sink, err = NewSyslogSink(tag); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported log format: %s", f.Format)
+	}
+
+	return NewStructuredLogger(level, sink).With("logger", name), nil
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+// recordingRecordSink is a RecordSink that stores every record it receives
+type recordingRecordSink struct {
+	lock    sync.Mutex
+	records []Record
+}
+
+func (r *recordingRecordSink) WriteRecord(record Record) error {
+	r.lock.Lock()
+	r.records = append(r.records, record)
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *recordingRecordSink) Records() []Record {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+func TestLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, level := range []Level{TraceLevel, DebugLevel, InfoLevel, WarnLevel, ErrorLevel} {
+		parsed, err := ParseLevel(level.String())
+		assert.Equal(level, parsed)
+		assert.NoError(err)
+	}
+
+	parsed, err := ParseLevel("WARN")
+	assert.Equal(WarnLevel, parsed)
+	assert.NoError(err)
+
+	parsed, err = ParseLevel("")
+	assert.Equal(InfoLevel, parsed)
+	assert.NoError(err)
+
+	_, err = ParseLevel("nosuch")
+	assert.Equal(ErrorInvalidLevel, err)
+	assert.Equal("Level(99)", Level(99).String())
+}
+
+func TestStructuredLogger(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		expected = errors.New("expected")
+		sink     = new(recordingRecordSink)
+		logger   = NewStructuredLogger(DebugLevel, sink)
+		calls    = 0
+	)
+
+	var _ Logger = logger
+	assert.False(logger.Enabled(TraceLevel))
+	assert.True(logger.Enabled(ErrorLevel))
+
+	logger.Trace("filtered: %s", Lazy(func() interface{} { calls++; return "value" }))
+	assert.Zero(calls)
+
+	logger.Error("Unable to connect", "deviceID", "mac:112233445566", "err", expected)
+	logger.Info("Connected %d devices", 12)
+	logger.Printf("Printf: %s", "value")
+	logger.With("deviceID", "mac:112233445566").Log(WarnLevel, "Slow consumer", "queued", 100)
+	logger.Log(TraceLevel, "filtered")
+
+	records := sink.Records()
+	require.Len(records, 4)
+
+	assert.Equal(ErrorLevel, records[0].Level)
+	assert.Equal("Unable to connect", records[0].Message)
+	assert.Equal([]Field{{"deviceID", "mac:112233445566"}, {"err", expected}}, records[0].Fields)
+	assert.False(records[0].Time.IsZero())
+
+	assert.Equal(InfoLevel, records[1].Level)
+	assert.Equal("Connected 12 devices", records[1].Message)
+	assert.Empty(records[1].Fields)
+
+	assert.Equal(InfoLevel, records[2].Level)
+	assert.Equal("Printf: value", records[2].Message)
+
+	assert.Equal(WarnLevel, records[3].Level)
+	assert.Equal([]Field{{"deviceID", "mac:112233445566"}, {"queued", 100}}, records[3].Fields)
+
+	// With on a StructuredLogger attaches fields to each record
+	contextual := With(logger, "session", "abc")
+	_, ok := contextual.(*StructuredLogger)
+	assert.True(ok)
+	contextual.Debug("resumed", "parked", 2)
+	assert.Equal([]Field{{"session", "abc"}, {"parked", 2}}, sink.Records()[4].Fields)
+	assert.Empty(logger.fields)
+}
+
+func TestStructuredLoggerFactory(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		output  bytes.Buffer
+		factory = StructuredLoggerFactory{Level: "warn", Output: &output}
+	)
+
+	logger, err := factory.NewLogger("test")
+	require.NoError(err)
+	logger.Info("filtered")
+	logger.Warn("written", "key", "value")
+
+	var record map[string]interface{}
+	require.NoError(json.Unmarshal(output.Bytes(), &record))
+	assert.Equal("warn", record["level"])
+	assert.Equal("written", record["msg"])
+	assert.Equal("test", record["logger"])
+	assert.Equal("value", record["key"])
+
+	_, err = (&StructuredLoggerFactory{Level: "nosuch"}).NewLogger("test")
+	assert.Equal(ErrorInvalidLevel, err)
+
+	_, err = (&StructuredLoggerFactory{Format: "nosuch"}).NewLogger("test")
+	assert.Error(err)
+}
@@ -0,0 +1,43 @@
+//go:build !windows && !plan9 && !nacl
+// +build !windows,!plan9,!nacl
+
+package logging
+
+import (
+	"bytes"
+	"log/syslog"
+)
+
+// syslogSink is the RecordSink returned by NewSyslogSink
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink produces a RecordSink that writes to the local syslog daemon with the given tag.  Each
+// record's fields are appended to its message in key=value form, and levels map onto syslog severities.
+func NewSyslogSink(tag string) (RecordSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) WriteRecord(r Record) error {
+	var buffer bytes.Buffer
+	buffer.WriteString(r.Message)
+	appendFields(&buffer, r.Fields)
+
+	message := buffer.String()
+	switch r.Level {
+	case TraceLevel, DebugLevel:
+		return s.writer.Debug(message)
+	case InfoLevel:
+		return s.writer.Info(message)
+	case WarnLevel:
+		return s.writer.Warning(message)
+	default:
+		return s.writer.Err(message)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9 || nacl
+// +build windows plan9 nacl
+
+package logging
+
+import "errors"
+
+var ErrorSyslogUnsupported = errors.New("Syslog is not supported on this platform")
+
+// NewSyslogSink always fails on platforms without a syslog daemon
+func NewSyslogSink(tag string) (RecordSink, error) {
+	return nil, ErrorSyslogUnsupported
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket
+type Limit struct {
+	// Rate is the number of tokens added to a bucket per second
+	Rate float64
+
+	// Burst is the maximum number of tokens a bucket holds, i.e. the largest
+	// number of requests that can be made at once
+	Burst int
+}
+
+// Result is the outcome of attempting to take a token from a bucket
+type Result struct {
+	// Allowed indicates whether a token was taken
+	Allowed bool
+
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+
+	// Reset is how long until the bucket is completely full again
+	Reset time.Duration
+
+	// RetryAfter is how long until a token will be available.  This is zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// Backend is the strategy for storing token buckets.  Implementations must be safe for
+// concurrent use.
+type Backend interface {
+	// Take attempts to remove a single token from the bucket identified by key.  A bucket that
+	// does not exist yet is treated as full.
+	Take(key string, limit Limit) (Result, error)
+}
+
+// refill computes the tokens in a bucket at time now, given the tokens it held at time last
+func refill(tokens float64, last, now time.Time, limit Limit) float64 {
+	if elapsed := now.Sub(last); elapsed > 0 {
+		tokens += elapsed.Seconds() * limit.Rate
+	}
+
+	return math.Min(tokens, float64(limit.Burst))
+}
+
+// take removes a token if one is available, returning the new token count and the result
+func take(tokens float64, limit Limit) (float64, Result) {
+	allowed := false
+	if tokens >= 1.0 {
+		tokens -= 1.0
+		allowed = true
+	}
+
+	return tokens, newResult(allowed, tokens, limit)
+}
+
+// newResult describes a bucket holding the given tokens after a take attempt
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	result := Result{
+		Allowed:   allowed,
+		Remaining: int(tokens),
+	}
+
+	if limit.Rate > 0 {
+		result.Reset = seconds((float64(limit.Burst) - tokens) / limit.Rate)
+		if !allowed {
+			result.RetryAfter = seconds((1.0 - tokens) / limit.Rate)
+		}
+	}
+
+	return result
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(math.Ceil(value * float64(time.Second)))
+}
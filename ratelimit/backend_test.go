@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRefill(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		limit  = Limit{Rate: 2.0, Burst: 10}
+		last   = time.Unix(1000, 0)
+	)
+
+	assert.Equal(3.0, refill(3.0, last, last, limit))
+	assert.Equal(3.0, refill(3.0, last, last.Add(-time.Second), limit))
+	assert.Equal(5.0, refill(3.0, last, last.Add(time.Second), limit))
+	assert.Equal(10.0, refill(3.0, last, last.Add(time.Hour), limit))
+}
+
+func TestTake(t *testing.T) {
+	testData := []struct {
+		tokens         float64
+		limit          Limit
+		expectedTokens float64
+		expectedResult Result
+	}{
+		{
+			5.0, Limit{Rate: 1.0, Burst: 5},
+			4.0, Result{Allowed: true, Remaining: 4, Reset: time.Second},
+		},
+		{
+			1.5, Limit{Rate: 2.0, Burst: 4},
+			0.5, Result{Allowed: true, Remaining: 0, Reset: 1750 * time.Millisecond},
+		},
+		{
+			0.5, Limit{Rate: 2.0, Burst: 4},
+			0.5, Result{Allowed: false, Remaining: 0, Reset: 1750 * time.Millisecond, RetryAfter: 250 * time.Millisecond},
+		},
+		{
+			0.0, Limit{Rate: 0.0, Burst: 4},
+			0.0, Result{Allowed: false, Remaining: 0},
+		},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		tokens, result := take(record.tokens, record.limit)
+		assert.Equal(t, record.expectedTokens, tokens)
+		assert.Equal(t, record.expectedResult, result)
+	}
+}
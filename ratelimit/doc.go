@@ -0,0 +1,15 @@
+/*
+Package ratelimit provides token-bucket rate limiting for HTTP handlers.  Requests are grouped into
+buckets by a KeyFunc, e.g. by client IP, device ID, or partner, and each bucket refills at a fixed
+rate up to a maximum burst.
+
+Bucket state lives in a Backend.  NewMemoryBackend keeps state in-process, which is sufficient
+for a single node.  NewRedisBackend keeps state in Redis so that every node in a cluster shares
+the same buckets.
+
+A Limiter applies a single policy to every request it handles.  A RouteLimiter instead applies
+separate policies to requests whose paths match configured patterns, so that expensive endpoints
+can be protected independently.  server.WebPA applies RouteLimiter to the primary handler using
+its RateLimits configuration.
+*/
+package ratelimit
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/fact"
+	"net"
+	"net/http"
+)
+
+const (
+	// DefaultPartnerHeader is the request header consulted by PartnerKey when no principal
+	// is present in the request context
+	DefaultPartnerHeader = "X-Webpa-Partner-Id"
+
+	RemoteAddrPrefix = "ip:"
+	DeviceIDPrefix   = "device:"
+	PartnerPrefix    = "partner:"
+	HeaderPrefix     = "header:"
+)
+
+// KeyFunc identifies the bucket a request draws tokens from.  If the returned bool is false,
+// the request has no key and is not rate limited.
+//
+// Keys produced by the KeyFuncs in this package carry a prefix that distinguishes the kind of
+// key, so different Limiters can share a Backend without colliding.
+type KeyFunc func(*http.Request) (string, bool)
+
+// RemoteAddrKey groups requests by the IP address of the client connection
+func RemoteAddrKey(request *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	if len(host) == 0 {
+		return "", false
+	}
+
+	return RemoteAddrPrefix + host, true
+}
+
+// DeviceIDKey groups requests by device.  The device ID is taken from the request context if
+// present, falling back to the device name header used by device.Manager.
+func DeviceIDKey(request *http.Request) (string, bool) {
+	if id, ok := fact.DeviceID(request.Context()); ok && len(id) > 0 {
+		return DeviceIDPrefix + id, true
+	}
+
+	if id, err := device.ParseID(request.Header.Get(device.DefaultDeviceNameHeader)); err == nil {
+		return DeviceIDPrefix + string(id), true
+	}
+
+	return "", false
+}
+
+// PartnerKey groups requests by partner.  The principal in the request context is used if
+// present, falling back to DefaultPartnerHeader.
+func PartnerKey(request *http.Request) (string, bool) {
+	if principal, ok := fact.Principal(request.Context()); ok && len(principal) > 0 {
+		return PartnerPrefix + principal, true
+	}
+
+	if partner := request.Header.Get(DefaultPartnerHeader); len(partner) > 0 {
+		return PartnerPrefix + partner, true
+	}
+
+	return "", false
+}
+
+// HeaderKey produces a KeyFunc that groups requests by the value of an arbitrary header
+func HeaderKey(name string) KeyFunc {
+	prefix := HeaderPrefix + http.CanonicalHeaderKey(name) + ":"
+	return func(request *http.Request) (string, bool) {
+		if value := request.Header.Get(name); len(value) > 0 {
+			return prefix + value, true
+		}
+
+		return "", false
+	}
+}
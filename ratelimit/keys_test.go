@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/fact"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteAddrKey(t *testing.T) {
+	testData := []struct {
+		remoteAddr  string
+		expectedKey string
+		expectedOK  bool
+	}{
+		{"192.168.1.1:32000", "ip:192.168.1.1", true},
+		{"[::1]:8080", "ip:::1", true},
+		{"10.0.0.1", "ip:10.0.0.1", true},
+		{"", "", false},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = record.remoteAddr
+
+		key, ok := RemoteAddrKey(request)
+		assert.Equal(t, record.expectedKey, key)
+		assert.Equal(t, record.expectedOK, ok)
+	}
+}
+
+func TestDeviceIDKey(t *testing.T) {
+	assert := assert.New(t)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	key, ok := DeviceIDKey(request)
+	assert.Equal("", key)
+	assert.False(ok)
+
+	request.Header.Set(device.DefaultDeviceNameHeader, "mac:112233445566")
+	key, ok = DeviceIDKey(request)
+	assert.Equal("device:mac:112233445566", key)
+	assert.True(ok)
+
+	request = request.WithContext(fact.SetDeviceID(request.Context(), string(device.IntToMAC(0xDEADBEEF))))
+	key, ok = DeviceIDKey(request)
+	assert.Equal("device:mac:0000deadbeef", key)
+	assert.True(ok)
+}
+
+func TestPartnerKey(t *testing.T) {
+	assert := assert.New(t)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	key, ok := PartnerKey(request)
+	assert.Equal("", key)
+	assert.False(ok)
+
+	request.Header.Set(DefaultPartnerHeader, "comcast")
+	key, ok = PartnerKey(request)
+	assert.Equal("partner:comcast", key)
+	assert.True(ok)
+
+	request = request.WithContext(fact.SetPrincipal(request.Context(), "cox"))
+	key, ok = PartnerKey(request)
+	assert.Equal("partner:cox", key)
+	assert.True(ok)
+}
+
+func TestHeaderKey(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		keyFunc = HeaderKey("x-api-key")
+		request = httptest.NewRequest("GET", "/", nil)
+	)
+
+	key, ok := keyFunc(request)
+	assert.Equal("", key)
+	assert.False(ok)
+
+	request.Header.Set("X-Api-Key", "abc")
+	key, ok = keyFunc(request)
+	assert.Equal("header:X-Api-Key:abc", key)
+	assert.True(ok)
+}
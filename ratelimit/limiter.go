@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"github.com/Comcast/webpa-common/httperror"
+	"github.com/Comcast/webpa-common/logging"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	LimitHeader      = "X-RateLimit-Limit"
+	RemainingHeader  = "X-RateLimit-Remaining"
+	ResetHeader      = "X-RateLimit-Reset"
+	RetryAfterHeader = "Retry-After"
+
+	DefaultRate    = 10.0
+	DefaultBurst   = 20
+	DefaultMessage = "Rate limit exceeded"
+)
+
+// Limiter is an Alice-style decorator that applies token-bucket rate limiting to a handler.
+// Every response carries the standard X-RateLimit-* headers.  Requests that exceed the limit
+// receive a 429 with a Retry-After header and a JSON error body.
+//
+// If the Backend returns an error, the error is logged and the request is allowed through.
+type Limiter struct {
+	// KeyFunc identifies the bucket for each request.  If nil, RemoteAddrKey is used.
+	KeyFunc KeyFunc
+
+	// Rate is the number of requests per second each bucket allows.  If nonpositive, DefaultRate is used.
+	Rate float64
+
+	// Burst is the maximum number of requests each bucket allows at once.  If nonpositive, DefaultBurst is used.
+	Burst int
+
+	// Backend stores the buckets.  If nil, each handler decorated by Then gets its own memory backend.
+	Backend Backend
+
+	// Message is the text placed in the JSON body of rejected requests.  If empty, DefaultMessage is used.
+	Message string
+
+	// Logger receives backend errors.  If nil, logging.DefaultLogger() is used.
+	Logger logging.Logger
+}
+
+func (l *Limiter) keyFunc() KeyFunc {
+	if l != nil && l.KeyFunc != nil {
+		return l.KeyFunc
+	}
+
+	return RemoteAddrKey
+}
+
+func (l *Limiter) limit() Limit {
+	limit := Limit{Rate: DefaultRate, Burst: DefaultBurst}
+	if l != nil && l.Rate > 0 {
+		limit.Rate = l.Rate
+	}
+
+	if l != nil && l.Burst > 0 {
+		limit.Burst = l.Burst
+	}
+
+	return limit
+}
+
+func (l *Limiter) backend() Backend {
+	if l != nil && l.Backend != nil {
+		return l.Backend
+	}
+
+	return NewMemoryBackend()
+}
+
+func (l *Limiter) message() string {
+	if l != nil && len(l.Message) > 0 {
+		return l.Message
+	}
+
+	return DefaultMessage
+}
+
+func (l *Limiter) logger() logging.Logger {
+	if l != nil && l.Logger != nil {
+		return l.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+// wholeSeconds renders a duration as a header value, rounding up
+func wholeSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// Then decorates the delegate with this rate limiting policy
+func (l *Limiter) Then(delegate http.Handler) http.Handler {
+	var (
+		keyFunc = l.keyFunc()
+		limit   = l.limit()
+		backend = l.backend()
+		message = l.message()
+		logger  = l.logger()
+		burst   = strconv.Itoa(limit.Burst)
+	)
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		key, ok := keyFunc(request)
+		if !ok {
+			delegate.ServeHTTP(response, request)
+			return
+		}
+
+		result, err := backend.Take(key, limit)
+		if err != nil {
+			logger.Error("Unable to apply rate limit for [%s]: %s", key, err)
+			delegate.ServeHTTP(response, request)
+			return
+		}
+
+		header := response.Header()
+		header.Set(LimitHeader, burst)
+		header.Set(RemainingHeader, strconv.Itoa(result.Remaining))
+		header.Set(ResetHeader, wholeSeconds(result.Reset))
+
+		if !result.Allowed {
+			header.Set(RetryAfterHeader, wholeSeconds(result.RetryAfter))
+			httperror.Formatf(response, http.StatusTooManyRequests, "%s", message)
+			return
+		}
+
+		delegate.ServeHTTP(response, request)
+	})
+}
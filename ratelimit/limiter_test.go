@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterDefaults(t *testing.T) {
+	assert := assert.New(t)
+	for _, l := range []*Limiter{nil, new(Limiter), &Limiter{Rate: -1.0, Burst: -1}} {
+		t.Logf("%#v", l)
+		assert.NotNil(l.keyFunc())
+		assert.Equal(Limit{Rate: DefaultRate, Burst: DefaultBurst}, l.limit())
+		assert.NotNil(l.backend())
+		assert.Equal(DefaultMessage, l.message())
+		assert.NotNil(l.logger())
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		called  = 0
+		limiter = Limiter{
+			Rate:    1.0,
+			Burst:   2,
+			Message: "slow down",
+		}
+
+		handler = limiter.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			called++
+			response.WriteHeader(http.StatusOK)
+		}))
+	)
+
+	for expected := 1; expected >= 0; expected-- {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(http.StatusOK, response.Code)
+		assert.Equal("2", response.Header().Get(LimitHeader))
+		assert.Equal(string('0'+rune(expected)), response.Header().Get(RemainingHeader))
+		assert.NotEqual("", response.Header().Get(ResetHeader))
+		assert.Equal("", response.Header().Get(RetryAfterHeader))
+	}
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal("0", response.Header().Get(RemainingHeader))
+	assert.Equal("1", response.Header().Get(RetryAfterHeader))
+	assert.JSONEq(`{"code": 429, "message": "slow down"}`, response.Body.String())
+	assert.Equal(2, called)
+}
+
+func TestLimiterNoKey(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		backend = new(mockBackend)
+		limiter = Limiter{
+			KeyFunc: func(*http.Request) (string, bool) { return "", false },
+			Backend: backend,
+		}
+
+		handler = limiter.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			response.WriteHeader(http.StatusAccepted)
+		}))
+
+		response = httptest.NewRecorder()
+	)
+
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusAccepted, response.Code)
+	assert.Equal("", response.Header().Get(LimitHeader))
+	backend.AssertExpectations(t)
+}
+
+func TestLimiterBackendError(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		backend = new(mockBackend)
+		limiter = Limiter{
+			KeyFunc: HeaderKey("X-Key"),
+			Backend: backend,
+			Logger:  logging.TestLogger(t),
+		}
+
+		handler = limiter.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			response.WriteHeader(http.StatusAccepted)
+		}))
+
+		request  = httptest.NewRequest("GET", "/", nil)
+		response = httptest.NewRecorder()
+	)
+
+	request.Header.Set("X-Key", "value")
+	backend.On("Take", "header:X-Key:value", Limit{Rate: DefaultRate, Burst: DefaultBurst}).
+		Return(Result{}, errors.New("expected")).
+		Once()
+
+	handler.ServeHTTP(response, request)
+	assert.Equal(http.StatusAccepted, response.Code)
+	assert.Equal("", response.Header().Get(LimitHeader))
+	backend.AssertExpectations(t)
+}
+
+func TestWholeSeconds(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("0", wholeSeconds(0))
+	assert.Equal("1", wholeSeconds(time.Millisecond))
+	assert.Equal("1", wholeSeconds(time.Second))
+	assert.Equal("3", wholeSeconds(2500*time.Millisecond))
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSweepInterval is how often a memory backend discards buckets that have refilled
+	DefaultSweepInterval = time.Minute
+)
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// memoryBackend is the in-process Backend
+type memoryBackend struct {
+	lock          sync.Mutex
+	buckets       map[string]*memoryBucket
+	now           func() time.Time
+	sweepInterval time.Duration
+	lastSweep     time.Time
+}
+
+// NewMemoryBackend creates a Backend that keeps buckets in memory.  Buckets that have completely
+// refilled are periodically discarded, since a missing bucket is equivalent to a full one.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		buckets:       make(map[string]*memoryBucket),
+		now:           time.Now,
+		sweepInterval: DefaultSweepInterval,
+	}
+}
+
+func (mb *memoryBackend) Take(key string, limit Limit) (Result, error) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	now := mb.now()
+	if now.Sub(mb.lastSweep) >= mb.sweepInterval {
+		mb.sweep(now)
+	}
+
+	b, ok := mb.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Burst), last: now}
+		mb.buckets[key] = b
+	}
+
+	var result Result
+	b.tokens, result = take(refill(b.tokens, b.last, now, limit), limit)
+	b.last = now
+	b.limit = limit
+	return result, nil
+}
+
+// sweep discards full buckets.  This method must be invoked under the lock.
+func (mb *memoryBackend) sweep(now time.Time) {
+	mb.lastSweep = now
+	for key, b := range mb.buckets {
+		if refill(b.tokens, b.last, now, b.limit) >= float64(b.limit.Burst) {
+			delete(mb.buckets, key)
+		}
+	}
+}
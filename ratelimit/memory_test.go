@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		clock   = newTestClock()
+		backend = NewMemoryBackend().(*memoryBackend)
+		limit   = Limit{Rate: 1.0, Burst: 3}
+	)
+
+	backend.now = clock.now
+
+	for expected := 2; expected >= 0; expected-- {
+		result, err := backend.Take("key", limit)
+		require.NoError(err)
+		assert.True(result.Allowed)
+		assert.Equal(expected, result.Remaining)
+	}
+
+	result, err := backend.Take("key", limit)
+	require.NoError(err)
+	assert.False(result.Allowed)
+	assert.Equal(time.Second, result.RetryAfter)
+	assert.Equal(3*time.Second, result.Reset)
+
+	// other keys have their own buckets
+	result, err = backend.Take("other", limit)
+	require.NoError(err)
+	assert.True(result.Allowed)
+
+	clock.advance(time.Second)
+	result, err = backend.Take("key", limit)
+	require.NoError(err)
+	assert.True(result.Allowed)
+	assert.Equal(0, result.Remaining)
+}
+
+func TestMemoryBackendSweep(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		clock   = newTestClock()
+		backend = NewMemoryBackend().(*memoryBackend)
+		limit   = Limit{Rate: 1.0, Burst: 3}
+	)
+
+	backend.now = clock.now
+	backend.Take("sweep", limit)
+	backend.Take("keep", Limit{Rate: 0.001, Burst: 3})
+	assert.Len(backend.buckets, 2)
+
+	clock.advance(DefaultSweepInterval)
+	backend.Take("new", limit)
+
+	assert.Len(backend.buckets, 2)
+	assert.Contains(backend.buckets, "keep")
+	assert.Contains(backend.buckets, "new")
+	assert.NotContains(backend.buckets, "sweep")
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/mock"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// testClock is a manually advanced clock
+type testClock struct {
+	lock    sync.Mutex
+	current time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{current: time.Unix(1500000000, 0)}
+}
+
+func (tc *testClock) now() time.Time {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.current
+}
+
+func (tc *testClock) advance(d time.Duration) {
+	tc.lock.Lock()
+	tc.current = tc.current.Add(d)
+	tc.lock.Unlock()
+}
+
+type mockBackend struct {
+	mock.Mock
+}
+
+func (m *mockBackend) Take(key string, limit Limit) (Result, error) {
+	arguments := m.Called(key, limit)
+	return arguments.Get(0).(Result), arguments.Error(1)
+}
+
+// fakeRedis emulates the bucket script, so that the redis backend can be tested without a server
+type fakeRedis struct {
+	lock     sync.Mutex
+	buckets  map[string][2]float64
+	commands []string
+	err      error
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{buckets: make(map[string][2]float64)}
+}
+
+func (fr *fakeRedis) pool() *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return &fakeConn{fakeRedis: fr}, nil
+		},
+	}
+}
+
+func (fr *fakeRedis) do(command string, args []interface{}) (interface{}, error) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	if len(command) == 0 {
+		return nil, nil
+	}
+
+	fr.commands = append(fr.commands, command)
+	if fr.err != nil {
+		return nil, fr.err
+	}
+
+	switch command {
+	case "EVALSHA":
+		return nil, redis.Error("NOSCRIPT No matching script")
+
+	case "EVAL":
+		var (
+			key      = args[2].(string)
+			burst    = float64(args[3].(int))
+			rate, _  = strconv.ParseFloat(args[4].(string), 64)
+			now, _   = strconv.ParseFloat(args[5].(string), 64)
+			state, _ = fr.buckets[key]
+			tokens   = burst
+			last     = now
+		)
+
+		if _, ok := fr.buckets[key]; ok {
+			tokens, last = state[0], state[1]
+		}
+
+		if now > last {
+			tokens += (now - last) * rate
+			if tokens > burst {
+				tokens = burst
+			}
+		}
+
+		allowed := int64(0)
+		if tokens >= 1 {
+			tokens--
+			allowed = 1
+		}
+
+		fr.buckets[key] = [2]float64{tokens, now}
+		return []interface{}{allowed, []byte(strconv.FormatFloat(tokens, 'f', -1, 64))}, nil
+
+	default:
+		return nil, errors.New("unsupported command: " + command)
+	}
+}
+
+type fakeConn struct {
+	*fakeRedis
+}
+
+func (fc *fakeConn) Close() error { return nil }
+func (fc *fakeConn) Err() error   { return nil }
+
+func (fc *fakeConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return fc.do(command, args)
+}
+
+func (fc *fakeConn) Send(string, ...interface{}) error { return errors.New("not supported") }
+func (fc *fakeConn) Flush() error                      { return nil }
+func (fc *fakeConn) Receive() (interface{}, error)     { return nil, errors.New("not supported") }
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRedisKeyPrefix is prepended to bucket keys stored in Redis
+	DefaultRedisKeyPrefix = "webpa.ratelimit."
+)
+
+// takeScript atomically refills and takes from a bucket stored as a Redis hash.  The current
+// time is supplied by the caller, so nodes sharing a Redis server should have synchronized clocks.
+//
+// KEYS[1] is the bucket key.  ARGV is the burst, the rate, and the current time in fractional seconds.
+// The reply is {allowed, tokens}, with tokens as a string to preserve its fractional part.
+var takeScript = redis.NewScript(1, `
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) * rate)
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+if rate > 0 then
+	redis.call('EXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+end
+
+return {allowed, tostring(tokens)}
+`)
+
+// redisBackend is a Backend that shares buckets between nodes through Redis
+type redisBackend struct {
+	pool      *redis.Pool
+	keyPrefix string
+	now       func() time.Time
+}
+
+// NewRedisBackend creates a Backend that stores buckets in Redis.  The pool is typically created
+// with store/redis.NewPool.  If keyPrefix is empty, DefaultRedisKeyPrefix is used.  Buckets expire
+// from Redis once they would have completely refilled.
+func NewRedisBackend(pool *redis.Pool, keyPrefix string) Backend {
+	if len(keyPrefix) == 0 {
+		keyPrefix = DefaultRedisKeyPrefix
+	}
+
+	return &redisBackend{
+		pool:      pool,
+		keyPrefix: keyPrefix,
+		now:       time.Now,
+	}
+}
+
+func (rb *redisBackend) Take(key string, limit Limit) (Result, error) {
+	conn := rb.pool.Get()
+	defer conn.Close()
+
+	now := float64(rb.now().UnixNano()) / float64(time.Second)
+	reply, err := redis.Values(
+		takeScript.Do(
+			conn,
+			rb.keyPrefix+key,
+			limit.Burst,
+			strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+			strconv.FormatFloat(now, 'f', -1, 64),
+		),
+	)
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	var (
+		allowed int
+		tokens  string
+	)
+
+	if _, err := redis.Scan(reply, &allowed, &tokens); err != nil {
+		return Result{}, err
+	}
+
+	remaining, err := strconv.ParseFloat(tokens, 64)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(allowed == 1, remaining, limit), nil
+}
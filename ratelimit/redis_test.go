@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRedisBackend(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		clock   = newTestClock()
+		fake    = newFakeRedis()
+		backend = NewRedisBackend(fake.pool(), "").(*redisBackend)
+		limit   = Limit{Rate: 2.0, Burst: 2}
+	)
+
+	backend.now = clock.now
+	assert.Equal(DefaultRedisKeyPrefix, backend.keyPrefix)
+
+	for expected := 1; expected >= 0; expected-- {
+		result, err := backend.Take("key", limit)
+		require.NoError(err)
+		assert.True(result.Allowed)
+		assert.Equal(expected, result.Remaining)
+	}
+
+	result, err := backend.Take("key", limit)
+	require.NoError(err)
+	assert.False(result.Allowed)
+	assert.Equal(500*time.Millisecond, result.RetryAfter)
+	assert.Equal(time.Second, result.Reset)
+
+	clock.advance(500 * time.Millisecond)
+	result, err = backend.Take("key", limit)
+	require.NoError(err)
+	assert.True(result.Allowed)
+
+	assert.Contains(fake.buckets, DefaultRedisKeyPrefix+"key")
+	assert.Contains(fake.commands, "EVAL")
+}
+
+func TestRedisBackendKeyPrefix(t *testing.T) {
+	var (
+		fake    = newFakeRedis()
+		backend = NewRedisBackend(fake.pool(), "custom.")
+	)
+
+	_, err := backend.Take("key", Limit{Rate: 1.0, Burst: 1})
+	assert.NoError(t, err)
+	assert.Contains(t, fake.buckets, "custom.key")
+}
+
+func TestRedisBackendError(t *testing.T) {
+	var (
+		fake          = newFakeRedis()
+		backend       = NewRedisBackend(fake.pool(), "")
+		expectedError = errors.New("expected")
+	)
+
+	fake.err = expectedError
+	result, err := backend.Take("key", Limit{Rate: 1.0, Burst: 1})
+	assert.Equal(t, Result{}, result)
+	assert.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	// RoutePrefix is prepended, along with the route's pattern, to the keys of each RouteLimit
+	// so that routes sharing a Backend never share buckets
+	RoutePrefix = "route:"
+
+	RemoteAddrKeyName = "ip"
+	DeviceIDKeyName   = "device"
+	PartnerKeyName    = "partner"
+	HeaderKeyName     = "header:"
+)
+
+var (
+	ErrorRoutePatternRequired = errors.New("A rate limit route pattern is required")
+)
+
+// NewKeyFunc produces the KeyFunc with the given configuration name.  The names are ip, device,
+// partner, and header:<name>.  An empty name is the same as ip.
+func NewKeyFunc(name string) (KeyFunc, error) {
+	switch {
+	case len(name) == 0 || name == RemoteAddrKeyName:
+		return RemoteAddrKey, nil
+	case name == DeviceIDKeyName:
+		return DeviceIDKey, nil
+	case name == PartnerKeyName:
+		return PartnerKey, nil
+	case strings.HasPrefix(name, HeaderKeyName) && len(name) > len(HeaderKeyName):
+		return HeaderKey(name[len(HeaderKeyName):]), nil
+	default:
+		return nil, fmt.Errorf("Unrecognized rate limit key: %s", name)
+	}
+}
+
+// RouteLimit is the configuration of a rate limit that applies only to requests whose path
+// matches a pattern.  Typically, this struct has its values injected via Viper.
+type RouteLimit struct {
+	// Pattern is matched against the request's URL path using path.Match syntax, e.g.
+	// /api/v2/device/*/stat.  As with http.ServeMux, a pattern ending in a slash matches
+	// every path beneath it.
+	Pattern string
+
+	// Methods restricts this limit to the given HTTP methods.  If empty, all methods are limited.
+	Methods []string
+
+	// Key names the KeyFunc used to group requests.  See NewKeyFunc.
+	Key string
+
+	// Rate is the number of requests per second each bucket allows.  If nonpositive, DefaultRate is used.
+	Rate float64
+
+	// Burst is the maximum number of requests each bucket allows at once.  If nonpositive, DefaultBurst is used.
+	Burst int
+
+	// Message is the text placed in the JSON body of rejected requests.  If empty, DefaultMessage is used.
+	Message string
+}
+
+// matches tests whether this limit applies to a request
+func (rl *RouteLimit) matches(request *http.Request) bool {
+	if len(rl.Methods) > 0 {
+		found := false
+		for _, method := range rl.Methods {
+			if strings.EqualFold(method, request.Method) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	requestPath := request.URL.Path
+	if strings.HasSuffix(rl.Pattern, "/") {
+		// match the pattern against the leading segments of the path
+		position := 0
+		for slashes := strings.Count(rl.Pattern, "/"); slashes > 0; slashes-- {
+			next := strings.IndexByte(requestPath[position:], '/')
+			if next < 0 {
+				return false
+			}
+
+			position += next + 1
+		}
+
+		requestPath = requestPath[:position]
+	}
+
+	matched, _ := path.Match(rl.Pattern, requestPath)
+	return matched
+}
+
+// RouteLimiter applies per-route rate limits to a handler.  It is distinct from a Limiter
+// applied to an entire server: requests that match no route are passed through unchanged, so
+// expensive endpoints can be protected without constraining the rest of an API.  Routes are
+// consulted in order, and only the first matching route is applied to a request.
+//
+// Responses to limited requests carry the same X-RateLimit-* headers and 429 bodies as Limiter.
+type RouteLimiter struct {
+	// Routes are the per-route limits
+	Routes []RouteLimit
+
+	// Backend is shared by all routes.  If nil, each route gets its own memory backend.
+	Backend Backend
+
+	// Logger receives backend errors.  If nil, logging.DefaultLogger() is used.
+	Logger logging.Logger
+}
+
+type route struct {
+	limit   RouteLimit
+	handler http.Handler
+}
+
+// Then decorates the delegate with this set of route limits.  An error is returned if any
+// route is misconfigured.
+func (rl *RouteLimiter) Then(delegate http.Handler) (http.Handler, error) {
+	if len(rl.Routes) == 0 {
+		return delegate, nil
+	}
+
+	routes := make([]route, 0, len(rl.Routes))
+	for _, limit := range rl.Routes {
+		if len(limit.Pattern) == 0 {
+			return nil, ErrorRoutePatternRequired
+		}
+
+		if _, err := path.Match(limit.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid rate limit route pattern [%s]: %s", limit.Pattern, err)
+		}
+
+		keyFunc, err := NewKeyFunc(limit.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		limiter := Limiter{
+			KeyFunc: prefixKey(RoutePrefix+limit.Pattern+":", keyFunc),
+			Rate:    limit.Rate,
+			Burst:   limit.Burst,
+			Backend: rl.Backend,
+			Message: limit.Message,
+			Logger:  rl.Logger,
+		}
+
+		routes = append(routes, route{limit: limit, handler: limiter.Then(delegate)})
+	}
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		for _, r := range routes {
+			if r.limit.matches(request) {
+				r.handler.ServeHTTP(response, request)
+				return
+			}
+		}
+
+		delegate.ServeHTTP(response, request)
+	}), nil
+}
+
+// prefixKey decorates a KeyFunc so that each of its keys carries a prefix
+func prefixKey(prefix string, keyFunc KeyFunc) KeyFunc {
+	return func(request *http.Request) (string, bool) {
+		key, ok := keyFunc(request)
+		if !ok {
+			return "", false
+		}
+
+		return prefix + key, true
+	}
+}
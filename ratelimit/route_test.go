@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewKeyFunc(t *testing.T) {
+	assert := assert.New(t)
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set(DefaultPartnerHeader, "comcast")
+	request.Header.Set("X-Custom", "value")
+
+	testData := []struct {
+		name        string
+		expectedKey string
+	}{
+		{"", "ip:10.0.0.1"},
+		{"ip", "ip:10.0.0.1"},
+		{"partner", "partner:comcast"},
+		{"header:X-Custom", "header:X-Custom:value"},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		keyFunc, err := NewKeyFunc(record.name)
+		if assert.NoError(err) {
+			key, ok := keyFunc(request)
+			assert.True(ok)
+			assert.Equal(record.expectedKey, key)
+		}
+	}
+
+	keyFunc, err := NewKeyFunc("device")
+	assert.NotNil(keyFunc)
+	assert.NoError(err)
+
+	for _, invalid := range []string{"nosuch", "header:"} {
+		keyFunc, err := NewKeyFunc(invalid)
+		assert.Nil(keyFunc)
+		assert.Error(err)
+	}
+}
+
+func TestRouteLimitMatches(t *testing.T) {
+	testData := []struct {
+		limit    RouteLimit
+		method   string
+		path     string
+		expected bool
+	}{
+		{RouteLimit{Pattern: "/api/v2/devices"}, "GET", "/api/v2/devices", true},
+		{RouteLimit{Pattern: "/api/v2/devices"}, "GET", "/api/v2/devices/more", false},
+		{RouteLimit{Pattern: "/api/v2/device/*/stat"}, "GET", "/api/v2/device/mac:112233445566/stat", true},
+		{RouteLimit{Pattern: "/api/v2/device/*/stat"}, "GET", "/api/v2/device/mac:112233445566", false},
+		{RouteLimit{Pattern: "/api/v2/"}, "GET", "/api/v2/devices", true},
+		{RouteLimit{Pattern: "/api/v2/"}, "GET", "/api/v2/", true},
+		{RouteLimit{Pattern: "/api/v2/"}, "GET", "/api/v2", false},
+		{RouteLimit{Pattern: "/api/*/"}, "GET", "/api/v3/device/foo", true},
+		{RouteLimit{Pattern: "/api/*/"}, "GET", "/other/v3/device", false},
+		{RouteLimit{Pattern: "/api/v2/devices", Methods: []string{"post"}}, "POST", "/api/v2/devices", true},
+		{RouteLimit{Pattern: "/api/v2/devices", Methods: []string{"POST"}}, "GET", "/api/v2/devices", false},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		assert.Equal(t, record.expected, record.limit.matches(httptest.NewRequest(record.method, record.path, nil)))
+	}
+}
+
+func TestRouteLimiterNoRoutes(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		delegate = http.NotFoundHandler()
+	)
+
+	handler, err := new(RouteLimiter).Then(delegate)
+	assert.NotNil(handler)
+	assert.NoError(err)
+}
+
+func TestRouteLimiterInvalid(t *testing.T) {
+	for _, invalid := range []RouteLimit{{}, {Pattern: "/api/["}, {Pattern: "/api", Key: "nosuch"}} {
+		t.Logf("%#v", invalid)
+		routeLimiter := RouteLimiter{Routes: []RouteLimit{invalid}}
+		handler, err := routeLimiter.Then(http.NotFoundHandler())
+		assert.Nil(t, handler)
+		assert.Error(t, err)
+	}
+}
+
+func TestRouteLimiter(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		called       = 0
+		routeLimiter = RouteLimiter{
+			Routes: []RouteLimit{
+				{Pattern: "/api/v2/devices", Rate: 1.0, Burst: 1, Message: "too many device listings"},
+				{Pattern: "/api/v2/", Rate: 1.0, Burst: 2},
+			},
+			Backend: NewMemoryBackend(),
+		}
+
+		delegate = http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			called++
+			response.WriteHeader(http.StatusOK)
+		})
+	)
+
+	handler, err := routeLimiter.Then(delegate)
+	require.NotNil(handler)
+	require.NoError(err)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+		return response
+	}
+
+	response := serve("/api/v2/devices")
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("1", response.Header().Get(LimitHeader))
+	assert.Equal("0", response.Header().Get(RemainingHeader))
+	assert.NotEqual("", response.Header().Get(ResetHeader))
+
+	response = serve("/api/v2/devices")
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal("1", response.Header().Get(RetryAfterHeader))
+	assert.JSONEq(`{"code": 429, "message": "too many device listings"}`, response.Body.String())
+
+	// the catch-all route has its own buckets, even though the backend is shared
+	response = serve("/api/v2/device/mac:112233445566/stat")
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("2", response.Header().Get(LimitHeader))
+	assert.Equal("1", response.Header().Get(RemainingHeader))
+
+	// paths matching no route are not limited
+	for repeat := 0; repeat < 5; repeat++ {
+		response = serve("/health")
+		assert.Equal(http.StatusOK, response.Code)
+		assert.Equal("", response.Header().Get(LimitHeader))
+	}
+
+	assert.Equal(7, called)
+}
@@ -0,0 +1,163 @@
+package secure
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/secure/key"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jws"
+	"time"
+)
+
+const (
+	// DefaultTokenAlgorithm is the signing algorithm used when a TokenBuilder does not specify one
+	DefaultTokenAlgorithm = "RS256"
+
+	// DefaultTokenLifetime is the interval between issue and expiry used when a TokenBuilder
+	// does not specify a lifetime
+	DefaultTokenLifetime = time.Hour
+)
+
+var (
+	ErrorNoSigningKey = errors.New("A signing key is required to mint tokens")
+)
+
+// TokenBuilder mints signed JWTs.  It is intended for integration tests and tooling that need valid
+// credentials without an external token issuer.  The zero value is not usable, as a signing Key is required.
+//
+// A TokenBuilder is not modified by Build, so a single builder may be used to mint any number of tokens.
+type TokenBuilder struct {
+	// Key is the private key, or HMAC secret for HS* algorithms, used to sign tokens
+	Key interface{}
+
+	// KeyId is the optional key identifier sent in the kid protected header
+	KeyId string
+
+	// Algorithm is the JWS signing algorithm, e.g. RS256.  If unset, DefaultTokenAlgorithm is used.
+	Algorithm string
+
+	// Issuer, Subject, and Audience are the optional iss, sub, and aud claims
+	Issuer   string
+	Subject  string
+	Audience []string
+
+	// Lifetime is the interval from issue to expiry.  If zero, DefaultTokenLifetime is used.  A negative
+	// lifetime produces a token that has already expired, which is useful for testing validation.
+	Lifetime time.Duration
+
+	// NotBefore, if nonzero, is added to the issue time to produce the nbf claim
+	NotBefore time.Duration
+
+	// Claims holds any custom claims, e.g. capabilities.  These claims are copied into
+	// each token, and standard claims set by this builder take precedence.
+	Claims map[string]interface{}
+
+	// Now is the optional source of the current time.  If unset, time.Now is used.
+	Now func() time.Time
+}
+
+// NewTokenBuilder creates a TokenBuilder that signs using the private key of a resolved key pair
+func NewTokenBuilder(resolver key.Resolver, keyId string) (*TokenBuilder, error) {
+	pair, err := resolver.ResolveKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pair.HasPrivate() {
+		return nil, ErrorNoSigningKey
+	}
+
+	return &TokenBuilder{Key: pair.Private(), KeyId: keyId}, nil
+}
+
+func (b *TokenBuilder) signingMethod() (crypto.SigningMethod, error) {
+	alg := b.Algorithm
+	if len(alg) == 0 {
+		alg = DefaultTokenAlgorithm
+	}
+
+	if signingMethod := jws.GetSigningMethod(alg); signingMethod != nil {
+		return signingMethod, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported signing algorithm: %s", alg)
+}
+
+func (b *TokenBuilder) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+
+	return time.Now()
+}
+
+func (b *TokenBuilder) lifetime() time.Duration {
+	if b.Lifetime != 0 {
+		return b.Lifetime
+	}
+
+	return DefaultTokenLifetime
+}
+
+// Build mints a token, returning its compact serialization
+func (b *TokenBuilder) Build() ([]byte, error) {
+	if b.Key == nil {
+		return nil, ErrorNoSigningKey
+	}
+
+	signingMethod, err := b.signingMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(jws.Claims, len(b.Claims)+6)
+	for name, value := range b.Claims {
+		claims.Set(name, value)
+	}
+
+	now := b.now()
+	claims.SetIssuedAt(now)
+	claims.SetExpiration(now.Add(b.lifetime()))
+	if b.NotBefore != 0 {
+		claims.SetNotBefore(now.Add(b.NotBefore))
+	}
+
+	if len(b.Issuer) > 0 {
+		claims.SetIssuer(b.Issuer)
+	}
+
+	if len(b.Subject) > 0 {
+		claims.SetSubject(b.Subject)
+	}
+
+	if len(b.Audience) > 0 {
+		claims.SetAudience(b.Audience...)
+	}
+
+	token := jws.NewJWT(claims, signingMethod).(jws.JWS)
+	if len(b.KeyId) > 0 {
+		token.Protected().Set("kid", b.KeyId)
+	}
+
+	return token.Compact(b.Key)
+}
+
+// Token mints a token and returns it as a Bearer Token, suitable for passing to a Validator
+func (b *TokenBuilder) Token() (*Token, error) {
+	compact, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{tokenType: Bearer, value: string(compact)}, nil
+}
+
+// Authorization mints a token and formats it as the value of an HTTP Authorization header
+func (b *TokenBuilder) Authorization() (string, error) {
+	token, err := b.Token()
+	if err != nil {
+		return "", err
+	}
+
+	return token.String(), nil
+}
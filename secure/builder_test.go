@@ -0,0 +1,148 @@
+package secure
+
+import (
+	"context"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jws"
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTokenBuilder(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "test")
+	require.NoError(err)
+	require.NotNil(builder)
+	assert.NotNil(builder.Key)
+	assert.Equal("test", builder.KeyId)
+
+	builder, err = NewTokenBuilder(publicKeyResolver, "test")
+	assert.Nil(builder)
+	assert.Equal(ErrorNoSigningKey, err)
+}
+
+func TestTokenBuilderBuild(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		now     = time.Now().Truncate(time.Second)
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "test")
+	require.NoError(err)
+
+	builder.Issuer = "test-issuer"
+	builder.Subject = "test-subject"
+	builder.Audience = []string{"test-audience"}
+	builder.Lifetime = 10 * time.Minute
+	builder.NotBefore = -time.Minute
+	builder.Claims = map[string]interface{}{"capabilities": []interface{}{"x1:webpa:api:.*:post"}, "iss": "overridden"}
+	builder.Now = func() time.Time { return now }
+
+	compact, err := builder.Build()
+	require.NoError(err)
+
+	parsed, err := jws.ParseJWT(compact)
+	require.NoError(err)
+	pair, err := publicKeyResolver.ResolveKey("test")
+	require.NoError(err)
+	assert.NoError(parsed.Validate(pair.Public(), crypto.SigningMethodRS256))
+
+	protected := parsed.(jws.JWS).Protected()
+	assert.Equal("RS256", protected.Get("alg"))
+	assert.Equal("test", protected.Get("kid"))
+
+	claims := parsed.Claims()
+	issuer, _ := claims.Issuer()
+	assert.Equal("test-issuer", issuer)
+	subject, _ := claims.Subject()
+	assert.Equal("test-subject", subject)
+	audience, _ := claims.Audience()
+	assert.Equal([]string{"test-audience"}, audience)
+
+	issuedAt, _ := claims.IssuedAt()
+	assert.Equal(now.Unix(), issuedAt.Unix())
+	expiration, _ := claims.Expiration()
+	assert.Equal(now.Add(10*time.Minute).Unix(), expiration.Unix())
+	notBefore, _ := claims.NotBefore()
+	assert.Equal(now.Add(-time.Minute).Unix(), notBefore.Unix())
+	assert.NotNil(claims.Get("capabilities"))
+}
+
+func TestTokenBuilderValidates(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		validator = JWSValidator{
+			Resolver:      publicKeyResolver,
+			JWTValidators: []*jwt.Validator{(&JWTValidatorFactory{}).New()},
+		}
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "")
+	require.NoError(err)
+	builder.Claims = map[string]interface{}{"capabilities": []interface{}{"x1:webpa:api:.*:post"}}
+
+	token, err := builder.Token()
+	require.NoError(err)
+	valid, err := validator.Validate(context.Background(), token)
+	assert.True(valid)
+	assert.NoError(err)
+
+	authorization, err := builder.Authorization()
+	require.NoError(err)
+	assert.True(strings.HasPrefix(authorization, "Bearer "))
+
+	// an expired token must fail validation
+	builder.Lifetime = -time.Hour
+	token, err = builder.Token()
+	require.NoError(err)
+	valid, err = validator.Validate(context.Background(), token)
+	assert.False(valid)
+	assert.Error(err)
+}
+
+func TestTokenBuilderHMAC(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		secret  = []byte("a test secret")
+		builder = TokenBuilder{Key: secret, Algorithm: "HS256"}
+	)
+
+	compact, err := builder.Build()
+	require.NoError(err)
+
+	parsed, err := jws.ParseJWT(compact)
+	require.NoError(err)
+	assert.NoError(parsed.(jws.JWS).Verify(secret, crypto.SigningMethodHS256))
+	assert.False(parsed.(jws.JWS).Protected().Has("kid"))
+}
+
+func TestTokenBuilderErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	compact, err := new(TokenBuilder).Build()
+	assert.Empty(compact)
+	assert.Equal(ErrorNoSigningKey, err)
+
+	compact, err = (&TokenBuilder{Key: []byte("secret"), Algorithm: "nosuch"}).Build()
+	assert.Empty(compact)
+	assert.Error(err)
+
+	token, err := new(TokenBuilder).Token()
+	assert.Nil(token)
+	assert.Error(err)
+
+	authorization, err := new(TokenBuilder).Authorization()
+	assert.Empty(authorization)
+	assert.Error(err)
+}
@@ -0,0 +1,217 @@
+package secure
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/fact"
+	"github.com/Comcast/webpa-common/httperror"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/secure/key"
+	"github.com/SermoDigital/jose/jws"
+	"github.com/SermoDigital/jose/jwt"
+	"net/http"
+	"time"
+)
+
+const (
+	// WWWAuthenticateHeader is the header sent with every response that rejects a request's credentials
+	WWWAuthenticateHeader = "WWW-Authenticate"
+
+	UnauthorizedMessage = "A valid bearer token is required"
+	ForbiddenMessage    = "The bearer token does not permit this request"
+)
+
+var (
+	ErrorNoBearerToken = errors.New("No bearer token was supplied")
+	ErrorNotJWT        = errors.New("The token is not a JWT")
+)
+
+// ClaimsAuthorizer decides whether an authenticated request is permitted, based on the claims of its
+// token.  A non-nil error rejects the request with http.StatusForbidden.
+type ClaimsAuthorizer func(*http.Request, jwt.Claims) error
+
+// RequireClaim produces a ClaimsAuthorizer which permits a request only if the named claim holds one of
+// the accepted values.  The claim may be either a single string or an array of strings, in which case
+// any element may match.
+func RequireClaim(name string, accepted ...string) ClaimsAuthorizer {
+	acceptedSet := make(map[string]bool, len(accepted))
+	for _, value := range accepted {
+		acceptedSet[value] = true
+	}
+
+	return func(_ *http.Request, claims jwt.Claims) error {
+		switch value := claims.Get(name).(type) {
+		case string:
+			if acceptedSet[value] {
+				return nil
+			}
+
+		case []string:
+			for _, element := range value {
+				if acceptedSet[element] {
+					return nil
+				}
+			}
+
+		case []interface{}:
+			for _, element := range value {
+				if s, ok := element.(string); ok && acceptedSet[s] {
+					return nil
+				}
+			}
+		}
+
+		return errors.New("The " + name + " claim does not have an accepted value")
+	}
+}
+
+// JWTHandler is an Alice-style decorator that authenticates requests with JWS-signed bearer tokens.
+// A request without a valid token is rejected with http.StatusUnauthorized, and an authenticated request
+// that any Authorizer denies is rejected with http.StatusForbidden.  Otherwise, the delegate is invoked
+// with the token's claims available via fact.Claims and, if the token has a subject, with that subject
+// as the fact.Principal.
+//
+// For tokens signed with keys published as a JWK set, the Resolver is typically created by a
+// key.ResolverFactory whose Format is key.FormatJWKS.  The Runnable from that factory's NewUpdater
+// refreshes the set in the background, and a token whose key id is not yet known causes an immediate
+// reload, so keys can be rotated without restarting the server.
+type JWTHandler struct {
+	// Logger receives a debug message for each rejected request.  If unset, logging.DefaultLogger() is used.
+	Logger logging.Logger
+
+	// Resolver supplies the verification key for each token, selected by the token's kid header.
+	// This field is required.
+	Resolver key.Resolver
+
+	// DefaultKeyId is the key id used for tokens that do not have a kid header
+	DefaultKeyId string
+
+	// Parser is the optional parser for tokens.  If unset, DefaultJWSParser is used.
+	Parser JWSParser
+
+	// Expected holds any claims, such as iss or aud, each token is expected to have
+	Expected jwt.Claims
+
+	// ClockSkew is the leeway allowed when checking the exp and nbf claims, which accommodates
+	// differences between this server's clock and the issuer's
+	ClockSkew time.Duration
+
+	// Authorizers are consulted in order for every authenticated request.  The first to return an
+	// error denies the request.
+	Authorizers []ClaimsAuthorizer
+
+	// Now is the optional source of the current time.  If unset, time.Now is used.
+	Now func() time.Time
+}
+
+func (h *JWTHandler) logger() logging.Logger {
+	if h.Logger != nil {
+		return h.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+func (h *JWTHandler) parser() JWSParser {
+	if h.Parser != nil {
+		return h.Parser
+	}
+
+	return DefaultJWSParser
+}
+
+func (h *JWTHandler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+
+	return time.Now()
+}
+
+// validator produces the jwt.Validator that checks a token's claims
+func (h *JWTHandler) validator() *jwt.Validator {
+	return &jwt.Validator{
+		Expected: h.Expected,
+		EXP:      h.ClockSkew,
+		NBF:      h.ClockSkew,
+		Fn: func(claims jwt.Claims) error {
+			return claims.Validate(h.now(), h.ClockSkew, h.ClockSkew)
+		},
+	}
+}
+
+// authenticate verifies the bearer token of a request, returning its claims
+func (h *JWTHandler) authenticate(request *http.Request) (jwt.Claims, error) {
+	token, err := NewToken(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == nil || token.Type() != Bearer {
+		return nil, ErrorNoBearerToken
+	}
+
+	jwsToken, err := h.parser().ParseJWS(token)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtToken, ok := jwsToken.(jwt.JWT)
+	if !ok {
+		return nil, ErrorNotJWT
+	}
+
+	protected := jwsToken.Protected()
+	if len(protected) == 0 {
+		return nil, ErrorNoProtectedHeader
+	}
+
+	alg, _ := protected.Get("alg").(string)
+	signingMethod := jws.GetSigningMethod(alg)
+	if signingMethod == nil {
+		return nil, ErrorNoSigningMethod
+	}
+
+	keyId, _ := protected.Get("kid").(string)
+	if len(keyId) == 0 {
+		keyId = h.DefaultKeyId
+	}
+
+	pair, err := h.Resolver.ResolveKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jwtToken.Validate(pair.Public(), signingMethod, h.validator()); err != nil {
+		return nil, err
+	}
+
+	return jwtToken.Claims(), nil
+}
+
+// Then decorates the delegate with this authentication policy
+func (h *JWTHandler) Then(delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		claims, err := h.authenticate(request)
+		if err != nil {
+			h.logger().Debug("Rejecting request for %s: %s", request.URL, err)
+			response.Header().Set(WWWAuthenticateHeader, string(Bearer))
+			httperror.Formatf(response, http.StatusUnauthorized, "%s", UnauthorizedMessage)
+			return
+		}
+
+		for _, authorize := range h.Authorizers {
+			if err := authorize(request, claims); err != nil {
+				h.logger().Debug("Denying request for %s: %s", request.URL, err)
+				httperror.Formatf(response, http.StatusForbidden, "%s", ForbiddenMessage)
+				return
+			}
+		}
+
+		ctx := fact.SetClaims(request.Context(), claims)
+		if subject, ok := claims.Subject(); ok && len(subject) > 0 {
+			ctx = fact.SetPrincipal(ctx, subject)
+		}
+
+		delegate.ServeHTTP(response, request.WithContext(ctx))
+	})
+}
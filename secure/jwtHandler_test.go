@@ -0,0 +1,281 @@
+package secure
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/fact"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/secure/key"
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireClaim(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		authorize = RequireClaim("scope", "read", "write")
+	)
+
+	for _, claims := range []jwt.Claims{
+		{"scope": "read"},
+		{"scope": []string{"admin", "write"}},
+		{"scope": []interface{}{123, "write"}},
+	} {
+		assert.NoError(authorize(nil, claims), "%v", claims)
+	}
+
+	for _, claims := range []jwt.Claims{
+		{},
+		{"scope": "admin"},
+		{"scope": []string{"admin"}},
+		{"scope": []interface{}{123}},
+		{"scope": 123},
+	} {
+		assert.Error(authorize(nil, claims), "%v", claims)
+	}
+}
+
+// testJWTHandlerServe runs a request through a JWTHandler, returning the response and the claims, if any,
+// that the delegate received
+func testJWTHandlerServe(handler *JWTHandler, authorization string) (*httptest.ResponseRecorder, jwt.Claims) {
+	var (
+		claims   jwt.Claims
+		response = httptest.NewRecorder()
+		request  = httptest.NewRequest("GET", "/test", nil)
+
+		decorated = handler.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			claims, _ = fact.Claims(request.Context())
+			response.WriteHeader(http.StatusOK)
+		}))
+	)
+
+	if len(authorization) > 0 {
+		request.Header.Set(AuthorizationHeader, authorization)
+	}
+
+	decorated.ServeHTTP(response, request)
+	return response, claims
+}
+
+func testJWTHandlerAccepted(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "test")
+	require.NoError(err)
+	builder.Subject = "test-subject"
+	builder.Claims = map[string]interface{}{"scope": "read"}
+
+	authorization, err := builder.Authorization()
+	require.NoError(err)
+
+	handler := &JWTHandler{
+		Logger:      logging.TestLogger(t),
+		Resolver:    publicKeyResolver,
+		Authorizers: []ClaimsAuthorizer{RequireClaim("scope", "read")},
+	}
+
+	response, claims := testJWTHandlerServe(handler, authorization)
+	assert.Equal(http.StatusOK, response.Code)
+	require.NotNil(claims)
+	subject, _ := claims.Subject()
+	assert.Equal("test-subject", subject)
+	assert.Equal("read", claims.Get("scope"))
+}
+
+func testJWTHandlerPrincipal(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "test")
+	require.NoError(err)
+	withoutSubject, err := builder.Authorization()
+	require.NoError(err)
+
+	builder.Subject = "test-subject"
+	withSubject, err := builder.Authorization()
+	require.NoError(err)
+
+	handler := &JWTHandler{
+		Logger:   logging.TestLogger(t),
+		Resolver: publicKeyResolver,
+	}
+
+	for authorization, expected := range map[string]string{withoutSubject: "", withSubject: "test-subject"} {
+		var (
+			principal string
+			found     bool
+			request   = httptest.NewRequest("GET", "/test", nil)
+			response  = httptest.NewRecorder()
+		)
+
+		request.Header.Set(AuthorizationHeader, authorization)
+		handler.Then(http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+			principal, found = fact.Principal(request.Context())
+		})).ServeHTTP(response, request)
+
+		assert.Equal(http.StatusOK, response.Code)
+		assert.Equal(expected, principal)
+		assert.Equal(len(expected) > 0, found)
+	}
+}
+
+func testJWTHandlerUnauthorized(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "test")
+	require.NoError(err)
+	builder.Lifetime = -time.Minute
+	expired, err := builder.Authorization()
+	require.NoError(err)
+
+	hmacBuilder := &TokenBuilder{Key: []byte("secret"), Algorithm: "HS256"}
+	forged, err := hmacBuilder.Authorization()
+	require.NoError(err)
+
+	handler := &JWTHandler{
+		Logger:   logging.TestLogger(t),
+		Resolver: publicKeyResolver,
+	}
+
+	for _, authorization := range []string{
+		"",
+		"Basic dXNlcjpwYXNzd29yZA==",
+		"Bearer this is not a JWT",
+		expired,
+		forged,
+	} {
+		response, claims := testJWTHandlerServe(handler, authorization)
+		assert.Equal(http.StatusUnauthorized, response.Code, authorization)
+		assert.Equal(string(Bearer), response.Header().Get(WWWAuthenticateHeader))
+		assert.Contains(response.Body.String(), UnauthorizedMessage)
+		assert.Nil(claims)
+	}
+}
+
+func testJWTHandlerClockSkew(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		now     = time.Now()
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "test")
+	require.NoError(err)
+	builder.Lifetime = time.Minute
+	builder.NotBefore = time.Minute
+	builder.Now = func() time.Time { return now }
+
+	authorization, err := builder.Authorization()
+	require.NoError(err)
+
+	handler := &JWTHandler{
+		Logger:    logging.TestLogger(t),
+		Resolver:  publicKeyResolver,
+		ClockSkew: 2 * time.Minute,
+	}
+
+	for _, offset := range []time.Duration{-30 * time.Second, 0, 2 * time.Minute} {
+		handler.Now = func() time.Time { return now.Add(offset) }
+		response, _ := testJWTHandlerServe(handler, authorization)
+		assert.Equal(http.StatusOK, response.Code, offset.String())
+	}
+
+	for _, offset := range []time.Duration{-2 * time.Minute, 4 * time.Minute} {
+		handler.Now = func() time.Time { return now.Add(offset) }
+		response, _ := testJWTHandlerServe(handler, authorization)
+		assert.Equal(http.StatusUnauthorized, response.Code, offset.String())
+	}
+}
+
+func testJWTHandlerForbidden(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		authorizerCalled = false
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "test")
+	require.NoError(err)
+	builder.Claims = map[string]interface{}{"scope": "read"}
+
+	authorization, err := builder.Authorization()
+	require.NoError(err)
+
+	handler := &JWTHandler{
+		Logger:   logging.TestLogger(t),
+		Resolver: publicKeyResolver,
+		Authorizers: []ClaimsAuthorizer{
+			RequireClaim("scope", "write"),
+			func(*http.Request, jwt.Claims) error {
+				authorizerCalled = true
+				return nil
+			},
+		},
+	}
+
+	response, claims := testJWTHandlerServe(handler, authorization)
+	assert.Equal(http.StatusForbidden, response.Code)
+	assert.Empty(response.Header().Get(WWWAuthenticateHeader))
+	assert.Contains(response.Body.String(), ForbiddenMessage)
+	assert.Nil(claims)
+	assert.False(authorizerCalled)
+}
+
+func testJWTHandlerKeyId(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		resolver      = new(key.MockResolver)
+		expectedError = errors.New("expected")
+	)
+
+	pair, err := publicKeyResolver.ResolveKey("")
+	require.NoError(err)
+	resolver.On("ResolveKey", "default").Return(pair, nil).Once()
+	resolver.On("ResolveKey", "unknown").Return(nil, expectedError).Once()
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "")
+	require.NoError(err)
+	withoutKeyId, err := builder.Authorization()
+	require.NoError(err)
+
+	builder.KeyId = "unknown"
+	withUnknownKeyId, err := builder.Authorization()
+	require.NoError(err)
+
+	handler := &JWTHandler{
+		Logger:       logging.TestLogger(t),
+		Resolver:     resolver,
+		DefaultKeyId: "default",
+	}
+
+	response, _ := testJWTHandlerServe(handler, withoutKeyId)
+	assert.Equal(http.StatusOK, response.Code)
+
+	response, _ = testJWTHandlerServe(handler, withUnknownKeyId)
+	assert.Equal(http.StatusUnauthorized, response.Code)
+
+	resolver.AssertExpectations(t)
+}
+
+func TestJWTHandler(t *testing.T) {
+	t.Run("Accepted", testJWTHandlerAccepted)
+	t.Run("Principal", testJWTHandlerPrincipal)
+	t.Run("Unauthorized", testJWTHandlerUnauthorized)
+	t.Run("ClockSkew", testJWTHandlerClockSkew)
+	t.Run("Forbidden", testJWTHandlerForbidden)
+	t.Run("KeyId", testJWTHandlerKeyId)
+}
@@ -0,0 +1,114 @@
+package key
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Comcast/webpa-common/concurrent"
+	"time"
+)
+
+var (
+	ErrorEmptyChain = errors.New("A resolver chain must have at least one resolver")
+)
+
+// ChainError is returned by a Chain when every one of its resolvers failed.  It
+// holds the error from each resolver, in chain order.
+type ChainError []error
+
+func (e ChainError) Error() string {
+	var output bytes.Buffer
+	output.WriteString("All key resolvers failed: ")
+	for i, err := range e {
+		if i > 0 {
+			output.WriteString("; ")
+		}
+
+		output.WriteString(err.Error())
+	}
+
+	return output.String()
+}
+
+// Chain is an ordered list of Resolvers consulted in turn.  The first Resolver that successfully
+// resolves a key wins, so a chain such as local file, then JWKS, then remote URL falls back to
+// later sources only when earlier ones fail.
+//
+// Chain implements Cache.  Each link that is itself a Cache, e.g. any Resolver created by
+// a ResolverFactory, is updated by UpdateKeys.
+type Chain []Resolver
+
+func (c Chain) ResolveKey(keyId string) (Pair, error) {
+	if len(c) == 0 {
+		return nil, ErrorEmptyChain
+	}
+
+	errs := make(ChainError, 0, len(c))
+	for _, resolver := range c {
+		pair, err := resolver.ResolveKey(keyId)
+		if err == nil {
+			return pair, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errs
+}
+
+func (c Chain) UpdateKeys() (count int, errors []error) {
+	for _, resolver := range c {
+		if keyCache, ok := resolver.(Cache); ok {
+			linkCount, linkErrors := keyCache.UpdateKeys()
+			count += linkCount
+			errors = append(errors, linkErrors...)
+		}
+	}
+
+	return
+}
+
+// ChainFactory is the JSON representation of a Chain.  Each ResolverFactory describes one
+// link, in the order links are consulted.
+type ChainFactory []ResolverFactory
+
+// NewResolver creates a Chain from this factory's links.  If there is exactly one link,
+// its Resolver is returned directly.
+func (cf ChainFactory) NewResolver() (Resolver, error) {
+	if len(cf) == 0 {
+		return nil, ErrorEmptyChain
+	}
+
+	chain := make(Chain, 0, len(cf))
+	for i := range cf {
+		resolver, err := cf[i].NewResolver()
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, resolver)
+	}
+
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+
+	return chain, nil
+}
+
+// updateInterval returns the smallest positive update interval among this factory's links,
+// or zero if no link is ever refreshed
+func (cf ChainFactory) updateInterval() (interval time.Duration) {
+	for _, factory := range cf {
+		if linkInterval := time.Duration(factory.UpdateInterval); linkInterval > 0 && (interval == 0 || linkInterval < interval) {
+			interval = linkInterval
+		}
+	}
+
+	return
+}
+
+// NewUpdater creates a Runnable that refreshes the given resolver at the shortest update
+// interval configured on any link.  As with NewUpdater, this method may return nil.
+func (cf ChainFactory) NewUpdater(resolver Resolver) concurrent.Runnable {
+	return NewUpdater(cf.updateInterval(), resolver)
+}
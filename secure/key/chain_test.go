@@ -0,0 +1,141 @@
+package key
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/resource"
+	"github.com/Comcast/webpa-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestChainResolveKey(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		expectedPair = &MockPair{}
+		failure      = errors.New("expected")
+
+		first  = &MockResolver{}
+		second = &MockCache{}
+		third  = &MockResolver{}
+		chain  = Chain{first, second, third}
+	)
+
+	first.On("ResolveKey", keyId).Return(nil, failure).Once()
+	second.On("ResolveKey", keyId).Return(expectedPair, nil).Once()
+
+	pair, err := chain.ResolveKey(keyId)
+	assert.Equal(expectedPair, pair)
+	assert.NoError(err)
+
+	first.On("ResolveKey", "other").Return(nil, failure).Once()
+	second.On("ResolveKey", "other").Return(nil, failure).Once()
+	third.On("ResolveKey", "other").Return(nil, failure).Once()
+
+	pair, err = chain.ResolveKey("other")
+	assert.Nil(pair)
+	if chainError, ok := err.(ChainError); assert.True(ok) {
+		assert.Len(chainError, 3)
+		assert.Equal("All key resolvers failed: expected; expected; expected", chainError.Error())
+	}
+
+	pair, err = Chain{}.ResolveKey(keyId)
+	assert.Nil(pair)
+	assert.Equal(ErrorEmptyChain, err)
+
+	first.AssertExpectations(t)
+	second.AssertExpectations(t)
+	third.AssertExpectations(t)
+}
+
+func TestChainUpdateKeys(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		failure = errors.New("expected")
+
+		first  = &MockCache{}
+		second = &MockResolver{}
+		third  = &MockCache{}
+		chain  = Chain{first, second, third}
+	)
+
+	first.On("UpdateKeys").Return(2, nil).Once()
+	third.On("UpdateKeys").Return(1, []error{failure}).Once()
+
+	count, errors := chain.UpdateKeys()
+	assert.Equal(3, count)
+	assert.Equal([]error{failure}, errors)
+
+	first.AssertExpectations(t)
+	second.AssertExpectations(t)
+	third.AssertExpectations(t)
+}
+
+func TestChainFactory(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		jsonConfiguration = fmt.Sprintf(`[
+			{"uri": "%s/nosuch.pub", "purpose": "verify"},
+			{"data": %q, "purpose": "verify", "format": "jwks", "updateInterval": "1h"},
+			{"uri": "%s", "purpose": "verify", "updateInterval": "10m"}
+		]`, httpServer.URL, testJWKS(t), publicKeyURLTemplate)
+
+		factory ChainFactory
+	)
+
+	require.NoError(json.Unmarshal([]byte(jsonConfiguration), &factory))
+	require.Len(factory, 3)
+	assert.Equal(10*time.Minute, factory.updateInterval())
+
+	resolver, err := factory.NewResolver()
+	require.NoError(err)
+	require.IsType(Chain{}, resolver)
+	assert.NotNil(factory.NewUpdater(resolver))
+
+	// the first link fails, so the JWKS link supplies the key
+	pair, err := resolver.ResolveKey(keyId)
+	require.NoError(err)
+	assert.Equal(testPublicKey(t), pair.Public())
+
+	if chain, ok := resolver.(Chain); assert.True(ok) {
+		pair, err = chain[1].ResolveKey(keyId)
+		assert.NotNil(pair)
+		assert.NoError(err)
+	}
+}
+
+func TestChainFactorySingle(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		factory = ChainFactory{
+			{Factory: resource.Factory{URI: publicKeyFilePath}, Purpose: PurposeVerify},
+		}
+	)
+
+	resolver, err := factory.NewResolver()
+	assert.IsType(&singleCache{}, resolver)
+	assert.NoError(err)
+	assert.Equal(time.Duration(0), factory.updateInterval())
+	assert.Nil(factory.NewUpdater(resolver))
+}
+
+func TestChainFactoryInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	resolver, err := ChainFactory{}.NewResolver()
+	assert.Nil(resolver)
+	assert.Equal(ErrorEmptyChain, err)
+
+	resolver, err = ChainFactory{
+		{Factory: resource.Factory{URI: publicKeyFilePath}, Purpose: PurposeVerify},
+		{Factory: resource.Factory{}, Purpose: PurposeVerify, UpdateInterval: types.Duration(time.Hour)},
+	}.NewResolver()
+
+	assert.Nil(resolver)
+	assert.Error(err)
+}
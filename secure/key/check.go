@@ -0,0 +1,27 @@
+package key
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/health"
+)
+
+// uncached returns the Resolver that actually loads keys for this cache
+func (b *basicCache) uncached() Resolver {
+	return b.delegate
+}
+
+// NewResolverCheck creates a health check which resolves the given key on every run.  When the resolver
+// is one of the caches created by this package, the cache is bypassed so that each run actually
+// contacts the key source, e.g. an outbound key server.  The cache itself is never modified.
+func NewResolverCheck(resolver Resolver, keyId string) health.Check {
+	if cache, ok := resolver.(interface {
+		uncached() Resolver
+	}); ok {
+		resolver = cache.uncached()
+	}
+
+	return health.CheckFunc(func(context.Context) error {
+		_, err := resolver.ResolveKey(keyId)
+		return err
+	})
+}
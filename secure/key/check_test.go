@@ -0,0 +1,66 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewResolverCheckBypassesCache(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		expectedPair = &MockPair{}
+		parser       = &MockParser{}
+	)
+
+	parser.On("ParseKey", PurposeVerify, mock.AnythingOfType("[]uint8")).Return(expectedPair, nil).Times(3)
+
+	resolver, err := (&ResolverFactory{
+		Factory: resource.Factory{URI: publicKeyURL},
+		Purpose: PurposeVerify,
+		Parser:  parser,
+	}).NewResolver()
+
+	require.NoError(err)
+	pair, err := resolver.ResolveKey(keyId)
+	assert.Equal(expectedPair, pair)
+	assert.NoError(err)
+
+	check := NewResolverCheck(resolver, keyId)
+	assert.NoError(check.Check(context.Background()))
+	assert.NoError(check.Check(context.Background()))
+
+	parser.AssertExpectations(t)
+}
+
+func TestNewResolverCheckFailure(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	resolver, err := (&ResolverFactory{
+		Factory: resource.Factory{URI: httpServer.URL + "/nosuch.pub"},
+		Purpose: PurposeVerify,
+	}).NewResolver()
+
+	require.NoError(err)
+	assert.Error(NewResolverCheck(resolver, keyId).Check(context.Background()))
+}
+
+func TestNewResolverCheckUncachedResolver(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		resolver      = &MockResolver{}
+	)
+
+	resolver.On("ResolveKey", keyId).Return(nil, expectedError).Once()
+	assert.Equal(expectedError, NewResolverCheck(resolver, keyId).Check(context.Background()))
+	resolver.AssertExpectations(t)
+}
@@ -0,0 +1,242 @@
+package key
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/resource"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// FormatPEM indicates that a key resource contains a single PEM-encoded key.  This is the default.
+	FormatPEM = "pem"
+
+	// FormatJWKS indicates that a key resource is a JSON Web Key Set, as described in RFC 7517.
+	// Keys are selected from the set by key id.
+	FormatJWKS = "jwks"
+
+	// DefaultMissInterval is the minimum time between reloads of a JWK set caused by unknown key ids
+	DefaultMissInterval = time.Minute
+)
+
+var (
+	ErrorUnsupportedFormat = errors.New("Key resource format must be either pem or jwks")
+	ErrorJWKSPrivateKey    = errors.New("JSON web key sets can only supply public keys")
+	ErrorUnsupportedJWK    = errors.New("Only RSA JSON web keys are supported")
+)
+
+// jsonWebKey is the subset of RFC 7517 key members this package understands
+type jsonWebKey struct {
+	KeyId   string `json:"kid"`
+	KeyType string `json:"kty"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// jsonWebKeySet is the RFC 7517 JWK set document
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// decodeJWKInteger decodes one of the unpadded base64url integers used by RSA JSON web keys
+func decodeJWKInteger(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// jwksEntry is the result of parsing a single key in a JWK set.  Keys that cannot be used are
+// retained with their errors, so that resolving them reports why.
+type jwksEntry struct {
+	pair Pair
+	err  error
+}
+
+// parseJWK produces a Pair from a single JSON web key
+func parseJWK(purpose Purpose, candidate *jsonWebKey) (Pair, error) {
+	if candidate.KeyType != "RSA" {
+		return nil, ErrorUnsupportedJWK
+	}
+
+	n, err := decodeJWKInteger(candidate.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := decodeJWKInteger(candidate.E)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Sign() < 1 || e.Sign() < 1 || e.BitLen() > 31 {
+		return nil, ErrorUnsupportedJWK
+	}
+
+	return &rsaPair{
+		purpose: purpose,
+		public: &rsa.PublicKey{
+			N: n,
+			E: int(e.Int64()),
+		},
+	}, nil
+}
+
+// parseJWKSet parses every key within a JWK set, mapping each key id onto its entry.  If the set contains
+// exactly one key, that key is also mapped onto the empty key id.  The returned count is the number of
+// keys that were successfully parsed.
+func parseJWKSet(purpose Purpose, data []byte) (entries map[string]jwksEntry, count int, err error) {
+	var set jsonWebKeySet
+	if err = json.Unmarshal(data, &set); err != nil {
+		return
+	}
+
+	entries = make(map[string]jwksEntry, len(set.Keys)+1)
+	for i := len(set.Keys) - 1; i >= 0; i-- {
+		// iterate backwards, so that the first of any duplicate key ids wins
+		var entry jwksEntry
+		entry.pair, entry.err = parseJWK(purpose, &set.Keys[i])
+		entries[set.Keys[i].KeyId] = entry
+	}
+
+	for _, entry := range entries {
+		if entry.err == nil {
+			count++
+		}
+	}
+
+	if len(set.Keys) == 1 {
+		if _, ok := entries[dummyKeyId]; !ok {
+			entries[dummyKeyId] = entries[set.Keys[0].KeyId]
+		}
+	}
+
+	return
+}
+
+// noSuchJWK produces the error returned when a key id does not exist in a JWK set
+func noSuchJWK(keyId string) error {
+	return fmt.Errorf("No key with id [%s] exists in the key set", keyId)
+}
+
+// parseJWKS locates the key with the given key id within a JWK set and produces a Pair from it.
+// If the set contains exactly one key, that key is used when keyId is empty.
+func parseJWKS(purpose Purpose, data []byte, keyId string) (Pair, error) {
+	entries, _, err := parseJWKSet(purpose, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, ok := entries[keyId]; ok {
+		return entry.pair, entry.err
+	}
+
+	return nil, noSuchJWK(keyId)
+}
+
+// jwksCache is the Cache used for JWK sets.  Unlike multiCache, which refreshes each key separately,
+// every update replaces the entire set, so that keys removed from the document by a rotation no longer
+// resolve.  A key id that is not in the current set causes the document to be reloaded immediately,
+// at most once per missInterval, which allows tokens signed with a newly published key to be accepted
+// before the next scheduled update.
+type jwksCache struct {
+	purpose      Purpose
+	loader       resource.Loader
+	missInterval time.Duration
+	now          func() time.Time
+
+	value      atomic.Value
+	updateLock sync.Mutex
+
+	// lastMiss is when a missing key id last caused a reload
+	lastMiss time.Time
+}
+
+func (cache *jwksCache) String() string {
+	return fmt.Sprintf(
+		"jwksCache{purpose: %s, loader: %s}",
+		cache.purpose,
+		cache.loader,
+	)
+}
+
+// fetch looks up a key id in the current set without blocking
+func (cache *jwksCache) fetch(keyId string) (entry jwksEntry, ok bool) {
+	entries, ok := cache.value.Load().(map[string]jwksEntry)
+	if ok {
+		entry, ok = entries[keyId]
+	}
+
+	return
+}
+
+// reload reads and parses the JWK set document, replacing the current set only if that succeeds.
+// This method must be called under the updateLock.
+func (cache *jwksCache) reload() (int, error) {
+	data, err := resource.ReadAll(cache.loader)
+	if err != nil {
+		return 0, err
+	}
+
+	entries, count, err := parseJWKSet(cache.purpose, data)
+	if err != nil {
+		return 0, err
+	}
+
+	cache.value.Store(entries)
+	return count, nil
+}
+
+func (cache *jwksCache) ResolveKey(keyId string) (Pair, error) {
+	if entry, ok := cache.fetch(keyId); ok {
+		return entry.pair, entry.err
+	}
+
+	cache.updateLock.Lock()
+	defer cache.updateLock.Unlock()
+
+	// another goroutine may have reloaded the set while this one waited for the lock
+	if entry, ok := cache.fetch(keyId); ok {
+		return entry.pair, entry.err
+	}
+
+	// until a set has been loaded, every call makes an attempt
+	loaded := cache.value.Load() != nil
+	if now := cache.now(); !loaded || now.Sub(cache.lastMiss) >= cache.missInterval {
+		if loaded {
+			cache.lastMiss = now
+		}
+
+		if _, err := cache.reload(); err != nil {
+			return nil, err
+		}
+
+		if entry, ok := cache.fetch(keyId); ok {
+			return entry.pair, entry.err
+		}
+	}
+
+	return nil, noSuchJWK(keyId)
+}
+
+// UpdateKeys reloads the entire JWK set.  The count is the number of usable keys in the new set.  If the
+// document cannot be loaded or parsed, the current set is retained.
+func (cache *jwksCache) UpdateKeys() (int, []error) {
+	cache.updateLock.Lock()
+	defer cache.updateLock.Unlock()
+
+	count, err := cache.reload()
+	if err != nil {
+		return 0, []error{err}
+	}
+
+	return count, nil
+}
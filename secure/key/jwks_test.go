@@ -0,0 +1,253 @@
+package key
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"github.com/Comcast/webpa-common/resource"
+	"github.com/Comcast/webpa-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPublicKey loads the RSA public key used throughout this package's tests
+func testPublicKey(t *testing.T) *rsa.PublicKey {
+	data, err := ioutil.ReadFile(publicKeyFilePath)
+	require.NoError(t, err)
+
+	pair, err := DefaultParser.ParseKey(PurposeVerify, data)
+	require.NoError(t, err)
+
+	publicKey, ok := pair.Public().(*rsa.PublicKey)
+	require.True(t, ok)
+	return publicKey
+}
+
+// testJWK produces the JSON web key for the test public key, with the given key id
+func testJWK(t *testing.T, kid string) string {
+	publicKey := testPublicKey(t)
+	return fmt.Sprintf(
+		`{"kid": "%s", "kty": "RSA", "use": "sig", "n": "%s", "e": "%s"}`,
+		kid,
+		base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	)
+}
+
+// testJWKS produces a JWK set containing the test public key plus one decoy key
+func testJWKS(t *testing.T) string {
+	return `{"keys": [{"kid": "decoy", "kty": "EC", "crv": "P-256", "x": "AA", "y": "AA"}, ` + testJWK(t, keyId) + `]}`
+}
+
+func TestParseJWKS(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		expected = testPublicKey(t)
+		jwks     = testJWKS(t)
+	)
+
+	pair, err := parseJWKS(PurposeVerify, []byte(jwks), keyId)
+	require.NoError(err)
+	require.NotNil(pair)
+	assert.Equal(PurposeVerify, pair.Purpose())
+	assert.Equal(expected, pair.Public())
+	assert.False(pair.HasPrivate())
+
+	pair, err = parseJWKS(PurposeVerify, []byte(jwks), "decoy")
+	assert.Nil(pair)
+	assert.Equal(ErrorUnsupportedJWK, err)
+
+	pair, err = parseJWKS(PurposeVerify, []byte(jwks), "nosuch")
+	assert.Nil(pair)
+	assert.Error(err)
+
+	pair, err = parseJWKS(PurposeVerify, []byte(jwks), "")
+	assert.Nil(pair)
+	assert.Error(err)
+
+	pair, err = parseJWKS(PurposeVerify, []byte("this is not JSON"), keyId)
+	assert.Nil(pair)
+	assert.Error(err)
+
+	pair, err = parseJWKS(PurposeVerify, []byte(`{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`), "")
+	assert.NotNil(pair)
+	assert.NoError(err)
+
+	pair, err = parseJWKS(PurposeVerify, []byte(`{"keys": [{"kty": "RSA", "n": "!!!", "e": "AQAB"}]}`), "")
+	assert.Nil(pair)
+	assert.Error(err)
+}
+
+func TestResolverFactoryJWKS(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		expected = testPublicKey(t)
+
+		factory = ResolverFactory{
+			Factory: resource.Factory{Data: testJWKS(t)},
+			Purpose: PurposeVerify,
+			Format:  FormatJWKS,
+		}
+	)
+
+	resolver, err := factory.NewResolver()
+	require.NoError(err)
+	require.NotNil(resolver)
+
+	pair, err := resolver.ResolveKey(keyId)
+	require.NoError(err)
+	assert.Equal(expected, pair.Public())
+
+	count, errors := resolver.(Cache).UpdateKeys()
+	assert.Equal(1, count)
+	assert.Empty(errors)
+
+	pair, err = resolver.ResolveKey("nosuch")
+	assert.Nil(pair)
+	assert.Error(err)
+}
+
+func TestResolverFactoryJWKSInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, factory := range []ResolverFactory{
+		{Factory: resource.Factory{Data: "{}"}, Purpose: PurposeSign, Format: FormatJWKS},
+		{Factory: resource.Factory{URI: publicKeyURLTemplate}, Purpose: PurposeVerify, Format: FormatJWKS},
+		{Factory: resource.Factory{}, Purpose: PurposeVerify, Format: FormatJWKS},
+		{Factory: resource.Factory{Data: "{}"}, Purpose: PurposeVerify, Format: "unsupported"},
+	} {
+		resolver, err := factory.NewResolver()
+		assert.Nil(resolver)
+		assert.Error(err)
+	}
+}
+
+func TestJWKSCacheRotation(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		expected = testPublicKey(t)
+
+		document atomic.Value
+		requests int32
+		server   = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			response.Write([]byte(document.Load().(string)))
+		}))
+
+		now = time.Now()
+	)
+
+	defer server.Close()
+	document.Store(`{"keys": [` + testJWK(t, "first") + `]}`)
+
+	factory := ResolverFactory{
+		Factory:      resource.Factory{URI: server.URL},
+		Purpose:      PurposeVerify,
+		Format:       FormatJWKS,
+		MissInterval: types.Duration(time.Minute),
+	}
+
+	resolver, err := factory.NewResolver()
+	require.NoError(err)
+	resolver.(*jwksCache).now = func() time.Time { return now }
+
+	pair, err := resolver.ResolveKey("first")
+	require.NoError(err)
+	assert.Equal(expected, pair.Public())
+
+	// a set with exactly one key supplies it for an empty key id
+	pair, err = resolver.ResolveKey("")
+	require.NoError(err)
+	assert.Equal(expected, pair.Public())
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+
+	// a new key is published: the first miss reloads the set
+	document.Store(`{"keys": [` + testJWK(t, "first") + `, ` + testJWK(t, "second") + `]}`)
+	pair, err = resolver.ResolveKey("second")
+	require.NoError(err)
+	assert.Equal(expected, pair.Public())
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+
+	// subsequent misses within the interval do not reload the set
+	pair, err = resolver.ResolveKey("nosuch")
+	assert.Nil(pair)
+	assert.Error(err)
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+
+	now = now.Add(time.Minute)
+	pair, err = resolver.ResolveKey("nosuch")
+	assert.Nil(pair)
+	assert.Error(err)
+	assert.Equal(int32(3), atomic.LoadInt32(&requests))
+
+	// the old key is retired: an update drops it
+	document.Store(`{"keys": [` + testJWK(t, "second") + `]}`)
+	count, errors := resolver.(Cache).UpdateKeys()
+	assert.Equal(1, count)
+	assert.Empty(errors)
+
+	pair, err = resolver.ResolveKey("first")
+	assert.Nil(pair)
+	assert.Error(err)
+
+	pair, err = resolver.ResolveKey("second")
+	require.NoError(err)
+	assert.Equal(expected, pair.Public())
+
+	// a failed update retains the current set
+	document.Store("this is not JSON")
+	count, errors = resolver.(Cache).UpdateKeys()
+	assert.Equal(0, count)
+	assert.Len(errors, 1)
+
+	pair, err = resolver.ResolveKey("second")
+	require.NoError(err)
+	assert.Equal(expected, pair.Public())
+}
+
+func TestJWKSCacheInitialFailure(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		document atomic.Value
+		server   = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			response.Write([]byte(document.Load().(string)))
+		}))
+	)
+
+	defer server.Close()
+	document.Store("this is not JSON")
+
+	resolver, err := (&ResolverFactory{
+		Factory: resource.Factory{URI: server.URL},
+		Purpose: PurposeVerify,
+		Format:  FormatJWKS,
+	}).NewResolver()
+
+	require.NoError(err)
+
+	pair, err := resolver.ResolveKey(keyId)
+	assert.Nil(pair)
+	assert.Error(err)
+
+	// until a set has been loaded, misses are not throttled
+	document.Store(testJWKS(t))
+	pair, err = resolver.ResolveKey(keyId)
+	require.NoError(err)
+	assert.Equal(testPublicKey(t), pair.Public())
+
+	pair, err = resolver.ResolveKey("decoy")
+	assert.Nil(pair)
+	assert.Equal(ErrorUnsupportedJWK, err)
+}
@@ -0,0 +1,91 @@
+package key
+
+import (
+	"fmt"
+	"github.com/Comcast/webpa-common/concurrent"
+)
+
+// Registry maps each key Purpose onto the Resolver used for keys of that purpose
+type Registry map[Purpose]Resolver
+
+// ResolveKey resolves a key for the given purpose.  An error is returned if no Resolver
+// is registered for that purpose.
+func (r Registry) ResolveKey(purpose Purpose, keyId string) (Pair, error) {
+	resolver, ok := r[purpose]
+	if !ok {
+		return nil, fmt.Errorf("No key resolver registered for purpose %s", purpose)
+	}
+
+	return resolver.ResolveKey(keyId)
+}
+
+// RegistryFactory is the JSON representation of a Registry.  Keys are purpose names,
+// e.g. "verify", and each value is the ordered chain of key sources for that purpose.  The
+// Purpose of each link is always taken from its map key.
+type RegistryFactory map[string]ChainFactory
+
+// chains returns a copy of this factory keyed by parsed Purpose, with the purpose of each link set
+func (rf RegistryFactory) chains() (map[Purpose]ChainFactory, error) {
+	chains := make(map[Purpose]ChainFactory, len(rf))
+	for name, links := range rf {
+		purpose, ok := purposeUnmarshal[name]
+		if !ok {
+			return nil, fmt.Errorf("Invalid key purpose: %s", name)
+		}
+
+		chain := make(ChainFactory, len(links))
+		copy(chain, links)
+		for i := range chain {
+			chain[i].Purpose = purpose
+		}
+
+		chains[purpose] = chain
+	}
+
+	return chains, nil
+}
+
+// NewRegistry creates a Registry from this factory's configuration
+func (rf RegistryFactory) NewRegistry() (Registry, error) {
+	chains, err := rf.chains()
+	if err != nil {
+		return nil, err
+	}
+
+	registry := make(Registry, len(chains))
+	for purpose, chain := range chains {
+		resolver, err := chain.NewResolver()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create key resolver for purpose %s: %s", purpose, err)
+		}
+
+		registry[purpose] = resolver
+	}
+
+	return registry, nil
+}
+
+// NewUpdater creates a Runnable which refreshes each of the given registry's resolvers
+// according to the update intervals configured in this factory.  This method returns nil
+// if no resolver needs updates.
+func (rf RegistryFactory) NewUpdater(registry Registry) (concurrent.Runnable, error) {
+	chains, err := rf.chains()
+	if err != nil {
+		return nil, err
+	}
+
+	var updaters concurrent.RunnableSet
+	for purpose, chain := range chains {
+		if resolver, ok := registry[purpose]; ok {
+			if updater := chain.NewUpdater(resolver); updater != nil {
+				updaters = append(updaters, updater)
+			}
+		}
+	}
+
+	if len(updaters) == 0 {
+		return nil, nil
+	}
+
+	return updaters, nil
+}
@@ -0,0 +1,95 @@
+package key
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		pair     = &MockPair{}
+		resolver = &MockResolver{}
+		registry = Registry{PurposeVerify: resolver}
+	)
+
+	resolver.On("ResolveKey", keyId).Return(pair, nil).Once()
+
+	actual, err := registry.ResolveKey(PurposeVerify, keyId)
+	require.NoError(err)
+	assert.Equal(pair, actual)
+
+	actual, err = registry.ResolveKey(PurposeSign, keyId)
+	assert.Nil(actual)
+	assert.Error(err)
+
+	resolver.AssertExpectations(t)
+}
+
+func TestRegistryFactory(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		jsonConfiguration = fmt.Sprintf(`{
+			"verify": [
+				{"uri": "%s/nosuch.pub"},
+				{"uri": "%s", "updateInterval": "1h"}
+			],
+			"sign": [
+				{"uri": "%s", "purpose": "verify"}
+			]
+		}`, httpServer.URL, publicKeyURL, privateKeyFilePath)
+
+		factory RegistryFactory
+	)
+
+	require.NoError(json.Unmarshal([]byte(jsonConfiguration), &factory))
+
+	registry, err := factory.NewRegistry()
+	require.NoError(err)
+	require.Len(registry, 2)
+
+	verify, err := registry.ResolveKey(PurposeVerify, keyId)
+	require.NoError(err)
+	assert.Equal(PurposeVerify, verify.Purpose())
+	assert.False(verify.HasPrivate())
+
+	// the purpose comes from the registry key, not the link
+	sign, err := registry.ResolveKey(PurposeSign, keyId)
+	require.NoError(err)
+	assert.Equal(PurposeSign, sign.Purpose())
+	assert.True(sign.HasPrivate())
+
+	// the factory must not be modified
+	assert.Equal(PurposeVerify, factory["sign"][0].Purpose)
+
+	updater, err := factory.NewUpdater(registry)
+	assert.NotNil(updater)
+	assert.NoError(err)
+
+	updater, err = RegistryFactory{"sign": factory["sign"]}.NewUpdater(registry)
+	assert.Nil(updater)
+	assert.NoError(err)
+}
+
+func TestRegistryFactoryInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, factory := range []RegistryFactory{
+		{"nosuch": ChainFactory{{}}},
+		{"verify": ChainFactory{}},
+	} {
+		registry, err := factory.NewRegistry()
+		assert.Nil(registry)
+		assert.Error(err)
+	}
+
+	updater, err := RegistryFactory{"nosuch": ChainFactory{{}}}.NewUpdater(Registry{})
+	assert.Nil(updater)
+	assert.Error(err)
+}
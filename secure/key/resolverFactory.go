@@ -41,7 +41,18 @@ type ResolverFactory struct {
 	// If negative or zero, keys are never refreshed and are cached forever.
 	UpdateInterval types.Duration `json:"updateInterval"`
 
+	// Format is the encoding of the key resource, either FormatPEM or FormatJWKS.
+	// If omitted, FormatPEM is assumed.  JWKS resources are selected by key id from
+	// a single document, so their templates cannot have parameters.
+	Format string `json:"format,omitempty"`
+
+	// MissInterval is the minimum time between reloads of a JWK set caused by tokens whose key ids
+	// are not in the set, as happens when a new key is published.  If not positive, DefaultMissInterval
+	// is used.  This field only applies to JWKS resources.
+	MissInterval types.Duration `json:"missInterval,omitempty"`
+
 	// Parser is a custom key parser.  If omitted, DefaultParser is used.
+	// This field is ignored for JWKS resources.
 	Parser Parser `json:"-"`
 }
 
@@ -53,9 +64,25 @@ func (factory *ResolverFactory) parser() Parser {
 	return DefaultParser
 }
 
+func (factory *ResolverFactory) missInterval() time.Duration {
+	if factory.MissInterval > 0 {
+		return time.Duration(factory.MissInterval)
+	}
+
+	return DefaultMissInterval
+}
+
 // NewResolver() creates a Resolver using this factory's configuration.  The
 // returned Resolver always caches keys forever once they have been loaded.
 func (factory *ResolverFactory) NewResolver() (Resolver, error) {
+	switch factory.Format {
+	case "", FormatPEM:
+	case FormatJWKS:
+		return factory.newJWKSResolver()
+	default:
+		return nil, ErrorUnsupportedFormat
+	}
+
 	expander, err := factory.NewExpander()
 	if err != nil {
 		return nil, err
@@ -98,6 +125,36 @@ func (factory *ResolverFactory) NewResolver() (Resolver, error) {
 	return nil, ErrorInvalidTemplate
 }
 
+// newJWKSResolver creates a Resolver that caches an entire JWK set, selecting keys from it by id
+func (factory *ResolverFactory) newJWKSResolver() (Resolver, error) {
+	if factory.Purpose.RequiresPrivateKey() {
+		return nil, ErrorJWKSPrivateKey
+	}
+
+	if len(factory.URI) > 0 {
+		expander, err := factory.NewExpander()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(expander.Names()) > 0 {
+			return nil, ErrorInvalidTemplate
+		}
+	}
+
+	loader, err := factory.NewLoader()
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwksCache{
+		purpose:      factory.Purpose,
+		loader:       loader,
+		missInterval: factory.missInterval(),
+		now:          time.Now,
+	}, nil
+}
+
 // NewUpdater uses this factory's configuration to conditionally create a Runnable updater
 // for the given resolver.  This method delegates to the NewUpdater function, and may
 // return a nil Runnable if no updates are necessary.
@@ -0,0 +1,139 @@
+package secure
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/store"
+	"github.com/SermoDigital/jose/jwt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReplayKeyPrefix is prepended to each jti to produce the key recorded in the store
+	DefaultReplayKeyPrefix = "jti."
+
+	// DefaultReplayTTL is how long the jti of a token without an exp claim is remembered
+	DefaultReplayTTL = time.Hour
+)
+
+var (
+	ErrorMissingJTI    = errors.New("The token does not have a jti claim")
+	ErrorTokenReplayed = errors.New("The token has already been used")
+	ErrorNoReplayStore = errors.New("The replay guard has no store")
+)
+
+// replayRecordedValue is stored under each recorded jti.  Only the key's existence matters.
+var replayRecordedValue = []byte{1}
+
+// ReplayGuard rejects tokens whose jti claim has already been seen, making each token single use.
+// Each jti is remembered until its token expires, so the store's size is bounded by the number of
+// tokens issued within a validity window.
+//
+// If the KV also implements store.KVAdder, a jti is recorded atomically, which prevents two servers
+// sharing the store from both accepting the same token.  Otherwise, recording is only atomic within
+// this process.
+type ReplayGuard struct {
+	// KV is the store holding recorded jti claims.  This field is required.
+	KV store.KV
+
+	// KeyPrefix is prepended to each jti to produce its key.  If unset, DefaultReplayKeyPrefix is used.
+	KeyPrefix string
+
+	// TTL is how long the jti of a token without an exp claim is remembered.  If not positive,
+	// DefaultReplayTTL is used.
+	TTL time.Duration
+
+	// Leeway is added to the time each jti is remembered, and should match the leeway allowed
+	// when validating the exp claim
+	Leeway time.Duration
+
+	// Now is the optional source of the current time.  If unset, time.Now is used.
+	Now func() time.Time
+
+	lock sync.Mutex
+}
+
+func (g *ReplayGuard) keyPrefix() string {
+	if len(g.KeyPrefix) > 0 {
+		return g.KeyPrefix
+	}
+
+	return DefaultReplayKeyPrefix
+}
+
+func (g *ReplayGuard) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+
+	return time.Now()
+}
+
+// ttl computes how long a token's jti must be remembered
+func (g *ReplayGuard) ttl(claims jwt.Claims) time.Duration {
+	ttl := g.TTL
+	if ttl <= 0 {
+		ttl = DefaultReplayTTL
+	}
+
+	if expiration, ok := claims.Expiration(); ok {
+		ttl = expiration.Sub(g.now())
+	}
+
+	ttl += g.Leeway
+	if ttl < time.Second {
+		// the token is expiring, but may still be inside the exp leeway of some validator
+		ttl = time.Second
+	}
+
+	return ttl
+}
+
+// Check records the jti of a token's claims, returning ErrorTokenReplayed if it has already been
+// recorded.  Tokens without a jti claim are rejected with ErrorMissingJTI.  This method should
+// only be called once a token's signature and claims have been verified, as it consumes the jti.
+func (g *ReplayGuard) Check(claims jwt.Claims) error {
+	if g.KV == nil {
+		return ErrorNoReplayStore
+	}
+
+	jti, ok := claims.JWTID()
+	if !ok || len(jti) == 0 {
+		return ErrorMissingJTI
+	}
+
+	var (
+		key   = g.keyPrefix() + jti
+		ttl   = g.ttl(claims)
+		added bool
+		err   error
+	)
+
+	if adder, ok := g.KV.(store.KVAdder); ok {
+		added, err = adder.Add(key, replayRecordedValue, ttl)
+	} else {
+		added, err = g.add(key, ttl)
+	}
+
+	if err != nil {
+		return err
+	} else if !added {
+		return ErrorTokenReplayed
+	}
+
+	return nil
+}
+
+// add records a key with a KV that does not support atomic adds
+func (g *ReplayGuard) add(key string, ttl time.Duration) (bool, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if _, err := g.KV.Get(key); err == nil {
+		return false, nil
+	} else if err != store.ErrorKeyNotFound {
+		return false, err
+	}
+
+	return true, g.KV.Set(key, replayRecordedValue, ttl)
+}
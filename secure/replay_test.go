@@ -0,0 +1,146 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/store"
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// nonAtomicKV hides any KVAdder implementation of the decorated store
+type nonAtomicKV struct {
+	store.KV
+}
+
+// failingKV is a store whose operations all fail
+type failingKV struct {
+	store.KV
+	err error
+}
+
+func (f failingKV) Get(string) ([]byte, error) {
+	return nil, f.err
+}
+
+func TestReplayGuardTTL(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		// exp claims have a resolution of seconds
+		now   = time.Now().Truncate(time.Second)
+		guard = ReplayGuard{Now: func() time.Time { return now }}
+	)
+
+	assert.Equal(DefaultReplayTTL, guard.ttl(jwt.Claims{}))
+
+	guard.TTL = 10 * time.Minute
+	assert.Equal(10*time.Minute, guard.ttl(jwt.Claims{}))
+
+	expiring := jwt.Claims{}
+	expiring.SetExpiration(now.Add(5 * time.Minute))
+	assert.Equal(5*time.Minute, guard.ttl(expiring).Round(time.Second))
+
+	guard.Leeway = 30 * time.Second
+	assert.Equal(5*time.Minute+30*time.Second, guard.ttl(expiring).Round(time.Second))
+
+	expired := jwt.Claims{}
+	expired.SetExpiration(now.Add(-time.Hour))
+	assert.Equal(time.Second, guard.ttl(expired))
+}
+
+func testReplayGuardCheck(t *testing.T, kv store.KV) {
+	var (
+		assert = assert.New(t)
+		guard  = ReplayGuard{KV: kv}
+
+		claims  = jwt.Claims{}
+		another = jwt.Claims{}
+	)
+
+	assert.Equal(ErrorMissingJTI, guard.Check(claims))
+
+	claims.SetJWTID("first")
+	claims.SetExpiration(time.Now().Add(time.Hour))
+	assert.NoError(guard.Check(claims))
+	assert.Equal(ErrorTokenReplayed, guard.Check(claims))
+
+	another.SetJWTID("second")
+	assert.NoError(guard.Check(another))
+	assert.Equal(ErrorTokenReplayed, guard.Check(another))
+
+	value, err := kv.Get(DefaultReplayKeyPrefix + "first")
+	assert.NotEmpty(value)
+	assert.NoError(err)
+
+	// a different key prefix is a different namespace
+	guard.KeyPrefix = "other."
+	assert.NoError(guard.Check(claims))
+}
+
+func TestReplayGuardCheck(t *testing.T) {
+	t.Run("Atomic", func(t *testing.T) { testReplayGuardCheck(t, store.NewMemoryKV()) })
+	t.Run("NonAtomic", func(t *testing.T) { testReplayGuardCheck(t, nonAtomicKV{store.NewMemoryKV()}) })
+}
+
+func TestReplayGuardCheckErrors(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		claims        = jwt.Claims{}
+	)
+
+	claims.SetJWTID("test")
+	assert.Equal(ErrorNoReplayStore, (&ReplayGuard{}).Check(claims))
+	assert.Equal(expectedError, (&ReplayGuard{KV: failingKV{err: expectedError}}).Check(claims))
+}
+
+func TestJWSValidatorReplayGuard(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		validator = JWSValidator{
+			Resolver:      publicKeyResolver,
+			JWTValidators: []*jwt.Validator{(&JWTValidatorFactory{}).New()},
+			ReplayGuard:   &ReplayGuard{KV: store.NewMemoryKV()},
+		}
+	)
+
+	builder, err := NewTokenBuilder(privateKeyResolver, "")
+	require.NoError(err)
+	builder.Claims = map[string]interface{}{"capabilities": []interface{}{"x1:webpa:api:.*:post"}}
+
+	// tokens without a jti are not accepted
+	token, err := builder.Token()
+	require.NoError(err)
+	valid, err := validator.Validate(context.Background(), token)
+	assert.False(valid)
+	assert.Equal(ErrorMissingJTI, err)
+
+	builder.Claims["jti"] = "TestJWSValidatorReplayGuard"
+	token, err = builder.Token()
+	require.NoError(err)
+
+	valid, err = validator.Validate(context.Background(), token)
+	assert.True(valid)
+	assert.NoError(err)
+
+	valid, err = validator.Validate(context.Background(), token)
+	assert.False(valid)
+	assert.Equal(ErrorTokenReplayed, err)
+
+	// a token that fails verification does not consume its jti
+	builder.Claims["jti"] = "expired"
+	builder.Lifetime = -time.Hour
+	token, err = builder.Token()
+	require.NoError(err)
+	valid, err = validator.Validate(context.Background(), token)
+	assert.False(valid)
+	assert.Error(err)
+
+	_, err = validator.ReplayGuard.KV.Get(DefaultReplayKeyPrefix + "expired")
+	assert.Equal(store.ErrorKeyNotFound, err)
+}
@@ -0,0 +1,136 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/resource"
+	"github.com/Comcast/webpa-common/wrp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SignatureMetadataKey is the WRP metadata key that carries a message's HMAC, of the form sha256=<hex digest>
+	SignatureMetadataKey = "signature"
+
+	// SignatureKeyIdMetadataKey is the WRP metadata key that identifies the secret used to sign a message
+	SignatureKeyIdMetadataKey = "signature-key-id"
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	ErrorMissingSignature    = errors.New("The message is not signed")
+	ErrorInvalidSignature    = errors.New("The message signature is invalid")
+	ErrorUnknownSignatureKey = errors.New("The message was signed with an unknown key")
+)
+
+// writeSignatureField appends a length-prefixed field to the signed content, so that
+// distinct messages can never produce the same bytes
+func writeSignatureField(buffer *bytes.Buffer, field []byte) {
+	var length [binary.MaxVarintLen64]byte
+	buffer.Write(length[:binary.PutUvarint(length[:], uint64(len(field)))])
+	buffer.Write(field)
+}
+
+// MessageSignature computes the value of SignatureMetadataKey for a WRP message.  The HMAC-SHA256
+// covers the message type, source, destination, transaction, and payload.  Metadata is not signed.
+func MessageSignature(secret []byte, message *wrp.Message) string {
+	var content bytes.Buffer
+	writeSignatureField(&content, []byte(strconv.FormatInt(int64(message.Type), 10)))
+	writeSignatureField(&content, []byte(message.Source))
+	writeSignatureField(&content, []byte(message.Destination))
+	writeSignatureField(&content, []byte(message.TransactionUUID))
+	writeSignatureField(&content, message.Payload)
+
+	h := hmac.New(sha256.New, secret)
+	h.Write(content.Bytes())
+	return signaturePrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// HMACKeys maps key identifiers onto the secrets used to sign and verify WRP messages
+type HMACKeys map[string][]byte
+
+// HMACKeysFactory is the JSON representation of HMACKeys.  Each secret is loaded
+// from a resource, such as a file, and any surrounding whitespace is trimmed.
+type HMACKeysFactory map[string]resource.Factory
+
+// NewHMACKeys loads each secret described by this factory
+func (f HMACKeysFactory) NewHMACKeys() (HMACKeys, error) {
+	keys := make(HMACKeys, len(f))
+	for keyId, factory := range f {
+		loader, err := factory.NewLoader()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load HMAC key [%s]: %s", keyId, err)
+		}
+
+		data, err := resource.ReadAll(loader)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load HMAC key [%s]: %s", keyId, err)
+		}
+
+		keys[keyId] = bytes.TrimSpace(data)
+	}
+
+	return keys, nil
+}
+
+// MessageSigner attaches HMAC signatures to WRP messages
+type MessageSigner struct {
+	// KeyId identifies the secret to verifiers.  It is sent with each message if not empty.
+	KeyId string
+
+	// Secret is the HMAC key
+	Secret []byte
+}
+
+// Sign computes the signature of a message and stores it in the message's metadata.
+// This method modifies the message, so callers must pass a copy of any shared message.
+func (s *MessageSigner) Sign(message *wrp.Message) {
+	if message.Metadata == nil {
+		message.Metadata = make(map[string]string, 2)
+	}
+
+	message.Metadata[SignatureMetadataKey] = MessageSignature(s.Secret, message)
+	if len(s.KeyId) > 0 {
+		message.Metadata[SignatureKeyIdMetadataKey] = s.KeyId
+	}
+}
+
+// MessageVerifier checks the HMAC signatures of WRP messages
+type MessageVerifier struct {
+	// Keys holds the secrets that messages may be signed with
+	Keys HMACKeys
+
+	// DefaultKeyId is the key used when a message does not identify its key
+	DefaultKeyId string
+}
+
+// Verify checks that a message carries a valid signature from one of this verifier's keys
+func (v *MessageVerifier) Verify(message *wrp.Message) error {
+	signature, ok := message.Metadata[SignatureMetadataKey]
+	if !ok || !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrorMissingSignature
+	}
+
+	keyId, ok := message.Metadata[SignatureKeyIdMetadataKey]
+	if !ok {
+		keyId = v.DefaultKeyId
+	}
+
+	secret, ok := v.Keys[keyId]
+	if !ok {
+		return ErrorUnknownSignatureKey
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(MessageSignature(secret, message))) {
+		return ErrorInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package secure
+
+import (
+	"github.com/Comcast/webpa-common/resource"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMessageSignature(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "dns:talaria.example.com",
+			Destination: "mac:112233445566/service",
+			Payload:     []byte("hello"),
+		}
+
+		signature = MessageSignature([]byte("secret"), message)
+	)
+
+	assert.Regexp(`^sha256=[0-9a-f]{64}$`, signature)
+	assert.Equal(signature, MessageSignature([]byte("secret"), message))
+	assert.NotEqual(signature, MessageSignature([]byte("other"), message))
+
+	// metadata is not signed
+	message.Metadata = map[string]string{"key": "value"}
+	assert.Equal(signature, MessageSignature([]byte("secret"), message))
+
+	// moving bytes between fields changes the signature
+	message.Destination, message.Payload = "mac:112233445566/servicehel", []byte("lo")
+	assert.NotEqual(signature, MessageSignature([]byte("secret"), message))
+}
+
+func TestMessageSignerVerifier(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		signer  = &MessageSigner{KeyId: "current", Secret: []byte("secret")}
+		message = &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Destination: "mac:112233445566",
+			Payload:     []byte("hello"),
+		}
+
+		verifier = &MessageVerifier{
+			Keys: HMACKeys{"current": []byte("secret"), "unused": []byte("unused")},
+		}
+	)
+
+	assert.Equal(ErrorMissingSignature, verifier.Verify(message))
+
+	signer.Sign(message)
+	assert.Equal("current", message.Metadata[SignatureKeyIdMetadataKey])
+	assert.NoError(verifier.Verify(message))
+
+	message.Payload = []byte("tampered")
+	assert.Equal(ErrorInvalidSignature, verifier.Verify(message))
+
+	message.Metadata[SignatureKeyIdMetadataKey] = "nosuch"
+	assert.Equal(ErrorUnknownSignatureKey, verifier.Verify(message))
+
+	message.Metadata[SignatureMetadataKey] = "md5=1234"
+	assert.Equal(ErrorMissingSignature, verifier.Verify(message))
+}
+
+func TestMessageVerifierDefaultKeyId(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		signer   = &MessageSigner{Secret: []byte("secret")}
+		message  = &wrp.Message{Type: wrp.SimpleEventMessageType, Payload: []byte("hello")}
+		verifier = &MessageVerifier{Keys: HMACKeys{"default": []byte("secret")}, DefaultKeyId: "default"}
+	)
+
+	signer.Sign(message)
+	_, ok := message.Metadata[SignatureKeyIdMetadataKey]
+	assert.False(ok)
+	assert.NoError(verifier.Verify(message))
+}
+
+func TestHMACKeysFactory(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	keys, err := HMACKeysFactory{
+		"current":  resource.Factory{Data: "  secret\n"},
+		"previous": resource.Factory{Data: "old"},
+	}.NewHMACKeys()
+
+	require.NoError(err)
+	assert.Equal(HMACKeys{"current": []byte("secret"), "previous": []byte("old")}, keys)
+
+	keys, err = HMACKeysFactory{"invalid": resource.Factory{}}.NewHMACKeys()
+	assert.Nil(keys)
+	assert.Error(err)
+
+	keys, err = HMACKeysFactory{"missing": resource.Factory{URI: "/nosuch/file/exists"}}.NewHMACKeys()
+	assert.Nil(keys)
+	assert.Error(err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Comcast/webpa-common/fact"
 	"github.com/Comcast/webpa-common/secure/key"
 	"github.com/SermoDigital/jose/jws"
 	"github.com/SermoDigital/jose/jwt"
@@ -69,6 +70,10 @@ type JWSValidator struct {
 	Resolver      key.Resolver
 	Parser        JWSParser
 	JWTValidators []*jwt.Validator
+
+	// ReplayGuard is optional.  When set, each token must carry a jti claim that has not been
+	// seen before, which makes tokens single use.
+	ReplayGuard *ReplayGuard
 }
 
 // capabilityValidation determines if a claim's capability is valid
@@ -79,10 +84,12 @@ func capabilityValidation(ctx context.Context, capability string) (valid_capabil
 	   pieces[0] == "x1"    && 
 	   pieces[1] == "webpa" {
 		
-		method_value, ok := ctx.Value("method").(string)
+		method_value, ok := fact.Method(ctx)
 		if ok && (pieces[4] == "all" || strings.EqualFold(pieces[4], method_value)) {
 			claimPath := fmt.Sprintf("/%s/[^/]+/%s", pieces[2],pieces[3])
-			valid_capabilities, _ = regexp.MatchString(claimPath, ctx.Value("path").(string))
+			if path_value, ok := fact.Path(ctx); ok {
+				valid_capabilities, _ = regexp.MatchString(claimPath, path_value)
+			}
 		}
 	}
 	
@@ -139,8 +146,17 @@ func (v JWSValidator) Validate(ctx context.Context, token *Token) (valid bool, e
 		return
 	}
 
+	claims, _ := jwsToken.Payload().(jws.Claims)
+
+	// consume the jti only after the token has otherwise been verified
+	if v.ReplayGuard != nil {
+		if err = v.ReplayGuard.Check(jwt.Claims(claims)); err != nil {
+			return
+		}
+	}
+
 	// validate jwt token claims capabilities
-	if caps, capOkay := jwsToken.Payload().(jws.Claims).Get("capabilities").([]interface{}); capOkay && len(caps) > 0 {
+	if caps, capOkay := claims.Get("capabilities").([]interface{}); capOkay && len(caps) > 0 {
 	
 /*  commenting out for now
     1. remove code in use below
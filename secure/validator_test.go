@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Comcast/webpa-common/fact"
 	"github.com/Comcast/webpa-common/secure/key"
 	"github.com/SermoDigital/jose"
 	"github.com/SermoDigital/jose/jws"
@@ -31,8 +32,8 @@ func ExampleSimpleJWSValidator(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, "method", "post")
-	ctx = context.WithValue(ctx, "path", "/api/foo/path")
+	ctx = fact.SetMethod(ctx, "post")
+	ctx = fact.SetPath(ctx, "/api/foo/path")
 
 	valid, err := validator.Validate(ctx, token)
 
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"github.com/Comcast/webpa-common/health"
+	"github.com/Comcast/webpa-common/logging"
+	"net"
+	"net/http"
+)
+
+const (
+	// ListenerReadyStatPrefix is the prefix of each health stat produced by ListenerReadyStat
+	ListenerReadyStatPrefix = "ListenerReady:"
+)
+
+// serveExecutor is an internal type used to serve HTTP on an existing listener.  *http.Server implements
+// this interface.  It can be mocked for testing.
+type serveExecutor interface {
+	Serve(listener net.Listener) error
+	ServeTLS(listener net.Listener, certificateFile, keyFile string) error
+}
+
+// Bind describes one address that a logical server listens on.  All of a server's binds share
+// its handler, but each bind has its own optional TLS configuration.  Multiple binds allow a server
+// to listen on explicit IPv4 and IPv6 addresses, or on several interfaces, where a single
+// wildcard address is not permitted.
+type Bind struct {
+	Address         string
+	CertificateFile string
+	KeyFile         string
+}
+
+func (b *Bind) Certificate() (certificateFile, keyFile string) {
+	return b.CertificateFile, b.KeyFile
+}
+
+// ListenerReadyStat returns the health stat that reports whether a server is listening on a
+// given address.  The stat is 1 while the server is listening and 0 otherwise.
+func ListenerReadyStat(name, address string) health.Stat {
+	return health.Stat(ListenerReadyStatPrefix + name + "@" + address)
+}
+
+// allBinds produces the complete set of binds for a logical server, which is its own address,
+// if set, followed by any additional binds.  Binds with no address are skipped.
+func allBinds(address, certificateFile, keyFile string, binds []Bind) []Bind {
+	all := make([]Bind, 0, len(binds)+1)
+	if len(address) > 0 {
+		all = append(all, Bind{Address: address, CertificateFile: certificateFile, KeyFile: keyFile})
+	}
+
+	for _, bind := range binds {
+		if len(bind.Address) > 0 {
+			all = append(all, bind)
+		}
+	}
+
+	return all
+}
+
+// setListenerReady reports a listener's readiness to an optional health monitor
+func setListenerReady(monitor health.Monitor, name, address string, ready bool) {
+	if monitor != nil {
+		value := 0
+		if ready {
+			value = 1
+		}
+
+		monitor.SendEvent(health.Set(ListenerReadyStat(name, address), value))
+	}
+}
+
+// ServeBind listens on the bind's address and then serves, on a separate goroutine, HTTP or, if the bind
+// has both a certificate and a key file, HTTPS.  An error is returned if the address cannot be bound.
+//
+// If monitor is not nil, ListenerReadyStat(name, bind.Address) is set to 1 once the address is bound,
+// and set to 0 if the address cannot be bound or the executor stops serving.
+func ServeBind(logger logging.Logger, monitor health.Monitor, name string, bind Bind, e serveExecutor) error {
+	listener, err := net.Listen("tcp", bind.Address)
+	if err != nil {
+		setListenerReady(monitor, name, bind.Address, false)
+		return err
+	}
+
+	setListenerReady(monitor, name, bind.Address, true)
+	go func() {
+		defer setListenerReady(monitor, name, bind.Address, false)
+
+		var serveError error
+		if certificateFile, keyFile := bind.Certificate(); len(certificateFile) > 0 && len(keyFile) > 0 {
+			serveError = e.ServeTLS(listener, certificateFile, keyFile)
+		} else {
+			serveError = e.Serve(listener)
+		}
+
+		logger.Error("[%s] stopped serving on [%s]: %s", name, bind.Address, serveError)
+	}()
+
+	return nil
+}
+
+// serveAll starts a logical server on each of its binds.  The newServer closure creates the http.Server
+// for a given address.  Failures to bind are logged, so that one unavailable address does not prevent a
+// server from listening on its others.
+func serveAll(logger logging.Logger, monitor health.Monitor, name string, binds []Bind, newServer func(string) *http.Server) {
+	for _, bind := range binds {
+		logger.Info("Starting [%s] on [%s]", name, bind.Address)
+		if err := ServeBind(logger, monitor, name, bind, newServer(bind.Address)); err != nil {
+			logger.Error("Unable to start [%s] on [%s]: %s", name, bind.Address, err)
+		}
+	}
+}
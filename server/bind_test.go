@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Comcast/webpa-common/health"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockServeExecutor struct {
+	mock.Mock
+}
+
+func (m *mockServeExecutor) Serve(listener net.Listener) error {
+	return m.Called(listener).Error(0)
+}
+
+func (m *mockServeExecutor) ServeTLS(listener net.Listener, certificateFile, keyFile string) error {
+	return m.Called(listener, certificateFile, keyFile).Error(0)
+}
+
+// startTestMonitor runs a health monitor until the test's shutdown channel is closed
+func startTestMonitor(t *testing.T) (*health.Health, func()) {
+	var (
+		monitor   = health.New(time.Hour, logging.TestLogger(t))
+		waitGroup = new(sync.WaitGroup)
+		shutdown  = make(chan struct{})
+	)
+
+	monitor.Run(waitGroup, shutdown)
+	return monitor, func() {
+		close(shutdown)
+		waitGroup.Wait()
+	}
+}
+
+// listenerStats returns the health stats produced by a monitor
+func listenerStats(t *testing.T, monitor health.Monitor) map[string]int {
+	response := httptest.NewRecorder()
+	monitor.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+
+	var stats map[string]int
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &stats))
+	return stats
+}
+
+func TestBindCertificate(t *testing.T) {
+	assert := assert.New(t)
+	bind := Bind{Address: "127.0.0.1:8080", CertificateFile: "file.cert", KeyFile: "file.key"}
+
+	certificateFile, keyFile := bind.Certificate()
+	assert.Equal("file.cert", certificateFile)
+	assert.Equal("file.key", keyFile)
+}
+
+func TestListenerReadyStat(t *testing.T) {
+	assert.Equal(t, health.Stat("ListenerReady:test@[::1]:8080"), ListenerReadyStat("test", "[::1]:8080"))
+}
+
+func TestAllBinds(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		extra  = []Bind{
+			{Address: "[::1]:8080", CertificateFile: "ipv6.cert", KeyFile: "ipv6.key"},
+			{},
+			{Address: "10.0.0.1:8080"},
+		}
+	)
+
+	assert.Empty(allBinds("", "", "", nil))
+	assert.Equal(
+		[]Bind{{Address: ":8080", CertificateFile: "file.cert", KeyFile: "file.key"}},
+		allBinds(":8080", "file.cert", "file.key", nil),
+	)
+
+	assert.Equal(
+		[]Bind{extra[0], extra[2]},
+		allBinds("", "file.cert", "file.key", extra),
+	)
+
+	assert.Equal(
+		[]Bind{{Address: "127.0.0.1:8080"}, extra[0], extra[2]},
+		allBinds("127.0.0.1:8080", "", "", extra),
+	)
+}
+
+func testServeBind(t *testing.T, bind Bind) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		monitor, stop    = startTestMonitor(t)
+		executor         = new(mockServeExecutor)
+		serving          = make(chan struct{})
+		stopServing      = make(chan struct{})
+		stoppedServing   = make(chan struct{})
+		serveExpectation *mock.Call
+	)
+
+	defer stop()
+
+	if certificateFile, keyFile := bind.Certificate(); len(certificateFile) > 0 {
+		serveExpectation = executor.On("ServeTLS", mock.AnythingOfType("*net.TCPListener"), certificateFile, keyFile)
+	} else {
+		serveExpectation = executor.On("Serve", mock.AnythingOfType("*net.TCPListener"))
+	}
+
+	serveExpectation.Return(errors.New("expected")).Once().Run(func(arguments mock.Arguments) {
+		defer close(stoppedServing)
+		close(serving)
+		<-stopServing
+		arguments.Get(0).(net.Listener).Close()
+	})
+
+	require.NoError(ServeBind(logging.TestLogger(t), monitor, "test", bind, executor))
+	<-serving
+	assert.Equal(1, listenerStats(t, monitor)[string(ListenerReadyStat("test", bind.Address))])
+
+	close(stopServing)
+	<-stoppedServing
+
+	// the readiness update is sent after the executor returns
+	for repeat := 0; repeat < 100; repeat++ {
+		if listenerStats(t, monitor)[string(ListenerReadyStat("test", bind.Address))] == 0 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(0, listenerStats(t, monitor)[string(ListenerReadyStat("test", bind.Address))])
+	executor.AssertExpectations(t)
+}
+
+func testServeBindListenError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		monitor, stop = startTestMonitor(t)
+		executor      = new(mockServeExecutor)
+	)
+
+	defer stop()
+
+	existing, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer existing.Close()
+
+	address := existing.Addr().String()
+	assert.Error(ServeBind(logging.TestLogger(t), monitor, "test", Bind{Address: address}, executor))
+
+	stats := listenerStats(t, monitor)
+	value, ok := stats[string(ListenerReadyStat("test", address))]
+	assert.True(ok)
+	assert.Equal(0, value)
+	executor.AssertExpectations(t)
+}
+
+func testServeBindNilMonitor(t *testing.T) {
+	var (
+		require  = require.New(t)
+		executor = new(mockServeExecutor)
+		served   = make(chan struct{})
+	)
+
+	executor.On("Serve", mock.AnythingOfType("*net.TCPListener")).
+		Return(errors.New("expected")).
+		Once().
+		Run(func(arguments mock.Arguments) {
+			arguments.Get(0).(net.Listener).Close()
+			close(served)
+		})
+
+	require.NoError(ServeBind(logging.DefaultLogger(), nil, "test", Bind{Address: "127.0.0.1:0"}, executor))
+	<-served
+	executor.AssertExpectations(t)
+}
+
+func TestServeBind(t *testing.T) {
+	t.Run("Insecure", func(t *testing.T) { testServeBind(t, Bind{Address: "127.0.0.1:0"}) })
+	t.Run("Secure", func(t *testing.T) {
+		testServeBind(t, Bind{Address: "127.0.0.1:0", CertificateFile: "file.cert", KeyFile: "file.key"})
+	})
+
+	t.Run("ListenError", testServeBindListenError)
+	t.Run("NilMonitor", testServeBindNilMonitor)
+}
+
+func TestWebPABinds(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		handler = new(mockHandler)
+
+		webPA = WebPA{
+			Primary: Basic{
+				Name:  "test",
+				Binds: []Bind{{Address: "127.0.0.1:0"}, {Address: "localhost:0"}},
+			},
+			Health: Health{
+				Name:        "test.health",
+				LogInterval: 60 * time.Minute,
+				Binds:       []Bind{{Address: "127.0.0.1:0"}},
+			},
+		}
+
+		_, logger         = newTestLogger()
+		monitor, runnable = webPA.Prepare(logger, handler)
+	)
+
+	require.NotNil(monitor)
+	require.NotNil(runnable)
+
+	var (
+		waitGroup = new(sync.WaitGroup)
+		shutdown  = make(chan struct{})
+	)
+
+	assert.Nil(runnable.Run(waitGroup, shutdown))
+
+	stats := listenerStats(t, monitor)
+	assert.Equal(1, stats[string(ListenerReadyStat("test", "127.0.0.1:0"))])
+	assert.Equal(1, stats[string(ListenerReadyStat("test", "localhost:0"))])
+	assert.Equal(1, stats[string(ListenerReadyStat("test.health", "127.0.0.1:0"))])
+
+	close(shutdown)
+	waitGroup.Wait()
+	handler.AssertExpectations(t)
+}
+
+func TestHealthNewBindsOnly(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		_, logger = newTestLogger()
+		h         = Health{Name: "test.health", Binds: []Bind{{Address: "127.0.0.1:0"}}}
+	)
+
+	handler, server := h.New(logger)
+	assert.NotNil(handler)
+	assert.Nil(server)
+}
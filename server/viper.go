@@ -35,6 +35,10 @@ const (
 	// logging information pertinent to the pprof server.
 	PprofSuffix = "pprof"
 
+	// MetricSuffix is the suffix appended to the server name, along with a period (.), for
+	// logging information pertinent to the metrics server.
+	MetricSuffix = "metric"
+
 	// FileFlagName is the name of the command-line flag for specifying an alternate
 	// configuration file for Viper to hunt for.
 	FileFlagName = "file"
@@ -78,6 +82,9 @@ func ConfigureViper(applicationName string, f *pflag.FlagSet, v *viper.Viper) (e
 	v.SetDefault("pprof.name", fmt.Sprintf("%s.%s", applicationName, PprofSuffix))
 	v.SetDefault("pprof.logConnectionState", DefaultLogConnectionState)
 
+	v.SetDefault("metric.name", fmt.Sprintf("%s.%s", applicationName, MetricSuffix))
+	v.SetDefault("metric.logConnectionState", DefaultLogConnectionState)
+
 	configName := applicationName
 	if f != nil {
 		if fileFlag := f.Lookup(FileFlagName); fileFlag != nil {
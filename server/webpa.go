@@ -6,6 +6,9 @@ import (
 	"github.com/Comcast/webpa-common/health"
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/logging/golog"
+	"github.com/Comcast/webpa-common/ratelimit"
+	"github.com/Comcast/webpa-common/xhttp"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"net/http"
 	"sync"
 	"time"
@@ -52,18 +55,25 @@ func ListenAndServe(logger logging.Logger, s Secure, e executor) {
 
 // Basic describes a simple HTTP server.  Typically, this struct has its values
 // injected via Viper.  See the New function in this package.
+//
+// Binds are additional addresses served along with Address, each with its own TLS configuration.
 type Basic struct {
 	Name               string
 	Address            string
 	CertificateFile    string
 	KeyFile            string
 	LogConnectionState bool
+	Binds              []Bind
 }
 
 func (b *Basic) Certificate() (certificateFile, keyFile string) {
 	return b.CertificateFile, b.KeyFile
 }
 
+func (b *Basic) binds() []Bind {
+	return allBinds(b.Address, b.CertificateFile, b.KeyFile, b.Binds)
+}
+
 // New creates an http.Server using this instance's configuration.  The given logger is required,
 // but the handler may be nil.  If the handler is nil, http.DefaultServeMux is used, which matches
 // the behavior of http.Server.
@@ -75,8 +85,13 @@ func (b *Basic) New(logger logging.Logger, handler http.Handler) *http.Server {
 		return nil
 	}
 
+	return b.newServer(logger, b.Address, handler)
+}
+
+// newServer creates the http.Server for one of this instance's binds
+func (b *Basic) newServer(logger logging.Logger, address string, handler http.Handler) *http.Server {
 	server := &http.Server{
-		Addr:     b.Address,
+		Addr:     address,
 		Handler:  handler,
 		ErrorLog: NewErrorLog(b.Name, logger),
 	}
@@ -100,19 +115,24 @@ type Health struct {
 	LogConnectionState bool
 	LogInterval        time.Duration
 	Options            []string
+	Binds              []Bind
 }
 
 func (h *Health) Certificate() (certificateFile, keyFile string) {
 	return h.CertificateFile, h.KeyFile
 }
 
+func (h *Health) binds() []Bind {
+	return allBinds(h.Address, h.CertificateFile, h.KeyFile, h.Binds)
+}
+
 // New creates both a health.Health monitor (which is also an HTTP handler) and an HTTP server
-// which services health requests.
+// which services health requests on the configured Address.
 //
-// This method returns nils if the configured Address is empty, which effectively disables
-// the health server.
+// This method returns nils if there is neither an Address nor any Binds, which effectively disables
+// the health server.  If only Binds are configured, the returned server is nil.
 func (h *Health) New(logger logging.Logger) (handler *health.Health, server *http.Server) {
-	if len(h.Address) == 0 {
+	if len(h.binds()) == 0 {
 		return
 	}
 
@@ -127,8 +147,17 @@ func (h *Health) New(logger logging.Logger) (handler *health.Health, server *htt
 		options...,
 	)
 
-	server = &http.Server{
-		Addr:     h.Address,
+	if len(h.Address) > 0 {
+		server = h.newServer(logger, h.Address, handler)
+	}
+
+	return
+}
+
+// newServer creates the http.Server for one of this instance's binds
+func (h *Health) newServer(logger logging.Logger, address string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:     address,
 		Handler:  handler,
 		ErrorLog: NewErrorLog(h.Name, logger),
 	}
@@ -137,7 +166,73 @@ func (h *Health) New(logger logging.Logger) (handler *health.Health, server *htt
 		server.ConnState = NewConnectionStateLogger(h.Name, logger)
 	}
 
-	return
+	return server
+}
+
+// Metric represents a configurable factory for a metrics server along with the metrics
+// Registry it exposes.
+//
+// As with Health, this struct duplicates the fields of Basic so that Viper can inject them.
+type Metric struct {
+	Name               string
+	Address            string
+	CertificateFile    string
+	KeyFile            string
+	LogConnectionState bool
+	Binds              []Bind
+
+	Namespace               string
+	Subsystem               string
+	DisableGoCollector      bool
+	DisableProcessCollector bool
+	Metrics                 []xmetrics.Metric
+}
+
+func (m *Metric) Certificate() (certificateFile, keyFile string) {
+	return m.CertificateFile, m.KeyFile
+}
+
+func (m *Metric) binds() []Bind {
+	return allBinds(m.Address, m.CertificateFile, m.KeyFile, m.Binds)
+}
+
+// NewRegistry creates the metrics Registry described by this configuration.  A Registry
+// is always created, even when no metrics server is configured.
+func (m *Metric) NewRegistry() (xmetrics.Registry, error) {
+	return xmetrics.NewRegistry(&xmetrics.Options{
+		Namespace:               m.Namespace,
+		Subsystem:               m.Subsystem,
+		DisableGoCollector:      m.DisableGoCollector,
+		DisableProcessCollector: m.DisableProcessCollector,
+		Metrics:                 m.Metrics,
+	})
+}
+
+// New creates an HTTP server which exposes the given registry's metrics.
+//
+// This method returns nil if the configured Address is empty, which effectively disables
+// the metrics server.
+func (m *Metric) New(logger logging.Logger, registry xmetrics.Registry) *http.Server {
+	if len(m.Address) == 0 {
+		return nil
+	}
+
+	return m.newServer(logger, m.Address, registry.Handler())
+}
+
+// newServer creates the http.Server for one of this instance's binds
+func (m *Metric) newServer(logger logging.Logger, address string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:     address,
+		Handler:  handler,
+		ErrorLog: NewErrorLog(m.Name, logger),
+	}
+
+	if m.LogConnectionState {
+		server.ConnState = NewConnectionStateLogger(m.Name, logger)
+	}
+
+	return server
 }
 
 // WebPA represents a server component within the WebPA cluster.  It is used for both
@@ -152,15 +247,23 @@ type WebPA struct {
 	Alternate Basic
 
 	// Health describes the health server for this application.  Note that if the Address
-	// is empty, no health server is started.
+	// and Binds are empty, no health server is started.
 	Health Health
 
 	// Pprof describes the pprof server for this application.  Note that if the Address
-	// is empty, no pprof server is started.
+	// and Binds are empty, no pprof server is started.
 	Pprof Basic
 
+	// Metric describes the metrics server for this application.  Note that if the Address
+	// and Binds are empty, no metrics server is started.
+	Metric Metric
+
 	// Log is the logging configuration for this application.
 	Log golog.LoggerFactory
+
+	// RateLimits are the per-route rate limits applied to the primary handler.  Requests whose
+	// paths match none of these routes are not limited.
+	RateLimits []ratelimit.RouteLimit
 }
 
 // Prepare gets a WebPA server ready for execution.  This method does not return errors, but the returned
@@ -168,37 +271,70 @@ type WebPA struct {
 // WebPA.Log object can be used to create a different logger if desired.
 //
 // The supplied http.Handler is used for the primary server.  If the alternate server has an address,
-// it will also be used for that server.  The health server uses an internally create handler, while the pprof
+// it will also be used for that server.  Any configured RateLimits are applied to this handler, and the
+// Runnable returns an error if they are invalid.  The health server uses an internally create handler, while the pprof
 // server uses http.DefaultServeMux.  The health Monitor created from configuration is returned so that other
 // infrastructure can make use of it.
+//
+// Each server listens on its Address and on each of its Binds.  When a health server is configured, the
+// readiness of every listener is reported in the health stats under ListenerReadyStat.
 func (w *WebPA) Prepare(logger logging.Logger, primaryHandler http.Handler) (health.Monitor, concurrent.Runnable) {
-	healthHandler, healthServer := w.Health.New(logger)
+	return w.PrepareWithMetrics(logger, nil, primaryHandler)
+}
+
+// PrepareWithMetrics is like Prepare, but also instruments the primary handler with the given registry
+// and starts the metrics server if one is configured.  The registry will typically come from Metric.NewRegistry,
+// and is also passed to other components such as device.Options.  If the registry is nil, this method behaves
+// exactly like Prepare.
+func (w *WebPA) PrepareWithMetrics(logger logging.Logger, registry xmetrics.Registry, primaryHandler http.Handler) (health.Monitor, concurrent.Runnable) {
+	healthHandler, _ := w.Health.New(logger)
 	return healthHandler, concurrent.RunnableFunc(func(waitGroup *sync.WaitGroup, shutdown <-chan struct{}) error {
-		if healthHandler != nil && healthServer != nil {
-			logger.Info("Starting [%s] on [%s]", w.Health.Name, w.Health.Address)
-			ListenAndServe(logger, &w.Health, healthServer)
+		if len(w.RateLimits) > 0 {
+			routeLimiter := ratelimit.RouteLimiter{Routes: w.RateLimits, Logger: logger}
+			limitedHandler, err := routeLimiter.Then(primaryHandler)
+			if err != nil {
+				return err
+			}
+
+			primaryHandler = limitedHandler
+		}
+
+		// the monitor is only set when there is a health server, since an unstarted Health blocks events
+		var monitor health.Monitor
+		if healthHandler != nil {
 			healthHandler.Run(waitGroup, shutdown)
+			monitor = healthHandler
+			serveAll(logger, monitor, w.Health.Name, w.Health.binds(), func(address string) *http.Server {
+				return w.Health.newServer(logger, address, healthHandler)
+			})
 
 			// wrap the primary handler in the RequestTracker decorator
 			primaryHandler = healthHandler.RequestTracker(primaryHandler)
 		}
 
-		if pprofServer := w.Pprof.New(logger, nil); pprofServer != nil {
-			logger.Info("Starting [%s] on [%s]", w.Pprof.Name, w.Pprof.Address)
-			ListenAndServe(logger, &w.Pprof, pprofServer)
+		if registry != nil {
+			primaryHandler = xhttp.NewInstrumenter(registry).Then(w.Primary.Name, primaryHandler)
+			serveAll(logger, monitor, w.Metric.Name, w.Metric.binds(), func(address string) *http.Server {
+				return w.Metric.newServer(logger, address, registry.Handler())
+			})
 		}
 
-		if primaryServer := w.Primary.New(logger, primaryHandler); primaryServer != nil {
-			logger.Info("Starting [%s] on [%s]", w.Primary.Name, w.Primary.Address)
-			ListenAndServe(logger, &w.Primary, primaryServer)
-		} else {
+		serveAll(logger, monitor, w.Pprof.Name, w.Pprof.binds(), func(address string) *http.Server {
+			return w.Pprof.newServer(logger, address, nil)
+		})
+
+		primaryBinds := w.Primary.binds()
+		if len(primaryBinds) == 0 {
 			return ErrorNoPrimaryAddress
 		}
 
-		if alternateServer := w.Alternate.New(logger, primaryHandler); alternateServer != nil {
-			logger.Info("Starting [%s] on [%s]", w.Alternate.Name, w.Alternate.Address)
-			ListenAndServe(logger, &w.Alternate, alternateServer)
-		}
+		serveAll(logger, monitor, w.Primary.Name, primaryBinds, func(address string) *http.Server {
+			return w.Primary.newServer(logger, address, primaryHandler)
+		})
+
+		serveAll(logger, monitor, w.Alternate.Name, w.Alternate.binds(), func(address string) *http.Server {
+			return w.Alternate.newServer(logger, address, primaryHandler)
+		})
 
 		return nil
 	})
@@ -3,6 +3,8 @@ package server
 import (
 	"errors"
 	"github.com/Comcast/webpa-common/health"
+	"github.com/Comcast/webpa-common/ratelimit"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -308,6 +310,116 @@ func TestHealthNew(t *testing.T) {
 	}
 }
 
+func TestMetricCertificate(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		testData = []struct {
+			certificateFile, keyFile string
+		}{
+			{"", ""},
+			{"", "file.key"},
+			{"file.cert", ""},
+			{"file.cert", "file.key"},
+		}
+	)
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		var (
+			metric = Metric{
+				CertificateFile: record.certificateFile,
+				KeyFile:         record.keyFile,
+			}
+
+			actualCertificateFile, actualKeyFile = metric.Certificate()
+		)
+
+		assert.Equal(record.certificateFile, actualCertificateFile)
+		assert.Equal(record.keyFile, actualKeyFile)
+	}
+}
+
+func TestMetricNewRegistry(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		metric  = Metric{
+			Namespace:               "test",
+			DisableGoCollector:      true,
+			DisableProcessCollector: true,
+			Metrics: []xmetrics.Metric{
+				{Name: "configured", Type: xmetrics.CounterType},
+			},
+		}
+	)
+
+	registry, err := metric.NewRegistry()
+	require.NotNil(registry)
+	require.NoError(err)
+	registry.NewCounter("configured").Add(1.0)
+
+	families, err := registry.Gather()
+	require.NoError(err)
+	require.Len(families, 1)
+	assert.Equal("test_configured", families[0].GetName())
+
+	metric.Metrics = []xmetrics.Metric{{Name: "bad", Type: "nosuch"}}
+	registry, err = metric.NewRegistry()
+	assert.Nil(registry)
+	assert.Error(err)
+}
+
+func TestMetricNew(t *testing.T) {
+	const expectedName = "TestMetricNew"
+
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		testData = []struct {
+			address            string
+			logConnectionState bool
+		}{
+			{"", false},
+			{"", true},
+			{":901", false},
+			{"localhost:9002", true},
+		}
+	)
+
+	registry, err := xmetrics.NewRegistry(nil)
+	require.NoError(err)
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+
+		var (
+			verify, logger = newTestLogger()
+			metric         = Metric{
+				Name:               expectedName,
+				Address:            record.address,
+				LogConnectionState: record.logConnectionState,
+			}
+
+			server = metric.New(logger, registry)
+		)
+
+		if len(record.address) > 0 {
+			require.NotNil(server)
+			assert.Equal(record.address, server.Addr)
+			assert.NotNil(server.Handler)
+			assertErrorLog(assert, verify, expectedName, server.ErrorLog)
+
+			if record.logConnectionState {
+				assertConnState(assert, verify, server.ConnState)
+			} else {
+				assert.Nil(server.ConnState)
+			}
+		} else {
+			require.Nil(server)
+		}
+	}
+}
+
 func TestWebPANoPrimaryAddress(t *testing.T) {
 	var (
 		assert  = assert.New(t)
@@ -379,3 +491,77 @@ func TestWebPA(t *testing.T) {
 	waitGroup.Wait() // the http.Server instances will still be running after this returns
 	handler.AssertExpectations(t)
 }
+
+func TestWebPAWithMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		handler = new(mockHandler)
+
+		webPA = WebPA{
+			Primary: Basic{
+				Name:    "test",
+				Address: ":0",
+			},
+			Metric: Metric{
+				Name:    "test.metric",
+				Address: ":0",
+			},
+		}
+	)
+
+	registry, err := webPA.Metric.NewRegistry()
+	require.NoError(err)
+
+	var (
+		_, logger         = newTestLogger()
+		monitor, runnable = webPA.PrepareWithMetrics(logger, registry, handler)
+	)
+
+	assert.Nil(monitor)
+	require.NotNil(runnable)
+
+	var (
+		waitGroup = new(sync.WaitGroup)
+		shutdown  = make(chan struct{})
+	)
+
+	assert.Nil(runnable.Run(waitGroup, shutdown))
+	close(shutdown)
+	waitGroup.Wait()
+	handler.AssertExpectations(t)
+}
+
+func TestWebPAInvalidRateLimits(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		handler = new(mockHandler)
+
+		webPA = WebPA{
+			Primary: Basic{
+				Name:    "test",
+				Address: ":0",
+			},
+			RateLimits: []ratelimit.RouteLimit{
+				{Pattern: "/api/v2/devices", Key: "nosuch"},
+			},
+		}
+
+		_, logger         = newTestLogger()
+		monitor, runnable = webPA.Prepare(logger, handler)
+	)
+
+	assert.Nil(monitor)
+	require.NotNil(runnable)
+
+	var (
+		waitGroup = new(sync.WaitGroup)
+		shutdown  = make(chan struct{})
+	)
+
+	defer close(shutdown)
+	assert.Error(runnable.Run(waitGroup, shutdown))
+	waitGroup.Wait()
+	handler.AssertExpectations(t)
+}
@@ -24,7 +24,25 @@ var (
 //
 // The go.serversets library returns endpoints in this format.  This function is
 // used to turn and endpoint into a valid base URL for a given service.
+//
+// Values that are already URLs of the form scheme://host:port, as reported by the Consul
+// and DNS backends, are also accepted.
 func ParseHostPort(value string) (baseURL string, err error) {
+	if !strings.HasPrefix(value, "[") && strings.Contains(value, "://") {
+		var endpoint *url.URL
+		if endpoint, err = url.Parse(value); err != nil {
+			return
+		}
+
+		if len(endpoint.Hostname()) == 0 || len(endpoint.Port()) == 0 {
+			err = fmt.Errorf("Missing host or port in endpoint %s", value)
+			return
+		}
+
+		baseURL = endpoint.Scheme + "://" + endpoint.Host
+		return
+	}
+
 	var host, portString string
 	host, portString, err = net.SplitHostPort(value)
 	if err != nil {
@@ -131,6 +149,41 @@ func (f *consistentHashFactory) New(endpoints []string) (Accessor, []string) {
 	return hash, baseURLs
 }
 
+// NewWeighted creates an Accessor that honors weights of 0, which drain endpoints.  The consistentHash
+// library gives every endpoint the same number of vnodes, so other weights are ignored and logged.  Use
+// NewRingAccessorFactory to route keys in proportion to weights.
+func (f *consistentHashFactory) NewWeighted(endpoints []Endpoint) (Accessor, []string) {
+	var (
+		values   = make([]string, 0, len(endpoints))
+		drained  int
+		weighted bool
+	)
+
+	for _, endpoint := range endpoints {
+		switch weight := endpoint.Weight(); weight {
+		case 0:
+			drained++
+			continue
+		case DefaultWeight:
+		default:
+			weighted = true
+		}
+
+		values = append(values, endpoint.Value)
+	}
+
+	if drained > 0 && len(values) == 0 {
+		f.logger.Error("All %d endpoints have a weight of 0, so weights are being ignored", drained)
+		return f.New(EndpointValues(endpoints))
+	}
+
+	if weighted {
+		f.logger.Warn("Nonzero endpoint weights are not supported by this accessor factory and are being ignored")
+	}
+
+	return f.New(values)
+}
+
 // UpdatableAccessor represents an accessor whose set of hashed endpoints can be changed.
 // Changes to this accessor via its Update method are atomic.  It is safe to use Get and
 // Update from multiple goroutines.
@@ -151,10 +204,18 @@ type UpdatableAccessor interface {
 	//
 	//     Subscribe(logger, watch, accessor.Update)
 	Update([]string)
+
+	// UpdateEndpoints atomically changes the set of endpoints returned by Get, as with Update.  If this
+	// accessor's factory is a WeightedAccessorFactory, keys are routed to endpoints in proportion to their
+	// weights.  Otherwise, the endpoints' metadata is ignored.
+	//
+	// This method may be used as a Subscription.EndpointListener.
+	UpdateEndpoints([]Endpoint)
 }
 
 // updatableAccessor is the internal UpdatableAccessor implementation
 type updatableAccessor struct {
+	logger   logging.Logger
 	factory  AccessorFactory
 	accessor atomic.Value
 }
@@ -168,6 +229,11 @@ func (ua *updatableAccessor) Update(endpoints []string) {
 	ua.accessor.Store(newAccessor)
 }
 
+func (ua *updatableAccessor) UpdateEndpoints(endpoints []Endpoint) {
+	newAccessor, _ := newAccessor(ua.logger, ua.factory, endpoints)
+	ua.accessor.Store(newAccessor)
+}
+
 // NewUpdatableAccessor is a factory function that produces an UpdatableAccessor
 // from a set of Options, which can be nil for defaults.
 //
@@ -175,6 +241,7 @@ func (ua *updatableAccessor) Update(endpoints []string) {
 // be empty, in which case Get will return errors until Update is called with a nonempty slice.
 func NewUpdatableAccessor(o *Options, initialEndpoints []string) UpdatableAccessor {
 	accessor := &updatableAccessor{
+		logger:  o.logger(),
 		factory: NewAccessorFactory(o),
 	}
 
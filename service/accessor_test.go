@@ -20,6 +20,12 @@ func TestParseHostPort(t *testing.T) {
 			{"localhost:8080", "http://localhost:8080", false},
 			{"[http://something.comcast.net]:8080", "http://something.comcast.net:8080", false},
 			{"[https://65.71.145.16]:8080", "https://65.71.145.16:8080", false},
+			{"http://something.comcast.net:8080", "http://something.comcast.net:8080", false},
+			{"https://65.71.145.16:8443", "https://65.71.145.16:8443", false},
+			{"https://[::1]:8443", "https://[::1]:8443", false},
+			{"http://something.comcast.net", "", true},
+			{"http://:8080", "", true},
+			{"http://something.comcast.net:8080/path%zz", "", true},
 			{"", "", true},
 			{"localhost", "", true},
 			{"something.comcast.net", "", true},
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"github.com/Comcast/webpa-common/gate"
+	"net/http"
+	"time"
+)
+
+// AlternateBackoff is a gate.BackoffPolicy which suggests a fixed retry delay along with an
+// alternate endpoint chosen by an Accessor, in the same way as NewRedirectHandler.
+type AlternateBackoff struct {
+	// RetryAfter is the delay suggested to clients
+	RetryAfter time.Duration
+
+	// Accessor selects the alternate node.  If nil, no alternate endpoint is suggested.
+	Accessor Accessor
+
+	// KeyFunc examines a request and returns the hash key passed to the Accessor.  If nil,
+	// the request's URL path is used as the key.
+	KeyFunc func(*http.Request) ([]byte, error)
+}
+
+func (ab *AlternateBackoff) keyFunc(request *http.Request) ([]byte, error) {
+	if ab.KeyFunc != nil {
+		return ab.KeyFunc(request)
+	}
+
+	return []byte(request.URL.Path), nil
+}
+
+// Backoff computes the hint for a rejected request.  Failures to select an alternate
+// endpoint are not errors:  the hint simply omits the alternate.
+func (ab *AlternateBackoff) Backoff(request *http.Request) gate.Backoff {
+	backoff := gate.Backoff{RetryAfter: ab.RetryAfter}
+	if ab.Accessor == nil {
+		return backoff
+	}
+
+	key, err := ab.keyFunc(request)
+	if err != nil {
+		return backoff
+	}
+
+	if node, err := ab.Accessor.Get(key); err == nil {
+		backoff.Alternate = ReplaceHostPort(node, request.URL)
+	}
+
+	return backoff
+}
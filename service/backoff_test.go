@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/gate"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlternateBackoffNoAccessor(t *testing.T) {
+	assert := assert.New(t)
+	policy := &AlternateBackoff{RetryAfter: time.Minute}
+	assert.Equal(gate.Backoff{RetryAfter: time.Minute}, policy.Backoff(httptest.NewRequest("GET", "/", nil)))
+}
+
+func TestAlternateBackoff(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		accessor = new(mockAccessor)
+		policy   = &AlternateBackoff{RetryAfter: 15 * time.Second, Accessor: accessor}
+	)
+
+	accessor.On("Get", []byte("/api/v2/device")).Return("http://alternate.com:8080", nil).Once()
+	assert.Equal(
+		gate.Backoff{RetryAfter: 15 * time.Second, Alternate: "http://alternate.com:8080/api/v2/device?foo=bar"},
+		policy.Backoff(httptest.NewRequest("GET", "http://localhost/api/v2/device?foo=bar", nil)),
+	)
+
+	accessor.On("Get", []byte("/failure")).Return("", errors.New("expected")).Once()
+	assert.Equal(
+		gate.Backoff{RetryAfter: 15 * time.Second},
+		policy.Backoff(httptest.NewRequest("GET", "/failure", nil)),
+	)
+
+	accessor.AssertExpectations(t)
+}
+
+func TestAlternateBackoffKeyFunc(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		accessor = new(mockAccessor)
+		keyError = errors.New("expected")
+		policy   = &AlternateBackoff{
+			Accessor: accessor,
+			KeyFunc: func(request *http.Request) ([]byte, error) {
+				if name := request.Header.Get("X-Device-Name"); len(name) > 0 {
+					return []byte(name), nil
+				}
+
+				return nil, keyError
+			},
+		}
+	)
+
+	request := httptest.NewRequest("GET", "/connect", nil)
+	assert.Equal(gate.Backoff{}, policy.Backoff(request))
+
+	accessor.On("Get", []byte("mac:112233445566")).Return("http://alternate.com", nil).Once()
+	request.Header.Set("X-Device-Name", "mac:112233445566")
+	assert.Equal(gate.Backoff{Alternate: "http://alternate.com/connect"}, policy.Backoff(request))
+
+	accessor.AssertExpectations(t)
+}
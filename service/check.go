@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/health"
+)
+
+var (
+	ErrorWatchClosed = errors.New("The service discovery watch has been closed")
+)
+
+// NewWatchCheck creates a health check which fails once the given watch has closed.  Watches
+// close when their Zookeeper session is irrecoverably lost, so this check reflects session health
+// without any additional Zookeeper traffic.
+func NewWatchCheck(watch Watch) health.Check {
+	return health.CheckFunc(func(context.Context) error {
+		if watch.IsClosed() {
+			return ErrorWatchClosed
+		}
+
+		return nil
+	})
+}
+
+// NewRegistrarCheck creates a health check for a registrar's Zookeeper session.  For a *FailoverRegistrar,
+// the check fails when no ensemble is active.  For any other Registrar, each run of the check opens and closes
+// a watch, which fails if Zookeeper cannot be reached.
+func NewRegistrarCheck(registrar Registrar) health.Check {
+	if failover, ok := registrar.(*FailoverRegistrar); ok {
+		return health.CheckFunc(func(context.Context) error {
+			if failover.Active() < 0 {
+				return ErrorNoEnsemble
+			}
+
+			return nil
+		})
+	}
+
+	return health.CheckFunc(func(context.Context) error {
+		watch, err := registrar.Watch()
+		if err != nil {
+			return err
+		}
+
+		watch.Close()
+		return nil
+	})
+}
+
+// NewSubscriptionSource creates a health source which reports, under the given stat, the count of endpoints
+// in the most recent watch event seen by a subscription.  Along with a Threshold whose Below field is set,
+// this allows a server to report that it has too few endpoints to route requests.
+func NewSubscriptionSource(subscription *Subscription, stat health.Stat) health.Source {
+	return health.SourceFunc(func(stats health.Stats) {
+		stats[stat] = len(subscription.Endpoints())
+	})
+}
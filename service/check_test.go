@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/health"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewWatchCheck(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		watch  = new(mockWatch)
+		check  = NewWatchCheck(watch)
+	)
+
+	watch.On("IsClosed").Return(false).Once()
+	watch.On("IsClosed").Return(true).Once()
+
+	assert.NoError(check.Check(context.Background()))
+	assert.Equal(ErrorWatchClosed, check.Check(context.Background()))
+	watch.AssertExpectations(t)
+}
+
+func TestNewRegistrarCheck(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		registrar     = new(mockRegistrar)
+		watch         = new(mockWatch)
+		check         = NewRegistrarCheck(registrar)
+	)
+
+	registrar.On("Watch").Return(watch, nil).Once()
+	registrar.On("Watch").Return(nil, expectedError).Once()
+	watch.On("Close").Once()
+
+	assert.NoError(check.Check(context.Background()))
+	assert.Equal(expectedError, check.Check(context.Background()))
+
+	registrar.AssertExpectations(t)
+	watch.AssertExpectations(t)
+}
+
+func TestNewRegistrarCheckFailover(t *testing.T) {
+	var (
+		assert              = assert.New(t)
+		registrar, mocks, _ = newTestFailoverRegistrar(t, 2)
+		primaryWatch, _     = newEnsembleWatch([]string{"primary"})
+		check               = NewRegistrarCheck(registrar)
+	)
+
+	assert.Equal(ErrorNoEnsemble, check.Check(context.Background()))
+
+	mocks[0].On("Watch").Return(primaryWatch, nil).Once()
+	_, err := registrar.Watch()
+	assert.NoError(err)
+	assert.NoError(check.Check(context.Background()))
+
+	registrar.Stop()
+	mocks[0].AssertExpectations(t)
+}
+
+func TestNewSubscriptionSource(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		subscription = new(Subscription)
+		source       = NewSubscriptionSource(subscription, "Endpoints")
+		stats        = make(health.Stats)
+	)
+
+	source.Collect(stats)
+	assert.Equal(health.Stats{"Endpoints": 0}, stats)
+
+	subscription.forward([]Endpoint{{Value: "http://first.comcast.net:8080"}, {Value: "http://second.comcast.net:8080"}})
+	source.Collect(stats)
+	assert.Equal(health.Stats{"Endpoints": 2}, stats)
+}
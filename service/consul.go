@@ -0,0 +1,504 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/hashicorp/consul/api"
+	"github.com/strava/go.serversets"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ZookeeperBackend selects Zookeeper, via go.serversets, for registrations and watches.  This is the default.
+	ZookeeperBackend = "zookeeper"
+
+	// ConsulBackend selects Consul for registrations and watches
+	ConsulBackend = "consul"
+
+	// SchemeMetadataKey is the Consul service metadata key that carries the scheme of a registered endpoint
+	SchemeMetadataKey = "scheme"
+
+	DefaultCheckTTL           = 15 * time.Second
+	DefaultDeregisterAfter    = time.Minute
+	DefaultWatchWaitTime      = 5 * time.Minute
+	DefaultWatchRetryInterval = 5 * time.Second
+)
+
+// ConsulOptions configures the Consul backend.  Registrations and watches are made through the Consul
+// agent at Address, which is normally the agent running on the local host.
+type ConsulOptions struct {
+	// Address is the host:port of the Consul agent.  If unset, the Consul client defaults apply,
+	// including the CONSUL_HTTP_ADDR environment variable.
+	Address string `json:"address,omitempty"`
+
+	// Scheme is the scheme, http or https, used to reach the Consul agent.  If unset, the Consul
+	// client defaults apply.
+	Scheme string `json:"scheme,omitempty"`
+
+	// Datacenter is the optional Consul datacenter.  If unset, the agent's datacenter is used.
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// Token is the optional ACL token used for all Consul requests.
+	Token string `json:"token,omitempty"`
+
+	// CheckTTL is the TTL of the health check attached to each registered endpoint.  The check is
+	// refreshed at half this interval, using the Options.PingFunc if one is supplied.  If not positive,
+	// DefaultCheckTTL is used.
+	CheckTTL time.Duration `json:"checkTTL"`
+
+	// DeregisterAfter is how long an endpoint's check may remain critical, e.g. because this process has
+	// died, before Consul deregisters the endpoint.  If not positive, DefaultDeregisterAfter is used.
+	DeregisterAfter time.Duration `json:"deregisterAfter"`
+
+	// WaitTime is the maximum duration of each blocking query made by a watch.  If not positive,
+	// DefaultWatchWaitTime is used.
+	WaitTime time.Duration `json:"waitTime"`
+
+	// RetryInterval is how long a watch waits before retrying a failed query.  If not positive,
+	// DefaultWatchRetryInterval is used.
+	RetryInterval time.Duration `json:"retryInterval"`
+}
+
+func (co *ConsulOptions) config() *api.Config {
+	config := api.DefaultConfig()
+	if co != nil {
+		if len(co.Address) > 0 {
+			config.Address = co.Address
+		}
+
+		if len(co.Scheme) > 0 {
+			config.Scheme = co.Scheme
+		}
+
+		config.Datacenter = co.Datacenter
+		config.Token = co.Token
+	}
+
+	return config
+}
+
+func (co *ConsulOptions) checkTTL() time.Duration {
+	if co != nil && co.CheckTTL > 0 {
+		return co.CheckTTL
+	}
+
+	return DefaultCheckTTL
+}
+
+func (co *ConsulOptions) deregisterAfter() time.Duration {
+	if co != nil && co.DeregisterAfter > 0 {
+		return co.DeregisterAfter
+	}
+
+	return DefaultDeregisterAfter
+}
+
+func (co *ConsulOptions) waitTime() time.Duration {
+	if co != nil && co.WaitTime > 0 {
+		return co.WaitTime
+	}
+
+	return DefaultWatchWaitTime
+}
+
+func (co *ConsulOptions) retryInterval() time.Duration {
+	if co != nil && co.RetryInterval > 0 {
+		return co.RetryInterval
+	}
+
+	return DefaultWatchRetryInterval
+}
+
+// consulClient is the subset of the Consul API used by ConsulRegistrar.  It can be mocked for testing.
+type consulClient interface {
+	ServiceRegister(*api.AgentServiceRegistration) error
+	ServiceDeregister(serviceID string) error
+	UpdateTTL(checkID, output, status string) error
+	Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+}
+
+// consulAPI adapts a Consul client to the consulClient interface.  api.Agent and api.Health both
+// have a Service method, so they cannot simply be embedded.
+type consulAPI struct {
+	agent  *api.Agent
+	health *api.Health
+}
+
+func (c *consulAPI) ServiceRegister(registration *api.AgentServiceRegistration) error {
+	return c.agent.ServiceRegister(registration)
+}
+
+func (c *consulAPI) ServiceDeregister(serviceID string) error {
+	return c.agent.ServiceDeregister(serviceID)
+}
+
+func (c *consulAPI) UpdateTTL(checkID, output, status string) error {
+	return c.agent.UpdateTTL(checkID, output, status)
+}
+
+func (c *consulAPI) Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	return c.health.Service(service, tag, passingOnly, q)
+}
+
+// consulRegistration is an endpoint registered with Consul, along with the shutdown channel of its heartbeat
+type consulRegistration struct {
+	checkID  string
+	shutdown chan struct{}
+}
+
+// ConsulRegistrar is a Registrar backed by Consul.  Each endpoint is registered as an instance of
+// Options.ServiceName, tagged with Options.Environment, and carries a TTL check that is kept alive by
+// a heartbeat.  Watches use blocking queries, and only report endpoints whose checks are passing.
+//
+// Consul has no equivalent of *serversets.Endpoint, so the endpoints returned by RegisterEndpoint are
+// always nil.  Clients should call Stop to deregister endpoints.  Secondary Zookeeper ensembles, configured
+// via Options.Failover, do not apply to this registrar.
+type ConsulRegistrar struct {
+	logger          logging.Logger
+	client          consulClient
+	clientError     error
+	serviceName     string
+	tag             string
+	checkTTL        time.Duration
+	deregisterAfter time.Duration
+	waitTime        time.Duration
+	retryInterval   time.Duration
+	after           func(time.Duration) <-chan time.Time
+
+	lock          sync.Mutex
+	registrations map[string]*consulRegistration
+	watches       map[*consulWatch]bool
+	stopped       bool
+}
+
+// NewConsulRegistrar creates a ConsulRegistrar from a set of options.  No connection to Consul is made
+// by this function.  If the Consul client cannot be created, the error is returned from each subsequent
+// call to RegisterEndpoint and Watch.
+func NewConsulRegistrar(o *Options) *ConsulRegistrar {
+	var (
+		client      consulClient
+		consul, err = api.NewClient(o.consul().config())
+	)
+
+	if err == nil {
+		client = &consulAPI{agent: consul.Agent(), health: consul.Health()}
+	}
+
+	return newConsulRegistrar(o, client, err)
+}
+
+func newConsulRegistrar(o *Options, client consulClient, clientError error) *ConsulRegistrar {
+	consul := o.consul()
+	return &ConsulRegistrar{
+		logger:          o.logger(),
+		client:          client,
+		clientError:     clientError,
+		serviceName:     o.serviceName(),
+		tag:             string(o.environment()),
+		checkTTL:        consul.checkTTL(),
+		deregisterAfter: consul.deregisterAfter(),
+		waitTime:        consul.waitTime(),
+		retryInterval:   consul.retryInterval(),
+		after:           time.After,
+		registrations:   make(map[string]*consulRegistration),
+		watches:         make(map[*consulWatch]bool),
+	}
+}
+
+// check returns the error, if any, that prevents this registrar from being used.  This method must
+// be called under the lock.
+func (r *ConsulRegistrar) check() error {
+	if r.clientError != nil {
+		return r.clientError
+	}
+
+	if r.stopped {
+		return ErrorStopped
+	}
+
+	return nil
+}
+
+func (r *ConsulRegistrar) RegisterEndpoint(host string, port int, ping func() error) (*serversets.Endpoint, error) {
+	return r.RegisterEndpointWithMetadata(host, port, ping, nil)
+}
+
+// RegisterEndpointWithMetadata registers an endpoint with Consul, publishing the metadata as the service's
+// metadata.  The host may be prefixed with a scheme, as produced by ParseRegistration.  Registering the same
+// endpoint again replaces the earlier registration.
+func (r *ConsulRegistrar) RegisterEndpointWithMetadata(host string, port int, ping func() error, metadata map[string]string) (*serversets.Endpoint, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+
+	scheme, address := DefaultScheme, host
+	if parts := strings.SplitN(host, "://", 2); len(parts) == 2 {
+		scheme, address = parts[0], parts[1]
+	}
+
+	meta := make(map[string]string, len(metadata)+1)
+	for key, value := range metadata {
+		meta[key] = value
+	}
+
+	meta[SchemeMetadataKey] = scheme
+
+	var (
+		serviceID = fmt.Sprintf("%s-%s-%d", r.serviceName, address, port)
+		checkID   = "service:" + serviceID
+	)
+
+	err := r.client.ServiceRegister(&api.AgentServiceRegistration{
+		ID:      serviceID,
+		Name:    r.serviceName,
+		Tags:    []string{r.tag},
+		Address: address,
+		Port:    port,
+		Meta:    meta,
+		Check: &api.AgentServiceCheck{
+			CheckID:                        checkID,
+			TTL:                            r.checkTTL.String(),
+			DeregisterCriticalServiceAfter: r.deregisterAfter.String(),
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, ok := r.registrations[serviceID]; ok {
+		close(existing.shutdown)
+	}
+
+	registration := &consulRegistration{checkID: checkID, shutdown: make(chan struct{})}
+	r.registrations[serviceID] = registration
+	go r.heartbeat(registration, ping)
+	return nil, nil
+}
+
+// heartbeat is the goroutine which keeps an endpoint's TTL check alive.  If ping is supplied, the check
+// is marked critical whenever ping returns an error.
+func (r *ConsulRegistrar) heartbeat(registration *consulRegistration, ping func() error) {
+	interval := r.checkTTL / 2
+	for {
+		status, output := api.HealthPassing, ""
+		if ping != nil {
+			if err := ping(); err != nil {
+				status, output = api.HealthCritical, err.Error()
+			}
+		}
+
+		if err := r.client.UpdateTTL(registration.checkID, output, status); err != nil {
+			r.logger.Error("Unable to update Consul check %s: %s", registration.checkID, err)
+		}
+
+		select {
+		case <-registration.shutdown:
+			return
+		case <-r.after(interval):
+		}
+	}
+}
+
+func (r *ConsulRegistrar) Watch() (Watch, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+
+	// the first query is made synchronously, so that configuration problems are reported immediately
+	entries, meta, err := r.client.Service(r.serviceName, r.tag, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		endpoints   = consulEndpointsWithMetadata(entries)
+		w           = &consulWatch{
+			registrar: r,
+			ctx:       ctx,
+			cancel:    cancel,
+			event:     make(chan struct{}, 1),
+			endpoints: endpoints,
+			values:    EndpointValues(endpoints),
+		}
+	)
+
+	r.watches[w] = true
+	go w.run(meta.LastIndex)
+	return w, nil
+}
+
+// Stop deregisters all endpoints and closes all watches.  Once stopped, a ConsulRegistrar cannot be
+// restarted.  This method is idempotent.
+func (r *ConsulRegistrar) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	r.stopped = true
+	for serviceID, registration := range r.registrations {
+		close(registration.shutdown)
+		if err := r.client.ServiceDeregister(serviceID); err != nil {
+			r.logger.Error("Unable to deregister Consul service %s: %s", serviceID, err)
+		}
+	}
+
+	for w := range r.watches {
+		w.close()
+	}
+
+	r.registrations = nil
+	r.watches = nil
+}
+
+// consulEndpointsWithMetadata produces the Endpoints for a set of Consul service entries, sorted by value.
+// The metadata of each endpoint is its service's metadata.
+func consulEndpointsWithMetadata(entries []*api.ServiceEntry) []Endpoint {
+	endpoints := make([]Endpoint, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Service == nil {
+			continue
+		}
+
+		scheme, address := entry.Service.Meta[SchemeMetadataKey], entry.Service.Address
+		if len(scheme) == 0 {
+			scheme = DefaultScheme
+		}
+
+		// an empty service address means the service is reachable at its node's address
+		if len(address) == 0 && entry.Node != nil {
+			address = entry.Node.Address
+		}
+
+		endpoints = append(endpoints, Endpoint{
+			Value:    scheme + "://" + net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)),
+			Metadata: entry.Service.Meta,
+		})
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Value < endpoints[j].Value })
+	return endpoints
+}
+
+// consulWatch is the Watch implementation returned by ConsulRegistrar
+type consulWatch struct {
+	registrar *ConsulRegistrar
+	ctx       context.Context
+	cancel    func()
+	event     chan struct{}
+	closed    int32
+
+	lock      sync.Mutex
+	endpoints []Endpoint
+	values    []string
+}
+
+// run is the goroutine which issues blocking queries, starting at the given index, until this watch is closed
+func (w *consulWatch) run(index uint64) {
+	r := w.registrar
+	for {
+		q := &api.QueryOptions{WaitIndex: index, WaitTime: r.waitTime}
+		entries, meta, err := r.client.Service(r.serviceName, r.tag, true, q.WithContext(w.ctx))
+		if w.IsClosed() {
+			return
+		}
+
+		if err != nil {
+			r.logger.Error("Unable to query Consul for service %s: %s", r.serviceName, err)
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-r.after(r.retryInterval):
+			}
+
+			continue
+		}
+
+		// per the Consul documentation, a raft index that moves backwards resets the blocking query
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		w.update(consulEndpointsWithMetadata(entries))
+	}
+}
+
+// update changes this watch's endpoints, signalling an event if they are different.  A change to the
+// metadata of an endpoint, such as its weight, is an update just like a change to the endpoints themselves.
+func (w *consulWatch) update(endpoints []Endpoint) {
+	w.lock.Lock()
+	changed := !reflect.DeepEqual(w.endpoints, endpoints)
+	w.endpoints = endpoints
+	w.values = EndpointValues(endpoints)
+	w.lock.Unlock()
+
+	if changed {
+		w.signal()
+	}
+}
+
+func (w *consulWatch) signal() {
+	select {
+	case w.event <- struct{}{}:
+	default:
+	}
+}
+
+// close marks this watch as closed, stops its queries, and wakes up any goroutine waiting on it
+func (w *consulWatch) close() {
+	if atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		w.cancel()
+		w.signal()
+	}
+}
+
+func (w *consulWatch) Close() {
+	w.registrar.lock.Lock()
+	delete(w.registrar.watches, w)
+	w.registrar.lock.Unlock()
+	w.close()
+}
+
+func (w *consulWatch) IsClosed() bool {
+	return atomic.LoadInt32(&w.closed) != 0
+}
+
+func (w *consulWatch) Event() <-chan struct{} {
+	return w.event
+}
+
+func (w *consulWatch) Endpoints() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.values
+}
+
+func (w *consulWatch) EndpointsWithMetadata() []Endpoint {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.endpoints
+}
+
+func (w *consulWatch) String() string {
+	return "consulWatch(" + w.registrar.serviceName + ")"
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestConsulOptionsDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, co := range []*ConsulOptions{nil, new(ConsulOptions)} {
+		t.Log(co)
+
+		assert.Equal(api.DefaultConfig().Address, co.config().Address)
+		assert.Equal(DefaultCheckTTL, co.checkTTL())
+		assert.Equal(DefaultDeregisterAfter, co.deregisterAfter())
+		assert.Equal(DefaultWatchWaitTime, co.waitTime())
+		assert.Equal(DefaultWatchRetryInterval, co.retryInterval())
+	}
+}
+
+func TestConsulOptions(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		co     = &ConsulOptions{
+			Address:         "consul.comcast.net:8501",
+			Scheme:          "https",
+			Datacenter:      "east",
+			Token:           "secret",
+			CheckTTL:        30 * time.Second,
+			DeregisterAfter: 10 * time.Minute,
+			WaitTime:        time.Minute,
+			RetryInterval:   time.Second,
+		}
+
+		config = co.config()
+	)
+
+	assert.Equal("consul.comcast.net:8501", config.Address)
+	assert.Equal("https", config.Scheme)
+	assert.Equal("east", config.Datacenter)
+	assert.Equal("secret", config.Token)
+	assert.Equal(30*time.Second, co.checkTTL())
+	assert.Equal(10*time.Minute, co.deregisterAfter())
+	assert.Equal(time.Minute, co.waitTime())
+	assert.Equal(time.Second, co.retryInterval())
+}
+
+func TestOptionsBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options), {Backend: "unrecognized"}, {Backend: ZookeeperBackend}} {
+		assert.Equal(ZookeeperBackend, o.backend())
+	}
+
+	assert.Equal(ConsulBackend, (&Options{Backend: "Consul"}).backend())
+	assert.Nil((*Options)(nil).consul())
+}
+
+func TestNewRegistrarConsul(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		registrar = NewRegistrar(&Options{Backend: ConsulBackend, ServiceName: "talaria", Environment: "prod"})
+	)
+
+	if consulRegistrar, ok := registrar.(*ConsulRegistrar); assert.True(ok) {
+		assert.NoError(consulRegistrar.clientError)
+		assert.NotNil(consulRegistrar.client)
+		assert.Equal("talaria", consulRegistrar.serviceName)
+		assert.Equal("prod", consulRegistrar.tag)
+	}
+
+	_, ok := registrar.(MetadataRegistrar)
+	assert.True(ok)
+}
+
+// newTestConsulRegistrar creates a ConsulRegistrar whose timers are driven by the returned channel
+func newTestConsulRegistrar(client consulClient) (*ConsulRegistrar, chan time.Time) {
+	var (
+		timer     = make(chan time.Time)
+		registrar = newConsulRegistrar(&Options{ServiceName: "talaria", Environment: "prod"}, client, nil)
+	)
+
+	registrar.after = func(time.Duration) <-chan time.Time { return timer }
+	return registrar, timer
+}
+
+func TestConsulRegistrarRegisterEndpoint(t *testing.T) {
+	var (
+		assert             = assert.New(t)
+		require            = require.New(t)
+		client             = new(mockConsulClient)
+		registrar, timer   = newTestConsulRegistrar(client)
+		pingError          = errors.New("expected ping error")
+		pingResults        = make(chan error, 2)
+		heartbeats         = make(chan string, 3)
+		actualRegistration *api.AgentServiceRegistration
+		expectedServiceID  = "talaria-talaria.comcast.net-8080"
+		expectedCheckID    = "service:" + expectedServiceID
+		expectedMetadata   = map[string]string{"region": "east", SchemeMetadataKey: "https"}
+		recordHeartbeat    = func(arguments mock.Arguments) { heartbeats <- arguments.String(2) }
+		waitForHeartbeat   = func(expected string) {
+			select {
+			case actual := <-heartbeats:
+				assert.Equal(expected, actual)
+			case <-time.After(5 * time.Second):
+				require.Fail("No heartbeat was sent")
+			}
+		}
+	)
+
+	client.On("ServiceRegister", mock.AnythingOfType("*api.AgentServiceRegistration")).
+		Return(nil).
+		Once().
+		Run(func(arguments mock.Arguments) {
+			actualRegistration = arguments.Get(0).(*api.AgentServiceRegistration)
+		})
+
+	client.On("UpdateTTL", expectedCheckID, "", api.HealthPassing).Return(nil).Run(recordHeartbeat)
+	client.On("UpdateTTL", expectedCheckID, pingError.Error(), api.HealthCritical).Return(nil).Once().Run(recordHeartbeat)
+	client.On("ServiceDeregister", expectedServiceID).Return(nil).Once()
+
+	pingResults <- nil
+	pingResults <- pingError
+	ping := func() error {
+		select {
+		case err := <-pingResults:
+			return err
+		default:
+			return nil
+		}
+	}
+
+	endpoint, err := registrar.RegisterEndpointWithMetadata("https://talaria.comcast.net", 8080, ping, map[string]string{"region": "east"})
+	assert.Nil(endpoint)
+	require.NoError(err)
+	require.NotNil(actualRegistration)
+
+	assert.Equal(expectedServiceID, actualRegistration.ID)
+	assert.Equal("talaria", actualRegistration.Name)
+	assert.Equal([]string{"prod"}, actualRegistration.Tags)
+	assert.Equal("talaria.comcast.net", actualRegistration.Address)
+	assert.Equal(8080, actualRegistration.Port)
+	assert.Equal(expectedMetadata, actualRegistration.Meta)
+	if assert.NotNil(actualRegistration.Check) {
+		assert.Equal(expectedCheckID, actualRegistration.Check.CheckID)
+		assert.Equal(DefaultCheckTTL.String(), actualRegistration.Check.TTL)
+		assert.Equal(DefaultDeregisterAfter.String(), actualRegistration.Check.DeregisterCriticalServiceAfter)
+	}
+
+	waitForHeartbeat(api.HealthPassing)
+	timer <- time.Now()
+	waitForHeartbeat(api.HealthCritical)
+	timer <- time.Now()
+	waitForHeartbeat(api.HealthPassing)
+
+	registrar.Stop()
+	registrar.Stop()
+
+	_, err = registrar.RegisterEndpoint("talaria.comcast.net", 8080, nil)
+	assert.Equal(ErrorStopped, err)
+	client.AssertExpectations(t)
+}
+
+func TestConsulRegistrarRegisterEndpointError(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		client           = new(mockConsulClient)
+		registrar, _     = newTestConsulRegistrar(client)
+		expectedError    = errors.New("expected")
+		actualServiceIDs []string
+	)
+
+	client.On("ServiceRegister", mock.AnythingOfType("*api.AgentServiceRegistration")).
+		Return(expectedError).
+		Once().
+		Run(func(arguments mock.Arguments) {
+			actualServiceIDs = append(actualServiceIDs, arguments.Get(0).(*api.AgentServiceRegistration).ID)
+		})
+
+	endpoint, err := registrar.RegisterEndpoint("talaria.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(expectedError, err)
+	assert.Equal([]string{"talaria-talaria.comcast.net-8080"}, actualServiceIDs)
+
+	// nothing was registered, so there is nothing to deregister
+	registrar.Stop()
+	client.AssertExpectations(t)
+}
+
+func TestConsulRegistrarClientError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		registrar     = newConsulRegistrar(nil, nil, expectedError)
+	)
+
+	endpoint, err := registrar.RegisterEndpoint("talaria.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(expectedError, err)
+
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(expectedError, err)
+}
+
+func TestConsulEndpoints(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		endpoints = consulEndpointsWithMetadata([]*api.ServiceEntry{
+			{Service: &api.AgentService{Address: "talaria-2.comcast.net", Port: 80}},
+			{Service: &api.AgentService{Address: "::1", Port: 8443, Meta: map[string]string{SchemeMetadataKey: "https"}}},
+			{Node: &api.Node{Address: "10.0.0.1"}, Service: &api.AgentService{Port: 8080}},
+			{Node: &api.Node{Address: "10.0.0.2"}},
+		})
+	)
+
+	assert.Empty(consulEndpointsWithMetadata(nil))
+	assert.Equal(
+		[]string{
+			"http://10.0.0.1:8080",
+			"http://talaria-2.comcast.net:80",
+			"https://[::1]:8443",
+		},
+		EndpointValues(endpoints),
+	)
+
+	assert.Equal(map[string]string{SchemeMetadataKey: "https"}, endpoints[2].Metadata)
+
+	// every endpoint must be usable by an Accessor
+	_, baseURLs := NewAccessorFactory(nil).New(EndpointValues(endpoints))
+	assert.Equal(EndpointValues(endpoints), baseURLs)
+}
+
+func TestConsulRegistrarWatch(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		client           = new(mockConsulClient)
+		registrar, timer = newTestConsulRegistrar(client)
+		queries          = make(chan *api.QueryOptions, 10)
+		initialEntries   = []*api.ServiceEntry{{Service: &api.AgentService{Address: "talaria-1.comcast.net", Port: 8080}}}
+		updatedEntries   = []*api.ServiceEntry{
+			{Service: &api.AgentService{Address: "talaria-1.comcast.net", Port: 8080}},
+			{Service: &api.AgentService{Address: "talaria-2.comcast.net", Port: 8080}},
+		}
+
+		recordQuery = func(arguments mock.Arguments) {
+			queries <- arguments.Get(3).(*api.QueryOptions)
+		}
+
+		waitForEvent = func(watch Watch) {
+			select {
+			case <-watch.Event():
+			case <-time.After(5 * time.Second):
+				require.Fail("No watch event was signalled")
+			}
+		}
+	)
+
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(initialEntries, &api.QueryMeta{LastIndex: 10}, nil).
+		Once()
+
+	// the first blocking query times out with no changes
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(initialEntries, &api.QueryMeta{LastIndex: 10}, nil).
+		Once().
+		Run(recordQuery)
+
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(updatedEntries, &api.QueryMeta{LastIndex: 12}, nil).
+		Once().
+		Run(recordQuery)
+
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(nil, nil, errors.New("expected")).
+		Once().
+		Run(recordQuery)
+
+	// after the retry, the raft index has moved backwards
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(initialEntries, &api.QueryMeta{LastIndex: 3}, nil).
+		Once().
+		Run(recordQuery)
+
+	// the final query blocks until the watch is closed
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(nil, nil, errors.New("cancelled")).
+		Once().
+		Run(func(arguments mock.Arguments) {
+			q := arguments.Get(3).(*api.QueryOptions)
+			queries <- q
+			<-q.Context().Done()
+		})
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	require.NotNil(watch)
+	assert.False(watch.IsClosed())
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080"}, watch.Endpoints())
+
+	for _, expectedIndex := range []uint64{10, 10} {
+		q := <-queries
+		assert.Equal(expectedIndex, q.WaitIndex)
+		assert.Equal(DefaultWatchWaitTime, q.WaitTime)
+	}
+
+	waitForEvent(watch)
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080", "http://talaria-2.comcast.net:8080"}, watch.Endpoints())
+
+	assert.Equal(uint64(12), (<-queries).WaitIndex)
+	timer <- time.Now()
+	assert.Equal(uint64(12), (<-queries).WaitIndex)
+
+	waitForEvent(watch)
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080"}, watch.Endpoints())
+	assert.Equal(uint64(0), (<-queries).WaitIndex)
+
+	registrar.Stop()
+	waitForEvent(watch)
+	assert.True(watch.IsClosed())
+
+	watch, err = registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(ErrorStopped, err)
+	client.AssertExpectations(t)
+}
+
+func TestConsulRegistrarWatchError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		client        = new(mockConsulClient)
+		registrar, _  = newTestConsulRegistrar(client)
+		expectedError = errors.New("expected")
+	)
+
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(nil, nil, expectedError).
+		Once()
+
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(expectedError, err)
+	client.AssertExpectations(t)
+}
+
+func TestConsulWatchClose(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		client       = new(mockConsulClient)
+		registrar, _ = newTestConsulRegistrar(client)
+		blocked      = make(chan struct{})
+		unblocked    = make(chan struct{})
+	)
+
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(nil, &api.QueryMeta{LastIndex: 1}, nil).
+		Once()
+
+	client.On("Service", "talaria", "prod", true, mock.AnythingOfType("*api.QueryOptions")).
+		Return(nil, nil, errors.New("cancelled")).
+		Once().
+		Run(func(arguments mock.Arguments) {
+			close(blocked)
+			<-arguments.Get(3).(*api.QueryOptions).Context().Done()
+			close(unblocked)
+		})
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	assert.Empty(watch.Endpoints())
+
+	<-blocked
+	watch.Close()
+	watch.Close()
+	assert.True(watch.IsClosed())
+
+	select {
+	case <-unblocked:
+	case <-time.After(5 * time.Second):
+		require.Fail("Closing the watch did not cancel its query")
+	}
+
+	// a closed watch is no longer tracked by the registrar
+	registrar.lock.Lock()
+	assert.Empty(registrar.watches)
+	registrar.lock.Unlock()
+	client.AssertExpectations(t)
+}
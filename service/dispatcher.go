@@ -0,0 +1,160 @@
+package service
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultDispatchAttempts is the number of distinct endpoints a Dispatcher tries when its Attempts is unset
+	DefaultDispatchAttempts = 3
+
+	// dispatchProbes bounds the number of alternate keys hashed, per attempt, to find distinct endpoints.
+	// Without a bound, a service with fewer endpoints than attempts would never stop probing.
+	dispatchProbes = 8
+)
+
+// Dispatcher makes calls against the endpoints an Accessor selects for a key, retrying the next endpoint
+// when a call fails and, optionally, hedging a slow call with a second one.  This replaces ad hoc retry
+// loops over Accessor.Get.
+//
+// The first endpoint is always the one Accessor.Get returns for the key, so a Dispatcher routes exactly
+// as the Accessor does while that endpoint is healthy.  The alternates are found by hashing the key with
+// a suffix for each attempt, so a given key always fails over to the same endpoints in the same order.
+type Dispatcher struct {
+	// Accessor selects the endpoints for each key.  This field is required.
+	Accessor Accessor
+
+	// Attempts is the maximum number of distinct endpoints tried for a single Dispatch.  If unset,
+	// DefaultDispatchAttempts is used.  Fewer endpoints are tried if the Accessor does not have that many.
+	Attempts int
+
+	// HedgeDelay is how long to wait on a call before starting another call, against the next endpoint,
+	// in parallel.  The first call to succeed wins.  If unset, calls are strictly sequential.
+	HedgeDelay time.Duration
+
+	// ShouldRetry determines whether a failed call is tried against the next endpoint.  If nil,
+	// every error is retried.
+	ShouldRetry func(error) bool
+
+	after func(time.Duration) <-chan time.Time
+}
+
+func (d *Dispatcher) attempts() int {
+	if d.Attempts > 0 {
+		return d.Attempts
+	}
+
+	return DefaultDispatchAttempts
+}
+
+func (d *Dispatcher) shouldRetry(err error) bool {
+	if d.ShouldRetry != nil {
+		return d.ShouldRetry(err)
+	}
+
+	return true
+}
+
+func (d *Dispatcher) hedge() <-chan time.Time {
+	if d.HedgeDelay <= 0 {
+		return nil
+	}
+
+	if d.after != nil {
+		return d.after(d.HedgeDelay)
+	}
+
+	return time.After(d.HedgeDelay)
+}
+
+// endpoints returns the distinct endpoints to try for a key, in order.  Any error from the Accessor
+// for the key itself is returned, while errors for the alternate keys simply end the search.
+func (d *Dispatcher) endpoints(key []byte) ([]string, error) {
+	first, err := d.Accessor.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		attempts  = d.attempts()
+		endpoints = append(make([]string, 0, attempts), first)
+		tried     = map[string]bool{first: true}
+		alternate = make([]byte, 0, len(key)+4)
+	)
+
+	for probe := 1; len(endpoints) < attempts && probe < attempts*dispatchProbes; probe++ {
+		alternate = strconv.AppendInt(append(append(alternate[:0], key...), '#'), int64(probe), 10)
+		endpoint, err := d.Accessor.Get(alternate)
+		if err != nil {
+			break
+		}
+
+		if !tried[endpoint] {
+			tried[endpoint] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// Dispatch invokes call with the endpoints selected for the given key until a call succeeds, a call fails
+// with an error that ShouldRetry rejects, or the attempts are exhausted.  The endpoints are base URLs, as
+// returned by the Accessor, and ReplaceHostPort can be used to produce a request URL for each one.
+//
+// This method returns nil as soon as any call succeeds.  Otherwise, it returns the error from the last
+// call to fail, or the Accessor's error if no endpoint could be selected.  When hedging, calls still in
+// flight once Dispatch returns are left to finish, and their results are discarded.  So, call must be safe
+// to run concurrently whenever HedgeDelay is set.
+func (d *Dispatcher) Dispatch(key []byte, call func(endpoint string) error) error {
+	endpoints, err := d.endpoints(key)
+	if err != nil {
+		return err
+	}
+
+	var (
+		results  = make(chan error, len(endpoints))
+		next     int
+		inFlight int
+		hedge    <-chan time.Time
+	)
+
+	launch := func() {
+		endpoint := endpoints[next]
+		next++
+		inFlight++
+		go func() {
+			results <- call(endpoint)
+		}()
+
+		hedge = nil
+		if next < len(endpoints) {
+			hedge = d.hedge()
+		}
+	}
+
+	launch()
+	for {
+		select {
+		case err = <-results:
+			inFlight--
+			if err == nil {
+				return nil
+			}
+
+			if !d.shouldRetry(err) {
+				return err
+			}
+
+			if next < len(endpoints) {
+				launch()
+			} else if inFlight == 0 {
+				return err
+			}
+
+		case <-hedge:
+			launch()
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDispatchAccessor returns an accessor that maps a key and its first two alternates to distinct endpoints
+func newTestDispatchAccessor() *mockAccessor {
+	accessor := new(mockAccessor)
+	accessor.On("Get", []byte("key")).Return("http://first.com:8080", nil)
+	accessor.On("Get", []byte("key#1")).Return("http://second.com:8080", nil)
+	accessor.On("Get", []byte("key#2")).Return("http://third.com:8080", nil)
+	return accessor
+}
+
+// dispatchRecorder records the endpoints passed to a call, in order
+type dispatchRecorder struct {
+	lock      sync.Mutex
+	endpoints []string
+}
+
+func (r *dispatchRecorder) call(results map[string]error) func(string) error {
+	return func(endpoint string) error {
+		r.lock.Lock()
+		r.endpoints = append(r.endpoints, endpoint)
+		r.lock.Unlock()
+		return results[endpoint]
+	}
+}
+
+func (r *dispatchRecorder) called() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.endpoints...)
+}
+
+func testDispatcherAccessorError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		accessor      = new(mockAccessor)
+		expectedError = errors.New("expected")
+		recorder      = new(dispatchRecorder)
+		dispatcher    = &Dispatcher{Accessor: accessor}
+	)
+
+	accessor.On("Get", []byte("key")).Return("", expectedError).Once()
+	assert.Equal(expectedError, dispatcher.Dispatch([]byte("key"), recorder.call(nil)))
+	assert.Empty(recorder.called())
+	accessor.AssertExpectations(t)
+}
+
+func testDispatcherFirstSucceeds(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		recorder   = new(dispatchRecorder)
+		dispatcher = &Dispatcher{Accessor: newTestDispatchAccessor()}
+	)
+
+	assert.NoError(dispatcher.Dispatch([]byte("key"), recorder.call(nil)))
+	assert.Equal([]string{"http://first.com:8080"}, recorder.called())
+}
+
+func testDispatcherRetry(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		recorder   = new(dispatchRecorder)
+		dispatcher = &Dispatcher{Accessor: newTestDispatchAccessor()}
+	)
+
+	assert.NoError(dispatcher.Dispatch([]byte("key"), recorder.call(map[string]error{"http://first.com:8080": errors.New("first")})))
+	assert.Equal([]string{"http://first.com:8080", "http://second.com:8080"}, recorder.called())
+}
+
+func testDispatcherExhausted(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		recorder   = new(dispatchRecorder)
+		lastError  = errors.New("third")
+		dispatcher = &Dispatcher{Accessor: newTestDispatchAccessor()}
+	)
+
+	err := dispatcher.Dispatch([]byte("key"), recorder.call(map[string]error{
+		"http://first.com:8080":  errors.New("first"),
+		"http://second.com:8080": errors.New("second"),
+		"http://third.com:8080":  lastError,
+	}))
+
+	assert.Equal(lastError, err)
+	assert.Equal([]string{"http://first.com:8080", "http://second.com:8080", "http://third.com:8080"}, recorder.called())
+}
+
+func testDispatcherAttempts(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		recorder   = new(dispatchRecorder)
+		dispatcher = &Dispatcher{Accessor: newTestDispatchAccessor(), Attempts: 2}
+		expected   = errors.New("second")
+	)
+
+	err := dispatcher.Dispatch([]byte("key"), recorder.call(map[string]error{
+		"http://first.com:8080":  errors.New("first"),
+		"http://second.com:8080": expected,
+	}))
+
+	assert.Equal(expected, err)
+	assert.Equal([]string{"http://first.com:8080", "http://second.com:8080"}, recorder.called())
+}
+
+func testDispatcherSingleEndpoint(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		accessor   = new(mockAccessor)
+		recorder   = new(dispatchRecorder)
+		expected   = errors.New("expected")
+		dispatcher = &Dispatcher{Accessor: accessor}
+	)
+
+	// every alternate hashes to the same endpoint, so probing gives up without another attempt
+	accessor.On("Get", mock.AnythingOfType("[]uint8")).Return("http://only.com:8080", nil)
+	assert.Equal(expected, dispatcher.Dispatch([]byte("key"), recorder.call(map[string]error{"http://only.com:8080": expected})))
+	assert.Equal([]string{"http://only.com:8080"}, recorder.called())
+	accessor.AssertNumberOfCalls(t, "Get", DefaultDispatchAttempts*dispatchProbes)
+}
+
+func testDispatcherAlternateError(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		accessor   = new(mockAccessor)
+		recorder   = new(dispatchRecorder)
+		expected   = errors.New("expected")
+		dispatcher = &Dispatcher{Accessor: accessor}
+	)
+
+	accessor.On("Get", []byte("key")).Return("http://first.com:8080", nil).Once()
+	accessor.On("Get", []byte("key#1")).Return("", errors.New("no alternate")).Once()
+	assert.Equal(expected, dispatcher.Dispatch([]byte("key"), recorder.call(map[string]error{"http://first.com:8080": expected})))
+	assert.Equal([]string{"http://first.com:8080"}, recorder.called())
+	accessor.AssertExpectations(t)
+}
+
+func testDispatcherShouldRetry(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		recorder   = new(dispatchRecorder)
+		fatal      = errors.New("fatal")
+		dispatcher = &Dispatcher{
+			Accessor:    newTestDispatchAccessor(),
+			ShouldRetry: func(err error) bool { return err != fatal },
+		}
+	)
+
+	err := dispatcher.Dispatch([]byte("key"), recorder.call(map[string]error{
+		"http://first.com:8080":  errors.New("retryable"),
+		"http://second.com:8080": fatal,
+	}))
+
+	assert.Equal(fatal, err)
+	assert.Equal([]string{"http://first.com:8080", "http://second.com:8080"}, recorder.called())
+}
+
+func testDispatcherHedge(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		hedges   = make(chan time.Duration, 3)
+		fire     = make(chan time.Time)
+		started  = make(chan string, 3)
+		release  = make(chan struct{})
+		finished = make(chan struct{})
+
+		dispatcher = &Dispatcher{
+			Accessor:   newTestDispatchAccessor(),
+			HedgeDelay: 100 * time.Millisecond,
+			after: func(d time.Duration) <-chan time.Time {
+				hedges <- d
+				return fire
+			},
+		}
+
+		result = make(chan error, 1)
+	)
+
+	defer close(release)
+	go func() {
+		result <- dispatcher.Dispatch([]byte("key"), func(endpoint string) error {
+			started <- endpoint
+			if endpoint == "http://first.com:8080" {
+				// the first endpoint is slow, and is hedged
+				<-release
+				close(finished)
+			}
+
+			return nil
+		})
+	}()
+
+	require.Equal("http://first.com:8080", <-started)
+	assert.Equal(100*time.Millisecond, <-hedges)
+
+	fire <- time.Now()
+	select {
+	case endpoint := <-started:
+		assert.Equal("http://second.com:8080", endpoint)
+	case <-time.After(5 * time.Second):
+		require.Fail("The hedged call was not made")
+	}
+
+	select {
+	case err := <-result:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		require.Fail("Dispatch did not return")
+	}
+
+	// the slow call is still in flight, and no third call was made
+	select {
+	case <-finished:
+		assert.Fail("The slow call should still be in flight")
+	default:
+	}
+
+	assert.Empty(started)
+}
+
+func testDispatcherHedgeAfterFailure(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		recorder   = new(dispatchRecorder)
+		hedges     = make(chan time.Duration, 3)
+		lastError  = errors.New("third")
+		dispatcher = &Dispatcher{
+			Accessor:   newTestDispatchAccessor(),
+			HedgeDelay: time.Hour,
+			after: func(d time.Duration) <-chan time.Time {
+				hedges <- d
+				return nil
+			},
+		}
+	)
+
+	// failures move on to the next endpoint without waiting for the hedge delay
+	err := dispatcher.Dispatch([]byte("key"), recorder.call(map[string]error{
+		"http://first.com:8080":  errors.New("first"),
+		"http://second.com:8080": errors.New("second"),
+		"http://third.com:8080":  lastError,
+	}))
+
+	assert.Equal(lastError, err)
+	assert.Equal([]string{"http://first.com:8080", "http://second.com:8080", "http://third.com:8080"}, recorder.called())
+
+	// the last endpoint is never hedged
+	assert.Len(hedges, 2)
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("AccessorError", testDispatcherAccessorError)
+	t.Run("FirstSucceeds", testDispatcherFirstSucceeds)
+	t.Run("Retry", testDispatcherRetry)
+	t.Run("Exhausted", testDispatcherExhausted)
+	t.Run("Attempts", testDispatcherAttempts)
+	t.Run("SingleEndpoint", testDispatcherSingleEndpoint)
+	t.Run("AlternateError", testDispatcherAlternateError)
+	t.Run("ShouldRetry", testDispatcherShouldRetry)
+	t.Run("Hedge", testDispatcherHedge)
+	t.Run("HedgeAfterFailure", testDispatcherHedgeAfterFailure)
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/strava/go.serversets"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DNSBackend selects DNS SRV records for watches.  Registrations are managed outside this process,
+	// e.g. by Kubernetes or Route53.
+	DNSBackend = "dns"
+
+	DefaultDNSProto        = "tcp"
+	DefaultDNSPollInterval = 30 * time.Second
+)
+
+// DNSOptions configures the DNS backend.  Endpoints are discovered by looking up the SRV records
+// for _Service._Proto.Name.  A Service or Proto of "-" leaves that label out, so setting both to "-"
+// looks up the SRV records of Name directly.
+type DNSOptions struct {
+	// Service is the SRV service label, without the leading underscore.  If unset, Options.ServiceName is used.
+	Service string `json:"service,omitempty"`
+
+	// Proto is the SRV protocol label, without the leading underscore.  If unset, DefaultDNSProto is used.
+	Proto string `json:"proto,omitempty"`
+
+	// Name is the domain that owns the SRV records, e.g. "talaria.default.svc.cluster.local".
+	Name string `json:"name,omitempty"`
+
+	// Scheme is the scheme of each discovered endpoint.  If unset, DefaultScheme is used.
+	Scheme string `json:"scheme,omitempty"`
+
+	// PollInterval is how often SRV records are looked up by a watch.  If not positive,
+	// DefaultDNSPollInterval is used.
+	PollInterval time.Duration `json:"pollInterval"`
+}
+
+func (do *DNSOptions) service(o *Options) string {
+	if do != nil && len(do.Service) > 0 {
+		if do.Service == "-" {
+			return ""
+		}
+
+		return do.Service
+	}
+
+	return o.serviceName()
+}
+
+func (do *DNSOptions) proto() string {
+	if do != nil && len(do.Proto) > 0 {
+		if do.Proto == "-" {
+			return ""
+		}
+
+		return do.Proto
+	}
+
+	return DefaultDNSProto
+}
+
+func (do *DNSOptions) name() string {
+	if do != nil {
+		return do.Name
+	}
+
+	return ""
+}
+
+func (do *DNSOptions) scheme() string {
+	if do != nil && len(do.Scheme) > 0 {
+		return do.Scheme
+	}
+
+	return DefaultScheme
+}
+
+func (do *DNSOptions) pollInterval() time.Duration {
+	if do != nil && do.PollInterval > 0 {
+		return do.PollInterval
+	}
+
+	return DefaultDNSPollInterval
+}
+
+// srvResolver is the subset of *net.Resolver used by DNSRegistrar.  It can be mocked for testing.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSRegistrar is a Registrar backed by DNS SRV records.  Each watch polls the records at a fixed interval,
+// signalling an event whenever the set of endpoints changes.  DNS is not updated by this process, so
+// RegisterEndpoint does nothing and always returns a nil endpoint.
+//
+// Failed lookups are logged, and the watch keeps its last known endpoints until a lookup succeeds.
+// Secondary Zookeeper ensembles, configured via Options.Failover, do not apply to this registrar.
+type DNSRegistrar struct {
+	logger       logging.Logger
+	resolver     srvResolver
+	service      string
+	proto        string
+	name         string
+	scheme       string
+	pollInterval time.Duration
+	after        func(time.Duration) <-chan time.Time
+
+	lock    sync.Mutex
+	watches map[*dnsWatch]bool
+	stopped bool
+}
+
+// NewDNSRegistrar creates a DNSRegistrar from a set of options.  No lookups are made by this function.
+func NewDNSRegistrar(o *Options) *DNSRegistrar {
+	return newDNSRegistrar(o, net.DefaultResolver)
+}
+
+func newDNSRegistrar(o *Options, resolver srvResolver) *DNSRegistrar {
+	dns := o.dns()
+	return &DNSRegistrar{
+		logger:       o.logger(),
+		resolver:     resolver,
+		service:      dns.service(o),
+		proto:        dns.proto(),
+		name:         dns.name(),
+		scheme:       dns.scheme(),
+		pollInterval: dns.pollInterval(),
+		after:        time.After,
+		watches:      make(map[*dnsWatch]bool),
+	}
+}
+
+// RegisterEndpoint does nothing, as SRV records are maintained outside this process.  The registration
+// is simply logged.
+func (r *DNSRegistrar) RegisterEndpoint(host string, port int, ping func() error) (*serversets.Endpoint, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return nil, ErrorStopped
+	}
+
+	r.logger.Info("Endpoint %s:%d is expected to be published in DNS by an external system", host, port)
+	return nil, nil
+}
+
+// lookup queries the SRV records and produces the sorted, distinct endpoints
+func (r *DNSRegistrar) lookup(ctx context.Context) ([]Endpoint, error) {
+	_, records, err := r.resolver.LookupSRV(ctx, r.service, r.proto, r.name)
+	if err != nil {
+		return nil, err
+	}
+
+	return dnsEndpointsWithMetadata(r.scheme, records), nil
+}
+
+func (r *DNSRegistrar) Watch() (Watch, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return nil, ErrorStopped
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// the first lookup is made synchronously, so that configuration problems are reported immediately
+	endpoints, err := r.lookup(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	w := &dnsWatch{
+		registrar: r,
+		ctx:       ctx,
+		cancel:    cancel,
+		event:     make(chan struct{}, 1),
+		endpoints: endpoints,
+		values:    EndpointValues(endpoints),
+	}
+
+	r.watches[w] = true
+	go w.run()
+	return w, nil
+}
+
+// Stop closes all watches.  Once stopped, a DNSRegistrar cannot be restarted.  This method is idempotent.
+func (r *DNSRegistrar) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	r.stopped = true
+	for w := range r.watches {
+		w.close()
+	}
+
+	r.watches = nil
+}
+
+// dnsEndpoints produces the sorted, distinct endpoint strings, of the form scheme://host:port accepted
+// by ParseHostPort, for a set of SRV records
+func dnsEndpoints(scheme string, records []*net.SRV) []string {
+	return EndpointValues(dnsEndpointsWithMetadata(scheme, records))
+}
+
+// dnsEndpointsWithMetadata produces the sorted, distinct Endpoints for a set of SRV records.  SRV weights
+// are only meaningful relative to one another, so they are scaled such that the heaviest record has
+// DefaultWeight.  If no record has a weight, the endpoints have no metadata and are weighted equally.
+// A target that appears in several records takes the largest of their weights.
+func dnsEndpointsWithMetadata(scheme string, records []*net.SRV) []Endpoint {
+	var (
+		values    = make([]string, 0, len(records))
+		weights   = make(map[string]uint16, len(records))
+		maxWeight uint16
+	)
+
+	for _, record := range records {
+		// SRV targets are fully qualified, and the trailing dot is not part of a usable URL
+		value := scheme + "://" + net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		if weight, ok := weights[value]; !ok {
+			values = append(values, value)
+			weights[value] = record.Weight
+		} else if record.Weight > weight {
+			weights[value] = record.Weight
+		}
+
+		if record.Weight > maxWeight {
+			maxWeight = record.Weight
+		}
+	}
+
+	sort.Strings(values)
+	endpoints := make([]Endpoint, len(values))
+	for i, value := range values {
+		endpoints[i].Value = value
+		if maxWeight > 0 {
+			weight := int(weights[value]) * DefaultWeight / int(maxWeight)
+			if weight == 0 && weights[value] > 0 {
+				// a record with any weight is never drained by scaling
+				weight = 1
+			}
+
+			endpoints[i].Metadata = map[string]string{WeightMetadataKey: strconv.Itoa(weight)}
+		}
+	}
+
+	return endpoints
+}
+
+// dnsWatch is the Watch implementation returned by DNSRegistrar
+type dnsWatch struct {
+	registrar *DNSRegistrar
+	ctx       context.Context
+	cancel    func()
+	event     chan struct{}
+	closed    int32
+
+	lock      sync.Mutex
+	endpoints []Endpoint
+	values    []string
+}
+
+// run is the goroutine which polls the SRV records until this watch is closed
+func (w *dnsWatch) run() {
+	r := w.registrar
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-r.after(r.pollInterval):
+		}
+
+		endpoints, err := r.lookup(w.ctx)
+		if w.IsClosed() {
+			return
+		}
+
+		if err != nil {
+			r.logger.Error("Unable to look up SRV records for %s: %s", r.name, err)
+			continue
+		}
+
+		w.update(endpoints)
+	}
+}
+
+// update changes this watch's endpoints, signalling an event if they are different
+func (w *dnsWatch) update(endpoints []Endpoint) {
+	w.lock.Lock()
+	changed := !reflect.DeepEqual(w.endpoints, endpoints)
+	w.endpoints = endpoints
+	w.values = EndpointValues(endpoints)
+	w.lock.Unlock()
+
+	if changed {
+		w.signal()
+	}
+}
+
+func (w *dnsWatch) signal() {
+	select {
+	case w.event <- struct{}{}:
+	default:
+	}
+}
+
+// close marks this watch as closed, stops its polling, and wakes up any goroutine waiting on it
+func (w *dnsWatch) close() {
+	if atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		w.cancel()
+		w.signal()
+	}
+}
+
+func (w *dnsWatch) Close() {
+	w.registrar.lock.Lock()
+	delete(w.registrar.watches, w)
+	w.registrar.lock.Unlock()
+	w.close()
+}
+
+func (w *dnsWatch) IsClosed() bool {
+	return atomic.LoadInt32(&w.closed) != 0
+}
+
+func (w *dnsWatch) Event() <-chan struct{} {
+	return w.event
+}
+
+func (w *dnsWatch) Endpoints() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.values
+}
+
+func (w *dnsWatch) EndpointsWithMetadata() []Endpoint {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.endpoints
+}
+
+func (w *dnsWatch) String() string {
+	return "dnsWatch(" + w.registrar.name + ")"
+}
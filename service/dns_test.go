@@ -0,0 +1,293 @@
+package service
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNSOptionsDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, do := range []*DNSOptions{nil, new(DNSOptions)} {
+		t.Log(do)
+
+		assert.Equal(DefaultServiceName, do.service(nil))
+		assert.Equal("talaria", do.service(&Options{ServiceName: "talaria"}))
+		assert.Equal(DefaultDNSProto, do.proto())
+		assert.Empty(do.name())
+		assert.Equal(DefaultScheme, do.scheme())
+		assert.Equal(DefaultDNSPollInterval, do.pollInterval())
+	}
+}
+
+func TestDNSOptions(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		do     = &DNSOptions{
+			Service:      "webpa",
+			Proto:        "udp",
+			Name:         "talaria.default.svc.cluster.local",
+			Scheme:       "https",
+			PollInterval: time.Minute,
+		}
+	)
+
+	assert.Equal("webpa", do.service(&Options{ServiceName: "talaria"}))
+	assert.Equal("udp", do.proto())
+	assert.Equal("talaria.default.svc.cluster.local", do.name())
+	assert.Equal("https", do.scheme())
+	assert.Equal(time.Minute, do.pollInterval())
+
+	direct := &DNSOptions{Service: "-", Proto: "-"}
+	assert.Empty(direct.service(&Options{ServiceName: "talaria"}))
+	assert.Empty(direct.proto())
+}
+
+func TestOptionsBackendDNS(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(DNSBackend, (&Options{Backend: DNSBackend}).backend())
+	assert.Equal(DNSBackend, (&Options{Backend: "DNS"}).backend())
+	assert.Nil((*Options)(nil).dns())
+}
+
+func TestNewRegistrarDNS(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		registrar = NewRegistrar(&Options{
+			Backend:     DNSBackend,
+			ServiceName: "talaria",
+			DNS:         &DNSOptions{Name: "talaria.default.svc.cluster.local"},
+		})
+	)
+
+	if dnsRegistrar, ok := registrar.(*DNSRegistrar); assert.True(ok) {
+		assert.Equal(net.DefaultResolver, dnsRegistrar.resolver)
+		assert.Equal("talaria", dnsRegistrar.service)
+		assert.Equal(DefaultDNSProto, dnsRegistrar.proto)
+		assert.Equal("talaria.default.svc.cluster.local", dnsRegistrar.name)
+	}
+}
+
+func TestDNSEndpoints(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		endpoints = dnsEndpoints("https", []*net.SRV{
+			{Target: "talaria-2.comcast.net.", Port: 8443, Priority: 10},
+			{Target: "talaria-1.comcast.net.", Port: 8080, Priority: 20},
+			{Target: "talaria-1.comcast.net", Port: 8080, Priority: 10},
+		})
+	)
+
+	assert.Empty(dnsEndpoints("http", nil))
+	assert.Equal([]string{"https://talaria-1.comcast.net:8080", "https://talaria-2.comcast.net:8443"}, endpoints)
+
+	// every endpoint must be usable by an Accessor
+	_, baseURLs := NewAccessorFactory(nil).New(endpoints)
+	assert.Equal(endpoints, baseURLs)
+}
+
+func TestDNSEndpointsWithMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	// without SRV weights, endpoints are weighted equally
+	assert.Equal(
+		[]Endpoint{{Value: "http://talaria-1.comcast.net:8080"}, {Value: "http://talaria-2.comcast.net:8080"}},
+		dnsEndpointsWithMetadata("http", []*net.SRV{
+			{Target: "talaria-2.comcast.net.", Port: 8080},
+			{Target: "talaria-1.comcast.net.", Port: 8080},
+		}),
+	)
+
+	// SRV weights are scaled relative to the heaviest record
+	assert.Equal(
+		[]Endpoint{
+			{Value: "http://talaria-1.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "100"}},
+			{Value: "http://talaria-2.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "25"}},
+			{Value: "http://talaria-3.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "0"}},
+			{Value: "http://talaria-4.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "1"}},
+		},
+		dnsEndpointsWithMetadata("http", []*net.SRV{
+			{Target: "talaria-2.comcast.net.", Port: 8080, Weight: 1000},
+			{Target: "talaria-1.comcast.net.", Port: 8080, Weight: 100},
+			{Target: "talaria-1.comcast.net.", Port: 8080, Weight: 4000},
+			{Target: "talaria-3.comcast.net.", Port: 8080},
+			{Target: "talaria-4.comcast.net.", Port: 8080, Weight: 1},
+		}),
+	)
+}
+
+// newTestDNSRegistrar creates a DNSRegistrar whose polling is driven by the returned channel
+func newTestDNSRegistrar(resolver srvResolver) (*DNSRegistrar, chan time.Time) {
+	var (
+		timer     = make(chan time.Time)
+		registrar = newDNSRegistrar(
+			&Options{ServiceName: "talaria", DNS: &DNSOptions{Name: "comcast.net"}},
+			resolver,
+		)
+	)
+
+	registrar.after = func(time.Duration) <-chan time.Time { return timer }
+	return registrar, timer
+}
+
+func TestDNSRegistrarRegisterEndpoint(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		resolver     = new(mockSRVResolver)
+		registrar, _ = newTestDNSRegistrar(resolver)
+	)
+
+	endpoint, err := registrar.RegisterEndpoint("http://talaria-1.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.NoError(err)
+
+	registrar.Stop()
+	registrar.Stop()
+	endpoint, err = registrar.RegisterEndpoint("http://talaria-1.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(ErrorStopped, err)
+	resolver.AssertExpectations(t)
+}
+
+func TestDNSRegistrarWatch(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		resolver         = new(mockSRVResolver)
+		registrar, timer = newTestDNSRegistrar(resolver)
+		lookups          = make(chan struct{}, 10)
+
+		initialRecords = []*net.SRV{{Target: "talaria-1.comcast.net.", Port: 8080}}
+		updatedRecords = []*net.SRV{
+			{Target: "talaria-2.comcast.net.", Port: 8080, Weight: 5},
+			{Target: "talaria-1.comcast.net.", Port: 8080, Weight: 10},
+		}
+
+		recordLookup = func(mock.Arguments) {
+			lookups <- struct{}{}
+		}
+
+		waitForEvent = func(watch Watch) {
+			select {
+			case <-watch.Event():
+			case <-time.After(5 * time.Second):
+				require.Fail("No watch event was signalled")
+			}
+		}
+	)
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", initialRecords, nil).
+		Once()
+
+	// the first poll finds no changes
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", initialRecords, nil).
+		Once().
+		Run(recordLookup)
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", updatedRecords, nil).
+		Once().
+		Run(recordLookup)
+
+	// a failed lookup keeps the last known endpoints
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", nil, errors.New("expected")).
+		Once().
+		Run(recordLookup)
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", initialRecords, nil).
+		Once().
+		Run(recordLookup)
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	require.NotNil(watch)
+	assert.False(watch.IsClosed())
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080"}, watch.Endpoints())
+
+	timer <- time.Now()
+	<-lookups
+	assert.Empty(watch.Event())
+
+	timer <- time.Now()
+	<-lookups
+	waitForEvent(watch)
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080", "http://talaria-2.comcast.net:8080"}, watch.Endpoints())
+	assert.Equal(
+		[]Endpoint{
+			{Value: "http://talaria-1.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "100"}},
+			{Value: "http://talaria-2.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "50"}},
+		},
+		WatchEndpoints(watch),
+	)
+
+	timer <- time.Now()
+	<-lookups
+	timer <- time.Now()
+	<-lookups
+	waitForEvent(watch)
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080"}, watch.Endpoints())
+
+	registrar.Stop()
+	waitForEvent(watch)
+	assert.True(watch.IsClosed())
+
+	watch, err = registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(ErrorStopped, err)
+	resolver.AssertExpectations(t)
+}
+
+func TestDNSRegistrarWatchError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		resolver      = new(mockSRVResolver)
+		registrar, _  = newTestDNSRegistrar(resolver)
+		expectedError = errors.New("expected")
+	)
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", nil, expectedError).
+		Once()
+
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(expectedError, err)
+	resolver.AssertExpectations(t)
+}
+
+func TestDNSWatchClose(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		resolver     = new(mockSRVResolver)
+		registrar, _ = newTestDNSRegistrar(resolver)
+	)
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", nil, nil).
+		Once()
+
+	watch, err := registrar.Watch()
+	assert.NoError(err)
+	assert.Empty(watch.Endpoints())
+	assert.Len(registrar.watches, 1)
+
+	watch.Close()
+	assert.True(watch.IsClosed())
+	assert.Empty(registrar.watches)
+	<-watch.Event()
+
+	// closing again does nothing
+	watch.Close()
+	assert.True(watch.IsClosed())
+	resolver.AssertExpectations(t)
+}
@@ -1,4 +1,4 @@
 /*
-Package service provides basic integration with go.serversets
+Package service provides basic integration with go.serversets, or alternatively Consul, etcd, or DNS SRV records
 */
 package service
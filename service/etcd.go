@@ -0,0 +1,610 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/strava/go.serversets"
+	"net"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// EtcdBackend selects etcd, via the v3 API, for registrations and watches
+	EtcdBackend = "etcd"
+
+	DefaultEtcdEndpoint = "localhost:2379"
+	DefaultLeaseTTL     = 15 * time.Second
+)
+
+// EtcdOptions configures the etcd backend.  The etcd cluster is given by the same Options.Connection
+// and Options.Servers used for Zookeeper, and Options.Timeout bounds both connecting and each request.
+type EtcdOptions struct {
+	// Username and Password are the optional credentials used to authenticate with etcd
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// LeaseTTL is the TTL of the lease attached to each registered endpoint.  The lease is refreshed at
+	// a third of this interval, so an endpoint disappears at most this long after this process dies.
+	// etcd leases have a granularity of one second.  If not positive, DefaultLeaseTTL is used.
+	LeaseTTL time.Duration `json:"leaseTTL"`
+
+	// RetryInterval is how long a watch waits before reestablishing itself after a failure.  If not positive,
+	// DefaultWatchRetryInterval is used.
+	RetryInterval time.Duration `json:"retryInterval"`
+}
+
+func (eo *EtcdOptions) leaseTTL() time.Duration {
+	if eo != nil && eo.LeaseTTL > 0 {
+		return eo.LeaseTTL
+	}
+
+	return DefaultLeaseTTL
+}
+
+func (eo *EtcdOptions) retryInterval() time.Duration {
+	if eo != nil && eo.RetryInterval > 0 {
+		return eo.RetryInterval
+	}
+
+	return DefaultWatchRetryInterval
+}
+
+// etcdConfig produces the etcd client configuration for a set of options
+func etcdConfig(o *Options) clientv3.Config {
+	config := clientv3.Config{
+		DialTimeout: o.timeout(),
+	}
+
+	if o != nil {
+		config.Endpoints = mergeServers(o.Connection, o.Servers)
+	}
+
+	if len(config.Endpoints) == 0 {
+		config.Endpoints = []string{DefaultEtcdEndpoint}
+	}
+
+	if eo := o.etcd(); eo != nil {
+		config.Username = eo.Username
+		config.Password = eo.Password
+	}
+
+	return config
+}
+
+// etcdClient is the subset of the etcd API used by EtcdRegistrar.  It can be mocked for testing.
+type etcdClient interface {
+	// Grant creates a lease with the given TTL in seconds
+	Grant(ctx context.Context, ttl int64) (int64, error)
+
+	// KeepAliveOnce refreshes a lease, returning an error if the lease has expired
+	KeepAliveOnce(ctx context.Context, lease int64) error
+
+	// Revoke deletes a lease along with the keys attached to it
+	Revoke(ctx context.Context, lease int64) error
+
+	// Put sets a key attached to a lease
+	Put(ctx context.Context, key, value string, lease int64) error
+
+	// List returns the values of all keys with the given prefix, along with the revision of the store
+	List(ctx context.Context, prefix string) ([]string, int64, error)
+
+	// Watch watches a prefix starting at the given revision.  The returned channel receives nil when any
+	// key with the prefix changes, or an error if the watch fails, and is closed once the watch ends.
+	Watch(ctx context.Context, prefix string, revision int64) <-chan error
+
+	Close() error
+}
+
+// etcdAPI adapts an etcd client to the etcdClient interface
+type etcdAPI struct {
+	client *clientv3.Client
+}
+
+func (e *etcdAPI) Grant(ctx context.Context, ttl int64) (int64, error) {
+	response, err := e.client.Grant(ctx, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(response.ID), nil
+}
+
+func (e *etcdAPI) KeepAliveOnce(ctx context.Context, lease int64) error {
+	_, err := e.client.KeepAliveOnce(ctx, clientv3.LeaseID(lease))
+	return err
+}
+
+func (e *etcdAPI) Revoke(ctx context.Context, lease int64) error {
+	_, err := e.client.Revoke(ctx, clientv3.LeaseID(lease))
+	return err
+}
+
+func (e *etcdAPI) Put(ctx context.Context, key, value string, lease int64) error {
+	_, err := e.client.Put(ctx, key, value, clientv3.WithLease(clientv3.LeaseID(lease)))
+	return err
+}
+
+func (e *etcdAPI) List(ctx context.Context, prefix string) ([]string, int64, error) {
+	response, err := e.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values := make([]string, len(response.Kvs))
+	for i, kv := range response.Kvs {
+		values[i] = string(kv.Value)
+	}
+
+	return values, response.Header.Revision, nil
+}
+
+func (e *etcdAPI) Watch(ctx context.Context, prefix string, revision int64) <-chan error {
+	changes := make(chan error, 1)
+	go func() {
+		defer close(changes)
+		for response := range e.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision)) {
+			err := response.Err()
+			select {
+			case changes <- err:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return changes
+}
+
+func (e *etcdAPI) Close() error {
+	return e.client.Close()
+}
+
+// etcdMember is the JSON value stored in etcd for each registered endpoint
+type etcdMember struct {
+	Value    string            `json:"value"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// etcdRegistration is an endpoint registered with etcd.  The heartbeat goroutine owns the lease, and
+// closes done once the lease has been revoked after shutdown.
+type etcdRegistration struct {
+	key      string
+	value    string
+	shutdown chan struct{}
+	done     chan struct{}
+}
+
+// EtcdRegistrar is a Registrar backed by etcd.  Each endpoint is stored under a key beneath
+// Options.BaseDirectory/Options.Environment/Options.ServiceName, named with Options.MemberPrefix, and is
+// attached to a lease kept alive by a heartbeat.  If the lease is lost, for example because etcd was
+// unreachable for longer than the lease TTL, the endpoint is registered again.  Watches report every
+// endpoint under the service's key prefix.
+//
+// etcd has no equivalent of *serversets.Endpoint, so the endpoints returned by RegisterEndpoint are always
+// nil.  Clients should call Stop to deregister endpoints.  Secondary Zookeeper ensembles, configured via
+// Options.Failover, do not apply to this registrar.
+type EtcdRegistrar struct {
+	logger        logging.Logger
+	client        etcdClient
+	clientError   error
+	prefix        string
+	memberPrefix  string
+	timeout       time.Duration
+	leaseTTL      time.Duration
+	retryInterval time.Duration
+	after         func(time.Duration) <-chan time.Time
+
+	lock          sync.Mutex
+	registrations map[string]*etcdRegistration
+	watches       map[*etcdWatch]bool
+	stopped       bool
+}
+
+// NewEtcdRegistrar creates an EtcdRegistrar from a set of options.  If the etcd client cannot be created,
+// for example because no etcd server could be reached within Options.Timeout, the error is returned from
+// each subsequent call to RegisterEndpoint and Watch.
+func NewEtcdRegistrar(o *Options) *EtcdRegistrar {
+	var (
+		client    etcdClient
+		etcd, err = clientv3.New(etcdConfig(o))
+	)
+
+	if err == nil {
+		client = &etcdAPI{client: etcd}
+	}
+
+	return newEtcdRegistrar(o, client, err)
+}
+
+func newEtcdRegistrar(o *Options, client etcdClient, clientError error) *EtcdRegistrar {
+	etcd := o.etcd()
+	return &EtcdRegistrar{
+		logger:        o.logger(),
+		client:        client,
+		clientError:   clientError,
+		prefix:        path.Join(o.baseDirectory(), string(o.environment()), o.serviceName()) + "/",
+		memberPrefix:  o.memberPrefix(),
+		timeout:       o.timeout(),
+		leaseTTL:      etcd.leaseTTL(),
+		retryInterval: etcd.retryInterval(),
+		after:         time.After,
+		registrations: make(map[string]*etcdRegistration),
+		watches:       make(map[*etcdWatch]bool),
+	}
+}
+
+// check returns the error, if any, that prevents this registrar from being used.  This method must
+// be called under the lock.
+func (r *EtcdRegistrar) check() error {
+	if r.clientError != nil {
+		return r.clientError
+	}
+
+	if r.stopped {
+		return ErrorStopped
+	}
+
+	return nil
+}
+
+// request returns the context used for a single etcd request, bounded by the configured timeout
+func (r *EtcdRegistrar) request(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, r.timeout)
+}
+
+// register grants a lease and attaches the given key to it, returning the lease
+func (r *EtcdRegistrar) register(key, value string) (int64, error) {
+	ttl := int64(r.leaseTTL / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	ctx, cancel := r.request(context.Background())
+	defer cancel()
+
+	lease, err := r.client.Grant(ctx, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := r.client.Put(ctx, key, value, lease); err != nil {
+		r.revoke(lease)
+		return 0, err
+	}
+
+	return lease, nil
+}
+
+// revoke revokes a lease, which deletes any key still attached to it
+func (r *EtcdRegistrar) revoke(lease int64) {
+	ctx, cancel := r.request(context.Background())
+	defer cancel()
+
+	if err := r.client.Revoke(ctx, lease); err != nil {
+		r.logger.Error("Unable to revoke etcd lease %x: %s", lease, err)
+	}
+}
+
+func (r *EtcdRegistrar) RegisterEndpoint(host string, port int, ping func() error) (*serversets.Endpoint, error) {
+	return r.RegisterEndpointWithMetadata(host, port, ping, nil)
+}
+
+// RegisterEndpointWithMetadata registers an endpoint with etcd, publishing the metadata alongside it.  The
+// host may be prefixed with a scheme, as produced by ParseRegistration.  Registering the same endpoint
+// again replaces the earlier registration.
+func (r *EtcdRegistrar) RegisterEndpointWithMetadata(host string, port int, ping func() error, metadata map[string]string) (*serversets.Endpoint, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+
+	scheme, address := DefaultScheme, host
+	if parts := strings.SplitN(host, "://", 2); len(parts) == 2 {
+		scheme, address = parts[0], parts[1]
+	}
+
+	value, err := json.Marshal(etcdMember{
+		Value:    scheme + "://" + net.JoinHostPort(address, strconv.Itoa(port)),
+		Metadata: metadata,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s%s%s-%d", r.prefix, r.memberPrefix, address, port)
+	if existing, ok := r.registrations[key]; ok {
+		// the earlier heartbeat must be finished with the key before it is attached to a new lease
+		close(existing.shutdown)
+		<-existing.done
+		delete(r.registrations, key)
+	}
+
+	lease, err := r.register(key, string(value))
+	if err != nil {
+		return nil, err
+	}
+
+	registration := &etcdRegistration{
+		key:      key,
+		value:    string(value),
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	r.registrations[key] = registration
+	go r.heartbeat(registration, lease, ping)
+	return nil, nil
+}
+
+// heartbeat is the goroutine which keeps an endpoint's lease alive.  If ping is supplied and returns an
+// error, the lease is revoked so that the endpoint is no longer watched, and the endpoint is registered
+// again once ping succeeds.  A lease that has expired is likewise replaced with a new registration.
+func (r *EtcdRegistrar) heartbeat(registration *etcdRegistration, lease int64, ping func() error) {
+	defer close(registration.done)
+	interval := r.leaseTTL / 3
+	for {
+		select {
+		case <-registration.shutdown:
+			if lease != 0 {
+				r.revoke(lease)
+			}
+
+			return
+		case <-r.after(interval):
+		}
+
+		if ping != nil {
+			if err := ping(); err != nil {
+				if lease != 0 {
+					r.logger.Error("Deregistering %s, as its ping failed: %s", registration.key, err)
+					r.revoke(lease)
+					lease = 0
+				}
+
+				continue
+			}
+		}
+
+		if lease != 0 {
+			ctx, cancel := r.request(context.Background())
+			err := r.client.KeepAliveOnce(ctx, lease)
+			cancel()
+
+			if err == nil {
+				continue
+			}
+
+			r.logger.Error("Unable to refresh etcd lease %x for %s: %s", lease, registration.key, err)
+			lease = 0
+		}
+
+		var err error
+		if lease, err = r.register(registration.key, registration.value); err != nil {
+			r.logger.Error("Unable to register %s: %s", registration.key, err)
+		} else {
+			r.logger.Info("Registered %s again", registration.key)
+		}
+	}
+}
+
+// list returns the endpoints currently registered under this registrar's prefix, along with the revision
+// of the store.  Values that cannot be parsed are logged and skipped.
+func (r *EtcdRegistrar) list(parent context.Context) ([]Endpoint, int64, error) {
+	ctx, cancel := r.request(parent)
+	defer cancel()
+
+	values, revision, err := r.client.List(ctx, r.prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	endpoints := make([]Endpoint, 0, len(values))
+	for _, value := range values {
+		var member etcdMember
+		if err := json.Unmarshal([]byte(value), &member); err != nil || len(member.Value) == 0 {
+			r.logger.Error("Ignoring invalid etcd registration under %s: %s", r.prefix, value)
+			continue
+		}
+
+		endpoints = append(endpoints, Endpoint{Value: member.Value, Metadata: member.Metadata})
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Value < endpoints[j].Value })
+	return endpoints, revision, nil
+}
+
+func (r *EtcdRegistrar) Watch() (Watch, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+
+	// the first list is made synchronously, so that configuration problems are reported immediately
+	endpoints, revision, err := r.list(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &etcdWatch{
+		registrar: r,
+		ctx:       ctx,
+		cancel:    cancel,
+		event:     make(chan struct{}, 1),
+		endpoints: endpoints,
+		values:    EndpointValues(endpoints),
+	}
+
+	r.watches[w] = true
+	go w.run(revision)
+	return w, nil
+}
+
+// Stop deregisters all endpoints, closes all watches, and closes the etcd client.  Once stopped, an
+// EtcdRegistrar cannot be restarted.  This method is idempotent.
+func (r *EtcdRegistrar) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	r.stopped = true
+	for _, registration := range r.registrations {
+		close(registration.shutdown)
+		<-registration.done
+	}
+
+	for w := range r.watches {
+		w.close()
+	}
+
+	r.registrations = nil
+	r.watches = nil
+
+	if r.client != nil {
+		if err := r.client.Close(); err != nil {
+			r.logger.Error("Unable to close etcd client: %s", err)
+		}
+	}
+}
+
+// etcdWatch is the Watch implementation returned by EtcdRegistrar
+type etcdWatch struct {
+	registrar *EtcdRegistrar
+	ctx       context.Context
+	cancel    func()
+	event     chan struct{}
+	closed    int32
+
+	lock      sync.Mutex
+	endpoints []Endpoint
+	values    []string
+}
+
+// run is the goroutine which watches the registrar's prefix, starting after the given revision, until this
+// watch is closed.  Each change lists the prefix again, so the endpoints always reflect a consistent revision.
+// A failed watch is reestablished from the last revision listed, so no changes are missed.
+func (w *etcdWatch) run(revision int64) {
+	r := w.registrar
+	for {
+		var err error
+		for err = range r.client.Watch(w.ctx, r.prefix, revision+1) {
+			if err != nil {
+				break
+			}
+
+			var endpoints []Endpoint
+			if endpoints, revision, err = r.list(w.ctx); err != nil {
+				break
+			}
+
+			w.update(endpoints)
+		}
+
+		if w.IsClosed() {
+			return
+		}
+
+		if err != nil {
+			r.logger.Error("Unable to watch etcd prefix %s: %s", r.prefix, err)
+		} else {
+			r.logger.Error("The watch on etcd prefix %s ended unexpectedly", r.prefix)
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-r.after(r.retryInterval):
+		}
+
+		// the revision may have been compacted while the watch was down, so resynchronize first
+		endpoints, current, err := r.list(w.ctx)
+		if err != nil {
+			r.logger.Error("Unable to list etcd prefix %s: %s", r.prefix, err)
+			continue
+		}
+
+		revision = current
+		w.update(endpoints)
+	}
+}
+
+// update changes this watch's endpoints, signalling an event if they are different
+func (w *etcdWatch) update(endpoints []Endpoint) {
+	w.lock.Lock()
+	changed := !reflect.DeepEqual(w.endpoints, endpoints)
+	w.endpoints = endpoints
+	w.values = EndpointValues(endpoints)
+	w.lock.Unlock()
+
+	if changed {
+		w.signal()
+	}
+}
+
+func (w *etcdWatch) signal() {
+	select {
+	case w.event <- struct{}{}:
+	default:
+	}
+}
+
+// close marks this watch as closed, stops its etcd watch, and wakes up any goroutine waiting on it
+func (w *etcdWatch) close() {
+	if atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		w.cancel()
+		w.signal()
+	}
+}
+
+func (w *etcdWatch) Close() {
+	w.registrar.lock.Lock()
+	delete(w.registrar.watches, w)
+	w.registrar.lock.Unlock()
+	w.close()
+}
+
+func (w *etcdWatch) IsClosed() bool {
+	return atomic.LoadInt32(&w.closed) != 0
+}
+
+func (w *etcdWatch) Event() <-chan struct{} {
+	return w.event
+}
+
+func (w *etcdWatch) Endpoints() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.values
+}
+
+func (w *etcdWatch) EndpointsWithMetadata() []Endpoint {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.endpoints
+}
+
+func (w *etcdWatch) String() string {
+	return "etcdWatch(" + w.registrar.prefix + ")"
+}
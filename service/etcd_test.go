@@ -0,0 +1,376 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEtcdOptionsDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, eo := range []*EtcdOptions{nil, new(EtcdOptions)} {
+		t.Log(eo)
+
+		assert.Equal(DefaultLeaseTTL, eo.leaseTTL())
+		assert.Equal(DefaultWatchRetryInterval, eo.retryInterval())
+	}
+
+	for _, o := range []*Options{nil, new(Options)} {
+		config := etcdConfig(o)
+		assert.Equal([]string{DefaultEtcdEndpoint}, config.Endpoints)
+		assert.Equal(DefaultTimeout, config.DialTimeout)
+		assert.Empty(config.Username)
+		assert.Empty(config.Password)
+	}
+}
+
+func TestEtcdOptions(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		o      = &Options{
+			Connection: "etcd-1.comcast.net:2379,etcd-2.comcast.net:2379",
+			Servers:    []string{"etcd-3.comcast.net:2379"},
+			Timeout:    10 * time.Second,
+			Etcd: &EtcdOptions{
+				Username:      "webpa",
+				Password:      "secret",
+				LeaseTTL:      30 * time.Second,
+				RetryInterval: time.Second,
+			},
+		}
+
+		config = etcdConfig(o)
+	)
+
+	assert.Equal([]string{"etcd-1.comcast.net:2379", "etcd-2.comcast.net:2379", "etcd-3.comcast.net:2379"}, config.Endpoints)
+	assert.Equal(10*time.Second, config.DialTimeout)
+	assert.Equal("webpa", config.Username)
+	assert.Equal("secret", config.Password)
+	assert.Equal(30*time.Second, o.etcd().leaseTTL())
+	assert.Equal(time.Second, o.etcd().retryInterval())
+	assert.Equal(EtcdBackend, (&Options{Backend: "ETCD"}).backend())
+	assert.Nil((*Options)(nil).etcd())
+}
+
+func TestNewRegistrarEtcd(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		registrar = NewRegistrar(&Options{Backend: EtcdBackend, ServiceName: "talaria", Environment: "prod", Timeout: 100 * time.Millisecond})
+	)
+
+	if etcdRegistrar, ok := registrar.(*EtcdRegistrar); assert.True(ok) {
+		assert.Equal("/webpa/prod/talaria/", etcdRegistrar.prefix)
+		assert.Equal(DefaultMemberPrefix, etcdRegistrar.memberPrefix)
+		assert.Equal(DefaultLeaseTTL, etcdRegistrar.leaseTTL)
+		etcdRegistrar.Stop()
+	}
+
+	_, ok := registrar.(MetadataRegistrar)
+	assert.True(ok)
+}
+
+// newTestEtcdRegistrar creates an EtcdRegistrar whose timers are driven by the returned channel
+func newTestEtcdRegistrar(client etcdClient) (*EtcdRegistrar, chan time.Time) {
+	var (
+		timer     = make(chan time.Time)
+		registrar = newEtcdRegistrar(&Options{ServiceName: "talaria", Environment: "prod"}, client, nil)
+	)
+
+	registrar.after = func(time.Duration) <-chan time.Time { return timer }
+	return registrar, timer
+}
+
+func TestEtcdRegistrarRegisterEndpoint(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		client           = new(mockEtcdClient)
+		registrar, timer = newTestEtcdRegistrar(client)
+		pingError        = errors.New("expected ping error")
+		keepAliveError   = errors.New("expected keepalive error")
+		pingResults      = make(chan error, 1)
+		calls            = make(chan string, 10)
+		expectedKey      = "/webpa/prod/talaria/webpa_talaria.comcast.net-8080"
+		expectedValue    = `{"value":"https://talaria.comcast.net:8080","metadata":{"region":"east"}}`
+		recordCall       = func(name string) func(mock.Arguments) {
+			return func(mock.Arguments) { calls <- name }
+		}
+
+		waitForCalls = func(expected ...string) {
+			for _, e := range expected {
+				select {
+				case actual := <-calls:
+					assert.Equal(e, actual)
+				case <-time.After(5 * time.Second):
+					require.Fail("No call was made", e)
+				}
+			}
+		}
+	)
+
+	ping := func() error {
+		select {
+		case err := <-pingResults:
+			return err
+		default:
+			return nil
+		}
+	}
+
+	client.On("Grant", int64(15)).Return(int64(1), nil).Once().Run(recordCall("grant"))
+	client.On("Put", expectedKey, expectedValue, int64(1)).Return(nil).Once().Run(recordCall("put"))
+	client.On("KeepAliveOnce", int64(1)).Return(nil).Once().Run(recordCall("keepalive"))
+	client.On("Revoke", int64(1)).Return(nil).Once().Run(recordCall("revoke"))
+	client.On("Grant", int64(15)).Return(int64(2), nil).Once().Run(recordCall("grant"))
+	client.On("Put", expectedKey, expectedValue, int64(2)).Return(nil).Once().Run(recordCall("put"))
+	client.On("KeepAliveOnce", int64(2)).Return(keepAliveError).Once().Run(recordCall("keepalive"))
+	client.On("Grant", int64(15)).Return(int64(3), nil).Once().Run(recordCall("grant"))
+	client.On("Put", expectedKey, expectedValue, int64(3)).Return(nil).Once().Run(recordCall("put"))
+	client.On("Revoke", int64(3)).Return(nil).Once().Run(recordCall("revoke"))
+	client.On("Close").Return(nil).Once()
+
+	endpoint, err := registrar.RegisterEndpointWithMetadata("https://talaria.comcast.net", 8080, ping, map[string]string{"region": "east"})
+	assert.Nil(endpoint)
+	require.NoError(err)
+	waitForCalls("grant", "put")
+
+	// a successful ping refreshes the lease
+	timer <- time.Now()
+	waitForCalls("keepalive")
+
+	// a failed ping deregisters the endpoint, and the next successful ping registers it again
+	pingResults <- pingError
+	timer <- time.Now()
+	waitForCalls("revoke")
+	timer <- time.Now()
+	waitForCalls("grant", "put")
+
+	// a lost lease is replaced
+	timer <- time.Now()
+	waitForCalls("keepalive", "grant", "put")
+
+	registrar.Stop()
+	registrar.Stop()
+	waitForCalls("revoke")
+
+	_, err = registrar.RegisterEndpoint("talaria.comcast.net", 8080, nil)
+	assert.Equal(ErrorStopped, err)
+	client.AssertExpectations(t)
+}
+
+func TestEtcdRegistrarRegisterEndpointAgain(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		client       = new(mockEtcdClient)
+		registrar, _ = newTestEtcdRegistrar(client)
+		expectedKey  = "/webpa/prod/talaria/webpa_talaria.comcast.net-80"
+	)
+
+	client.On("Grant", int64(15)).Return(int64(1), nil).Once()
+	client.On("Put", expectedKey, `{"value":"http://talaria.comcast.net:80"}`, int64(1)).Return(nil).Once()
+	client.On("Revoke", int64(1)).Return(nil).Once()
+	client.On("Grant", int64(15)).Return(int64(2), nil).Once()
+	client.On("Put", expectedKey, `{"value":"http://talaria.comcast.net:80","metadata":{"weight":"50"}}`, int64(2)).Return(nil).Once()
+	client.On("Revoke", int64(2)).Return(nil).Once()
+	client.On("Close").Return(nil).Once()
+
+	_, err := registrar.RegisterEndpoint("talaria.comcast.net", 80, nil)
+	require.NoError(err)
+
+	// the earlier registration's lease is revoked before the endpoint is registered again
+	_, err = registrar.RegisterEndpointWithMetadata("talaria.comcast.net", 80, nil, map[string]string{WeightMetadataKey: "50"})
+	require.NoError(err)
+	assert.Len(registrar.registrations, 1)
+
+	registrar.Stop()
+	client.AssertExpectations(t)
+}
+
+func TestEtcdRegistrarRegisterEndpointError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		client        = new(mockEtcdClient)
+		registrar, _  = newTestEtcdRegistrar(client)
+		expectedError = errors.New("expected")
+	)
+
+	client.On("Grant", int64(15)).Return(int64(0), expectedError).Once()
+	endpoint, err := registrar.RegisterEndpoint("talaria.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(expectedError, err)
+
+	// a failed put must not leave the lease behind
+	client.On("Grant", int64(15)).Return(int64(1), nil).Once()
+	client.On("Put", mock.AnythingOfType("string"), mock.AnythingOfType("string"), int64(1)).Return(expectedError).Once()
+	client.On("Revoke", int64(1)).Return(nil).Once()
+	endpoint, err = registrar.RegisterEndpoint("talaria.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(expectedError, err)
+
+	// nothing was registered, so there is nothing to revoke
+	client.On("Close").Return(nil).Once()
+	registrar.Stop()
+	client.AssertExpectations(t)
+}
+
+func TestEtcdRegistrarClientError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		registrar     = newEtcdRegistrar(nil, nil, expectedError)
+	)
+
+	endpoint, err := registrar.RegisterEndpoint("talaria.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(expectedError, err)
+
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(expectedError, err)
+
+	registrar.Stop()
+}
+
+func TestEtcdRegistrarWatch(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		client           = new(mockEtcdClient)
+		registrar, timer = newTestEtcdRegistrar(client)
+		prefix           = "/webpa/prod/talaria/"
+		talaria1         = `{"value":"http://talaria-1.comcast.net:8080","metadata":{"weight":"50"}}`
+		talaria2         = `{"value":"http://talaria-2.comcast.net:8080"}`
+		firstChanges     = make(chan error, 1)
+		secondChanges    = make(chan error, 1)
+		watched          = make(chan context.Context, 2)
+
+		waitForEvent = func(watch Watch) {
+			select {
+			case <-watch.Event():
+			case <-time.After(5 * time.Second):
+				require.Fail("No watch event was signalled")
+			}
+		}
+
+		waitForWatch = func() context.Context {
+			select {
+			case ctx := <-watched:
+				return ctx
+			case <-time.After(5 * time.Second):
+				require.Fail("No etcd watch was started")
+				return nil
+			}
+		}
+	)
+
+	client.On("List", prefix).Return([]string{talaria1, "this is not valid"}, int64(10), nil).Once()
+	client.On("Watch", mock.Anything, prefix, int64(11)).
+		Return((<-chan error)(firstChanges)).
+		Once().
+		Run(func(arguments mock.Arguments) { watched <- arguments.Get(0).(context.Context) })
+
+	client.On("List", prefix).Return([]string{talaria1, talaria2}, int64(12), nil).Once()
+
+	// after the watch fails, the prefix is listed again and the watch resumes from that revision
+	client.On("List", prefix).Return([]string{talaria2}, int64(20), nil).Once()
+	client.On("Watch", mock.Anything, prefix, int64(21)).
+		Return((<-chan error)(secondChanges)).
+		Once().
+		Run(func(arguments mock.Arguments) { watched <- arguments.Get(0).(context.Context) })
+
+	client.On("Close").Return(nil).Once()
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	require.NotNil(watch)
+	assert.False(watch.IsClosed())
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080"}, watch.Endpoints())
+	if metadataWatch, ok := watch.(MetadataWatch); assert.True(ok) {
+		assert.Equal(
+			[]Endpoint{{Value: "http://talaria-1.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "50"}}},
+			metadataWatch.EndpointsWithMetadata(),
+		)
+	}
+
+	waitForWatch()
+	firstChanges <- nil
+	waitForEvent(watch)
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080", "http://talaria-2.comcast.net:8080"}, watch.Endpoints())
+
+	firstChanges <- errors.New("expected watch error")
+	timer <- time.Now()
+	waitForEvent(watch)
+	assert.Equal([]string{"http://talaria-2.comcast.net:8080"}, watch.Endpoints())
+
+	ctx := waitForWatch()
+	registrar.Stop()
+	waitForEvent(watch)
+	assert.True(watch.IsClosed())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		assert.Fail("The etcd watch was not cancelled")
+	}
+
+	close(secondChanges)
+	watch, err = registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(ErrorStopped, err)
+	client.AssertExpectations(t)
+}
+
+func TestEtcdRegistrarWatchError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		client        = new(mockEtcdClient)
+		registrar, _  = newTestEtcdRegistrar(client)
+		expectedError = errors.New("expected")
+	)
+
+	client.On("List", "/webpa/prod/talaria/").Return(nil, int64(0), expectedError).Once()
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(expectedError, err)
+	client.AssertExpectations(t)
+}
+
+func TestEtcdWatchClose(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		client       = new(mockEtcdClient)
+		registrar, _ = newTestEtcdRegistrar(client)
+		changes      = make(chan error)
+	)
+
+	client.On("List", "/webpa/prod/talaria/").Return([]string{}, int64(1), nil).Once()
+	client.On("Watch", mock.Anything, "/webpa/prod/talaria/", int64(2)).
+		Return((<-chan error)(changes)).
+		Once().
+		Run(func(arguments mock.Arguments) {
+			go func(ctx context.Context) {
+				<-ctx.Done()
+				close(changes)
+			}(arguments.Get(0).(context.Context))
+		})
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	assert.Empty(watch.Endpoints())
+	assert.Equal("etcdWatch(/webpa/prod/talaria/)", watch.(*etcdWatch).String())
+
+	watch.Close()
+	watch.Close()
+	assert.True(watch.IsClosed())
+	assert.Empty(registrar.watches)
+
+	client.On("Close").Return(nil).Once()
+	registrar.Stop()
+}
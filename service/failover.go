@@ -0,0 +1,349 @@
+package service
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/strava/go.serversets"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrorNoEnsemble = errors.New("Unable to connect to any Zookeeper ensemble")
+	ErrorStopped    = errors.New("That registrar has been stopped")
+)
+
+// registration is an endpoint registered through a FailoverRegistrar.  It is repeated
+// against each ensemble that becomes active.
+type registration struct {
+	host     string
+	port     int
+	ping     func() error
+	endpoint *serversets.Endpoint
+}
+
+// FailoverRegistrar is a Registrar which spans a prioritized list of Zookeeper ensembles.  Exactly
+// one ensemble is active at a time, and all registrations and watches are made against it.
+//
+// The active ensemble is monitored with an internal watch.  When that watch closes, the session has been
+// irrecoverably lost, and the FailoverRegistrar moves every registration and watch to the most preferred
+// ensemble that can be reached.  While a secondary is active, more preferred ensembles are retried at
+// each failback interval, and the FailoverRegistrar fails back as soon as one of them can be reached.
+//
+// Watches returned by this registrar span failovers, and are only closed by Close or Stop.  The endpoints
+// returned by RegisterEndpoint are those of the ensemble that was active at the time, so clients should
+// call Stop rather than closing those endpoints directly.
+type FailoverRegistrar struct {
+	logger           logging.Logger
+	ensembles        []Registrar
+	failbackInterval time.Duration
+	after            func(time.Duration) <-chan time.Time
+
+	lock          sync.Mutex
+	active        int
+	watch         Watch
+	registrations []*registration
+	watches       map[*failoverWatch]bool
+	shutdown      chan struct{}
+	stopped       bool
+}
+
+// newFailoverRegistrar creates a FailoverRegistrar, using the factory to create the Registrar for each ensemble
+func newFailoverRegistrar(o *Options, ensembles [][]string, factory func([]string) Registrar) *FailoverRegistrar {
+	r := &FailoverRegistrar{
+		logger:           o.logger(),
+		ensembles:        make([]Registrar, len(ensembles)),
+		failbackInterval: o.failbackInterval(),
+		after:            time.After,
+		active:           -1,
+		watches:          make(map[*failoverWatch]bool),
+	}
+
+	for i, servers := range ensembles {
+		r.ensembles[i] = factory(servers)
+	}
+
+	return r
+}
+
+// Active returns the index of the currently active ensemble, where 0 is the primary and higher
+// values are secondaries in order of preference.  If no ensemble is active, this method returns -1.
+func (r *FailoverRegistrar) Active() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.active
+}
+
+func (r *FailoverRegistrar) RegisterEndpoint(host string, port int, ping func() error) (*serversets.Endpoint, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.start(); err != nil {
+		return nil, err
+	}
+
+	endpoint, err := r.ensembles[r.active].RegisterEndpoint(host, port, ping)
+	if err != nil {
+		return nil, err
+	}
+
+	r.registrations = append(r.registrations, &registration{host: host, port: port, ping: ping, endpoint: endpoint})
+	return endpoint, nil
+}
+
+func (r *FailoverRegistrar) Watch() (Watch, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.start(); err != nil {
+		return nil, err
+	}
+
+	w := &failoverWatch{registrar: r, event: make(chan struct{}, 1)}
+	r.watches[w] = true
+	return w, nil
+}
+
+// Stop closes all registered endpoints and watches and halts monitoring.  Once stopped, a
+// FailoverRegistrar cannot be restarted.  This method is idempotent.
+func (r *FailoverRegistrar) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	r.stopped = true
+	if r.shutdown != nil {
+		close(r.shutdown)
+	}
+
+	r.deactivate()
+	for w := range r.watches {
+		w.close()
+	}
+
+	r.watches = nil
+}
+
+// start connects to the most preferred ensemble and starts monitoring, if that has not already happened.
+// This method must be called under the lock.
+func (r *FailoverRegistrar) start() error {
+	if r.stopped {
+		return ErrorStopped
+	}
+
+	if r.shutdown != nil {
+		return nil
+	}
+
+	if !r.connect(len(r.ensembles), -1) {
+		return ErrorNoEnsemble
+	}
+
+	r.shutdown = make(chan struct{})
+	go r.monitor(r.shutdown)
+	return nil
+}
+
+// connect activates the first ensemble before limit that can be reached, skipping the
+// given index unless no other ensemble can be reached.  This method must be called under the lock.
+func (r *FailoverRegistrar) connect(limit, skip int) bool {
+	order := make([]int, 0, limit)
+	for i := 0; i < limit; i++ {
+		if i != skip {
+			order = append(order, i)
+		}
+	}
+
+	if skip >= 0 && skip < limit {
+		order = append(order, skip)
+	}
+
+	for _, index := range order {
+		watch, err := r.ensembles[index].Watch()
+		if err != nil {
+			r.logger.Error("Unable to connect to Zookeeper ensemble %d: %s", index, err)
+			continue
+		}
+
+		r.activate(index, watch)
+		return true
+	}
+
+	return false
+}
+
+// activate makes an ensemble the active one, moving all registrations onto it
+// and notifying all watches.  This method must be called under the lock.
+func (r *FailoverRegistrar) activate(index int, watch Watch) {
+	r.deactivate()
+
+	r.logger.Info("Zookeeper ensemble %d is now active", index)
+	r.active = index
+	r.watch = watch
+	for _, registration := range r.registrations {
+		endpoint, err := r.ensembles[index].RegisterEndpoint(registration.host, registration.port, registration.ping)
+		if err != nil {
+			r.logger.Error("Unable to register endpoint %s:%d with Zookeeper ensemble %d: %s", registration.host, registration.port, index, err)
+			continue
+		}
+
+		registration.endpoint = endpoint
+	}
+
+	r.notify()
+}
+
+// deactivate releases the active ensemble, if any.  This method must be called under the lock.
+func (r *FailoverRegistrar) deactivate() {
+	for _, registration := range r.registrations {
+		if registration.endpoint != nil {
+			registration.endpoint.Close()
+			registration.endpoint = nil
+		}
+	}
+
+	if r.watch != nil {
+		r.watch.Close()
+		r.watch = nil
+	}
+
+	r.active = -1
+}
+
+// notify signals every watch that the endpoints may have changed.  This method must be called under the lock.
+func (r *FailoverRegistrar) notify() {
+	for w := range r.watches {
+		w.signal()
+	}
+}
+
+// monitor is the goroutine which watches the active ensemble, failing over when its session is lost
+// and failing back when a more preferred ensemble can be reached
+func (r *FailoverRegistrar) monitor(shutdown <-chan struct{}) {
+	var failback <-chan time.Time
+	for {
+		r.lock.Lock()
+		var (
+			active = r.active
+			watch  = r.watch
+			event  <-chan struct{}
+		)
+
+		r.lock.Unlock()
+		if watch != nil {
+			event = watch.Event()
+		}
+
+		if active != 0 && failback == nil {
+			failback = r.after(r.failbackInterval)
+		} else if active == 0 {
+			failback = nil
+		}
+
+		select {
+		case <-shutdown:
+			return
+
+		case <-event:
+			r.lock.Lock()
+			if r.stopped || watch != r.watch {
+				r.lock.Unlock()
+				continue
+			}
+
+			if watch.IsClosed() {
+				r.logger.Error("Lost session with Zookeeper ensemble %d", active)
+				r.deactivate()
+				if !r.connect(len(r.ensembles), active) {
+					r.logger.Error("No Zookeeper ensemble is reachable.  Retrying in %s", r.failbackInterval)
+				}
+			} else {
+				r.notify()
+			}
+
+			r.lock.Unlock()
+
+		case <-failback:
+			failback = nil
+			r.lock.Lock()
+			if r.stopped {
+				r.lock.Unlock()
+				return
+			}
+
+			limit := r.active
+			if limit < 0 {
+				limit = len(r.ensembles)
+			}
+
+			r.failBack(limit)
+			r.lock.Unlock()
+		}
+	}
+}
+
+// failBack activates the most preferred reachable ensemble before limit, leaving the currently
+// active ensemble in place if none can be reached.  This method must be called under the lock.
+func (r *FailoverRegistrar) failBack(limit int) {
+	for index := 0; index < limit; index++ {
+		watch, err := r.ensembles[index].Watch()
+		if err != nil {
+			continue
+		}
+
+		r.logger.Info("Failing back to Zookeeper ensemble %d", index)
+		r.activate(index, watch)
+		return
+	}
+}
+
+// failoverWatch is the Watch implementation returned by FailoverRegistrar.  It always reports the
+// endpoints of the registrar's active ensemble.
+type failoverWatch struct {
+	registrar *FailoverRegistrar
+	event     chan struct{}
+	closed    int32
+}
+
+func (w *failoverWatch) signal() {
+	select {
+	case w.event <- struct{}{}:
+	default:
+	}
+}
+
+// close marks this watch as closed and wakes up any goroutine waiting on it
+func (w *failoverWatch) close() {
+	if atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		w.signal()
+	}
+}
+
+func (w *failoverWatch) Close() {
+	w.registrar.lock.Lock()
+	delete(w.registrar.watches, w)
+	w.registrar.lock.Unlock()
+	w.close()
+}
+
+func (w *failoverWatch) IsClosed() bool {
+	return atomic.LoadInt32(&w.closed) != 0
+}
+
+func (w *failoverWatch) Event() <-chan struct{} {
+	return w.event
+}
+
+func (w *failoverWatch) Endpoints() []string {
+	w.registrar.lock.Lock()
+	defer w.registrar.lock.Unlock()
+	if w.registrar.watch != nil {
+		return w.registrar.watch.Endpoints()
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newTestFailoverRegistrar creates a FailoverRegistrar over mock ensembles, with a failback
+// timer controlled by the returned channel
+func newTestFailoverRegistrar(t *testing.T, count int) (*FailoverRegistrar, []*mockRegistrar, chan time.Time) {
+	var (
+		ensembles = make([][]string, count)
+		mocks     = make([]*mockRegistrar, count)
+		failback  = make(chan time.Time, 1)
+		next      = 0
+	)
+
+	for i := 0; i < count; i++ {
+		ensembles[i] = []string{"ensemble"}
+		mocks[i] = new(mockRegistrar)
+	}
+
+	r := newFailoverRegistrar(
+		&Options{Logger: logging.TestLogger(t)},
+		ensembles,
+		func([]string) Registrar {
+			m := mocks[next]
+			next++
+			return m
+		},
+	)
+
+	r.after = func(d time.Duration) <-chan time.Time {
+		assert.Equal(t, DefaultFailbackInterval, d)
+		return failback
+	}
+
+	return r, mocks, failback
+}
+
+// newEnsembleWatch creates a mock ensemble watch along with the channel that signals its events
+func newEnsembleWatch(endpoints []string) (*mockWatch, chan struct{}) {
+	var (
+		watch  = new(mockWatch)
+		events = make(chan struct{}, 1)
+	)
+
+	watch.On("Event").Return((<-chan struct{})(events))
+	watch.On("Endpoints").Return(endpoints)
+	watch.On("Close").Return()
+	return watch, events
+}
+
+func waitForEvent(t *testing.T, watch Watch) {
+	select {
+	case <-watch.Event():
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "No watch event was signaled")
+	}
+}
+
+func testFailoverRegistrarNoEnsemble(t *testing.T) {
+	var (
+		assert              = assert.New(t)
+		expectedError       = errors.New("expected")
+		registrar, mocks, _ = newTestFailoverRegistrar(t, 2)
+	)
+
+	mocks[0].On("Watch").Return(nil, expectedError)
+	mocks[1].On("Watch").Return(nil, expectedError)
+
+	endpoint, err := registrar.RegisterEndpoint("http://localhost", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(ErrorNoEnsemble, err)
+
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(ErrorNoEnsemble, err)
+	assert.Equal(-1, registrar.Active())
+
+	for _, m := range mocks {
+		m.AssertExpectations(t)
+	}
+}
+
+func testFailoverRegistrarFailoverAndFailback(t *testing.T) {
+	var (
+		assert                     = assert.New(t)
+		require                    = require.New(t)
+		registrar, mocks, failback = newTestFailoverRegistrar(t, 2)
+
+		primaryWatch, primaryEvents = newEnsembleWatch([]string{"primary"})
+		secondaryWatch, _           = newEnsembleWatch([]string{"secondary"})
+		recoveredWatch, _           = newEnsembleWatch([]string{"recovered"})
+	)
+
+	mocks[0].On("Watch").Return(primaryWatch, nil).Once()
+	mocks[0].On("RegisterEndpoint", "http://localhost", 8080, mock.MatchedBy(nilPingFunc)).Return(nil, nil).Twice()
+	mocks[1].On("Watch").Return(secondaryWatch, nil).Once()
+	mocks[1].On("RegisterEndpoint", "http://localhost", 8080, mock.MatchedBy(nilPingFunc)).Return(nil, nil).Once()
+
+	_, err := registrar.RegisterEndpoint("http://localhost", 8080, nil)
+	require.NoError(err)
+	assert.Equal(0, registrar.Active())
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	require.NotNil(watch)
+	assert.Equal([]string{"primary"}, watch.Endpoints())
+
+	// an ordinary endpoint change is passed through
+	primaryWatch.On("IsClosed").Return(false).Once()
+	primaryEvents <- struct{}{}
+	waitForEvent(t, watch)
+	assert.False(watch.IsClosed())
+
+	// losing the primary session moves everything to the secondary
+	primaryWatch.On("IsClosed").Return(true).Once()
+	primaryEvents <- struct{}{}
+	waitForEvent(t, watch)
+	assert.False(watch.IsClosed())
+	assert.Equal(1, registrar.Active())
+	assert.Equal([]string{"secondary"}, watch.Endpoints())
+
+	// the primary is still down at the first failback attempt
+	mocks[0].On("Watch").Return(nil, errors.New("expected")).Once()
+	failback <- time.Now()
+
+	// the primary has recovered at the second attempt
+	mocks[0].On("Watch").Return(recoveredWatch, nil).Once()
+	failback <- time.Now()
+	waitForEvent(t, watch)
+	assert.Equal(0, registrar.Active())
+	assert.Equal([]string{"recovered"}, watch.Endpoints())
+
+	registrar.Stop()
+	registrar.Stop()
+	assert.True(watch.IsClosed())
+	assert.Equal(-1, registrar.Active())
+
+	_, err = registrar.Watch()
+	assert.Equal(ErrorStopped, err)
+	_, err = registrar.RegisterEndpoint("http://localhost", 8080, nil)
+	assert.Equal(ErrorStopped, err)
+
+	for _, m := range mocks {
+		m.AssertExpectations(t)
+	}
+
+	primaryWatch.AssertCalled(t, "Close")
+	secondaryWatch.AssertCalled(t, "Close")
+	recoveredWatch.AssertCalled(t, "Close")
+}
+
+func testFailoverRegistrarWatchClose(t *testing.T) {
+	var (
+		assert              = assert.New(t)
+		require             = require.New(t)
+		registrar, mocks, _ = newTestFailoverRegistrar(t, 2)
+		primaryWatch, _     = newEnsembleWatch([]string{"primary"})
+	)
+
+	mocks[0].On("Watch").Return(primaryWatch, nil).Once()
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	watch.Close()
+	assert.True(watch.IsClosed())
+	waitForEvent(t, watch)
+
+	registrar.Stop()
+	mocks[0].AssertExpectations(t)
+}
+
+func TestFailoverRegistrar(t *testing.T) {
+	t.Run("NoEnsemble", testFailoverRegistrarNoEnsemble)
+	t.Run("FailoverAndFailback", testFailoverRegistrarFailoverAndFailback)
+	t.Run("WatchClose", testFailoverRegistrarWatchClose)
+}
+
+func TestNewRegistrarFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.IsType((*registrar)(nil), NewRegistrar(&Options{}))
+
+	failover, ok := NewRegistrar(&Options{
+		Failover: []Ensemble{
+			{Connection: "secondary.comcast.net:2181"},
+		},
+	}).(*FailoverRegistrar)
+
+	if assert.True(ok) {
+		assert.Len(failover.ensembles, 2)
+		assert.Equal(DefaultFailbackInterval, failover.failbackInterval)
+		assert.Equal(-1, failover.Active())
+	}
+}
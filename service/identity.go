@@ -0,0 +1,101 @@
+package service
+
+import (
+	"github.com/strava/go.serversets"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// InstanceIDMetadataKey is the registration metadata key that carries the instance ID
+	InstanceIDMetadataKey = "instanceId"
+
+	// ZoneMetadataKey is the registration metadata key that carries the instance's zone
+	ZoneMetadataKey = "zone"
+)
+
+// MetadataRegistrar is implemented by Registrars that can publish metadata alongside a registered
+// endpoint.  RegisterAll uses this interface when it is available.  Registrars that do not implement it,
+// such as the one returned by NewRegistrar, register endpoints without metadata.
+type MetadataRegistrar interface {
+	Registrar
+	RegisterEndpointWithMetadata(host string, port int, ping func() error, metadata map[string]string) (*serversets.Endpoint, error)
+}
+
+// Identity describes a single running instance of a service
+type Identity struct {
+	// Scheme is the scheme clients use to reach this instance, e.g. https
+	Scheme string
+
+	// Host is the host name or address of this instance
+	Host string
+
+	// Port is the port this instance is registered with
+	Port uint16
+
+	// Zone is the optional availability zone or datacenter of this instance
+	Zone string
+
+	// Metadata holds the configured registration metadata, along with the instance ID and zone
+	Metadata map[string]string
+}
+
+// ID returns the stable identifier for this instance, of the form scheme://host:port.  When a zone
+// is configured, the ID is prefixed with the zone and a slash, e.g. east/https://talaria-1.net:8443.
+func (i *Identity) ID() string {
+	id := i.Scheme + "://" + net.JoinHostPort(i.Host, strconv.Itoa(int(i.Port)))
+	if len(i.Zone) > 0 {
+		return i.Zone + "/" + id
+	}
+
+	return id
+}
+
+func (i *Identity) String() string {
+	return i.ID()
+}
+
+// newIdentity computes the Identity described by a set of options.  The first registration that
+// parses determines the scheme, host, and port.  If there is no such registration, the local
+// host name is used with DefaultScheme and no port.
+func newIdentity(o *Options) *Identity {
+	identity := &Identity{Scheme: DefaultScheme, Zone: o.zone()}
+	for _, registration := range o.registrations() {
+		// ParseRegistration always produces a host of the form scheme://host
+		if host, port, err := ParseRegistration(registration); err == nil {
+			parts := strings.SplitN(host, "://", 2)
+			identity.Scheme, identity.Host, identity.Port = parts[0], parts[1], port
+			break
+		}
+	}
+
+	if len(identity.Host) == 0 {
+		if hostname, err := os.Hostname(); err == nil {
+			identity.Host = hostname
+		} else {
+			identity.Host = DefaultHost
+		}
+	}
+
+	metadata := o.metadata()
+	identity.Metadata = make(map[string]string, len(metadata)+2)
+	for key, value := range metadata {
+		identity.Metadata[key] = value
+	}
+
+	identity.Metadata[InstanceIDMetadataKey] = identity.ID()
+	if len(identity.Zone) > 0 {
+		identity.Metadata[ZoneMetadataKey] = identity.Zone
+	}
+
+	return identity
+}
+
+// Identity is the accessor for this instance's identity, computed from the registrations, zone, and
+// metadata of these options.  Components that need to identify the running instance, such as in
+// websocket close frames or audit records, should use this method so that every component agrees.
+func (o *Options) Identity() *Identity {
+	return newIdentity(o)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"github.com/strava/go.serversets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestIdentityID(t *testing.T) {
+	assert := assert.New(t)
+
+	identity := &Identity{Scheme: "https", Host: "talaria-1.comcast.net", Port: 8443}
+	assert.Equal("https://talaria-1.comcast.net:8443", identity.ID())
+	assert.Equal(identity.ID(), identity.String())
+
+	identity.Zone = "east"
+	assert.Equal("east/https://talaria-1.comcast.net:8443", identity.ID())
+}
+
+func TestOptionsIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	identity := (&Options{
+		Registrations: []string{"https://talaria-1.comcast.net:8443", "talaria-1.alias.net"},
+		Zone:          "east",
+		Metadata:      map[string]string{"version": "1.2.3"},
+	}).Identity()
+
+	assert.Equal("https", identity.Scheme)
+	assert.Equal("talaria-1.comcast.net", identity.Host)
+	assert.Equal(uint16(8443), identity.Port)
+	assert.Equal("east", identity.Zone)
+	assert.Equal(
+		map[string]string{
+			"version":             "1.2.3",
+			InstanceIDMetadataKey: "east/https://talaria-1.comcast.net:8443",
+			ZoneMetadataKey:       "east",
+		},
+		identity.Metadata,
+	)
+
+	// the identity is stable across calls
+	assert.Equal(identity, (&Options{
+		Registrations: []string{"https://talaria-1.comcast.net:8443", "talaria-1.alias.net"},
+		Zone:          "east",
+		Metadata:      map[string]string{"version": "1.2.3"},
+	}).Identity())
+}
+
+func TestOptionsIdentityNoRegistrations(t *testing.T) {
+	assert := assert.New(t)
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = DefaultHost
+	}
+
+	for _, o := range []*Options{nil, new(Options)} {
+		t.Log(o)
+		identity := o.Identity()
+		assert.Equal(DefaultScheme, identity.Scheme)
+		assert.Equal(hostname, identity.Host)
+		assert.Equal(uint16(0), identity.Port)
+		assert.Equal(map[string]string{InstanceIDMetadataKey: identity.ID()}, identity.Metadata)
+	}
+}
+
+func TestRegisterAllWithMetadata(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		registrar = new(mockMetadataRegistrar)
+		endpoint  = new(serversets.Endpoint)
+		options   = &Options{
+			Registrations: []string{"https://node1.comcast.net:1467"},
+			Metadata:      map[string]string{"version": "1.2.3"},
+		}
+	)
+
+	registrar.On(
+		"RegisterEndpointWithMetadata",
+		"https://node1.comcast.net",
+		1467,
+		mock.MatchedBy(nilPingFunc),
+		map[string]string{"version": "1.2.3", InstanceIDMetadataKey: "https://node1.comcast.net:1467"},
+	).Return(endpoint, nil).Once()
+
+	endpoints, err := RegisterAll(registrar, options)
+	require.NoError(err)
+	assert.Len(endpoints, 1)
+	assert.True(endpoints.Has("https://node1.comcast.net:1467"))
+	registrar.AssertExpectations(t)
+}
@@ -1,8 +1,12 @@
 package service
 
 import (
+	"context"
+	"github.com/hashicorp/consul/api"
 	"github.com/strava/go.serversets"
 	"github.com/stretchr/testify/mock"
+	"net"
+	"sync"
 )
 
 func nilPingFunc(actual func() error) bool {
@@ -29,6 +33,11 @@ type mockWatch struct {
 	mock.Mock
 }
 
+// String avoids formatting the mock's internal state, which races with concurrent calls
+func (m *mockWatch) String() string {
+	return "mockWatch"
+}
+
 func (m *mockWatch) Close() {
 	m.Called()
 }
@@ -58,6 +67,20 @@ func (m *mockAccessor) Get(key []byte) (string, error) {
 	return arguments.String(0), arguments.Error(1)
 }
 
+type mockEndpointStore struct {
+	mock.Mock
+}
+
+func (m *mockEndpointStore) Load() ([]Endpoint, error) {
+	arguments := m.Called()
+	endpoints, _ := arguments.Get(0).([]Endpoint)
+	return endpoints, arguments.Error(1)
+}
+
+func (m *mockEndpointStore) Save(endpoints []Endpoint) error {
+	return m.Called(endpoints).Error(0)
+}
+
 type mockAccessorFactory struct {
 	mock.Mock
 }
@@ -68,3 +91,150 @@ func (m *mockAccessorFactory) New(endpoints []string) (Accessor, []string) {
 	second, _ := arguments.Get(1).([]string)
 	return first, second
 }
+
+type mockMetadataRegistrar struct {
+	mockRegistrar
+}
+
+func (m *mockMetadataRegistrar) RegisterEndpointWithMetadata(host string, port int, pingFunc func() error, metadata map[string]string) (*serversets.Endpoint, error) {
+	arguments := m.Called(host, port, pingFunc, metadata)
+	first, _ := arguments.Get(0).(*serversets.Endpoint)
+	return first, arguments.Error(1)
+}
+
+type mockConsulClient struct {
+	mock.Mock
+}
+
+func (m *mockConsulClient) ServiceRegister(registration *api.AgentServiceRegistration) error {
+	return m.Called(registration).Error(0)
+}
+
+func (m *mockConsulClient) ServiceDeregister(serviceID string) error {
+	return m.Called(serviceID).Error(0)
+}
+
+func (m *mockConsulClient) UpdateTTL(checkID, output, status string) error {
+	return m.Called(checkID, output, status).Error(0)
+}
+
+func (m *mockConsulClient) Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	arguments := m.Called(service, tag, passingOnly, q)
+	first, _ := arguments.Get(0).([]*api.ServiceEntry)
+	second, _ := arguments.Get(1).(*api.QueryMeta)
+	return first, second, arguments.Error(2)
+}
+
+type mockSRVResolver struct {
+	mock.Mock
+}
+
+func (m *mockSRVResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	arguments := m.Called(ctx, service, proto, name)
+	records, _ := arguments.Get(1).([]*net.SRV)
+	return arguments.String(0), records, arguments.Error(2)
+}
+
+type mockEtcdClient struct {
+	mock.Mock
+}
+
+func (m *mockEtcdClient) Grant(ctx context.Context, ttl int64) (int64, error) {
+	arguments := m.Called(ttl)
+	return arguments.Get(0).(int64), arguments.Error(1)
+}
+
+func (m *mockEtcdClient) KeepAliveOnce(ctx context.Context, lease int64) error {
+	return m.Called(lease).Error(0)
+}
+
+func (m *mockEtcdClient) Revoke(ctx context.Context, lease int64) error {
+	return m.Called(lease).Error(0)
+}
+
+func (m *mockEtcdClient) Put(ctx context.Context, key, value string, lease int64) error {
+	return m.Called(key, value, lease).Error(0)
+}
+
+func (m *mockEtcdClient) List(ctx context.Context, prefix string) ([]string, int64, error) {
+	arguments := m.Called(prefix)
+	first, _ := arguments.Get(0).([]string)
+	return first, arguments.Get(1).(int64), arguments.Error(2)
+}
+
+// Watch passes the context along to the expectation, so that tests can wait for the watch to be cancelled
+func (m *mockEtcdClient) Watch(ctx context.Context, prefix string, revision int64) <-chan error {
+	return m.Called(ctx, prefix, revision).Get(0).(<-chan error)
+}
+
+func (m *mockEtcdClient) Close() error {
+	return m.Called().Error(0)
+}
+
+// fakeWatch is a Watch whose endpoints are set directly by tests.  Unlike TestWatch, Endpoints never
+// blocks, and each change is delivered as an event that the test waits on.
+type fakeWatch struct {
+	lock      sync.Mutex
+	endpoints []string
+	closed    bool
+	event     chan struct{}
+}
+
+func newFakeWatch(endpoints ...string) *fakeWatch {
+	return &fakeWatch{endpoints: endpoints, event: make(chan struct{})}
+}
+
+func (w *fakeWatch) String() string {
+	return "fakeWatch"
+}
+
+// Close only marks this watch as closed, as nothing may be receiving an event
+func (w *fakeWatch) Close() {
+	w.lock.Lock()
+	w.closed = true
+	w.lock.Unlock()
+}
+
+func (w *fakeWatch) IsClosed() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.closed
+}
+
+func (w *fakeWatch) Event() <-chan struct{} {
+	return w.event
+}
+
+func (w *fakeWatch) Endpoints() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]string(nil), w.endpoints...)
+}
+
+// set changes the endpoints of this watch, blocking until the event is received
+func (w *fakeWatch) set(endpoints ...string) {
+	w.lock.Lock()
+	w.endpoints = endpoints
+	w.lock.Unlock()
+	w.event <- struct{}{}
+}
+
+// close closes this watch, blocking until the event is received
+func (w *fakeWatch) close() {
+	w.Close()
+	w.event <- struct{}{}
+}
+
+// heartbeatWatch is a fakeWatch that also reports heartbeats sent by tests
+type heartbeatWatch struct {
+	*fakeWatch
+	heartbeat chan struct{}
+}
+
+func newHeartbeatWatch(endpoints ...string) *heartbeatWatch {
+	return &heartbeatWatch{fakeWatch: newFakeWatch(endpoints...), heartbeat: make(chan struct{})}
+}
+
+func (w *heartbeatWatch) Heartbeat() <-chan struct{} {
+	return w.heartbeat
+}
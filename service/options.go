@@ -3,6 +3,7 @@ package service
 import (
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/strava/go.serversets"
+	"sort"
 	"strings"
 	"time"
 )
@@ -17,8 +18,38 @@ const (
 	DefaultEnvironment   = serversets.Local
 	DefaultServiceName   = "test"
 	DefaultVnodeCount    = 211
+
+	DefaultFailbackInterval = time.Minute
 )
 
+// Ensemble describes a secondary Zookeeper ensemble used when the primary ensemble,
+// given by Options.Connection and Options.Servers, is lost.
+type Ensemble struct {
+	// Connection is the comma-delimited Zookeeper connection string for this ensemble.
+	// Both this and Servers may be set, and they will be merged together.
+	Connection string `json:"connection,omitempty"`
+
+	// Servers is the array of Zookeeper servers in this ensemble.
+	Servers []string `json:"servers,omitempty"`
+
+	// Priority orders secondary ensembles.  Lower values are preferred, and ensembles with
+	// the same priority are tried in the order they are configured.  The primary ensemble
+	// is always preferred over any secondary.
+	Priority int `json:"priority"`
+}
+
+// mergeServers combines a comma-delimited connection string with a list of servers
+func mergeServers(connection string, servers []string) []string {
+	merged := make([]string, 0, 10)
+	if len(connection) > 0 {
+		for _, server := range strings.Split(connection, ",") {
+			merged = append(merged, strings.TrimSpace(server))
+		}
+	}
+
+	return append(merged, servers...)
+}
+
 // Options represents the set of configurable attributes for service discovery and registration
 type Options struct {
 	// Logger is used by any component configured via this Options.  If unset, a default
@@ -60,6 +91,42 @@ type Options struct {
 	// PingFunc is the callback function used to determine if this application is still able
 	// to respond to requests.  This can be nil, and there is no default.
 	PingFunc func() error `json:"-"`
+
+	// Failover is the optional list of secondary Zookeeper ensembles.  When set, registrations
+	// and watches move to the most preferred reachable secondary whenever the current ensemble's
+	// session is irrecoverably lost.
+	Failover []Ensemble `json:"failover,omitempty"`
+
+	// FailbackInterval is how often a more preferred ensemble is retried while failed over.
+	// If not positive, DefaultFailbackInterval is used.
+	FailbackInterval time.Duration `json:"failbackInterval"`
+
+	// Zone is the optional availability zone or datacenter of this instance.  It becomes part of the
+	// instance ID and is published with each registration.
+	Zone string `json:"zone,omitempty"`
+
+	// FallbackZones is the ordered list of zones a NewZoneAccessor fails over to when the local Zone has
+	// no endpoints.  Zones not listed here are tried afterwards, in lexical order.
+	FallbackZones []string `json:"fallbackZones,omitempty"`
+
+	// Metadata is published with each registration, for registrars that support metadata.  See Identity.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Backend is the service discovery system used by NewRegistrar, one of ZookeeperBackend, ConsulBackend,
+	// DNSBackend, or EtcdBackend.  If unset, ZookeeperBackend is used.
+	Backend string `json:"backend,omitempty"`
+
+	// Consul configures the ConsulBackend.  It is ignored by the ZookeeperBackend.
+	Consul *ConsulOptions `json:"consul,omitempty"`
+
+	// DNS configures the DNSBackend.  It is ignored by the other backends.
+	DNS *DNSOptions `json:"dns,omitempty"`
+
+	// Etcd configures the EtcdBackend.  It is ignored by the other backends.
+	Etcd *EtcdOptions `json:"etcd,omitempty"`
+
+	// Probe configures the health probing done by a ProbingRegistrar.  It is ignored by NewRegistrar.
+	Probe *ProbeOptions `json:"probe,omitempty"`
 }
 
 func (o *Options) logger() logging.Logger {
@@ -70,19 +137,57 @@ func (o *Options) logger() logging.Logger {
 	return logging.DefaultLogger()
 }
 
-func (o *Options) servers() []string {
-	servers := make([]string, 0, 10)
-
+func (o *Options) backend() string {
 	if o != nil {
-		if len(o.Connection) > 0 {
-			for _, server := range strings.Split(o.Connection, ",") {
-				servers = append(servers, strings.TrimSpace(server))
-			}
+		switch {
+		case strings.EqualFold(o.Backend, ConsulBackend):
+			return ConsulBackend
+		case strings.EqualFold(o.Backend, DNSBackend):
+			return DNSBackend
+		case strings.EqualFold(o.Backend, EtcdBackend):
+			return EtcdBackend
 		}
+	}
 
-		if len(o.Servers) > 0 {
-			servers = append(servers, o.Servers...)
-		}
+	return ZookeeperBackend
+}
+
+func (o *Options) consul() *ConsulOptions {
+	if o != nil {
+		return o.Consul
+	}
+
+	return nil
+}
+
+func (o *Options) dns() *DNSOptions {
+	if o != nil {
+		return o.DNS
+	}
+
+	return nil
+}
+
+func (o *Options) etcd() *EtcdOptions {
+	if o != nil {
+		return o.Etcd
+	}
+
+	return nil
+}
+
+func (o *Options) probe() *ProbeOptions {
+	if o != nil {
+		return o.Probe
+	}
+
+	return nil
+}
+
+func (o *Options) servers() []string {
+	var servers []string
+	if o != nil {
+		servers = mergeServers(o.Connection, o.Servers)
 	}
 
 	if len(servers) == 0 {
@@ -92,6 +197,37 @@ func (o *Options) servers() []string {
 	return servers
 }
 
+// ensembles returns the server lists of each configured ensemble in order of preference.
+// The primary ensemble is always first, and secondaries without any servers are skipped.
+func (o *Options) ensembles() [][]string {
+	ensembles := [][]string{o.servers()}
+	if o == nil || len(o.Failover) == 0 {
+		return ensembles
+	}
+
+	failover := make([]Ensemble, len(o.Failover))
+	copy(failover, o.Failover)
+	sort.SliceStable(failover, func(i, j int) bool {
+		return failover[i].Priority < failover[j].Priority
+	})
+
+	for _, ensemble := range failover {
+		if servers := mergeServers(ensemble.Connection, ensemble.Servers); len(servers) > 0 {
+			ensembles = append(ensembles, servers)
+		}
+	}
+
+	return ensembles
+}
+
+func (o *Options) failbackInterval() time.Duration {
+	if o != nil && o.FailbackInterval > 0 {
+		return o.FailbackInterval
+	}
+
+	return DefaultFailbackInterval
+}
+
 func (o *Options) timeout() time.Duration {
 	if o != nil && o.Timeout > 0 {
 		return time.Duration(o.Timeout)
@@ -140,6 +276,30 @@ func (o *Options) registrations() []string {
 	return nil
 }
 
+func (o *Options) zone() string {
+	if o != nil {
+		return o.Zone
+	}
+
+	return ""
+}
+
+func (o *Options) fallbackZones() []string {
+	if o != nil {
+		return o.FallbackZones
+	}
+
+	return nil
+}
+
+func (o *Options) metadata() map[string]string {
+	if o != nil {
+		return o.Metadata
+	}
+
+	return nil
+}
+
 func (o *Options) vnodeCount() int {
 	if o != nil && o.VnodeCount > 0 {
 		return int(o.VnodeCount)
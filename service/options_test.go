@@ -25,9 +25,39 @@ func TestOptionsDefault(t *testing.T) {
 		assert.Empty(o.registrations())
 		assert.Equal(DefaultVnodeCount, o.vnodeCount())
 		assert.Nil(o.pingFunc())
+		assert.Equal([][]string{{DefaultServer}}, o.ensembles())
+		assert.Equal(DefaultFailbackInterval, o.failbackInterval())
 	}
 }
 
+func TestOptionsEnsembles(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		o      = &Options{
+			Connection: "primary.comcast.net:2181",
+			Failover: []Ensemble{
+				{Servers: []string{"third.comcast.net:2181"}, Priority: 2},
+				{Connection: "second1.comcast.net:2181, second2.comcast.net:2181", Priority: 1},
+				{Priority: 0},
+				{Servers: []string{"fourth.comcast.net:2181"}, Priority: 2},
+			},
+			FailbackInterval: 15 * time.Second,
+		}
+	)
+
+	assert.Equal(
+		[][]string{
+			{"primary.comcast.net:2181"},
+			{"second1.comcast.net:2181", "second2.comcast.net:2181"},
+			{"third.comcast.net:2181"},
+			{"fourth.comcast.net:2181"},
+		},
+		o.ensembles(),
+	)
+
+	assert.Equal(15*time.Second, o.failbackInterval())
+}
+
 func TestOptions(t *testing.T) {
 	assert := assert.New(t)
 	logger := logging.TestLogger(t)
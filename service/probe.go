@@ -0,0 +1,429 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/strava/go.serversets"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// TCPProbe checks an endpoint by opening, then immediately closing, a TCP connection to it
+	TCPProbe = "tcp"
+
+	// HTTPProbe checks an endpoint by issuing a GET to ProbeOptions.Path, which must return a 2xx status
+	HTTPProbe = "http"
+
+	DefaultProbeType             = TCPProbe
+	DefaultProbePath             = "/health"
+	DefaultProbeInterval         = 10 * time.Second
+	DefaultProbeTimeout          = 2 * time.Second
+	DefaultProbeFailureThreshold = 1
+)
+
+// Prober is the strategy for checking the health of a single endpoint.  The endpoint is in any of the
+// forms accepted by ParseHostPort, optionally tagged with a zone via ZoneEndpoint.
+type Prober interface {
+	Probe(ctx context.Context, endpoint string) error
+}
+
+// ProberFunc is a function type that implements Prober
+type ProberFunc func(context.Context, string) error
+
+func (pf ProberFunc) Probe(ctx context.Context, endpoint string) error {
+	return pf(ctx, endpoint)
+}
+
+// probeURL produces the base URL of an endpoint, ignoring any zone tag
+func probeURL(endpoint string) (*url.URL, error) {
+	_, endpoint = ParseZoneEndpoint(endpoint)
+	baseURL, err := ParseHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.Parse(baseURL)
+}
+
+// NewTCPProber produces a Prober which considers an endpoint healthy if a TCP connection can be opened to it
+func NewTCPProber() Prober {
+	var dialer net.Dialer
+	return ProberFunc(func(ctx context.Context, endpoint string) error {
+		target, err := probeURL(endpoint)
+		if err != nil {
+			return err
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp", target.Host)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	})
+}
+
+// NewHTTPProber produces a Prober which issues a GET for the given path on each endpoint.  An endpoint is
+// healthy if it responds with a 2xx status.  If client is nil, http.DefaultClient is used.
+func NewHTTPProber(client *http.Client, path string) Prober {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return ProberFunc(func(ctx context.Context, endpoint string) error {
+		target, err := probeURL(endpoint)
+		if err != nil {
+			return err
+		}
+
+		request, err := http.NewRequest("GET", target.Scheme+"://"+target.Host+path, nil)
+		if err != nil {
+			return err
+		}
+
+		response, err := client.Do(request.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return fmt.Errorf("Health probe of %s returned status %d", endpoint, response.StatusCode)
+		}
+
+		return nil
+	})
+}
+
+// ProbeOptions configures the active health probing done by a ProbingRegistrar
+type ProbeOptions struct {
+	// Type is the kind of probe, either TCPProbe or HTTPProbe.  If unset, DefaultProbeType is used.
+	Type string `json:"type,omitempty"`
+
+	// Path is the path requested by an HTTPProbe.  If unset, DefaultProbePath is used.
+	Path string `json:"path,omitempty"`
+
+	// Interval is how often every endpoint is probed.  Newly discovered endpoints are also probed as soon as
+	// they appear.  If not positive, DefaultProbeInterval is used.
+	Interval time.Duration `json:"interval"`
+
+	// Timeout is the limit on each individual probe.  If not positive, DefaultProbeTimeout is used.
+	Timeout time.Duration `json:"timeout"`
+
+	// FailureThreshold is the number of consecutive failed probes after which an endpoint is considered
+	// unhealthy.  A single successful probe makes it healthy again.  If not positive, DefaultProbeFailureThreshold
+	// is used.
+	FailureThreshold int `json:"failureThreshold"`
+
+	// Prober is an optional custom probe strategy.  If set, Type and Path are ignored.
+	Prober Prober `json:"-"`
+}
+
+func (po *ProbeOptions) prober() Prober {
+	if po != nil && po.Prober != nil {
+		return po.Prober
+	}
+
+	if po != nil && strings.EqualFold(po.Type, HTTPProbe) {
+		path := po.Path
+		if len(path) == 0 {
+			path = DefaultProbePath
+		}
+
+		return NewHTTPProber(nil, path)
+	}
+
+	return NewTCPProber()
+}
+
+func (po *ProbeOptions) interval() time.Duration {
+	if po != nil && po.Interval > 0 {
+		return po.Interval
+	}
+
+	return DefaultProbeInterval
+}
+
+func (po *ProbeOptions) timeout() time.Duration {
+	if po != nil && po.Timeout > 0 {
+		return po.Timeout
+	}
+
+	return DefaultProbeTimeout
+}
+
+func (po *ProbeOptions) failureThreshold() int {
+	if po != nil && po.FailureThreshold > 0 {
+		return po.FailureThreshold
+	}
+
+	return DefaultProbeFailureThreshold
+}
+
+// ProbingRegistrar decorates another Registrar so that its watches report only the endpoints that pass
+// active health probes.  This shields a Subscription's Listener from endpoints that have died but have
+// not yet been removed from service discovery, e.g. while a Zookeeper session times out.
+//
+// If every endpoint is unhealthy, which more likely indicates a problem with the probes themselves,
+// watches report all endpoints rather than none.  Registrations are passed through to the decorated Registrar.
+type ProbingRegistrar struct {
+	logger    logging.Logger
+	delegate  Registrar
+	prober    Prober
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+	after     func(time.Duration) <-chan time.Time
+}
+
+// NewProbingRegistrar decorates a Registrar, such as one returned by NewRegistrar, with the health probing
+// configured by Options.Probe
+func NewProbingRegistrar(o *Options, delegate Registrar) *ProbingRegistrar {
+	probe := o.probe()
+	return &ProbingRegistrar{
+		logger:    o.logger(),
+		delegate:  delegate,
+		prober:    probe.prober(),
+		interval:  probe.interval(),
+		timeout:   probe.timeout(),
+		threshold: probe.failureThreshold(),
+		after:     time.After,
+	}
+}
+
+func (r *ProbingRegistrar) RegisterEndpoint(host string, port int, ping func() error) (*serversets.Endpoint, error) {
+	return r.delegate.RegisterEndpoint(host, port, ping)
+}
+
+// RegisterEndpointWithMetadata registers an endpoint with the decorated Registrar.  The metadata is
+// published only if that Registrar is a MetadataRegistrar.
+func (r *ProbingRegistrar) RegisterEndpointWithMetadata(host string, port int, ping func() error, metadata map[string]string) (*serversets.Endpoint, error) {
+	if metadataRegistrar, ok := r.delegate.(MetadataRegistrar); ok {
+		return metadataRegistrar.RegisterEndpointWithMetadata(host, port, ping, metadata)
+	}
+
+	return r.delegate.RegisterEndpoint(host, port, ping)
+}
+
+// Watch creates a watch against the decorated Registrar.  The initial endpoints are probed before this
+// method returns, so the returned watch's endpoints are already filtered.
+func (r *ProbingRegistrar) Watch() (Watch, error) {
+	watch, err := r.delegate.Watch()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &probingWatch{
+		registrar: r,
+		watch:     watch,
+		event:     make(chan struct{}, 1),
+		shutdown:  make(chan struct{}),
+		failures:  make(map[string]int),
+	}
+
+	w.probe(WatchEndpoints(watch), true)
+	go w.run()
+	return w, nil
+}
+
+// probingWatch is the Watch implementation returned by ProbingRegistrar
+type probingWatch struct {
+	registrar *ProbingRegistrar
+	watch     Watch
+	event     chan struct{}
+	shutdown  chan struct{}
+	closed    int32
+
+	lock sync.Mutex
+
+	// discovered holds the endpoints most recently reported by the decorated watch, and failures holds
+	// the number of consecutive failed probes for each of them
+	discovered []Endpoint
+	failures   map[string]int
+	endpoints  []Endpoint
+	values     []string
+}
+
+// run is the goroutine which probes endpoints until this watch is closed
+func (w *probingWatch) run() {
+	r := w.registrar
+	tick := r.after(r.interval)
+	for {
+		select {
+		case <-w.shutdown:
+			return
+
+		case <-w.watch.Event():
+			if w.watch.IsClosed() {
+				w.close()
+				return
+			}
+
+			// only endpoints that have just appeared need probing
+			if w.probe(WatchEndpoints(w.watch), false) {
+				w.signal()
+			}
+
+		case <-tick:
+			tick = r.after(r.interval)
+
+			w.lock.Lock()
+			discovered := w.discovered
+			w.lock.Unlock()
+
+			if w.probe(discovered, true) {
+				w.signal()
+			}
+		}
+	}
+}
+
+// probe checks a set of discovered endpoints concurrently, then updates this watch's healthy endpoints.
+// If all is false, only endpoints that have never been probed are checked.  The return value indicates
+// whether the healthy endpoints, or their metadata, changed.
+func (w *probingWatch) probe(discovered []Endpoint, all bool) bool {
+	var (
+		r       = w.registrar
+		results = make(map[string]error, len(discovered))
+		targets = make([]string, 0, len(discovered))
+	)
+
+	w.lock.Lock()
+	for _, endpoint := range discovered {
+		if _, probed := w.failures[endpoint.Value]; all || !probed {
+			targets = append(targets, endpoint.Value)
+		}
+	}
+
+	w.lock.Unlock()
+
+	if len(targets) > 0 {
+		var (
+			resultLock  sync.Mutex
+			waitGroup   sync.WaitGroup
+			ctx, cancel = context.WithTimeout(context.Background(), r.timeout)
+		)
+
+		waitGroup.Add(len(targets))
+		for _, endpoint := range targets {
+			go func(endpoint string) {
+				defer waitGroup.Done()
+				err := r.prober.Probe(ctx, endpoint)
+
+				resultLock.Lock()
+				results[endpoint] = err
+				resultLock.Unlock()
+			}(endpoint)
+		}
+
+		waitGroup.Wait()
+		cancel()
+	}
+
+	return w.update(discovered, results)
+}
+
+// update applies a set of probe results and recomputes the healthy endpoints, returning true if they changed
+func (w *probingWatch) update(discovered []Endpoint, results map[string]error) bool {
+	r := w.registrar
+
+	w.lock.Lock()
+	var (
+		failures = make(map[string]int, len(discovered))
+		healthy  = make([]Endpoint, 0, len(discovered))
+	)
+
+	for _, discoveredEndpoint := range discovered {
+		endpoint := discoveredEndpoint.Value
+		count, probed := w.failures[endpoint]
+		if err, ok := results[endpoint]; ok {
+			if err == nil {
+				if probed && count >= r.threshold {
+					r.logger.Info("Endpoint %s is healthy again", endpoint)
+				}
+
+				count = 0
+			} else {
+				count++
+				if count == r.threshold {
+					r.logger.Error("Endpoint %s is unhealthy: %s", endpoint, err)
+				}
+			}
+		}
+
+		failures[endpoint] = count
+		if count < r.threshold {
+			healthy = append(healthy, discoveredEndpoint)
+		}
+	}
+
+	if len(healthy) == 0 && len(discovered) > 0 {
+		r.logger.Error("All %d endpoints are unhealthy, so probe results are being ignored", len(discovered))
+		healthy = append(healthy, discovered...)
+	}
+
+	changed := !reflect.DeepEqual(w.endpoints, healthy)
+	w.discovered = discovered
+	w.failures = failures
+	w.endpoints = healthy
+	w.values = EndpointValues(healthy)
+	w.lock.Unlock()
+
+	return changed
+}
+
+func (w *probingWatch) signal() {
+	select {
+	case w.event <- struct{}{}:
+	default:
+	}
+}
+
+// close marks this watch as closed, stops its probing, and wakes up any goroutine waiting on it
+func (w *probingWatch) close() {
+	if atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		close(w.shutdown)
+		w.signal()
+	}
+}
+
+func (w *probingWatch) Close() {
+	w.close()
+	w.watch.Close()
+}
+
+func (w *probingWatch) IsClosed() bool {
+	return atomic.LoadInt32(&w.closed) != 0
+}
+
+func (w *probingWatch) Event() <-chan struct{} {
+	return w.event
+}
+
+func (w *probingWatch) Endpoints() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.values
+}
+
+func (w *probingWatch) EndpointsWithMetadata() []Endpoint {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.endpoints
+}
+
+func (w *probingWatch) String() string {
+	return fmt.Sprintf("probingWatch(%v)", w.watch)
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbeOptionsDefault(t *testing.T) {
+	assert := assert.New(t)
+	for _, po := range []*ProbeOptions{nil, new(ProbeOptions)} {
+		assert.NotNil(po.prober())
+		assert.Equal(DefaultProbeInterval, po.interval())
+		assert.Equal(DefaultProbeTimeout, po.timeout())
+		assert.Equal(DefaultProbeFailureThreshold, po.failureThreshold())
+	}
+
+	assert.Nil((*Options)(nil).probe())
+	assert.Nil(new(Options).probe())
+}
+
+func TestProbeOptions(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		called = false
+		po     = ProbeOptions{
+			Interval:         time.Minute,
+			Timeout:          time.Second,
+			FailureThreshold: 3,
+			Prober: ProberFunc(func(context.Context, string) error {
+				called = true
+				return nil
+			}),
+		}
+	)
+
+	assert.Equal(time.Minute, po.interval())
+	assert.Equal(time.Second, po.timeout())
+	assert.Equal(3, po.failureThreshold())
+	assert.NoError(po.prober().Probe(context.Background(), "http://localhost:8080"))
+	assert.True(called)
+	assert.Equal(&po, (&Options{Probe: &po}).probe())
+}
+
+func TestTCPProber(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		prober  = NewTCPProber()
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Close()
+		}
+	}()
+
+	address := listener.Addr().String()
+	assert.NoError(prober.Probe(context.Background(), "http://"+address))
+	assert.NoError(prober.Probe(context.Background(), address))
+	assert.NoError(prober.Probe(context.Background(), ZoneEndpoint("east", "http://"+address)))
+
+	listener.Close()
+	assert.Error(prober.Probe(context.Background(), "http://"+address))
+	assert.Error(prober.Probe(context.Background(), "this is not an endpoint"))
+}
+
+func TestHTTPProber(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			if request.URL.Path == "/health" {
+				response.WriteHeader(http.StatusOK)
+			} else {
+				response.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	)
+
+	defer server.Close()
+	endpoint := ZoneEndpoint("east", server.URL)
+
+	assert.NoError(NewHTTPProber(nil, "health").Probe(context.Background(), endpoint))
+	assert.NoError((&ProbeOptions{Type: HTTPProbe}).prober().Probe(context.Background(), endpoint))
+
+	err := NewHTTPProber(server.Client(), "/ready").Probe(context.Background(), endpoint)
+	if assert.Error(err) {
+		assert.True(strings.Contains(err.Error(), "503"))
+	}
+
+	assert.Error(NewHTTPProber(nil, "/health").Probe(context.Background(), "this is not an endpoint"))
+}
+
+// testProber is a Prober whose results are controlled by test code
+type testProber struct {
+	lock      sync.Mutex
+	unhealthy map[string]bool
+}
+
+func (tp *testProber) set(unhealthy ...string) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	tp.unhealthy = make(map[string]bool, len(unhealthy))
+	for _, endpoint := range unhealthy {
+		tp.unhealthy[endpoint] = true
+	}
+}
+
+func (tp *testProber) Probe(_ context.Context, endpoint string) error {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if tp.unhealthy[endpoint] {
+		return errors.New("expected")
+	}
+
+	return nil
+}
+
+func TestProbingRegistrarWatch(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		resolver            = new(mockSRVResolver)
+		dnsRegistrar, timer = newTestDNSRegistrar(resolver)
+		prober              = new(testProber)
+		probeTimer          = make(chan time.Time)
+		registrar           = NewProbingRegistrar(
+			&Options{Probe: &ProbeOptions{Prober: prober, FailureThreshold: 2}},
+			dnsRegistrar,
+		)
+
+		first  = "http://talaria-1.comcast.net:8080"
+		second = "http://talaria-2.comcast.net:8080"
+		third  = "http://talaria-3.comcast.net:8080"
+
+		waitForEvent = func(watch Watch) {
+			select {
+			case <-watch.Event():
+			case <-time.After(5 * time.Second):
+				require.Fail("No watch event was signalled")
+			}
+		}
+	)
+
+	registrar.after = func(time.Duration) <-chan time.Time { return probeTimer }
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-1.comcast.net.", Port: 8080}, {Target: "talaria-2.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-1.comcast.net.", Port: 8080}, {Target: "talaria-2.comcast.net.", Port: 8080}, {Target: "talaria-3.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	// a single failure is below the threshold
+	prober.set(second)
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	require.NotNil(watch)
+	assert.Equal([]string{first, second}, watch.Endpoints())
+
+	probeTimer <- time.Now()
+	waitForEvent(watch)
+	assert.Equal([]string{first}, watch.Endpoints())
+
+	prober.set()
+	probeTimer <- time.Now()
+	waitForEvent(watch)
+	assert.Equal([]string{first, second}, watch.Endpoints())
+
+	// newly discovered endpoints are probed immediately
+	prober.set(third)
+	timer <- time.Now()
+	waitForEvent(watch)
+	assert.Equal([]string{first, second, third}, watch.Endpoints())
+
+	probeTimer <- time.Now()
+	waitForEvent(watch)
+	assert.Equal([]string{first, second}, watch.Endpoints())
+
+	// when nothing is healthy, probe results are ignored
+	prober.set(first, second, third)
+	probeTimer <- time.Now()
+	probeTimer <- time.Now()
+	waitForEvent(watch)
+	assert.Equal([]string{first, second, third}, watch.Endpoints())
+
+	dnsRegistrar.Stop()
+	waitForEvent(watch)
+	assert.True(watch.IsClosed())
+	resolver.AssertExpectations(t)
+}
+
+func TestProbingRegistrarWatchError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		delegate      = new(mockRegistrar)
+		registrar     = NewProbingRegistrar(nil, delegate)
+	)
+
+	delegate.On("Watch").Return(nil, expectedError).Once()
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(expectedError, err)
+	delegate.AssertExpectations(t)
+}
+
+func TestProbingRegistrarClose(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		resolver  = new(mockSRVResolver)
+		delegate  = newDNSRegistrar(&Options{ServiceName: "talaria", DNS: &DNSOptions{Name: "comcast.net"}}, resolver)
+		registrar = NewProbingRegistrar(&Options{Probe: &ProbeOptions{Prober: new(testProber)}}, delegate)
+	)
+
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-1.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	assert.Equal([]string{"http://talaria-1.comcast.net:8080"}, watch.Endpoints())
+	assert.Equal("probingWatch(dnsWatch(comcast.net))", watch.(*probingWatch).String())
+
+	watch.Close()
+	assert.True(watch.IsClosed())
+	assert.Empty(delegate.watches)
+	<-watch.Event()
+	resolver.AssertExpectations(t)
+}
+
+func TestProbingRegistrarRegisterEndpoint(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		delegate = new(mockMetadataRegistrar)
+		plain    = new(mockRegistrar)
+		metadata = map[string]string{"zone": "east"}
+	)
+
+	delegate.On("RegisterEndpoint", "localhost", 8080, mock.MatchedBy(nilPingFunc)).Return(nil, nil).Once()
+	delegate.On("RegisterEndpointWithMetadata", "localhost", 8080, mock.MatchedBy(nilPingFunc), metadata).Return(nil, nil).Once()
+	plain.On("RegisterEndpoint", "localhost", 8080, mock.MatchedBy(nilPingFunc)).Return(nil, nil).Once()
+
+	registrar := NewProbingRegistrar(nil, delegate)
+	_, err := registrar.RegisterEndpoint("localhost", 8080, nil)
+	assert.NoError(err)
+	_, err = registrar.RegisterEndpointWithMetadata("localhost", 8080, nil, metadata)
+	assert.NoError(err)
+
+	_, err = NewProbingRegistrar(nil, plain).RegisterEndpointWithMetadata("localhost", 8080, nil, metadata)
+	assert.NoError(err)
+
+	delegate.AssertExpectations(t)
+	plain.AssertExpectations(t)
+}
@@ -14,8 +14,13 @@ type RegisteredEndpoints map[string]*serversets.Endpoint
 // AddHostPort handles producing the same endpoint string as produced by Watches
 // and maps that string to the given endpoint object.
 func (r RegisteredEndpoints) AddHostPort(host string, port int, endpoint *serversets.Endpoint) {
+	r[hashHostPort(host, port)] = endpoint
+}
+
+// hashHostPort produces the same endpoint string for a host and port as produced by Watches
+func hashHostPort(host string, port int) string {
 	hashedEndpoint, _ := ParseHostPort(net.JoinHostPort(host, strconv.Itoa(port)))
-	r[hashedEndpoint] = endpoint
+	return hashedEndpoint
 }
 
 // Has simply tests if the given watched endpoint occurs in this mapping.
@@ -35,19 +35,42 @@ func (r *registrar) Watch() (Watch, error) {
 // NewRegistrar produces a serversets.ServerSet using a supplied set of options.
 // Because of limitations with the underlying go.serversets library, this function should
 // be called exactly once for any given process.
+//
+// If the options configure any secondary ensembles, the returned Registrar is a *FailoverRegistrar
+// spanning the primary and all secondaries.  If the options select the ConsulBackend, the returned
+// Registrar is a *ConsulRegistrar instead, and this function can be called any number of times.
+// Likewise, the DNSBackend produces a *DNSRegistrar and the EtcdBackend produces an *EtcdRegistrar.
 func NewRegistrar(o *Options) Registrar {
+	switch o.backend() {
+	case ConsulBackend:
+		return NewConsulRegistrar(o)
+	case DNSBackend:
+		return NewDNSRegistrar(o)
+	case EtcdBackend:
+		return NewEtcdRegistrar(o)
+	}
+
 	// yuck, really? in 2016 people use global variables for configuration?
 	serversets.BaseDirectory = o.baseDirectory()
 	serversets.MemberPrefix = o.memberPrefix()
 
-	serverSet := serversets.New(
-		o.environment(),
-		o.serviceName(),
-		o.servers(),
-	)
+	newServerSet := func(servers []string) Registrar {
+		serverSet := serversets.New(
+			o.environment(),
+			o.serviceName(),
+			servers,
+		)
+
+		serverSet.ZKTimeout = o.timeout()
+		return (*registrar)(serverSet)
+	}
 
-	serverSet.ZKTimeout = o.timeout()
-	return (*registrar)(serverSet)
+	ensembles := o.ensembles()
+	if len(ensembles) > 1 {
+		return newFailoverRegistrar(o, ensembles, newServerSet)
+	}
+
+	return newServerSet(ensembles[0])
 }
 
 var (
@@ -96,43 +119,59 @@ func ParseRegistration(value string) (string, uint16, error) {
 	return host, defaultPorts[scheme], nil
 }
 
-// RegisterAll registers all host:port strings found in o.Registrations.
+// parseRegistrations parses each of the given registrations.  If any are invalid, an error is returned,
+// as the configuration is invalid and no endpoint should be registered.
+func parseRegistrations(registrations []string) ([]string, []int, error) {
+	var (
+		hosts = make([]string, 0, len(registrations))
+		ports = make([]int, 0, len(registrations))
+	)
+
+	for _, registration := range registrations {
+		host, port, err := ParseRegistration(registration)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid registration %s: %s", registration, err)
+		}
+
+		hosts = append(hosts, host)
+		ports = append(ports, int(port))
+	}
+
+	return hosts, ports, nil
+}
+
+// registerEndpoint registers a single endpoint, publishing the metadata of o.Identity() if the
+// registrar is a MetadataRegistrar
+func registerEndpoint(registrar Registrar, o *Options, host string, port int) (*serversets.Endpoint, error) {
+	o.logger().Info("Registering endpoint: %s:%d", host, port)
+	if metadataRegistrar, ok := registrar.(MetadataRegistrar); ok {
+		return metadataRegistrar.RegisterEndpointWithMetadata(host, port, o.pingFunc(), o.Identity().Metadata)
+	}
+
+	return registrar.RegisterEndpoint(host, port, o.pingFunc())
+}
+
+// RegisterAll registers all host:port strings found in o.Registrations.  If the registrar is a
+// MetadataRegistrar, the metadata of o.Identity() is published with each endpoint.
+//
+// Registrations made by this function are not monitored.  Use a RegistrationMonitor to have endpoints
+// registered again if they drop out of service discovery.
 func RegisterAll(registrar Registrar, o *Options) (RegisteredEndpoints, error) {
 	registrations := o.registrations()
 	if len(registrations) > 0 {
-		var (
-			hosts = make([]string, 0, len(registrations))
-			ports = make([]int, 0, len(registrations))
-		)
-
-		// first, parse all the registrations.  if any are invalid, we
-		// don't want to register any endpoint as the configuration is invalid.
-		for _, registration := range registrations {
-			host, port, err := ParseRegistration(registration)
-			if err != nil {
-				return nil, fmt.Errorf("Invalid registration %s: %s", registration, err)
-			}
-
-			hosts = append(hosts, host)
-			ports = append(ports, int(port))
+		hosts, ports, err := parseRegistrations(registrations)
+		if err != nil {
+			return nil, err
 		}
 
-		var (
-			logger    = o.logger()
-			pingFunc  = o.pingFunc()
-			endpoints = make(RegisteredEndpoints, len(registrations))
-		)
-
+		endpoints := make(RegisteredEndpoints, len(registrations))
 		for index, host := range hosts {
-			port := ports[index]
-			logger.Info("Registering endpoint: %s:%d", host, port)
-
-			registeredEndpoint, err := registrar.RegisterEndpoint(host, port, pingFunc)
+			registeredEndpoint, err := registerEndpoint(registrar, o, host, ports[index])
 			if err != nil {
 				return endpoints, err
 			}
 
-			endpoints.AddHostPort(host, port, registeredEndpoint)
+			endpoints.AddHostPort(host, ports[index], registeredEndpoint)
 		}
 
 		return endpoints, nil
@@ -0,0 +1,489 @@
+package service
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/strava/go.serversets"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRegistrationCheckInterval  = 30 * time.Second
+	DefaultRegistrationConfirmTimeout = time.Minute
+	DefaultReregisterMinBackoff       = time.Second
+	DefaultReregisterMaxBackoff       = 2 * time.Minute
+)
+
+// RegistrationState describes whether this server's registered endpoints are visible in service discovery
+type RegistrationState int
+
+const (
+	// RegistrationActive indicates that every registered endpoint is visible
+	RegistrationActive RegistrationState = iota
+
+	// RegistrationLost indicates that one or more registered endpoints have dropped out of service
+	// discovery, e.g. due to a Zookeeper session expiring or Consul deregistering a critical service
+	RegistrationLost
+
+	// RegistrationFailed indicates that an attempt to register lost endpoints again failed.  Another
+	// attempt is made after a backoff.
+	RegistrationFailed
+
+	// Reregistered indicates that lost endpoints were registered again.  The state becomes
+	// RegistrationActive once the endpoints are visible through the watch.
+	Reregistered
+)
+
+func (s RegistrationState) String() string {
+	switch s {
+	case RegistrationActive:
+		return "active"
+	case RegistrationLost:
+		return "lost"
+	case RegistrationFailed:
+		return "failed"
+	case Reregistered:
+		return "reregistered"
+	default:
+		return "unknown"
+	}
+}
+
+// RegistrationEvent describes a change in the state of this server's registrations
+type RegistrationEvent struct {
+	State RegistrationState
+
+	// Missing holds the registered endpoints, in the form produced by ParseHostPort, that were not
+	// visible in service discovery.  This field is empty for RegistrationActive events.
+	Missing []string
+
+	// Attempt is the number of re-registration attempts made since the endpoints were lost
+	Attempt int
+
+	// Err is the error from a failed re-registration, and is only set for RegistrationFailed events
+	Err error
+}
+
+// monitoredRegistration tracks a single endpoint registered by a RegistrationMonitor
+type monitoredRegistration struct {
+	host         string
+	port         int
+	endpoint     *serversets.Endpoint
+	registeredAt time.Time
+	seen         bool
+}
+
+// RegistrationMonitor registers this server's endpoints and then watches service discovery to make sure they
+// stay registered.  If a registered endpoint drops out of the watch, as happens when a Zookeeper session
+// expires or Consul deregisters a service whose check went critical, the endpoint is registered again after
+// a jittered exponential backoff.  A closed watch is likewise reopened.
+//
+// Keeping a registration alive while it is visible remains the job of the backend:  Consul and etcd refresh
+// their TTLs with a heartbeat, while Zookeeper uses ephemeral nodes.  RegistrationMonitor only heals
+// registrations that the backend has lost.  The DNSBackend does not register endpoints, so this type should
+// not be used with it.
+type RegistrationMonitor struct {
+	// Logger is the optional Logger used by this monitor.  If not supplied, o.Logger is used.
+	Logger logging.Logger
+
+	// Registrar is the service registration component used both to register and to watch endpoints
+	Registrar Registrar
+
+	// Options supplies the registrations, the PingFunc, and the Identity metadata, exactly as with RegisterAll
+	Options *Options
+
+	// CheckInterval is how often the watch is examined in the absence of watch events.  If not positive,
+	// DefaultRegistrationCheckInterval is used.
+	CheckInterval time.Duration
+
+	// ConfirmTimeout is how long a newly registered endpoint may take to appear in the watch.  An endpoint
+	// that has never been seen is only considered lost once this interval has elapsed.  If not positive,
+	// DefaultRegistrationConfirmTimeout is used.
+	ConfirmTimeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay before each re-registration attempt.  The delay starts at
+	// MinBackoff and doubles with each attempt up to MaxBackoff, with up to half of it randomized so that
+	// a fleet of servers does not re-register in lockstep.  Nonpositive values select DefaultReregisterMinBackoff
+	// and DefaultReregisterMaxBackoff, respectively.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Listener is the optional sink for changes in registration state.  It is invoked on the monitor's
+	// goroutine, so it should return quickly.  A panic in the Listener is recovered and logged.
+	Listener func(RegistrationEvent)
+
+	// After is an optional function which is used to produce a time channel for delays.
+	// If this field is nil, time.After is used.
+	After func(time.Duration) <-chan time.Time
+
+	mutex         sync.Mutex
+	registrations map[string]*monitoredRegistration
+	state         RegistrationState
+	shutdown      chan struct{}
+	stopped       chan struct{}
+	now           func() time.Time
+}
+
+func (m *RegistrationMonitor) logger() logging.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+
+	return m.Options.logger()
+}
+
+func (m *RegistrationMonitor) after() func(time.Duration) <-chan time.Time {
+	if m.After != nil {
+		return m.After
+	}
+
+	return time.After
+}
+
+func (m *RegistrationMonitor) checkInterval() time.Duration {
+	if m.CheckInterval > 0 {
+		return m.CheckInterval
+	}
+
+	return DefaultRegistrationCheckInterval
+}
+
+func (m *RegistrationMonitor) confirmTimeout() time.Duration {
+	if m.ConfirmTimeout > 0 {
+		return m.ConfirmTimeout
+	}
+
+	return DefaultRegistrationConfirmTimeout
+}
+
+// backoff returns the jittered delay before the given attempt, where the first attempt is 0
+func (m *RegistrationMonitor) backoff(attempt int) time.Duration {
+	var (
+		delay = m.MinBackoff
+		max   = m.MaxBackoff
+	)
+
+	if delay <= 0 {
+		delay = DefaultReregisterMinBackoff
+	}
+
+	if max <= 0 {
+		max = DefaultReregisterMaxBackoff
+	}
+
+	for ; attempt > 0 && delay < max; attempt-- {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Endpoints returns the endpoints currently registered by this monitor.  The returned map is a copy,
+// and changes as endpoints are registered again.  This method returns nil if this monitor has
+// not been run.
+func (m *RegistrationMonitor) Endpoints() RegisteredEndpoints {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.registrations == nil {
+		return nil
+	}
+
+	endpoints := make(RegisteredEndpoints, len(m.registrations))
+	for key, r := range m.registrations {
+		endpoints[key] = r.endpoint
+	}
+
+	return endpoints
+}
+
+// State returns the most recent registration state
+func (m *RegistrationMonitor) State() RegistrationState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.state
+}
+
+// Run registers all host:port strings found in Options.Registrations, in the same way as RegisterAll, then
+// starts monitoring them.  This method is idempotent, and returns ErrorAlreadyRunning if this instance is
+// already running.  Running a cancelled monitor again resumes monitoring the endpoints it has already
+// registered.  If any endpoint cannot be registered or the watch cannot be created, the error is returned
+// and the monitor is not started.
+func (m *RegistrationMonitor) Run() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.shutdown != nil {
+		return ErrorAlreadyRunning
+	}
+
+	if m.now == nil {
+		m.now = time.Now
+	}
+
+	if m.registrations == nil {
+		registrations, err := m.registerAll()
+		if err != nil {
+			return err
+		}
+
+		m.registrations = registrations
+	}
+
+	watch, err := m.Registrar.Watch()
+	if err != nil {
+		return err
+	}
+
+	m.state = RegistrationActive
+	m.shutdown = make(chan struct{})
+	m.stopped = make(chan struct{})
+	go m.monitor(watch, m.shutdown, m.stopped)
+	return nil
+}
+
+// registerAll performs the initial registration of each endpoint in Options.Registrations
+func (m *RegistrationMonitor) registerAll() (map[string]*monitoredRegistration, error) {
+	hosts, ports, err := parseRegistrations(m.Options.registrations())
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := make(map[string]*monitoredRegistration, len(hosts))
+	for index, host := range hosts {
+		endpoint, err := registerEndpoint(m.Registrar, m.Options, host, ports[index])
+		if err != nil {
+			return nil, err
+		}
+
+		registrations[hashHostPort(host, ports[index])] = &monitoredRegistration{
+			host:         host,
+			port:         ports[index],
+			endpoint:     endpoint,
+			registeredAt: m.now(),
+		}
+	}
+
+	return registrations, nil
+}
+
+// Cancel stops monitoring registrations.  The endpoints remain registered:  clients deregister them by
+// closing the Endpoints or stopping the Registrar, as with RegisterAll.  This method returns ErrorNotRunning
+// if this monitor is not running.
+func (m *RegistrationMonitor) Cancel() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.shutdown == nil {
+		return ErrorNotRunning
+	}
+
+	close(m.shutdown)
+	m.shutdown = nil
+	return nil
+}
+
+// dispatch records a new state and notifies the Listener
+func (m *RegistrationMonitor) dispatch(event RegistrationEvent) {
+	m.mutex.Lock()
+	m.state = event.State
+	m.mutex.Unlock()
+
+	if m.Listener == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logging.ReportPanic(m.logger(), "Registration listener panicked", r)
+		}
+	}()
+
+	m.Listener(event)
+}
+
+// missing compares the watched endpoints against the registrations.  The registered endpoints that are
+// considered lost are returned in sorted order, along with the count of endpoints registered too recently
+// to have been confirmed.
+func (m *RegistrationMonitor) missing(watched []string) ([]string, int) {
+	visible := make(map[string]bool, len(watched))
+	for _, value := range watched {
+		if hashed, err := ParseHostPort(value); err == nil {
+			visible[hashed] = true
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var (
+		lost        []string
+		unconfirmed int
+		now         = m.now()
+	)
+
+	for key, r := range m.registrations {
+		switch {
+		case visible[key]:
+			r.seen = true
+		case r.seen || now.Sub(r.registeredAt) >= m.confirmTimeout():
+			lost = append(lost, key)
+		default:
+			unconfirmed++
+		}
+	}
+
+	sort.Strings(lost)
+	return lost, unconfirmed
+}
+
+// reregister registers the given endpoints again, first closing any previous registration so that
+// a backend which still holds it does not end up with duplicates
+func (m *RegistrationMonitor) reregister(keys []string) error {
+	for _, key := range keys {
+		m.mutex.Lock()
+		r := m.registrations[key]
+		m.mutex.Unlock()
+
+		if r.endpoint != nil {
+			r.endpoint.Close()
+		}
+
+		endpoint, err := registerEndpoint(m.Registrar, m.Options, r.host, r.port)
+
+		m.mutex.Lock()
+		r.endpoint, r.seen = endpoint, false
+		if err == nil {
+			r.registeredAt = m.now()
+		}
+
+		m.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// monitor is a goroutine that examines the watch for this monitor's registrations, registering
+// lost endpoints again and reopening the watch when it closes
+func (m *RegistrationMonitor) monitor(watch Watch, shutdown <-chan struct{}, stopped chan<- struct{}) {
+	var (
+		logger = m.logger()
+		after  = m.after()
+
+		check  = after(m.checkInterval())
+		retry  <-chan time.Time
+		reopen <-chan time.Time
+
+		lost          []string
+		attempts      int
+		watchAttempts int
+
+		verify = func() {
+			var unconfirmed int
+			lost, unconfirmed = m.missing(watch.Endpoints())
+			if len(lost) > 0 {
+				if m.State() == RegistrationActive {
+					logger.Error("Registered endpoints missing from service discovery: %v", lost)
+					m.dispatch(RegistrationEvent{State: RegistrationLost, Missing: lost, Attempt: attempts})
+				}
+
+				if retry == nil {
+					retry = after(m.backoff(attempts))
+				}
+
+				return
+			}
+
+			// nothing is lost, so there is nothing to retry while any new registrations are confirmed
+			retry = nil
+			if unconfirmed == 0 && m.State() != RegistrationActive {
+				logger.Info("All registered endpoints are visible in service discovery")
+				attempts = 0
+				m.dispatch(RegistrationEvent{State: RegistrationActive})
+			}
+		}
+	)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logging.ReportPanic(logger, "Registration monitor ending due to panic", r)
+		}
+
+		if watch != nil {
+			watch.Close()
+		}
+
+		close(stopped)
+	}()
+
+	logger.Info("Monitoring registrations using: %v", watch)
+	verify()
+
+	for {
+		var events <-chan struct{}
+		if watch != nil {
+			events = watch.Event()
+		}
+
+		select {
+		case <-shutdown:
+			logger.Info("Registration monitor ending because it was cancelled")
+			return
+
+		case <-events:
+			if watch.IsClosed() {
+				logger.Error("Registration watch was closed")
+				watch, reopen = nil, after(m.backoff(watchAttempts))
+				continue
+			}
+
+			verify()
+
+		case <-check:
+			if watch != nil {
+				verify()
+			}
+
+			check = after(m.checkInterval())
+
+		case <-reopen:
+			reopen = nil
+			newWatch, err := m.Registrar.Watch()
+			if err == ErrorStopped {
+				logger.Info("Registration monitor ending because the registrar was stopped")
+				return
+			} else if err != nil {
+				watchAttempts++
+				logger.Error("Unable to reopen registration watch: %s", err)
+				reopen = after(m.backoff(watchAttempts))
+				continue
+			}
+
+			watch, watchAttempts = newWatch, 0
+			verify()
+
+		case <-retry:
+			retry = nil
+			attempts++
+			logger.Info("Registering lost endpoints again, attempt %d: %v", attempts, lost)
+			if err := m.reregister(lost); err == ErrorStopped {
+				logger.Info("Registration monitor ending because the registrar was stopped")
+				return
+			} else if err != nil {
+				logger.Error("Unable to register lost endpoints again: %s", err)
+				m.dispatch(RegistrationEvent{State: RegistrationFailed, Missing: lost, Attempt: attempts, Err: err})
+				retry = after(m.backoff(attempts))
+				continue
+			}
+
+			m.dispatch(RegistrationEvent{State: Reregistered, Missing: lost, Attempt: attempts})
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistrationState(t *testing.T) {
+	assert := assert.New(t)
+	for state, expected := range map[RegistrationState]string{
+		RegistrationActive:    "active",
+		RegistrationLost:      "lost",
+		RegistrationFailed:    "failed",
+		Reregistered:          "reregistered",
+		RegistrationState(-1): "unknown",
+	} {
+		assert.Equal(expected, state.String())
+	}
+}
+
+func TestRegistrationMonitorBackoff(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		monitor = &RegistrationMonitor{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	)
+
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		for repeat := 0; repeat < 10; repeat++ {
+			actual := monitor.backoff(attempt)
+			assert.True(actual >= expected/2 && actual <= expected, "attempt %d produced %s", attempt, actual)
+		}
+	}
+
+	monitor = new(RegistrationMonitor)
+	actual := monitor.backoff(0)
+	assert.True(actual >= DefaultReregisterMinBackoff/2 && actual <= DefaultReregisterMinBackoff)
+	actual = monitor.backoff(100)
+	assert.True(actual >= DefaultReregisterMaxBackoff/2 && actual <= DefaultReregisterMaxBackoff)
+}
+
+// registrationMonitorTest collects the delays and events of a RegistrationMonitor under test
+type registrationMonitorTest struct {
+	t         *testing.T
+	checks    chan chan time.Time
+	delays    chan chan time.Time
+	events    chan RegistrationEvent
+	lock      sync.Mutex
+	now       time.Time
+	registrar *mockRegistrar
+}
+
+func newRegistrationMonitorTest(t *testing.T, registrations ...string) (*registrationMonitorTest, *RegistrationMonitor) {
+	test := &registrationMonitorTest{
+		t:         t,
+		checks:    make(chan chan time.Time, 10),
+		delays:    make(chan chan time.Time, 10),
+		events:    make(chan RegistrationEvent, 10),
+		now:       time.Now(),
+		registrar: new(mockRegistrar),
+	}
+
+	monitor := &RegistrationMonitor{
+		Registrar:      test.registrar,
+		Options:        &Options{Logger: logging.TestLogger(t), Registrations: registrations},
+		CheckInterval:  time.Hour,
+		ConfirmTimeout: time.Minute,
+		Listener:       func(e RegistrationEvent) { test.events <- e },
+		After: func(d time.Duration) <-chan time.Time {
+			c := make(chan time.Time, 1)
+			if d == time.Hour {
+				test.checks <- c
+			} else {
+				test.delays <- c
+			}
+
+			return c
+		},
+		now: test.currentTime,
+	}
+
+	return test, monitor
+}
+
+func (test *registrationMonitorTest) currentTime() time.Time {
+	test.lock.Lock()
+	defer test.lock.Unlock()
+	return test.now
+}
+
+func (test *registrationMonitorTest) advance(d time.Duration) {
+	test.lock.Lock()
+	test.now = test.now.Add(d)
+	test.lock.Unlock()
+}
+
+func (test *registrationMonitorTest) expectRegistration(host string, port int, err error) {
+	test.registrar.On("RegisterEndpoint", host, port, mock.MatchedBy(nilPingFunc)).Return(nil, err).Once()
+}
+
+func (test *registrationMonitorTest) nextCheck() chan time.Time {
+	select {
+	case c := <-test.checks:
+		return c
+	case <-time.After(5 * time.Second):
+		require.Fail(test.t, "No check was scheduled")
+		return nil
+	}
+}
+
+func (test *registrationMonitorTest) nextDelay() chan time.Time {
+	select {
+	case c := <-test.delays:
+		return c
+	case <-time.After(5 * time.Second):
+		require.Fail(test.t, "No delay was scheduled")
+		return nil
+	}
+}
+
+// cancel stops the monitor, waiting for its goroutine to exit so that nothing is logged after the test
+func (test *registrationMonitorTest) cancel(monitor *RegistrationMonitor) {
+	monitor.mutex.Lock()
+	stopped := monitor.stopped
+	monitor.mutex.Unlock()
+
+	require.NoError(test.t, monitor.Cancel())
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		require.Fail(test.t, "The monitor did not stop")
+	}
+}
+
+func (test *registrationMonitorTest) nextEvent() RegistrationEvent {
+	select {
+	case e := <-test.events:
+		return e
+	case <-time.After(5 * time.Second):
+		require.Fail(test.t, "No registration event was dispatched")
+		return RegistrationEvent{}
+	}
+}
+
+func testRegistrationMonitorReregister(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		test, monitor = newRegistrationMonitorTest(t, "http://host1:8080", "host2:9090")
+		watch         = newFakeWatch("host1:8080", "host2:9090")
+		expectedError = errors.New("expected")
+	)
+
+	test.expectRegistration("http://host1", 8080, nil)
+	test.expectRegistration("http://host2", 9090, nil)
+	test.registrar.On("Watch").Return(watch, nil).Once()
+
+	require.NoError(monitor.Run())
+	assert.Equal(ErrorAlreadyRunning, monitor.Run())
+	assert.Equal(RegisteredEndpoints{"http://host1:8080": nil, "http://host2:9090": nil}, monitor.Endpoints())
+	test.nextCheck()
+
+	watch.set("host1:8080")
+	assert.Equal(RegistrationEvent{State: RegistrationLost, Missing: []string{"http://host2:9090"}}, test.nextEvent())
+	assert.Equal(RegistrationLost, monitor.State())
+
+	test.expectRegistration("http://host2", 9090, expectedError)
+	test.nextDelay() <- time.Now()
+	assert.Equal(
+		RegistrationEvent{State: RegistrationFailed, Missing: []string{"http://host2:9090"}, Attempt: 1, Err: expectedError},
+		test.nextEvent(),
+	)
+
+	test.expectRegistration("http://host2", 9090, nil)
+	test.nextDelay() <- time.Now()
+	assert.Equal(RegistrationEvent{State: Reregistered, Missing: []string{"http://host2:9090"}, Attempt: 2}, test.nextEvent())
+
+	watch.set("host1:8080", "host2:9090")
+	assert.Equal(RegistrationEvent{State: RegistrationActive}, test.nextEvent())
+	assert.Equal(RegistrationActive, monitor.State())
+
+	test.cancel(monitor)
+	assert.Equal(ErrorNotRunning, monitor.Cancel())
+	test.registrar.AssertExpectations(t)
+}
+
+func testRegistrationMonitorUnconfirmed(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		test, monitor = newRegistrationMonitorTest(t, "host1:8080")
+		watch         = newFakeWatch()
+	)
+
+	test.expectRegistration("http://host1", 8080, nil)
+	test.registrar.On("Watch").Return(watch, nil).Once()
+
+	require.NoError(monitor.Run())
+	defer test.cancel(monitor)
+
+	// a registration that has never been seen is not lost until the confirmation timeout elapses
+	test.nextCheck() <- time.Now()
+	check := test.nextCheck()
+	assert.Empty(test.events)
+	assert.Empty(test.delays)
+
+	test.advance(time.Minute)
+	check <- time.Now()
+	assert.Equal(RegistrationEvent{State: RegistrationLost, Missing: []string{"http://host1:8080"}}, test.nextEvent())
+
+	test.expectRegistration("http://host1", 8080, nil)
+	test.nextDelay() <- time.Now()
+	assert.Equal(RegistrationEvent{State: Reregistered, Missing: []string{"http://host1:8080"}, Attempt: 1}, test.nextEvent())
+
+	// the new registration gets its own confirmation timeout, so a check does not retry right away
+	test.nextCheck() <- time.Now()
+	check = test.nextCheck()
+	assert.Empty(test.events)
+	assert.Empty(test.delays)
+
+	test.advance(time.Minute)
+	check <- time.Now()
+	test.nextDelay()
+	assert.Empty(test.events)
+
+	watch.set("host1:8080")
+	assert.Equal(RegistrationEvent{State: RegistrationActive}, test.nextEvent())
+	test.registrar.AssertExpectations(t)
+}
+
+func testRegistrationMonitorWatchClosed(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		test, monitor = newRegistrationMonitorTest(t, "host1:8080")
+		first         = newFakeWatch("host1:8080")
+		second        = newFakeWatch()
+		expectedError = errors.New("expected")
+	)
+
+	test.expectRegistration("http://host1", 8080, nil)
+	test.registrar.On("Watch").Return(first, nil).Once()
+
+	require.NoError(monitor.Run())
+	test.nextCheck()
+
+	first.close()
+	test.registrar.On("Watch").Return(nil, expectedError).Once()
+	test.nextDelay() <- time.Now()
+
+	// the session that closed the watch took the registration with it
+	test.registrar.On("Watch").Return(second, nil).Once()
+	test.nextDelay() <- time.Now()
+	assert.Equal(RegistrationEvent{State: RegistrationLost, Missing: []string{"http://host1:8080"}}, test.nextEvent())
+
+	test.expectRegistration("http://host1", 8080, nil)
+	test.nextDelay() <- time.Now()
+	assert.Equal(RegistrationEvent{State: Reregistered, Missing: []string{"http://host1:8080"}, Attempt: 1}, test.nextEvent())
+
+	second.set("host1:8080")
+	assert.Equal(RegistrationEvent{State: RegistrationActive}, test.nextEvent())
+
+	test.cancel(monitor)
+	test.registrar.AssertExpectations(t)
+}
+
+func testRegistrationMonitorStopped(t *testing.T) {
+	var (
+		require       = require.New(t)
+		test, monitor = newRegistrationMonitorTest(t, "host1:8080")
+		watch         = newFakeWatch("host1:8080")
+	)
+
+	test.expectRegistration("http://host1", 8080, nil)
+	test.registrar.On("Watch").Return(watch, nil).Once()
+
+	require.NoError(monitor.Run())
+	defer test.cancel(monitor)
+	test.nextCheck()
+
+	watch.close()
+	test.registrar.On("Watch").Return(nil, ErrorStopped).Once()
+	test.nextDelay() <- time.Now()
+
+	// the monitor must stop rather than retrying a stopped registrar
+	select {
+	case <-test.delays:
+		require.Fail("The monitor should not retry a stopped registrar")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	test.registrar.AssertExpectations(t)
+}
+
+func testRegistrationMonitorRunError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+	)
+
+	t.Run("Registration", func(t *testing.T) {
+		test, monitor := newRegistrationMonitorTest(t, "host1:8080")
+		test.expectRegistration("http://host1", 8080, expectedError)
+		assert.Equal(expectedError, monitor.Run())
+		assert.Nil(monitor.Endpoints())
+		test.registrar.AssertExpectations(t)
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		test, monitor := newRegistrationMonitorTest(t, "host1:8080")
+		test.expectRegistration("http://host1", 8080, nil)
+		test.registrar.On("Watch").Return(nil, expectedError).Once()
+		assert.Equal(expectedError, monitor.Run())
+		assert.Equal(ErrorNotRunning, monitor.Cancel())
+
+		// the endpoints are not registered again on the next run
+		test.registrar.On("Watch").Return(newFakeWatch("host1:8080"), nil).Once()
+		assert.NoError(monitor.Run())
+		test.cancel(monitor)
+		test.registrar.AssertExpectations(t)
+	})
+}
+
+func TestRegistrationMonitor(t *testing.T) {
+	t.Run("Reregister", testRegistrationMonitorReregister)
+	t.Run("Unconfirmed", testRegistrationMonitorUnconfirmed)
+	t.Run("WatchClosed", testRegistrationMonitorWatchClosed)
+	t.Run("Stopped", testRegistrationMonitorStopped)
+	t.Run("RunError", testRegistrationMonitorRunError)
+}
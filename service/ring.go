@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrorNoEndpoints = errors.New("No endpoints are available")
+)
+
+// ringHash computes the position of a key on a hash ring.  FNV-1a is cheap enough to run for every
+// Get, but it does not spread similar inputs, such as the vnode names of a single endpoint, well.
+// So, its result is passed through the splitmix64 finalizer to distribute points evenly around the ring.
+func ringHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// ringPoint is a single vnode on a hash ring
+type ringPoint struct {
+	hash    uint64
+	baseURL string
+}
+
+// ring is an immutable hash ring, sorted by point.  Each base URL owns vnodeCount points, and a key
+// is owned by the first point at or after the key's hash, wrapping around at the end of the ring.
+//
+// Because the points owned by a base URL depend only on that base URL, adding or removing an endpoint
+// moves only the keys owned by that endpoint's points.  All other keys map to the same endpoint
+// before and after the change.
+type ring []ringPoint
+
+func (r ring) Len() int {
+	return len(r)
+}
+
+func (r ring) Less(i, j int) bool {
+	if r[i].hash == r[j].hash {
+		// break ties deterministically, so that the ring does not depend on insertion order
+		return r[i].baseURL < r[j].baseURL
+	}
+
+	return r[i].hash < r[j].hash
+}
+
+func (r ring) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r ring) Get(key []byte) (string, error) {
+	if len(r) == 0 {
+		return "", ErrorNoEndpoints
+	}
+
+	hash := ringHash(key)
+	i := sort.Search(len(r), func(i int) bool { return r[i].hash >= hash })
+	if i == len(r) {
+		i = 0
+	}
+
+	return r[i].baseURL, nil
+}
+
+// NewRingAccessorFactory produces a WeightedAccessorFactory whose Accessors are hash rings with Options.VnodeCount
+// points for each endpoint of DefaultWeight.  Unlike the default AccessorFactory, the ring's hashing is implemented
+// in this package, so the mapping of keys to endpoints is stable across releases of any third party library.
+func NewRingAccessorFactory(o *Options) AccessorFactory {
+	return &ringFactory{
+		logger:     o.logger(),
+		vnodeCount: o.vnodeCount(),
+	}
+}
+
+// ringFactory is the AccessorFactory that creates rings
+type ringFactory struct {
+	logger     logging.Logger
+	vnodeCount int
+}
+
+func (f *ringFactory) New(endpoints []string) (Accessor, []string) {
+	return f.NewWeighted(NewEndpoints(endpoints))
+}
+
+// NewWeighted creates a ring in which each base URL owns a share of Options.VnodeCount points proportional
+// to its weight, relative to DefaultWeight.  Endpoints with a weight of 0 own no points, unless every
+// endpoint has a weight of 0, in which case the weights are ignored rather than leaving no endpoint for any key.
+//
+// A base URL always owns the same points for a given weight, and raising its weight only adds points.  So,
+// shifting weights between endpoints moves keys only to and from the endpoints whose weights changed.
+func (f *ringFactory) NewWeighted(endpoints []Endpoint) (Accessor, []string) {
+	var (
+		baseURLs = make([]string, 0, len(endpoints))
+		weights  = make(map[string]int, len(endpoints))
+		total    int
+	)
+
+	for _, endpoint := range endpoints {
+		baseURL, err := ParseHostPort(endpoint.Value)
+		if err != nil {
+			f.logger.Error("Skipping bad endpoint [%s]: %s", endpoint.Value, err)
+			continue
+		}
+
+		if _, ok := weights[baseURL]; !ok {
+			weight := endpoint.Weight()
+			weights[baseURL] = weight
+			total += weight
+			baseURLs = append(baseURLs, baseURL)
+		}
+	}
+
+	if total == 0 && len(baseURLs) > 0 {
+		f.logger.Error("All %d endpoints have a weight of 0, so weights are being ignored", len(baseURLs))
+		for baseURL := range weights {
+			weights[baseURL] = DefaultWeight
+		}
+	}
+
+	sort.Strings(baseURLs)
+	var (
+		r        = make(ring, 0, len(baseURLs)*f.vnodeCount)
+		weighted = baseURLs[:0]
+		vnode    []byte
+	)
+
+	for _, baseURL := range baseURLs {
+		weight := weights[baseURL]
+		if weight == 0 {
+			continue
+		}
+
+		// every endpoint with a nonzero weight owns at least one point
+		points := f.vnodeCount * weight / DefaultWeight
+		if points < 1 {
+			points = 1
+		}
+
+		for i := 0; i < points; i++ {
+			vnode = strconv.AppendInt(append(append(vnode[:0], baseURL...), '#'), int64(i), 10)
+			r = append(r, ringPoint{ringHash(vnode), baseURL})
+		}
+
+		weighted = append(weighted, baseURL)
+	}
+
+	sort.Sort(r)
+	return r, weighted
+}
+
+// NewRingAccessor produces an UpdatableAccessor backed by a hash ring from NewRingAccessorFactory.
+// As with NewUpdatableAccessor, the returned accessor's Update method may be used as a Subscription.Listener,
+// and its UpdateEndpoints method may be used as a Subscription.EndpointListener to route keys by weight.
+// Each update replaces the ring atomically, and the keys owned by endpoints present both before and after
+// the update continue to map to those endpoints.
+//
+// The initialEndpoints slice can be empty, in which case Get returns ErrorNoEndpoints until Update is called
+// with a nonempty slice.
+func NewRingAccessor(o *Options, initialEndpoints []string) UpdatableAccessor {
+	accessor := &updatableAccessor{
+		logger:  o.logger(),
+		factory: NewRingAccessorFactory(o),
+	}
+
+	accessor.Update(initialEndpoints)
+	return accessor
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		empty  ring
+	)
+
+	value, err := empty.Get([]byte("key"))
+	assert.Empty(value)
+	assert.Equal(ErrorNoEndpoints, err)
+
+	// a ring with points on either side of a key's hash, to verify wrapping
+	point := ringHash([]byte("key"))
+	r := ring{{point - 1, "before"}, {point, "at"}, {point + 1, "after"}}
+	assert.True(sort.IsSorted(r))
+
+	value, err = r.Get([]byte("key"))
+	assert.Equal("at", value)
+	assert.NoError(err)
+
+	value, err = r[:1].Get([]byte("key"))
+	assert.Equal("before", value)
+	assert.NoError(err)
+
+	value, err = r[2:].Get([]byte("key"))
+	assert.Equal("after", value)
+	assert.NoError(err)
+
+	tied := ring{{1, "b"}, {1, "a"}}
+	sort.Sort(tied)
+	assert.Equal(ring{{1, "a"}, {1, "b"}}, tied)
+}
+
+func TestRingFactory(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		factory = NewRingAccessorFactory(&Options{Logger: logging.TestLogger(t), VnodeCount: 10})
+	)
+
+	require.NotNil(factory)
+	accessor, baseURLs := factory.New([]string{"host2:80", "host1:8080", "this.is.not.valid", "host2:80"})
+	require.NotNil(accessor)
+	assert.Equal([]string{"http://host1:8080", "http://host2:80"}, baseURLs)
+
+	r, ok := accessor.(ring)
+	require.True(ok)
+	assert.Len(r, 20)
+	assert.True(sort.IsSorted(r))
+
+	accessor, baseURLs = factory.New([]string{"this.is.not.valid", "neither.is.this"})
+	assert.Empty(baseURLs)
+	value, err := accessor.Get([]byte("key"))
+	assert.Empty(value)
+	assert.Equal(ErrorNoEndpoints, err)
+}
+
+func TestRingFactoryDefaultVnodeCount(t *testing.T) {
+	assert := assert.New(t)
+	accessor, _ := NewRingAccessorFactory(nil).New([]string{"host1:8080"})
+	assert.Len(accessor, DefaultVnodeCount)
+}
+
+// ringOwners maps each of a set of test keys onto its endpoint
+func ringOwners(t *testing.T, accessor Accessor, keyCount int) map[string]string {
+	owners := make(map[string]string, keyCount)
+	for i := 0; i < keyCount; i++ {
+		key := fmt.Sprintf("mac:%012x", i)
+		owner, err := accessor.Get([]byte(key))
+		require.NoError(t, err)
+		owners[key] = owner
+	}
+
+	return owners
+}
+
+func TestNewRingAccessorChurn(t *testing.T) {
+	const keyCount = 10000
+
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		accessor = NewRingAccessor(nil, []string{"host1:80", "host2:80", "host3:80", "host4:80"})
+	)
+
+	require.NotNil(accessor)
+	before := ringOwners(t, accessor, keyCount)
+
+	// each endpoint gets a reasonable share of the keys
+	shares := make(map[string]int)
+	for _, owner := range before {
+		shares[owner]++
+	}
+
+	assert.Len(shares, 4)
+	for owner, share := range shares {
+		assert.InDelta(keyCount/4, share, keyCount/10, "endpoint %s", owner)
+	}
+
+	// the ring does not depend on the order of the endpoints
+	accessor.Update([]string{"host4:80", "host3:80", "host2:80", "host1:80"})
+	assert.Equal(before, ringOwners(t, accessor, keyCount))
+
+	// adding an endpoint only moves keys onto that endpoint
+	accessor.Update([]string{"host1:80", "host2:80", "host3:80", "host4:80", "host5:80"})
+	added := ringOwners(t, accessor, keyCount)
+	moved := 0
+	for key, owner := range added {
+		if owner != before[key] {
+			moved++
+			assert.Equal("http://host5:80", owner)
+		}
+	}
+
+	assert.InDelta(keyCount/5, moved, keyCount/10)
+
+	// removing an endpoint only moves the keys that endpoint owned
+	accessor.Update([]string{"host1:80", "host3:80", "host4:80", "host5:80"})
+	for key, owner := range ringOwners(t, accessor, keyCount) {
+		if added[key] != "http://host2:80" {
+			assert.Equal(added[key], owner)
+		} else {
+			assert.NotEqual("http://host2:80", owner)
+		}
+	}
+
+	accessor.Update(nil)
+	value, err := accessor.Get([]byte("key"))
+	assert.Empty(value)
+	assert.Equal(ErrorNoEndpoints, err)
+}
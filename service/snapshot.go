@@ -0,0 +1,91 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// EndpointStore is the persistence strategy for the last-known endpoints of a service.  A Subscription
+// saves each nonempty set of endpoints from its watch, and restores the saved endpoints when it starts,
+// so that requests can be routed before service discovery reports anything after a cold start.
+type EndpointStore interface {
+	// Load returns the persisted endpoints.  An empty store returns no endpoints and no error.
+	Load() ([]Endpoint, error)
+
+	// Save replaces the persisted endpoints with the given list
+	Save([]Endpoint) error
+}
+
+// FileEndpointStore is an EndpointStore that keeps endpoints as a JSON array in a single file.  Writes
+// go to a temporary file that is then renamed, so a crash never leaves a partial file.
+type FileEndpointStore struct {
+	Path string
+
+	lock sync.Mutex
+}
+
+func (fs *FileEndpointStore) Load() ([]Endpoint, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	data, err := ioutil.ReadFile(fs.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var endpoints []Endpoint
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
+
+func (fs *FileEndpointStore) Save(endpoints []Endpoint) error {
+	data, err := json.Marshal(endpoints)
+	if err != nil {
+		return err
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	temp, err := ioutil.TempFile(filepath.Dir(fs.Path), filepath.Base(fs.Path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), fs.Path)
+}
+
+// RestoreAccessor updates an accessor with the endpoints persisted in a store, which is useful for seeding
+// an accessor before its Subscription runs.  The restored endpoints are returned.  If the store is empty,
+// the accessor is left unchanged.
+func RestoreAccessor(accessor UpdatableAccessor, store EndpointStore) ([]Endpoint, error) {
+	endpoints, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(endpoints) > 0 {
+		accessor.UpdateEndpoints(endpoints)
+	}
+
+	return endpoints, nil
+}
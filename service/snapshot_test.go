@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileEndpointStore(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	directory, err := ioutil.TempDir("", "service")
+	require.NoError(err)
+	defer os.RemoveAll(directory)
+
+	store := &FileEndpointStore{Path: filepath.Join(directory, "endpoints.json")}
+
+	endpoints, err := store.Load()
+	assert.Empty(endpoints)
+	assert.NoError(err)
+
+	expected := []Endpoint{{Value: "http://host1:8080"}, {Value: "http://host2:8080", Metadata: map[string]string{WeightMetadataKey: "50"}}}
+	require.NoError(store.Save(expected))
+
+	endpoints, err = store.Load()
+	assert.Equal(expected, endpoints)
+	assert.NoError(err)
+
+	// saving replaces the persisted endpoints
+	require.NoError(store.Save(expected[1:]))
+	endpoints, err = store.Load()
+	assert.Equal(expected[1:], endpoints)
+	assert.NoError(err)
+
+	files, err := ioutil.ReadDir(directory)
+	require.NoError(err)
+	assert.Len(files, 1)
+}
+
+func TestFileEndpointStoreInvalid(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	file, err := ioutil.TempFile("", "service")
+	require.NoError(err)
+	defer os.Remove(file.Name())
+
+	file.Write([]byte("this is not json"))
+	file.Close()
+
+	endpoints, err := (&FileEndpointStore{Path: file.Name()}).Load()
+	assert.Nil(endpoints)
+	assert.Error(err)
+
+	assert.Error((&FileEndpointStore{Path: filepath.Join(file.Name(), "nosuchdirectory", "endpoints.json")}).Save(nil))
+}
+
+func TestRestoreAccessor(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		store         = new(mockEndpointStore)
+		accessor      = NewUpdatableAccessor(nil, nil)
+	)
+
+	store.On("Load").Return(nil, expectedError).Once()
+	endpoints, err := RestoreAccessor(accessor, store)
+	assert.Nil(endpoints)
+	assert.Equal(expectedError, err)
+
+	store.On("Load").Return(nil, nil).Once()
+	endpoints, err = RestoreAccessor(accessor, store)
+	assert.Empty(endpoints)
+	assert.NoError(err)
+	_, err = accessor.Get([]byte("key"))
+	assert.Error(err)
+
+	store.On("Load").Return([]Endpoint{{Value: "http://host1:8080"}}, nil).Once()
+	endpoints, err = RestoreAccessor(accessor, store)
+	assert.Equal([]Endpoint{{Value: "http://host1:8080"}}, endpoints)
+	assert.NoError(err)
+
+	instance, err := accessor.Get([]byte("key"))
+	assert.Equal("http://host1:8080", instance)
+	assert.NoError(err)
+
+	store.AssertExpectations(t)
+}
+
+func nextSubscriptionUpdate(t *testing.T, updates <-chan []string) []string {
+	select {
+	case endpoints := <-updates:
+		return endpoints
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "No endpoints were dispatched")
+		return nil
+	}
+}
+
+func testSubscriptionStoreRestored(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		store     = new(mockEndpointStore)
+		registrar = new(mockRegistrar)
+		watch     = newFakeWatch()
+		updates   = make(chan []string, 1)
+
+		subscription = Subscription{
+			Registrar:     registrar,
+			Store:         store,
+			WarmupTimeout: 5 * time.Second,
+			Listener:      func(endpoints []string) { updates <- endpoints },
+		}
+	)
+
+	registrar.On("Watch").Return(watch, nil).Once()
+	store.On("Load").Return([]Endpoint{{Value: "http://host1:8080"}}, nil).Once()
+
+	// the restored endpoints are dispatched without waiting on the watch
+	require.NoError(subscription.Run())
+	assert.True(subscription.Ready())
+	assert.Equal([]string{"http://host1:8080"}, nextSubscriptionUpdate(t, updates))
+	assert.Equal([]Endpoint{{Value: "http://host1:8080"}}, subscription.Endpoints())
+
+	store.On("Save", []Endpoint{{Value: "http://host2:8080"}}).Return(nil).Once()
+	watch.set("http://host2:8080")
+	assert.Equal([]string{"http://host2:8080"}, nextSubscriptionUpdate(t, updates))
+
+	// losing every endpoint does not erase the snapshot
+	watch.set()
+	assert.Empty(nextSubscriptionUpdate(t, updates))
+
+	assert.NoError(subscription.Cancel())
+	registrar.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func testSubscriptionStoreErrors(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		expectedError = errors.New("expected")
+		store         = new(mockEndpointStore)
+		registrar     = new(mockRegistrar)
+		watch         = newFakeWatch()
+		updates       = make(chan []string, 1)
+
+		subscription = Subscription{
+			Registrar: registrar,
+			Store:     store,
+			Listener:  func(endpoints []string) { updates <- endpoints },
+		}
+	)
+
+	registrar.On("Watch").Return(watch, nil).Once()
+	store.On("Load").Return(nil, expectedError).Once()
+	store.On("Save", []Endpoint{{Value: "http://host1:8080"}}).Return(expectedError).Once()
+
+	require.NoError(subscription.Run())
+
+	// neither error keeps the watch's endpoints from being dispatched
+	watch.set("http://host1:8080")
+	assert.Equal([]string{"http://host1:8080"}, nextSubscriptionUpdate(t, updates))
+	assert.Empty(updates)
+
+	assert.NoError(subscription.Cancel())
+	registrar.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestSubscriptionStore(t *testing.T) {
+	t.Run("Restored", testSubscriptionStoreRestored)
+	t.Run("Errors", testSubscriptionStoreErrors)
+}
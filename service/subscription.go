@@ -3,17 +3,65 @@ package service
 import (
 	"errors"
 	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// UpdateCount is the counter of endpoint updates dispatched to a subscription's Listener
+	UpdateCount = "service_discovery_updates_total"
+
+	// EndpointCount is the gauge of endpoints most recently dispatched to a subscription's Listener
+	EndpointCount = "service_discovery_endpoints"
+
+	// ListenerPanicCount is the counter of panics recovered from a subscription's Listener
+	ListenerPanicCount = "service_discovery_listener_panics_total"
+
+	// WatchRestartCount is the counter of silent watches replaced by a subscription's watchdog
+	WatchRestartCount = "service_discovery_watch_restarts_total"
+)
+
 var (
 	ErrorAlreadyRunning = errors.New("That subscription is already running")
 	ErrorNotRunning     = errors.New("That subscription is not running")
+	ErrorWarmupTimeout  = errors.New("No endpoints were available before the warm-up timeout elapsed")
 )
 
+// HeartbeatWatch is implemented by Watches that signal they are alive even when their endpoints do not change
+type HeartbeatWatch interface {
+	Watch
+
+	// Heartbeat returns a channel that receives periodically while the watch is healthy
+	Heartbeat() <-chan struct{}
+}
+
+// watchHeartbeat returns the heartbeat channel of a watch, or nil if the watch is not a HeartbeatWatch
+func watchHeartbeat(watch Watch) <-chan struct{} {
+	if heartbeatWatch, ok := watch.(HeartbeatWatch); ok {
+		return heartbeatWatch.Heartbeat()
+	}
+
+	return nil
+}
+
+// WatchdogEvent describes an attempt by a subscription's watchdog to replace a silent watch
+type WatchdogEvent struct {
+	// Silent is the watch that reported neither events nor heartbeats within the WatchdogInterval
+	Silent Watch
+
+	// Replacement is the new watch obtained from the Registrar.  This field is nil if Err is set.
+	Replacement Watch
+
+	// Err is the error from the Registrar.  When set, the silent watch is kept and replacing it is
+	// attempted again after another WatchdogInterval.
+	Err error
+}
+
 // Subscription represents a specific sink for watch events.  The Listener function is notified
-// with updated endpoints.
+// with updated endpoints.  Additional listeners, each with its own timeout, may share the same watch
+// via AddListener.
 type Subscription struct {
 	// Logger is the option Logger used by this subscription.  If not supplied, it defaults to logging.DefaultLogger().
 	Logger logging.Logger
@@ -21,8 +69,9 @@ type Subscription struct {
 	// Registrar is the service registration component used to create a Watch.
 	Registrar Registrar
 
-	// Listener is the sink for service endpoint updates.  This field is required, and must not
-	// be changed concurrently with any methods of this type.
+	// Listener is the sink for service endpoint updates.  This field is required unless EndpointListener
+	// is set or listeners are registered with AddListener, and must not be changed concurrently with any
+	// methods of this type.
 	//
 	// This field can be set to UpdatableAccessor.Update.  That will simply update the accessor's
 	// endpoints with every watch event:
@@ -38,8 +87,16 @@ type Subscription struct {
 	//     )
 	//
 	//     subscription.Run()
+	//
+	// A panic in the Listener is recovered and logged, and monitoring continues with the next update.
 	Listener func([]string)
 
+	// EndpointListener is an optional sink for the same updates as Listener, which also receives the
+	// registration metadata of each endpoint when the watch is a MetadataWatch.  This field can be set to
+	// UpdatableAccessor.UpdateEndpoints in order to route keys by endpoint weight.  If this field is set,
+	// Listener is not required.  When both are set, both are notified of each update.
+	EndpointListener func([]Endpoint)
+
 	// Timeout is an optional interval used for fault tolerance in the face of network flapping.  If set
 	// to a positive value, then updates will not be immediately dispatched to the Listener.  Rather, when an
 	// update first occurs, a timer is started.  Within the timer interval, only the most recent update is kept.
@@ -47,44 +104,339 @@ type Subscription struct {
 	Timeout time.Duration
 
 	// After is an optional function which is used to produce a time channel for delays.  Setting this
-	// field is only relevant if Timeout > 0.  If this field is nil, time.After is used.
+	// field is only relevant if Timeout or WatchdogInterval is positive.  If this field is nil, time.After is used.
 	After func(time.Duration) <-chan time.Time
 
-	mutex    sync.Mutex
-	watch    Watch
-	shutdown chan struct{}
+	// Metrics is the optional provider used to record endpoint updates.  If not supplied,
+	// metrics are discarded.
+	Metrics xmetrics.Provider
+
+	// WarmupTimeout is an optional bound on how long Run blocks waiting for the first nonempty set
+	// of endpoints to be dispatched to the Listener.  This allows a server to defer reporting ready until
+	// its Accessor can actually route requests.  While warming up, the first nonempty set of endpoints
+	// is dispatched immediately, regardless of Timeout.  If this field is not positive, Run does not wait.
+	WarmupTimeout time.Duration
+
+	// Store is the optional EndpointStore for the last-known endpoints.  When set, each nonempty set of
+	// endpoints reported by the watch is saved, regardless of Timeout, and the saved endpoints are dispatched
+	// as soon as this subscription runs, before the watch reports anything.  Restored endpoints satisfy
+	// WarmupTimeout.  Empty sets are never saved, so that losing service discovery does not erase the
+	// last-known endpoints.
+	Store EndpointStore
+
+	// WatchdogInterval is an optional bound on how long the watch may go without reporting an event or,
+	// for a HeartbeatWatch, a heartbeat.  A watch silent for longer is assumed to be dead, as can happen to a
+	// Zookeeper watch after certain session recoveries.  It is replaced with a new watch from the Registrar and
+	// then closed.  If this field is not positive, the watch is trusted for as long as it is open.
+	WatchdogInterval time.Duration
+
+	// WatchdogListener is the optional sink for each attempt to replace a silent watch.  A panic in this
+	// listener is recovered and logged.
+	WatchdogListener func(WatchdogEvent)
+
+	mutex     sync.Mutex
+	watch     Watch
+	shutdown  chan struct{}
+	warm      int32
+	listeners []*SubscriptionListener
+	notify    func(func())
+	last      []Endpoint
+	updated   bool
 }
 
-// monitor is a goroutine that monitors the watch and dispatches updated endpoints
-// to the Listener.
-func (s *Subscription) monitor(watch Watch, shutdown <-chan struct{}) {
+// SubscriptionListener is a sink registered with Subscription.AddListener.  Each SubscriptionListener
+// debounces updates with its own timeout, independently of the Subscription's Timeout and of any other
+// listeners, so that several consumers can share a single watch.
+type SubscriptionListener struct {
+	listener func([]Endpoint)
+	timeout  time.Duration
+	updates  chan []Endpoint
+	removed  chan struct{}
+}
+
+// offer replaces any update not yet received by this listener's goroutine.  This method must only be
+// called while holding the subscription's mutex, so that there is never more than one sender.
+func (l *SubscriptionListener) offer(endpoints []Endpoint) {
+	if l.updates == nil {
+		// this listener has never been started
+		return
+	}
+
+	select {
+	case <-l.updates:
+	default:
+	}
+
+	l.updates <- endpoints
+}
+
+// start spawns the goroutine that dispatches updates to this listener for one run of the subscription.
+// Each run gets its own updates channel, so that a goroutine left over from a previous run cannot
+// receive updates meant for this one.  This method must only be called while holding the subscription's mutex.
+func (l *SubscriptionListener) start(after func(time.Duration) <-chan time.Time, notify func(func()), shutdown <-chan struct{}) {
+	l.updates = make(chan []Endpoint, 1)
+	go l.run(l.updates, after, notify, shutdown)
+}
+
+// run dispatches the updates offered to this listener until either the subscription stops or
+// this listener is removed
+func (l *SubscriptionListener) run(updates <-chan []Endpoint, after func(time.Duration) <-chan time.Time, notify func(func()), shutdown <-chan struct{}) {
 	var (
-		logger    = s.Logger
 		delay     <-chan time.Time
-		after     = s.After
-		endpoints []string
+		endpoints []Endpoint
 	)
 
-	if logger == nil {
-		logger = logging.DefaultLogger()
+	for {
+		select {
+		case <-shutdown:
+			return
+
+		case <-l.removed:
+			return
+
+		case <-delay:
+			delay = nil
+			notify(func() { l.listener(endpoints) })
+
+		case endpoints = <-updates:
+			if l.timeout <= 0 {
+				notify(func() { l.listener(endpoints) })
+			} else if delay == nil {
+				delay = after(l.timeout)
+			}
+		}
+	}
+}
+
+// AddListener registers an additional sink for the endpoint updates of this subscription.  Updates are
+// dispatched to the given listener after the given timeout in the same manner as Timeout, and a nonpositive
+// timeout dispatches each update immediately.  This method may be called whether or not this subscription
+// is running.  A listener added to a running subscription that has already seen an update is sent the
+// most recent endpoints, subject to its timeout.
+//
+// The returned SubscriptionListener is passed to RemoveListener to stop sending updates to the listener.
+func (s *Subscription) AddListener(listener func([]Endpoint), timeout time.Duration) *SubscriptionListener {
+	l := &SubscriptionListener{
+		listener: listener,
+		timeout:  timeout,
+		removed:  make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners = append(s.listeners, l)
+	if s.shutdown != nil {
+		l.start(s.after(), s.notify, s.shutdown)
+		if s.updated {
+			l.offer(s.last)
+		}
+	}
+
+	return l
+}
+
+// RemoveListener stops sending updates to a listener registered with AddListener, including any update
+// that is waiting on the listener's timeout.  This method returns false if the listener was not registered
+// with this subscription or has already been removed.
+func (s *Subscription) RemoveListener(l *SubscriptionListener) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, candidate := range s.listeners {
+		if candidate == l {
+			last := len(s.listeners) - 1
+			s.listeners[i] = s.listeners[last]
+			s.listeners[last] = nil
+			s.listeners = s.listeners[:last]
+			close(l.removed)
+			return true
+		}
+	}
+
+	return false
+}
+
+// forward offers the endpoints of each watch event to the listeners registered with AddListener
+func (s *Subscription) forward(endpoints []Endpoint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.last, s.updated = endpoints, true
+	for _, l := range s.listeners {
+		l.offer(endpoints)
+	}
+}
+
+func (s *Subscription) logger() logging.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+func (s *Subscription) after() func(time.Duration) <-chan time.Time {
+	if s.After != nil {
+		return s.After
+	}
+
+	return time.After
+}
+
+// restore loads the endpoints persisted in the Store, if any
+func (s *Subscription) restore(logger logging.Logger) []Endpoint {
+	if s.Store == nil {
+		return nil
+	}
+
+	endpoints, err := s.Store.Load()
+	if err != nil {
+		logger.Error("Unable to restore endpoints: %s", err)
+		return nil
+	}
+
+	return endpoints
+}
+
+// save persists a nonempty set of endpoints in the Store, if any
+func (s *Subscription) save(endpoints []Endpoint) {
+	if s.Store == nil || len(endpoints) == 0 {
+		return
 	}
 
-	if after == nil {
-		after = time.After
+	if err := s.Store.Save(endpoints); err != nil {
+		s.logger().Error("Unable to save endpoints: %s", err)
 	}
+}
+
+// Endpoints returns the endpoints of the most recent watch event seen by this subscription since it
+// was last run, regardless of any Timeout.  Before the first event, these are the endpoints restored
+// from the Store.  This method returns nil if no event has been seen and nothing was restored.
+func (s *Subscription) Endpoints() []Endpoint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.last
+}
+
+// Ready tests if a nonempty set of endpoints has been dispatched to the Listener since
+// this subscription was last run
+func (s *Subscription) Ready() bool {
+	return atomic.LoadInt32(&s.warm) != 0
+}
+
+// monitor is a goroutine that monitors the watch and dispatches updated endpoints
+// to the Listener.
+func (s *Subscription) monitor(watch Watch, provider xmetrics.Provider, notify func(func()), shutdown <-chan struct{}, ready, stopped chan<- struct{}) {
+	var (
+		logger    = s.logger()
+		delay     <-chan time.Time
+		after     = s.after()
+		endpoints []Endpoint
+
+		watchdog  <-chan time.Time
+		heartbeat = watchHeartbeat(watch)
+
+		updateCount   = provider.NewCounter(UpdateCount)
+		endpointCount = provider.NewGauge(EndpointCount)
+		restartCount  = provider.NewCounter(WatchRestartCount)
+
+		dispatch = func() {
+			updateCount.Add(1.0)
+			endpointCount.Set(float64(len(endpoints)))
+			if s.Listener != nil {
+				notify(func() { s.Listener(EndpointValues(endpoints)) })
+			}
+
+			if s.EndpointListener != nil {
+				notify(func() { s.EndpointListener(endpoints) })
+			}
+
+			if len(endpoints) > 0 && atomic.CompareAndSwapInt32(&s.warm, 0, 1) {
+				close(ready)
+			}
+
+			endpoints = nil
+		}
+
+		warmingUp = func() bool {
+			return s.WarmupTimeout > 0 && len(endpoints) > 0 && !s.Ready()
+		}
+
+		// update handles the endpoints most recently reported by the watch
+		update = func() {
+			s.forward(endpoints)
+			s.save(endpoints)
+
+			if warmingUp() {
+				// don't delay the first usable endpoints
+				delay = nil
+				logger.Info("Dispatching first endpoints: %v", EndpointValues(endpoints))
+				dispatch()
+				return
+			}
+
+			if delay != nil {
+				// there is a delay in effect, so just keep listening for updates
+				logger.Info("Still waiting %s to dispatch updates", s.Timeout)
+				return
+			}
+
+			if s.Timeout > 0 {
+				logger.Info("Waiting %s to dispatch updates", s.Timeout)
+				delay = after(s.Timeout)
+				return
+			}
+
+			// there is no current delay and no Timeout configured,
+			// so dispatch immediately
+			logger.Info("Dispatching updated endpoints: %v", EndpointValues(endpoints))
+			dispatch()
+		}
+
+		// resetWatchdog restarts the interval within which the watch must report something
+		resetWatchdog = func() {
+			if s.WatchdogInterval > 0 {
+				watchdog = after(s.WatchdogInterval)
+			}
+		}
+	)
 
 	defer func() {
 		if r := recover(); r != nil {
-			logger.Error("Subscription ending due to panic: %s", r)
+			logging.ReportPanic(logger, "Subscription ending due to panic", r)
 		}
 
 		// ensure that the cancellation logic runs in this case, since no explicit
-		// call to Cancel may have happened, e.g. panic, the watch was closed, etc
-		s.Cancel()
+		// call to Cancel may have happened, e.g. panic, the watch was closed, etc.
+		// If this subscription has since been cancelled and run again, the new run is left alone.
+		s.mutex.Lock()
+		if s.shutdown == shutdown {
+			s.cancel()
+		}
+
+		s.mutex.Unlock()
+		close(stopped)
 	}()
 
 	logger.Info("Monitoring subscription to: %v", watch)
 
+	if endpoints = s.restore(logger); len(endpoints) > 0 {
+		s.forward(endpoints)
+		logger.Info("Dispatching restored endpoints: %v", EndpointValues(endpoints))
+		dispatch()
+	}
+
+	if s.WarmupTimeout > 0 {
+		// the watch may already have endpoints, in which case no event is pending for them
+		if endpoints = WatchEndpoints(watch); len(endpoints) > 0 {
+			s.forward(endpoints)
+			s.save(endpoints)
+			logger.Info("Dispatching initial endpoints: %v", EndpointValues(endpoints))
+			dispatch()
+		}
+	}
+
+	resetWatchdog()
 	for {
 		select {
 		case <-shutdown:
@@ -93,57 +445,163 @@ func (s *Subscription) monitor(watch Watch, shutdown <-chan struct{}) {
 
 		case <-delay:
 			delay = nil
-			logger.Info("Dispatching updated endpoints after delay: %v", endpoints)
-			s.Listener(endpoints)
-			endpoints = nil
+			logger.Info("Dispatching updated endpoints after delay: %v", EndpointValues(endpoints))
+			dispatch()
 
-		case <-watch.Event():
-			if watch.IsClosed() {
-				logger.Info("Subscription ending because the watch was closed")
+		case <-heartbeat:
+			resetWatchdog()
+
+		case <-watchdog:
+			logger.Error("Replacing watch %v, which has been silent for %s", watch, s.WatchdogInterval)
+			replacement, err := s.rewatch(watch, shutdown)
+			if err == ErrorNotRunning {
+				logger.Info("Subscription ending because it was cancelled")
 				return
 			}
 
-			endpoints = watch.Endpoints()
+			if s.WatchdogListener != nil {
+				event := WatchdogEvent{Silent: watch, Replacement: replacement, Err: err}
+				notify(func() { s.WatchdogListener(event) })
+			}
 
-			if delay != nil {
-				// there is a delay in effect, so just keep listening for updates
-				logger.Info("Still waiting %s to dispatch updates", s.Timeout)
+			if err == ErrorStopped {
+				logger.Info("Subscription ending because the registrar was stopped")
+				return
+			} else if err != nil {
+				logger.Error("Unable to replace silent watch: %s", err)
+				resetWatchdog()
 				continue
 			}
 
-			if s.Timeout > 0 {
-				logger.Info("Waiting %s to dispatch updates", s.Timeout)
-				delay = after(s.Timeout)
-				continue
+			restartCount.Add(1.0)
+			watch, heartbeat = replacement, watchHeartbeat(replacement)
+			resetWatchdog()
+
+			// the replacement may already have endpoints, in which case no event is pending for them.
+			// An empty replacement is left to report its first event, so that the last endpoints are not dropped.
+			if current := WatchEndpoints(watch); len(current) > 0 {
+				endpoints = current
+				update()
 			}
 
-			// there is no current delay and no Timeout configured,
-			// so dispatch immediately
-			logger.Info("Dispatching updated endpoints: %v", endpoints)
-			s.Listener(endpoints)
-			endpoints = nil
+		case <-watch.Event():
+			if watch.IsClosed() {
+				logger.Info("Subscription ending because the watch was closed")
+				return
+			}
+
+			resetWatchdog()
+			endpoints = WatchEndpoints(watch)
+			update()
 		}
 	}
 }
 
+// rewatch replaces a silent watch with a new one from the Registrar.  The silent watch is only closed once
+// its replacement is in place, so that an error from the Registrar leaves this subscription as it was.
+// If this subscription is cancelled in the meantime, the replacement is closed and ErrorNotRunning is returned.
+func (s *Subscription) rewatch(silent Watch, shutdown <-chan struct{}) (Watch, error) {
+	replacement, err := s.Registrar.Watch()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	if s.shutdown != shutdown {
+		s.mutex.Unlock()
+		replacement.Close()
+		return nil, ErrorNotRunning
+	}
+
+	s.watch = replacement
+	s.mutex.Unlock()
+
+	silent.Close()
+	return replacement, nil
+}
+
 // Run starts monitoring the watch for this subscription.  This method is idempotent, and returns
 // ErrorAlreadyRunning if this instance is already running.
+//
+// If WarmupTimeout is set, this method then blocks until the first nonempty set of endpoints has been
+// dispatched.  ErrorWarmupTimeout is returned if that does not happen within the timeout, though this
+// subscription continues to run.  If monitoring stops while warming up, e.g. the watch was closed,
+// ErrorNotRunning is returned.
 func (s *Subscription) Run() error {
+	ready, stopped, err := s.start()
+	if err != nil || s.WarmupTimeout <= 0 {
+		return err
+	}
+
+	timer := time.NewTimer(s.WarmupTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return nil
+
+	case <-stopped:
+		if s.Ready() {
+			return nil
+		}
+
+		return ErrorNotRunning
+
+	case <-timer.C:
+		return ErrorWarmupTimeout
+	}
+}
+
+// start creates the watch and spawns the monitor goroutine.  The returned channels are closed
+// when the subscription becomes ready and when the monitor goroutine exits, respectively.
+func (s *Subscription) start() (<-chan struct{}, <-chan struct{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	if s.watch != nil {
-		return ErrorAlreadyRunning
+		return nil, nil, ErrorAlreadyRunning
 	}
 
 	watch, err := s.Registrar.Watch()
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	var (
+		ready    = make(chan struct{})
+		stopped  = make(chan struct{})
+		logger   = s.logger()
+		provider = s.Metrics
+	)
+
+	if provider == nil {
+		provider = xmetrics.NewDiscardProvider()
 	}
 
+	panicCount := provider.NewCounter(ListenerPanicCount)
+
+	// notify invokes a single listener.  A misbehaving listener must not tear down the subscription
+	// or keep the other listeners from receiving the update.
+	s.notify = func(listener func()) {
+		defer func() {
+			if r := recover(); r != nil {
+				panicCount.Add(1.0)
+				logging.ReportPanic(logger, "Subscription listener panicked", r)
+			}
+		}()
+
+		listener()
+	}
+
+	atomic.StoreInt32(&s.warm, 0)
 	s.watch = watch
 	s.shutdown = make(chan struct{})
-	go s.monitor(s.watch, s.shutdown)
-	return nil
+	s.last, s.updated = nil, false
+	for _, l := range s.listeners {
+		l.start(s.after(), s.notify, s.shutdown)
+	}
+
+	go s.monitor(s.watch, provider, s.notify, s.shutdown, ready, stopped)
+	return ready, stopped, nil
 }
 
 // Cancel stops monitoring the watch for this subscription.  This method is idempotent, and returns
@@ -152,7 +610,11 @@ func (s *Subscription) Run() error {
 func (s *Subscription) Cancel() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	return s.cancel()
+}
 
+// cancel performs the work of Cancel.  This method must be called while holding the mutex.
+func (s *Subscription) cancel() error {
 	// close the shutdown channel first, so log messages accurately
 	// reflect cancellation when applicable
 	if s.shutdown != nil {
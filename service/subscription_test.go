@@ -2,7 +2,11 @@ package service
 
 import (
 	"errors"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -32,43 +36,58 @@ func testSubscriptionWatchError(t *testing.T) {
 func testSubscriptionListenerPanic(t *testing.T) {
 	var (
 		assert        = assert.New(t)
+		require       = require.New(t)
 		expectedError = errors.New("testSubscriptionListenerPanic")
 
 		watch     = NewTestWatch(t)
 		registrar = new(mockRegistrar)
 
-		expectedEndpoints = []string{"expected endpoint"}
-		listenerCalled    = make(chan struct{})
-
-		subscription = Subscription{
-			Registrar: registrar,
-			Listener: func(endpoints []string) {
-				defer close(listenerCalled)
-				assert.Equal(expectedEndpoints, endpoints)
-				panic(expectedError)
-			},
+		expectedEndpoints = [][]string{
+			[]string{"testSubscriptionListenerPanic1"},
+			[]string{"testSubscriptionListenerPanic2", "testSubscriptionListenerPanic3"},
 		}
+
+		listenerOutput = make(chan []string, 1)
+		listenerCalls  = 0
 	)
 
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	subscription := Subscription{
+		Registrar: registrar,
+		Metrics:   registry,
+		Listener: func(endpoints []string) {
+			listenerOutput <- endpoints
+			listenerCalls++
+			if listenerCalls == 1 {
+				panic(expectedError)
+			}
+		},
+	}
+
 	registrar.On("Watch").Once().Return(watch, nil)
 
 	assert.NoError(subscription.Run())
 	assert.Equal(ErrorAlreadyRunning, subscription.Run())
 
-	// simulate one event, which should dispatch then panic
-	watch.NextEndpoints(expectedEndpoints)
-	<-listenerCalled
+	// the first event panics, but the subscription keeps monitoring
+	for _, endpoints := range expectedEndpoints {
+		watch.NextEndpoints(endpoints)
+		assert.Equal(endpoints, <-listenerOutput)
+	}
 
-	// the monitor goroutine calls Cancel, so we can't guarantee that invoking
-	// Cancel here will happen before (or after) that call.
-	err := subscription.Cancel()
-	assert.True(err == nil || err == ErrorNotRunning)
+	assert.NoError(subscription.Cancel())
 	assert.True(watch.IsClosed())
-
-	// However, a second Cancel will always behave the same
 	assert.Equal(ErrorNotRunning, subscription.Cancel())
 	assert.True(watch.IsClosed())
 
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, ListenerPanicCount+" 1"), body)
+	assert.True(strings.Contains(body, UpdateCount+" 2"), body)
+
 	registrar.AssertExpectations(t)
 }
 
@@ -183,9 +202,584 @@ func testSubscriptionWithTimeout(t *testing.T) {
 	registrar.AssertExpectations(t)
 }
 
+func testSubscriptionMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		watch     = NewTestWatch(t)
+		registrar = new(mockRegistrar)
+
+		listenerOutput = make(chan []string, 1)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	subscription := Subscription{
+		Registrar: registrar,
+		Metrics:   registry,
+		Listener: func(endpoints []string) {
+			listenerOutput <- endpoints
+		},
+	}
+
+	registrar.On("Watch").Once().Return(watch, nil)
+	require.NoError(subscription.Run())
+
+	watch.NextEndpoints([]string{"host1"})
+	<-listenerOutput
+	watch.NextEndpoints([]string{"host1", "host2"})
+	<-listenerOutput
+
+	assert.NoError(subscription.Cancel())
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, UpdateCount+" 2"), body)
+	assert.True(strings.Contains(body, EndpointCount+" 2"), body)
+
+	registrar.AssertExpectations(t)
+}
+
+func testSubscriptionWarmupInitialEndpoints(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		watch     = new(mockWatch)
+		registrar = new(mockRegistrar)
+
+		listenerOutput = make(chan []string, 1)
+		subscription   = Subscription{
+			Registrar:     registrar,
+			WarmupTimeout: 5 * time.Second,
+			Listener: func(endpoints []string) {
+				listenerOutput <- endpoints
+			},
+		}
+	)
+
+	registrar.On("Watch").Once().Return(watch, nil)
+	watch.On("Event").Return((<-chan struct{})(make(chan struct{})))
+	watch.On("Endpoints").Once().Return([]string{"testSubscriptionWarmupInitialEndpoints"})
+	watch.On("Close").Once()
+
+	assert.False(subscription.Ready())
+	assert.NoError(subscription.Run())
+	assert.True(subscription.Ready())
+	assert.Equal([]string{"testSubscriptionWarmupInitialEndpoints"}, <-listenerOutput)
+
+	assert.NoError(subscription.Cancel())
+	registrar.AssertExpectations(t)
+	watch.AssertExpectations(t)
+}
+
+func testSubscriptionWarmupFirstEvent(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		watch     = new(mockWatch)
+		registrar = new(mockRegistrar)
+		events    = make(chan struct{}, 1)
+
+		listenerOutput = make(chan []string, 2)
+		subscription   = Subscription{
+			Registrar:     registrar,
+			WarmupTimeout: 5 * time.Second,
+			Timeout:       time.Hour,
+			After: func(time.Duration) <-chan time.Time {
+				assert.Fail("The first endpoints should not be delayed")
+				return nil
+			},
+			Listener: func(endpoints []string) {
+				listenerOutput <- endpoints
+			},
+		}
+	)
+
+	registrar.On("Watch").Once().Return(watch, nil)
+	watch.On("Event").Return((<-chan struct{})(events))
+	watch.On("IsClosed").Return(false)
+	watch.On("Endpoints").Once().Return([]string{})
+	watch.On("Endpoints").Once().Return([]string{"testSubscriptionWarmupFirstEvent"})
+	watch.On("Close").Once()
+
+	events <- struct{}{}
+	assert.NoError(subscription.Run())
+	assert.True(subscription.Ready())
+	assert.Equal([]string{"testSubscriptionWarmupFirstEvent"}, <-listenerOutput)
+
+	assert.NoError(subscription.Cancel())
+	registrar.AssertExpectations(t)
+	watch.AssertExpectations(t)
+}
+
+func testSubscriptionWarmupTimeout(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		watch     = new(mockWatch)
+		registrar = new(mockRegistrar)
+
+		subscription = Subscription{
+			Registrar:     registrar,
+			WarmupTimeout: 50 * time.Millisecond,
+			Listener: func([]string) {
+				assert.Fail("The listener should not have been called")
+			},
+		}
+	)
+
+	registrar.On("Watch").Once().Return(watch, nil)
+	watch.On("Event").Return((<-chan struct{})(make(chan struct{})))
+	watch.On("Endpoints").Once().Return([]string{})
+	watch.On("Close").Once()
+
+	assert.Equal(ErrorWarmupTimeout, subscription.Run())
+	assert.False(subscription.Ready())
+
+	// the subscription is still running
+	assert.Equal(ErrorAlreadyRunning, subscription.Run())
+	assert.NoError(subscription.Cancel())
+
+	registrar.AssertExpectations(t)
+	watch.AssertExpectations(t)
+}
+
+func testSubscriptionWarmupWatchClosed(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		watch     = new(mockWatch)
+		registrar = new(mockRegistrar)
+		events    = make(chan struct{})
+
+		subscription = Subscription{
+			Registrar:     registrar,
+			WarmupTimeout: 5 * time.Second,
+			Listener: func([]string) {
+				assert.Fail("The listener should not have been called")
+			},
+		}
+	)
+
+	registrar.On("Watch").Once().Return(watch, nil)
+	watch.On("Event").Return((<-chan struct{})(events))
+	watch.On("IsClosed").Return(true)
+	watch.On("Endpoints").Once().Return([]string{})
+	watch.On("Close").Once()
+
+	close(events)
+	assert.Equal(ErrorNotRunning, subscription.Run())
+	assert.False(subscription.Ready())
+	assert.Equal(ErrorNotRunning, subscription.Cancel())
+
+	registrar.AssertExpectations(t)
+	watch.AssertExpectations(t)
+}
+
+func testSubscriptionEndpointListener(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		watch = &metadataTestWatch{
+			TestWatch: NewTestWatch(t),
+			metadata: map[string]map[string]string{
+				"http://blue.comcast.net:8080": {WeightMetadataKey: "90"},
+			},
+		}
+
+		registrar      = new(mockRegistrar)
+		listenerOutput = make(chan []string, 1)
+		endpointOutput = make(chan []Endpoint, 1)
+		subscription   = Subscription{
+			Registrar: registrar,
+			Listener: func(endpoints []string) {
+				listenerOutput <- endpoints
+				panic("the EndpointListener should still be notified")
+			},
+			EndpointListener: func(endpoints []Endpoint) {
+				endpointOutput <- endpoints
+			},
+		}
+	)
+
+	registrar.On("Watch").Once().Return(watch, nil)
+	assert.NoError(subscription.Run())
+
+	watch.NextEndpoints([]string{"http://blue.comcast.net:8080", "http://green.comcast.net:8080"})
+	assert.Equal([]string{"http://blue.comcast.net:8080", "http://green.comcast.net:8080"}, <-listenerOutput)
+	assert.Equal(
+		[]Endpoint{
+			{Value: "http://blue.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "90"}},
+			{Value: "http://green.comcast.net:8080"},
+		},
+		<-endpointOutput,
+	)
+
+	// updates are dispatched in order, so the first dispatch has finished once the next one starts
+	watch.NextEndpoints([]string{"http://green.comcast.net:8080"})
+	assert.Equal([]string{"http://green.comcast.net:8080"}, <-listenerOutput)
+	assert.Equal([]Endpoint{{Value: "http://green.comcast.net:8080"}}, <-endpointOutput)
+	assert.True(subscription.Ready())
+
+	assert.NoError(subscription.Cancel())
+
+	// the Listener is not required
+	var (
+		second             = &metadataTestWatch{TestWatch: NewTestWatch(t)}
+		secondSubscription = Subscription{
+			Registrar: registrar,
+			EndpointListener: func(endpoints []Endpoint) {
+				endpointOutput <- endpoints
+			},
+		}
+	)
+
+	registrar.On("Watch").Once().Return(second, nil)
+	assert.NoError(secondSubscription.Run())
+
+	second.NextEndpoints([]string{"http://green.comcast.net:8080"})
+	assert.Equal([]Endpoint{{Value: "http://green.comcast.net:8080"}}, <-endpointOutput)
+
+	assert.NoError(secondSubscription.Cancel())
+	registrar.AssertExpectations(t)
+}
+
+func testSubscriptionAddListener(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		watch     = NewTestWatch(t)
+		registrar = new(mockRegistrar)
+		delays    = make(chan chan time.Time, 1)
+
+		immediateOutput = make(chan []Endpoint, 1)
+		delayedOutput   = make(chan []Endpoint, 1)
+		lateOutput      = make(chan []Endpoint, 1)
+
+		subscription = Subscription{
+			Registrar: registrar,
+			After: func(timeout time.Duration) <-chan time.Time {
+				assert.Equal(time.Minute, timeout)
+				delay := make(chan time.Time, 1)
+				delays <- delay
+				return delay
+			},
+		}
+
+		first  = []Endpoint{{Value: "http://first.comcast.net:8080"}}
+		second = []Endpoint{{Value: "http://second.comcast.net:8080"}}
+		third  = []Endpoint{{Value: "http://third.comcast.net:8080"}}
+	)
+
+	registrar.On("Watch").Once().Return(watch, nil)
+
+	immediate := subscription.AddListener(func(endpoints []Endpoint) { immediateOutput <- endpoints }, 0)
+	subscription.AddListener(func(endpoints []Endpoint) { delayedOutput <- endpoints }, time.Minute)
+	subscription.AddListener(func([]Endpoint) { panic("the other listeners should still be notified") }, 0)
+	require.NoError(subscription.Run())
+
+	watch.NextEndpoints(EndpointValues(first))
+	assert.Equal(first, <-immediateOutput)
+	delay := <-delays
+
+	// the delayed listener keeps only the most recent update while its timeout is in effect
+	watch.NextEndpoints(EndpointValues(second))
+	assert.Equal(second, <-immediateOutput)
+
+	select {
+	case <-delayedOutput:
+		assert.Fail("The delayed listener should not have been notified yet")
+	default:
+	}
+
+	delay <- time.Now()
+	assert.Equal(second, <-delayedOutput)
+
+	// a listener added while running is sent the most recent endpoints
+	late := subscription.AddListener(func(endpoints []Endpoint) { lateOutput <- endpoints }, 0)
+	assert.Equal(second, <-lateOutput)
+
+	assert.True(subscription.RemoveListener(immediate))
+	assert.False(subscription.RemoveListener(immediate))
+	assert.False(subscription.RemoveListener(new(SubscriptionListener)))
+
+	watch.NextEndpoints(EndpointValues(third))
+	assert.Equal(third, <-lateOutput)
+	(<-delays) <- time.Now()
+	assert.Equal(third, <-delayedOutput)
+
+	select {
+	case <-immediateOutput:
+		assert.Fail("A removed listener should not be notified")
+	default:
+	}
+
+	assert.NoError(subscription.Cancel())
+	assert.True(subscription.RemoveListener(late))
+
+	// listeners added while not running are started by the next run
+	var (
+		restarted = NewTestWatch(t)
+		output    = make(chan []Endpoint, 1)
+	)
+
+	registrar.On("Watch").Once().Return(restarted, nil)
+	subscription.AddListener(func(endpoints []Endpoint) { output <- endpoints }, 0)
+	require.NoError(subscription.Run())
+
+	restarted.NextEndpoints(EndpointValues(first))
+	assert.Equal(first, <-output)
+	(<-delays) <- time.Now()
+	assert.Equal(first, <-delayedOutput)
+
+	assert.NoError(subscription.Cancel())
+	registrar.AssertExpectations(t)
+}
+
+// newWatchdogAfter produces an After function for a subscription with the given WatchdogInterval.  Each timer
+// it creates is sent on the returned channel, so that tests can fire the timers.
+func newWatchdogAfter(t *testing.T, interval time.Duration) (func(time.Duration) <-chan time.Time, <-chan chan time.Time) {
+	timers := make(chan chan time.Time, 10)
+	return func(d time.Duration) <-chan time.Time {
+		assert.Equal(t, interval, d)
+		timer := make(chan time.Time, 1)
+		timers <- timer
+		return timer
+	}, timers
+}
+
+// nextWatchdog waits for the subscription to start its next watchdog timer
+func nextWatchdog(t *testing.T, timers <-chan chan time.Time) chan<- time.Time {
+	select {
+	case timer := <-timers:
+		return timer
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "The watchdog was not started")
+		return nil
+	}
+}
+
+func testSubscriptionWatchdogReplace(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		silent        = newFakeWatch()
+		replacement   = newFakeWatch("http://replacement.com:8080")
+		registrar     = new(mockRegistrar)
+		after, timers = newWatchdogAfter(t, time.Minute)
+
+		listenerOutput = make(chan []string, 1)
+		watchdogEvents = make(chan WatchdogEvent, 1)
+	)
+
+	registry, err := xmetrics.NewRegistry(&xmetrics.Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NoError(err)
+
+	subscription := Subscription{
+		Registrar:        registrar,
+		Metrics:          registry,
+		After:            after,
+		WatchdogInterval: time.Minute,
+		Listener: func(endpoints []string) {
+			listenerOutput <- endpoints
+		},
+		WatchdogListener: func(event WatchdogEvent) {
+			watchdogEvents <- event
+		},
+	}
+
+	registrar.On("Watch").Return(silent, nil).Once()
+	registrar.On("Watch").Return(replacement, nil).Once()
+	require.NoError(subscription.Run())
+	nextWatchdog(t, timers)
+
+	// each event restarts the watchdog
+	silent.set("http://silent.com:8080")
+	assert.Equal([]string{"http://silent.com:8080"}, <-listenerOutput)
+	nextWatchdog(t, timers) <- time.Now()
+
+	select {
+	case event := <-watchdogEvents:
+		assert.Equal(WatchdogEvent{Silent: silent, Replacement: replacement}, event)
+	case <-time.After(5 * time.Second):
+		require.Fail("No watchdog event was dispatched")
+	}
+
+	// the endpoints the replacement already has are dispatched without waiting for an event
+	assert.Equal([]string{"http://replacement.com:8080"}, <-listenerOutput)
+	assert.True(silent.IsClosed())
+	assert.False(replacement.IsClosed())
+	nextWatchdog(t, timers)
+
+	replacement.set("http://updated.com:8080")
+	assert.Equal([]string{"http://updated.com:8080"}, <-listenerOutput)
+
+	assert.NoError(subscription.Cancel())
+	assert.True(replacement.IsClosed())
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	body := response.Body.String()
+	assert.True(strings.Contains(body, WatchRestartCount+" 1"), body)
+
+	registrar.AssertExpectations(t)
+}
+
+func testSubscriptionWatchdogEmptyReplacement(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		silent        = newFakeWatch()
+		replacement   = newFakeWatch()
+		registrar     = new(mockRegistrar)
+		after, timers = newWatchdogAfter(t, time.Minute)
+
+		listenerOutput = make(chan []string, 1)
+		subscription   = Subscription{
+			Registrar:        registrar,
+			After:            after,
+			WatchdogInterval: time.Minute,
+			Listener: func(endpoints []string) {
+				listenerOutput <- endpoints
+			},
+		}
+	)
+
+	registrar.On("Watch").Return(silent, nil).Once()
+	registrar.On("Watch").Return(replacement, nil).Once()
+	require.NoError(subscription.Run())
+
+	silent.set("http://silent.com:8080")
+	assert.Equal([]string{"http://silent.com:8080"}, <-listenerOutput)
+	nextWatchdog(t, timers)
+	nextWatchdog(t, timers) <- time.Now()
+	nextWatchdog(t, timers)
+
+	// the last endpoints are kept until the replacement reports an event
+	select {
+	case endpoints := <-listenerOutput:
+		assert.Fail("An empty replacement should not be dispatched", "endpoints: %v", endpoints)
+	default:
+	}
+
+	assert.Equal([]Endpoint{{Value: "http://silent.com:8080"}}, subscription.Endpoints())
+	replacement.set("http://replacement.com:8080")
+	assert.Equal([]string{"http://replacement.com:8080"}, <-listenerOutput)
+
+	assert.NoError(subscription.Cancel())
+	registrar.AssertExpectations(t)
+}
+
+func testSubscriptionWatchdogHeartbeat(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		watch         = newHeartbeatWatch("http://heartbeat.com:8080")
+		registrar     = new(mockRegistrar)
+		after, timers = newWatchdogAfter(t, time.Minute)
+
+		subscription = Subscription{
+			Registrar:        registrar,
+			After:            after,
+			WatchdogInterval: time.Minute,
+			Listener:         func([]string) {},
+			WatchdogListener: func(WatchdogEvent) {
+				assert.Fail("A watch with a heartbeat is not silent")
+			},
+		}
+	)
+
+	registrar.On("Watch").Return(watch, nil).Once()
+	require.NoError(subscription.Run())
+	first := nextWatchdog(t, timers)
+
+	// a heartbeat restarts the watchdog, so the first timer no longer applies
+	watch.heartbeat <- struct{}{}
+	nextWatchdog(t, timers)
+	first <- time.Now()
+
+	watch.heartbeat <- struct{}{}
+	nextWatchdog(t, timers)
+	assert.False(watch.IsClosed())
+
+	assert.NoError(subscription.Cancel())
+	assert.True(watch.IsClosed())
+	registrar.AssertExpectations(t)
+}
+
+func testSubscriptionWatchdogError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		expectedError = errors.New("expected")
+
+		silent        = newFakeWatch()
+		registrar     = new(mockRegistrar)
+		after, timers = newWatchdogAfter(t, time.Minute)
+
+		listenerOutput = make(chan []string, 1)
+		watchdogEvents = make(chan WatchdogEvent, 1)
+		subscription   = Subscription{
+			Registrar:        registrar,
+			After:            after,
+			WatchdogInterval: time.Minute,
+			Listener: func(endpoints []string) {
+				listenerOutput <- endpoints
+			},
+			WatchdogListener: func(event WatchdogEvent) {
+				watchdogEvents <- event
+			},
+		}
+	)
+
+	registrar.On("Watch").Return(silent, nil).Once()
+	registrar.On("Watch").Return(nil, expectedError).Once()
+	registrar.On("Watch").Return(nil, ErrorStopped).Once()
+	require.NoError(subscription.Run())
+
+	// the silent watch is kept, and the watchdog tries again later
+	nextWatchdog(t, timers) <- time.Now()
+	assert.Equal(WatchdogEvent{Silent: silent, Err: expectedError}, <-watchdogEvents)
+	nextWatchdog(t, timers)
+	assert.False(silent.IsClosed())
+
+	silent.set("http://silent.com:8080")
+	assert.Equal([]string{"http://silent.com:8080"}, <-listenerOutput)
+	nextWatchdog(t, timers) <- time.Now()
+
+	// a stopped registrar ends the subscription
+	assert.Equal(WatchdogEvent{Silent: silent, Err: ErrorStopped}, <-watchdogEvents)
+	for deadline := time.Now().Add(5 * time.Second); !silent.IsClosed(); time.Sleep(10 * time.Millisecond) {
+		require.True(time.Now().Before(deadline), "The subscription did not end")
+	}
+
+	assert.Equal(ErrorNotRunning, subscription.Cancel())
+	registrar.AssertExpectations(t)
+}
+
 func TestSubscription(t *testing.T) {
 	t.Run("WatchError", testSubscriptionWatchError)
 	t.Run("ListenerPanic", testSubscriptionListenerPanic)
 	t.Run("NoTimeout", testSubscriptionNoTimeout)
 	t.Run("WithTimeout", testSubscriptionWithTimeout)
+	t.Run("Metrics", testSubscriptionMetrics)
+	t.Run("EndpointListener", testSubscriptionEndpointListener)
+	t.Run("AddListener", testSubscriptionAddListener)
+
+	t.Run("Warmup", func(t *testing.T) {
+		t.Run("InitialEndpoints", testSubscriptionWarmupInitialEndpoints)
+		t.Run("FirstEvent", testSubscriptionWarmupFirstEvent)
+		t.Run("Timeout", testSubscriptionWarmupTimeout)
+		t.Run("WatchClosed", testSubscriptionWarmupWatchClosed)
+	})
+
+	t.Run("Watchdog", func(t *testing.T) {
+		t.Run("Replace", testSubscriptionWatchdogReplace)
+		t.Run("EmptyReplacement", testSubscriptionWatchdogEmptyReplacement)
+		t.Run("Heartbeat", testSubscriptionWatchdogHeartbeat)
+		t.Run("Error", testSubscriptionWatchdogError)
+	})
 }
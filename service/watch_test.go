@@ -93,3 +93,18 @@ func NewTestWatch(t *testing.T) *TestWatch {
 		endpoints: make(chan []string),
 	}
 }
+
+// metadataTestWatch is a TestWatch that also reports fixed metadata for each of its endpoints
+type metadataTestWatch struct {
+	*TestWatch
+	metadata map[string]map[string]string
+}
+
+func (mw *metadataTestWatch) EndpointsWithMetadata() []Endpoint {
+	endpoints := NewEndpoints(mw.Endpoints())
+	for i := range endpoints {
+		endpoints[i].Metadata = mw.metadata[endpoints[i].Value]
+	}
+
+	return endpoints
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"strconv"
+)
+
+const (
+	// WeightMetadataKey is the registration metadata key that carries an endpoint's weight.  Weights are
+	// relative to DefaultWeight, so an endpoint registered with a weight of 50 receives roughly half the
+	// share of keys of an endpoint with no weight.  During a blue/green rollout, the weights of the new
+	// nodes can be raised gradually while those of the old nodes are lowered to 0.
+	WeightMetadataKey = "weight"
+
+	// DefaultWeight is the weight of an endpoint whose metadata does not carry a valid weight
+	DefaultWeight = 100
+
+	// MaxWeight is the largest weight an endpoint can have.  Larger weights are reduced to this value.
+	MaxWeight = 10 * DefaultWeight
+)
+
+// Endpoint is a discovered endpoint along with the metadata published when it was registered
+type Endpoint struct {
+	// Value is the endpoint, in the same form reported by Watch.Endpoints
+	Value string `json:"value"`
+
+	// Metadata is the registration metadata of this endpoint, which is nil if the Watch that discovered
+	// this endpoint does not report metadata
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Weight returns this endpoint's weight, parsed from WeightMetadataKey.  DefaultWeight is returned if
+// the metadata has no weight or the weight is not a nonnegative integer.  A weight of 0 means that no
+// keys should be routed to this endpoint.
+func (e Endpoint) Weight() int {
+	if value, ok := e.Metadata[WeightMetadataKey]; ok {
+		if weight, err := strconv.Atoi(value); err == nil && weight >= 0 {
+			if weight > MaxWeight {
+				return MaxWeight
+			}
+
+			return weight
+		}
+	}
+
+	return DefaultWeight
+}
+
+// MetadataWatch is implemented by Watches that can report the registration metadata of each endpoint
+type MetadataWatch interface {
+	Watch
+
+	// EndpointsWithMetadata returns the same endpoints as Endpoints, in the same order, along with their metadata
+	EndpointsWithMetadata() []Endpoint
+}
+
+// WatchEndpoints returns the current endpoints of a watch along with their metadata.  If the watch is not
+// a MetadataWatch, each endpoint has nil metadata.
+func WatchEndpoints(watch Watch) []Endpoint {
+	if metadataWatch, ok := watch.(MetadataWatch); ok {
+		return metadataWatch.EndpointsWithMetadata()
+	}
+
+	return NewEndpoints(watch.Endpoints())
+}
+
+// NewEndpoints produces Endpoints, with no metadata, from endpoint strings
+func NewEndpoints(values []string) []Endpoint {
+	if values == nil {
+		return nil
+	}
+
+	endpoints := make([]Endpoint, len(values))
+	for i, value := range values {
+		endpoints[i].Value = value
+	}
+
+	return endpoints
+}
+
+// EndpointValues returns the endpoint strings of a slice of Endpoints
+func EndpointValues(endpoints []Endpoint) []string {
+	if endpoints == nil {
+		return nil
+	}
+
+	values := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		values[i] = endpoint.Value
+	}
+
+	return values
+}
+
+// WeightedAccessorFactory is implemented by AccessorFactories whose Accessors can route keys to endpoints
+// in proportion to their weights.  NewRingAccessorFactory produces a WeightedAccessorFactory.  The default
+// factory from NewAccessorFactory is also a WeightedAccessorFactory, but only honors weights of 0.
+type WeightedAccessorFactory interface {
+	AccessorFactory
+
+	// NewWeighted creates an Accessor from a slice of endpoints, accepting the same endpoints as New.  The
+	// returned slice of strings is the sorted, deduped list of base URLs that were added with a nonzero weight.
+	NewWeighted([]Endpoint) (Accessor, []string)
+}
+
+// newAccessor creates an Accessor using the weights of the endpoints, if the factory supports weights.
+// Otherwise, the weights are ignored, which is logged if any endpoint has a weight other than DefaultWeight.
+func newAccessor(logger logging.Logger, factory AccessorFactory, endpoints []Endpoint) (Accessor, []string) {
+	if weighted, ok := factory.(WeightedAccessorFactory); ok {
+		return weighted.NewWeighted(endpoints)
+	}
+
+	for _, endpoint := range endpoints {
+		if endpoint.Weight() != DefaultWeight {
+			logger.Warn("Endpoint weights are not supported by %T and are being ignored", factory)
+			break
+		}
+	}
+
+	return factory.New(EndpointValues(endpoints))
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+func TestEndpointWeight(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		metadata map[string]string
+		expected int
+	}{
+		{nil, DefaultWeight},
+		{map[string]string{"foo": "bar"}, DefaultWeight},
+		{map[string]string{WeightMetadataKey: "50"}, 50},
+		{map[string]string{WeightMetadataKey: "0"}, 0},
+		{map[string]string{WeightMetadataKey: "-1"}, DefaultWeight},
+		{map[string]string{WeightMetadataKey: "heavy"}, DefaultWeight},
+		{map[string]string{WeightMetadataKey: "1000000"}, MaxWeight},
+	} {
+		assert.Equal(testCase.expected, Endpoint{Value: "http://host:8080", Metadata: testCase.metadata}.Weight(), "%v", testCase.metadata)
+	}
+}
+
+func TestEndpointValues(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(NewEndpoints(nil))
+	assert.Nil(EndpointValues(nil))
+	assert.Equal([]Endpoint{}, NewEndpoints([]string{}))
+
+	endpoints := NewEndpoints([]string{"host1:80", "host2:80"})
+	assert.Equal([]Endpoint{{Value: "host1:80"}, {Value: "host2:80"}}, endpoints)
+	assert.Equal([]string{"host1:80", "host2:80"}, EndpointValues(endpoints))
+}
+
+func TestWatchEndpoints(t *testing.T) {
+	assert := assert.New(t)
+
+	watch := new(mockWatch)
+	watch.On("Endpoints").Return([]string{"host1:80"}).Once()
+	assert.Equal([]Endpoint{{Value: "host1:80"}}, WatchEndpoints(watch))
+	watch.AssertExpectations(t)
+
+	metadataWatch := &metadataTestWatch{
+		TestWatch: NewTestWatch(t),
+		metadata:  map[string]map[string]string{"host1:80": {WeightMetadataKey: "10"}},
+	}
+
+	go func() { metadataWatch.endpoints <- []string{"host1:80", "host2:80"} }()
+	assert.Equal(
+		[]Endpoint{{Value: "host1:80", Metadata: map[string]string{WeightMetadataKey: "10"}}, {Value: "host2:80"}},
+		WatchEndpoints(metadataWatch),
+	)
+}
+
+// ringShares counts the keys owned by each endpoint of an Accessor
+func ringShares(t *testing.T, accessor Accessor, keyCount int) map[string]int {
+	shares := make(map[string]int)
+	for _, owner := range ringOwners(t, accessor, keyCount) {
+		shares[owner]++
+	}
+
+	return shares
+}
+
+func TestRingFactoryWeighted(t *testing.T) {
+	const keyCount = 10000
+
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		factory = NewRingAccessorFactory(&Options{Logger: logging.TestLogger(t)}).(WeightedAccessorFactory)
+
+		weighted = func(weights ...string) []Endpoint {
+			endpoints := make([]Endpoint, 0, len(weights))
+			for i, weight := range weights {
+				endpoint := Endpoint{Value: "http://host" + string('1'+byte(i)) + ":8080"}
+				if len(weight) > 0 {
+					endpoint.Metadata = map[string]string{WeightMetadataKey: weight}
+				}
+
+				endpoints = append(endpoints, endpoint)
+			}
+
+			return endpoints
+		}
+	)
+
+	// with default weights, a weighted ring is the same as an unweighted one
+	unweighted, _ := factory.New([]string{"http://host1:8080", "http://host2:8080"})
+	accessor, baseURLs := factory.NewWeighted(weighted("", ""))
+	assert.Equal(unweighted, accessor)
+	assert.Equal([]string{"http://host1:8080", "http://host2:8080"}, baseURLs)
+
+	// keys are shared in proportion to weight
+	accessor, baseURLs = factory.NewWeighted(weighted("300", "100"))
+	require.Len(baseURLs, 2)
+	assert.Len(accessor, 4*DefaultVnodeCount)
+	assert.True(sort.IsSorted(accessor.(ring)))
+
+	shares := ringShares(t, accessor, keyCount)
+	assert.InDelta(keyCount*3/4, shares["http://host1:8080"], keyCount/10)
+	assert.InDelta(keyCount/4, shares["http://host2:8080"], keyCount/10)
+
+	// shifting weight onto an endpoint only moves keys onto that endpoint
+	before := ringOwners(t, accessor, keyCount)
+	accessor, _ = factory.NewWeighted(weighted("300", "200"))
+	for key, owner := range ringOwners(t, accessor, keyCount) {
+		if owner != before[key] {
+			assert.Equal("http://host2:8080", owner)
+		}
+	}
+
+	// endpoints with a weight of 0 are drained
+	accessor, baseURLs = factory.NewWeighted(weighted("0", "10"))
+	assert.Equal([]string{"http://host2:8080"}, baseURLs)
+	assert.Len(accessor, DefaultVnodeCount*10/DefaultWeight)
+	assert.Equal(map[string]int{"http://host2:8080": 100}, ringShares(t, accessor, 100))
+
+	// every endpoint with some weight owns at least one point
+	accessor, _ = NewRingAccessorFactory(&Options{VnodeCount: 10}).(WeightedAccessorFactory).NewWeighted(weighted("1"))
+	assert.Len(accessor, 1)
+
+	// when every endpoint is drained, weights are ignored
+	accessor, baseURLs = factory.NewWeighted(weighted("0", "0"))
+	assert.Equal([]string{"http://host1:8080", "http://host2:8080"}, baseURLs)
+	assert.Equal(unweighted, accessor)
+
+	accessor, baseURLs = factory.NewWeighted(nil)
+	assert.Empty(baseURLs)
+	_, err := accessor.Get([]byte("key"))
+	assert.Equal(ErrorNoEndpoints, err)
+}
+
+func TestConsistentHashFactoryWeighted(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		factory = NewAccessorFactory(&Options{Logger: logging.TestLogger(t)}).(WeightedAccessorFactory)
+
+		drained = map[string]string{WeightMetadataKey: "0"}
+		heavy   = map[string]string{WeightMetadataKey: "300"}
+	)
+
+	// endpoints with a weight of 0 are drained
+	accessor, baseURLs := factory.NewWeighted([]Endpoint{{Value: "host1:80", Metadata: drained}, {Value: "host2:80"}})
+	assert.Equal([]string{"http://host2:80"}, baseURLs)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		instance, err := accessor.Get([]byte(key))
+		require.NoError(err)
+		assert.Equal("http://host2:80", instance)
+	}
+
+	// other weights are ignored
+	_, baseURLs = factory.NewWeighted([]Endpoint{{Value: "host1:80", Metadata: heavy}, {Value: "host2:80"}})
+	assert.Equal([]string{"http://host1:80", "http://host2:80"}, baseURLs)
+
+	// when every endpoint is drained, weights are ignored
+	_, baseURLs = factory.NewWeighted([]Endpoint{{Value: "host1:80", Metadata: drained}, {Value: "host2:80", Metadata: drained}})
+	assert.Equal([]string{"http://host1:80", "http://host2:80"}, baseURLs)
+}
+
+func TestUpdatableAccessorUpdateEndpoints(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		factory  = new(mockAccessorFactory)
+		expected = new(mockAccessor)
+		accessor = &updatableAccessor{logger: logging.TestLogger(t), factory: factory}
+	)
+
+	// without a WeightedAccessorFactory, metadata is ignored
+	factory.On("New", []string{"host1:80", "host2:80"}).Return(expected, []string{"http://host1:80", "http://host2:80"}).Once()
+	expected.On("Get", []byte("key")).Return("http://host2:80", nil).Once()
+
+	accessor.UpdateEndpoints([]Endpoint{{Value: "host1:80", Metadata: map[string]string{WeightMetadataKey: "0"}}, {Value: "host2:80"}})
+	instance, err := accessor.Get([]byte("key"))
+	require.NoError(err)
+	assert.Equal("http://host2:80", instance)
+
+	ring := NewRingAccessor(nil, nil)
+	ring.UpdateEndpoints([]Endpoint{{Value: "host1:80", Metadata: map[string]string{WeightMetadataKey: "0"}}, {Value: "host2:80"}})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		instance, err := ring.Get([]byte(key))
+		require.NoError(err)
+		assert.Equal("http://host2:80", instance)
+	}
+
+	factory.AssertExpectations(t)
+	expected.AssertExpectations(t)
+}
+
+func TestZoneAccessorUpdateEndpoints(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		accessor = NewZoneAccessor(&Options{Zone: "east"}, NewRingAccessorFactory(nil), nil)
+	)
+
+	// weights and metadata apply within each zone
+	accessor.UpdateEndpoints([]Endpoint{
+		{Value: "east/http://talaria-east-1.comcast.net:8080", Metadata: map[string]string{WeightMetadataKey: "0"}},
+		{Value: "east/http://talaria-east-2.comcast.net:8080"},
+		{Value: "west/http://talaria-west.comcast.net:8080"},
+	})
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		instance, err := accessor.Get([]byte(key))
+		require.NoError(err)
+		assert.Equal("http://talaria-east-2.comcast.net:8080", instance)
+	}
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"errors"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/strava/go.serversets"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrorNoLocalRegistrar = errors.New("No registrar is configured for the local zone")
+)
+
+// ZoneEndpoint tags an endpoint with a zone, producing a string of the form zone/endpoint.  This is the
+// same form as Identity.ID.  If zone is empty, the endpoint is returned unchanged.
+func ZoneEndpoint(zone, endpoint string) string {
+	if len(zone) > 0 {
+		return zone + "/" + endpoint
+	}
+
+	return endpoint
+}
+
+// ParseZoneEndpoint splits a value produced by ZoneEndpoint into its zone and endpoint.  Untagged
+// endpoints, such as those reported by a single Registrar's watch, have an empty zone.
+func ParseZoneEndpoint(value string) (zone, endpoint string) {
+	// the zone separator must precede any scheme, which also contains slashes
+	prefix := value
+	if i := strings.Index(value, "://"); i >= 0 {
+		prefix = value[:i]
+	}
+
+	if i := strings.IndexByte(prefix, '/'); i >= 0 {
+		return value[:i], value[i+1:]
+	}
+
+	return "", value
+}
+
+// zoneOrder sorts zones by preference: the local zone first, then each fallback zone as configured,
+// then any other zones in lexical order
+func zoneOrder(local string, fallback []string, zones map[string][]Endpoint) []string {
+	var (
+		ordered = make([]string, 0, len(zones))
+		added   = make(map[string]bool, len(zones))
+		add     = func(zone string) {
+			if _, ok := zones[zone]; ok && !added[zone] {
+				added[zone] = true
+				ordered = append(ordered, zone)
+			}
+		}
+	)
+
+	add(local)
+	for _, zone := range fallback {
+		add(zone)
+	}
+
+	others := make([]string, 0, len(zones))
+	for zone := range zones {
+		if !added[zone] {
+			others = append(others, zone)
+		}
+	}
+
+	sort.Strings(others)
+	return append(ordered, others...)
+}
+
+// zoneAccessors is an immutable list of per-zone Accessors, in order of preference
+type zoneAccessors []Accessor
+
+func (za zoneAccessors) Get(key []byte) (string, error) {
+	if len(za) == 0 {
+		return "", ErrorNoEndpoints
+	}
+
+	return za[0].Get(key)
+}
+
+// zoneAccessor is the UpdatableAccessor returned by NewZoneAccessor
+type zoneAccessor struct {
+	logger   logging.Logger
+	factory  AccessorFactory
+	local    string
+	fallback []string
+	accessor atomic.Value
+}
+
+// NewZoneAccessor produces an UpdatableAccessor for endpoints tagged with their zones, as produced by
+// ZoneEndpoint or by the watches of a ZoneRegistrar.  Keys are hashed only among the endpoints of the
+// most preferred zone that has any: Options.Zone, then each of Options.FallbackZones, then any remaining
+// zones in lexical order.  When every endpoint of the local zone disappears, keys fail over to the next
+// zone, and they fail back once local endpoints are available again.
+//
+// The factory creates the Accessor for each zone.  If nil, NewAccessorFactory is used.  Untagged endpoints
+// belong to the empty zone.
+func NewZoneAccessor(o *Options, factory AccessorFactory, initialEndpoints []string) UpdatableAccessor {
+	if factory == nil {
+		factory = NewAccessorFactory(o)
+	}
+
+	accessor := &zoneAccessor{
+		logger:   o.logger(),
+		factory:  factory,
+		local:    o.zone(),
+		fallback: o.fallbackZones(),
+	}
+
+	accessor.Update(initialEndpoints)
+	return accessor
+}
+
+func (za *zoneAccessor) Get(key []byte) (string, error) {
+	return za.accessor.Load().(zoneAccessors).Get(key)
+}
+
+func (za *zoneAccessor) Update(endpoints []string) {
+	za.UpdateEndpoints(NewEndpoints(endpoints))
+}
+
+func (za *zoneAccessor) UpdateEndpoints(endpoints []Endpoint) {
+	zones := make(map[string][]Endpoint)
+	for _, tagged := range endpoints {
+		zone, endpoint := ParseZoneEndpoint(tagged.Value)
+		zones[zone] = append(zones[zone], Endpoint{Value: endpoint, Metadata: tagged.Metadata})
+	}
+
+	accessors := make(zoneAccessors, 0, len(zones))
+	for _, zone := range zoneOrder(za.local, za.fallback, zones) {
+		// a zone whose endpoints are all invalid cannot serve any keys
+		if accessor, baseURLs := newAccessor(za.logger, za.factory, zones[zone]); len(baseURLs) > 0 {
+			accessors = append(accessors, accessor)
+		}
+	}
+
+	za.accessor.Store(accessors)
+}
+
+// ZoneRegistrar is a Registrar that spans several zones, each with its own Registrar.  Endpoints are
+// registered only in the local zone, given by Options.Zone.  Watches merge the endpoints of every zone,
+// tagging each with its zone via ZoneEndpoint, which makes them suitable for a Subscription whose
+// Listener is the Update method of a NewZoneAccessor.
+//
+// The Registrars are owned by the caller, and a ZoneRegistrar does not stop them.
+type ZoneRegistrar struct {
+	logger     logging.Logger
+	local      string
+	registrars map[string]Registrar
+}
+
+// NewZoneRegistrar creates a ZoneRegistrar for Registrars keyed by zone.  The Registrar for Options.Zone,
+// if any, is used for registrations.
+func NewZoneRegistrar(o *Options, registrars map[string]Registrar) *ZoneRegistrar {
+	copied := make(map[string]Registrar, len(registrars))
+	for zone, registrar := range registrars {
+		copied[zone] = registrar
+	}
+
+	return &ZoneRegistrar{
+		logger:     o.logger(),
+		local:      o.zone(),
+		registrars: copied,
+	}
+}
+
+func (r *ZoneRegistrar) RegisterEndpoint(host string, port int, ping func() error) (*serversets.Endpoint, error) {
+	return r.RegisterEndpointWithMetadata(host, port, ping, nil)
+}
+
+// RegisterEndpointWithMetadata registers an endpoint with the local zone's Registrar.  The metadata is
+// published only if that Registrar is a MetadataRegistrar.
+func (r *ZoneRegistrar) RegisterEndpointWithMetadata(host string, port int, ping func() error, metadata map[string]string) (*serversets.Endpoint, error) {
+	registrar, ok := r.registrars[r.local]
+	if !ok {
+		return nil, ErrorNoLocalRegistrar
+	}
+
+	if metadataRegistrar, ok := registrar.(MetadataRegistrar); ok {
+		return metadataRegistrar.RegisterEndpointWithMetadata(host, port, ping, metadata)
+	}
+
+	return registrar.RegisterEndpoint(host, port, ping)
+}
+
+// Watch creates a watch against each zone's Registrar.  If any of them fails, the watches already
+// created are closed and the error is returned.
+//
+// The returned watch remains open while any zone's watch is open.  The endpoints of a zone whose
+// watch closes are dropped, so that an Accessor fails over to the remaining zones.
+func (r *ZoneRegistrar) Watch() (Watch, error) {
+	watches := make(map[string]Watch, len(r.registrars))
+	for zone, registrar := range r.registrars {
+		watch, err := registrar.Watch()
+		if err != nil {
+			for _, created := range watches {
+				created.Close()
+			}
+
+			return nil, err
+		}
+
+		watches[zone] = watch
+	}
+
+	w := &zoneWatch{
+		logger:   r.logger,
+		watches:  watches,
+		event:    make(chan struct{}, 1),
+		shutdown: make(chan struct{}),
+	}
+
+	w.lock.Lock()
+	w.update()
+	w.lock.Unlock()
+
+	for zone, watch := range watches {
+		go w.monitor(zone, watch)
+	}
+
+	return w, nil
+}
+
+// zoneWatch is the Watch implementation returned by ZoneRegistrar
+type zoneWatch struct {
+	logger   logging.Logger
+	event    chan struct{}
+	shutdown chan struct{}
+	closed   int32
+
+	lock      sync.Mutex
+	watches   map[string]Watch
+	endpoints []Endpoint
+	values    []string
+}
+
+// monitor is the goroutine which merges the endpoints of a single zone's watch into this watch
+func (w *zoneWatch) monitor(zone string, watch Watch) {
+	for {
+		select {
+		case <-w.shutdown:
+			return
+
+		case <-watch.Event():
+			w.lock.Lock()
+			if watch.IsClosed() {
+				if !w.IsClosed() {
+					w.logger.Error("The watch for zone [%s] closed, and its endpoints are no longer available", zone)
+				}
+
+				delete(w.watches, zone)
+			}
+
+			w.update()
+			remaining := len(w.watches)
+			w.lock.Unlock()
+
+			if remaining == 0 {
+				w.close()
+				return
+			}
+
+			w.signal()
+			if watch.IsClosed() {
+				return
+			}
+		}
+	}
+}
+
+// update recomputes the merged endpoints of all open zone watches, along with any metadata they report.
+// This method must be called under the lock.
+func (w *zoneWatch) update() {
+	endpoints := make([]Endpoint, 0, len(w.endpoints))
+	for zone, watch := range w.watches {
+		for _, endpoint := range WatchEndpoints(watch) {
+			endpoints = append(endpoints, Endpoint{Value: ZoneEndpoint(zone, endpoint.Value), Metadata: endpoint.Metadata})
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Value < endpoints[j].Value })
+	w.endpoints = endpoints
+	w.values = EndpointValues(endpoints)
+}
+
+func (w *zoneWatch) signal() {
+	select {
+	case w.event <- struct{}{}:
+	default:
+	}
+}
+
+// close marks this watch as closed, stops monitoring the zone watches, and wakes up any goroutine waiting on it
+func (w *zoneWatch) close() {
+	if atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		close(w.shutdown)
+		w.signal()
+	}
+}
+
+func (w *zoneWatch) Close() {
+	w.close()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, watch := range w.watches {
+		watch.Close()
+	}
+}
+
+func (w *zoneWatch) IsClosed() bool {
+	return atomic.LoadInt32(&w.closed) != 0
+}
+
+func (w *zoneWatch) Event() <-chan struct{} {
+	return w.event
+}
+
+func (w *zoneWatch) Endpoints() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.values
+}
+
+func (w *zoneWatch) EndpointsWithMetadata() []Endpoint {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.endpoints
+}
+
+func (w *zoneWatch) String() string {
+	return "zoneWatch"
+}
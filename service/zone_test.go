@@ -0,0 +1,364 @@
+package service
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestZoneEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, record := range []struct {
+		value            string
+		expectedZone     string
+		expectedEndpoint string
+	}{
+		{"http://talaria-1.comcast.net:8080", "", "http://talaria-1.comcast.net:8080"},
+		{"talaria-1.comcast.net:8080", "", "talaria-1.comcast.net:8080"},
+		{"east/http://talaria-1.comcast.net:8080", "east", "http://talaria-1.comcast.net:8080"},
+		{"east/talaria-1.comcast.net:8080", "east", "talaria-1.comcast.net:8080"},
+		{"us/east/https://talaria-1.comcast.net:8443", "us", "east/https://talaria-1.comcast.net:8443"},
+	} {
+		zone, endpoint := ParseZoneEndpoint(record.value)
+		assert.Equal(record.expectedZone, zone, record.value)
+		assert.Equal(record.expectedEndpoint, endpoint, record.value)
+
+		if len(record.expectedZone) > 0 {
+			assert.Equal(record.value, ZoneEndpoint(zone, endpoint))
+		}
+	}
+
+	assert.Equal("http://talaria-1.comcast.net:8080", ZoneEndpoint("", "http://talaria-1.comcast.net:8080"))
+	assert.Equal("east/https://talaria-1.comcast.net:8443", ZoneEndpoint("east", "https://talaria-1.comcast.net:8443"))
+
+	i := &Identity{Scheme: "https", Host: "talaria-1.comcast.net", Port: 8443, Zone: "east"}
+	zone, endpoint := ParseZoneEndpoint(i.ID())
+	assert.Equal("east", zone)
+	assert.Equal("https://talaria-1.comcast.net:8443", endpoint)
+}
+
+func TestZoneOrder(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		zones  = map[string][]Endpoint{"east": nil, "west": nil, "central": nil, "": nil}
+	)
+
+	assert.Equal([]string{"", "central", "east", "west"}, zoneOrder("", nil, zones))
+	assert.Equal([]string{"west", "", "central", "east"}, zoneOrder("west", nil, zones))
+	assert.Equal([]string{"west", "east", "", "central"}, zoneOrder("west", []string{"missing", "east", "west"}, zones))
+	assert.Equal([]string{"central", "east"}, zoneOrder("missing", nil, map[string][]Endpoint{"east": nil, "central": nil}))
+	assert.Empty(zoneOrder("east", []string{"west"}, nil))
+}
+
+func TestOptionsFallbackZones(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil((*Options)(nil).fallbackZones())
+	assert.Nil(new(Options).fallbackZones())
+	assert.Equal([]string{"central", "west"}, (&Options{FallbackZones: []string{"central", "west"}}).fallbackZones())
+}
+
+func TestZoneAccessor(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		accessor = NewZoneAccessor(
+			&Options{Zone: "east", FallbackZones: []string{"central"}},
+			nil,
+			[]string{
+				"east/http://talaria-east.comcast.net:8080",
+				"west/http://talaria-west.comcast.net:8080",
+				"central/http://talaria-central.comcast.net:8080",
+				"http://talaria.comcast.net:8080",
+			},
+		)
+
+		assertGet = func(expected string) {
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				actual, err := accessor.Get([]byte(key))
+				require.NoError(err)
+				assert.Equal(expected, actual)
+			}
+		}
+	)
+
+	assertGet("http://talaria-east.comcast.net:8080")
+
+	// the local zone loses all of its endpoints, so keys move to the first fallback
+	accessor.Update([]string{
+		"west/http://talaria-west.comcast.net:8080",
+		"central/http://talaria-central.comcast.net:8080",
+		"http://talaria.comcast.net:8080",
+	})
+
+	assertGet("http://talaria-central.comcast.net:8080")
+
+	// zones whose endpoints are all invalid are skipped, and unlisted zones follow in lexical order
+	accessor.Update([]string{
+		"west/http://talaria-west.comcast.net:8080",
+		"central/this is not valid",
+		"http://talaria.comcast.net:8080",
+	})
+
+	assertGet("http://talaria.comcast.net:8080")
+
+	accessor.Update([]string{"west/http://talaria-west.comcast.net:8080"})
+	assertGet("http://talaria-west.comcast.net:8080")
+
+	// fail back as soon as any local endpoint returns
+	accessor.Update([]string{"west/http://talaria-west.comcast.net:8080", "east/http://talaria-east.comcast.net:8080"})
+	assertGet("http://talaria-east.comcast.net:8080")
+
+	accessor.Update(nil)
+	instance, err := accessor.Get([]byte("a"))
+	assert.Empty(instance)
+	assert.Equal(ErrorNoEndpoints, err)
+}
+
+func TestZoneAccessorFactory(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		eastAccessor  = new(mockAccessor)
+		westAccessor  = new(mockAccessor)
+		factory       = new(mockAccessorFactory)
+		expectedError = errors.New("expected")
+	)
+
+	factory.On("New", []string{"http://talaria-east.comcast.net:8080"}).
+		Return(eastAccessor, []string{"http://talaria-east.comcast.net:8080"}).
+		Once()
+
+	factory.On("New", []string{"http://talaria-west.comcast.net:8080"}).
+		Return(westAccessor, []string{"http://talaria-west.comcast.net:8080"}).
+		Once()
+
+	eastAccessor.On("Get", []byte("key")).Return("", expectedError).Once()
+
+	accessor := NewZoneAccessor(
+		&Options{Zone: "east"},
+		factory,
+		[]string{"west/http://talaria-west.comcast.net:8080", "east/http://talaria-east.comcast.net:8080"},
+	)
+
+	instance, err := accessor.Get([]byte("key"))
+	assert.Empty(instance)
+	assert.Equal(expectedError, err)
+
+	factory.AssertExpectations(t)
+	eastAccessor.AssertExpectations(t)
+	westAccessor.AssertExpectations(t)
+}
+
+func TestZoneRegistrarRegisterEndpoint(t *testing.T) {
+	var (
+		assert            = assert.New(t)
+		eastRegistrar     = new(mockRegistrar)
+		westRegistrar     = new(mockMetadataRegistrar)
+		expectedMetadata  = map[string]string{"key": "value"}
+		expectedEndpoints = []interface{}{nil, nil}
+	)
+
+	eastRegistrar.On("RegisterEndpoint", "http://talaria-east.comcast.net", 8080, mock.MatchedBy(nilPingFunc)).
+		Return(expectedEndpoints...).
+		Times(3)
+
+	westRegistrar.On("RegisterEndpointWithMetadata", "http://talaria-west.comcast.net", 8080, mock.MatchedBy(nilPingFunc), expectedMetadata).
+		Return(expectedEndpoints...).
+		Once()
+
+	registrars := map[string]Registrar{"east": eastRegistrar, "west": westRegistrar}
+
+	east := NewZoneRegistrar(&Options{Zone: "east"}, registrars)
+	endpoint, err := east.RegisterEndpoint("http://talaria-east.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.NoError(err)
+
+	// the local registrar does not support metadata, so it is not published
+	endpoint, err = east.RegisterEndpointWithMetadata("http://talaria-east.comcast.net", 8080, nil, expectedMetadata)
+	assert.Nil(endpoint)
+	assert.NoError(err)
+
+	west := NewZoneRegistrar(&Options{Zone: "west"}, registrars)
+	endpoint, err = west.RegisterEndpointWithMetadata("http://talaria-west.comcast.net", 8080, nil, expectedMetadata)
+	assert.Nil(endpoint)
+	assert.NoError(err)
+
+	// changes to the map after construction do not affect the registrar
+	delete(registrars, "east")
+	endpoint, err = east.RegisterEndpoint("http://talaria-east.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.NoError(err)
+
+	endpoint, err = NewZoneRegistrar(&Options{Zone: "central"}, registrars).RegisterEndpoint("http://talaria-central.comcast.net", 8080, nil)
+	assert.Nil(endpoint)
+	assert.Equal(ErrorNoLocalRegistrar, err)
+
+	eastRegistrar.AssertExpectations(t)
+	westRegistrar.AssertExpectations(t)
+}
+
+// newTestZoneRegistrar creates a ZoneRegistrar spanning DNSRegistrars whose SRV records are supplied by mocks
+func newTestZoneRegistrar(zones ...string) (*ZoneRegistrar, map[string]*DNSRegistrar, map[string]*mockSRVResolver, map[string]chan time.Time) {
+	var (
+		registrars    = make(map[string]Registrar, len(zones))
+		dnsRegistrars = make(map[string]*DNSRegistrar, len(zones))
+		resolvers     = make(map[string]*mockSRVResolver, len(zones))
+		timers        = make(map[string]chan time.Time, len(zones))
+	)
+
+	for _, zone := range zones {
+		resolvers[zone] = new(mockSRVResolver)
+		dnsRegistrars[zone], timers[zone] = newTestDNSRegistrar(resolvers[zone])
+		registrars[zone] = dnsRegistrars[zone]
+	}
+
+	return NewZoneRegistrar(&Options{Zone: zones[0]}, registrars), dnsRegistrars, resolvers, timers
+}
+
+func TestZoneRegistrarWatch(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		registrar, dnsRegistrars, resolvers, timers = newTestZoneRegistrar("east", "west")
+
+		waitForEvent = func(watch Watch) {
+			select {
+			case <-watch.Event():
+			case <-time.After(5 * time.Second):
+				require.Fail("No watch event was signalled")
+			}
+		}
+	)
+
+	resolvers["east"].On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-east-1.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	resolvers["east"].On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-east-1.comcast.net.", Port: 8080}, {Target: "talaria-east-2.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	resolvers["west"].On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-west-1.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	watch, err := registrar.Watch()
+	require.NoError(err)
+	require.NotNil(watch)
+	assert.False(watch.IsClosed())
+	assert.Equal(
+		[]string{"east/http://talaria-east-1.comcast.net:8080", "west/http://talaria-west-1.comcast.net:8080"},
+		watch.Endpoints(),
+	)
+
+	timers["east"] <- time.Now()
+	waitForEvent(watch)
+	assert.Equal(
+		[]string{
+			"east/http://talaria-east-1.comcast.net:8080",
+			"east/http://talaria-east-2.comcast.net:8080",
+			"west/http://talaria-west-1.comcast.net:8080",
+		},
+		watch.Endpoints(),
+	)
+
+	// losing a zone's watch drops its endpoints, but the merged watch stays open
+	dnsRegistrars["west"].Stop()
+	waitForEvent(watch)
+	assert.False(watch.IsClosed())
+	assert.Equal(
+		[]string{"east/http://talaria-east-1.comcast.net:8080", "east/http://talaria-east-2.comcast.net:8080"},
+		watch.Endpoints(),
+	)
+
+	dnsRegistrars["east"].Stop()
+	waitForEvent(watch)
+	assert.True(watch.IsClosed())
+
+	for _, resolver := range resolvers {
+		resolver.AssertExpectations(t)
+	}
+}
+
+func TestZoneRegistrarWatchError(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		expectedError    = errors.New("expected")
+		failing          = new(mockRegistrar)
+		resolver         = new(mockSRVResolver)
+		eastRegistrar, _ = newTestDNSRegistrar(resolver)
+		registrar        = NewZoneRegistrar(&Options{Zone: "east"}, map[string]Registrar{"east": eastRegistrar, "west": failing})
+	)
+
+	// the order in which zones are watched is unspecified, so the DNS lookup may not happen
+	resolver.On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").Return("", nil, nil)
+	failing.On("Watch").Return(nil, expectedError).Once()
+
+	watch, err := registrar.Watch()
+	assert.Nil(watch)
+	assert.Equal(expectedError, err)
+	assert.Empty(eastRegistrar.watches)
+	failing.AssertExpectations(t)
+}
+
+func TestZoneRegistrarSubscription(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		registrar, dnsRegistrars, resolvers, timers = newTestZoneRegistrar("east", "west")
+
+		accessor     = NewZoneAccessor(&Options{Zone: "east"}, nil, nil)
+		updates      = make(chan []string, 10)
+		subscription = Subscription{
+			Registrar: registrar,
+			Listener: func(endpoints []string) {
+				accessor.Update(endpoints)
+				updates <- endpoints
+			},
+			WarmupTimeout: 5 * time.Second,
+		}
+	)
+
+	resolvers["east"].On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-east-1.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	resolvers["east"].On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", nil, nil).
+		Once()
+
+	resolvers["west"].On("LookupSRV", mock.Anything, "talaria", "tcp", "comcast.net").
+		Return("", []*net.SRV{{Target: "talaria-west-1.comcast.net.", Port: 8080}}, nil).
+		Once()
+
+	require.NoError(subscription.Run())
+	<-updates
+
+	instance, err := accessor.Get([]byte("key"))
+	assert.Equal("http://talaria-east-1.comcast.net:8080", instance)
+	assert.NoError(err)
+
+	// the local zone's endpoints disappear from its watch
+	timers["east"] <- time.Now()
+	select {
+	case endpoints := <-updates:
+		assert.Equal([]string{"west/http://talaria-west-1.comcast.net:8080"}, endpoints)
+	case <-time.After(5 * time.Second):
+		require.Fail("No update was dispatched")
+	}
+
+	instance, err = accessor.Get([]byte("key"))
+	assert.Equal("http://talaria-west-1.comcast.net:8080", instance)
+	assert.NoError(err)
+
+	assert.NoError(subscription.Cancel())
+	assert.Empty(dnsRegistrars["east"].watches)
+	assert.Empty(dnsRegistrars["west"].watches)
+}
@@ -0,0 +1,243 @@
+package spool
+
+import (
+	"container/list"
+	"encoding/hex"
+	"fmt"
+	"github.com/Comcast/webpa-common/logging"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultMaxSize int64 = 64 * 1024 * 1024
+
+	// recordSuffix is the file extension for spooled records
+	recordSuffix = ".record"
+)
+
+// Options describes the configuration of a disk spool
+type Options struct {
+	// Directory is where spooled records are stored.  This field is required.  The directory is
+	// created if it does not exist, and any records already present are loaded.
+	Directory string `json:"directory"`
+
+	// MaxSize is the maximum total bytes of records retained.  If not supplied, DefaultMaxSize is used.
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// Logger is the sink for logging output.  If not supplied, logging.DefaultLogger() is used.
+	Logger logging.Logger `json:"-"`
+}
+
+func (o *Options) maxSize() int64 {
+	if o != nil && o.MaxSize > 0 {
+		return o.MaxSize
+	}
+
+	return DefaultMaxSize
+}
+
+func (o *Options) logger() logging.Logger {
+	if o != nil && o.Logger != nil {
+		return o.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+// diskRecord is the in-memory index entry for a single record file
+type diskRecord struct {
+	sequence uint64
+	key      string
+	size     int64
+}
+
+// disk is the file-backed Interface implementation
+type disk struct {
+	lock      sync.Mutex
+	logger    logging.Logger
+	directory string
+	maxSize   int64
+
+	size     int64
+	sequence uint64
+	records  *list.List
+}
+
+// NewDisk creates a spool that stores records beneath o.Directory.  Records left from a previous
+// process are indexed, and are evicted as necessary to honor o.MaxSize.
+func NewDisk(o *Options) (Interface, error) {
+	if o == nil || len(o.Directory) == 0 {
+		return nil, ErrorNoDirectory
+	}
+
+	if err := os.MkdirAll(o.Directory, 0700); err != nil {
+		return nil, err
+	}
+
+	d := &disk{
+		logger:    o.logger(),
+		directory: o.Directory,
+		maxSize:   o.maxSize(),
+		records:   list.New(),
+	}
+
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	d.evict(0)
+	return d, nil
+}
+
+// fileName produces the name of a record's file, which encodes its sequence and key
+func fileName(r *diskRecord) string {
+	return fmt.Sprintf("%020d-%s%s", r.sequence, hex.EncodeToString([]byte(r.key)), recordSuffix)
+}
+
+// parseFileName is the inverse of fileName
+func parseFileName(name string) (*diskRecord, bool) {
+	if !strings.HasSuffix(name, recordSuffix) {
+		return nil, false
+	}
+
+	parts := strings.SplitN(strings.TrimSuffix(name, recordSuffix), "-", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	sequence, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	key, err := hex.DecodeString(parts[1])
+	if err != nil || len(key) == 0 {
+		return nil, false
+	}
+
+	return &diskRecord{sequence: sequence, key: string(key)}, true
+}
+
+func (d *disk) path(r *diskRecord) string {
+	return filepath.Join(d.directory, fileName(r))
+}
+
+// load indexes the records already present in the directory.  ioutil.ReadDir returns
+// entries sorted by name, which is also sequence order.
+func (d *disk) load() error {
+	files, err := ioutil.ReadDir(d.directory)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		r, ok := parseFileName(file.Name())
+		if !ok {
+			continue
+		}
+
+		r.size = file.Size()
+		d.records.PushBack(r)
+		d.size += r.size
+		if r.sequence >= d.sequence {
+			d.sequence = r.sequence + 1
+		}
+	}
+
+	if d.records.Len() > 0 {
+		d.logger.Info("Loaded %d spooled records (%d bytes) from %s", d.records.Len(), d.size, d.directory)
+	}
+
+	return nil
+}
+
+// remove deletes a record's file and its index entry.  The lock must be held.
+func (d *disk) remove(e *list.Element) {
+	r := d.records.Remove(e).(*diskRecord)
+	d.size -= r.size
+	if err := os.Remove(d.path(r)); err != nil && !os.IsNotExist(err) {
+		d.logger.Error("Unable to remove spooled record %s: %s", d.path(r), err)
+	}
+}
+
+// evict removes the oldest records until there is room for the given number of bytes.  The lock must be held.
+func (d *disk) evict(room int64) {
+	for d.records.Len() > 0 && d.size+room > d.maxSize {
+		r := d.records.Front().Value.(*diskRecord)
+		d.logger.Warn("Evicting spooled record for [%s] to honor the maximum spool size", r.key)
+		d.remove(d.records.Front())
+	}
+}
+
+func (d *disk) Append(key string, record []byte) error {
+	if len(key) == 0 {
+		return ErrorInvalidKey
+	}
+
+	size := int64(len(record))
+	if size > d.maxSize {
+		return ErrorRecordTooLarge
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.evict(size)
+	r := &diskRecord{sequence: d.sequence, key: key, size: size}
+
+	// write to a temporary file first, so that a crash never leaves a partial record
+	temporary := d.path(r) + ".tmp"
+	if err := ioutil.WriteFile(temporary, record, 0600); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	if err := os.Rename(temporary, d.path(r)); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	d.sequence++
+	d.size += size
+	d.records.PushBack(r)
+	return nil
+}
+
+func (d *disk) Drain(key string) ([][]byte, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	drained := make([][]byte, 0)
+	for e := d.records.Front(); e != nil; {
+		next := e.Next()
+		if r := e.Value.(*diskRecord); r.key == key {
+			record, err := ioutil.ReadFile(d.path(r))
+			if err != nil {
+				// leave this and subsequent records in place, so that a retry can pick them up
+				return drained, err
+			}
+
+			drained = append(drained, record)
+			d.remove(e)
+		}
+
+		e = next
+	}
+
+	return drained, nil
+}
+
+func (d *disk) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.records.Len()
+}
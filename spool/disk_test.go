@@ -0,0 +1,123 @@
+package spool
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDirectory(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "spool")
+	require.NoError(t, err)
+	return directory
+}
+
+func TestOptionsDefault(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Equal(DefaultMaxSize, o.maxSize())
+		assert.NotNil(o.logger())
+	}
+}
+
+func TestNewDiskNoDirectory(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		d, err := NewDisk(o)
+		assert.Nil(d)
+		assert.Equal(ErrorNoDirectory, err)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	assert := assert.New(t)
+
+	expected := &diskRecord{sequence: 12, key: "mac:112233445566"}
+	actual, ok := parseFileName(fileName(expected))
+	assert.True(ok)
+	assert.Equal(expected, actual)
+
+	for _, invalid := range []string{"", "foo.txt", "12.record", "abc-6d6163.record", "12-zz.record", "12-.record", "12-6d6163.record.tmp"} {
+		_, ok := parseFileName(invalid)
+		assert.False(ok, invalid)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		directory = newTestDirectory(t)
+	)
+
+	defer os.RemoveAll(directory)
+
+	d, err := NewDisk(&Options{Directory: directory, MaxSize: 10, Logger: logging.TestLogger(t)})
+	require.NoError(err)
+	require.NotNil(d)
+
+	assert.Equal(ErrorInvalidKey, d.Append("", []byte("a")))
+	assert.Equal(ErrorRecordTooLarge, d.Append("key1", []byte("this is too large")))
+	assert.Equal(0, d.Len())
+
+	assert.NoError(d.Append("key1", []byte("abc")))
+	assert.NoError(d.Append("key2", []byte("def")))
+	assert.NoError(d.Append("key1", []byte("ghi")))
+	assert.Equal(3, d.Len())
+
+	// this evicts the oldest record
+	assert.NoError(d.Append("key2", []byte("jk")))
+	assert.Equal(3, d.Len())
+
+	records, err := d.Drain("key1")
+	assert.NoError(err)
+	assert.Equal([][]byte{[]byte("ghi")}, records)
+	assert.Equal(2, d.Len())
+
+	records, err = d.Drain("nosuch")
+	assert.NoError(err)
+	assert.Empty(records)
+
+	// records survive a restart
+	restarted, err := NewDisk(&Options{Directory: directory, MaxSize: 10, Logger: logging.TestLogger(t)})
+	require.NoError(err)
+	assert.Equal(2, restarted.Len())
+	assert.NoError(restarted.Append("key2", []byte("lmn")))
+
+	records, err = restarted.Drain("key2")
+	assert.NoError(err)
+	assert.Equal([][]byte{[]byte("def"), []byte("jk"), []byte("lmn")}, records)
+	assert.Equal(0, restarted.Len())
+
+	files, err := filepath.Glob(filepath.Join(directory, "*"))
+	assert.NoError(err)
+	assert.Empty(files)
+}
+
+func TestDiskEvictsOnLoad(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		directory = newTestDirectory(t)
+	)
+
+	defer os.RemoveAll(directory)
+
+	d, err := NewDisk(&Options{Directory: directory, MaxSize: 100, Logger: logging.TestLogger(t)})
+	require.NoError(err)
+	for _, record := range []string{"first", "second", "third"} {
+		require.NoError(d.Append("key", []byte(record)))
+	}
+
+	// a smaller spool only keeps the newest records that fit
+	smaller, err := NewDisk(&Options{Directory: directory, MaxSize: 11, Logger: logging.TestLogger(t)})
+	require.NoError(err)
+
+	records, err := smaller.Drain("key")
+	assert.NoError(err)
+	assert.Equal([][]byte{[]byte("second"), []byte("third")}, records)
+}
@@ -0,0 +1,9 @@
+/*
+Package spool provides bounded, persistent storage for records that could not be delivered
+immediately.  Records are grouped by key, e.g. by device ID, and are drained in the order they were
+appended.  When a spool is full, the oldest records across all keys are evicted to make room.
+
+NewDisk stores each record as a file beneath a directory, so that spooled records survive
+process restarts.
+*/
+package spool
@@ -0,0 +1,25 @@
+package spool
+
+import (
+	"errors"
+)
+
+var (
+	ErrorRecordTooLarge = errors.New("The record is larger than the spool")
+	ErrorInvalidKey     = errors.New("Spool keys cannot be empty")
+	ErrorNoDirectory    = errors.New("A spool directory is required")
+)
+
+// Interface represents a spool of records grouped by key.  Implementations are safe for concurrent use.
+type Interface interface {
+	// Append adds a record for the given key.  If the spool is full, the oldest records are evicted
+	// to make room.  A record larger than the entire spool is rejected with ErrorRecordTooLarge.
+	Append(key string, record []byte) error
+
+	// Drain removes and returns all records for the given key, oldest first.  If there are no such
+	// records, this method returns an empty slice and a nil error.
+	Drain(key string) ([][]byte, error)
+
+	// Len returns the total number of records in this spool
+	Len() int
+}
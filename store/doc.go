@@ -1,5 +1,8 @@
 /*
 Package store implements some additional atomic value storage on top of sync/atomic.
 In particular, this means transparent caching of arbitrary values.
+
+This package also defines KV, a minimal key-value abstraction with expiry and watches, along with
+an in-memory implementation.  The redis subpackage provides a Redis-backed KV.
 */
 package store
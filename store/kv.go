@@ -0,0 +1,61 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrorKeyNotFound = errors.New("No such key")
+	ErrorKVClosed    = errors.New("The key-value store has been closed")
+)
+
+// KVEvent describes a change to a single key
+type KVEvent struct {
+	// Key is the key which changed
+	Key string
+
+	// Value is the new value for the key.  This field is nil when Deleted is true.
+	Value []byte
+
+	// Deleted indicates that the key was removed, either explicitly or through expiry
+	Deleted bool
+}
+
+// KVWatch is a subscription to the changes of a single key
+type KVWatch interface {
+	// Events returns the channel on which changes are delivered.  This channel is
+	// closed when the watch is closed.
+	Events() <-chan KVEvent
+
+	// Close cancels this watch.  This method is idempotent.
+	Close() error
+}
+
+// KV is a minimal key-value store with expiry.  Components that need simple
+// persistence, such as webhook registrations, depend on this interface rather
+// than on any particular backend.
+type KV interface {
+	// Get returns the current value for a key.  If the key does not exist or has
+	// expired, ErrorKeyNotFound is returned.
+	Get(key string) ([]byte, error)
+
+	// Set stores a value under a key.  If ttl is positive, the key expires after that
+	// duration.  Otherwise, the key never expires.
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Delete removes a key.  Deleting a nonexistent key is not an error.
+	Delete(key string) error
+
+	// Watch subscribes to changes of a key
+	Watch(key string) (KVWatch, error)
+}
+
+// KVAdder is implemented by KV stores that can atomically store a key only if it is absent.
+// Components that must not race with other processes sharing the store, such as replay guards,
+// use this interface when the store supports it.
+type KVAdder interface {
+	// Add stores a value under a key only if the key does not already exist.  If the key exists,
+	// the store is not modified and false is returned.  The ttl has the same meaning as for KV.Set.
+	Add(key string, value []byte, ttl time.Duration) (bool, error)
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryEntry is a single key's state within a MemoryKV
+type memoryEntry struct {
+	value []byte
+	timer *time.Timer
+}
+
+// MemoryKV is an in-process KV implementation.  Expired keys are removed by timers,
+// so watchers are notified of expiry as it happens.
+type MemoryKV struct {
+	lock     sync.Mutex
+	entries  map[string]*memoryEntry
+	watchers map[string]map[*memoryWatch]bool
+}
+
+// NewMemoryKV creates an empty, in-memory KV
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{
+		entries:  make(map[string]*memoryEntry),
+		watchers: make(map[string]map[*memoryWatch]bool),
+	}
+}
+
+// copyBytes prevents callers from sharing slices with the store
+func copyBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+
+	c := make([]byte, len(value))
+	copy(c, value)
+	return c
+}
+
+func (m *MemoryKV) Get(key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if entry, ok := m.entries[key]; ok {
+		return copyBytes(entry.value), nil
+	}
+
+	return nil, ErrorKeyNotFound
+}
+
+func (m *MemoryKV) Set(key string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if existing, ok := m.entries[key]; ok && existing.timer != nil {
+		existing.timer.Stop()
+	}
+
+	entry := &memoryEntry{value: copyBytes(value)}
+	if ttl > 0 {
+		entry.timer = time.AfterFunc(ttl, func() { m.expire(key, entry) })
+	}
+
+	m.entries[key] = entry
+	m.notify(KVEvent{Key: key, Value: copyBytes(value)})
+	return nil
+}
+
+func (m *MemoryKV) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.entries[key]; ok {
+		return false, nil
+	}
+
+	entry := &memoryEntry{value: copyBytes(value)}
+	if ttl > 0 {
+		entry.timer = time.AfterFunc(ttl, func() { m.expire(key, entry) })
+	}
+
+	m.entries[key] = entry
+	m.notify(KVEvent{Key: key, Value: copyBytes(value)})
+	return true, nil
+}
+
+// expire removes the given entry, but only if it is still the current entry for the key
+func (m *MemoryKV) expire(key string, entry *memoryEntry) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.entries[key] == entry {
+		delete(m.entries, key)
+		m.notify(KVEvent{Key: key, Deleted: true})
+	}
+}
+
+func (m *MemoryKV) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if entry, ok := m.entries[key]; ok {
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+
+		delete(m.entries, key)
+		m.notify(KVEvent{Key: key, Deleted: true})
+	}
+
+	return nil
+}
+
+func (m *MemoryKV) Watch(key string) (KVWatch, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w := &memoryWatch{
+		kv:     m,
+		key:    key,
+		events: make(chan KVEvent, 10),
+	}
+
+	if m.watchers[key] == nil {
+		m.watchers[key] = make(map[*memoryWatch]bool)
+	}
+
+	m.watchers[key][w] = true
+	return w, nil
+}
+
+// notify dispatches an event to all watchers of its key.  This method must be invoked
+// under the lock.  Watchers that are not keeping up miss events rather than blocking the store.
+func (m *MemoryKV) notify(event KVEvent) {
+	for w := range m.watchers[event.Key] {
+		select {
+		case w.events <- event:
+		default:
+		}
+	}
+}
+
+func (m *MemoryKV) removeWatch(w *memoryWatch) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if watchers, ok := m.watchers[w.key]; ok && watchers[w] {
+		delete(watchers, w)
+		if len(watchers) == 0 {
+			delete(m.watchers, w.key)
+		}
+
+		close(w.events)
+	}
+}
+
+type memoryWatch struct {
+	kv     *MemoryKV
+	key    string
+	events chan KVEvent
+}
+
+func (w *memoryWatch) Events() <-chan KVEvent {
+	return w.events
+}
+
+func (w *memoryWatch) Close() error {
+	w.kv.removeWatch(w)
+	return nil
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryKV(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		kv      KV = NewMemoryKV()
+	)
+
+	value, err := kv.Get("missing")
+	assert.Nil(value)
+	assert.Equal(ErrorKeyNotFound, err)
+	assert.NoError(kv.Delete("missing"))
+
+	original := []byte("value")
+	require.NoError(kv.Set("key", original, 0))
+	original[0] = 'X'
+
+	value, err = kv.Get("key")
+	assert.Equal([]byte("value"), value)
+	assert.NoError(err)
+
+	value[0] = 'Y'
+	value, _ = kv.Get("key")
+	assert.Equal([]byte("value"), value)
+
+	require.NoError(kv.Set("key", []byte("updated"), 0))
+	value, err = kv.Get("key")
+	assert.Equal([]byte("updated"), value)
+	assert.NoError(err)
+
+	require.NoError(kv.Delete("key"))
+	value, err = kv.Get("key")
+	assert.Nil(value)
+	assert.Equal(ErrorKeyNotFound, err)
+}
+
+func TestMemoryKVAdd(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		require         = require.New(t)
+		kv              = NewMemoryKV()
+		adder   KVAdder = kv
+	)
+
+	added, err := adder.Add("key", []byte("first"), 0)
+	require.NoError(err)
+	assert.True(added)
+
+	added, err = adder.Add("key", []byte("second"), 0)
+	require.NoError(err)
+	assert.False(added)
+
+	value, err := kv.Get("key")
+	assert.Equal([]byte("first"), value)
+	assert.NoError(err)
+
+	// once an added key expires, it can be added again
+	watch, err := kv.Watch("expiring")
+	require.NoError(err)
+	defer watch.Close()
+
+	added, err = adder.Add("expiring", []byte("value"), 10*time.Millisecond)
+	require.NoError(err)
+	assert.True(added)
+
+	for deleted := false; !deleted; {
+		select {
+		case event := <-watch.Events():
+			deleted = event.Deleted
+		case <-time.After(5 * time.Second):
+			require.FailNow("No expiry event")
+		}
+	}
+
+	added, err = adder.Add("expiring", []byte("again"), 0)
+	require.NoError(err)
+	assert.True(added)
+}
+
+func TestMemoryKVExpiry(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		kv      = NewMemoryKV()
+	)
+
+	watch, err := kv.Watch("key")
+	require.NotNil(watch)
+	require.NoError(err)
+	defer watch.Close()
+
+	require.NoError(kv.Set("key", []byte("value"), 10*time.Millisecond))
+	select {
+	case event := <-watch.Events():
+		assert.Equal(KVEvent{Key: "key", Value: []byte("value")}, event)
+	case <-time.After(5 * time.Second):
+		require.Fail("No set event")
+	}
+
+	select {
+	case event := <-watch.Events():
+		assert.Equal(KVEvent{Key: "key", Deleted: true}, event)
+	case <-time.After(5 * time.Second):
+		require.Fail("No expiry event")
+	}
+
+	value, err := kv.Get("key")
+	assert.Nil(value)
+	assert.Equal(ErrorKeyNotFound, err)
+}
+
+func TestMemoryKVOverwriteCancelsExpiry(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		kv      = NewMemoryKV()
+	)
+
+	require.NoError(kv.Set("key", []byte("shortlived"), 10*time.Millisecond))
+	require.NoError(kv.Set("key", []byte("permanent"), 0))
+	time.Sleep(50 * time.Millisecond)
+
+	value, err := kv.Get("key")
+	assert.Equal([]byte("permanent"), value)
+	assert.NoError(err)
+}
+
+func TestMemoryKVWatch(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		kv      = NewMemoryKV()
+	)
+
+	watch, err := kv.Watch("key")
+	require.NotNil(watch)
+	require.NoError(err)
+
+	other, err := kv.Watch("other")
+	require.NotNil(other)
+	require.NoError(err)
+
+	require.NoError(kv.Set("key", []byte("value"), 0))
+	require.NoError(kv.Delete("key"))
+	assert.Equal(KVEvent{Key: "key", Value: []byte("value")}, <-watch.Events())
+	assert.Equal(KVEvent{Key: "key", Deleted: true}, <-watch.Events())
+
+	select {
+	case event := <-other.Events():
+		assert.Fail("Unexpected event", "%v", event)
+	default:
+	}
+
+	assert.NoError(watch.Close())
+	assert.NoError(watch.Close())
+	_, ok := <-watch.Events()
+	assert.False(ok)
+
+	assert.NoError(other.Close())
+}
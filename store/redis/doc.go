@@ -0,0 +1,6 @@
+/*
+Package redis provides a store.KV implementation backed by a Redis server.  Watches are
+implemented with Redis pub/sub: each Set or Delete made through this package publishes a
+notification, so watchers see changes made by any process using the same Redis server.
+*/
+package redis
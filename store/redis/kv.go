@@ -0,0 +1,317 @@
+package redis
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/store"
+	"github.com/garyburd/redigo/redis"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAddress       = "localhost:6379"
+	DefaultMaxIdle       = 3
+	DefaultIdleTimeout   = 4 * time.Minute
+	DefaultChannelPrefix = "webpa.kv."
+
+	// notifySet and notifyDelete are the first byte of each published notification
+	notifySet    byte = 's'
+	notifyDelete byte = 'd'
+)
+
+// Options describes how to connect to Redis.  A nil *Options is valid, and connects to
+// DefaultAddress with no password on database 0.
+type Options struct {
+	// Address is the host:port of the Redis server
+	Address string `json:"address"`
+
+	// Password is the optional Redis AUTH password
+	Password string `json:"password,omitempty"`
+
+	// Database is the Redis database number to select
+	Database int `json:"database"`
+
+	// KeyPrefix is prepended to every key.  This allows several applications to share one server.
+	KeyPrefix string `json:"keyPrefix"`
+
+	// ChannelPrefix is prepended to every key to produce the pub/sub channel used for watches
+	ChannelPrefix string `json:"channelPrefix"`
+
+	// MaxIdle is the maximum number of idle connections kept in the pool
+	MaxIdle int `json:"maxIdle"`
+
+	// IdleTimeout is how long an idle connection remains in the pool before being closed
+	IdleTimeout time.Duration `json:"idleTimeout"`
+}
+
+func (o *Options) address() string {
+	if o != nil && len(o.Address) > 0 {
+		return o.Address
+	}
+
+	return DefaultAddress
+}
+
+func (o *Options) password() string {
+	if o != nil {
+		return o.Password
+	}
+
+	return ""
+}
+
+func (o *Options) database() int {
+	if o != nil {
+		return o.Database
+	}
+
+	return 0
+}
+
+func (o *Options) keyPrefix() string {
+	if o != nil {
+		return o.KeyPrefix
+	}
+
+	return ""
+}
+
+func (o *Options) channelPrefix() string {
+	if o != nil && len(o.ChannelPrefix) > 0 {
+		return o.ChannelPrefix
+	}
+
+	return DefaultChannelPrefix
+}
+
+func (o *Options) maxIdle() int {
+	if o != nil && o.MaxIdle > 0 {
+		return o.MaxIdle
+	}
+
+	return DefaultMaxIdle
+}
+
+func (o *Options) idleTimeout() time.Duration {
+	if o != nil && o.IdleTimeout > 0 {
+		return o.IdleTimeout
+	}
+
+	return DefaultIdleTimeout
+}
+
+// NewPool creates a redis.Pool using the connection settings in the given Options
+func NewPool(o *Options) *redis.Pool {
+	var (
+		address  = o.address()
+		password = o.password()
+		database = o.database()
+	)
+
+	return &redis.Pool{
+		MaxIdle:     o.maxIdle(),
+		IdleTimeout: o.idleTimeout(),
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial(
+				"tcp",
+				address,
+				redis.DialPassword(password),
+				redis.DialDatabase(database),
+			)
+		},
+	}
+}
+
+// KV is a store.KV backed by a pool of Redis connections
+type KV struct {
+	pool          *redis.Pool
+	keyPrefix     string
+	channelPrefix string
+}
+
+// New creates a KV which connects to Redis as described by the given Options
+func New(o *Options) *KV {
+	return NewWithPool(NewPool(o), o)
+}
+
+// NewWithPool creates a KV using an existing connection pool.  Only the key and channel
+// prefixes are used from the Options.
+func NewWithPool(pool *redis.Pool, o *Options) *KV {
+	return &KV{
+		pool:          pool,
+		keyPrefix:     o.keyPrefix(),
+		channelPrefix: o.channelPrefix(),
+	}
+}
+
+// Close releases the connection pool
+func (kv *KV) Close() error {
+	return kv.pool.Close()
+}
+
+func (kv *KV) Get(key string) ([]byte, error) {
+	conn := kv.pool.Get()
+	defer conn.Close()
+
+	value, err := redis.Bytes(conn.Do("GET", kv.keyPrefix+key))
+	if err == redis.ErrNil {
+		return nil, store.ErrorKeyNotFound
+	}
+
+	return value, err
+}
+
+func (kv *KV) Set(key string, value []byte, ttl time.Duration) error {
+	conn := kv.pool.Get()
+	defer conn.Close()
+
+	var err error
+	if ttl > 0 {
+		milliseconds := int64(ttl / time.Millisecond)
+		if milliseconds < 1 {
+			milliseconds = 1
+		}
+
+		_, err = conn.Do("SET", kv.keyPrefix+key, value, "PX", milliseconds)
+	} else {
+		_, err = conn.Do("SET", kv.keyPrefix+key, value)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("PUBLISH", kv.channelPrefix+key, append([]byte{notifySet}, value...))
+	return err
+}
+
+func (kv *KV) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	conn := kv.pool.Get()
+	defer conn.Close()
+
+	arguments := []interface{}{kv.keyPrefix + key, value}
+	if ttl > 0 {
+		milliseconds := int64(ttl / time.Millisecond)
+		if milliseconds < 1 {
+			milliseconds = 1
+		}
+
+		arguments = append(arguments, "PX", milliseconds)
+	}
+
+	// SET with NX replies with nil when the key already exists
+	_, err := redis.String(conn.Do("SET", append(arguments, "NX")...))
+	if err == redis.ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = conn.Do("PUBLISH", kv.channelPrefix+key, append([]byte{notifySet}, value...))
+	return true, err
+}
+
+func (kv *KV) Delete(key string) error {
+	conn := kv.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", kv.keyPrefix+key); err != nil {
+		return err
+	}
+
+	_, err := conn.Do("PUBLISH", kv.channelPrefix+key, []byte{notifyDelete})
+	return err
+}
+
+// Watch subscribes to changes of the given key.  Each watch holds its own Redis connection
+// for the lifetime of the subscription.  Keys which expire on the server are not reported.
+func (kv *KV) Watch(key string) (store.KVWatch, error) {
+	psc := redis.PubSubConn{Conn: kv.pool.Get()}
+	if err := psc.Subscribe(kv.channelPrefix + key); err != nil {
+		psc.Close()
+		return nil, err
+	}
+
+	w := &watch{
+		key:    key,
+		conn:   psc,
+		events: make(chan store.KVEvent, 10),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go w.receive()
+	return w, nil
+}
+
+type watch struct {
+	key       string
+	conn      redis.PubSubConn
+	events    chan store.KVEvent
+	closed    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (w *watch) Events() <-chan store.KVEvent {
+	return w.events
+}
+
+// Close unsubscribes this watch, waits for the server to acknowledge, and then
+// releases the connection.  Close must be called even if the events channel has
+// been closed due to a connection failure.
+func (w *watch) Close() (err error) {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+		w.conn.Unsubscribe()
+		<-w.done
+		err = w.conn.Close()
+	})
+
+	return
+}
+
+// receive is the goroutine that translates pub/sub notifications into events.  It exits
+// when the subscription ends or the underlying connection fails.
+func (w *watch) receive() {
+	defer close(w.done)
+	defer close(w.events)
+
+	for {
+		switch v := w.conn.Receive().(type) {
+		case redis.Message:
+			if len(v.Data) == 0 {
+				continue
+			}
+
+			event := store.KVEvent{Key: w.key}
+			if v.Data[0] == notifyDelete {
+				event.Deleted = true
+			} else {
+				event.Value = v.Data[1:]
+			}
+
+			select {
+			case w.events <- event:
+			case <-w.closed:
+			}
+
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+
+		case error:
+			return
+		}
+	}
+}
+
+// Check implements health.Check by issuing a PING to the Redis server
+func (kv *KV) Check(ctx context.Context) error {
+	connection := kv.pool.Get()
+	defer connection.Close()
+
+	_, err := connection.Do("PING")
+	return err
+}
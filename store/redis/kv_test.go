@@ -0,0 +1,166 @@
+package redis
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestOptionsDefaults(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options)} {
+		assert.Equal(DefaultAddress, o.address())
+		assert.Empty(o.password())
+		assert.Equal(0, o.database())
+		assert.Empty(o.keyPrefix())
+		assert.Equal(DefaultChannelPrefix, o.channelPrefix())
+		assert.Equal(DefaultMaxIdle, o.maxIdle())
+		assert.Equal(DefaultIdleTimeout, o.idleTimeout())
+	}
+}
+
+func TestOptions(t *testing.T) {
+	assert := assert.New(t)
+	o := &Options{
+		Address:       "redis.example.com:1234",
+		Password:      "password",
+		Database:      2,
+		KeyPrefix:     "keys.",
+		ChannelPrefix: "channels.",
+		MaxIdle:       10,
+		IdleTimeout:   time.Minute,
+	}
+
+	assert.Equal("redis.example.com:1234", o.address())
+	assert.Equal("password", o.password())
+	assert.Equal(2, o.database())
+	assert.Equal("keys.", o.keyPrefix())
+	assert.Equal("channels.", o.channelPrefix())
+	assert.Equal(10, o.maxIdle())
+	assert.Equal(time.Minute, o.idleTimeout())
+
+	pool := NewPool(o)
+	assert.Equal(10, pool.MaxIdle)
+	assert.Equal(time.Minute, pool.IdleTimeout)
+}
+
+func TestKV(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		server  = newFakeServer()
+
+		kv store.KV = NewWithPool(server.pool(), &Options{KeyPrefix: "test."})
+	)
+
+	value, err := kv.Get("key")
+	assert.Nil(value)
+	assert.Equal(store.ErrorKeyNotFound, err)
+
+	require.NoError(kv.Set("key", []byte("value"), 0))
+	value, err = kv.Get("key")
+	assert.Equal([]byte("value"), value)
+	assert.NoError(err)
+	assert.Equal([]byte("value"), server.values["test.key"])
+	assert.NotContains(server.expiries, "test.key")
+
+	require.NoError(kv.Set("key", []byte("expiring"), 2*time.Second))
+	assert.Equal(2*time.Second, server.expiries["test.key"])
+
+	require.NoError(kv.Set("key", []byte("short"), time.Microsecond))
+	assert.Equal(time.Millisecond, server.expiries["test.key"])
+
+	require.NoError(kv.Delete("key"))
+	value, err = kv.Get("key")
+	assert.Nil(value)
+	assert.Equal(store.ErrorKeyNotFound, err)
+}
+
+func TestKVAdd(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		server  = newFakeServer()
+
+		adder store.KVAdder = NewWithPool(server.pool(), &Options{KeyPrefix: "test."})
+	)
+
+	added, err := adder.Add("key", []byte("first"), 2*time.Second)
+	require.NoError(err)
+	assert.True(added)
+	assert.Equal([]byte("first"), server.values["test.key"])
+	assert.Equal(2*time.Second, server.expiries["test.key"])
+
+	added, err = adder.Add("key", []byte("second"), 0)
+	require.NoError(err)
+	assert.False(added)
+	assert.Equal([]byte("first"), server.values["test.key"])
+	assert.Equal(2*time.Second, server.expiries["test.key"])
+
+	added, err = adder.Add("another", []byte("value"), 0)
+	require.NoError(err)
+	assert.True(added)
+	assert.NotContains(server.expiries, "test.another")
+}
+
+func TestKVWatch(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		server  = newFakeServer()
+
+		kv = NewWithPool(server.pool(), nil)
+	)
+
+	defer kv.Close()
+
+	watch, err := kv.Watch("key")
+	require.NotNil(watch)
+	require.NoError(err)
+
+	require.NoError(kv.Set("key", []byte("value"), 0))
+	require.NoError(kv.Set("other", []byte("ignored"), 0))
+	require.NoError(kv.Delete("key"))
+
+	select {
+	case event := <-watch.Events():
+		assert.Equal(store.KVEvent{Key: "key", Value: []byte("value")}, event)
+	case <-time.After(5 * time.Second):
+		require.Fail("No set event")
+	}
+
+	select {
+	case event := <-watch.Events():
+		assert.Equal(store.KVEvent{Key: "key", Deleted: true}, event)
+	case <-time.After(5 * time.Second):
+		require.Fail("No delete event")
+	}
+
+	assert.NoError(watch.Close())
+	assert.NoError(watch.Close())
+
+	select {
+	case _, ok := <-watch.Events():
+		assert.False(ok)
+	case <-time.After(5 * time.Second):
+		assert.Fail("The events channel was not closed")
+	}
+}
+
+func TestKVCheck(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		server = newFakeServer()
+		kv     = NewWithPool(server.pool(), nil)
+	)
+
+	assert.NoError(kv.Check(context.Background()))
+
+	server.lock.Lock()
+	server.down = true
+	server.lock.Unlock()
+	assert.Equal(errFakeDown, kv.Check(context.Background()))
+}
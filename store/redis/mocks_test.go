@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"sync"
+	"time"
+)
+
+var (
+	errFakeClosed = errors.New("fake connection closed")
+	errFakeDown   = errors.New("fake server down")
+)
+
+// fakeServer is a tiny in-process stand-in for Redis which understands only the
+// commands used by this package
+type fakeServer struct {
+	lock        sync.Mutex
+	values      map[string][]byte
+	expiries    map[string]time.Duration
+	subscribers map[string]map[*fakeConn]bool
+	commands    []string
+	down        bool
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		values:      make(map[string][]byte),
+		expiries:    make(map[string]time.Duration),
+		subscribers: make(map[string]map[*fakeConn]bool),
+	}
+}
+
+func (fs *fakeServer) pool() *redis.Pool {
+	return &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return &fakeConn{server: fs, replies: make(chan interface{}, 100)}, nil
+		},
+	}
+}
+
+func (fs *fakeServer) do(c *fakeConn, command string, args []interface{}) (interface{}, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if len(command) > 0 {
+		fs.commands = append(fs.commands, command)
+	}
+
+	switch command {
+	case "":
+		return nil, nil
+
+	case "GET":
+		if value, ok := fs.values[args[0].(string)]; ok {
+			return value, nil
+		}
+
+		return nil, nil
+
+	case "SET":
+		var (
+			key    = args[0].(string)
+			expiry time.Duration
+			nx     bool
+		)
+
+		for i := 2; i < len(args); i++ {
+			switch args[i] {
+			case "PX":
+				i++
+				expiry = time.Duration(args[i].(int64)) * time.Millisecond
+			case "NX":
+				nx = true
+			}
+		}
+
+		if _, exists := fs.values[key]; nx && exists {
+			return nil, nil
+		}
+
+		fs.values[key] = args[1].([]byte)
+		delete(fs.expiries, key)
+		if expiry > 0 {
+			fs.expiries[key] = expiry
+		}
+
+		return "OK", nil
+
+	case "DEL":
+		delete(fs.values, args[0].(string))
+		return int64(1), nil
+
+	case "PUBLISH":
+		channel := args[0].(string)
+		for subscriber := range fs.subscribers[channel] {
+			subscriber.replies <- []interface{}{[]byte("message"), []byte(channel), args[1].([]byte)}
+		}
+
+		return int64(len(fs.subscribers[channel])), nil
+
+	case "SUBSCRIBE":
+		for _, arg := range args {
+			channel := arg.(string)
+			if fs.subscribers[channel] == nil {
+				fs.subscribers[channel] = make(map[*fakeConn]bool)
+			}
+
+			fs.subscribers[channel][c] = true
+			c.subscriptions++
+			c.replies <- []interface{}{[]byte("subscribe"), []byte(channel), int64(c.subscriptions)}
+		}
+
+		return nil, nil
+
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		for channel, subscribers := range fs.subscribers {
+			if subscribers[c] {
+				delete(subscribers, c)
+				c.subscriptions--
+				c.replies <- []interface{}{[]byte("unsubscribe"), []byte(channel), int64(c.subscriptions)}
+			}
+		}
+
+		return nil, nil
+
+	case "PING":
+		if fs.down {
+			return nil, errFakeDown
+		}
+
+		return "PONG", nil
+
+	case "ECHO":
+		c.replies <- args[0]
+		return nil, nil
+	}
+
+	return nil, errors.New("unsupported command: " + command)
+}
+
+type fakeConn struct {
+	server        *fakeServer
+	replies       chan interface{}
+	subscriptions int
+	closeOnce     sync.Once
+}
+
+func (c *fakeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.replies) })
+	return nil
+}
+
+func (c *fakeConn) Err() error {
+	return nil
+}
+
+func (c *fakeConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return c.server.do(c, command, args)
+}
+
+func (c *fakeConn) Send(command string, args ...interface{}) error {
+	_, err := c.server.do(c, command, args)
+	return err
+}
+
+func (c *fakeConn) Flush() error {
+	return nil
+}
+
+func (c *fakeConn) Receive() (interface{}, error) {
+	if reply, ok := <-c.replies; ok {
+		return reply, nil
+	}
+
+	return nil, errFakeClosed
+}
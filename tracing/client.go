@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Doer is the behavior of anything that executes HTTP transactions.  *http.Client implements
+// this interface, and it is also what httppool.Client expects of its Handler.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Client decorates a Doer so that each outbound request executes within a client span.
+// The trace context is propagated to the remote server via request headers.  A Client can
+// be used as the Handler of an httppool.Client.
+type Client struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	delegate   Doer
+}
+
+// NewClient creates a tracing Client.  If delegate is nil, http.DefaultClient is used.
+func NewClient(provider trace.TracerProvider, delegate Doer) *Client {
+	if delegate == nil {
+		delegate = http.DefaultClient
+	}
+
+	return &Client{
+		tracer:     provider.Tracer(InstrumentationName),
+		propagator: Propagator(),
+		delegate:   delegate,
+	}
+}
+
+func (c *Client) Do(request *http.Request) (*http.Response, error) {
+	ctx, span := c.tracer.Start(
+		request.Context(),
+		request.Method+" "+request.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String(MethodKey, request.Method),
+			attribute.String(TargetKey, request.URL.Path),
+		),
+	)
+
+	defer span.End()
+
+	// don't modify the caller's request
+	request = request.WithContext(ctx)
+	request.Header = cloneHeader(request.Header)
+	c.propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, err := c.delegate.Do(request)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return response, err
+	}
+
+	span.SetAttributes(attribute.Int(StatusCodeKey, response.StatusCode))
+	if response.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(response.StatusCode))
+	}
+
+	return response, nil
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h)+1)
+	for name, values := range h {
+		clone[name] = append([]string(nil), values...)
+	}
+
+	return clone
+}
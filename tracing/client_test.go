@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"testing"
+)
+
+func TestNewClientDefault(t *testing.T) {
+	provider, _ := newTestProvider()
+	client := NewClient(provider, nil)
+	require.NotNil(t, client)
+	assert.Equal(t, http.DefaultClient, client.delegate)
+}
+
+func TestClient(t *testing.T) {
+	testData := []struct {
+		statusCode   int
+		expectedCode codes.Code
+	}{
+		{http.StatusOK, codes.Unset},
+		{http.StatusBadRequest, codes.Unset},
+		{http.StatusInternalServerError, codes.Error},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+
+		var (
+			assert             = assert.New(t)
+			require            = require.New(t)
+			provider, recorder = newTestProvider()
+			delegate           = new(mockDoer)
+			client             = NewClient(provider, delegate)
+			request, _         = http.NewRequest("POST", "http://example.com/hook", nil)
+			expectedResponse   = &http.Response{StatusCode: record.statusCode}
+			tracedRequest      *http.Request
+		)
+
+		delegate.On("Do", mock.MatchedBy(func(r *http.Request) bool { tracedRequest = r; return true })).
+			Return(expectedResponse, nil).
+			Once()
+
+		response, err := client.Do(request)
+		assert.Equal(expectedResponse, response)
+		assert.NoError(err)
+
+		spans := recorder.Ended()
+		require.Len(spans, 1)
+		assert.Equal(trace.SpanKindClient, spans[0].SpanKind())
+		assert.Equal(record.expectedCode, spans[0].Status().Code)
+		assert.Contains(spans[0].Attributes(), attribute.Int(StatusCodeKey, record.statusCode))
+
+		require.NotNil(tracedRequest)
+		assert.NotEqual("", tracedRequest.Header.Get("traceparent"))
+		assert.Equal("", request.Header.Get("traceparent"))
+		assert.Equal(spans[0].SpanContext(), trace.SpanContextFromContext(tracedRequest.Context()))
+
+		delegate.AssertExpectations(t)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	var (
+		assert             = assert.New(t)
+		require            = require.New(t)
+		provider, recorder = newTestProvider()
+		delegate           = new(mockDoer)
+		client             = NewClient(provider, delegate)
+		request, _         = http.NewRequest("GET", "http://example.com", nil)
+		expectedError      = errors.New("expected")
+	)
+
+	delegate.On("Do", mock.AnythingOfType("*http.Request")).Return(nil, expectedError).Once()
+
+	response, err := client.Do(request)
+	assert.Nil(response)
+	assert.Equal(expectedError, err)
+
+	spans := recorder.Ended()
+	require.Len(spans, 1)
+	assert.Equal(codes.Error, spans[0].Status().Code)
+	delegate.AssertExpectations(t)
+}
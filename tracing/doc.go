@@ -0,0 +1,10 @@
+/*
+Package tracing integrates OpenTelemetry distributed tracing with WebPA components.
+
+NewTracerProvider configures an SDK TracerProvider from Options.  The returned provider is
+handed to Middleware for inbound HTTP requests, to Client for outbound requests made through
+an httppool, and to device.Options so that routing to and from devices produces child spans.
+Trace context crosses the websocket inside WRP message metadata via InjectMessage and
+ExtractMessage.
+*/
+package tracing
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"github.com/Comcast/webpa-common/xhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const (
+	MethodKey     = "http.method"
+	TargetKey     = "http.target"
+	StatusCodeKey = "http.status_code"
+)
+
+// Middleware decorates http.Handlers so that each request executes within a server span.
+// Any trace context in the inbound request headers becomes the parent of that span.
+type Middleware struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewMiddleware creates a Middleware whose spans come from the given provider
+func NewMiddleware(provider trace.TracerProvider) *Middleware {
+	return &Middleware{
+		tracer:     provider.Tracer(InstrumentationName),
+		propagator: Propagator(),
+	}
+}
+
+// Then decorates the given delegate, naming each server span with the supplied name.
+// The span is available to the delegate through the request's context.
+func (m *Middleware) Then(name string, delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		ctx := m.propagator.Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		ctx, span := m.tracer.Start(
+			ctx,
+			name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String(MethodKey, request.Method),
+				attribute.String(TargetKey, request.URL.Path),
+			),
+		)
+
+		defer span.End()
+
+		writer := xhttp.WrapResponseWriter(response)
+		delegate.ServeHTTP(writer, request.WithContext(ctx))
+
+		statusCode := writer.StatusCode()
+		if statusCode == 0 {
+			// net/http implicitly writes a 200 when the handler writes nothing
+			statusCode = http.StatusOK
+		}
+
+		span.SetAttributes(attribute.Int(StatusCodeKey, statusCode))
+		if statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(statusCode))
+		}
+	})
+}
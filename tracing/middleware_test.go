@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	testData := []struct {
+		handlerStatus  int
+		expectedStatus int
+		expectedCode   codes.Code
+	}{
+		{0, http.StatusOK, codes.Unset},
+		{http.StatusAccepted, http.StatusAccepted, codes.Unset},
+		{http.StatusNotFound, http.StatusNotFound, codes.Unset},
+		{http.StatusServiceUnavailable, http.StatusServiceUnavailable, codes.Error},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+
+		var (
+			assert             = assert.New(t)
+			require            = require.New(t)
+			provider, recorder = newTestProvider()
+			delegateSpan       trace.SpanContext
+
+			handler = NewMiddleware(provider).Then("test", http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+				delegateSpan = trace.SpanContextFromContext(request.Context())
+				if record.handlerStatus > 0 {
+					response.WriteHeader(record.handlerStatus)
+				}
+			}))
+
+			response = httptest.NewRecorder()
+			request  = httptest.NewRequest("GET", "/api/v2/device", nil)
+		)
+
+		request.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		handler.ServeHTTP(response, request)
+
+		spans := recorder.Ended()
+		require.Len(spans, 1)
+		assert.Equal("test", spans[0].Name())
+		assert.Equal(trace.SpanKindServer, spans[0].SpanKind())
+		assert.Equal("0af7651916cd43dd8448eb211c80319c", spans[0].SpanContext().TraceID().String())
+		assert.Equal("b7ad6b7169203331", spans[0].Parent().SpanID().String())
+		assert.Equal(spans[0].SpanContext(), delegateSpan)
+		assert.Equal(record.expectedCode, spans[0].Status().Code)
+		assert.Contains(spans[0].Attributes(), attribute.Int(StatusCodeKey, record.expectedStatus))
+		assert.Contains(spans[0].Attributes(), attribute.String(TargetKey, "/api/v2/device"))
+	}
+}
@@ -0,0 +1,24 @@
+package tracing
+
+import (
+	"github.com/stretchr/testify/mock"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+)
+
+// newTestProvider creates a TracerProvider that records spans synchronously
+func newTestProvider() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), recorder
+}
+
+type mockDoer struct {
+	mock.Mock
+}
+
+func (m *mockDoer) Do(request *http.Request) (*http.Response, error) {
+	arguments := m.Called(request)
+	response, _ := arguments.Get(0).(*http.Response)
+	return response, arguments.Error(1)
+}
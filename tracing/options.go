@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	// InstrumentationName is the name of the tracers created by this package
+	InstrumentationName = "github.com/Comcast/webpa-common/tracing"
+
+	// ServiceNameKey is the resource attribute holding the service name
+	ServiceNameKey = "service.name"
+
+	DefaultServiceName = "webpa"
+	DefaultSampleRatio = 1.0
+)
+
+// Options describes how to configure tracing for an application
+type Options struct {
+	// ServiceName is the name reported for spans created by this application.
+	// If not supplied, DefaultServiceName is used.
+	ServiceName string `json:"serviceName"`
+
+	// SampleRatio is the fraction of new traces that are sampled, in the range (0.0, 1.0].
+	// If not positive, DefaultSampleRatio is used.  Spans whose parent was sampled are always sampled.
+	SampleRatio float64 `json:"sampleRatio"`
+
+	// Exporter is the sink for completed spans.  If not supplied, spans are created and
+	// propagated but never exported.
+	Exporter sdktrace.SpanExporter `json:"-"`
+}
+
+func (o *Options) serviceName() string {
+	if o != nil && len(o.ServiceName) > 0 {
+		return o.ServiceName
+	}
+
+	return DefaultServiceName
+}
+
+func (o *Options) sampleRatio() float64 {
+	if o != nil && o.SampleRatio > 0.0 {
+		return o.SampleRatio
+	}
+
+	return DefaultSampleRatio
+}
+
+func (o *Options) exporter() sdktrace.SpanExporter {
+	if o != nil {
+		return o.Exporter
+	}
+
+	return nil
+}
+
+// NewTracerProvider creates an SDK TracerProvider from a set of options.  Callers should
+// invoke Shutdown on the returned provider when the application exits so that any
+// buffered spans are exported.
+func NewTracerProvider(o *Options) *sdktrace.TracerProvider {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(
+			sdktrace.ParentBased(sdktrace.TraceIDRatioBased(o.sampleRatio())),
+		),
+		sdktrace.WithResource(
+			resource.NewSchemaless(attribute.String(ServiceNameKey, o.serviceName())),
+		),
+	}
+
+	if exporter := o.exporter(); exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	return sdktrace.NewTracerProvider(options...)
+}
+
+// Propagator returns the propagator used to carry trace context over HTTP and WRP
+func Propagator() propagation.TextMapPropagator {
+	return propagation.TraceContext{}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestOptionsDefault(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*Options{nil, new(Options), &Options{SampleRatio: -1.0}} {
+		t.Logf("%#v", o)
+		assert.Equal(DefaultServiceName, o.serviceName())
+		assert.Equal(DefaultSampleRatio, o.sampleRatio())
+		assert.Nil(o.exporter())
+	}
+}
+
+func TestOptions(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		exporter = tracetest.NewInMemoryExporter()
+		o        = Options{
+			ServiceName: "test",
+			SampleRatio: 0.5,
+			Exporter:    exporter,
+		}
+	)
+
+	assert.Equal("test", o.serviceName())
+	assert.Equal(0.5, o.sampleRatio())
+	assert.Equal(exporter, o.exporter())
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		exporter = tracetest.NewInMemoryExporter()
+		provider = NewTracerProvider(&Options{ServiceName: "test", Exporter: exporter})
+	)
+
+	require.NotNil(provider)
+	_, span := provider.Tracer(InstrumentationName).Start(context.Background(), "test span")
+	span.End()
+
+	// the in-memory exporter discards its spans on shutdown, so just flush
+	require.NoError(provider.ForceFlush(context.Background()))
+	spans := exporter.GetSpans()
+	require.Len(spans, 1)
+	assert.Equal("test span", spans[0].Name)
+
+	serviceName, ok := spans[0].Resource.Set().Value(ServiceNameKey)
+	assert.True(ok)
+	assert.Equal("test", serviceName.AsString())
+}
+
+func TestNewTracerProviderNoExporter(t *testing.T) {
+	provider := NewTracerProvider(nil)
+	require.NotNil(t, provider)
+
+	_, span := provider.Tracer(InstrumentationName).Start(context.Background(), "test span")
+	assert.True(t, span.SpanContext().IsValid())
+	span.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+}
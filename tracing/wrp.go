@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/wrp"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// InjectMessage stores the trace context of ctx into the metadata of a WRP message, allowing
+// spans to continue on the other side of a websocket.  The message's Metadata map is created
+// if necessary.
+func InjectMessage(ctx context.Context, message *wrp.Message) {
+	if message.Metadata == nil {
+		message.Metadata = make(map[string]string)
+	}
+
+	Propagator().Inject(ctx, propagation.MapCarrier(message.Metadata))
+}
+
+// ExtractMessage returns a copy of ctx that carries any trace context found in the metadata
+// of a WRP message.  If the message carries no trace context, ctx is returned unchanged.
+func ExtractMessage(ctx context.Context, message *wrp.Message) context.Context {
+	if len(message.Metadata) == 0 {
+		return ctx
+	}
+
+	return Propagator().Extract(ctx, propagation.MapCarrier(message.Metadata))
+}
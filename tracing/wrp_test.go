@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestInjectExtractMessage(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		provider, _  = newTestProvider()
+		ctx, span    = provider.Tracer(InstrumentationName).Start(context.Background(), "test")
+		message      = new(wrp.Message)
+		extractedCtx = ExtractMessage(context.Background(), message)
+	)
+
+	defer span.End()
+	assert.Equal(context.Background(), extractedCtx)
+
+	InjectMessage(ctx, message)
+	require.NotNil(message.Metadata)
+	assert.NotEqual("", message.Metadata["traceparent"])
+
+	extracted := trace.SpanContextFromContext(ExtractMessage(context.Background(), message))
+	assert.True(extracted.IsRemote())
+	assert.Equal(span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(span.SpanContext().SpanID(), extracted.SpanID())
+}
+
+func TestInjectMessagePreservesMetadata(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		provider, _ = newTestProvider()
+		ctx, span   = provider.Tracer(InstrumentationName).Start(context.Background(), "test")
+		message     = &wrp.Message{Metadata: map[string]string{"existing": "value"}}
+	)
+
+	defer span.End()
+	InjectMessage(ctx, message)
+	assert.Equal("value", message.Metadata["existing"])
+	assert.NotEqual("", message.Metadata["traceparent"])
+}
@@ -0,0 +1,19 @@
+package webhook
+
+// DeadLetterSink receives events that could not be delivered to a webhook, along with the
+// reason.  Implementations must be safe for concurrent use, as each webhook's delivery
+// goroutine may invoke the sink.
+type DeadLetterSink interface {
+	DeadLetter(w W, e *Event, reason error)
+}
+
+// DeadLetterFunc is a function type that implements DeadLetterSink
+type DeadLetterFunc func(W, *Event, error)
+
+func (f DeadLetterFunc) DeadLetter(w W, e *Event, reason error) {
+	f(w, e, reason)
+}
+
+type discardDeadLetter struct{}
+
+func (discardDeadLetter) DeadLetter(W, *Event, error) {}
@@ -0,0 +1,545 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the HMAC of the request body, of the form sha1=<hex digest>,
+	// for webhooks that were registered with a secret.
+	SignatureHeader = "X-Webpa-Signature"
+
+	// EventHeader carries the type of event being delivered
+	EventHeader = "X-Webpa-Event"
+
+	// DeviceIDHeader carries the identifier of the device which produced the event
+	DeviceIDHeader = "X-Webpa-Device-Id"
+
+	// EventPrefix is the prefix of WRP destinations that denote events
+	EventPrefix = "event:"
+)
+
+var (
+	ErrorQueueFull     = errors.New("The webhook delivery queue is full")
+	ErrorWebhookCutOff = errors.New("The webhook has been cut off due to repeated delivery failures")
+)
+
+// StatusError indicates that a webhook responded with a non-2xx status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (se *StatusError) Error() string {
+	return fmt.Sprintf("Webhook responded with status code %d", se.StatusCode)
+}
+
+// Event is a single occurrence that can be delivered to webhooks
+type Event struct {
+	// Type is the event type, which is matched against each webhook's Events
+	Type string
+
+	// DeviceID is the device which produced this event, matched against each webhook's Matcher
+	DeviceID string
+
+	// ContentType is the MIME type of Contents
+	ContentType string
+
+	// Contents is the request body delivered to each matching webhook
+	Contents []byte
+
+	// Message is the optional decoded form of Contents.  When set along with Format, a dispatcher with
+	// a HopRecorder re-encodes Contents for each delivery so that the message carries a hop.
+	Message *wrp.Message
+
+	// Format is the WRP format of Contents
+	Format wrp.Format
+}
+
+// EventType extracts the event type from a WRP destination.  A destination of the form
+// event:device-status/mac:112233445566/online yields device-status/mac:112233445566/online.
+func EventType(destination string) string {
+	return strings.TrimPrefix(destination, EventPrefix)
+}
+
+// Sign produces the value of the SignatureHeader for the given secret and body
+func Sign(secret string, body []byte) string {
+	h := hmac.New(sha1.New, []byte(secret))
+	h.Write(body)
+	return "sha1=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// matcher is the compiled form of a webhook's matching rules
+type matcher struct {
+	source    string
+	events    []*regexp.Regexp
+	deviceIDs []*regexp.Regexp
+}
+
+// matcherSource produces a string which changes whenever a webhook's matching rules change
+func matcherSource(w *W) string {
+	return strings.Join(w.Events, "\x00") + "\x01" + strings.Join(w.Matcher.DeviceId, "\x00")
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		compiled = append(compiled, r)
+	}
+
+	return compiled, nil
+}
+
+func newMatcher(w *W) (m *matcher, err error) {
+	m = &matcher{source: matcherSource(w)}
+	if m.events, err = compileAll(w.Events); err != nil {
+		return nil, err
+	}
+
+	if m.deviceIDs, err = compileAll(w.Matcher.DeviceId); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches tests an event against the compiled rules.  A webhook with no device id
+// patterns matches all devices.
+func (m *matcher) matches(e *Event) bool {
+	return matchAny(m.events, e.Type) &&
+		(len(m.deviceIDs) == 0 || matchAny(m.deviceIDs, e.DeviceID))
+}
+
+// Dispatcher fans events out to every registered webhook that matches them.  Each webhook
+// has its own bounded delivery queue and goroutine, so a slow or failing endpoint never delays
+// deliveries to other endpoints.  Failed deliveries are retried with exponential backoff, and
+// an endpoint that fails too many times in a row is cut off for a period of time.  Events which
+// cannot be delivered are handed to the optional DeadLetterSink.
+type Dispatcher struct {
+	list            List
+	client          HTTPClient
+	logger          logging.Logger
+	deadLetter      DeadLetterSink
+	queueSize       int
+	maxRetries      int
+	initialBackoff  time.Duration
+	maxBackoff      time.Duration
+	cutoffThreshold int
+	cutoffPeriod    time.Duration
+	metrics         dispatcherMetrics
+	hopRecorder     *wrp.HopRecorder
+	now             func() time.Time
+
+	lock     sync.Mutex
+	matchers map[string]*matcher
+	queues   map[string]*endpointQueue
+	shutdown chan struct{}
+	closed   bool
+	workers  sync.WaitGroup
+}
+
+// NewDispatcher creates a Dispatcher that sends to the webhooks in the given list
+func NewDispatcher(list List, o *DispatcherOptions) *Dispatcher {
+	return &Dispatcher{
+		list:            list,
+		client:          o.client(),
+		logger:          o.logger(),
+		deadLetter:      o.deadLetter(),
+		queueSize:       o.queueSize(),
+		maxRetries:      o.maxRetries(),
+		initialBackoff:  o.initialBackoff(),
+		maxBackoff:      o.maxBackoff(),
+		cutoffThreshold: o.cutoffThreshold(),
+		cutoffPeriod:    o.cutoffPeriod(),
+		metrics:         newDispatcherMetrics(o.metricsProvider()),
+		hopRecorder:     o.hopRecorder(),
+		now:             time.Now,
+		matchers:        make(map[string]*matcher),
+		queues:          make(map[string]*endpointQueue),
+		shutdown:        make(chan struct{}),
+	}
+}
+
+// matcherFor returns the compiled matcher for the given webhook, recompiling only when the
+// webhook's rules have changed since the last dispatch.
+func (d *Dispatcher) matcherFor(w *W) (*matcher, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if m, ok := d.matchers[w.ID()]; ok && m.source == matcherSource(w) {
+		return m, nil
+	}
+
+	m, err := newMatcher(w)
+	if err != nil {
+		return nil, err
+	}
+
+	d.matchers[w.ID()] = m
+	return m, nil
+}
+
+// queueFor returns the delivery queue for a webhook, starting one if necessary.  If this
+// Dispatcher has been closed, this method returns nil.
+func (d *Dispatcher) queueFor(url string) *endpointQueue {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	q, ok := d.queues[url]
+	if !ok {
+		q = &endpointQueue{
+			url:        url,
+			deliveries: make(chan delivery, d.queueSize),
+		}
+
+		d.queues[url] = q
+		d.workers.Add(1)
+		go d.deliverAll(q)
+	}
+
+	return q
+}
+
+// Healthy tests whether the webhook with the given URL is currently accepting deliveries.
+// A webhook that has never been delivered to is considered healthy.
+func (d *Dispatcher) Healthy(url string) bool {
+	d.lock.Lock()
+	q := d.queues[url]
+	d.lock.Unlock()
+
+	return q == nil || !q.isCutOff(d.now())
+}
+
+// Check implements health.Check.  The check fails if any webhook that has been delivered to is
+// currently cut off.
+func (d *Dispatcher) Check(context.Context) error {
+	var (
+		now    = d.now()
+		cutOff []string
+	)
+
+	d.lock.Lock()
+	for url, q := range d.queues {
+		if q.isCutOff(now) {
+			cutOff = append(cutOff, url)
+		}
+	}
+
+	d.lock.Unlock()
+	if len(cutOff) > 0 {
+		sort.Strings(cutOff)
+		return fmt.Errorf("Webhooks cut off: %s", strings.Join(cutOff, ", "))
+	}
+
+	return nil
+}
+
+// Close stops all delivery queues.  Pending deliveries are abandoned.  This method is idempotent.
+func (d *Dispatcher) Close() error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return nil
+	}
+
+	d.closed = true
+	close(d.shutdown)
+	for _, q := range d.queues {
+		close(q.deliveries)
+	}
+
+	d.lock.Unlock()
+	d.workers.Wait()
+	return nil
+}
+
+// Dispatch enqueues the event for all matching, unexpired webhooks.  The number of deliveries
+// queued is returned.  This method never blocks: an event that cannot be queued for a webhook,
+// either because the queue is full or because the webhook has been cut off, is dead-lettered.
+func (d *Dispatcher) Dispatch(e *Event) (count int) {
+	now := d.now()
+	for _, w := range d.list.GetAll() {
+		if !w.Until.IsZero() && now.After(w.Until) {
+			continue
+		}
+
+		m, err := d.matcherFor(w)
+		if err != nil {
+			d.logger.Error("Invalid matcher for webhook %s: %s", w.ID(), err)
+			continue
+		} else if !m.matches(e) {
+			continue
+		}
+
+		q := d.queueFor(w.ID())
+		if q == nil {
+			d.logger.Error("Unable to deliver event %s to webhook %s: dispatcher closed", e.Type, w.ID())
+			continue
+		}
+
+		if q.isCutOff(now) {
+			d.metrics.dropped(w.ID())
+			d.deadLetter.DeadLetter(*w, e, ErrorWebhookCutOff)
+			continue
+		}
+
+		select {
+		case q.deliveries <- delivery{w: *w, e: e, queuedAt: now}:
+			d.metrics.queued(w.ID())
+			count++
+		default:
+			d.logger.Warn("Dropped event %s for webhook %s: delivery queue full", e.Type, w.ID())
+			d.metrics.dropped(w.ID())
+			d.deadLetter.DeadLetter(*w, e, ErrorQueueFull)
+		}
+	}
+
+	return
+}
+
+// OnDeviceEvent is a device.Listener that dispatches simple events received from devices
+func (d *Dispatcher) OnDeviceEvent(e *device.Event) {
+	if e.Type != device.MessageReceived || e.Message == nil || e.Message.MessageType() != wrp.SimpleEventMessageType {
+		return
+	}
+
+	// the device.Event and its raw contents are reused after listeners return, but the message is not
+	message, _ := e.Message.(*wrp.Message)
+	d.Dispatch(&Event{
+		Type:        EventType(e.Message.To()),
+		DeviceID:    string(e.Device.ID()),
+		ContentType: e.Format.ContentType(),
+		Contents:    append([]byte(nil), e.Contents...),
+		Message:     message,
+		Format:      e.Format,
+	})
+}
+
+// newRequest creates the HTTP request that delivers an event to a single webhook
+func newRequest(w *W, e *Event) (*http.Request, error) {
+	request, err := http.NewRequest("POST", w.Config.URL, bytes.NewReader(e.Contents))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", e.ContentType)
+	request.Header.Set(EventHeader, e.Type)
+	if len(e.DeviceID) > 0 {
+		request.Header.Set(DeviceIDHeader, e.DeviceID)
+	}
+
+	if len(w.Config.Secret) > 0 {
+		request.Header.Set(SignatureHeader, Sign(w.Config.Secret, e.Contents))
+	}
+
+	return request, nil
+}
+
+// send performs a single delivery attempt
+func (d *Dispatcher) send(w *W, e *Event) error {
+	request, err := newRequest(w, e)
+	if err != nil {
+		return err
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &StatusError{StatusCode: response.StatusCode}
+	}
+
+	return nil
+}
+
+// backoff computes the wait time before the given retry, starting at 1
+func (d *Dispatcher) backoff(retry int) time.Duration {
+	wait := d.initialBackoff
+	for i := 1; i < retry && wait < d.maxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > d.maxBackoff {
+		wait = d.maxBackoff
+	}
+
+	return wait
+}
+
+// deliverAll is the goroutine that drains a single endpoint's queue
+func (d *Dispatcher) deliverAll(q *endpointQueue) {
+	defer d.workers.Done()
+	for next := range q.deliveries {
+		d.metrics.dequeued(q.url)
+		d.deliver(q, next)
+	}
+}
+
+// withHop returns the event to send for a delivery.  If a hop is recorded, the returned event is a
+// copy whose Contents carry the hop, covering the time the delivery spent queued.  Otherwise, the
+// delivery's own event is returned.
+func (d *Dispatcher) withHop(next delivery) *Event {
+	if next.e.Message == nil || !d.hopRecorder.Records(next.e.Message) {
+		return next.e
+	}
+
+	var (
+		message = *next.e.Message
+		event   = *next.e
+	)
+
+	d.hopRecorder.Record(&message, next.queuedAt, wrp.HopStatusOK)
+	event.Message = &message
+	event.Contents = nil
+	if err := wrp.NewEncoderBytes(&event.Contents, event.Format).Encode(&message); err != nil {
+		d.logger.Error("Unable to encode hop for event %s: %s", next.e.Type, err)
+		return next.e
+	}
+
+	return &event
+}
+
+// deliver attempts a single delivery, retrying as configured
+func (d *Dispatcher) deliver(q *endpointQueue, next delivery) {
+	var (
+		err   error
+		event = d.withHop(next)
+	)
+
+	for retry := 0; retry <= d.maxRetries; retry++ {
+		if retry > 0 {
+			d.metrics.retried(q.url)
+			select {
+			case <-time.After(d.backoff(retry)):
+			case <-d.shutdown:
+				return
+			}
+		}
+
+		if err = d.send(&next.w, event); err == nil {
+			d.metrics.delivered(q.url)
+			q.success()
+			return
+		}
+
+		d.logger.Debug("Delivery of event %s to webhook %s failed: %s", next.e.Type, q.url, err)
+	}
+
+	d.logger.Error("Unable to deliver event %s to webhook %s after %d retries: %s", next.e.Type, q.url, d.maxRetries, err)
+	d.metrics.failed(q.url)
+	if q.failure(d.now(), d.cutoffThreshold, d.cutoffPeriod) {
+		d.logger.Error("Webhook %s has been cut off for %s", q.url, d.cutoffPeriod)
+		d.metrics.cutOff(q.url)
+		d.notifyFailure(&next.w)
+	}
+
+	d.deadLetter.DeadLetter(next.w, next.e, err)
+}
+
+// notifyFailure informs a webhook's FailureURL, if any, that the webhook has been cut off
+func (d *Dispatcher) notifyFailure(w *W) {
+	if len(w.FailureURL) == 0 {
+		return
+	}
+
+	body := []byte(fmt.Sprintf(`{"message": "webhook %s has been cut off", "until": "%s"}`, w.ID(), d.now().Add(d.cutoffPeriod).Format(time.RFC3339)))
+	request, err := http.NewRequest("POST", w.FailureURL, bytes.NewReader(body))
+	if err != nil {
+		d.logger.Error("Invalid failure URL for webhook %s: %s", w.ID(), err)
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	response, err := d.client.Do(request)
+	if err != nil {
+		d.logger.Error("Unable to notify failure URL for webhook %s: %s", w.ID(), err)
+		return
+	}
+
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+}
+
+// delivery is a single queued item
+type delivery struct {
+	w        W
+	e        *Event
+	queuedAt time.Time
+}
+
+// endpointQueue holds the delivery state for one webhook
+type endpointQueue struct {
+	url        string
+	deliveries chan delivery
+
+	lock                sync.Mutex
+	consecutiveFailures int
+	cutOffUntil         time.Time
+}
+
+func (q *endpointQueue) isCutOff(now time.Time) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return now.Before(q.cutOffUntil)
+}
+
+func (q *endpointQueue) success() {
+	q.lock.Lock()
+	q.consecutiveFailures = 0
+	q.lock.Unlock()
+}
+
+// failure records a failed delivery, returning true if this failure caused the webhook to be cut off
+func (q *endpointQueue) failure(now time.Time, threshold int, period time.Duration) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.consecutiveFailures++
+	if q.consecutiveFailures >= threshold {
+		q.consecutiveFailures = 0
+		q.cutOffUntil = now.Add(period)
+		return true
+	}
+
+	return false
+}
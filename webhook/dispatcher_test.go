@@ -0,0 +1,428 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testDevice is a minimal device.Interface exposing only an ID
+type testDevice struct {
+	device.Interface
+	id device.ID
+}
+
+func (d testDevice) ID() device.ID {
+	return d.id
+}
+
+type deadLettered struct {
+	w      W
+	e      *Event
+	reason error
+}
+
+func newDeadLetterChannel() (chan deadLettered, DeadLetterSink) {
+	output := make(chan deadLettered, 10)
+	return output, DeadLetterFunc(func(w W, e *Event, reason error) {
+		output <- deadLettered{w, e, reason}
+	})
+}
+
+func TestEventType(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("device-status/mac:112233445566/online", EventType("event:device-status/mac:112233445566/online"))
+	assert.Equal("something", EventType("something"))
+}
+
+func TestSign(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("sha1=5112055c05f944f85755efc5cd8970e194e9f45b", Sign("secret", []byte("hello")))
+}
+
+func TestStatusError(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("Webhook responded with status code 500", (&StatusError{StatusCode: 500}).Error())
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	assert := assert.New(t)
+	dispatcher := NewDispatcher(NewList(nil), &DispatcherOptions{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second})
+
+	assert.Equal(time.Second, dispatcher.backoff(1))
+	assert.Equal(2*time.Second, dispatcher.backoff(2))
+	assert.Equal(4*time.Second, dispatcher.backoff(3))
+	assert.Equal(5*time.Second, dispatcher.backoff(4))
+	assert.Equal(5*time.Second, dispatcher.backoff(100))
+}
+
+func TestDispatcherDispatch(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		requests = make(chan *http.Request, 10)
+		bodies   = make(chan string, 10)
+
+		signed   = newTestW("http://signed.com")
+		unsigned = newTestW("http://unsigned.com")
+		other    = newTestW("http://other.com")
+		expired  = newTestW("http://expired.com")
+
+		list = NewList(nil)
+	)
+
+	signed.Events = []string{"device-status/.*"}
+	signed.Matcher.DeviceId = []string{"mac:112233445566"}
+	signed.Config.Secret = "secret"
+	other.Events = []string{"unrelated"}
+	list.Update([]W{signed, unsigned, other, expired})
+	list.Filter(func(hooks []W) []W {
+		hooks[3].Until = time.Now().Add(-time.Hour)
+		return hooks
+	})
+
+	dispatcher := NewDispatcher(list, &DispatcherOptions{
+		Logger: logging.TestLogger(t),
+		Client: clientFunc(func(request *http.Request) (*http.Response, error) {
+			body, _ := ioutil.ReadAll(request.Body)
+			bodies <- string(body)
+			requests <- request
+			return newTestResponse(http.StatusAccepted), nil
+		}),
+	})
+
+	defer dispatcher.Close()
+
+	count := dispatcher.Dispatch(&Event{
+		Type:        "device-status/online",
+		DeviceID:    "mac:112233445566",
+		ContentType: "application/json",
+		Contents:    []byte("hello"),
+	})
+
+	assert.Equal(2, count)
+	received := make(map[string]*http.Request)
+	for i := 0; i < 2; i++ {
+		select {
+		case request := <-requests:
+			assert.Equal("hello", <-bodies)
+			received[request.URL.String()] = request
+		case <-time.After(5 * time.Second):
+			require.Fail("No request received")
+		}
+	}
+
+	request := received["http://signed.com"]
+	require.NotNil(request)
+	assert.Equal("POST", request.Method)
+	assert.Equal("application/json", request.Header.Get("Content-Type"))
+	assert.Equal("device-status/online", request.Header.Get(EventHeader))
+	assert.Equal("mac:112233445566", request.Header.Get(DeviceIDHeader))
+	assert.Equal(Sign("secret", []byte("hello")), request.Header.Get(SignatureHeader))
+
+	request = received["http://unsigned.com"]
+	require.NotNil(request)
+	assert.Empty(request.Header.Get(SignatureHeader))
+
+	// a different device only matches the webhook with no device restrictions
+	assert.Equal(1, dispatcher.Dispatch(&Event{Type: "device-status/online", DeviceID: "mac:ffffffffffff"}))
+	select {
+	case request := <-requests:
+		<-bodies
+		assert.Equal("http://unsigned.com", request.URL.String())
+	case <-time.After(5 * time.Second):
+		assert.Fail("No request received")
+	}
+
+	assert.True(dispatcher.Healthy("http://signed.com"))
+	assert.True(dispatcher.Healthy("http://nosuch.com"))
+	assert.NoError(dispatcher.Check(context.Background()))
+}
+
+func TestDispatcherRetry(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		attempts = make(chan int, 10)
+		attempt  = 0
+
+		deadLetters, sink = newDeadLetterChannel()
+
+		dispatcher = NewDispatcher(
+			NewList([]W{newTestW("http://retry.com")}),
+			&DispatcherOptions{
+				Logger:         logging.TestLogger(t),
+				MaxRetries:     2,
+				InitialBackoff: time.Millisecond,
+				DeadLetter:     sink,
+				Client: clientFunc(func(*http.Request) (*http.Response, error) {
+					attempt++
+					attempts <- attempt
+					if attempt < 3 {
+						return newTestResponse(http.StatusServiceUnavailable), nil
+					}
+
+					return newTestResponse(http.StatusOK), nil
+				}),
+			},
+		)
+	)
+
+	defer dispatcher.Close()
+	assert.Equal(1, dispatcher.Dispatch(&Event{Type: "test"}))
+
+	for expected := 1; expected <= 3; expected++ {
+		select {
+		case actual := <-attempts:
+			assert.Equal(expected, actual)
+		case <-time.After(5 * time.Second):
+			assert.Fail("Delivery was not retried")
+			return
+		}
+	}
+
+	select {
+	case d := <-deadLetters:
+		assert.Fail("Unexpected dead letter", "%v", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.True(dispatcher.Healthy("http://retry.com"))
+}
+
+func TestDispatcherCutoff(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		expectedError = errors.New("expected")
+		failures      = make(chan string, 10)
+
+		deadLetters, sink = newDeadLetterChannel()
+
+		w = newTestW("http://failing.com")
+	)
+
+	w.FailureURL = "http://failure.com"
+	dispatcher := NewDispatcher(
+		NewList([]W{w}),
+		&DispatcherOptions{
+			Logger:          logging.TestLogger(t),
+			MaxRetries:      -1,
+			CutoffThreshold: 2,
+			CutoffPeriod:    time.Hour,
+			DeadLetter:      sink,
+			Client: clientFunc(func(request *http.Request) (*http.Response, error) {
+				if request.URL.String() == "http://failure.com" {
+					failures <- request.Header.Get("Content-Type")
+					return newTestResponse(http.StatusOK), nil
+				}
+
+				return nil, expectedError
+			}),
+		},
+	)
+
+	defer dispatcher.Close()
+
+	for i := 0; i < 2; i++ {
+		event := &Event{Type: "test"}
+		assert.Equal(1, dispatcher.Dispatch(event))
+
+		select {
+		case d := <-deadLetters:
+			assert.Equal("http://failing.com", d.w.ID())
+			assert.True(event == d.e)
+			assert.Equal(expectedError, d.reason)
+		case <-time.After(5 * time.Second):
+			require.Fail("No dead letter")
+		}
+	}
+
+	select {
+	case contentType := <-failures:
+		assert.Equal("application/json", contentType)
+	case <-time.After(5 * time.Second):
+		assert.Fail("Failure URL was not notified")
+	}
+
+	assert.False(dispatcher.Healthy("http://failing.com"))
+	if err := dispatcher.Check(context.Background()); assert.Error(err) {
+		assert.Contains(err.Error(), "http://failing.com")
+	}
+
+	// while cut off, events go straight to the dead letter sink
+	assert.Equal(0, dispatcher.Dispatch(&Event{Type: "test"}))
+	select {
+	case d := <-deadLetters:
+		assert.Equal(ErrorWebhookCutOff, d.reason)
+	case <-time.After(5 * time.Second):
+		assert.Fail("No dead letter")
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+
+		deadLetters, sink = newDeadLetterChannel()
+
+		dispatcher = NewDispatcher(
+			NewList([]W{newTestW("http://slow.com")}),
+			&DispatcherOptions{
+				Logger:     logging.TestLogger(t),
+				QueueSize:  1,
+				DeadLetter: sink,
+				Client: clientFunc(func(*http.Request) (*http.Response, error) {
+					started <- struct{}{}
+					<-release
+					return newTestResponse(http.StatusOK), nil
+				}),
+			},
+		)
+	)
+
+	// the first event is taken by the delivery goroutine, the second fills the queue
+	assert.Equal(1, dispatcher.Dispatch(&Event{Type: "first"}))
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		require.Fail("Delivery did not start")
+	}
+
+	assert.Equal(1, dispatcher.Dispatch(&Event{Type: "second"}))
+	assert.Equal(0, dispatcher.Dispatch(&Event{Type: "third"}))
+
+	select {
+	case d := <-deadLetters:
+		assert.Equal("third", d.e.Type)
+		assert.Equal(ErrorQueueFull, d.reason)
+	case <-time.After(5 * time.Second):
+		assert.Fail("No dead letter")
+	}
+
+	close(release)
+	assert.NoError(dispatcher.Close())
+	assert.NoError(dispatcher.Close())
+	assert.Equal(0, dispatcher.Dispatch(&Event{Type: "afterClose"}))
+}
+
+func TestDispatcherOnDeviceEvent(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		requests = make(chan *http.Request, 10)
+
+		dispatcher = NewDispatcher(
+			NewList([]W{newTestW("http://hook.com")}),
+			&DispatcherOptions{
+				Logger: logging.TestLogger(t),
+				Client: clientFunc(func(request *http.Request) (*http.Response, error) {
+					requests <- request
+					return newTestResponse(http.StatusOK), nil
+				}),
+			},
+		)
+
+		d = testDevice{id: device.ID("mac:112233445566")}
+	)
+
+	defer dispatcher.Close()
+
+	// events other than received simple events are ignored
+	dispatcher.OnDeviceEvent(&device.Event{Type: device.Connect, Device: d})
+	dispatcher.OnDeviceEvent(&device.Event{Type: device.MessageReceived, Device: d})
+	dispatcher.OnDeviceEvent(&device.Event{
+		Type:    device.MessageReceived,
+		Device:  d,
+		Message: &wrp.Message{Type: wrp.SimpleRequestResponseMessageType},
+	})
+
+	dispatcher.OnDeviceEvent(&device.Event{
+		Type:     device.MessageReceived,
+		Device:   d,
+		Message:  &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "event:test/something"},
+		Format:   wrp.Msgpack,
+		Contents: []byte("raw"),
+	})
+
+	select {
+	case request := <-requests:
+		require.NotNil(request)
+		assert.Equal("test/something", request.Header.Get(EventHeader))
+		assert.Equal("mac:112233445566", request.Header.Get(DeviceIDHeader))
+		assert.Equal("application/msgpack", request.Header.Get("Content-Type"))
+	case <-time.After(5 * time.Second):
+		assert.Fail("No request received")
+	}
+
+	select {
+	case request := <-requests:
+		assert.Fail("Unexpected request", "%v", request)
+	default:
+	}
+}
+
+func TestDispatcherHops(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		bodies  = make(chan []byte, 10)
+
+		dispatcher = NewDispatcher(
+			NewList([]W{newTestW("http://hook.com")}),
+			&DispatcherOptions{
+				Logger:      logging.TestLogger(t),
+				HopRecorder: &wrp.HopRecorder{Name: "caduceus-1.webhook"},
+				Client: clientFunc(func(request *http.Request) (*http.Response, error) {
+					body, _ := ioutil.ReadAll(request.Body)
+					bodies <- body
+					return newTestResponse(http.StatusOK), nil
+				}),
+			},
+		)
+
+		d       = testDevice{id: device.ID("mac:112233445566")}
+		message = (&wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "event:test"}).SetIncludeSpans(true)
+	)
+
+	defer dispatcher.Close()
+	message.Spans = [][]string{wrp.Hop{Name: "talaria-1.device", Start: time.Now()}.Span()}
+
+	var contents []byte
+	require.NoError(wrp.NewEncoderBytes(&contents, wrp.Msgpack).Encode(message))
+	dispatcher.OnDeviceEvent(&device.Event{
+		Type:     device.MessageReceived,
+		Device:   d,
+		Message:  message,
+		Format:   wrp.Msgpack,
+		Contents: contents,
+	})
+
+	select {
+	case body := <-bodies:
+		delivered := new(wrp.Message)
+		require.NoError(wrp.NewDecoderBytes(body, wrp.Msgpack).Decode(delivered))
+		require.Len(delivered.Spans, 2)
+
+		hop, err := wrp.ParseHop(delivered.Spans[1])
+		require.NoError(err)
+		assert.Equal("talaria-1.device", hop.Parent)
+		assert.Equal("caduceus-1.webhook", hop.Name)
+		assert.Equal(wrp.HopStatusOK, hop.Status)
+	case <-time.After(5 * time.Second):
+		assert.Fail("No request received")
+	}
+
+	// the device's message is left alone
+	assert.Len(message.Spans, 1)
+}
@@ -1,6 +1,7 @@
 package webhook
 
 import (
+	"encoding/json"
 	"github.com/spf13/viper"
 	"net/http"
 	"time"
@@ -86,11 +87,16 @@ func (m *monitor) listen() {
 }
 
 func (m *monitor) ServeHTTP(response http.ResponseWriter, request *http.Request) {
-	// TODO: transform a request into a []W
 	var update []W
+	if err := json.NewDecoder(request.Body).Decode(&update); err != nil {
+		jsonResponse(response, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	select {
 	case m.changes <- update:
+		jsonResponse(response, http.StatusOK, "Success")
 	default:
+		jsonResponse(response, http.StatusServiceUnavailable, "too many pending updates")
 	}
 }
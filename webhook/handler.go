@@ -4,25 +4,94 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Comcast/webpa-common/logging"
 	"io/ioutil"
 	"net"
 	"net/http"
 )
 
+// Registry is the HTTP API for webhook registrations.  GET lists the current webhooks, while
+// POST or PUT registers a new webhook or updates an existing one with the same URL.
 type Registry struct {
 	UpdatableList
+
+	// Store is the optional persistence for this registry.  If nil, registrations are
+	// held in memory only.
+	Store Store
+
+	// Logger is the optional logger for this registry.  If nil, logging.DefaultLogger() is used.
+	Logger logging.Logger
+}
+
+// NewRegistry creates a Registry backed by the given store.  The store, if supplied, is used to
+// load the initial set of webhooks.
+func NewRegistry(store Store, logger logging.Logger) (*Registry, error) {
+	var initial []W
+	if store != nil {
+		var err error
+		if initial, err = store.Load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Registry{
+		UpdatableList: NewList(initial),
+		Store:         store,
+		Logger:        logger,
+	}, nil
+}
+
+func (r *Registry) logger() logging.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+// save persists the current list of webhooks, if this registry has a store
+func (r *Registry) save() error {
+	if r.Store == nil {
+		return nil
+	}
+
+	all := r.GetAll()
+	hooks := make([]W, len(all))
+	for i, w := range all {
+		hooks[i] = *w
+	}
+
+	return r.Store.Save(hooks)
 }
 
 // jsonResponse is an internal convenience function to write a json response
 func jsonResponse(rw http.ResponseWriter, code int, msg string) {
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(code)
-	rw.Write( []byte(fmt.Sprintf(`{"message":"%s"}`, msg)) )
+	rw.Write([]byte(fmt.Sprintf(`{"message":"%s"}`, msg)))
+}
+
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		r.get(rw, req)
+	case "POST", "PUT":
+		r.update(rw, req)
+	default:
+		rw.Header().Set("Allow", "GET, POST, PUT")
+		jsonResponse(rw, http.StatusMethodNotAllowed, "method not allowed")
+	}
 }
 
 // get is an api call to return all the registered listeners
 func (r *Registry) get(rw http.ResponseWriter, req *http.Request) {
-	if msg, err := json.Marshal( r.GetAll() ); err != nil {
+	all := r.GetAll()
+	if all == nil {
+		// always respond with a JSON array, even when there are no webhooks
+		all = []*W{}
+	}
+
+	if msg, err := json.Marshal(all); err != nil {
 		jsonResponse(rw, http.StatusInternalServerError, err.Error())
 	} else {
 		rw.Header().Set("Content-Type", "application/json")
@@ -54,11 +123,14 @@ func (w *W) registrationValidation() (string, int) {
 		return "invalid content_type", http.StatusBadRequest
 	}
 	if len(w.Matcher.DeviceId) == 0 {
-		w.Matcher.DeviceId = []string{".*"}  // match anything
-	}	
+		w.Matcher.DeviceId = []string{".*"} // match anything
+	}
 	if len(w.Events) == 0 {
 		return "invalid events", http.StatusBadRequest
 	}
+	if _, err := newMatcher(w); err != nil {
+		return "invalid matcher: " + err.Error(), http.StatusBadRequest
+	}
 
 	return "", http.StatusOK
 }
@@ -67,20 +139,24 @@ func (w *W) registrationValidation() (string, int) {
 func (r *Registry) update(rw http.ResponseWriter, req *http.Request) {
 	payload, err := ioutil.ReadAll(req.Body)
 	req.Body.Close()
-	
+	if err != nil {
+		jsonResponse(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	w := new(W)
 	err = json.Unmarshal(payload, w)
 	if err != nil {
-		jsonResponse(rw, http.StatusInternalServerError, err.Error())
+		jsonResponse(rw, http.StatusBadRequest, err.Error())
 		return
 	}
-	
+
 	issue, code := w.registrationValidation()
 	if issue != "" || code != http.StatusOK {
 		jsonResponse(rw, code, issue)
 		return
 	}
-	
+
 	// update the requesters address
 	ip, err := parseIP(req.RemoteAddr)
 	if err != nil {
@@ -88,14 +164,13 @@ func (r *Registry) update(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	w.Address = ip
-	
-	// send W as a single item array
-	msg, err := json.Marshal( [1]W{*w} )
-	if err != nil {
-		jsonResponse(rw, http.StatusInternalServerError, err.Error())
+
+	r.Update([]W{*w})
+	if err := r.save(); err != nil {
+		r.logger().Error("Unable to persist webhook %s: %s", w.ID(), err)
+		jsonResponse(rw, http.StatusInternalServerError, "unable to persist webhook")
 		return
 	}
-	
-	fmt.Printf("Publish message to aws.sns here: %+v\n", msg)
-	//PublishMessage( string(msg) )  // publish message to aws.sns // TODO: not implemented yet
-}
\ No newline at end of file
+
+	jsonResponse(rw, http.StatusOK, "Success")
+}
@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewRegistry(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		store   = new(mockStore)
+	)
+
+	store.On("Load").Return([]W{newTestW("http://existing.com")}, nil).Once()
+	registry, err := NewRegistry(store, nil)
+	require.NotNil(registry)
+	assert.NoError(err)
+	assert.Equal(1, registry.Len())
+
+	expectedError := errors.New("expected")
+	store.On("Load").Return(nil, expectedError).Once()
+	registry, err = NewRegistry(store, nil)
+	assert.Nil(registry)
+	assert.Equal(expectedError, err)
+
+	registry, err = NewRegistry(nil, nil)
+	require.NotNil(registry)
+	assert.NoError(err)
+	assert.Equal(0, registry.Len())
+
+	store.AssertExpectations(t)
+}
+
+func TestRegistryGet(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		registry, _ = NewRegistry(nil, nil)
+	)
+
+	response := httptest.NewRecorder()
+	registry.ServeHTTP(response, httptest.NewRequest("GET", "/hooks", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.JSONEq(`[]`, response.Body.String())
+
+	registry.Update([]W{newTestW("http://existing.com")})
+	response = httptest.NewRecorder()
+	registry.ServeHTTP(response, httptest.NewRequest("GET", "/hooks", nil))
+	assert.Equal(http.StatusOK, response.Code)
+
+	var hooks []W
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &hooks))
+	require.Len(hooks, 1)
+	assert.Equal("http://existing.com", hooks[0].ID())
+}
+
+func TestRegistryUpdate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		store   = new(mockStore)
+
+		registry = &Registry{UpdatableList: NewList(nil), Store: store}
+	)
+
+	store.On("Save", mock.AnythingOfType("[]webhook.W")).Return(nil).Once()
+
+	request := httptest.NewRequest(
+		"POST",
+		"/hooks",
+		strings.NewReader(`{"config": {"url": "http://new.com", "content_type": "json", "secret": "secret"}, "events": ["device-status.*"]}`),
+	)
+
+	request.RemoteAddr = "127.0.0.1:1234"
+	response := httptest.NewRecorder()
+	registry.ServeHTTP(response, request)
+	assert.Equal(http.StatusOK, response.Code)
+
+	require.Equal(1, registry.Len())
+	w := registry.Get(0)
+	assert.Equal("http://new.com", w.ID())
+	assert.Equal("secret", w.Config.Secret)
+	assert.Equal([]string{".*"}, w.Matcher.DeviceId)
+	assert.Equal("127.0.0.1", w.Address)
+
+	saved := store.Calls[0].Arguments.Get(0).([]W)
+	require.Len(saved, 1)
+	assert.Equal("http://new.com", saved[0].ID())
+
+	store.AssertExpectations(t)
+}
+
+func TestRegistryUpdateStoreError(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		store  = new(mockStore)
+
+		registry = &Registry{UpdatableList: NewList(nil), Store: store}
+	)
+
+	store.On("Save", mock.AnythingOfType("[]webhook.W")).Return(errors.New("expected")).Once()
+
+	request := httptest.NewRequest("PUT", "/hooks", strings.NewReader(`{"config": {"url": "http://new.com", "content_type": "json"}, "events": [".*"]}`))
+	response := httptest.NewRecorder()
+	registry.ServeHTTP(response, request)
+	assert.Equal(http.StatusInternalServerError, response.Code)
+
+	store.AssertExpectations(t)
+}
+
+func TestRegistryUpdateInvalid(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		testData = []struct {
+			body         string
+			expectedCode int
+		}{
+			{`this is not json`, http.StatusBadRequest},
+			{`{}`, http.StatusBadRequest},
+			{`{"config": {"url": "http://foo.com"}}`, http.StatusBadRequest},
+			{`{"config": {"url": "http://foo.com", "content_type": "json"}}`, http.StatusBadRequest},
+			{`{"config": {"url": "http://foo.com", "content_type": "json"}, "events": ["("]}`, http.StatusBadRequest},
+			{`{"config": {"url": "http://foo.com", "content_type": "json"}, "events": [".*"], "matcher": {"device_id": ["["]}}`, http.StatusBadRequest},
+		}
+	)
+
+	for _, record := range testData {
+		registry, _ := NewRegistry(nil, nil)
+		response := httptest.NewRecorder()
+		registry.ServeHTTP(response, httptest.NewRequest("POST", "/hooks", strings.NewReader(record.body)))
+		assert.Equal(record.expectedCode, response.Code, record.body)
+		assert.Equal(0, registry.Len())
+	}
+}
+
+func TestRegistryMethodNotAllowed(t *testing.T) {
+	assert := assert.New(t)
+	registry, _ := NewRegistry(nil, nil)
+
+	response := httptest.NewRecorder()
+	registry.ServeHTTP(response, httptest.NewRequest("DELETE", "/hooks", nil))
+	assert.Equal(http.StatusMethodNotAllowed, response.Code)
+	assert.Equal("GET, POST, PUT", response.HeaderMap.Get("Allow"))
+}
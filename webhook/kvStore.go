@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/Comcast/webpa-common/store"
+)
+
+const (
+	// DefaultKVStoreKey is the key under which a KVStore keeps webhooks when no key is configured
+	DefaultKVStoreKey = "webhooks"
+)
+
+// KVStore is a Store that keeps all webhooks as a single JSON value within a store.KV.
+// This allows several servers to share registrations through a common backend such as Redis.
+type KVStore struct {
+	KV  store.KV
+	Key string
+}
+
+func (kvs *KVStore) key() string {
+	if len(kvs.Key) > 0 {
+		return kvs.Key
+	}
+
+	return DefaultKVStoreKey
+}
+
+func (kvs *KVStore) Load() ([]W, error) {
+	data, err := kvs.KV.Get(kvs.key())
+	if err == store.ErrorKeyNotFound {
+		return []W{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var hooks []W
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+func (kvs *KVStore) Save(hooks []W) error {
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return err
+	}
+
+	// webhooks carry their own expiry, so the stored list itself never expires
+	return kvs.KV.Set(kvs.key(), data, 0)
+}
@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"github.com/Comcast/webpa-common/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestKVStore(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		kv      = store.NewMemoryKV()
+
+		kvStore = &KVStore{KV: kv}
+	)
+
+	hooks, err := kvStore.Load()
+	assert.Empty(hooks)
+	assert.NoError(err)
+
+	require.NoError(kvStore.Save([]W{newTestW("http://first.com")}))
+	_, err = kv.Get(DefaultKVStoreKey)
+	assert.NoError(err)
+
+	hooks, err = kvStore.Load()
+	require.NoError(err)
+	require.Len(hooks, 1)
+	assert.Equal("http://first.com", hooks[0].ID())
+
+	require.NoError(kv.Set("custom", []byte("this is not json"), 0))
+	hooks, err = (&KVStore{KV: kv, Key: "custom"}).Load()
+	assert.Nil(hooks)
+	assert.Error(err)
+}
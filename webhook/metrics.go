@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"github.com/Comcast/webpa-common/xmetrics"
+)
+
+const (
+	// DeliveryCount is the counter of delivery outcomes, labeled by webhook URL and outcome
+	DeliveryCount = "webhook_deliveries_total"
+
+	// RetryCount is the counter of delivery retries, labeled by webhook URL
+	RetryCount = "webhook_retries_total"
+
+	// CutoffCount is the counter of times a webhook has been cut off, labeled by webhook URL
+	CutoffCount = "webhook_cutoffs_total"
+
+	// QueueDepth is the gauge of events waiting for delivery, labeled by webhook URL
+	QueueDepth = "webhook_queue_depth"
+
+	URLLabel     = "url"
+	OutcomeLabel = "outcome"
+
+	OutcomeDelivered = "delivered"
+	OutcomeFailed    = "failed"
+	OutcomeDropped   = "dropped"
+)
+
+// dispatcherMetrics holds the per-webhook metrics for a Dispatcher
+type dispatcherMetrics struct {
+	deliveries xmetrics.Counter
+	retries    xmetrics.Counter
+	cutoffs    xmetrics.Counter
+	queueDepth xmetrics.Gauge
+}
+
+func newDispatcherMetrics(provider xmetrics.Provider) dispatcherMetrics {
+	return dispatcherMetrics{
+		deliveries: provider.NewCounter(DeliveryCount, URLLabel, OutcomeLabel),
+		retries:    provider.NewCounter(RetryCount, URLLabel),
+		cutoffs:    provider.NewCounter(CutoffCount, URLLabel),
+		queueDepth: provider.NewGauge(QueueDepth, URLLabel),
+	}
+}
+
+func (dm dispatcherMetrics) queued(url string)    { dm.queueDepth.With(url).Add(1.0) }
+func (dm dispatcherMetrics) dequeued(url string)  { dm.queueDepth.With(url).Add(-1.0) }
+func (dm dispatcherMetrics) retried(url string)   { dm.retries.With(url).Add(1.0) }
+func (dm dispatcherMetrics) cutOff(url string)    { dm.cutoffs.With(url).Add(1.0) }
+func (dm dispatcherMetrics) delivered(url string) { dm.deliveries.With(url, OutcomeDelivered).Add(1.0) }
+func (dm dispatcherMetrics) failed(url string)    { dm.deliveries.With(url, OutcomeFailed).Add(1.0) }
+func (dm dispatcherMetrics) dropped(url string)   { dm.deliveries.With(url, OutcomeDropped).Add(1.0) }
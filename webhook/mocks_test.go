@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/mock"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+type mockStore struct {
+	mock.Mock
+}
+
+func (m *mockStore) Load() ([]W, error) {
+	arguments := m.Called()
+	hooks, _ := arguments.Get(0).([]W)
+	return hooks, arguments.Error(1)
+}
+
+func (m *mockStore) Save(hooks []W) error {
+	return m.Called(hooks).Error(0)
+}
+
+// clientFunc is a function type that implements HTTPClient
+type clientFunc func(*http.Request) (*http.Response, error)
+
+func (cf clientFunc) Do(request *http.Request) (*http.Response, error) {
+	return cf(request)
+}
+
+func newTestResponse(statusCode int) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+}
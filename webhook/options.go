@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultDeliveryQueueSize = 100
+	DefaultMaxRetries        = 3
+	DefaultInitialBackoff    = time.Second
+	DefaultMaxBackoff        = 30 * time.Second
+	DefaultCutoffThreshold   = 10
+	DefaultCutoffPeriod      = time.Minute
+)
+
+// HTTPClient is the behavior required to deliver webhook requests.  *http.Client implements this interface.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// DispatcherOptions configures a webhook Dispatcher.  The zero value and a nil *DispatcherOptions
+// are both valid, and produce a Dispatcher with default settings.
+type DispatcherOptions struct {
+	// Logger is used for all dispatcher logging.  If unset, logging.DefaultLogger() is used.
+	Logger logging.Logger
+
+	// Client performs webhook deliveries.  If unset, http.DefaultClient is used.
+	Client HTTPClient
+
+	// QueueSize is the maximum number of events queued for any one webhook
+	QueueSize int
+
+	// MaxRetries is the number of times a failed delivery is retried.  A negative value
+	// disables retries, while zero uses DefaultMaxRetries.
+	MaxRetries int
+
+	// InitialBackoff is the wait before the first retry.  Each subsequent retry doubles this wait,
+	// up to MaxBackoff.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the longest wait between retries
+	MaxBackoff time.Duration
+
+	// CutoffThreshold is the number of consecutive failed deliveries, after retries, which
+	// causes a webhook to be cut off
+	CutoffThreshold int
+
+	// CutoffPeriod is how long a webhook remains cut off.  While cut off, events for the webhook
+	// are dead-lettered immediately.
+	CutoffPeriod time.Duration
+
+	// DeadLetter receives events that could not be delivered.  If unset, such events are discarded.
+	DeadLetter DeadLetterSink
+
+	// Metrics is the provider for per-webhook delivery metrics.  If unset, metrics are discarded.
+	Metrics xmetrics.Provider
+
+	// HopRecorder is the optional recorder that appends a hop to the spans of each event's Message
+	// as it is delivered to a webhook.  If unset, no hops are recorded.
+	HopRecorder *wrp.HopRecorder
+}
+
+func (o *DispatcherOptions) logger() logging.Logger {
+	if o != nil && o.Logger != nil {
+		return o.Logger
+	}
+
+	return logging.DefaultLogger()
+}
+
+func (o *DispatcherOptions) client() HTTPClient {
+	if o != nil && o.Client != nil {
+		return o.Client
+	}
+
+	return http.DefaultClient
+}
+
+func (o *DispatcherOptions) queueSize() int {
+	if o != nil && o.QueueSize > 0 {
+		return o.QueueSize
+	}
+
+	return DefaultDeliveryQueueSize
+}
+
+func (o *DispatcherOptions) maxRetries() int {
+	if o != nil {
+		if o.MaxRetries < 0 {
+			return 0
+		} else if o.MaxRetries > 0 {
+			return o.MaxRetries
+		}
+	}
+
+	return DefaultMaxRetries
+}
+
+func (o *DispatcherOptions) initialBackoff() time.Duration {
+	if o != nil && o.InitialBackoff > 0 {
+		return o.InitialBackoff
+	}
+
+	return DefaultInitialBackoff
+}
+
+func (o *DispatcherOptions) maxBackoff() time.Duration {
+	if o != nil && o.MaxBackoff > 0 {
+		return o.MaxBackoff
+	}
+
+	return DefaultMaxBackoff
+}
+
+func (o *DispatcherOptions) cutoffThreshold() int {
+	if o != nil && o.CutoffThreshold > 0 {
+		return o.CutoffThreshold
+	}
+
+	return DefaultCutoffThreshold
+}
+
+func (o *DispatcherOptions) cutoffPeriod() time.Duration {
+	if o != nil && o.CutoffPeriod > 0 {
+		return o.CutoffPeriod
+	}
+
+	return DefaultCutoffPeriod
+}
+
+func (o *DispatcherOptions) deadLetter() DeadLetterSink {
+	if o != nil && o.DeadLetter != nil {
+		return o.DeadLetter
+	}
+
+	return discardDeadLetter{}
+}
+
+func (o *DispatcherOptions) hopRecorder() *wrp.HopRecorder {
+	if o != nil {
+		return o.HopRecorder
+	}
+
+	return nil
+}
+
+func (o *DispatcherOptions) metricsProvider() xmetrics.Provider {
+	if o != nil && o.Metrics != nil {
+		return o.Metrics
+	}
+
+	return xmetrics.NewDiscardProvider()
+}
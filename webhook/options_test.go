@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDispatcherOptionsDefaults(t *testing.T) {
+	assert := assert.New(t)
+	for _, o := range []*DispatcherOptions{nil, new(DispatcherOptions)} {
+		assert.NotNil(o.logger())
+		assert.Equal(http.DefaultClient, o.client())
+		assert.Equal(DefaultDeliveryQueueSize, o.queueSize())
+		assert.Equal(DefaultMaxRetries, o.maxRetries())
+		assert.Equal(DefaultInitialBackoff, o.initialBackoff())
+		assert.Equal(DefaultMaxBackoff, o.maxBackoff())
+		assert.Equal(DefaultCutoffThreshold, o.cutoffThreshold())
+		assert.Equal(DefaultCutoffPeriod, o.cutoffPeriod())
+		assert.Equal(discardDeadLetter{}, o.deadLetter())
+		assert.NotNil(o.metricsProvider())
+	}
+}
+
+func TestDispatcherOptions(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		logger   = logging.TestLogger(t)
+		client   = new(http.Client)
+		provider = xmetrics.NewDiscardProvider()
+		sink     = DeadLetterFunc(func(W, *Event, error) {})
+
+		o = DispatcherOptions{
+			Logger:          logger,
+			Client:          client,
+			QueueSize:       17,
+			MaxRetries:      5,
+			InitialBackoff:  time.Millisecond,
+			MaxBackoff:      time.Second,
+			CutoffThreshold: 3,
+			CutoffPeriod:    time.Hour,
+			DeadLetter:      sink,
+			Metrics:         provider,
+		}
+	)
+
+	assert.Equal(logger, o.logger())
+	assert.Equal(client, o.client())
+	assert.Equal(17, o.queueSize())
+	assert.Equal(5, o.maxRetries())
+	assert.Equal(time.Millisecond, o.initialBackoff())
+	assert.Equal(time.Second, o.maxBackoff())
+	assert.Equal(3, o.cutoffThreshold())
+	assert.Equal(time.Hour, o.cutoffPeriod())
+	assert.NotNil(o.deadLetter())
+	assert.Equal(provider, o.metricsProvider())
+
+	o.MaxRetries = -1
+	assert.Equal(0, o.maxRetries())
+}
@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store is the persistence strategy for registered webhooks.  A Registry saves its entire
+// list through the Store whenever a registration changes, and loads it again at startup,
+// so that webhooks survive restarts.
+type Store interface {
+	// Load returns all persisted webhooks.  An empty store returns an empty slice and no error.
+	Load() ([]W, error)
+
+	// Save replaces the persisted webhooks with the given list
+	Save([]W) error
+}
+
+// FileStore is a Store that keeps webhooks as a JSON array in a single file.  Writes
+// go to a temporary file that is then renamed, so a crash never leaves a partial file.
+type FileStore struct {
+	Path string
+
+	lock sync.Mutex
+}
+
+func (fs *FileStore) Load() ([]W, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	data, err := ioutil.ReadFile(fs.Path)
+	if os.IsNotExist(err) {
+		return []W{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var hooks []W
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+func (fs *FileStore) Save(hooks []W) error {
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return err
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	temp, err := ioutil.TempFile(filepath.Dir(fs.Path), filepath.Base(fs.Path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), fs.Path)
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	directory, err := ioutil.TempDir("", "webhook")
+	require.NoError(err)
+	defer os.RemoveAll(directory)
+
+	store := &FileStore{Path: filepath.Join(directory, "webhooks.json")}
+
+	hooks, err := store.Load()
+	assert.Empty(hooks)
+	assert.NoError(err)
+
+	expected := []W{newTestW("http://first.com"), newTestW("http://second.com")}
+	expected[1].Config.Secret = "secret"
+	require.NoError(store.Save(expected))
+
+	hooks, err = store.Load()
+	require.NoError(err)
+	require.Len(hooks, 2)
+	assert.Equal("http://first.com", hooks[0].ID())
+	assert.Equal("http://second.com", hooks[1].ID())
+	assert.Equal("secret", hooks[1].Config.Secret)
+}
+
+func TestFileStoreInvalid(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	file, err := ioutil.TempFile("", "webhook")
+	require.NoError(err)
+	defer os.Remove(file.Name())
+
+	file.Write([]byte("this is not json"))
+	file.Close()
+
+	hooks, err := (&FileStore{Path: file.Name()}).Load()
+	assert.Nil(hooks)
+	assert.Error(err)
+}
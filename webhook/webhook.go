@@ -1,6 +1,7 @@
 package webhook
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -78,19 +79,22 @@ type UpdatableList interface {
 }
 
 type updatableList struct {
+	// lock serializes writers.  Readers are lock-free, since the list is copy-on-write.
+	lock  sync.Mutex
 	value atomic.Value
 }
 
-func (ul *updatableList) Len() int {
-	if list, ok := ul.value.Load().([]W); ok {
-		return len(list)
-	}
+func (ul *updatableList) load() []W {
+	list, _ := ul.value.Load().([]W)
+	return list
+}
 
-	return 0
+func (ul *updatableList) Len() int {
+	return len(ul.load())
 }
 
 func (ul *updatableList) Get(index int) *W {
-	if list, ok := ul.value.Load().([]W); ok {
+	if list := ul.load(); index >= 0 && index < len(list) {
 		return &list[index]
 	}
 
@@ -102,55 +106,80 @@ func (ul *updatableList) Get(index int) *W {
 
 // getAll builds a list of registered listeners
 func (ul *updatableList) GetAll() (all []*W) {
-	for i:=0; i<ul.Len(); i++ {
-		all = append(all, ul.Get(i))
+	list := ul.load()
+	for i := 0; i < len(list); i++ {
+		all = append(all, &list[i])
 	}
-	
+
 	return
 }
 
+// expiration computes the absolute expiry of a webhook given its requested duration
+func expiration(now time.Time, duration time.Duration) time.Time {
+	if duration > 0 && duration < DEFAULT_EXPIRATION_DURATION {
+		return now.Add(duration)
+	}
+
+	return now.Add(DEFAULT_EXPIRATION_DURATION)
+}
+
 func (ul *updatableList) Update(newItems []W) {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	var (
+		now      = time.Now()
+		existing = ul.load()
+		items    = make([]W, len(existing), len(existing)+len(newItems))
+	)
+
+	copy(items, existing)
 	for _, newItem := range newItems {
 		found := false
-		items := ul.GetAll()
-		
+
 		// update item
-		for i:=0; i<len(items) && !found; i++  {
+		for i := 0; i < len(items) && !found; i++ {
 			if items[i].Config.URL == newItem.Config.URL {
 				found = true
-				
-				if newItem.Duration > 0 && newItem.Duration < DEFAULT_EXPIRATION_DURATION {
-					items[i].Until = time.Now().Add(newItem.Duration)
-				} else {
-					items[i].Until = time.Now().Add(DEFAULT_EXPIRATION_DURATION)
-				}
+
+				items[i].Duration = newItem.Duration
+				items[i].Until = expiration(now, newItem.Duration)
 				items[i].Matcher = newItem.Matcher
 				items[i].Events = newItem.Events
 				items[i].Config.ContentType = newItem.Config.ContentType
 				items[i].Config.Secret = newItem.Config.Secret
+				items[i].FailureURL = newItem.FailureURL
+				if len(newItem.Address) > 0 {
+					items[i].Address = newItem.Address
+				}
 			}
 		}
-		
+
 		// add item
 		if !found {
-			newItem.Until = time.Now().Add(DEFAULT_EXPIRATION_DURATION)
-			items = append(items, &newItem)
+			newItem.Until = expiration(now, newItem.Duration)
+			items = append(items, newItem)
 		}
-		
-		// store items
-		ul.value.Store(items)
 	}
+
+	// store items
+	ul.value.Store(items)
 }
 
 func (ul *updatableList) Filter(filter func([]W) []W) {
-	if list, ok := ul.value.Load().([]W); ok {
-		copyOf := make([]W, len(list))
-		for i, w := range list {
-			copyOf[i] = w
-		}
-
-		ul.Update(filter(copyOf))
+	if filter == nil {
+		return
 	}
+
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	list := ul.load()
+	copyOf := make([]W, len(list))
+	copy(copyOf, list)
+
+	// filtering replaces the list wholesale, so that webhooks can be removed
+	ul.value.Store(filter(copyOf))
 }
 
 // NewList just creates an UpdatableList.  Don't forget:
@@ -159,4 +188,4 @@ func NewList(initial []W) UpdatableList {
 	ul := &updatableList{}
 	ul.Update(initial)
 	return ul
-}
\ No newline at end of file
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestW(url string) W {
+	w := W{Events: []string{".*"}}
+	w.Config.URL = url
+	w.Config.ContentType = "json"
+	return w
+}
+
+func TestNewListEmpty(t *testing.T) {
+	assert := assert.New(t)
+	list := NewList(nil)
+
+	assert.Equal(0, list.Len())
+	assert.Nil(list.Get(0))
+	assert.Empty(list.GetAll())
+}
+
+func TestListUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	first := newTestW("http://first.com")
+	first.Duration = time.Minute
+	second := newTestW("http://second.com")
+
+	list := NewList([]W{first})
+	assert.Equal(1, list.Len())
+	assert.Equal("http://first.com", list.Get(0).ID())
+	assert.True(list.Get(0).Until.After(time.Now()))
+	assert.True(list.Get(0).Until.Before(time.Now().Add(2 * time.Minute)))
+
+	list.Update([]W{second})
+	assert.Equal(2, list.Len())
+	assert.Equal("http://second.com", list.Get(1).ID())
+	assert.True(list.Get(1).Until.After(time.Now().Add(DEFAULT_EXPIRATION_DURATION - time.Minute)))
+
+	updated := newTestW("http://first.com")
+	updated.Events = []string{"updated"}
+	updated.Config.Secret = "secret"
+	list.Update([]W{updated})
+	assert.Equal(2, list.Len())
+	assert.Equal([]string{"updated"}, list.Get(0).Events)
+	assert.Equal("secret", list.Get(0).Config.Secret)
+
+	all := list.GetAll()
+	assert.Len(all, 2)
+	assert.Equal("http://first.com", all[0].ID())
+	assert.Equal("http://second.com", all[1].ID())
+}
+
+func TestListFilter(t *testing.T) {
+	assert := assert.New(t)
+	list := NewList([]W{newTestW("http://first.com"), newTestW("http://second.com")})
+
+	list.Filter(nil)
+	assert.Equal(2, list.Len())
+
+	list.Filter(func(hooks []W) []W {
+		assert.Len(hooks, 2)
+		return hooks[1:]
+	})
+
+	assert.Equal(1, list.Len())
+	assert.Equal("http://second.com", list.Get(0).ID())
+}
@@ -0,0 +1,165 @@
+package wrp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	// ChunkIndexKey is the metadata key holding the zero-based position of a chunk within its payload
+	ChunkIndexKey = "chunk-index"
+
+	// ChunkFinalKey is the metadata key that flags the last chunk of a payload.  Its value is always "true".
+	ChunkFinalKey = "chunk-final"
+
+	// DefaultChunkSize is the largest payload, in bytes, carried by each chunk when a Chunker is
+	// created with a nonpositive chunk size
+	DefaultChunkSize = 64 * 1024
+)
+
+var (
+	ErrorNotChunk        = errors.New("The message is not a chunk")
+	ErrorChunkOutOfOrder = errors.New("The chunk is out of order")
+	ErrorChunksComplete  = errors.New("The final chunk has already been received")
+)
+
+// chunkMetadata copies a template's metadata, adding the chunk keys
+func chunkMetadata(template map[string]string, index int, final bool) map[string]string {
+	metadata := make(map[string]string, len(template)+2)
+	for k, v := range template {
+		metadata[k] = v
+	}
+
+	metadata[ChunkIndexKey] = strconv.Itoa(index)
+	if final {
+		metadata[ChunkFinalKey] = "true"
+	}
+
+	return metadata
+}
+
+// IsChunk tests whether a message was produced by a Chunker
+func IsChunk(message *Message) bool {
+	_, ok := message.Metadata[ChunkIndexKey]
+	return ok
+}
+
+// ParseChunk returns the position of a chunk within its payload and whether it is the final chunk
+func ParseChunk(message *Message) (index int, final bool, err error) {
+	value, ok := message.Metadata[ChunkIndexKey]
+	if !ok {
+		return 0, false, ErrorNotChunk
+	}
+
+	if index, err = strconv.Atoi(value); err != nil || index < 0 {
+		return 0, false, ErrorNotChunk
+	}
+
+	return index, message.Metadata[ChunkFinalKey] == "true", nil
+}
+
+// Chunker splits a payload that is too large for a single frame into a sequence of messages, each
+// carrying at most a fixed number of payload bytes.  Every chunk is a copy of a template message, with
+// ChunkIndexKey and, on the last chunk, ChunkFinalKey added to its metadata.  The payload is read
+// incrementally, so only one chunk is held in memory at a time.
+//
+// A ChunkAssembler reverses this process.
+type Chunker struct {
+	template  Message
+	source    *bufio.Reader
+	chunkSize int
+	index     int
+	done      bool
+}
+
+// NewChunker creates a Chunker for a payload.  The template's own Payload is ignored.  If chunkSize is
+// not positive, DefaultChunkSize is used.
+func NewChunker(template *Message, payload io.Reader, chunkSize int) *Chunker {
+	if chunkSize < 1 {
+		chunkSize = DefaultChunkSize
+	}
+
+	c := &Chunker{
+		template:  *template,
+		source:    bufio.NewReaderSize(payload, chunkSize+1),
+		chunkSize: chunkSize,
+	}
+
+	c.template.Payload = nil
+	return c
+}
+
+// Next returns the next chunk, or io.EOF once every chunk has been returned.  An empty payload produces
+// a single, final chunk.
+//
+// The payload of each chunk refers to an internal buffer, so a chunk must be encoded before Next is called again.
+func (c *Chunker) Next() (*Message, error) {
+	if c.done {
+		return nil, io.EOF
+	}
+
+	// peeking one byte past the chunk reveals whether this is the last chunk
+	data, err := c.source.Peek(c.chunkSize + 1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	final := len(data) <= c.chunkSize
+	if !final {
+		data = data[:c.chunkSize]
+	}
+
+	c.source.Discard(len(data))
+
+	chunk := c.template
+	chunk.Payload = data
+	chunk.Metadata = chunkMetadata(c.template.Metadata, c.index, final)
+
+	c.index++
+	c.done = final
+	return &chunk, nil
+}
+
+// ChunkAssembler reassembles a payload from the chunks produced by a Chunker.  Chunks must be added in order.
+type ChunkAssembler struct {
+	output io.Writer
+	next   int
+	done   bool
+}
+
+// NewChunkAssembler creates a ChunkAssembler which writes the reassembled payload to output
+func NewChunkAssembler(output io.Writer) *ChunkAssembler {
+	return &ChunkAssembler{output: output}
+}
+
+// Add writes the payload of the next chunk to this assembler's output.  This method returns true once the
+// final chunk has been written.
+func (ca *ChunkAssembler) Add(chunk *Message) (bool, error) {
+	if ca.done {
+		return true, ErrorChunksComplete
+	}
+
+	index, final, err := ParseChunk(chunk)
+	if err != nil {
+		return false, err
+	}
+
+	if index != ca.next {
+		return false, ErrorChunkOutOfOrder
+	}
+
+	if _, err := ca.output.Write(chunk.Payload); err != nil {
+		return false, err
+	}
+
+	ca.next++
+	ca.done = final
+	return final, nil
+}
+
+// Done tests whether the final chunk has been added
+func (ca *ChunkAssembler) Done() bool {
+	return ca.done
+}
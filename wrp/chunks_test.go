@@ -0,0 +1,176 @@
+package wrp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func testChunkerRoundTrip(t *testing.T, size, chunkSize, expectedChunks int) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		payload  = bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+		template = &Message{
+			Type:            SimpleRequestResponseMessageType,
+			Source:          "dns:firmware.example.com",
+			Destination:     "mac:112233445566/firmware",
+			TransactionUUID: "download",
+			Metadata:        map[string]string{"image": "primary"},
+			Payload:         []byte("ignored"),
+		}
+
+		chunker   = NewChunker(template, bytes.NewReader(payload), chunkSize)
+		output    bytes.Buffer
+		assembler = NewChunkAssembler(&output)
+		chunks    int
+	)
+
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(err)
+		require.NotNil(chunk)
+		assert.True(IsChunk(chunk))
+		if chunkSize > 0 {
+			assert.True(len(chunk.Payload) <= chunkSize)
+		} else {
+			assert.True(len(chunk.Payload) <= DefaultChunkSize)
+		}
+		assert.Equal(template.Destination, chunk.Destination)
+		assert.Equal("primary", chunk.Metadata["image"])
+
+		// chunks go over the wire encoded, one per frame
+		var encoded []byte
+		require.NoError(NewEncoderBytes(&encoded, Msgpack).Encode(chunk))
+
+		decoded := new(Message)
+		require.NoError(NewDecoderBytes(encoded, Msgpack).Decode(decoded))
+
+		index, final, err := ParseChunk(decoded)
+		require.NoError(err)
+		assert.Equal(chunks, index)
+
+		done, err := assembler.Add(decoded)
+		require.NoError(err)
+		assert.Equal(final, done)
+		assert.Equal(final, assembler.Done())
+		chunks++
+	}
+
+	assert.Equal(expectedChunks, chunks)
+	assert.True(assembler.Done())
+	assert.Equal(string(payload), output.String())
+	assert.Equal(map[string]string{"image": "primary"}, template.Metadata)
+	assert.Equal([]byte("ignored"), template.Payload)
+
+	// once done, a Chunker stays done
+	chunk, err := chunker.Next()
+	assert.Nil(chunk)
+	assert.Equal(io.EOF, err)
+}
+
+func TestChunker(t *testing.T) {
+	testData := []struct {
+		size, chunkSize, expectedChunks int
+	}{
+		{0, 10, 1},
+		{9, 10, 1},
+		{10, 10, 1},
+		{11, 10, 2},
+		{100, 10, 10},
+		{105, 10, 11},
+		{3*DefaultChunkSize + 1, 0, 4},
+	}
+
+	for _, record := range testData {
+		t.Run(strconv.Itoa(record.size)+"/"+strconv.Itoa(record.chunkSize), func(t *testing.T) {
+			testChunkerRoundTrip(t, record.size, record.chunkSize, record.expectedChunks)
+		})
+	}
+}
+
+func TestChunkerError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		chunker       = NewChunker(&Message{Type: SimpleEventMessageType}, errorReader{expectedError}, 10)
+	)
+
+	chunk, err := chunker.Next()
+	assert.Nil(chunk)
+	assert.Equal(expectedError, err)
+}
+
+func TestParseChunk(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, message := range []*Message{
+		{},
+		{Metadata: map[string]string{"foo": "bar"}},
+	} {
+		assert.False(IsChunk(message))
+		_, _, err := ParseChunk(message)
+		assert.Equal(ErrorNotChunk, err)
+	}
+
+	for _, value := range []string{"", "abc", "-1"} {
+		message := &Message{Metadata: map[string]string{ChunkIndexKey: value}}
+		assert.True(IsChunk(message))
+		_, _, err := ParseChunk(message)
+		assert.Equal(ErrorNotChunk, err)
+	}
+
+	index, final, err := ParseChunk(&Message{Metadata: map[string]string{ChunkIndexKey: "3", ChunkFinalKey: "true"}})
+	assert.Equal(3, index)
+	assert.True(final)
+	assert.NoError(err)
+}
+
+func TestChunkAssemblerErrors(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		output    bytes.Buffer
+		assembler = NewChunkAssembler(&output)
+	)
+
+	done, err := assembler.Add(&Message{Payload: []byte("not a chunk")})
+	assert.False(done)
+	assert.Equal(ErrorNotChunk, err)
+
+	done, err = assembler.Add(&Message{Payload: []byte("second"), Metadata: map[string]string{ChunkIndexKey: "1"}})
+	assert.False(done)
+	assert.Equal(ErrorChunkOutOfOrder, err)
+
+	done, err = assembler.Add(&Message{Payload: []byte("first"), Metadata: map[string]string{ChunkIndexKey: "0", ChunkFinalKey: "true"}})
+	assert.True(done)
+	assert.NoError(err)
+
+	done, err = assembler.Add(&Message{Payload: []byte("extra"), Metadata: map[string]string{ChunkIndexKey: "1"}})
+	assert.True(done)
+	assert.Equal(ErrorChunksComplete, err)
+	assert.Equal("first", output.String())
+
+	expectedError := errors.New("expected")
+	assembler = NewChunkAssembler(&failingWriter{expectedError})
+	done, err = assembler.Add(&Message{Payload: []byte("first"), Metadata: map[string]string{ChunkIndexKey: "0"}})
+	assert.False(done)
+	assert.Equal(expectedError, err)
+	assert.False(assembler.Done())
+}
+
+// failingWriter is an io.Writer that always fails
+type failingWriter struct {
+	err error
+}
+
+func (fw *failingWriter) Write([]byte) (int, error) {
+	return 0, fw.err
+}
@@ -0,0 +1,174 @@
+package wrp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+)
+
+const (
+	// PayloadEncodingKey is the metadata key that flags a compressed payload.  Its value
+	// names the compression applied, e.g. GzipEncoding.
+	PayloadEncodingKey = "content-encoding"
+
+	// GzipEncoding is the PayloadEncodingKey value for a gzip-compressed payload
+	GzipEncoding = "gzip"
+
+	// DefaultCompressionThreshold is the payload size, in bytes, at or above which payloads are
+	// compressed when a compressing encoder is created with a nonpositive threshold
+	DefaultCompressionThreshold = 1024
+)
+
+var (
+	ErrorInvalidCompressedPayload = errors.New("The compressed payload could not be decompressed")
+)
+
+// CompressPayload gzips a payload
+func CompressPayload(payload []byte) ([]byte, error) {
+	var (
+		output bytes.Buffer
+		writer = gzip.NewWriter(&output)
+	)
+
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return output.Bytes(), nil
+}
+
+// DecompressPayload reverses CompressPayload
+func DecompressPayload(payload []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, ErrorInvalidCompressedPayload
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, ErrorInvalidCompressedPayload
+	}
+
+	return decompressed, nil
+}
+
+// payloadFields returns pointers to the payload and metadata of the WRP message types that carry both.
+// For any other value, this function returns false.
+func payloadFields(value interface{}) (*[]byte, *map[string]string, bool) {
+	switch message := value.(type) {
+	case *Message:
+		return &message.Payload, &message.Metadata, true
+	case *SimpleRequestResponse:
+		return &message.Payload, &message.Metadata, true
+	case *SimpleEvent:
+		return &message.Payload, &message.Metadata, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// shallowCopy copies a message produced by payloadFields, so that its payload and metadata may be replaced
+func shallowCopy(value interface{}) interface{} {
+	switch message := value.(type) {
+	case *Message:
+		copied := *message
+		return &copied
+	case *SimpleRequestResponse:
+		copied := *message
+		return &copied
+	case *SimpleEvent:
+		copied := *message
+		return &copied
+	default:
+		return value
+	}
+}
+
+// compressingEncoder decorates an Encoder so that large payloads are gzipped
+type compressingEncoder struct {
+	Encoder
+	threshold int
+}
+
+// NewCompressingEncoder decorates an Encoder so that each message whose payload is at least threshold
+// bytes is sent with a gzipped payload, flagged with the PayloadEncodingKey metadata.  Only the encoded
+// form is compressed: messages passed to Encode are never modified.  The payload is left as is if
+// compression would not make it smaller, or if the message already declares a payload encoding.
+//
+// Decoders created by this package transparently decompress such payloads.  If threshold is not
+// positive, DefaultCompressionThreshold is used.
+func NewCompressingEncoder(encoder Encoder, threshold int) Encoder {
+	if threshold < 1 {
+		threshold = DefaultCompressionThreshold
+	}
+
+	return &compressingEncoder{Encoder: encoder, threshold: threshold}
+}
+
+func (ce *compressingEncoder) Encode(value interface{}) error {
+	payload, metadata, ok := payloadFields(value)
+	if !ok || len(*payload) < ce.threshold {
+		return ce.Encoder.Encode(value)
+	}
+
+	if _, encoded := (*metadata)[PayloadEncodingKey]; encoded {
+		return ce.Encoder.Encode(value)
+	}
+
+	compressed, err := CompressPayload(*payload)
+	if err != nil {
+		return err
+	}
+
+	if len(compressed) >= len(*payload) {
+		return ce.Encoder.Encode(value)
+	}
+
+	copied := shallowCopy(value)
+	payload, metadata, _ = payloadFields(copied)
+
+	flagged := make(map[string]string, len(*metadata)+1)
+	for k, v := range *metadata {
+		flagged[k] = v
+	}
+
+	flagged[PayloadEncodingKey] = GzipEncoding
+	*payload = compressed
+	*metadata = flagged
+	return ce.Encoder.Encode(copied)
+}
+
+// decoderDecorator wraps a ugorji Decoder so that compressed payloads are transparently decompressed
+type decoderDecorator struct {
+	Decoder
+}
+
+func (dd *decoderDecorator) Decode(value interface{}) error {
+	if err := dd.Decoder.Decode(value); err != nil {
+		return err
+	}
+
+	payload, metadata, ok := payloadFields(value)
+	if !ok || (*metadata)[PayloadEncodingKey] != GzipEncoding {
+		// payload encodings other than gzip are left for the application to interpret
+		return nil
+	}
+
+	decompressed, err := DecompressPayload(*payload)
+	if err != nil {
+		return err
+	}
+
+	*payload = decompressed
+	delete(*metadata, PayloadEncodingKey)
+	if len(*metadata) == 0 {
+		*metadata = nil
+	}
+
+	return nil
+}
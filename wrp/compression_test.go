@@ -0,0 +1,191 @@
+package wrp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	"testing"
+)
+
+var compressiblePayload = bytes.Repeat([]byte("a highly compressible payload "), 100)
+
+// codecDecoderBytes creates an undecorated Decoder, which exposes payloads as they were sent
+func codecDecoderBytes(input []byte, f Format) Decoder {
+	return codec.NewDecoderBytes(input, f.handle())
+}
+
+func TestCompressPayload(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	compressed, err := CompressPayload(compressiblePayload)
+	require.NoError(err)
+	assert.True(len(compressed) < len(compressiblePayload))
+
+	decompressed, err := DecompressPayload(compressed)
+	require.NoError(err)
+	assert.Equal(compressiblePayload, decompressed)
+
+	decompressed, err = DecompressPayload([]byte("this is not gzipped"))
+	assert.Nil(decompressed)
+	assert.Equal(ErrorInvalidCompressedPayload, err)
+
+	decompressed, err = DecompressPayload(compressed[:len(compressed)/2])
+	assert.Nil(decompressed)
+	assert.Equal(ErrorInvalidCompressedPayload, err)
+}
+
+func testCompressingEncoderRoundTrip(t *testing.T, f Format) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		messages = []struct {
+			original interface{}
+			decoded  interface{}
+		}{
+			{
+				&Message{Type: SimpleEventMessageType, Source: "mac:112233445566", Destination: "event:test", Payload: compressiblePayload},
+				new(Message),
+			},
+			{
+				&SimpleRequestResponse{Source: "mac:112233445566", Destination: "dns:test", Metadata: map[string]string{"foo": "bar"}, Payload: compressiblePayload},
+				new(SimpleRequestResponse),
+			},
+			{
+				&SimpleEvent{Source: "mac:112233445566", Destination: "event:test", Payload: compressiblePayload},
+				new(SimpleEvent),
+			},
+		}
+	)
+
+	for _, record := range messages {
+		var (
+			output  []byte
+			encoder = NewCompressingEncoder(NewEncoderBytes(&output, f), 0)
+		)
+
+		require.NoError(encoder.Encode(record.original))
+
+		// the original message is never modified
+		payload, metadata, ok := payloadFields(record.original)
+		require.True(ok)
+		assert.Equal(compressiblePayload, *payload)
+		assert.NotContains(*metadata, PayloadEncodingKey)
+
+		// the wire form carries a flagged, compressed payload
+		raw := new(Message)
+		require.NoError(codecDecoderBytes(output, f).Decode(raw))
+		assert.Equal(GzipEncoding, raw.Metadata[PayloadEncodingKey])
+		assert.True(len(raw.Payload) < len(compressiblePayload))
+
+		// decoders transparently decompress
+		require.NoError(NewDecoderBytes(output, f).Decode(record.decoded))
+		payload, metadata, _ = payloadFields(record.decoded)
+		assert.Equal(compressiblePayload, *payload)
+		assert.NotContains(*metadata, PayloadEncodingKey)
+
+		if _, ok := record.original.(*SimpleRequestResponse); ok {
+			assert.Equal(map[string]string{"foo": "bar"}, *metadata)
+		} else {
+			assert.Nil(*metadata)
+		}
+	}
+}
+
+func testCompressingEncoderSkipped(t *testing.T, f Format) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		incompressible = make([]byte, 2048)
+	)
+
+	_, err := rand.Read(incompressible)
+	require.NoError(err)
+
+	for _, message := range []*Message{
+		// below the threshold
+		{Source: "mac:112233445566", Payload: []byte("small")},
+
+		// already encoded by the application
+		{Source: "mac:112233445566", Metadata: map[string]string{PayloadEncodingKey: "br"}, Payload: compressiblePayload},
+
+		// compression would not help
+		{Source: "mac:112233445566", Payload: incompressible},
+	} {
+		var (
+			output  []byte
+			encoder = NewCompressingEncoder(NewEncoderBytes(&output, f), 100)
+			raw     = new(Message)
+		)
+
+		require.NoError(encoder.Encode(message))
+		require.NoError(codecDecoderBytes(output, f).Decode(raw))
+		assert.Equal(message.Payload, raw.Payload)
+		assert.Equal(message.Metadata, raw.Metadata)
+
+		// payload encodings other than gzip are not touched by decoders
+		decoded := new(Message)
+		require.NoError(NewDecoderBytes(output, f).Decode(decoded))
+		assert.Equal(message.Payload, decoded.Payload)
+		assert.Equal(message.Metadata, decoded.Metadata)
+	}
+}
+
+func testDecoderInvalidCompressedPayload(t *testing.T, f Format) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		output  []byte
+	)
+
+	require.NoError(NewEncoderBytes(&output, f).Encode(&Message{
+		Source:   "mac:112233445566",
+		Metadata: map[string]string{PayloadEncodingKey: GzipEncoding},
+		Payload:  []byte("this is not gzipped"),
+	}))
+
+	assert.Equal(ErrorInvalidCompressedPayload, NewDecoderBytes(output, f).Decode(new(Message)))
+}
+
+func TestCompressingEncoder(t *testing.T) {
+	for _, f := range []Format{Msgpack, JSON} {
+		t.Run(f.String(), func(t *testing.T) {
+			t.Run("RoundTrip", func(t *testing.T) { testCompressingEncoderRoundTrip(t, f) })
+			t.Run("Skipped", func(t *testing.T) { testCompressingEncoderSkipped(t, f) })
+			t.Run("InvalidCompressedPayload", func(t *testing.T) { testDecoderInvalidCompressedPayload(t, f) })
+		})
+	}
+}
+
+func TestCompressingEncoderPool(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		encoderPool = NewCompressingEncoderPool(1, Msgpack, 100)
+		decoderPool = NewDecoderPool(1, Msgpack)
+
+		output  []byte
+		raw     = new(Message)
+		decoded = new(Message)
+	)
+
+	require.NoError(encoderPool.EncodeBytes(&output, &Message{Source: "mac:112233445566", Payload: compressiblePayload}))
+	require.NoError(codecDecoderBytes(output, Msgpack).Decode(raw))
+	assert.Equal(GzipEncoding, raw.Metadata[PayloadEncodingKey])
+
+	require.NoError(decoderPool.DecodeBytes(decoded, output))
+	assert.Equal(compressiblePayload, decoded.Payload)
+
+	// pools without a threshold never compress
+	output = nil
+	raw = new(Message)
+	require.NoError(NewEncoderPool(1, Msgpack).EncodeBytes(&output, &Message{Source: "mac:112233445566", Payload: compressiblePayload}))
+	require.NoError(codecDecoderBytes(output, Msgpack).Decode(raw))
+	assert.Equal(compressiblePayload, raw.Payload)
+}
@@ -77,5 +77,27 @@ Some common uses of this package include:
 		return buffer.Bytes(), nil
 	}
 
+(5) Streaming large payloads, such as firmware images, without holding them in memory:
+
+	func sendFirmware(output io.Writer, message *Message, image *os.File, size int64) error {
+		return EncodeStream(output, message, image, size)
+	}
+
+	func receiveFirmware(input io.Reader, image io.Writer) (*Message, error) {
+		message, payload, err := DecodeStream(input)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.Copy(image, payload)
+		return message, err
+	}
+
+Payloads larger than a single frame can be split into chunks with a Chunker, and reassembled on the
+other side with a ChunkAssembler.
+
+HTTP handlers that work with a single format can accept and return WRP messages in either format by
+decorating them with a Transcoder, which negotiates formats via the Content-Type and Accept headers.
+
 */
 package wrp
@@ -116,15 +116,19 @@ func NewEncoderBytes(output *[]byte, f Format) Encoder {
 }
 
 // NewDecoder produces a ugorji Decoder using the appropriate WRP configuration
-// for the given format
+// for the given format.  Payloads compressed by a compressing Encoder are decompressed.
 func NewDecoder(input io.Reader, f Format) Decoder {
-	return codec.NewDecoder(input, f.handle())
+	return &decoderDecorator{
+		codec.NewDecoder(input, f.handle()),
+	}
 }
 
 // NewDecoderBytes produces a ugorji Decoder using the appropriate WRP configuration
-// for the given format
+// for the given format.  Payloads compressed by a compressing Encoder are decompressed.
 func NewDecoderBytes(input []byte, f Format) Decoder {
-	return codec.NewDecoderBytes(input, f.handle())
+	return &decoderDecorator{
+		codec.NewDecoderBytes(input, f.handle()),
+	}
 }
 
 // TranscodeMessage converts a WRP message of any type from one format into another,
@@ -17,6 +17,19 @@ const (
 	InvalidMessageTypeString = "!!INVALID!!"
 )
 
+// QOSValue is the quality of service of a WRP message, from 0 to 99.  Higher values indicate
+// more important messages.  Values are grouped into levels, with each level beginning at one of the
+// QOS*Value constants.
+type QOSValue int
+
+const (
+	QOSLowValue      QOSValue = 0
+	QOSMediumValue   QOSValue = 25
+	QOSHighValue     QOSValue = 50
+	QOSCriticalValue QOSValue = 75
+	QOSMaxValue      QOSValue = 99
+)
+
 func (mt MessageType) String() string {
 	switch mt {
 	case AuthMessageType:
@@ -117,6 +130,7 @@ type Message struct {
 	Payload                 []byte            `wrp:"payload,omitempty"`
 	ServiceName             string            `wrp:"service_name,omitempty"`
 	URL                     string            `wrp:"url,omitempty"`
+	QualityOfService        QOSValue          `wrp:"qos,omitempty"`
 }
 
 func (msg *Message) MessageType() MessageType {
@@ -160,6 +160,7 @@ func TestMessage(t *testing.T) {
 				Status:                  &expectedStatus,
 				RequestDeliveryResponse: &expectedRequestDeliveryResponse,
 				IncludeSpans:            &expectedIncludeSpans,
+				QualityOfService:        QOSCriticalValue,
 			},
 			Message{
 				Type:            SimpleRequestResponseMessageType,
@@ -12,21 +12,30 @@ const (
 // encode WRP messages.  Unlike a sync.Pool, this pool holds on to its pooled
 // encoders across garbage collections.
 type EncoderPool struct {
-	pool   chan Encoder
-	format Format
+	pool                 chan Encoder
+	format               Format
+	compressionThreshold int
 }
 
 // NewEncoderPool returns an EncoderPool for a given format.  The initialBufferSize is
 // used when encoding to byte arrays.  If this value is nonpositive, DefaultInitialBufferSize
 // is used instead.
 func NewEncoderPool(poolSize int, f Format) *EncoderPool {
+	return NewCompressingEncoderPool(poolSize, f, 0)
+}
+
+// NewCompressingEncoderPool returns an EncoderPool whose encoders gzip payloads of at least
+// compressionThreshold bytes, as described by NewCompressingEncoder.  If compressionThreshold
+// is not positive, payloads are never compressed.
+func NewCompressingEncoderPool(poolSize int, f Format, compressionThreshold int) *EncoderPool {
 	if poolSize < 1 {
 		poolSize = DefaultPoolSize
 	}
 
 	ep := &EncoderPool{
-		pool:   make(chan Encoder, poolSize),
-		format: f,
+		pool:                 make(chan Encoder, poolSize),
+		format:               f,
+		compressionThreshold: compressionThreshold,
 	}
 
 	for repeat := 0; repeat < poolSize; repeat++ {
@@ -45,7 +54,12 @@ func (ep *EncoderPool) Format() Format {
 // This method is used internally to populate and manage the pool, but
 // can also be used externally to obtain a new, unpooled instance.
 func (ep *EncoderPool) New() Encoder {
-	return NewEncoder(nil, ep.format)
+	encoder := NewEncoder(nil, ep.format)
+	if ep.compressionThreshold > 0 {
+		encoder = NewCompressingEncoder(encoder, ep.compressionThreshold)
+	}
+
+	return encoder
 }
 
 // Get returns an Encoder from the pool.  If the pool is empty, a new Encoder is
@@ -0,0 +1,139 @@
+package wrp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// HopStatusOK is the hop status recorded when a component handled a message successfully
+	HopStatusOK = http.StatusOK
+
+	// hopFields is the number of strings in a span produced by Hop
+	hopFields = 5
+)
+
+var (
+	ErrorInvalidSpan = errors.New("Invalid WRP span")
+)
+
+// Hop describes one component's handling of a message.  Hops are carried in the spans of a message,
+// each span being a list of strings of the form [parent, name, start time, duration, status].  The start
+// time is in nanoseconds since the Unix epoch, and the duration is in nanoseconds.
+type Hop struct {
+	// Parent is the name of the previous hop, or empty if this is the first hop
+	Parent string
+
+	// Name identifies the node and component that recorded this hop
+	Name string
+
+	// Start is when the component began handling the message
+	Start time.Time
+
+	// Duration is how long the component held the message
+	Duration time.Duration
+
+	// Status is an HTTP-style status code describing the outcome of this hop
+	Status int
+}
+
+// Span produces the WRP span for this hop
+func (h Hop) Span() []string {
+	return []string{
+		h.Parent,
+		h.Name,
+		strconv.FormatInt(h.Start.UnixNano(), 10),
+		strconv.FormatInt(int64(h.Duration), 10),
+		strconv.Itoa(h.Status),
+	}
+}
+
+// ParseHop reconstructs a Hop from a WRP span
+func ParseHop(span []string) (Hop, error) {
+	if len(span) != hopFields {
+		return Hop{}, ErrorInvalidSpan
+	}
+
+	start, err := strconv.ParseInt(span[2], 10, 64)
+	if err != nil {
+		return Hop{}, ErrorInvalidSpan
+	}
+
+	duration, err := strconv.ParseInt(span[3], 10, 64)
+	if err != nil {
+		return Hop{}, ErrorInvalidSpan
+	}
+
+	status, err := strconv.Atoi(span[4])
+	if err != nil {
+		return Hop{}, ErrorInvalidSpan
+	}
+
+	return Hop{
+		Parent:   span[0],
+		Name:     span[1],
+		Start:    time.Unix(0, start),
+		Duration: time.Duration(duration),
+		Status:   status,
+	}, nil
+}
+
+// HopRecorder appends hops to the spans of messages as they pass through a component.  A nil
+// *HopRecorder is valid, and records nothing.
+type HopRecorder struct {
+	// Name identifies this node and component, e.g. talaria-1.device.  This value is the name of each hop.
+	Name string
+
+	// Always causes hops to be recorded for every message.  By default, hops are only recorded
+	// for messages that have set IncludeSpans.
+	Always bool
+
+	// Now is the source of the current time.  If nil, time.Now is used.
+	Now func() time.Time
+}
+
+func (r *HopRecorder) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+
+	return time.Now()
+}
+
+// Records tests whether this recorder will append a hop to the given message
+func (r *HopRecorder) Records(message *Message) bool {
+	if r == nil || message == nil {
+		return false
+	}
+
+	return r.Always || (message.IncludeSpans != nil && *message.IncludeSpans)
+}
+
+// Record appends a hop to the message's spans, if this recorder records the message.  The hop began at start
+// and lasts until now.  This method returns true if a hop was recorded.
+//
+// The message's existing Spans slice is never modified, so it is safe to record hops in a shallow copy of a
+// shared message.
+func (r *HopRecorder) Record(message *Message, start time.Time, status int) bool {
+	if !r.Records(message) {
+		return false
+	}
+
+	hop := Hop{
+		Name:     r.Name,
+		Start:    start,
+		Duration: r.now().Sub(start),
+		Status:   status,
+	}
+
+	if count := len(message.Spans); count > 0 && len(message.Spans[count-1]) > 1 {
+		hop.Parent = message.Spans[count-1][1]
+	}
+
+	spans := make([][]string, len(message.Spans), len(message.Spans)+1)
+	copy(spans, message.Spans)
+	message.Spans = append(spans, hop.Span())
+	return true
+}
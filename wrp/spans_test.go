@@ -0,0 +1,101 @@
+package wrp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHop(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		hop    = Hop{
+			Parent:   "talaria-1.device",
+			Name:     "caduceus-1.webhook",
+			Start:    time.Unix(1500000000, 123),
+			Duration: 25 * time.Millisecond,
+			Status:   http.StatusOK,
+		}
+	)
+
+	span := hop.Span()
+	assert.Equal([]string{"talaria-1.device", "caduceus-1.webhook", "1500000000000000123", "25000000", "200"}, span)
+
+	parsed, err := ParseHop(span)
+	assert.NoError(err)
+	assert.Equal(hop.Parent, parsed.Parent)
+	assert.Equal(hop.Name, parsed.Name)
+	assert.True(hop.Start.Equal(parsed.Start))
+	assert.Equal(hop.Duration, parsed.Duration)
+	assert.Equal(hop.Status, parsed.Status)
+}
+
+func TestParseHopInvalid(t *testing.T) {
+	for _, span := range [][]string{
+		nil,
+		{"parent", "name", "1", "2"},
+		{"parent", "name", "x", "2", "200"},
+		{"parent", "name", "1", "x", "200"},
+		{"parent", "name", "1", "2", "x"},
+	} {
+		t.Logf("%#v", span)
+		_, err := ParseHop(span)
+		assert.Equal(t, ErrorInvalidSpan, err)
+	}
+}
+
+func TestHopRecorderRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilRecorder *HopRecorder
+	assert.False(nilRecorder.Records(new(Message)))
+	assert.False(nilRecorder.Record(new(Message).SetIncludeSpans(true), time.Now(), HopStatusOK))
+
+	recorder := new(HopRecorder)
+	assert.False(recorder.Records(nil))
+	assert.False(recorder.Records(new(Message)))
+	assert.False(recorder.Records(new(Message).SetIncludeSpans(false)))
+	assert.True(recorder.Records(new(Message).SetIncludeSpans(true)))
+
+	recorder.Always = true
+	assert.True(recorder.Records(new(Message)))
+}
+
+func TestHopRecorderRecord(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		start    = time.Unix(1500000000, 0)
+		recorder = &HopRecorder{
+			Name: "talaria-1.device",
+			Now:  func() time.Time { return start.Add(time.Second) },
+		}
+
+		original = new(Message).SetIncludeSpans(true)
+	)
+
+	// the first hop has no parent
+	assert.True(recorder.Record(original, start, HopStatusOK))
+	if assert.Len(original.Spans, 1) {
+		hop, err := ParseHop(original.Spans[0])
+		assert.NoError(err)
+		assert.Equal("", hop.Parent)
+		assert.Equal("talaria-1.device", hop.Name)
+		assert.True(start.Equal(hop.Start))
+		assert.Equal(time.Second, hop.Duration)
+		assert.Equal(HopStatusOK, hop.Status)
+	}
+
+	// recording into a shallow copy leaves the original's spans alone
+	copied := *original
+	recorder.Name = "caduceus-1.webhook"
+	assert.True(recorder.Record(&copied, start, http.StatusAccepted))
+	assert.Len(original.Spans, 1)
+	if assert.Len(copied.Spans, 2) {
+		hop, err := ParseHop(copied.Spans[1])
+		assert.NoError(err)
+		assert.Equal("talaria-1.device", hop.Parent)
+		assert.Equal("caduceus-1.webhook", hop.Name)
+		assert.Equal(http.StatusAccepted, hop.Status)
+	}
+}
@@ -0,0 +1,384 @@
+package wrp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// payloadKey is the Msgpack map key of the payload field
+	payloadKey = "payload"
+
+	// maxStreamDepth is the deepest nesting of arrays and maps allowed in a streamed message
+	maxStreamDepth = 32
+)
+
+var (
+	ErrorInvalidStream      = errors.New("The stream does not contain a Msgpack WRP message")
+	ErrorStreamTooDeep      = errors.New("The streamed message is nested too deeply")
+	ErrorInvalidPayloadSize = errors.New("The payload size must be between 0 and 4294967295 bytes")
+)
+
+// byteReader adapts an io.Reader so that single bytes can be read without reading ahead,
+// which leaves any data after a message unconsumed
+type byteReader struct {
+	io.Reader
+	one [1]byte
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(br.Reader, br.one[:]); err != nil {
+		return 0, err
+	}
+
+	return br.one[0], nil
+}
+
+// streamReader is the reader used to scan a streamed message
+type streamReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func newStreamReader(input io.Reader) streamReader {
+	if sr, ok := input.(streamReader); ok {
+		return sr
+	}
+
+	return &byteReader{Reader: input}
+}
+
+// appendMapHeader appends the Msgpack header of a map with count entries
+func appendMapHeader(output []byte, count uint32) []byte {
+	switch {
+	case count < 16:
+		return append(output, 0x80|byte(count))
+	case count <= 0xffff:
+		return append(output, 0xde, byte(count>>8), byte(count))
+	default:
+		return append(output, 0xdf, byte(count>>24), byte(count>>16), byte(count>>8), byte(count))
+	}
+}
+
+// appendRawHeader appends the Msgpack header of a raw value of the given size, in the same form that
+// the Msgpack Encoders of this package use for []byte
+func appendRawHeader(output []byte, size uint32) []byte {
+	switch {
+	case size < 32:
+		return append(output, 0xa0|byte(size))
+	case size <= 0xffff:
+		return append(output, 0xda, byte(size>>8), byte(size))
+	default:
+		return append(output, 0xdb, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+	}
+}
+
+// readUint reads a big-endian unsigned integer of the given number of bytes
+func readUint(input io.Reader, size int) (uint32, error) {
+	var buffer [4]byte
+	if _, err := io.ReadFull(input, buffer[4-size:]); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint32(buffer[:]), nil
+}
+
+// readMapHeader reads the header of a Msgpack map whose first byte has already been read, returning
+// its count of entries
+func readMapHeader(first byte, input io.Reader) (uint32, error) {
+	switch {
+	case first&0xf0 == 0x80:
+		return uint32(first & 0x0f), nil
+	case first == 0xde:
+		return readUint(input, 2)
+	case first == 0xdf:
+		return readUint(input, 4)
+	default:
+		return 0, ErrorInvalidStream
+	}
+}
+
+// readRawHeader reads the header of a Msgpack str or bin value whose first byte has already been read,
+// returning its size.  A nil value has a size of zero.  The second return value is false if the header
+// is not that of a raw value.
+func readRawHeader(first byte, input io.Reader) (uint32, bool, error) {
+	var (
+		size uint32
+		err  error
+	)
+
+	switch {
+	case first&0xe0 == 0xa0:
+		size = uint32(first & 0x1f)
+	case first == 0xc0:
+		size = 0
+	case first == 0xc4 || first == 0xd9:
+		size, err = readUint(input, 1)
+	case first == 0xc5 || first == 0xda:
+		size, err = readUint(input, 2)
+	case first == 0xc6 || first == 0xdb:
+		size, err = readUint(input, 4)
+	default:
+		return 0, false, nil
+	}
+
+	return size, true, err
+}
+
+// isPayloadKey tests whether the raw Msgpack encoding of a map key is the payload field's key
+func isPayloadKey(raw []byte) bool {
+	input := bytes.NewReader(raw[1:])
+	size, ok, err := readRawHeader(raw[0], input)
+	return ok && err == nil && int(size) == input.Len() && string(raw[len(raw)-input.Len():]) == payloadKey
+}
+
+// copyN appends exactly n bytes from input to output.  The output grows only as bytes are read, so
+// a corrupt length cannot force a large allocation.
+func copyN(output []byte, input io.Reader, n uint32) ([]byte, error) {
+	buffer := bytes.NewBuffer(output)
+	_, err := io.CopyN(buffer, input, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return buffer.Bytes(), err
+}
+
+// scanValue appends the raw Msgpack encoding of the next value in input, whose first byte has already been read
+func scanValue(output []byte, first byte, input streamReader, depth int) ([]byte, error) {
+	if depth > maxStreamDepth {
+		return output, ErrorStreamTooDeep
+	}
+
+	output = append(output, first)
+
+	var (
+		// fixed is the count of bytes that follow the first byte, and lengthSize is the size of any length field
+		fixed      uint32
+		lengthSize int
+
+		// elements is the count of nested values, for arrays and maps
+		elements uint32
+		isArray  bool
+		isMap    bool
+	)
+
+	switch {
+	case first <= 0x7f, first >= 0xe0, first == 0xc0, first == 0xc2, first == 0xc3:
+	case first&0xf0 == 0x80:
+		elements, isMap = uint32(first&0x0f), true
+	case first&0xf0 == 0x90:
+		elements, isArray = uint32(first&0x0f), true
+	case first&0xe0 == 0xa0:
+		fixed = uint32(first & 0x1f)
+	case first == 0xc4, first == 0xd9:
+		lengthSize = 1
+	case first == 0xc5, first == 0xda:
+		lengthSize = 2
+	case first == 0xc6, first == 0xdb:
+		lengthSize = 4
+	case first == 0xc7:
+		lengthSize, fixed = 1, 1
+	case first == 0xc8:
+		lengthSize, fixed = 2, 1
+	case first == 0xc9:
+		lengthSize, fixed = 4, 1
+	case first == 0xca, first == 0xce, first == 0xd2:
+		fixed = 4
+	case first == 0xcb, first == 0xcf, first == 0xd3:
+		fixed = 8
+	case first == 0xcc, first == 0xd0:
+		fixed = 1
+	case first == 0xcd, first == 0xd1:
+		fixed = 2
+	case first >= 0xd4 && first <= 0xd8:
+		fixed = 1 + (1 << (first - 0xd4))
+	case first == 0xdc:
+		lengthSize, isArray = 2, true
+	case first == 0xdd:
+		lengthSize, isArray = 4, true
+	case first == 0xde:
+		lengthSize, isMap = 2, true
+	case first == 0xdf:
+		lengthSize, isMap = 4, true
+	default:
+		return output, ErrorInvalidStream
+	}
+
+	var err error
+	if lengthSize > 0 {
+		var length uint32
+		if length, err = readUint(input, lengthSize); err != nil {
+			return output, err
+		}
+
+		var buffer [4]byte
+		binary.BigEndian.PutUint32(buffer[:], length)
+		output = append(output, buffer[4-lengthSize:]...)
+
+		if isArray || isMap {
+			elements = length
+		} else {
+			fixed += length
+		}
+	}
+
+	if output, err = copyN(output, input, fixed); err != nil {
+		return output, err
+	}
+
+	if isMap {
+		elements *= 2
+	}
+
+	for ; elements > 0; elements-- {
+		next, err := input.ReadByte()
+		if err != nil {
+			return output, err
+		}
+
+		if output, err = scanValue(output, next, input, depth+1); err != nil {
+			return output, err
+		}
+	}
+
+	return output, nil
+}
+
+// EncodeStream writes a message to output in Msgpack, with a payload of exactly size bytes copied from the
+// payload reader rather than taken from message.Payload.  The payload is never held in memory in its
+// entirety, which allows large payloads such as firmware images to be sent with little allocation.
+//
+// The payload is the last field written, so the output can be read back with DecodeStream.  The output is
+// otherwise the same as that of a Msgpack Encoder created by this package.  Neither compression nor any
+// other payload encoding is applied.
+func EncodeStream(output io.Writer, message *Message, payload io.Reader, size int64) error {
+	if size < 0 || size > 0xffffffff {
+		return ErrorInvalidPayloadSize
+	}
+
+	copied := *message
+	copied.Payload = nil
+
+	var encoded []byte
+	if err := NewEncoderBytes(&encoded, Msgpack).Encode(&copied); err != nil {
+		return err
+	}
+
+	if size == 0 {
+		_, err := output.Write(encoded)
+		return err
+	}
+
+	input := bytes.NewReader(encoded[1:])
+	count, err := readMapHeader(encoded[0], input)
+	if err != nil {
+		return err
+	}
+
+	header := appendMapHeader(make([]byte, 0, len(encoded)+16), count+1)
+	header = append(header, encoded[len(encoded)-input.Len():]...)
+	header = appendRawHeader(header, uint32(len(payloadKey)))
+	header = append(header, payloadKey...)
+	header = appendRawHeader(header, uint32(size))
+
+	if _, err := output.Write(header); err != nil {
+		return err
+	}
+
+	written, err := io.CopyN(output, payload, size)
+	if err == io.EOF && written < size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// payloadReader limits reads to a streamed payload, reporting a truncated payload as io.ErrUnexpectedEOF
+type payloadReader struct {
+	io.LimitedReader
+}
+
+func (pr *payloadReader) Read(b []byte) (int, error) {
+	n, err := pr.LimitedReader.Read(b)
+	if err == io.EOF && pr.N > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// DecodeStream reads a Msgpack message from input without reading its payload into memory.  The returned
+// message has a nil Payload, and the payload is instead read from the returned io.Reader.  The payload must
+// be read to its end before any further data is read from input.
+//
+// Payloads are only streamed when they are the last field of a message, as with EncodeStream.  Otherwise,
+// the payload is buffered and decoded just like a Msgpack Decoder created by this package would, including any
+// decompression.  A payload that is streamed is never decompressed, and its metadata still declares any
+// PayloadEncodingKey.
+func DecodeStream(input io.Reader) (*Message, io.Reader, error) {
+	var (
+		reader = newStreamReader(input)
+		fields []byte
+		kept   uint32
+		size   uint32
+		stream bool
+	)
+
+	first, err := reader.ReadByte()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count, err := readMapHeader(first, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		start := len(fields)
+		if first, err = reader.ReadByte(); err != nil {
+			return nil, nil, err
+		}
+
+		if fields, err = scanValue(fields, first, reader, 0); err != nil {
+			return nil, nil, err
+		}
+
+		isPayload := isPayloadKey(fields[start:])
+		if first, err = reader.ReadByte(); err != nil {
+			return nil, nil, err
+		}
+
+		if isPayload && i == count-1 {
+			var ok bool
+			if size, ok, err = readRawHeader(first, reader); err != nil {
+				return nil, nil, err
+			} else if ok {
+				fields, stream = fields[:start], true
+				break
+			}
+		}
+
+		if fields, err = scanValue(fields, first, reader, 0); err != nil {
+			return nil, nil, err
+		}
+
+		kept++
+	}
+
+	message := new(Message)
+	if err := NewDecoderBytes(append(appendMapHeader(nil, kept), fields...), Msgpack).Decode(message); err != nil {
+		return nil, nil, err
+	}
+
+	if stream {
+		return message, &payloadReader{io.LimitedReader{R: reader, N: int64(size)}}, nil
+	}
+
+	payload := message.Payload
+	message.Payload = nil
+	return message, bytes.NewReader(payload), nil
+}
@@ -0,0 +1,292 @@
+package wrp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+// onlyReader hides any other methods of an io.Reader, such as io.ByteReader
+type onlyReader struct {
+	io.Reader
+}
+
+// errorReader is an io.Reader that always fails
+type errorReader struct {
+	err error
+}
+
+func (er errorReader) Read([]byte) (int, error) {
+	return 0, er.err
+}
+
+func testStreamMessage() *Message {
+	return (&Message{
+		Type:            SimpleRequestResponseMessageType,
+		Source:          "dns:firmware.example.com",
+		Destination:     "mac:112233445566/firmware",
+		TransactionUUID: "download",
+		ContentType:     "application/octet-stream",
+		Headers:         []string{"X-Firmware-Version: 1.2.3"},
+		Metadata:        map[string]string{"image": "primary"},
+		Spans:           [][]string{{"", "talaria-1", "1", "2", "200"}},
+	}).SetStatus(200).SetIncludeSpans(true)
+}
+
+func testEncodeStream(t *testing.T, size int) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		message = testStreamMessage()
+		payload = bytes.Repeat([]byte{0xAB}, size)
+		output  bytes.Buffer
+	)
+
+	// the message's own payload is ignored
+	message.Payload = []byte("ignored")
+	require.NoError(EncodeStream(&output, message, bytes.NewReader(payload), int64(size)))
+	assert.Equal([]byte("ignored"), message.Payload)
+
+	expected := *message
+	expected.Payload = payload
+	if size == 0 {
+		expected.Payload = nil
+	}
+
+	decoded := new(Message)
+	require.NoError(NewDecoderBytes(output.Bytes(), Msgpack).Decode(decoded))
+	assert.Equal(expected, *decoded)
+
+	streamed, reader, err := DecodeStream(&output)
+	require.NoError(err)
+	require.NotNil(streamed)
+	require.NotNil(reader)
+	assert.Nil(streamed.Payload)
+
+	actual, err := ioutil.ReadAll(reader)
+	require.NoError(err)
+	assert.Equal(payload, actual)
+
+	expected.Payload = nil
+	assert.Equal(expected, *streamed)
+	assert.Zero(output.Len())
+}
+
+func TestEncodeStream(t *testing.T) {
+	for _, size := range []int{0, 1, 31, 32, 65535, 65536, 3 * 1024 * 1024} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			testEncodeStream(t, size)
+		})
+	}
+}
+
+func TestEncodeStreamErrors(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = testStreamMessage()
+		output  bytes.Buffer
+	)
+
+	assert.Equal(ErrorInvalidPayloadSize, EncodeStream(&output, message, nil, -1))
+	assert.Equal(ErrorInvalidPayloadSize, EncodeStream(&output, message, nil, 0x100000000))
+	assert.Zero(output.Len())
+
+	assert.Equal(io.ErrUnexpectedEOF, EncodeStream(&output, message, bytes.NewReader([]byte("short")), 100))
+
+	expectedError := errors.New("expected")
+	assert.Equal(expectedError, EncodeStream(&output, message, errorReader{expectedError}, 100))
+}
+
+func TestDecodeStreamBuffered(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		// any field after the payload means that the payload cannot be streamed
+		message = &Message{
+			Type:             SimpleEventMessageType,
+			Source:           "mac:112233445566",
+			Destination:      "event:test",
+			Payload:          compressiblePayload,
+			ServiceName:      "test",
+			QualityOfService: 75,
+		}
+
+		output bytes.Buffer
+	)
+
+	require.NoError(NewCompressingEncoder(NewEncoder(&output, Msgpack), 0).Encode(message))
+
+	decoded, reader, err := DecodeStream(&output)
+	require.NoError(err)
+	require.NotNil(decoded)
+
+	// buffered payloads are decompressed, just as with a Decoder
+	payload, err := ioutil.ReadAll(reader)
+	require.NoError(err)
+	assert.Equal(compressiblePayload, payload)
+
+	expected := *message
+	expected.Payload = nil
+	assert.Equal(expected, *decoded)
+}
+
+func TestDecodeStreamSequence(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		output  bytes.Buffer
+	)
+
+	require.NoError(EncodeStream(&output, &Message{Type: SimpleEventMessageType, Destination: "event:first"}, bytes.NewReader([]byte("first payload")), 13))
+	require.NoError(EncodeStream(&output, &Message{Type: SimpleEventMessageType, Destination: "event:second"}, bytes.NewReader([]byte("second payload")), 14))
+
+	// without io.ByteReader, nothing past the first message may be consumed
+	input := onlyReader{&output}
+	for _, expected := range []struct {
+		destination string
+		payload     string
+	}{
+		{"event:first", "first payload"},
+		{"event:second", "second payload"},
+	} {
+		message, reader, err := DecodeStream(input)
+		require.NoError(err)
+		assert.Equal(expected.destination, message.Destination)
+
+		payload, err := ioutil.ReadAll(reader)
+		require.NoError(err)
+		assert.Equal(expected.payload, string(payload))
+	}
+
+	message, reader, err := DecodeStream(input)
+	assert.Nil(message)
+	assert.Nil(reader)
+	assert.Equal(io.EOF, err)
+}
+
+func TestDecodeStreamTruncated(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		output  bytes.Buffer
+	)
+
+	require.NoError(EncodeStream(&output, &Message{Type: SimpleEventMessageType}, bytes.NewReader(compressiblePayload), int64(len(compressiblePayload))))
+	encoded := output.Bytes()
+
+	message, reader, err := DecodeStream(bytes.NewReader(encoded[:len(encoded)-10]))
+	require.NoError(err)
+	require.NotNil(message)
+
+	_, err = ioutil.ReadAll(reader)
+	assert.Equal(io.ErrUnexpectedEOF, err)
+
+	// truncated within the fields
+	message, reader, err = DecodeStream(bytes.NewReader(encoded[:5]))
+	assert.Nil(message)
+	assert.Nil(reader)
+	assert.Equal(io.ErrUnexpectedEOF, err)
+}
+
+func TestDecodeStreamInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	deep := []byte{0x81, 0xa1, 'x'}
+	for i := 0; i <= maxStreamDepth+1; i++ {
+		deep = append(deep, 0x91)
+	}
+
+	deep = append(deep, 0x01)
+
+	for _, testCase := range []struct {
+		input    []byte
+		expected error
+	}{
+		{[]byte{0x91, 0x01}, ErrorInvalidStream},
+		{[]byte{0x81, 0xa1, 'x', 0xc1}, ErrorInvalidStream},
+		{deep, ErrorStreamTooDeep},
+	} {
+		message, reader, err := DecodeStream(bytes.NewReader(testCase.input))
+		assert.Nil(message)
+		assert.Nil(reader)
+		assert.Equal(testCase.expected, err)
+	}
+}
+
+func TestScanValue(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		values = []interface{}{
+			nil, true, false,
+			int8(-5), int8(-100), int16(-1000), int32(-100000), int64(-10000000000),
+			uint8(5), uint8(200), uint16(1000), uint32(100000), uint64(10000000000),
+			float32(1.5), float64(2.5),
+			"", "short", string(bytes.Repeat([]byte("x"), 100)), string(bytes.Repeat([]byte("x"), 70000)),
+			[]byte{}, []byte("bytes"),
+			[]interface{}{}, []interface{}{1, "two"}, make([]interface{}, 20), make([]interface{}, 70000),
+			map[string]interface{}{"a": 1}, map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7, "h": 8, "i": 9, "j": 10, "k": 11, "l": 12, "m": 13, "n": 14, "o": 15, "p": 16},
+		}
+	)
+
+	for _, value := range values {
+		var encoded []byte
+		require.NoError(NewEncoderBytes(&encoded, Msgpack).Encode(value))
+
+		input := bytes.NewReader(encoded[1:])
+		scanned, err := scanValue(nil, encoded[0], input, 0)
+		assert.NoError(err)
+		assert.Equal(encoded, scanned)
+		assert.Zero(input.Len())
+	}
+
+	// msgpack types that the Encoders of this package never produce
+	for _, encoded := range [][]byte{
+		{0xc4, 0x02, 0x01, 0x02},
+		{0xc5, 0x00, 0x01, 0x01},
+		{0xc6, 0x00, 0x00, 0x00, 0x01, 0x01},
+		{0xd9, 0x01, 'x'},
+		{0xc7, 0x01, 0x05, 0x01},
+		{0xc8, 0x00, 0x01, 0x05, 0x01},
+		{0xc9, 0x00, 0x00, 0x00, 0x01, 0x05, 0x01},
+		{0xd4, 0x05, 0x01},
+		{0xd8, 0x05, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		{0xdf, 0x00, 0x00, 0x00, 0x01, 0x01, 0x02},
+		{0xdd, 0x00, 0x00, 0x00, 0x01, 0x01},
+	} {
+		input := bytes.NewReader(encoded[1:])
+		scanned, err := scanValue(nil, encoded[0], input, 0)
+		assert.NoError(err)
+		assert.Equal(encoded, scanned)
+		assert.Zero(input.Len())
+	}
+}
+
+func TestDecodeStreamBinPayload(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	// other msgpack implementations write payloads as bin, and their keys as str8
+	encoded := []byte{
+		0x82,
+		0xa8, 'm', 's', 'g', '_', 't', 'y', 'p', 'e', 0x04,
+		0xd9, 0x07, 'p', 'a', 'y', 'l', 'o', 'a', 'd', 0xc4, 0x03, 0x01, 0x02, 0x03,
+	}
+
+	message, reader, err := DecodeStream(bytes.NewReader(encoded))
+	require.NoError(err)
+	assert.Equal(SimpleEventMessageType, message.Type)
+
+	payload, err := ioutil.ReadAll(reader)
+	require.NoError(err)
+	assert.Equal([]byte{0x01, 0x02, 0x03}, payload)
+}
@@ -0,0 +1,298 @@
+package wrp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Comcast/webpa-common/httperror"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AlternateMsgpackContentType is the unofficial MIME type for Msgpack that some clients send.  It is
+	// accepted in requests, but ContentType is always used in responses.
+	AlternateMsgpackContentType = "application/x-msgpack"
+)
+
+var (
+	ErrorUnsupportedContentType = errors.New("The content type is not a supported WRP format")
+	ErrorNotAcceptable          = errors.New("None of the acceptable media types is a supported WRP format")
+)
+
+// FormatForContentType returns the Format identified by a Content-Type value.  Media type parameters, such as
+// charset, are ignored.  ErrorUnsupportedContentType is returned if the media type is not a WRP format.
+func FormatForContentType(contentType string) (Format, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Msgpack, ErrorUnsupportedContentType
+	}
+
+	switch mediaType {
+	case Msgpack.ContentType(), AlternateMsgpackContentType:
+		return Msgpack, nil
+	case JSON.ContentType():
+		return JSON, nil
+	default:
+		return Msgpack, ErrorUnsupportedContentType
+	}
+}
+
+// NegotiateFormat selects the Format of a response from the value of an Accept header.  The supported format
+// with the highest quality is chosen, and the preferred format wins any tie.  As with HTTP, the quality of a
+// format is taken from the most specific media range that includes it, so "application/json;q=0, */*" excludes JSON.  The preferred format is also
+// returned when the Accept value is empty.  If the Accept value does not allow any WRP format, this function
+// returns ErrorNotAcceptable.
+func NegotiateFormat(accept string, preferred Format) (Format, error) {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return preferred, nil
+	}
+
+	// qualities and specificities are indexed by Format.  A format's quality comes from the most specific media
+	// range that matches it, and a negative specificity means that no media range has matched it.
+	var (
+		qualities     = [...]float64{Msgpack: 0, JSON: 0}
+		specificities = [...]int{Msgpack: -1, JSON: -1}
+	)
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, parameters, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := parameters["q"]; ok {
+			if quality, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		for format := range qualities {
+			specificity := mediaRangeSpecificity(mediaType, Format(format))
+			if specificity > specificities[format] || (specificity >= 0 && specificity == specificities[format] && quality > qualities[format]) {
+				qualities[format], specificities[format] = quality, specificity
+			}
+		}
+	}
+
+	best, bestQuality := preferred, qualities[preferred]
+	for format, quality := range qualities {
+		if quality > bestQuality {
+			best, bestQuality = Format(format), quality
+		}
+	}
+
+	if bestQuality <= 0 {
+		return preferred, ErrorNotAcceptable
+	}
+
+	return best, nil
+}
+
+// mediaRangeSpecificity tests if a media range from an Accept header, such as application/*, includes a format.
+// A negative value is returned if the media range does not include the format.  Otherwise, more specific media
+// ranges produce larger values.
+func mediaRangeSpecificity(mediaRange string, f Format) int {
+	switch {
+	case mediaRange == "*/*":
+		return 0
+	case mediaRange == "application/*":
+		return 1
+	case mediaRange == f.ContentType(), mediaRange == AlternateMsgpackContentType && f == Msgpack:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// messageKey is the context key for the message decoded by a Transcoder
+type messageKey struct{}
+
+// SetMessage returns a new context carrying the given WRP message
+func SetMessage(ctx context.Context, message *Message) context.Context {
+	return context.WithValue(ctx, messageKey{}, message)
+}
+
+// MessageFromContext returns the WRP message associated with the context, such as the request message
+// decoded by a Transcoder
+func MessageFromContext(ctx context.Context) (*Message, bool) {
+	message, ok := ctx.Value(messageKey{}).(*Message)
+	return message, ok
+}
+
+// Transcoder is an Alice-style decorator that lets clients exchange WRP messages with a handler in either
+// JSON or Msgpack, while the handler itself only deals with a single internal format.
+//
+// A request body is decoded according to its Content-Type, and is passed to the delegate encoded in the internal
+// format along with a matching Content-Type.  The decoded message is also available to the delegate via
+// MessageFromContext.  Requests without a Content-Type are assumed to be in the internal format.  Unsupported
+// content types are rejected with 415, and bodies that do not decode are rejected with 400.
+//
+// The response format is negotiated via the Accept header, preferring the format of the request.  When it differs
+// from the internal format, a successful delegate response carrying the internal format's Content-Type is buffered
+// and transcoded.  Other responses, such as JSON errors from httperror, are passed through untouched.  Requests that
+// accept no WRP format are rejected with 406.
+type Transcoder struct {
+	// Format is the internal format that the delegate reads and writes.  The zero value is Msgpack.
+	Format Format
+}
+
+func (t *Transcoder) format() Format {
+	if t != nil {
+		return t.Format
+	}
+
+	return Msgpack
+}
+
+// Then decorates the delegate with transcoding
+func (t *Transcoder) Then(delegate http.Handler) http.Handler {
+	internal := t.format()
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requestFormat := internal
+		if contentType := request.Header.Get("Content-Type"); len(contentType) > 0 {
+			var err error
+			if requestFormat, err = FormatForContentType(contentType); err != nil {
+				httperror.Formatf(response, http.StatusUnsupportedMediaType, "%s: %s", err, contentType)
+				return
+			}
+		}
+
+		responseFormat, err := NegotiateFormat(request.Header.Get("Accept"), requestFormat)
+		if err != nil {
+			httperror.Formatf(response, http.StatusNotAcceptable, "%s", err)
+			return
+		}
+
+		if request, err = transcodeRequest(request, requestFormat, internal); err != nil {
+			httperror.Formatf(response, http.StatusBadRequest, "Could not decode WRP message: %s", err)
+			return
+		}
+
+		if responseFormat == internal {
+			delegate.ServeHTTP(response, request)
+			return
+		}
+
+		buffered := &transcodingWriter{header: make(http.Header)}
+		delegate.ServeHTTP(buffered, request)
+		buffered.flush(response, internal, responseFormat)
+	})
+}
+
+// transcodeRequest decodes the body of a request, returning a copy of the request whose body is encoded in
+// the internal format and whose context carries the decoded message.  A request without a body is returned as is.
+func transcodeRequest(request *http.Request, requestFormat, internal Format) (*http.Request, error) {
+	if request.Body == nil {
+		return request, nil
+	}
+
+	contents, err := ioutil.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contents) == 0 {
+		request.Body = ioutil.NopCloser(bytes.NewReader(contents))
+		return request, nil
+	}
+
+	message := new(Message)
+	if err := NewDecoderBytes(contents, requestFormat).Decode(message); err != nil {
+		return nil, err
+	}
+
+	if requestFormat != internal {
+		var transcoded []byte
+		if err := NewEncoderBytes(&transcoded, internal).Encode(message); err != nil {
+			return nil, err
+		}
+
+		contents = transcoded
+	}
+
+	// the header is copied so that the caller's request is not modified
+	header := make(http.Header, len(request.Header))
+	for name, values := range request.Header {
+		header[name] = values
+	}
+
+	header.Set("Content-Type", internal.ContentType())
+	header.Del("Content-Length")
+
+	transcoded := request.WithContext(SetMessage(request.Context(), message))
+	transcoded.Header = header
+	transcoded.Body = ioutil.NopCloser(bytes.NewReader(contents))
+	transcoded.ContentLength = int64(len(contents))
+	return transcoded, nil
+}
+
+// transcodingWriter buffers a delegate's response so that a WRP body can be transcoded once it is complete
+type transcodingWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (tw *transcodingWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *transcodingWriter) WriteHeader(statusCode int) {
+	if tw.statusCode == 0 {
+		tw.statusCode = statusCode
+	}
+}
+
+func (tw *transcodingWriter) Write(data []byte) (int, error) {
+	if tw.statusCode == 0 {
+		tw.statusCode = http.StatusOK
+	}
+
+	return tw.body.Write(data)
+}
+
+// successful tests if the delegate's response has a 2xx status.  Other responses are errors, which are
+// not WRP messages even when their Content-Type matches the internal format.
+func (tw *transcodingWriter) successful() bool {
+	return tw.statusCode == 0 || (tw.statusCode >= 200 && tw.statusCode < 300)
+}
+
+// flush writes the buffered response, transcoding it from the internal format into the response format
+// if it is a WRP message.  A body that cannot be transcoded is written as the delegate produced it.
+func (tw *transcodingWriter) flush(response http.ResponseWriter, internal, responseFormat Format) {
+	body := tw.body.Bytes()
+	if format, err := FormatForContentType(tw.header.Get("Content-Type")); err == nil && format == internal && len(body) > 0 && tw.successful() {
+		var (
+			message     = new(Message)
+			transcoded  []byte
+			transcoding = NewDecoderBytes(body, internal).Decode(message)
+		)
+
+		if transcoding == nil {
+			transcoding = NewEncoderBytes(&transcoded, responseFormat).Encode(message)
+		}
+
+		if transcoding == nil {
+			body = transcoded
+			tw.header.Set("Content-Type", responseFormat.ContentType())
+			tw.header.Del("Content-Length")
+		}
+	}
+
+	for name, values := range tw.header {
+		response.Header()[name] = values
+	}
+
+	if tw.statusCode > 0 {
+		response.WriteHeader(tw.statusCode)
+	}
+
+	response.Write(body)
+}
@@ -0,0 +1,263 @@
+package wrp
+
+import (
+	"bytes"
+	"context"
+	"github.com/Comcast/webpa-common/httperror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFormatForContentType(t *testing.T) {
+	assert := assert.New(t)
+	for contentType, expected := range map[string]Format{
+		"application/msgpack":              Msgpack,
+		"application/x-msgpack":            Msgpack,
+		"Application/MsgPack":              Msgpack,
+		"application/json":                 JSON,
+		"application/json; charset=utf-8":  JSON,
+		"application/json ; charset=UTF-8": JSON,
+	} {
+		actual, err := FormatForContentType(contentType)
+		assert.Equal(expected, actual, "content type: %s", contentType)
+		assert.NoError(err)
+	}
+
+	for _, contentType := range []string{"", "text/plain", "application/octet-stream", "application/json;;;"} {
+		_, err := FormatForContentType(contentType)
+		assert.Equal(ErrorUnsupportedContentType, err, "content type: %s", contentType)
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	assert := assert.New(t)
+	testData := []struct {
+		accept    string
+		preferred Format
+		expected  Format
+	}{
+		{"", Msgpack, Msgpack},
+		{"  ", JSON, JSON},
+		{"*/*", JSON, JSON},
+		{"application/*", Msgpack, Msgpack},
+		{"application/json", Msgpack, JSON},
+		{"application/msgpack", JSON, Msgpack},
+		{"application/x-msgpack", JSON, Msgpack},
+		{"text/html, application/json", Msgpack, JSON},
+		{"application/json;q=0.5, application/msgpack", JSON, Msgpack},
+		{"application/json, application/msgpack", JSON, JSON},
+		{"application/json, application/msgpack", Msgpack, Msgpack},
+		{"application/json;q=0.9, */*;q=0.1", Msgpack, JSON},
+		{"application/msgpack;q=0, */*", Msgpack, JSON},
+		{"application/json;q=bad, application/msgpack;q=0.2", JSON, Msgpack},
+	}
+
+	for _, record := range testData {
+		actual, err := NegotiateFormat(record.accept, record.preferred)
+		assert.Equal(record.expected, actual, "accept: %s", record.accept)
+		assert.NoError(err, "accept: %s", record.accept)
+	}
+
+	for _, accept := range []string{"text/html", "application/json;q=0, application/msgpack;q=0", "text/*"} {
+		_, err := NegotiateFormat(accept, Msgpack)
+		assert.Equal(ErrorNotAcceptable, err, "accept: %s", accept)
+	}
+}
+
+func TestSetMessage(t *testing.T) {
+	assert := assert.New(t)
+	message, ok := MessageFromContext(context.Background())
+	assert.Nil(message)
+	assert.False(ok)
+
+	expected := &Message{Type: SimpleEventMessageType}
+	message, ok = MessageFromContext(SetMessage(context.Background(), expected))
+	assert.True(expected == message)
+	assert.True(ok)
+}
+
+// echoHandler verifies that a request arrives in the internal format, then echoes the request's
+// message back with an updated payload
+func echoHandler(t *testing.T, internal Format) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		assert := assert.New(t)
+		assert.Equal(internal.ContentType(), request.Header.Get("Content-Type"))
+
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		assert.Equal(int64(len(body)), request.ContentLength)
+
+		decoded := new(Message)
+		require.NoError(t, NewDecoderBytes(body, internal).Decode(decoded))
+
+		fromContext, ok := MessageFromContext(request.Context())
+		require.True(t, ok)
+		assert.Equal(decoded, fromContext)
+
+		if decoded.Type == SimpleRequestResponseMessageType {
+			httperror.Formatf(response, http.StatusNotFound, "No such device: %s", decoded.Destination)
+			return
+		}
+
+		decoded.Payload = []byte("echo")
+		response.Header().Set("Content-Type", internal.ContentType())
+		response.WriteHeader(http.StatusCreated)
+		NewEncoder(response, internal).Encode(decoded)
+	})
+}
+
+func encodeTestMessage(t *testing.T, message *Message, f Format) []byte {
+	var body []byte
+	require.NoError(t, NewEncoderBytes(&body, f).Encode(message))
+	return body
+}
+
+func testTranscoder(t *testing.T, internal Format) {
+	var (
+		handler = (&Transcoder{Format: internal}).Then(echoHandler(t, internal))
+		message = &Message{Type: SimpleEventMessageType, Source: "test", Destination: "mac:112233445566", Payload: []byte("hello")}
+		echoed  = &Message{Type: SimpleEventMessageType, Source: "test", Destination: "mac:112233445566", Payload: []byte("echo")}
+	)
+
+	for _, record := range []struct {
+		contentType    string
+		accept         string
+		responseFormat Format
+	}{
+		{Msgpack.ContentType(), "", Msgpack},
+		{JSON.ContentType(), "", JSON},
+		{AlternateMsgpackContentType, "", Msgpack},
+		{"", "", internal},
+		{JSON.ContentType() + "; charset=utf-8", Msgpack.ContentType(), Msgpack},
+		{Msgpack.ContentType(), JSON.ContentType(), JSON},
+		{JSON.ContentType(), "*/*", JSON},
+	} {
+		t.Run(record.contentType+"/"+record.accept, func(t *testing.T) {
+			var (
+				assert        = assert.New(t)
+				requestFormat = internal
+			)
+
+			if len(record.contentType) > 0 {
+				requestFormat, _ = FormatForContentType(record.contentType)
+			}
+
+			request := httptest.NewRequest("POST", "/api/v2/device", bytes.NewReader(encodeTestMessage(t, message, requestFormat)))
+			if len(record.contentType) > 0 {
+				request.Header.Set("Content-Type", record.contentType)
+			}
+
+			if len(record.accept) > 0 {
+				request.Header.Set("Accept", record.accept)
+			}
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			assert.Equal(http.StatusCreated, response.Code)
+			assert.Equal(record.responseFormat.ContentType(), response.HeaderMap.Get("Content-Type"))
+
+			actual := new(Message)
+			assert.NoError(NewDecoderBytes(response.Body.Bytes(), record.responseFormat).Decode(actual))
+			assert.Equal(echoed, actual)
+
+			// the caller's request is left alone
+			assert.Equal(record.contentType, request.Header.Get("Content-Type"))
+		})
+	}
+
+	t.Run("ErrorResponse", func(t *testing.T) {
+		var (
+			assert   = assert.New(t)
+			request  = httptest.NewRequest("POST", "/api/v2/device", bytes.NewReader(encodeTestMessage(t, &Message{Type: SimpleRequestResponseMessageType, Destination: "mac:112233445566"}, Msgpack)))
+			response = httptest.NewRecorder()
+		)
+
+		// errors are never transcoded, even when they look like the internal format
+		request.Header.Set("Content-Type", Msgpack.ContentType())
+		request.Header.Set("Accept", JSON.ContentType()+","+Msgpack.ContentType()+";q=0.1")
+		if internal == JSON {
+			request.Header.Set("Accept", Msgpack.ContentType())
+		}
+
+		handler.ServeHTTP(response, request)
+		assert.Equal(http.StatusNotFound, response.Code)
+		assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+		assert.JSONEq(`{"code": 404, "message": "No such device: mac:112233445566"}`, response.Body.String())
+	})
+}
+
+func testTranscoderRejected(t *testing.T) {
+	var (
+		handler = new(Transcoder).Then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			assert.Fail(t, "The delegate should not have been called")
+		}))
+
+		message = encodeTestMessage(t, &Message{Type: SimpleEventMessageType}, JSON)
+	)
+
+	for _, record := range []struct {
+		contentType  string
+		accept       string
+		body         []byte
+		expectedCode int
+	}{
+		{"text/plain", "", message, http.StatusUnsupportedMediaType},
+		{JSON.ContentType(), "text/html", message, http.StatusNotAcceptable},
+		{JSON.ContentType(), "", []byte("this is not JSON"), http.StatusBadRequest},
+		{Msgpack.ContentType(), "", message, http.StatusBadRequest},
+	} {
+		var (
+			assert   = assert.New(t)
+			request  = httptest.NewRequest("POST", "/api/v2/device", bytes.NewReader(record.body))
+			response = httptest.NewRecorder()
+		)
+
+		request.Header.Set("Content-Type", record.contentType)
+		if len(record.accept) > 0 {
+			request.Header.Set("Accept", record.accept)
+		}
+
+		handler.ServeHTTP(response, request)
+		assert.Equal(record.expectedCode, response.Code, "content type: %s, accept: %s", record.contentType, record.accept)
+		assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+	}
+}
+
+func testTranscoderNoBody(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		called  bool
+		handler = (*Transcoder)(nil).Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			called = true
+			_, ok := MessageFromContext(request.Context())
+			assert.False(ok)
+
+			response.Header().Set("Content-Type", Msgpack.ContentType())
+			response.Write(encodeTestMessage(t, &Message{Type: SimpleEventMessageType, Source: "status"}, Msgpack))
+		}))
+
+		request  = httptest.NewRequest("GET", "/api/v2/status", nil)
+		response = httptest.NewRecorder()
+	)
+
+	request.Header.Set("Accept", JSON.ContentType())
+	handler.ServeHTTP(response, request)
+	assert.True(called)
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal(JSON.ContentType(), response.HeaderMap.Get("Content-Type"))
+
+	actual := new(Message)
+	assert.NoError(NewDecoderBytes(response.Body.Bytes(), JSON).Decode(actual))
+	assert.Equal(&Message{Type: SimpleEventMessageType, Source: "status"}, actual)
+}
+
+func TestTranscoder(t *testing.T) {
+	t.Run("Msgpack", func(t *testing.T) { testTranscoder(t, Msgpack) })
+	t.Run("JSON", func(t *testing.T) { testTranscoder(t, JSON) })
+	t.Run("Rejected", testTranscoderRejected)
+	t.Run("NoBody", testTranscoderNoBody)
+}
@@ -0,0 +1,142 @@
+package wrp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	ErrorInvalidMessageType    = errors.New("The message type is not a known WRP message type")
+	ErrorMissingField          = errors.New("A required field is missing")
+	ErrorPayloadTooLarge       = errors.New("The payload exceeds the maximum size")
+	ErrorDestinationNotAllowed = errors.New("The destination is not allowed")
+)
+
+// Validator checks a WRP message against a policy, returning a non-nil error if the message is
+// not acceptable.  The validators in this package return *ValidationError.
+type Validator interface {
+	Validate(*Message) error
+}
+
+// ValidatorFunc is a function type that implements Validator
+type ValidatorFunc func(*Message) error
+
+func (vf ValidatorFunc) Validate(message *Message) error {
+	return vf(message)
+}
+
+// Validators is a Validator composed of other Validators.  A message is valid only if it passes each
+// Validator, which are applied in order.  The error from the first Validator to fail is returned.
+type Validators []Validator
+
+func (vs Validators) Validate(message *Message) error {
+	for _, v := range vs {
+		if err := v.Validate(message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidationError describes a message that failed validation
+type ValidationError struct {
+	// Type is the type of the invalid message
+	Type MessageType
+
+	// Field is the name of the offending field as it appears in encoded messages, e.g. "dest"
+	Field string
+
+	// Err is the reason the message is invalid, such as ErrorMissingField
+	Err error
+}
+
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid %s message: %s: %s", ve.Type, ve.Field, ve.Err)
+}
+
+// requiredFields holds the fields that each type of message must have, keyed by the field names
+// in encoded messages
+var requiredFields = map[MessageType][]string{
+	AuthMessageType:                  {"status"},
+	SimpleRequestResponseMessageType: {"source", "dest", "transaction_uuid"},
+	SimpleEventMessageType:           {"source", "dest"},
+	CreateMessageType:                {"source", "dest", "transaction_uuid"},
+	RetrieveMessageType:              {"source", "dest", "transaction_uuid"},
+	UpdateMessageType:                {"source", "dest", "transaction_uuid"},
+	DeleteMessageType:                {"source", "dest", "transaction_uuid"},
+	ServiceRegistrationMessageType:   {"service_name", "url"},
+	ServiceAliveMessageType:          nil,
+}
+
+// hasField tests if the named field of a message is set
+func hasField(message *Message, field string) bool {
+	switch field {
+	case "status":
+		return message.Status != nil
+	case "source":
+		return len(message.Source) > 0
+	case "dest":
+		return len(message.Destination) > 0
+	case "transaction_uuid":
+		return len(message.TransactionUUID) > 0
+	case "service_name":
+		return len(message.ServiceName) > 0
+	case "url":
+		return len(message.URL) > 0
+	default:
+		return false
+	}
+}
+
+// NewRequiredFieldsValidator produces a Validator which checks that each message has a known type and
+// carries the fields the WRP specification requires for that type, e.g. a SimpleEvent must have a
+// source and a destination.
+func NewRequiredFieldsValidator() Validator {
+	return ValidatorFunc(func(message *Message) error {
+		fields, ok := requiredFields[message.Type]
+		if !ok {
+			return &ValidationError{Type: message.Type, Field: "msg_type", Err: ErrorInvalidMessageType}
+		}
+
+		for _, field := range fields {
+			if !hasField(message, field) {
+				return &ValidationError{Type: message.Type, Field: field, Err: ErrorMissingField}
+			}
+		}
+
+		return nil
+	})
+}
+
+// NewMaxPayloadValidator produces a Validator which rejects messages whose payloads are larger than
+// the given number of bytes
+func NewMaxPayloadValidator(maxPayload int) Validator {
+	return ValidatorFunc(func(message *Message) error {
+		if len(message.Payload) > maxPayload {
+			return &ValidationError{Type: message.Type, Field: "payload", Err: ErrorPayloadTooLarge}
+		}
+
+		return nil
+	})
+}
+
+// NewDestinationValidator produces a Validator which only allows destinations that match at least one
+// of the given patterns, e.g. `^(mac|uuid):` or `^event:device-status/`.  Messages without a destination
+// are left to NewRequiredFieldsValidator.  With no patterns, every destination is rejected.
+func NewDestinationValidator(allowed ...*regexp.Regexp) Validator {
+	return ValidatorFunc(func(message *Message) error {
+		if len(message.Destination) == 0 {
+			return nil
+		}
+
+		for _, pattern := range allowed {
+			if pattern.MatchString(message.Destination) {
+				return nil
+			}
+		}
+
+		return &ValidationError{Type: message.Type, Field: "dest", Err: ErrorDestinationNotAllowed}
+	})
+}
@@ -0,0 +1,131 @@
+package wrp
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestValidatorFunc(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		expected      = new(Message)
+		validator     = ValidatorFunc(func(actual *Message) error {
+			assert.True(expected == actual)
+			return expectedError
+		})
+	)
+
+	assert.Equal(expectedError, validator.Validate(expected))
+}
+
+func TestValidators(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		first   = errors.New("first")
+		second  = errors.New("second")
+		called  []string
+		succeed = func(name string) Validator {
+			return ValidatorFunc(func(*Message) error {
+				called = append(called, name)
+				return nil
+			})
+		}
+
+		fail = func(name string, err error) Validator {
+			return ValidatorFunc(func(*Message) error {
+				called = append(called, name)
+				return err
+			})
+		}
+	)
+
+	assert.NoError(Validators(nil).Validate(new(Message)))
+
+	assert.NoError(Validators{succeed("a"), succeed("b")}.Validate(new(Message)))
+	assert.Equal([]string{"a", "b"}, called)
+
+	// the first failure is returned, and later validators are not applied
+	called = nil
+	assert.Equal(first, Validators{succeed("a"), fail("b", first), fail("c", second)}.Validate(new(Message)))
+	assert.Equal([]string{"a", "b"}, called)
+}
+
+func TestValidationError(t *testing.T) {
+	assert := assert.New(t)
+	err := &ValidationError{Type: SimpleEventMessageType, Field: "dest", Err: ErrorMissingField}
+	assert.Equal("Invalid SimpleEvent message: dest: "+ErrorMissingField.Error(), err.Error())
+}
+
+func TestNewRequiredFieldsValidator(t *testing.T) {
+	var (
+		validator = NewRequiredFieldsValidator()
+		status    = int64(200)
+
+		testData = []struct {
+			message       Message
+			expectedField string
+			expectedError error
+		}{
+			{Message{Type: AuthMessageType, Status: &status}, "", nil},
+			{Message{Type: AuthMessageType}, "status", ErrorMissingField},
+			{Message{Type: SimpleEventMessageType, Source: "mac:112233445566", Destination: "event:device-status"}, "", nil},
+			{Message{Type: SimpleEventMessageType, Destination: "event:device-status"}, "source", ErrorMissingField},
+			{Message{Type: SimpleEventMessageType, Source: "mac:112233445566"}, "dest", ErrorMissingField},
+			{Message{Type: SimpleRequestResponseMessageType, Source: "dns:webpa.comcast.com", Destination: "mac:112233445566", TransactionUUID: "1234"}, "", nil},
+			{Message{Type: SimpleRequestResponseMessageType, Source: "dns:webpa.comcast.com", Destination: "mac:112233445566"}, "transaction_uuid", ErrorMissingField},
+			{Message{Type: RetrieveMessageType, Source: "dns:webpa.comcast.com", Destination: "mac:112233445566", TransactionUUID: "1234"}, "", nil},
+			{Message{Type: DeleteMessageType, Source: "dns:webpa.comcast.com", TransactionUUID: "1234"}, "dest", ErrorMissingField},
+			{Message{Type: ServiceRegistrationMessageType, ServiceName: "config", URL: "tcp://127.0.0.1:6666"}, "", nil},
+			{Message{Type: ServiceRegistrationMessageType, ServiceName: "config"}, "url", ErrorMissingField},
+			{Message{Type: ServiceAliveMessageType}, "", nil},
+			{Message{Type: MessageType(-1)}, "msg_type", ErrorInvalidMessageType},
+			{Message{}, "msg_type", ErrorInvalidMessageType},
+		}
+	)
+
+	for _, record := range testData {
+		t.Run(record.message.Type.String(), func(t *testing.T) {
+			err := validator.Validate(&record.message)
+			if record.expectedError == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Equal(t, &ValidationError{Type: record.message.Type, Field: record.expectedField, Err: record.expectedError}, err)
+		})
+	}
+}
+
+func TestNewMaxPayloadValidator(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		validator = NewMaxPayloadValidator(5)
+	)
+
+	assert.NoError(validator.Validate(&Message{Type: SimpleEventMessageType}))
+	assert.NoError(validator.Validate(&Message{Type: SimpleEventMessageType, Payload: []byte("12345")}))
+	assert.Equal(
+		&ValidationError{Type: SimpleEventMessageType, Field: "payload", Err: ErrorPayloadTooLarge},
+		validator.Validate(&Message{Type: SimpleEventMessageType, Payload: []byte("123456")}),
+	)
+}
+
+func TestNewDestinationValidator(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		validator = NewDestinationValidator(regexp.MustCompile(`^mac:`), regexp.MustCompile(`^event:device-status/`))
+	)
+
+	assert.NoError(validator.Validate(&Message{Type: SimpleEventMessageType}))
+	assert.NoError(validator.Validate(&Message{Type: SimpleRequestResponseMessageType, Destination: "mac:112233445566/config"}))
+	assert.NoError(validator.Validate(&Message{Type: SimpleEventMessageType, Destination: "event:device-status/mac:112233445566/online"}))
+	assert.Equal(
+		&ValidationError{Type: SimpleEventMessageType, Field: "dest", Err: ErrorDestinationNotAllowed},
+		validator.Validate(&Message{Type: SimpleEventMessageType, Destination: "event:iot"}),
+	)
+
+	assert.Error(NewDestinationValidator().Validate(&Message{Type: SimpleEventMessageType, Destination: "mac:112233445566"}))
+}
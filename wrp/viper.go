@@ -13,6 +13,10 @@ const (
 type PoolFactory struct {
 	DecoderPoolSize int
 	EncoderPoolSize int
+
+	// CompressionThreshold is the payload size, in bytes, at or above which pooled encoders
+	// gzip payloads.  If not positive, payloads are not compressed.
+	CompressionThreshold int
 }
 
 func NewPoolFactory(v *viper.Viper) (pf *PoolFactory, err error) {
@@ -25,7 +29,7 @@ func NewPoolFactory(v *viper.Viper) (pf *PoolFactory, err error) {
 }
 
 func (pf *PoolFactory) NewEncoderPool(f Format) *EncoderPool {
-	return NewEncoderPool(pf.EncoderPoolSize, f)
+	return NewCompressingEncoderPool(pf.EncoderPoolSize, f, pf.CompressionThreshold)
 }
 
 func (pf *PoolFactory) NewDecoderPool(f Format) *DecoderPool {
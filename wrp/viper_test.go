@@ -34,13 +34,15 @@ func TestNewPoolFactory(t *testing.T) {
 		require.NoError(v.ReadConfig(strings.NewReader(`{
 			"wrp": {
 				"decoderPoolSize": 131,
-				"encoderPoolSize": 67
+				"encoderPoolSize": 67,
+				"compressionThreshold": 512
 			}
 		}`)))
 
 		factory, err := NewPoolFactory(v.Sub(ViperKey))
 		require.NotNil(factory)
 		require.NoError(err)
+		assert.Equal(512, factory.CompressionThreshold)
 
 		for _, format := range []Format{JSON, Msgpack} {
 			t.Run(format.String(), func(t *testing.T) {
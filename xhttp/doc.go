@@ -0,0 +1,6 @@
+/*
+Package xhttp provides reusable net/http middleware common to WebPA servers, such as
+request instrumentation.  Middleware in this package follows the Alice-style constructor
+convention, decorating a delegate http.Handler.
+*/
+package xhttp
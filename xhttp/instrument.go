@@ -0,0 +1,95 @@
+package xhttp
+
+import (
+	"github.com/Comcast/webpa-common/xmetrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// RequestCount is the name of the counter of HTTP requests, labeled by route and status class
+	RequestCount = "http_requests_total"
+
+	// RequestDuration is the name of the histogram of request durations in seconds, labeled by route and status class
+	RequestDuration = "http_request_duration_seconds"
+
+	// ResponseSize is the name of the histogram of response body sizes in bytes, labeled by route and status class
+	ResponseSize = "http_response_size_bytes"
+
+	// InFlight is the name of the gauge of requests currently being served, labeled by route
+	InFlight = "http_requests_in_flight"
+
+	// RouteLabel is the label carrying the route name
+	RouteLabel = "route"
+
+	// StatusClassLabel is the label carrying the status class of a response, e.g. "2xx"
+	StatusClassLabel = "class"
+)
+
+// StatusClass returns the class of an HTTP status code, such as "2xx" or "5xx".  Codes outside
+// of the range 100-599 are reported as "unknown".
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// Instrumenter records a consistent set of HTTP metrics for decorated handlers.  A single
+// Instrumenter is intended to be shared by all routes in a server, with each route
+// supplying its own name.
+type Instrumenter struct {
+	requestCount    xmetrics.Counter
+	requestDuration xmetrics.Histogram
+	responseSize    xmetrics.Histogram
+	inFlight        xmetrics.Gauge
+	now             func() time.Time
+}
+
+// NewInstrumenter creates the HTTP metrics from the given provider.  If provider is nil,
+// a discard provider is used.
+func NewInstrumenter(provider xmetrics.Provider) *Instrumenter {
+	if provider == nil {
+		provider = xmetrics.NewDiscardProvider()
+	}
+
+	return &Instrumenter{
+		requestCount:    provider.NewCounter(RequestCount, RouteLabel, StatusClassLabel),
+		requestDuration: provider.NewHistogram(RequestDuration, RouteLabel, StatusClassLabel),
+		responseSize:    provider.NewHistogram(ResponseSize, RouteLabel, StatusClassLabel),
+		inFlight:        provider.NewGauge(InFlight, RouteLabel),
+		now:             time.Now,
+	}
+}
+
+// Then decorates the delegate so that every request served is recorded under the given route.
+func (i *Instrumenter) Then(route string, delegate http.Handler) http.Handler {
+	inFlight := i.inFlight.With(route)
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		var (
+			start   = i.now()
+			wrapped = WrapResponseWriter(response)
+		)
+
+		inFlight.Add(1.0)
+		defer func() {
+			inFlight.Add(-1.0)
+
+			statusCode := wrapped.StatusCode()
+			if statusCode == 0 {
+				// the delegate wrote nothing, so net/http will send a 200
+				statusCode = http.StatusOK
+			}
+
+			class := StatusClass(statusCode)
+			i.requestCount.With(route, class).Add(1.0)
+			i.requestDuration.With(route, class).Observe(i.now().Sub(start).Seconds())
+			i.responseSize.With(route, class).Observe(float64(wrapped.Written()))
+		}()
+
+		delegate.ServeHTTP(wrapped, request)
+	})
+}
@@ -0,0 +1,91 @@
+package xhttp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusClass(t *testing.T) {
+	assert := assert.New(t)
+	var testData = []struct {
+		statusCode int
+		expected   string
+	}{
+		{0, "unknown"},
+		{99, "unknown"},
+		{100, "1xx"},
+		{200, "2xx"},
+		{202, "2xx"},
+		{301, "3xx"},
+		{404, "4xx"},
+		{500, "5xx"},
+		{599, "5xx"},
+		{600, "unknown"},
+	}
+
+	for _, record := range testData {
+		assert.Equal(record.expected, StatusClass(record.statusCode), "status code %d", record.statusCode)
+	}
+}
+
+func TestInstrumenter(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		provider = newTestProvider()
+		current  = time.Now()
+
+		instrumenter = NewInstrumenter(provider)
+	)
+
+	instrumenter.now = func() time.Time {
+		result := current
+		current = current.Add(time.Second)
+		return result
+	}
+
+	var (
+		inFlightDuringRequest float64
+		handler               = instrumenter.Then("test", http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			inFlightDuringRequest = provider.value(InFlight, "test")
+			response.WriteHeader(http.StatusAccepted)
+			response.Write([]byte("hello"))
+		}))
+	)
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusAccepted, response.Code)
+	assert.Equal("hello", response.Body.String())
+
+	assert.Equal(1.0, inFlightDuringRequest)
+	assert.Equal(0.0, provider.value(InFlight, "test"))
+	assert.Equal(1.0, provider.value(RequestCount, "test", "2xx"))
+	assert.Equal([]float64{1.0}, provider.observed(RequestDuration, "test", "2xx"))
+	assert.Equal([]float64{5.0}, provider.observed(ResponseSize, "test", "2xx"))
+}
+
+func TestInstrumenterImplicitStatus(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		provider = newTestProvider()
+		handler  = NewInstrumenter(provider).Then("implicit", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(1.0, provider.value(RequestCount, "implicit", "2xx"))
+	assert.Equal([]float64{0.0}, provider.observed(ResponseSize, "implicit", "2xx"))
+}
+
+func TestInstrumenterNilProvider(t *testing.T) {
+	assert := assert.New(t)
+	handler := NewInstrumenter(nil).Then("nil", http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusInternalServerError, response.Code)
+}
@@ -0,0 +1,107 @@
+package xhttp
+
+import (
+	"github.com/Comcast/webpa-common/xmetrics"
+	"strings"
+	"sync"
+)
+
+// testProvider is an in-memory xmetrics.Provider that records values by metric name
+// and joined label values.
+type testProvider struct {
+	lock         sync.Mutex
+	values       map[string]map[string]float64
+	observations map[string]map[string][]float64
+}
+
+func newTestProvider() *testProvider {
+	return &testProvider{
+		values:       make(map[string]map[string]float64),
+		observations: make(map[string]map[string][]float64),
+	}
+}
+
+func (p *testProvider) value(name string, labelValues ...string) float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.values[name][strings.Join(labelValues, ",")]
+}
+
+func (p *testProvider) observed(name string, labelValues ...string) []float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.observations[name][strings.Join(labelValues, ",")]
+}
+
+func (p *testProvider) add(name, labels string, delta float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.values[name] == nil {
+		p.values[name] = make(map[string]float64)
+	}
+
+	p.values[name][labels] += delta
+}
+
+func (p *testProvider) set(name, labels string, value float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.values[name] == nil {
+		p.values[name] = make(map[string]float64)
+	}
+
+	p.values[name][labels] = value
+}
+
+func (p *testProvider) observe(name, labels string, value float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.observations[name] == nil {
+		p.observations[name] = make(map[string][]float64)
+	}
+
+	p.observations[name][labels] = append(p.observations[name][labels], value)
+}
+
+func (p *testProvider) NewCounter(name string, labelNames ...string) xmetrics.Counter {
+	return testCounter{testMetric{provider: p, name: name}}
+}
+
+func (p *testProvider) NewGauge(name string, labelNames ...string) xmetrics.Gauge {
+	return testGauge{testMetric{provider: p, name: name}}
+}
+
+func (p *testProvider) NewHistogram(name string, labelNames ...string) xmetrics.Histogram {
+	return testHistogram{testMetric{provider: p, name: name}}
+}
+
+type testMetric struct {
+	provider *testProvider
+	name     string
+	labels   string
+}
+
+func (m testMetric) with(labelValues []string) testMetric {
+	return testMetric{provider: m.provider, name: m.name, labels: strings.Join(labelValues, ",")}
+}
+
+type testCounter struct{ testMetric }
+
+func (c testCounter) With(labelValues ...string) xmetrics.Counter {
+	return testCounter{c.with(labelValues)}
+}
+func (c testCounter) Add(delta float64) { c.provider.add(c.name, c.labels, delta) }
+
+type testGauge struct{ testMetric }
+
+func (g testGauge) With(labelValues ...string) xmetrics.Gauge { return testGauge{g.with(labelValues)} }
+func (g testGauge) Set(value float64)                         { g.provider.set(g.name, g.labels, value) }
+func (g testGauge) Add(delta float64)                         { g.provider.add(g.name, g.labels, delta) }
+
+type testHistogram struct{ testMetric }
+
+func (h testHistogram) With(labelValues ...string) xmetrics.Histogram {
+	return testHistogram{h.with(labelValues)}
+}
+
+func (h testHistogram) Observe(value float64) { h.provider.observe(h.name, h.labels, value) }
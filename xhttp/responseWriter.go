@@ -0,0 +1,77 @@
+package xhttp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+var (
+	ErrorHijackNotSupported = errors.New("The underlying http.ResponseWriter does not support hijacking")
+)
+
+// ResponseWriter wraps an http.ResponseWriter and keeps track of the status code and the
+// number of body bytes written.
+type ResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	written    int64
+}
+
+// WrapResponseWriter produces a *ResponseWriter that decorates the given delegate.  If the
+// delegate is already a *ResponseWriter, it is returned as is.
+func WrapResponseWriter(delegate http.ResponseWriter) *ResponseWriter {
+	if wrapped, ok := delegate.(*ResponseWriter); ok {
+		return wrapped
+	}
+
+	return &ResponseWriter{ResponseWriter: delegate}
+}
+
+// StatusCode returns the status code written to the response.  If no status code was
+// explicitly written but a body was, http.StatusOK is returned as net/http does.  If nothing
+// has been written at all, this method returns 0.
+func (rw *ResponseWriter) StatusCode() int {
+	if rw.statusCode == 0 && rw.written > 0 {
+		return http.StatusOK
+	}
+
+	return rw.statusCode
+}
+
+// Written returns the number of body bytes written to the response
+func (rw *ResponseWriter) Written() int64 {
+	return rw.written
+}
+
+func (rw *ResponseWriter) WriteHeader(statusCode int) {
+	if rw.statusCode == 0 {
+		rw.statusCode = statusCode
+	}
+
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *ResponseWriter) Write(data []byte) (int, error) {
+	count, err := rw.ResponseWriter.Write(data)
+	rw.written += int64(count)
+	return count, err
+}
+
+// Flush delegates to the underlying http.Flusher, if supported
+func (rw *ResponseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack delegates to the underlying http.Hijacker, which allows websocket upgrades
+// to pass through decorated handlers.
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+
+	return nil, nil, ErrorHijackNotSupported
+}
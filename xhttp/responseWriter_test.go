@@ -0,0 +1,41 @@
+package xhttp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseWriter(t *testing.T) {
+	assert := assert.New(t)
+	recorder := httptest.NewRecorder()
+	wrapped := WrapResponseWriter(recorder)
+	assert.True(wrapped == WrapResponseWriter(wrapped))
+
+	assert.Equal(0, wrapped.StatusCode())
+	assert.Equal(int64(0), wrapped.Written())
+
+	count, err := wrapped.Write([]byte("abc"))
+	assert.Equal(3, count)
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, wrapped.StatusCode())
+	assert.Equal(int64(3), wrapped.Written())
+
+	wrapped.Flush()
+	assert.True(recorder.Flushed)
+
+	conn, buffer, err := wrapped.Hijack()
+	assert.Nil(conn)
+	assert.Nil(buffer)
+	assert.Equal(ErrorHijackNotSupported, err)
+}
+
+func TestResponseWriterFirstStatusWins(t *testing.T) {
+	assert := assert.New(t)
+	wrapped := WrapResponseWriter(httptest.NewRecorder())
+
+	wrapped.WriteHeader(http.StatusNotFound)
+	wrapped.WriteHeader(http.StatusOK)
+	assert.Equal(http.StatusNotFound, wrapped.StatusCode())
+}
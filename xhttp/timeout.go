@@ -0,0 +1,183 @@
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"github.com/Comcast/webpa-common/httperror"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout           = 30 * time.Second
+	DefaultTimeoutStatusCode = http.StatusServiceUnavailable
+	DefaultTimeoutMessage    = "The request timed out"
+)
+
+// Timeout is an Alice-style decorator that enforces a maximum duration for a handler.  When the
+// timeout elapses, the request's context is cancelled and a JSON error body is written to the
+// client in place of whatever the delegate would have produced.  Cancellation that does not come
+// from this timeout, such as a client disconnecting, is left to the delegate to handle.  Unlike http.TimeoutHandler, both
+// the status code and body are configurable.
+//
+// A Timeout is typically created once per route, allowing routes to have different limits.
+type Timeout struct {
+	// Timeout is the maximum time the delegate has to produce a response.  If nonpositive,
+	// DefaultTimeout is used.
+	Timeout time.Duration
+
+	// StatusCode is the HTTP status code written when a request times out.  If nonpositive,
+	// DefaultTimeoutStatusCode is used.
+	StatusCode int
+
+	// Message is the text placed in the message field of the default JSON body.  If empty,
+	// DefaultTimeoutMessage is used.
+	Message string
+
+	// Body, if set, is written verbatim as the JSON response body on timeout in place of the
+	// default {"code": %d, "message": "%s"} body.
+	Body []byte
+}
+
+func (t *Timeout) timeout() time.Duration {
+	if t != nil && t.Timeout > 0 {
+		return t.Timeout
+	}
+
+	return DefaultTimeout
+}
+
+func (t *Timeout) statusCode() int {
+	if t != nil && t.StatusCode > 0 {
+		return t.StatusCode
+	}
+
+	return DefaultTimeoutStatusCode
+}
+
+func (t *Timeout) message() string {
+	if t != nil && len(t.Message) > 0 {
+		return t.Message
+	}
+
+	return DefaultTimeoutMessage
+}
+
+// writeTimeout writes the configured timeout response
+func (t *Timeout) writeTimeout(response http.ResponseWriter) {
+	code := t.statusCode()
+	if t != nil && len(t.Body) > 0 {
+		response.Header().Set("Content-Type", "application/json")
+		response.WriteHeader(code)
+		response.Write(t.Body)
+		return
+	}
+
+	httperror.Formatf(response, code, "%s", t.message())
+}
+
+// Then decorates the delegate with this timeout policy
+func (t *Timeout) Then(delegate http.Handler) http.Handler {
+	timeout := t.timeout()
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+
+		var (
+			buffered = &timeoutWriter{header: make(http.Header)}
+			done     = make(chan struct{})
+			panics   = make(chan interface{}, 1)
+		)
+
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					panics <- r
+				}
+			}()
+
+			delegate.ServeHTTP(buffered, request.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panics:
+			panic(p)
+
+		case <-done:
+			buffered.writeTo(response)
+
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded || request.Context().Err() != nil {
+				// the client went away or an enclosing deadline passed, so this is not our timeout.  The
+				// delegate sees the same cancellation, and its response is passed through as is.
+				select {
+				case p := <-panics:
+					panic(p)
+				case <-done:
+					buffered.writeTo(response)
+				}
+
+				return
+			}
+
+			buffered.lock.Lock()
+			defer buffered.lock.Unlock()
+
+			buffered.timedOut = true
+			t.writeTimeout(response)
+		}
+	})
+}
+
+// timeoutWriter buffers a delegate's response so that it can be discarded if the
+// delegate takes too long.
+type timeoutWriter struct {
+	lock       sync.Mutex
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+	timedOut   bool
+}
+
+// writeTo copies the delegate's buffered response to the actual response
+func (tw *timeoutWriter) writeTo(response http.ResponseWriter) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	for name, values := range tw.header {
+		response.Header()[name] = values
+	}
+
+	if tw.statusCode > 0 {
+		response.WriteHeader(tw.statusCode)
+	}
+
+	response.Write(tw.body.Bytes())
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if !tw.timedOut && tw.statusCode == 0 {
+		tw.statusCode = statusCode
+	}
+}
+
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	return tw.body.Write(data)
+}
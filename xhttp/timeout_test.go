@@ -0,0 +1,143 @@
+package xhttp
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, timeout := range []*Timeout{nil, new(Timeout)} {
+		assert.Equal(DefaultTimeout, timeout.timeout())
+		assert.Equal(DefaultTimeoutStatusCode, timeout.statusCode())
+		assert.Equal(DefaultTimeoutMessage, timeout.message())
+	}
+}
+
+func TestTimeoutCompleted(t *testing.T) {
+	assert := assert.New(t)
+	timeout := &Timeout{Timeout: time.Hour}
+
+	handler := timeout.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		assert.NotNil(request.Context().Done())
+		response.Header().Set("X-Test", "value")
+		response.WriteHeader(http.StatusCreated)
+		response.Write([]byte("created"))
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusCreated, response.Code)
+	assert.Equal("value", response.HeaderMap.Get("X-Test"))
+	assert.Equal("created", response.Body.String())
+}
+
+func TestTimeoutExpired(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		testData = []struct {
+			timeout      *Timeout
+			expectedCode int
+			expectedBody string
+		}{
+			{
+				&Timeout{Timeout: time.Millisecond},
+				http.StatusServiceUnavailable,
+				`{"code": 503, "message": "The request timed out"}`,
+			},
+			{
+				&Timeout{Timeout: time.Millisecond, StatusCode: http.StatusGatewayTimeout, Message: "too slow"},
+				http.StatusGatewayTimeout,
+				`{"code": 504, "message": "too slow"}`,
+			},
+			{
+				&Timeout{Timeout: time.Millisecond, Body: []byte(`{"error": "custom"}`)},
+				http.StatusServiceUnavailable,
+				`{"error": "custom"}`,
+			},
+		}
+	)
+
+	for _, record := range testData {
+		var (
+			cancelled = make(chan struct{})
+			release   = make(chan struct{})
+			writeErr  = make(chan error, 1)
+
+			handler = record.timeout.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+				<-request.Context().Done()
+				close(cancelled)
+				<-release
+
+				_, err := response.Write([]byte("too late"))
+				writeErr <- err
+			}))
+
+			response = httptest.NewRecorder()
+		)
+
+		handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+		<-cancelled
+		close(release)
+
+		assert.Equal(http.ErrHandlerTimeout, <-writeErr)
+		assert.Equal(record.expectedCode, response.Code)
+		assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+		assert.JSONEq(record.expectedBody, response.Body.String())
+	}
+}
+
+func TestTimeoutCancelled(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	for name, parent := range map[string]func() (context.Context, context.CancelFunc){
+		"Cancelled": func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		},
+		"EnclosingDeadline": func() (context.Context, context.CancelFunc) {
+			return expired, func() {}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				assert      = assert.New(t)
+				ctx, cancel = parent()
+				timeout     = &Timeout{Timeout: time.Hour}
+
+				handler = timeout.Then(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+					<-request.Context().Done()
+					response.WriteHeader(499)
+					response.Write([]byte("cancelled"))
+				}))
+
+				response = httptest.NewRecorder()
+			)
+
+			defer cancel()
+			handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+			// the delegate's own response is written, rather than the timeout response
+			assert.Equal(499, response.Code)
+			assert.Equal("cancelled", response.Body.String())
+		})
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(Timeout).Then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("expected")
+	}))
+
+	assert.Panics(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
@@ -0,0 +1,29 @@
+package xmetrics
+
+type discardCounter struct{}
+
+func (d discardCounter) With(...string) Counter { return d }
+func (d discardCounter) Add(float64)            {}
+
+type discardGauge struct{}
+
+func (d discardGauge) With(...string) Gauge { return d }
+func (d discardGauge) Set(float64)          {}
+func (d discardGauge) Add(float64)          {}
+
+type discardHistogram struct{}
+
+func (d discardHistogram) With(...string) Histogram { return d }
+func (d discardHistogram) Observe(float64)          {}
+
+type discardProvider struct{}
+
+func (discardProvider) NewCounter(string, ...string) Counter     { return discardCounter{} }
+func (discardProvider) NewGauge(string, ...string) Gauge         { return discardGauge{} }
+func (discardProvider) NewHistogram(string, ...string) Histogram { return discardHistogram{} }
+
+// NewDiscardProvider returns a Provider whose metrics silently drop all values.  This is
+// the provider components use when none is configured.
+func NewDiscardProvider() Provider {
+	return discardProvider{}
+}
@@ -0,0 +1,28 @@
+package xmetrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDiscardProvider(t *testing.T) {
+	assert := assert.New(t)
+	provider := NewDiscardProvider()
+	assert.NotNil(provider)
+
+	counter := provider.NewCounter("counter", "label")
+	assert.NotNil(counter)
+	assert.NotNil(counter.With("value"))
+	counter.With("value").Add(1.0)
+
+	gauge := provider.NewGauge("gauge", "label")
+	assert.NotNil(gauge)
+	assert.NotNil(gauge.With("value"))
+	gauge.With("value").Set(10.0)
+	gauge.With("value").Add(-1.0)
+
+	histogram := provider.NewHistogram("histogram", "label")
+	assert.NotNil(histogram)
+	assert.NotNil(histogram.With("value"))
+	histogram.With("value").Observe(123.5)
+}
@@ -0,0 +1,11 @@
+/*
+Package xmetrics defines the metrics abstractions shared by WebPA components.  Code that
+produces metrics depends only on the Counter, Gauge, and Histogram interfaces and obtains
+them from a Provider, leaving the choice of backend to the application.
+
+Applications normally create a single Registry from Options, which may be unmarshalled with
+Viper via NewOptions.  A Registry is a Prometheus-backed Provider that also exposes an
+http.Handler for scraping.  The same Registry is then handed to the device, service, httppool,
+and server packages.
+*/
+package xmetrics
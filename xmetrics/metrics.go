@@ -0,0 +1,31 @@
+package xmetrics
+
+// Counter is a monotonically increasing metric.  With returns a Counter scoped to the
+// given label values, which must be supplied in the same order as the label names used
+// to create this counter.
+type Counter interface {
+	With(labelValues ...string) Counter
+	Add(delta float64)
+}
+
+// Gauge is a metric whose value can go up or down.
+type Gauge interface {
+	With(labelValues ...string) Gauge
+	Set(value float64)
+	Add(delta float64)
+}
+
+// Histogram records observations into buckets, e.g. request durations or sizes.
+type Histogram interface {
+	With(labelValues ...string) Histogram
+	Observe(value float64)
+}
+
+// Provider is the factory for metrics.  Components accept a Provider rather than a concrete
+// metrics backend.  The label names given at creation time define the label values that
+// must be passed to With.
+type Provider interface {
+	NewCounter(name string, labelNames ...string) Counter
+	NewGauge(name string, labelNames ...string) Gauge
+	NewHistogram(name string, labelNames ...string) Histogram
+}
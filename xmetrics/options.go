@@ -0,0 +1,83 @@
+package xmetrics
+
+const (
+	// CounterType is the Metric.Type value for counters
+	CounterType = "counter"
+
+	// GaugeType is the Metric.Type value for gauges
+	GaugeType = "gauge"
+
+	// HistogramType is the Metric.Type value for histograms
+	HistogramType = "histogram"
+)
+
+// Metric describes a single configured metric.  Configured metrics are registered when the
+// Registry is created, which allows help text, buckets, and labels to be supplied externally
+// instead of in code.
+type Metric struct {
+	// Name is the metric name, without the namespace or subsystem.  This field is required.
+	Name string `json:"name"`
+
+	// Type is one of CounterType, GaugeType, or HistogramType.  This field is required.
+	Type string `json:"type"`
+
+	// Help is the optional help text for this metric.  If not supplied, the name is used.
+	Help string `json:"help,omitempty"`
+
+	// LabelNames are the optional label names for this metric.
+	LabelNames []string `json:"labelNames,omitempty"`
+
+	// Buckets are the optional histogram buckets.  This field is ignored for other metric types.
+	// If not supplied, prometheus.DefBuckets is used.
+	Buckets []float64 `json:"buckets,omitempty"`
+}
+
+// Options represents the configuration of a Registry
+type Options struct {
+	// Namespace is the prometheus namespace prepended to every metric name
+	Namespace string `json:"namespace,omitempty"`
+
+	// Subsystem is the prometheus subsystem prepended to every metric name
+	Subsystem string `json:"subsystem,omitempty"`
+
+	// DisableGoCollector turns off the standard go runtime metrics
+	DisableGoCollector bool `json:"disableGoCollector"`
+
+	// DisableProcessCollector turns off the standard process metrics
+	DisableProcessCollector bool `json:"disableProcessCollector"`
+
+	// Metrics are the configured metrics that are registered up front
+	Metrics []Metric `json:"metrics,omitempty"`
+}
+
+func (o *Options) namespace() string {
+	if o != nil {
+		return o.Namespace
+	}
+
+	return ""
+}
+
+func (o *Options) subsystem() string {
+	if o != nil {
+		return o.Subsystem
+	}
+
+	return ""
+}
+
+func (o *Options) goCollector() bool {
+	return o == nil || !o.DisableGoCollector
+}
+
+func (o *Options) processCollector() bool {
+	return o == nil || !o.DisableProcessCollector
+}
+
+func (o *Options) metrics() []Metric {
+	if o != nil {
+		return o.Metrics
+	}
+
+	return nil
+}
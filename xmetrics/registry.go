@@ -0,0 +1,239 @@
+package xmetrics
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var (
+	ErrorMissingMetricName = errors.New("A metric name is required")
+)
+
+// Registry is a Prometheus-backed Provider.  Besides creating metrics, a Registry can be used
+// to register arbitrary prometheus collectors and exposes an http.Handler that renders all
+// metrics in the Prometheus exposition format.
+//
+// Metrics created through the Provider methods are cached by name, so asking for the same
+// metric more than once returns the same underlying collector.  Asking for an existing name
+// with a different metric type panics, in the same manner as prometheus.MustRegister.
+type Registry interface {
+	Provider
+	prometheus.Registerer
+	prometheus.Gatherer
+
+	// Handler returns the http.Handler that serves this registry's metrics
+	Handler() http.Handler
+}
+
+// NewRegistry creates a Registry from a set of options.  Each configured metric is registered
+// immediately, and any configuration error is returned.
+func NewRegistry(o *Options) (Registry, error) {
+	r := &registry{
+		Registry:   prometheus.NewRegistry(),
+		namespace:  o.namespace(),
+		subsystem:  o.subsystem(),
+		collectors: make(map[string]prometheus.Collector),
+	}
+
+	if o.goCollector() {
+		if err := r.Register(prometheus.NewGoCollector()); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.processCollector() {
+		if err := r.Register(prometheus.NewProcessCollector(os.Getpid(), r.namespace)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range o.metrics() {
+		if _, err := r.getOrCreate(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// registry is the internal Registry implementation
+type registry struct {
+	*prometheus.Registry
+	namespace string
+	subsystem string
+
+	lock       sync.Mutex
+	collectors map[string]prometheus.Collector
+}
+
+// getOrCreate returns the collector for the given metric, creating and registering it if
+// necessary.  Metrics with the same name but a different type produce an error.
+func (r *registry) getOrCreate(m Metric) (prometheus.Collector, error) {
+	if len(m.Name) == 0 {
+		return nil, ErrorMissingMetricName
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if existing, ok := r.collectors[m.Name]; ok {
+		if typeOf(existing) != m.Type {
+			return nil, fmt.Errorf("Metric %s is already registered as a %s", m.Name, typeOf(existing))
+		}
+
+		return existing, nil
+	}
+
+	help := m.Help
+	if len(help) == 0 {
+		help = m.Name
+	}
+
+	var collector prometheus.Collector
+	switch m.Type {
+	case CounterType:
+		collector = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: r.namespace,
+				Subsystem: r.subsystem,
+				Name:      m.Name,
+				Help:      help,
+			},
+			m.LabelNames,
+		)
+
+	case GaugeType:
+		collector = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: r.namespace,
+				Subsystem: r.subsystem,
+				Name:      m.Name,
+				Help:      help,
+			},
+			m.LabelNames,
+		)
+
+	case HistogramType:
+		collector = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: r.namespace,
+				Subsystem: r.subsystem,
+				Name:      m.Name,
+				Help:      help,
+				Buckets:   m.Buckets,
+			},
+			m.LabelNames,
+		)
+
+	default:
+		return nil, fmt.Errorf("Metric %s has an invalid type: %s", m.Name, m.Type)
+	}
+
+	if err := r.Register(collector); err != nil {
+		return nil, err
+	}
+
+	r.collectors[m.Name] = collector
+	return collector, nil
+}
+
+// mustGetOrCreate is the panicking version of getOrCreate, used to implement Provider
+func (r *registry) mustGetOrCreate(m Metric) prometheus.Collector {
+	collector, err := r.getOrCreate(m)
+	if err != nil {
+		panic(err)
+	}
+
+	return collector
+}
+
+func (r *registry) NewCounter(name string, labelNames ...string) Counter {
+	return prometheusCounter{
+		vec: r.mustGetOrCreate(Metric{Name: name, Type: CounterType, LabelNames: labelNames}).(*prometheus.CounterVec),
+	}
+}
+
+func (r *registry) NewGauge(name string, labelNames ...string) Gauge {
+	return prometheusGauge{
+		vec: r.mustGetOrCreate(Metric{Name: name, Type: GaugeType, LabelNames: labelNames}).(*prometheus.GaugeVec),
+	}
+}
+
+func (r *registry) NewHistogram(name string, labelNames ...string) Histogram {
+	return prometheusHistogram{
+		vec: r.mustGetOrCreate(Metric{Name: name, Type: HistogramType, LabelNames: labelNames}).(*prometheus.HistogramVec),
+	}
+}
+
+func (r *registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.Registry, promhttp.HandlerOpts{})
+}
+
+// typeOf returns the Metric.Type value for a collector created by a registry
+func typeOf(collector prometheus.Collector) string {
+	switch collector.(type) {
+	case *prometheus.CounterVec:
+		return CounterType
+	case *prometheus.GaugeVec:
+		return GaugeType
+	case *prometheus.HistogramVec:
+		return HistogramType
+	default:
+		return ""
+	}
+}
+
+// appendLabelValues returns a new slice so that derived metrics never share label storage
+func appendLabelValues(current []string, more []string) []string {
+	result := make([]string, 0, len(current)+len(more))
+	result = append(result, current...)
+	return append(result, more...)
+}
+
+type prometheusCounter struct {
+	vec         *prometheus.CounterVec
+	labelValues []string
+}
+
+func (c prometheusCounter) With(labelValues ...string) Counter {
+	return prometheusCounter{c.vec, appendLabelValues(c.labelValues, labelValues)}
+}
+
+func (c prometheusCounter) Add(delta float64) {
+	c.vec.WithLabelValues(c.labelValues...).Add(delta)
+}
+
+type prometheusGauge struct {
+	vec         *prometheus.GaugeVec
+	labelValues []string
+}
+
+func (g prometheusGauge) With(labelValues ...string) Gauge {
+	return prometheusGauge{g.vec, appendLabelValues(g.labelValues, labelValues)}
+}
+
+func (g prometheusGauge) Set(value float64) {
+	g.vec.WithLabelValues(g.labelValues...).Set(value)
+}
+
+func (g prometheusGauge) Add(delta float64) {
+	g.vec.WithLabelValues(g.labelValues...).Add(delta)
+}
+
+type prometheusHistogram struct {
+	vec         *prometheus.HistogramVec
+	labelValues []string
+}
+
+func (h prometheusHistogram) With(labelValues ...string) Histogram {
+	return prometheusHistogram{h.vec, appendLabelValues(h.labelValues, labelValues)}
+}
+
+func (h prometheusHistogram) Observe(value float64) {
+	h.vec.WithLabelValues(h.labelValues...).Observe(value)
+}
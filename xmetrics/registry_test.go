@@ -0,0 +1,114 @@
+package xmetrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewRegistryDefault(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	r, err := NewRegistry(nil)
+	require.NotNil(r)
+	require.NoError(err)
+
+	families, err := r.Gather()
+	assert.NoError(err)
+	assert.NotEmpty(families)
+}
+
+func TestNewRegistryConfigured(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		o       = &Options{
+			Namespace:               "test",
+			Subsystem:               "unit",
+			DisableGoCollector:      true,
+			DisableProcessCollector: true,
+			Metrics: []Metric{
+				{Name: "requests", Type: CounterType, Help: "the request count", LabelNames: []string{"code"}},
+				{Name: "depth", Type: GaugeType},
+				{Name: "latency", Type: HistogramType, Buckets: []float64{1.0, 2.0}},
+			},
+		}
+	)
+
+	r, err := NewRegistry(o)
+	require.NotNil(r)
+	require.NoError(err)
+
+	// the configured label names take precedence
+	r.NewCounter("requests").With("200").Add(2.0)
+	r.NewGauge("depth").Set(12.0)
+	r.NewHistogram("latency").Observe(1.5)
+
+	families, err := r.Gather()
+	require.NoError(err)
+	require.Len(families, 3)
+
+	byName := make(map[string]string)
+	for _, family := range families {
+		byName[family.GetName()] = family.GetHelp()
+	}
+
+	assert.Equal("the request count", byName["test_unit_requests"])
+	assert.Equal("depth", byName["test_unit_depth"])
+	assert.Equal("latency", byName["test_unit_latency"])
+}
+
+func TestNewRegistryInvalidMetric(t *testing.T) {
+	testData := []Metric{
+		{Type: CounterType},
+		{Name: "foo", Type: "nosuch"},
+		{Name: "invalid-name", Type: GaugeType},
+	}
+
+	for _, record := range testData {
+		t.Logf("%#v", record)
+		r, err := NewRegistry(&Options{Metrics: []Metric{record}})
+		assert.Nil(t, r)
+		assert.Error(t, err)
+	}
+}
+
+func TestRegistryProvider(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	r, err := NewRegistry(&Options{DisableGoCollector: true, DisableProcessCollector: true})
+	require.NotNil(r)
+	require.NoError(err)
+
+	counter := r.NewCounter("counter", "first", "second")
+	counter.With("a").With("b").Add(1.0)
+	counter.With("a", "b").Add(2.0)
+	r.NewCounter("counter", "first", "second").With("a", "b").Add(3.0)
+
+	gauge := r.NewGauge("gauge", "label")
+	gauge.With("value").Set(10.0)
+	gauge.With("value").Add(-3.0)
+
+	r.NewHistogram("histogram").Observe(0.5)
+
+	assert.Panics(func() { r.NewGauge("counter") })
+	assert.Panics(func() { r.NewCounter("bad-name") })
+
+	response := httptest.NewRecorder()
+	r.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.True(strings.Contains(body, `counter{first="a",second="b"} 6`), body)
+	assert.True(strings.Contains(body, `gauge{label="value"} 7`), body)
+	assert.True(strings.Contains(body, `histogram_count 1`), body)
+}
@@ -0,0 +1,22 @@
+package xmetrics
+
+import (
+	"github.com/spf13/viper"
+)
+
+const (
+	// MetricsKey is the default Viper subkey used for metrics configuration.
+	// WebPA servers should typically use this key as a standard.
+	MetricsKey = "metric"
+)
+
+// NewOptions produces an Options from a Viper instance.  As with other optional modules,
+// the supplied Viper may be nil, in which case a default Options is returned.
+func NewOptions(v *viper.Viper) (o *Options, err error) {
+	o = new(Options)
+	if v != nil {
+		err = v.Unmarshal(o)
+	}
+
+	return
+}
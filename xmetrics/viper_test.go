@@ -0,0 +1,47 @@
+package xmetrics
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewOptions(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		configuration = bytes.NewBufferString(`{
+			"namespace": "webpa",
+			"subsystem": "test",
+			"disableGoCollector": true,
+			"metrics": [
+				{"name": "requests", "type": "counter", "help": "request count", "labelNames": ["code"]},
+				{"name": "latency", "type": "histogram", "buckets": [0.5, 1.0]}
+			]
+		}`)
+
+		v = viper.New()
+	)
+
+	v.SetConfigType("json")
+	require.Nil(v.ReadConfig(configuration))
+
+	o, err := NewOptions(v)
+	require.NotNil(o)
+	assert.Nil(err)
+	assert.Equal("webpa", o.Namespace)
+	assert.Equal("test", o.Subsystem)
+	assert.True(o.DisableGoCollector)
+	assert.False(o.DisableProcessCollector)
+	require.Len(o.Metrics, 2)
+	assert.Equal(Metric{Name: "requests", Type: CounterType, Help: "request count", LabelNames: []string{"code"}}, o.Metrics[0])
+	assert.Equal(Metric{Name: "latency", Type: HistogramType, Buckets: []float64{0.5, 1.0}}, o.Metrics[1])
+}
+
+func TestNewOptionsNilViper(t *testing.T) {
+	o, err := NewOptions(nil)
+	assert.NotNil(t, o)
+	assert.Nil(t, err)
+}